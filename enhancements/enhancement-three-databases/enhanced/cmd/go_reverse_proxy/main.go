@@ -1,41 +1,994 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
+	// Embedded zone data so rate limit schedules work without system tzdata
+	_ "time/tzdata"
 
+	"github.com/codytheroux96/go-reverse-proxy/db/migrations"
 	"github.com/codytheroux96/go-reverse-proxy/internal/app"
+	"github.com/codytheroux96/go-reverse-proxy/internal/migrate"
+	"github.com/codytheroux96/go-reverse-proxy/internal/registry"
 	"github.com/codytheroux96/go-reverse-proxy/test_servers/server_one"
 	"github.com/codytheroux96/go-reverse-proxy/test_servers/server_two"
 )
 
-func redirectHandler(w http.ResponseWriter, r *http.Request) {
-	target := "https://localhost:8443" + r.URL.Path
-	if r.URL.RawQuery != "" {
-		target += "?" + r.URL.RawQuery
-	}
+func main() {
+	proxyAddr := flag.String("addr", envOr("PROXY_ADDR", ":8443"), "HTTPS proxy listen address (env PROXY_ADDR)")
+	redirectAddr := flag.String("redirect-addr", envOr("REDIRECT_ADDR", ":8080"), "HTTP redirect listen address, empty to disable (env REDIRECT_ADDR)")
+	internalAddr := flag.String("internal-addr", os.Getenv("INTERNAL_ADDR"), "plaintext internal listen address, empty to disable (env INTERNAL_ADDR)")
+	dev := flag.Bool("dev", false, "also start the bundled test backends")
+	serverOneAddr := flag.String("dev-server-one-addr", ":4200", "listen address for test server one in dev mode")
+	serverTwoAddr := flag.String("dev-server-two-addr", ":2200", "listen address for test server two in dev mode")
+	runMigrations := flag.Bool("migrate", envOr("MIGRATE", "true") == "true", "apply pending database migrations at startup (env MIGRATE)")
+	preflightOnly := flag.Bool("preflight", false, "run the startup checks, print their report and exit")
+	flag.Parse()
 
-	http.Redirect(w, r, target, http.StatusTemporaryRedirect)
-}
+	// Startup problems are collected into one preflight report, printed
+	// before any listener starts
+	preflight := app.NewPreflight()
+	if v := os.Getenv("PREFLIGHT_MAX_CLOCK_SKEW"); v != "" {
+		skew, err := time.ParseDuration(v)
+		if err != nil || skew <= 0 {
+			fmt.Fprintln(os.Stderr, "PREFLIGHT_MAX_CLOCK_SKEW must be a positive duration")
+			os.Exit(1)
+		}
+		preflight.MaxClockSkew = skew
+	}
 
-func main() {
 	// Try PostgreSQL first, fallback to in-memory
 	databaseURL := os.Getenv("DATABASE_URL")
 	if databaseURL == "" {
 		databaseURL = "postgres://postgres@localhost/reverse_proxy?sslmode=disable"
 	}
 
-	application, err := app.NewApplicationWithPostgreSQL(databaseURL)
+	pool, err := poolConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// REGISTRY_FAILOVER=true keeps routing and registrations working from an
+	// in-memory mirror while PostgreSQL is unreachable, and replays the
+	// changes into it when it comes back
+	newApplication := app.NewApplicationWithPostgreSQL
+	if os.Getenv("REGISTRY_FAILOVER") == "true" {
+		newApplication = app.NewApplicationWithFailoverRegistry
+	}
+	application, err := newApplication(databaseURL, pool)
 	if err != nil {
 		// Fallback to in-memory registry
 		fmt.Printf("PostgreSQL connection failed, using in-memory registry: %v\n", err)
+		preflight.Warn("database", "PostgreSQL unreachable, using the in-memory registry")
 		application = app.NewApplicationWithInMemoryRegistry()
 	} else {
 		fmt.Println("Using PostgreSQL-backed registry")
+
+		// Instances starting together serialize on an advisory lock, so each
+		// migration is applied once
+		if *runMigrations {
+			reg := application.Registry.(interface{ DB() *sql.DB })
+			applied, err := migrate.Up(context.Background(), reg.DB(), migrations.FS)
+			if err != nil {
+				application.Logger.Error("failed to migrate database", "error", err)
+				os.Exit(1)
+			}
+			if len(applied) > 0 {
+				application.Logger.Info("applied database migrations", "versions", applied)
+			}
+		}
+		if reg, ok := application.Registry.(interface{ DB() *sql.DB }); ok {
+			preflight.CheckDatabase(context.Background(), reg.DB(), migrations.FS)
+		}
+	}
+
+	if sinks := os.Getenv("ACCESS_LOG_SINKS"); sinks != "" {
+		if err := application.ConfigureAccessLog(sinks); err != nil {
+			application.Logger.Error("failed to configure access log sinks", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	if auditFile := os.Getenv("AUDIT_LOG_FILE"); auditFile != "" {
+		if err := application.ConfigureAuditFile(auditFile); err != nil {
+			application.Logger.Error("failed to configure audit log file", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	if token := os.Getenv("ADMIN_TOKEN"); token != "" {
+		application.UseAdmin(app.RequireBearerToken(token))
+	}
+
+	// SERVICE_WEIGHTS=checkout-v1=9,checkout-v2=1 splits the traffic of routes
+	// served by several logical services between them
+	if weights := os.Getenv("SERVICE_WEIGHTS"); weights != "" {
+		for _, part := range strings.Split(weights, ",") {
+			service, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+			weight, err := strconv.Atoi(value)
+			if err == nil {
+				err = application.ServiceGroups.SetWeight(service, weight)
+			}
+			if err != nil {
+				application.Logger.Error("invalid SERVICE_WEIGHTS", "entry", part, "error", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	// PREFIX_OWNERS=payments=<token>,search=<token> lets each team claim the
+	// route prefixes it registers with its X-Registry-Token, so other teams
+	// cannot register under them; PREFIX_CONFLICT_POLICY=takeover lets a team
+	// take a prefix over by also sending X-Prefix-Takeover: true
+	if owners := os.Getenv("PREFIX_OWNERS"); owners != "" {
+		cfg := app.OwnershipConfig{Teams: make(map[string]string), Policy: os.Getenv("PREFIX_CONFLICT_POLICY")}
+		for _, part := range strings.Split(owners, ",") {
+			team, token, _ := strings.Cut(strings.TrimSpace(part), "=")
+			cfg.Teams[team] = token
+		}
+		var store app.OwnershipStore = app.NewMemoryOwnershipStore()
+		if reg, ok := application.Registry.(interface{ DB() *sql.DB }); ok {
+			store = app.NewPostgresOwnershipStore(reg.DB())
+		}
+		ownership, err := app.NewPrefixOwnership(cfg, store)
+		if err != nil {
+			application.Logger.Error("invalid PREFIX_OWNERS", "error", err)
+			os.Exit(1)
+		}
+		application.Ownership = ownership
+	}
+
+	// PROXY_AUTH_TOKEN requires a bearer token on proxied routes, except
+	// those matched by the auth_bypass rules in POLICY_FILE
+	if token := os.Getenv("PROXY_AUTH_TOKEN"); token != "" {
+		application.Use(application.Authenticate(app.RequireBearerToken(token)))
+	}
+
+	if origins := os.Getenv("CORS_ALLOWED_ORIGINS"); origins != "" {
+		application.Use(app.CORS(app.CORSConfig{
+			AllowedOrigins: strings.Split(origins, ","),
+			AllowedMethods: []string{http.MethodGet, http.MethodPost},
+			MaxAge:         600,
+		}))
+	}
+
+	if plugins := os.Getenv("PROXY_PLUGINS"); plugins != "" {
+		for _, path := range strings.Split(plugins, ",") {
+			if err := application.LoadPlugin(strings.TrimSpace(path)); err != nil {
+				application.Logger.Error("failed to load plugin", "error", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	// WASM_FILTERS=name:prefix:path,... attaches WASM filters at startup
+	if filters := os.Getenv("WASM_FILTERS"); filters != "" {
+		for _, spec := range strings.Split(filters, ",") {
+			parts := strings.SplitN(strings.TrimSpace(spec), ":", 3)
+			if len(parts) != 3 {
+				application.Logger.Error("invalid WASM_FILTERS entry, expected name:prefix:path", "entry", spec)
+				os.Exit(1)
+			}
+			if _, err := application.WasmFilters.Load(parts[0], parts[1], parts[2]); err != nil {
+				application.Logger.Error("failed to load wasm filter", "error", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	if policyFile := os.Getenv("POLICY_FILE"); policyFile != "" {
+		if err := application.LoadPolicies(policyFile); err != nil {
+			application.Logger.Error("failed to load policies", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	if grpcConfig := os.Getenv("GRPC_ROUTES_FILE"); grpcConfig != "" {
+		translator, err := app.LoadGRPCTranslator(grpcConfig, application.Logger)
+		if err != nil {
+			application.Logger.Error("failed to load grpc routes", "error", err)
+			os.Exit(1)
+		}
+		application.GRPC = translator
+	}
+
+	if aggregateConfig := os.Getenv("AGGREGATE_ROUTES_FILE"); aggregateConfig != "" {
+		aggregates, err := app.LoadAggregateRoutes(aggregateConfig)
+		if err != nil {
+			application.Logger.Error("failed to load aggregate routes", "error", err)
+			os.Exit(1)
+		}
+		application.Aggregates = aggregates
+	}
+
+	if schemaFile := os.Getenv("SCHEMA_FILE"); schemaFile != "" {
+		if err := application.ConfigureSchemas(schemaFile); err != nil {
+			application.Logger.Error("failed to load request schemas", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	if errorPages := os.Getenv("ERROR_PAGES_FILE"); errorPages != "" {
+		pages, err := app.LoadErrorPages(errorPages)
+		if err != nil {
+			application.Logger.Error("failed to load error pages", "error", err)
+			os.Exit(1)
+		}
+		application.ErrorPages = pages
+	}
+
+	// STATIC_ROUTES=prefix=dir,... serves local directories
+	if static := os.Getenv("STATIC_ROUTES"); static != "" {
+		for _, spec := range strings.Split(static, ",") {
+			prefix, dir, ok := strings.Cut(strings.TrimSpace(spec), "=")
+			if !ok {
+				application.Logger.Error("invalid STATIC_ROUTES entry, expected prefix=dir", "entry", spec)
+				os.Exit(1)
+			}
+			if err := application.Static.Add(prefix, dir, app.DefaultStaticMaxAge); err != nil {
+				application.Logger.Error("failed to add static route", "error", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	application.SetNormalization(app.NormalizeConfig{
+		LowercasePaths: os.Getenv("NORMALIZE_LOWERCASE_PATHS") == "true",
+		SortQuery:      os.Getenv("NORMALIZE_SORT_QUERY") == "true",
+	})
+
+	if postCache := os.Getenv("POST_CACHE_ROUTES"); postCache != "" {
+		routes, err := app.ParsePostCacheRoutes(postCache)
+		if err == nil {
+			err = application.SetPostCacheRoutes(routes)
+		}
+		if err != nil {
+			application.Logger.Error("invalid POST_CACHE_ROUTES", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// CACHE_AUTH_ROUTES=prefix=mode,... lets credentialed requests use the
+	// cache on routes that opt in, shared or partitioned per user
+	if cacheAuth := os.Getenv("CACHE_AUTH_ROUTES"); cacheAuth != "" {
+		routes, err := app.ParseCacheAuthRoutes(cacheAuth)
+		if err == nil {
+			err = application.SetCacheAuthRoutes(routes)
+		}
+		if err != nil {
+			application.Logger.Error("invalid CACHE_AUTH_ROUTES", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// RANGE_FILL_ROUTES=/media,/videos fetches and caches whole objects for
+	// range requests on those routes, up to RANGE_FILL_MAX_BYTES each
+	if fill := os.Getenv("RANGE_FILL_ROUTES"); fill != "" {
+		cfg := app.RangeFillConfig{Routes: strings.Split(fill, ",")}
+		if v := os.Getenv("RANGE_FILL_MAX_BYTES"); v != "" {
+			if cfg.MaxBytes, err = strconv.Atoi(v); err != nil {
+				application.Logger.Error("invalid RANGE_FILL_MAX_BYTES", "error", err)
+				os.Exit(1)
+			}
+		}
+		if err := application.SetRangeFill(cfg); err != nil {
+			application.Logger.Error("invalid range fill configuration", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// SIGNED_URL_ROUTES=/downloads only serves URLs signed with
+	// SIGNED_URL_SECRET; POST /admin/signed-urls mints them, valid for at
+	// most SIGNED_URL_MAX_TTL
+	if routes := os.Getenv("SIGNED_URL_ROUTES"); routes != "" {
+		cfg := app.SignedURLConfig{Routes: strings.Split(routes, ","), Secret: os.Getenv("SIGNED_URL_SECRET")}
+		if v := os.Getenv("SIGNED_URL_MAX_TTL"); v != "" {
+			if cfg.MaxTTL, err = time.ParseDuration(v); err != nil {
+				application.Logger.Error("invalid SIGNED_URL_MAX_TTL", "error", err)
+				os.Exit(1)
+			}
+		}
+		if err := application.SetSignedURLs(cfg); err != nil {
+			application.Logger.Error("invalid signed url configuration", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// REQUEST_COMPRESSION_FILE=routes.json sets the request body encoding
+	// each route's backends accept
+	if file := os.Getenv("REQUEST_COMPRESSION_FILE"); file != "" {
+		routes, err := app.LoadRequestCompression(file)
+		if err == nil {
+			err = application.SetRequestCompression(routes)
+		}
+		if err != nil {
+			application.Logger.Error("invalid request compression routes", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// RESPONSE_ASSERTIONS_FILE=assertions.json checks backend responses per
+	// route for content type, size and JSON well-formedness
+	if file := os.Getenv("RESPONSE_ASSERTIONS_FILE"); file != "" {
+		assertions, err := app.LoadResponseAssertions(file)
+		if err == nil {
+			application.ResponseAssertions, err = app.NewResponseAssertions(assertions)
+		}
+		if err != nil {
+			application.Logger.Error("invalid response assertions", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// UPSTREAM_MAX_HEADERS, UPSTREAM_MAX_HEADER_BYTES, UPSTREAM_HEADER_LIMITS
+	// and UPSTREAM_STRIP_HEADERS bound the request headers sent to backends
+	if limitsCfg, enabled, err := headerLimitConfig(); err != nil {
+		application.Logger.Error("invalid header limits", "error", err)
+		os.Exit(1)
+	} else if enabled {
+		limits, err := app.NewHeaderLimits(limitsCfg)
+		if err != nil {
+			application.Logger.Error("invalid header limits", "error", err)
+			os.Exit(1)
+		}
+		application.HeaderLimits = limits
+	}
+
+	// RATE_LIMIT_ALGORITHM picks the default algorithm and
+	// RATE_LIMIT_ROUTES=prefix=algorithm,... overrides it per route
+	if os.Getenv("RATE_LIMIT_ALGORITHM") != "" || os.Getenv("RATE_LIMIT_ROUTES") != "" {
+		algorithm := envOr("RATE_LIMIT_ALGORITHM", app.RateLimitTokenBucket)
+		routes, err := app.ParseRateLimitRoutes(os.Getenv("RATE_LIMIT_ROUTES"))
+		if err == nil {
+			err = application.SetRateLimitAlgorithms(algorithm, routes)
+		}
+		if err != nil {
+			application.Logger.Error("invalid rate limit algorithms", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// RATE_LIMIT_COSTS=prefix=cost,... makes heavy routes use more of a
+	// client's budget
+	if costs := os.Getenv("RATE_LIMIT_COSTS"); costs != "" {
+		routes, err := app.ParseRateLimitCosts(costs)
+		if err == nil {
+			err = application.SetRateLimitCosts(routes)
+		}
+		if err != nil {
+			application.Logger.Error("invalid RATE_LIMIT_COSTS", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// RATE_LIMIT_SOFT_RPS warns clients before the hard limit rejects them
+	if v := os.Getenv("RATE_LIMIT_SOFT_RPS"); v != "" {
+		rps, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			application.Logger.Error("RATE_LIMIT_SOFT_RPS must be a number", "value", v)
+			os.Exit(1)
+		}
+		burst := 0
+		if b := os.Getenv("RATE_LIMIT_SOFT_BURST"); b != "" {
+			if burst, err = strconv.Atoi(b); err != nil {
+				application.Logger.Error("RATE_LIMIT_SOFT_BURST must be an integer", "value", b)
+				os.Exit(1)
+			}
+		}
+		if err := application.SetSoftRateLimit(rps, burst); err != nil {
+			application.Logger.Error("invalid soft rate limit", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	if schedule := os.Getenv("RATE_LIMIT_SCHEDULE_FILE"); schedule != "" {
+		scheduler, err := app.LoadRateLimitSchedule(schedule, application.Logger)
+		if err != nil {
+			application.Logger.Error("failed to load rate limit schedule", "error", err)
+			os.Exit(1)
+		}
+		application.RateLimitSchedule = scheduler
+	}
+
+	penalties, err := penaltyConfig()
+	if err != nil {
+		application.Logger.Error("invalid rate limit penalty settings", "error", err)
+		os.Exit(1)
+	}
+	if penalties.TarpitAfter > 0 || penalties.BanAfter > 0 {
+		application.Penalties = app.NewPenaltyBox(penalties)
+	}
+
+	// LEADER_ELECTION=postgres or redis://host:port/db runs active health
+	// checks only on the elected instance; LEADER_ID names this instance
+	if election := os.Getenv("LEADER_ELECTION"); election != "" {
+		backend, err := leaderBackend(application, election)
+		if err != nil {
+			application.Logger.Error("invalid LEADER_ELECTION", "error", err)
+			os.Exit(1)
+		}
+		application.Leader = app.NewLeaderElection(backend, os.Getenv("LEADER_ID"), application.Logger)
+	}
+
+	// CLUSTER_BIND=:7946 with CLUSTER_PEERS=host:7946,... gossips backend
+	// failures with other replicas
+	if bind := os.Getenv("CLUSTER_BIND"); bind != "" {
+		cluster, err := app.NewCluster(clusterConfig(bind), application.Logger)
+		if err != nil {
+			application.Logger.Error("failed to start cluster gossip", "error", err)
+			os.Exit(1)
+		}
+		application.Cluster = cluster
+	}
+
+	// DNS_CACHE_TTL, DNS_NEGATIVE_TTL, DNS_TIMEOUT and DNS_NAMESERVERS=host:53,...
+	// resolve backend hostnames with caching and fallback nameservers
+	if resolverCfg, enabled, err := resolverConfig(); err != nil {
+		application.Logger.Error("invalid DNS configuration", "error", err)
+		os.Exit(1)
+	} else if enabled {
+		resolver, err := app.NewResolver(resolverCfg, application.Logger)
+		if err != nil {
+			application.Logger.Error("invalid DNS configuration", "error", err)
+			os.Exit(1)
+		}
+		application.SetResolver(resolver)
+	}
+
+	// UPSTREAM_CONN_MAX_LIFETIME, UPSTREAM_IDLE_TIMEOUT and UPSTREAM_KEEPALIVE
+	// retire pooled backend connections before middleboxes drop them
+	if connCfg, enabled, err := upstreamConnConfig(); err != nil {
+		application.Logger.Error("invalid upstream connection configuration", "error", err)
+		os.Exit(1)
+	} else if enabled {
+		conns, err := app.NewUpstreamConns(connCfg)
+		if err != nil {
+			application.Logger.Error("invalid upstream connection configuration", "error", err)
+			os.Exit(1)
+		}
+		application.SetUpstreamConns(conns)
+	}
+
+	// PREWARM_CONNECTIONS opens that many connections to each backend at
+	// start, on registration and on config reload, each backend taking at
+	// most PREWARM_TIMEOUT
+	if v := os.Getenv("PREWARM_CONNECTIONS"); v != "" {
+		cfg := app.PrewarmConfig{}
+		if cfg.Connections, err = strconv.Atoi(v); err != nil || cfg.Connections < 1 {
+			application.Logger.Error("PREWARM_CONNECTIONS must be a positive integer")
+			os.Exit(1)
+		}
+		if v := os.Getenv("PREWARM_TIMEOUT"); v != "" {
+			if cfg.Timeout, err = time.ParseDuration(v); err != nil {
+				application.Logger.Error("invalid PREWARM_TIMEOUT", "error", err)
+				os.Exit(1)
+			}
+		}
+		prewarmer, err := app.NewPrewarmer(cfg, application.Logger)
+		if err != nil {
+			application.Logger.Error("invalid prewarm configuration", "error", err)
+			os.Exit(1)
+		}
+		application.SetPrewarmer(prewarmer)
+	}
+
+	// ENRICH_HEADERS=geo,tls,device,subject sends client context headers to
+	// backends; geo looks clients up in the GEO_RANGES_FILE table
+	if enrich := os.Getenv("ENRICH_HEADERS"); enrich != "" {
+		var geo app.GeoLookup
+		if file := os.Getenv("GEO_RANGES_FILE"); file != "" {
+			ranges, err := app.LoadGeoRanges(file)
+			if err != nil {
+				application.Logger.Error("failed to load geo ranges", "error", err)
+				os.Exit(1)
+			}
+			geo = ranges
+		}
+		enrichment, err := app.NewEnrichment(strings.Split(enrich, ","), geo)
+		if err != nil {
+			application.Logger.Error("invalid ENRICH_HEADERS", "error", err)
+			os.Exit(1)
+		}
+		application.Enrichment = enrichment
+	}
+
+	// DEGRADE_ROUTES=/catalog,/pages serves expired cached responses on those
+	// routes when every backend is down, up to DEGRADE_MAX_STALE old
+	if routes := os.Getenv("DEGRADE_ROUTES"); routes != "" {
+		cfg := app.DegradeConfig{Routes: strings.Split(routes, ",")}
+		if v := os.Getenv("DEGRADE_MAX_STALE"); v != "" {
+			if cfg.MaxStale, err = time.ParseDuration(v); err != nil {
+				application.Logger.Error("invalid DEGRADE_MAX_STALE", "error", err)
+				os.Exit(1)
+			}
+		}
+		degrader, err := app.NewDegrader(cfg, application.Logger)
+		if err != nil {
+			application.Logger.Error("invalid degraded routes", "error", err)
+			os.Exit(1)
+		}
+		application.SetDegrader(degrader)
+	}
+
+	// HEALTH_READINESS_PATH gates routing, HEALTH_LIVENESS_PATH reports
+	// whether the backend process is up, DEREGISTER_AFTER removes backends
+	// that stay down that long, HEALTH_DEGRADED_WEIGHT is the share of
+	// traffic a backend reporting itself degraded keeps, and
+	// HEALTH_STARTUP_TIMEOUT and HEALTH_STARTUP_PASSES give new backends
+	// longer checks until they have passed a few
+	healthChecks := app.HealthCheckConfig{
+		ReadinessPath: os.Getenv("HEALTH_READINESS_PATH"),
+		LivenessPath:  os.Getenv("HEALTH_LIVENESS_PATH"),
+	}
+	if v := os.Getenv("DEREGISTER_AFTER"); v != "" {
+		if healthChecks.DeregisterAfter, err = time.ParseDuration(v); err != nil {
+			application.Logger.Error("invalid DEREGISTER_AFTER", "error", err)
+			os.Exit(1)
+		}
+	}
+	if v := os.Getenv("HEALTH_DEGRADED_WEIGHT"); v != "" {
+		if healthChecks.DegradedWeight, err = strconv.ParseFloat(v, 64); err != nil {
+			application.Logger.Error("invalid HEALTH_DEGRADED_WEIGHT", "error", err)
+			os.Exit(1)
+		}
+	}
+	if v := os.Getenv("HEALTH_STARTUP_TIMEOUT"); v != "" {
+		if healthChecks.StartupTimeout, err = time.ParseDuration(v); err != nil {
+			application.Logger.Error("invalid HEALTH_STARTUP_TIMEOUT", "error", err)
+			os.Exit(1)
+		}
+	}
+	if v := os.Getenv("HEALTH_STARTUP_PASSES"); v != "" {
+		if healthChecks.StartupPasses, err = strconv.Atoi(v); err != nil {
+			application.Logger.Error("invalid HEALTH_STARTUP_PASSES", "error", err)
+			os.Exit(1)
+		}
+	}
+	if err := application.SetHealthChecks(healthChecks); err != nil {
+		application.Logger.Error("invalid health checks", "error", err)
+		os.Exit(1)
+	}
+
+	// HEALTH_WEIGHTED=true spreads each route's traffic by health check
+	// latency and recent error rate instead of round-robin
+	if os.Getenv("HEALTH_WEIGHTED") == "true" {
+		weights, err := app.NewHealthWeights(app.WeightConfig{})
+		if err != nil {
+			application.Logger.Error("invalid health weighting", "error", err)
+			os.Exit(1)
+		}
+		application.SetHealthWeights(weights)
+	}
+
+	// PROXY_ZONE=us-east-1a keeps traffic on backends registered in that zone
+	// while a route has ZONE_MIN_LOCAL (default 1) healthy ones there
+	if zone := os.Getenv("PROXY_ZONE"); zone != "" {
+		cfg := app.ZoneConfig{Zone: zone}
+		if v := os.Getenv("ZONE_MIN_LOCAL"); v != "" {
+			if cfg.MinLocal, err = strconv.Atoi(v); err != nil {
+				application.Logger.Error("invalid ZONE_MIN_LOCAL", "error", err)
+				os.Exit(1)
+			}
+		}
+		zones, err := app.NewZoneRouting(cfg)
+		if err != nil {
+			application.Logger.Error("invalid zone routing", "error", err)
+			os.Exit(1)
+		}
+		application.Zones = zones
+	}
+
+	// EVENT_SINKS=log,webhook:<url>,... observes every internal event
+	if sinks := os.Getenv("EVENT_SINKS"); sinks != "" {
+		if err := application.ConfigureEventSinks(sinks); err != nil {
+			application.Logger.Error("invalid EVENT_SINKS", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// REGISTRY_WEBHOOK_URLS=<url>,... POSTs every registration and
+	// deregistration, signed with REGISTRY_WEBHOOK_SECRET when set
+	if urls := os.Getenv("REGISTRY_WEBHOOK_URLS"); urls != "" {
+		cfg, err := registryWebhookConfig(urls)
+		if err != nil {
+			application.Logger.Error("invalid registry webhook config", "error", err)
+			os.Exit(1)
+		}
+		webhooks, err := app.NewRegistryWebhooks(cfg, application.Logger)
+		if err != nil {
+			application.Logger.Error("invalid registry webhooks", "error", err)
+			os.Exit(1)
+		}
+		application.SetRegistryWebhooks(webhooks)
+	}
+
+	if os.Getenv("COALESCE_GETS") == "true" {
+		application.Coalescer = app.NewCoalescer()
+	}
+
+	// DISABLED_FEATURES=retries,coalescing starts with reliability features
+	// switched off; /admin/features switches them back on at runtime
+	if features := os.Getenv("DISABLED_FEATURES"); features != "" {
+		for _, feature := range strings.Split(features, ",") {
+			if err := application.Reliability.SetEnabled(strings.TrimSpace(feature), false); err != nil {
+				application.Logger.Error("invalid DISABLED_FEATURES", "error", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	// IDEMPOTENT_POSTS=/api/search,... retries POSTs under those prefixes
+	// like idempotent requests; /admin/retries changes them at runtime
+	if v := os.Getenv("IDEMPOTENT_POSTS"); v != "" {
+		if err := application.RetryPolicy.SetIdempotentPosts(app.ParseIdempotentPosts(v)); err != nil {
+			application.Logger.Error("invalid IDEMPOTENT_POSTS", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// BLUE_GREEN_FILE=routes.json splits prefixes into backend groups that
+	// deploy tooling switches between through /admin/bluegreen/cutover
+	if file := os.Getenv("BLUE_GREEN_FILE"); file != "" {
+		routes, err := app.LoadBlueGreenRoutes(file)
+		if err != nil {
+			application.Logger.Error("failed to load blue/green routes", "error", err)
+			os.Exit(1)
+		}
+		for _, route := range routes {
+			if err := application.BlueGreen.Set(route); err != nil {
+				application.Logger.Error("invalid blue/green route", "prefix", route.Prefix, "error", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	// EXPERIMENTS_FILE=experiments.json splits routes between A/B variants
+	if file := os.Getenv("EXPERIMENTS_FILE"); file != "" {
+		experiments, err := app.LoadExperiments(file)
+		if err != nil {
+			application.Logger.Error("failed to load experiments", "error", err)
+			os.Exit(1)
+		}
+		for _, e := range experiments {
+			if _, err := application.Experiments.Set(e); err != nil {
+				application.Logger.Error("invalid experiment", "name", e.Name, "error", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	// OPENAPI_IMPORTS=name=spec.json,... creates routes from OpenAPI specs,
+	// with OPENAPI_UPSTREAMS and OPENAPI_MOUNTS overriding name=value per spec
+	if imports := os.Getenv("OPENAPI_IMPORTS"); imports != "" {
+		specs, err := parsePairs("OPENAPI_IMPORTS", imports)
+		if err != nil {
+			application.Logger.Error("invalid OPENAPI_IMPORTS", "error", err)
+			os.Exit(1)
+		}
+		upstreams, err := parsePairs("OPENAPI_UPSTREAMS", os.Getenv("OPENAPI_UPSTREAMS"))
+		if err != nil {
+			application.Logger.Error("invalid OPENAPI_UPSTREAMS", "error", err)
+			os.Exit(1)
+		}
+		mounts, err := parsePairs("OPENAPI_MOUNTS", os.Getenv("OPENAPI_MOUNTS"))
+		if err != nil {
+			application.Logger.Error("invalid OPENAPI_MOUNTS", "error", err)
+			os.Exit(1)
+		}
+
+		for name, spec := range specs {
+			err := application.ImportOpenAPI(app.OpenAPIImportConfig{
+				Name:     name,
+				Spec:     spec,
+				Upstream: upstreams[name],
+				Mount:    mounts[name],
+			})
+			if err != nil {
+				application.Logger.Error("failed to import openapi spec", "error", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	if defaultBackend := os.Getenv("DEFAULT_BACKEND"); defaultBackend != "" {
+		application.Router.SetDefaultBackend(defaultBackend)
+	}
+
+	redirectCfg := &app.RedirectConfig{HTTP: app.HTTPSRedirectRules(localHost(*proxyAddr))}
+	if rulesFile := os.Getenv("REDIRECT_RULES_FILE"); rulesFile != "" {
+		redirectCfg, err = app.LoadRedirectConfig(rulesFile)
+		if err != nil {
+			application.Logger.Error("failed to load redirect rules", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	httpRedirects, err := app.NewRedirector(redirectCfg.HTTP)
+	if err != nil {
+		application.Logger.Error("invalid http redirect rules", "error", err)
+		os.Exit(1)
+	}
+
+	if len(redirectCfg.HTTPS) > 0 {
+		httpsRedirects, err := app.NewRedirector(redirectCfg.HTTPS)
+		if err != nil {
+			application.Logger.Error("invalid https redirect rules", "error", err)
+			os.Exit(1)
+		}
+		application.Use(httpsRedirects.Middleware)
+	}
+
+	if os.Getenv("REQUEST_TIMEOUT") != "" || os.Getenv("ROUTE_TIMEOUTS") != "" {
+		var timeouts app.TimeoutConfig
+		if v := os.Getenv("REQUEST_TIMEOUT"); v != "" {
+			timeouts.Default, err = time.ParseDuration(v)
+			if err != nil || timeouts.Default < 0 {
+				application.Logger.Error("REQUEST_TIMEOUT must be a non-negative duration", "value", v)
+				os.Exit(1)
+			}
+		}
+		timeouts.Routes, err = app.ParseRouteTimeouts(os.Getenv("ROUTE_TIMEOUTS"))
+		if err != nil {
+			application.Logger.Error("invalid ROUTE_TIMEOUTS", "error", err)
+			os.Exit(1)
+		}
+		application.SetRequestTimeouts(timeouts)
+	}
+
+	// DRAIN_TIMEOUT bounds how long deregistration waits for a backend's
+	// in-flight requests before removing it anyway
+	if v := os.Getenv("DRAIN_TIMEOUT"); v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err == nil {
+			err = application.SetDrainTimeout(timeout)
+		}
+		if err != nil {
+			application.Logger.Error("invalid DRAIN_TIMEOUT", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// SESSION_DRAIN_PERIOD is how long a shutdown asks clients to reconnect
+	// elsewhere, with Connection: close, before the listeners close
+	sessionDrain := app.DefaultSessionDrainPeriod
+	if v := os.Getenv("SESSION_DRAIN_PERIOD"); v != "" {
+		period, err := time.ParseDuration(v)
+		if err == nil {
+			err = application.SetSessionDrainPeriod(period)
+		}
+		if err != nil {
+			application.Logger.Error("invalid SESSION_DRAIN_PERIOD", "error", err)
+			os.Exit(1)
+		}
+		if period > 0 {
+			sessionDrain = period
+		}
+	}
+
+	connLimits, err := connLimitConfig()
+	if err != nil {
+		application.Logger.Error("invalid connection limits", "error", err)
+		os.Exit(1)
+	}
+	if connLimits.MaxConns > 0 || connLimits.MaxConnsPerIP > 0 {
+		application.Connections = app.NewConnLimiter(connLimits, application.Logger)
+	}
+
+	streams, err := clientStreamConfig()
+	if err != nil {
+		application.Logger.Error("invalid client stream limits", "error", err)
+		os.Exit(1)
+	}
+	if streams.MaxStreams > 0 || len(streams.Classes) > 0 || streams.MaxStreamsPerConn > 0 {
+		if err := application.SetClientStreamLimits(streams); err != nil {
+			application.Logger.Error("invalid client stream limits", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// ROUTE_LOG_RULES samples or quiets the log lines of noisy routes
+	if v := os.Getenv("ROUTE_LOG_RULES"); v != "" {
+		rules, err := app.ParseRouteLogRules(v)
+		if err == nil {
+			err = application.RouteLogging.SetRules(rules)
+		}
+		if err != nil {
+			application.Logger.Error("invalid route log rules", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// SAMPLE_RATE and SAMPLE_FILTERS select requests recorded in full for
+	// GET /admin/samples
+	sampling, err := samplingConfig()
+	if err != nil {
+		application.Logger.Error("invalid request sampling settings", "error", err)
+		os.Exit(1)
+	}
+	if sampling.Rate > 0 || len(sampling.Filters) > 0 {
+		if err := application.SetSampling(sampling); err != nil {
+			application.Logger.Error("invalid request sampling settings", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// ROUTE_MISS_TTL and ROUTE_MISS_CAPACITY remember paths no route
+	// matched, so repeated probes skip the registry lookup
+	misses, err := routeMissConfig()
+	if err != nil {
+		application.Logger.Error("invalid route miss cache settings", "error", err)
+		os.Exit(1)
+	}
+	if misses.TTL > 0 || misses.Capacity > 0 {
+		if err := application.SetRouteMissCache(misses); err != nil {
+			application.Logger.Error("invalid route miss cache settings", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	admission, err := admissionConfig()
+	if err != nil {
+		application.Logger.Error("invalid admission control settings", "error", err)
+		os.Exit(1)
+	}
+	if admission.MaxInFlight > 0 {
+		application.SetAdmission(admission)
+	}
+
+	// MEMORY_LIMIT overrides the detected container memory limit and
+	// MEMORY_REJECT_RATIO is the share of it past which new requests are
+	// rejected
+	monitor, err := selfMonitorConfig()
+	if err != nil {
+		application.Logger.Error("invalid self-monitor settings", "error", err)
+		os.Exit(1)
+	}
+	selfMonitor, err := app.NewSelfMonitor(monitor, application.Logger)
+	if err != nil {
+		application.Logger.Error("invalid self-monitor settings", "error", err)
+		os.Exit(1)
+	}
+	application.SetSelfMonitor(selfMonitor)
+
+	// TRAFFIC_SUMMARY_INTERVAL logs bytes per route that often, warning when
+	// a route's responses grow PAYLOAD_GROWTH_FACTOR times past their usual
+	// size
+	if v := os.Getenv("TRAFFIC_SUMMARY_INTERVAL"); v != "" {
+		summary := app.TrafficSummaryConfig{}
+		if summary.Interval, err = time.ParseDuration(v); err != nil {
+			application.Logger.Error("invalid TRAFFIC_SUMMARY_INTERVAL", "error", err)
+			os.Exit(1)
+		}
+		if v := os.Getenv("PAYLOAD_GROWTH_FACTOR"); v != "" {
+			if summary.GrowthFactor, err = strconv.ParseFloat(v, 64); err != nil {
+				application.Logger.Error("invalid PAYLOAD_GROWTH_FACTOR", "error", err)
+				os.Exit(1)
+			}
+		}
+		if err := application.SetTrafficSummary(summary); err != nil {
+			application.Logger.Error("invalid traffic summary", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// SHED_STAGES lists optional stages (wasm, schema, assertions,
+	// enrichment) skipped while request latency or CPU passes its threshold
+	if stages := os.Getenv("SHED_STAGES"); stages != "" {
+		cfg, err := stageShedConfig(stages)
+		if err != nil {
+			application.Logger.Error("invalid stage shedding settings", "error", err)
+			os.Exit(1)
+		}
+		shedder, err := app.NewStageShedder(cfg, application.Logger)
+		if err != nil {
+			application.Logger.Error("invalid stage shedding settings", "error", err)
+			os.Exit(1)
+		}
+		application.SetStageShedder(shedder)
+	}
+
+	// BOT_DETECTION_FILE=bots.json scores requests on the listed routes as
+	// likely bots and allows, rate limits, challenges or blocks them
+	if file := os.Getenv("BOT_DETECTION_FILE"); file != "" {
+		cfg, err := app.LoadBotDetection(file)
+		if err != nil {
+			application.Logger.Error("failed to load bot detection", "error", err)
+			os.Exit(1)
+		}
+		detector, err := app.NewBotDetector(cfg, application.Logger)
+		if err != nil {
+			application.Logger.Error("invalid bot detection settings", "error", err)
+			os.Exit(1)
+		}
+		application.SetBotDetector(detector)
+	}
+
+	if critical := os.Getenv("CRITICAL_ROUTES"); critical != "" {
+		application.Probes.SetCriticalPrefixes(strings.Split(critical, ","))
+	}
+
+	certFile := os.Getenv("TLS_CERT_FILE")
+	if certFile == "" {
+		certFile = "cert/cert.pem"
+	}
+	keyFile := os.Getenv("TLS_KEY_FILE")
+	if keyFile == "" {
+		keyFile = "cert/key.pem"
+	}
+
+	tlsConfig := &tls.Config{}
+	if cert := preflight.CheckCertificate(certFile, keyFile); cert != nil {
+		if err := application.Probes.SetTLSCertificate(cert); err != nil {
+			application.Logger.Error("invalid TLS certificate", "error", err)
+			os.Exit(1)
+		}
+		if err := application.Certificates.TrackPair(certFile, app.CertRoleServing, cert); err != nil {
+			application.Logger.Error("invalid TLS certificate", "error", err)
+			os.Exit(1)
+		}
+		tlsConfig.Certificates = []tls.Certificate{*cert}
+	}
+
+	// TLS_POLICY_FILE=policies.json sets per-route TLS requirements, and
+	// TLS_CLIENT_CA_FILE verifies the client certificates they ask for
+	if file := os.Getenv("TLS_POLICY_FILE"); file != "" {
+		policies, err := app.LoadTLSPolicies(file)
+		if err != nil {
+			application.Logger.Error("failed to load tls policies", "error", err)
+			os.Exit(1)
+		}
+		if application.TLSPolicies, err = app.NewTLSPolicies(policies); err != nil {
+			application.Logger.Error("invalid tls policies", "error", err)
+			os.Exit(1)
+		}
+	}
+	if caFile := os.Getenv("TLS_CLIENT_CA_FILE"); caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			application.Logger.Error("failed to read client CA file", "error", err)
+			os.Exit(1)
+		}
+		tlsConfig.ClientCAs = x509.NewCertPool()
+		if !tlsConfig.ClientCAs.AppendCertsFromPEM(pem) {
+			application.Logger.Error("no certificates found in client CA file", "path", caFile)
+			os.Exit(1)
+		}
+		if err := application.Certificates.TrackPEM(caFile, app.CertRoleClientCA, pem); err != nil {
+			application.Logger.Error("invalid client CA file", "error", err)
+			os.Exit(1)
+		}
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	} else if application.TLSPolicies != nil && application.TLSPolicies.RequiresClientCerts() {
+		application.Logger.Error("tls policies require client certificates but TLS_CLIENT_CA_FILE is not set")
+		os.Exit(1)
+	}
+
+	preflight.CheckPort("proxy", *proxyAddr)
+	preflight.CheckPort("redirect", *redirectAddr)
+	preflight.CheckPort("internal", *internalAddr)
+	if *dev {
+		preflight.CheckPort("dev server one", *serverOneAddr)
+		preflight.CheckPort("dev server two", *serverTwoAddr)
+	}
+	preflight.Report(os.Stderr)
+	if preflight.Fatal() {
+		os.Exit(1)
+	}
+	if *preflightOnly {
+		os.Exit(0)
 	}
 
 	application.Logger.Info("MESSAGE FROM MAIN SERVER: APPLICATION IS RUNNING!!!")
@@ -43,34 +996,67 @@ func main() {
 	application.Start()
 
 	proxyServer := &http.Server{
-		Addr:         ":8443",
-		Handler:      application.RateLimit(application.Routes()),
+		Addr:         *proxyAddr,
+		Handler:      application.Handler(),
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 30 * time.Second,
+		TLSConfig:    tlsConfig,
+	}
+	if application.ClientStreams != nil {
+		application.ClientStreams.ConfigureServer(proxyServer)
 	}
+	// servers are the listeners whose client sessions drain on shutdown
+	servers := []*http.Server{proxyServer}
 
-	go func() {
-		application.Logger.Info("Starting test server one on :4200")
-		server_one.Serve()
-	}()
+	if *dev {
+		proxyURL := "https://" + localHost(*proxyAddr)
 
-	go func() {
-		application.Logger.Info("Starting test server two on :2200")
-		server_two.Serve()
-	}()
+		go func() {
+			application.Logger.Info("Starting test server one", "addr", *serverOneAddr)
+			server_one.Serve(*serverOneAddr, proxyURL)
+		}()
 
-	redirectServer := &http.Server{
-		Addr:    ":8080",
-		Handler: http.HandlerFunc(redirectHandler),
+		go func() {
+			application.Logger.Info("Starting test server two", "addr", *serverTwoAddr)
+			server_two.Serve(*serverTwoAddr, proxyURL)
+		}()
 	}
 
-	go func() {
-		application.Logger.Info("Starting redirect server on :8080")
-		if err := redirectServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			application.Logger.Error("Redirect server failed", "error", err)
+	if *redirectAddr != "" {
+		redirectServer := &http.Server{
+			Addr:    *redirectAddr,
+			Handler: httpRedirects.Handler(),
 		}
-	}()
+
+		go func() {
+			application.Logger.Info("Starting redirect server", "addr", *redirectAddr)
+			if err := redirectServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				application.Logger.Error("Redirect server failed", "error", err)
+			}
+		}()
+	}
+
+	// The internal listener serves the proxy over plaintext; with TLS
+	// policies only routes that allow_plaintext are served on it
+	if *internalAddr != "" {
+		internalServer := &http.Server{
+			Addr:         *internalAddr,
+			Handler:      application.Handler(),
+			IdleTimeout:  time.Minute,
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 30 * time.Second,
+		}
+
+		servers = append(servers, internalServer)
+
+		go func() {
+			application.Logger.Info("Starting internal plaintext server", "addr", *internalAddr)
+			if err := internalServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				application.Logger.Error("Internal server failed", "error", err)
+			}
+		}()
+	}
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
@@ -78,14 +1064,558 @@ func main() {
 	go func() {
 		<-sigChan
 		application.Logger.Info("Shutdown signal received, gracefully shutting down...")
+
+		// In-flight requests get a further 30 seconds once the drain
+		// period ends
+		ctx, cancel := context.WithTimeout(context.Background(), sessionDrain+30*time.Second)
+		defer cancel()
+		if err := application.DrainSessions(ctx, servers...); err != nil {
+			application.Logger.Warn("servers did not shut down cleanly", "error", err)
+		}
 		application.Shutdown()
 		os.Exit(0)
 	}()
 
-	application.Logger.Info("Starting reverse proxy server on :8443")
-	if err := proxyServer.ListenAndServeTLS("cert/cert.pem", "cert/key.pem"); err != nil {
+	listener, err := net.Listen("tcp", *proxyAddr)
+	if err != nil {
+		application.Logger.Error("failed to listen", "addr", *proxyAddr, "error", err)
+		application.Shutdown()
+		os.Exit(1)
+	}
+	if application.Connections != nil {
+		listener = application.Connections.Wrap(listener)
+	}
+
+	application.Logger.Info("Starting reverse proxy server", "addr", *proxyAddr)
+	if err := proxyServer.ServeTLS(listener, "", ""); err != nil && err != http.ErrServerClosed {
 		application.Logger.Error("Proxy server failed", "error", err)
 		application.Shutdown()
 		os.Exit(1)
 	}
+
+	// The signal handler exits once the drained servers have shut down
+	select {}
+}
+
+// poolConfig reads DB_MAX_OPEN_CONNS, DB_MAX_IDLE_CONNS, DB_CONN_MAX_LIFETIME,
+// DB_CONN_MAX_IDLE_TIME, DB_PING_INTERVAL and DB_QUERY_TIMEOUT
+func poolConfig() (registry.PoolConfig, error) {
+	var cfg registry.PoolConfig
+
+	for env, field := range map[string]*int{
+		"DB_MAX_OPEN_CONNS": &cfg.MaxOpenConns,
+		"DB_MAX_IDLE_CONNS": &cfg.MaxIdleConns,
+	} {
+		if v := os.Getenv(env); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return cfg, fmt.Errorf("%s must be a non-negative integer", env)
+			}
+			*field = n
+		}
+	}
+
+	for env, field := range map[string]*time.Duration{
+		"DB_CONN_MAX_LIFETIME":  &cfg.ConnMaxLifetime,
+		"DB_CONN_MAX_IDLE_TIME": &cfg.ConnMaxIdleTime,
+		"DB_PING_INTERVAL":      &cfg.PingInterval,
+		"DB_QUERY_TIMEOUT":      &cfg.QueryTimeout,
+	} {
+		if v := os.Getenv(env); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				return cfg, fmt.Errorf("%s must be a non-negative duration", env)
+			}
+			*field = d
+		}
+	}
+
+	return cfg, nil
+}
+
+// registryWebhookConfig reads REGISTRY_WEBHOOK_SECRET,
+// REGISTRY_WEBHOOK_MAX_ATTEMPTS, REGISTRY_WEBHOOK_MAX_BACKOFF and
+// REGISTRY_WEBHOOK_DEAD_LETTER_FILE for the comma separated urls
+func registryWebhookConfig(urls string) (app.RegistryWebhookConfig, error) {
+	cfg := app.RegistryWebhookConfig{
+		Secret:         os.Getenv("REGISTRY_WEBHOOK_SECRET"),
+		DeadLetterFile: os.Getenv("REGISTRY_WEBHOOK_DEAD_LETTER_FILE"),
+	}
+	for _, u := range strings.Split(urls, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			cfg.URLs = append(cfg.URLs, u)
+		}
+	}
+
+	if v := os.Getenv("REGISTRY_WEBHOOK_MAX_ATTEMPTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("REGISTRY_WEBHOOK_MAX_ATTEMPTS must be a non-negative integer")
+		}
+		cfg.MaxAttempts = n
+	}
+
+	if v := os.Getenv("REGISTRY_WEBHOOK_MAX_BACKOFF"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return cfg, fmt.Errorf("REGISTRY_WEBHOOK_MAX_BACKOFF must be a non-negative duration")
+		}
+		cfg.MaxBackoff = d
+	}
+
+	return cfg, nil
+}
+
+// connLimitConfig reads MAX_CONNS, MAX_CONNS_PER_IP and CONN_QUEUE_TIMEOUT
+func connLimitConfig() (app.ConnLimitConfig, error) {
+	var cfg app.ConnLimitConfig
+
+	if v := os.Getenv("MAX_CONNS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("MAX_CONNS must be a non-negative integer")
+		}
+		cfg.MaxConns = n
+	}
+
+	if v := os.Getenv("MAX_CONNS_PER_IP"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("MAX_CONNS_PER_IP must be a non-negative integer")
+		}
+		cfg.MaxConnsPerIP = n
+	}
+
+	if v := os.Getenv("CONN_QUEUE_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return cfg, fmt.Errorf("CONN_QUEUE_TIMEOUT must be a non-negative duration")
+		}
+		cfg.QueueTimeout = d
+	}
+
+	return cfg, nil
+}
+
+// clientStreamConfig reads MAX_STREAMS_PER_CLIENT, CLIENT_STREAM_CLASSES and
+// MAX_STREAMS_PER_CONN
+func clientStreamConfig() (app.ClientStreamConfig, error) {
+	var cfg app.ClientStreamConfig
+
+	if v := os.Getenv("MAX_STREAMS_PER_CLIENT"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("MAX_STREAMS_PER_CLIENT must be a non-negative integer")
+		}
+		cfg.MaxStreams = n
+	}
+
+	if v := os.Getenv("CLIENT_STREAM_CLASSES"); v != "" {
+		classes, err := app.ParseStreamClasses(v)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Classes = classes
+	}
+
+	if v := os.Getenv("MAX_STREAMS_PER_CONN"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("MAX_STREAMS_PER_CONN must be a non-negative integer")
+		}
+		cfg.MaxStreamsPerConn = n
+	}
+
+	return cfg, nil
+}
+
+// samplingConfig reads SAMPLE_RATE, SAMPLE_FILTERS, SAMPLE_CAPACITY and
+// SAMPLE_MAX_BODY_BYTES
+func samplingConfig() (app.SamplingConfig, error) {
+	var cfg app.SamplingConfig
+
+	if v := os.Getenv("SAMPLE_RATE"); v != "" {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return cfg, fmt.Errorf("SAMPLE_RATE must be between 0 and 1")
+		}
+		cfg.Rate = rate
+	}
+
+	if v := os.Getenv("SAMPLE_FILTERS"); v != "" {
+		filters, err := app.ParseSampleFilters(v)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Filters = filters
+	}
+
+	if v := os.Getenv("SAMPLE_CAPACITY"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("SAMPLE_CAPACITY must be a non-negative integer")
+		}
+		cfg.Capacity = n
+	}
+
+	if v := os.Getenv("SAMPLE_MAX_BODY_BYTES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("SAMPLE_MAX_BODY_BYTES must be a non-negative integer")
+		}
+		cfg.MaxBodyBytes = n
+	}
+
+	return cfg, nil
+}
+
+// routeMissConfig reads ROUTE_MISS_TTL and ROUTE_MISS_CAPACITY
+func routeMissConfig() (app.RouteMissConfig, error) {
+	var cfg app.RouteMissConfig
+
+	if v := os.Getenv("ROUTE_MISS_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return cfg, fmt.Errorf("ROUTE_MISS_TTL must be a non-negative duration")
+		}
+		cfg.TTL = d
+	}
+
+	if v := os.Getenv("ROUTE_MISS_CAPACITY"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("ROUTE_MISS_CAPACITY must be a non-negative integer")
+		}
+		cfg.Capacity = n
+	}
+
+	return cfg, nil
+}
+
+// admissionConfig reads MAX_IN_FLIGHT, ADMISSION_MAX_QUEUE,
+// ADMISSION_QUEUE_TIMEOUT and PRIORITY_CLASSES
+func admissionConfig() (app.AdmissionConfig, error) {
+	var cfg app.AdmissionConfig
+
+	if v := os.Getenv("MAX_IN_FLIGHT"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("MAX_IN_FLIGHT must be a non-negative integer")
+		}
+		cfg.MaxInFlight = n
+	}
+
+	if v := os.Getenv("ADMISSION_MAX_QUEUE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("ADMISSION_MAX_QUEUE must be a non-negative integer")
+		}
+		cfg.MaxQueue = n
+	}
+
+	if v := os.Getenv("ADMISSION_QUEUE_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return cfg, fmt.Errorf("ADMISSION_QUEUE_TIMEOUT must be a non-negative duration")
+		}
+		cfg.QueueTimeout = d
+	}
+
+	if v := os.Getenv("PRIORITY_CLASSES"); v != "" {
+		classes, err := app.ParsePriorityClasses(v)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Classes = classes
+	}
+
+	return cfg, nil
+}
+
+// selfMonitorConfig reads MEMORY_LIMIT, MEMORY_REJECT_RATIO and
+// SELF_MONITOR_INTERVAL over the defaults
+func selfMonitorConfig() (app.SelfMonitorConfig, error) {
+	cfg := app.DefaultSelfMonitorConfig()
+
+	if v := os.Getenv("MEMORY_LIMIT"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("MEMORY_LIMIT must be a non-negative number of bytes")
+		}
+		cfg.MemoryLimit = n
+	}
+
+	if v := os.Getenv("MEMORY_REJECT_RATIO"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return cfg, fmt.Errorf("MEMORY_REJECT_RATIO must be a number between 0 and 1")
+		}
+		cfg.RejectRatio = f
+	}
+
+	if v := os.Getenv("SELF_MONITOR_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return cfg, fmt.Errorf("SELF_MONITOR_INTERVAL must be a duration")
+		}
+		cfg.Interval = d
+	}
+
+	return cfg, nil
+}
+
+// stageShedConfig reads SHED_LATENCY_THRESHOLD, SHED_CPU_THRESHOLD and
+// SHED_INTERVAL for the comma separated stages
+func stageShedConfig(stages string) (app.StageShedConfig, error) {
+	var cfg app.StageShedConfig
+	for _, stage := range strings.Split(stages, ",") {
+		if stage = strings.TrimSpace(stage); stage != "" {
+			cfg.Stages = append(cfg.Stages, stage)
+		}
+	}
+
+	if v := os.Getenv("SHED_LATENCY_THRESHOLD"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return cfg, fmt.Errorf("SHED_LATENCY_THRESHOLD must be a non-negative duration")
+		}
+		cfg.LatencyThreshold = d
+	}
+
+	if v := os.Getenv("SHED_CPU_THRESHOLD"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return cfg, fmt.Errorf("SHED_CPU_THRESHOLD must be a number between 0 and 1")
+		}
+		cfg.CPUThreshold = f
+	}
+
+	if v := os.Getenv("SHED_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return cfg, fmt.Errorf("SHED_INTERVAL must be a non-negative duration")
+		}
+		cfg.Interval = d
+	}
+
+	return cfg, nil
+}
+
+// clusterConfig reads CLUSTER_PEERS, CLUSTER_ADVERTISE, CLUSTER_NODE_NAME,
+// CLUSTER_SECRET and CLUSTER_INSECURE
+func clusterConfig(bind string) app.ClusterConfig {
+	cfg := app.ClusterConfig{
+		BindAddr:      bind,
+		AdvertiseAddr: os.Getenv("CLUSTER_ADVERTISE"),
+		NodeName:      os.Getenv("CLUSTER_NODE_NAME"),
+		Secret:        os.Getenv("CLUSTER_SECRET"),
+		Insecure:      os.Getenv("CLUSTER_INSECURE") == "true",
+	}
+	for _, peer := range strings.Split(os.Getenv("CLUSTER_PEERS"), ",") {
+		if peer = strings.TrimSpace(peer); peer != "" {
+			cfg.Peers = append(cfg.Peers, peer)
+		}
+	}
+	return cfg
+}
+
+// headerLimitConfig reads UPSTREAM_MAX_HEADERS, UPSTREAM_MAX_HEADER_BYTES,
+// UPSTREAM_HEADER_LIMITS=Cookie=4096,... and UPSTREAM_STRIP_HEADERS=a,b,
+// reporting whether any of them is set
+func headerLimitConfig() (app.HeaderLimitConfig, bool, error) {
+	var cfg app.HeaderLimitConfig
+	enabled := false
+
+	counts := map[string]*int{
+		"UPSTREAM_MAX_HEADERS":      &cfg.MaxCount,
+		"UPSTREAM_MAX_HEADER_BYTES": &cfg.MaxBytes,
+	}
+	for name, dst := range counts {
+		if v := os.Getenv(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				return cfg, false, fmt.Errorf("%s must be a positive integer", name)
+			}
+			*dst = n
+			enabled = true
+		}
+	}
+
+	if v := os.Getenv("UPSTREAM_HEADER_LIMITS"); v != "" {
+		pairs, err := parsePairs("UPSTREAM_HEADER_LIMITS", v)
+		if err != nil {
+			return cfg, false, err
+		}
+		cfg.MaxValueBytes = make(map[string]int, len(pairs))
+		for name, value := range pairs {
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return cfg, false, fmt.Errorf("UPSTREAM_HEADER_LIMITS: %s needs a positive size", name)
+			}
+			cfg.MaxValueBytes[name] = n
+		}
+		enabled = true
+	}
+
+	for _, name := range strings.Split(os.Getenv("UPSTREAM_STRIP_HEADERS"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			cfg.Strip = append(cfg.Strip, name)
+			enabled = true
+		}
+	}
+
+	return cfg, enabled, nil
+}
+
+// resolverConfig reads DNS_CACHE_TTL, DNS_NEGATIVE_TTL, DNS_TIMEOUT and
+// DNS_NAMESERVERS, reporting whether any of them is set
+func resolverConfig() (app.ResolverConfig, bool, error) {
+	var cfg app.ResolverConfig
+	enabled := false
+
+	durations := map[string]*time.Duration{
+		"DNS_CACHE_TTL":    &cfg.CacheTTL,
+		"DNS_NEGATIVE_TTL": &cfg.NegativeTTL,
+		"DNS_TIMEOUT":      &cfg.Timeout,
+	}
+	for name, dst := range durations {
+		if v := os.Getenv(name); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				return cfg, false, fmt.Errorf("%s must be a positive duration", name)
+			}
+			*dst = d
+			enabled = true
+		}
+	}
+
+	for _, ns := range strings.Split(os.Getenv("DNS_NAMESERVERS"), ",") {
+		if ns = strings.TrimSpace(ns); ns != "" {
+			cfg.Nameservers = append(cfg.Nameservers, ns)
+			enabled = true
+		}
+	}
+
+	return cfg, enabled, nil
+}
+
+// upstreamConnConfig reads UPSTREAM_CONN_MAX_LIFETIME, UPSTREAM_IDLE_TIMEOUT
+// and UPSTREAM_KEEPALIVE, reporting whether any of them is set
+func upstreamConnConfig() (app.UpstreamConnConfig, bool, error) {
+	var cfg app.UpstreamConnConfig
+	enabled := false
+
+	durations := map[string]*time.Duration{
+		"UPSTREAM_CONN_MAX_LIFETIME": &cfg.MaxLifetime,
+		"UPSTREAM_IDLE_TIMEOUT":      &cfg.IdleTimeout,
+		"UPSTREAM_KEEPALIVE":         &cfg.KeepAlive,
+	}
+	for name, dst := range durations {
+		if v := os.Getenv(name); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				return cfg, false, fmt.Errorf("%s must be a positive duration", name)
+			}
+			*dst = d
+			enabled = true
+		}
+	}
+
+	return cfg, enabled, nil
+}
+
+// leaderBackend creates the leader election backend for a LEADER_ELECTION
+// value: "postgres" shares the registry's database, a redis:// URL uses Redis
+func leaderBackend(application *app.Application, election string) (app.LeaderBackend, error) {
+	if election == "postgres" {
+		reg, ok := application.Registry.(interface{ DB() *sql.DB })
+		if !ok {
+			return nil, fmt.Errorf("postgres leader election requires the PostgreSQL registry")
+		}
+		return app.NewPostgresLeaderBackend(reg.DB()), nil
+	}
+	if strings.HasPrefix(election, "redis://") || strings.HasPrefix(election, "rediss://") {
+		return app.NewRedisLeaderBackend(election, envOr("LEADER_KEY_PREFIX", "go-reverse-proxy:"))
+	}
+	return nil, fmt.Errorf("expected postgres or a redis:// URL, got %q", election)
+}
+
+// penaltyConfig reads TARPIT_AFTER, TARPIT_DELAY, TARPIT_MAX_DELAY,
+// BAN_AFTER, BAN_DURATION, BAN_MAX_DURATION and PENALTY_WINDOW
+func penaltyConfig() (app.PenaltyConfig, error) {
+	var cfg app.PenaltyConfig
+
+	counts := map[string]*int{
+		"TARPIT_AFTER": &cfg.TarpitAfter,
+		"BAN_AFTER":    &cfg.BanAfter,
+	}
+	for name, dst := range counts {
+		if v := os.Getenv(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return cfg, fmt.Errorf("%s must be a non-negative integer", name)
+			}
+			*dst = n
+		}
+	}
+
+	durations := map[string]*time.Duration{
+		"TARPIT_DELAY":     &cfg.TarpitDelay,
+		"TARPIT_MAX_DELAY": &cfg.MaxTarpitDelay,
+		"BAN_DURATION":     &cfg.BanDuration,
+		"BAN_MAX_DURATION": &cfg.MaxBanDuration,
+		"PENALTY_WINDOW":   &cfg.Window,
+	}
+	for name, dst := range durations {
+		if v := os.Getenv(name); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				return cfg, fmt.Errorf("%s must be a positive duration", name)
+			}
+			*dst = d
+		}
+	}
+
+	return cfg, nil
+}
+
+// parsePairs parses a comma separated list of name=value pairs from the
+// named environment variable
+func parsePairs(variable, spec string) (map[string]string, error) {
+	pairs := make(map[string]string)
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid %s entry %q, expected name=value", variable, part)
+		}
+		pairs[name] = value
+	}
+
+	return pairs, nil
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// localHost turns a listen address such as ":8443" into a host:port reachable
+// from this machine
+func localHost(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, port)
 }
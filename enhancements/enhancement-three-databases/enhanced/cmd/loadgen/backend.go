@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Latency distributions for synthetic backends
+const (
+	DistFixed       = "fixed"
+	DistUniform     = "uniform"
+	DistNormal      = "normal"
+	DistExponential = "exponential"
+)
+
+// BackendConfig shapes how a synthetic backend answers
+type BackendConfig struct {
+	// Latency is the mean response latency
+	Latency time.Duration
+	// Dist is how latencies are spread around the mean
+	Dist string
+	// ErrorRate is the fraction of requests answered with 500
+	ErrorRate float64
+	// ResponseSize is the body size of successful responses in bytes
+	ResponseSize int
+}
+
+// validate checks the distribution and rates
+func (cfg BackendConfig) validate() error {
+	switch cfg.Dist {
+	case DistFixed, DistUniform, DistNormal, DistExponential:
+	default:
+		return fmt.Errorf("unknown latency distribution %q", cfg.Dist)
+	}
+	if cfg.Latency < 0 {
+		return fmt.Errorf("latency must not be negative")
+	}
+	if cfg.ErrorRate < 0 || cfg.ErrorRate > 1 {
+		return fmt.Errorf("error rate must be between 0 and 1")
+	}
+	if cfg.ResponseSize < 0 {
+		return fmt.Errorf("response size must not be negative")
+	}
+	return nil
+}
+
+// latency draws a response latency from the configured distribution
+func (cfg BackendConfig) latency() time.Duration {
+	mean := float64(cfg.Latency)
+	var d float64
+	switch cfg.Dist {
+	case DistUniform:
+		d = rand.Float64() * 2 * mean
+	case DistNormal:
+		d = rand.NormFloat64()*mean/4 + mean
+	case DistExponential:
+		d = rand.ExpFloat64() * mean
+	default:
+		d = mean
+	}
+	return time.Duration(math.Max(d, 0))
+}
+
+// Backend is a synthetic backend listening on a loopback port
+type Backend struct {
+	Name    string
+	BaseURL string
+	server  *http.Server
+}
+
+// StartBackend starts a synthetic backend on a free loopback port. It
+// answers /health immediately and every other path after a drawn latency.
+func StartBackend(name string, cfg BackendConfig) (*Backend, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for backend %s: %w", name, err)
+	}
+
+	body := bytes.Repeat([]byte("x"), cfg.ResponseSize)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil {
+			r.Body.Close()
+		}
+		time.Sleep(cfg.latency())
+		if rand.Float64() < cfg.ErrorRate {
+			http.Error(w, "synthetic failure", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(body)
+	})
+
+	b := &Backend{
+		Name:    name,
+		BaseURL: "http://" + ln.Addr().String(),
+		server:  &http.Server{Handler: mux},
+	}
+	go b.server.Serve(ln)
+	return b, nil
+}
+
+// Close stops the backend
+func (b *Backend) Close() error {
+	return b.server.Close()
+}
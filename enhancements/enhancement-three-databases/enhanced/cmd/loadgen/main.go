@@ -0,0 +1,346 @@
+// Command loadgen drives HTTP traffic at a go-reverse-proxy and reports
+// throughput and latency. With -bench it starts synthetic backends and an
+// in-process proxy in front of them, so a run exercises the full router,
+// cache and breaker stack without any outside setup.
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/codytheroux96/go-reverse-proxy/proxy"
+)
+
+const usage = `Usage: loadgen [flags]
+
+Drives traffic at -target, or with -bench at an in-process proxy in front of
+synthetic backends, and reports throughput, status codes and latency.
+
+Examples:
+  loadgen -bench -d 10s -c 64 -latency 5ms -latency-dist exponential
+  loadgen -bench -error-rate 0.05 -unique-ratio 1
+  loadgen -target https://localhost:8443 -insecure -paths /s1/health,/s2/health
+
+Flags:
+`
+
+// benchPrefix is the route the synthetic backends are registered under
+const benchPrefix = "/bench"
+
+// load describes the traffic to drive
+type load struct {
+	target      string
+	paths       []string
+	method      string
+	body        []byte
+	concurrency int
+	duration    time.Duration
+	requests    int
+	rps         float64
+	uniqueRatio float64
+}
+
+func main() {
+	fs := flag.NewFlagSet("loadgen", flag.ExitOnError)
+	target := fs.String("target", "", "base URL of a running proxy to drive")
+	paths := fs.String("paths", "", "comma-separated request paths (default /s1/health, or /bench/items with -bench)")
+	method := fs.String("method", http.MethodGet, "request method: GET or POST")
+	bodySize := fs.Int("body-size", 0, "request body size in bytes for POST")
+	concurrency := fs.Int("c", 32, "concurrent workers")
+	duration := fs.Duration("d", 10*time.Second, "run duration; 0 runs until -n requests are sent")
+	requests := fs.Int("n", 0, "total requests to send; 0 sends until -d elapses")
+	rps := fs.Float64("rps", 0, "overall request rate; 0 is unlimited")
+	uniqueRatio := fs.Float64("unique-ratio", 0, "fraction of requests with a unique query string, which misses the cache")
+	insecure := fs.Bool("insecure", false, "skip TLS certificate verification")
+	output := fs.String("o", "table", "output format: table or json")
+	verbose := fs.Bool("v", false, "log the in-process proxy's output to stderr")
+
+	bench := fs.Bool("bench", false, "start synthetic backends and an in-process proxy and drive them")
+	backends := fs.Int("backends", 3, "synthetic backends in bench mode")
+	latency := fs.Duration("latency", 5*time.Millisecond, "mean synthetic backend latency")
+	latencyDist := fs.String("latency-dist", DistFixed, "synthetic latency distribution: fixed, uniform, normal or exponential")
+	errorRate := fs.Float64("error-rate", 0, "fraction of synthetic backend responses that are 500s")
+	responseSize := fs.Int("response-size", 1024, "synthetic backend response size in bytes")
+	cacheTTL := fs.Duration("cache-ttl", 0, "in-process proxy cache TTL; 0 keeps the proxy default")
+	coalesce := fs.Bool("coalesce", false, "enable request coalescing in the in-process proxy")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(os.Args[1:])
+
+	if *output != "table" && *output != "json" {
+		fatalf("unknown output format %q", *output)
+	}
+	if *method != http.MethodGet && *method != http.MethodPost {
+		fatalf("unsupported method %q", *method)
+	}
+	if *concurrency <= 0 {
+		fatalf("-c must be positive")
+	}
+	if *duration <= 0 && *requests <= 0 {
+		fatalf("one of -d or -n must be positive")
+	}
+	if *uniqueRatio < 0 || *uniqueRatio > 1 {
+		fatalf("-unique-ratio must be between 0 and 1")
+	}
+	if *bench == (*target != "") {
+		fatalf("pass exactly one of -target or -bench")
+	}
+
+	l := load{
+		target:      strings.TrimSuffix(*target, "/"),
+		method:      *method,
+		concurrency: *concurrency,
+		duration:    *duration,
+		requests:    *requests,
+		rps:         *rps,
+		uniqueRatio: *uniqueRatio,
+	}
+	if *method == http.MethodPost {
+		l.body = bytes.Repeat([]byte("x"), *bodySize)
+	}
+
+	if *bench {
+		cfg := BackendConfig{
+			Latency:      *latency,
+			Dist:         *latencyDist,
+			ErrorRate:    *errorRate,
+			ResponseSize: *responseSize,
+		}
+		stop, addr, err := startBench(*backends, cfg, *cacheTTL, *coalesce, *verbose)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		defer stop()
+		l.target = addr
+	}
+
+	switch {
+	case *paths != "":
+		l.paths = strings.Split(*paths, ",")
+	case *bench:
+		l.paths = []string{benchPrefix + "/items"}
+	default:
+		l.paths = []string{"/s1/health"}
+	}
+
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			MaxIdleConnsPerHost: *concurrency,
+			TLSClientConfig:     &tls.Config{InsecureSkipVerify: *insecure},
+		},
+	}
+
+	if *bench {
+		if err := waitReady(client, l.target+l.paths[0], 15*time.Second); err != nil {
+			fatalf("%v", err)
+		}
+	}
+
+	report := l.run(client)
+	if err := report.print(*output); err != nil {
+		fatalf("%v", err)
+	}
+}
+
+// startBench starts the synthetic backends and an in-process proxy routing
+// benchPrefix to them, returning the proxy's base URL and a stop function
+func startBench(n int, cfg BackendConfig, cacheTTL time.Duration, coalesce, verbose bool) (func(), string, error) {
+	if n <= 0 {
+		return nil, "", fmt.Errorf("-backends must be positive")
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, "", err
+	}
+
+	logger := slog.New(slog.DiscardHandler)
+	if verbose {
+		logger = slog.New(slog.NewTextHandler(os.Stderr, nil))
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to listen for the proxy: %w", err)
+	}
+
+	opts := []proxy.Option{
+		proxy.WithLogger(logger),
+		proxy.WithListener(ln),
+		proxy.WithRateLimit(0, 0),
+	}
+	if cacheTTL > 0 {
+		opts = append(opts, proxy.WithCache(proxy.NewCache(cacheTTL, 64<<20, logger)))
+	}
+	if coalesce {
+		opts = append(opts, proxy.WithRequestCoalescing())
+	}
+	p, err := proxy.New(opts...)
+	if err != nil {
+		ln.Close()
+		return nil, "", err
+	}
+	go p.Serve()
+
+	var started []*Backend
+	stop := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		p.Shutdown(ctx)
+		for _, b := range started {
+			b.Close()
+		}
+	}
+
+	for i := 1; i <= n; i++ {
+		b, err := StartBackend(fmt.Sprintf("bench_%d", i), cfg)
+		if err != nil {
+			stop()
+			return nil, "", err
+		}
+		started = append(started, b)
+		p.Registry().Register(proxy.Server{Name: b.Name, BaseURL: b.BaseURL, Prefixes: []string{benchPrefix}})
+	}
+
+	return stop, "http://" + ln.Addr().String(), nil
+}
+
+// waitReady polls a URL until the proxy stops answering 503, which it does
+// until the first health check has marked the backends healthy
+func waitReady(client *http.Client, target string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		resp, err := client.Get(target + "?loadgen=ready")
+		if err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusServiceUnavailable {
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("proxy not ready after %s", timeout)
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+// run drives the load with l.concurrency workers until the duration elapses
+// or the request count is reached
+func (l load) run(client *http.Client) Report {
+	ctx := context.Background()
+	if l.duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, l.duration)
+		defer cancel()
+	}
+
+	var ticks <-chan time.Time
+	if l.rps > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / l.rps))
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	var sent atomic.Int64
+	recorders := make([]*recorder, l.concurrency)
+	var wg sync.WaitGroup
+	start := time.Now()
+
+	for w := range recorders {
+		rec := newRecorder()
+		recorders[w] = rec
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := sent.Add(1)
+				if l.requests > 0 && i > int64(l.requests) {
+					return
+				}
+				if ticks != nil {
+					select {
+					case <-ticks:
+					case <-ctx.Done():
+						return
+					}
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				l.do(ctx, client, i, rec)
+			}
+		}()
+	}
+
+	wg.Wait()
+	return newReport(recorders, time.Since(start))
+}
+
+// do sends one request and records its outcome; requests cut off by the end
+// of the run are not recorded
+func (l load) do(ctx context.Context, client *http.Client, i int64, rec *recorder) {
+	target := l.target + l.paths[int(i)%len(l.paths)]
+	if rand.Float64() < l.uniqueRatio {
+		sep := "?"
+		if strings.Contains(target, "?") {
+			sep = "&"
+		}
+		target += sep + "loadgen=" + strconv.FormatInt(i, 10)
+	}
+
+	var body io.Reader
+	if l.body != nil {
+		body = bytes.NewReader(l.body)
+	}
+	req, err := http.NewRequestWithContext(ctx, l.method, target, body)
+	if err != nil {
+		rec.errors[err.Error()]++
+		return
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		rec.errors[err.Error()]++
+		return
+	}
+	n, err := io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if err != nil && ctx.Err() != nil {
+		return
+	}
+
+	rec.latencies = append(rec.latencies, time.Since(start))
+	rec.statuses[resp.StatusCode]++
+	rec.bytes += n
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "loadgen: "+format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// recorder collects one worker's results; workers never share a recorder
+type recorder struct {
+	latencies []time.Duration
+	statuses  map[int]int
+	errors    map[string]int
+	bytes     int64
+}
+
+func newRecorder() *recorder {
+	return &recorder{statuses: make(map[int]int), errors: make(map[string]int)}
+}
+
+// Report summarizes a load run
+type Report struct {
+	Requests   int            `json:"requests"`
+	Duration   time.Duration  `json:"duration_ns"`
+	Throughput float64        `json:"throughput_rps"`
+	Statuses   map[string]int `json:"statuses"`
+	Errors     map[string]int `json:"errors,omitempty"`
+	Bytes      int64          `json:"bytes"`
+	Latency    LatencyReport  `json:"latency"`
+}
+
+// LatencyReport summarizes the latencies of completed requests
+type LatencyReport struct {
+	Mean time.Duration `json:"mean_ns"`
+	P50  time.Duration `json:"p50_ns"`
+	P90  time.Duration `json:"p90_ns"`
+	P99  time.Duration `json:"p99_ns"`
+	Max  time.Duration `json:"max_ns"`
+}
+
+// newReport merges the workers' recorders into a report
+func newReport(recorders []*recorder, elapsed time.Duration) Report {
+	report := Report{
+		Duration: elapsed,
+		Statuses: make(map[string]int),
+		Errors:   make(map[string]int),
+	}
+
+	var latencies []time.Duration
+	for _, rec := range recorders {
+		latencies = append(latencies, rec.latencies...)
+		for status, count := range rec.statuses {
+			report.Statuses[strconv.Itoa(status)] += count
+			report.Requests += count
+		}
+		for err, count := range rec.errors {
+			report.Errors[err] += count
+			report.Requests += count
+		}
+		report.Bytes += rec.bytes
+	}
+	if elapsed > 0 {
+		report.Throughput = float64(report.Requests) / elapsed.Seconds()
+	}
+
+	if len(latencies) == 0 {
+		return report
+	}
+	slices.Sort(latencies)
+	var total time.Duration
+	for _, latency := range latencies {
+		total += latency
+	}
+	report.Latency = LatencyReport{
+		Mean: total / time.Duration(len(latencies)),
+		P50:  percentile(latencies, 0.50),
+		P90:  percentile(latencies, 0.90),
+		P99:  percentile(latencies, 0.99),
+		Max:  latencies[len(latencies)-1],
+	}
+	return report
+}
+
+// percentile returns the nearest-rank percentile of sorted latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(float64(len(sorted))*p+0.5) - 1
+	return sorted[max(0, min(i, len(sorted)-1))]
+}
+
+// print writes the report as a table or JSON
+func (report Report) print(output string) error {
+	if output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "requests\t%d\n", report.Requests)
+	fmt.Fprintf(tw, "duration\t%s\n", report.Duration.Round(time.Millisecond))
+	fmt.Fprintf(tw, "throughput\t%.1f req/s\n", report.Throughput)
+	fmt.Fprintf(tw, "transferred\t%d bytes\n", report.Bytes)
+	fmt.Fprintf(tw, "latency mean\t%s\n", report.Latency.Mean.Round(time.Microsecond))
+	fmt.Fprintf(tw, "latency p50\t%s\n", report.Latency.P50.Round(time.Microsecond))
+	fmt.Fprintf(tw, "latency p90\t%s\n", report.Latency.P90.Round(time.Microsecond))
+	fmt.Fprintf(tw, "latency p99\t%s\n", report.Latency.P99.Round(time.Microsecond))
+	fmt.Fprintf(tw, "latency max\t%s\n", report.Latency.Max.Round(time.Microsecond))
+	for _, status := range sortedKeys(report.Statuses) {
+		fmt.Fprintf(tw, "status %s\t%d\n", status, report.Statuses[status])
+	}
+	for _, err := range sortedKeys(report.Errors) {
+		fmt.Fprintf(tw, "error %q\t%d\n", err, report.Errors[err])
+	}
+	return tw.Flush()
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client talks to the proxy's registry and admin API
+type Client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+// NewClient creates a client for the proxy at baseURL
+func NewClient(baseURL, token string, insecure bool) *Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	return &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		token:   token,
+		http:    &http.Client{Timeout: 10 * time.Second, Transport: transport},
+	}
+}
+
+// APIError is a non-2xx response from the proxy
+type APIError struct {
+	Status  int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("proxy returned %d: %s", e.Status, e.Message)
+}
+
+// do sends a request with an optional JSON body and decodes a JSON response into out
+func (c *Client) do(method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	req.Header.Set("X-Admin-Actor", "proxyctl")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach proxy: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &APIError{Status: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// Server mirrors registry.Server
+type Server struct {
+	Name         string    `json:"name"`
+	BaseURL      string    `json:"base_url"`
+	Prefixes     []string  `json:"routes"`
+	Zone         string    `json:"zone,omitempty"`
+	Service      string    `json:"service,omitempty"`
+	RegisteredAt time.Time `json:"registered_at"`
+}
+
+// ListServers returns every registered server. The in-memory registry wraps
+// the list in {"servers": [...]} while the PostgreSQL registry returns a bare
+// array, so both shapes are accepted.
+func (c *Client) ListServers() ([]Server, error) {
+	var raw json.RawMessage
+	if err := c.do(http.MethodGet, "/registry", nil, &raw); err != nil {
+		return nil, err
+	}
+
+	var servers []Server
+	if bytes.HasPrefix(bytes.TrimSpace(raw), []byte("[")) {
+		if err := json.Unmarshal(raw, &servers); err != nil {
+			return nil, fmt.Errorf("failed to decode servers: %w", err)
+		}
+		return servers, nil
+	}
+
+	var wrapped struct {
+		Servers []Server `json:"servers"`
+	}
+	if err := json.Unmarshal(raw, &wrapped); err != nil {
+		return nil, fmt.Errorf("failed to decode servers: %w", err)
+	}
+	return wrapped.Servers, nil
+}
+
+// Register registers a server with the proxy
+func (c *Client) Register(s Server) error {
+	return c.do(http.MethodPost, "/register", s, nil)
+}
+
+// Deregister removes a server. The in-memory registry takes a POST body and
+// the PostgreSQL registry a DELETE with a query parameter, so a 405 on the
+// first form falls back to the second.
+func (c *Client) Deregister(name string) error {
+	err := c.do(http.MethodPost, "/deregister", map[string]string{"name": name}, nil)
+	if apiErr, ok := err.(*APIError); ok && apiErr.Status == http.StatusMethodNotAllowed {
+		return c.do(http.MethodDelete, "/deregister?name="+url.QueryEscape(name), nil, nil)
+	}
+	return err
+}
+
+// HealthStatus mirrors app.HealthStatus
+type HealthStatus struct {
+	IsHealthy           bool          `json:"is_healthy"`
+	IsLive              bool          `json:"is_live"`
+	LastChecked         time.Time     `json:"last_checked"`
+	ConsecutiveFailures int           `json:"consecutive_failures"`
+	LastResponseTime    time.Duration `json:"last_response_time"`
+}
+
+// Health returns the health status of every backend
+func (c *Client) Health() (map[string]HealthStatus, error) {
+	var resp struct {
+		Servers map[string]HealthStatus `json:"servers"`
+	}
+	err := c.do(http.MethodGet, "/admin/health", nil, &resp)
+	return resp.Servers, err
+}
+
+// Breaker is a circuit breaker's state as reported by the admin API
+type Breaker struct {
+	State        string    `json:"state"`
+	Failures     int       `json:"failures"`
+	LastOpenTime time.Time `json:"last_open_time"`
+	InFlight     int       `json:"in_flight"`
+}
+
+// Breakers returns the state of every circuit breaker
+func (c *Client) Breakers() (map[string]Breaker, error) {
+	var resp struct {
+		Breakers map[string]Breaker `json:"breakers"`
+	}
+	err := c.do(http.MethodGet, "/admin/breakers", nil, &resp)
+	return resp.Breakers, err
+}
+
+// ResetBreaker closes the circuit breaker for a server
+func (c *Client) ResetBreaker(server string) error {
+	return c.do(http.MethodPost, "/admin/breakers/reset", map[string]string{"server": server}, nil)
+}
+
+// PurgeCache drops a single cache key, or every entry when key is empty
+func (c *Client) PurgeCache(key string) (int, error) {
+	var body interface{}
+	if key != "" {
+		body = map[string]string{"key": key}
+	}
+
+	var resp struct {
+		Entries int `json:"entries"`
+	}
+	err := c.do(http.MethodPost, "/admin/cache/purge", body, &resp)
+	return resp.Entries, err
+}
+
+// RateLimit mirrors the /admin/ratelimit payload
+type RateLimit struct {
+	Enabled   *bool             `json:"enabled,omitempty"`
+	RPS       *float64          `json:"rps,omitempty"`
+	Burst     *int              `json:"burst,omitempty"`
+	Algorithm *string           `json:"algorithm,omitempty"`
+	Routes    map[string]string `json:"routes,omitempty"`
+	SoftRPS   *float64          `json:"soft_rps,omitempty"`
+	SoftBurst *int              `json:"soft_burst,omitempty"`
+	Costs     map[string]int    `json:"costs,omitempty"`
+}
+
+// GetRateLimit returns the current rate limiter settings
+func (c *Client) GetRateLimit() (RateLimit, error) {
+	var rl RateLimit
+	err := c.do(http.MethodGet, "/admin/ratelimit", nil, &rl)
+	return rl, err
+}
+
+// SetRateLimit updates the fields set in rl and returns the new settings
+func (c *Client) SetRateLimit(rl RateLimit) (RateLimit, error) {
+	var updated RateLimit
+	err := c.do(http.MethodPut, "/admin/ratelimit", rl, &updated)
+	return updated, err
+}
+
+// BlueGreenRoute mirrors app.BlueGreenRoute
+type BlueGreenRoute struct {
+	Prefix       string              `json:"prefix"`
+	Groups       map[string][]string `json:"groups"`
+	Active       string              `json:"active"`
+	Canary       string              `json:"canary,omitempty"`
+	CanaryWeight int                 `json:"canary_weight,omitempty"`
+	Previous     string              `json:"previous,omitempty"`
+	SwitchedAt   *time.Time          `json:"switched_at,omitempty"`
+}
+
+// BlueGreenRoutes returns every blue/green route
+func (c *Client) BlueGreenRoutes() ([]BlueGreenRoute, error) {
+	var resp struct {
+		Routes []BlueGreenRoute `json:"routes"`
+	}
+	err := c.do(http.MethodGet, "/admin/bluegreen", nil, &resp)
+	return resp.Routes, err
+}
+
+// Cutover is the result of a blue/green cutover
+type Cutover struct {
+	Status string `json:"status"`
+	Prefix string `json:"prefix"`
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+	Active string `json:"active,omitempty"`
+	Purged int    `json:"purged"`
+}
+
+// Cutover switches a prefix to a backend group, or to the other group when
+// group is empty. Unless force is set the proxy health checks the group first.
+func (c *Client) Cutover(prefix, group string, force bool) (Cutover, error) {
+	var resp Cutover
+	body := map[string]interface{}{"prefix": prefix, "to": group, "force": force}
+	err := c.do(http.MethodPost, "/admin/bluegreen/cutover", body, &resp)
+	return resp, err
+}
+
+// ExperimentVariant mirrors app.ExperimentVariant
+type ExperimentVariant struct {
+	Name    string   `json:"name"`
+	Weight  int      `json:"weight"`
+	Servers []string `json:"servers"`
+}
+
+// Experiment mirrors app.ExperimentStatus
+type Experiment struct {
+	Name       string              `json:"name"`
+	Prefix     string              `json:"prefix"`
+	Cookie     string              `json:"cookie,omitempty"`
+	UserHeader string              `json:"user_header,omitempty"`
+	Variants   []ExperimentVariant `json:"variants"`
+	Assigned   map[string]uint64   `json:"assigned"`
+}
+
+// Experiments returns every A/B experiment with its assignment counts
+func (c *Client) Experiments() ([]Experiment, error) {
+	var resp struct {
+		Experiments []Experiment `json:"experiments"`
+	}
+	err := c.do(http.MethodGet, "/admin/experiments", nil, &resp)
+	return resp.Experiments, err
+}
+
+// TrafficRamp mirrors app.TrafficRampStatus
+type TrafficRamp struct {
+	Prefix        string    `json:"prefix"`
+	Canary        string    `json:"canary"`
+	Steps         []int     `json:"steps"`
+	StepDuration  string    `json:"step_duration,omitempty"`
+	MaxErrorRate  float64   `json:"max_error_rate"`
+	MaxP99Latency string    `json:"max_p99_latency,omitempty"`
+	MinRequests   uint64    `json:"min_requests"`
+	OnBreach      string    `json:"on_breach"`
+	State         string    `json:"state"`
+	Weight        int       `json:"weight"`
+	Step          int       `json:"step"`
+	StartedAt     time.Time `json:"started_at"`
+	StepStartedAt time.Time `json:"step_started_at"`
+	Reason        string    `json:"reason,omitempty"`
+	Window        struct {
+		Requests   uint64  `json:"requests"`
+		Failures   uint64  `json:"failures"`
+		ErrorRate  float64 `json:"error_rate"`
+		P99Seconds float64 `json:"p99_seconds"`
+	} `json:"window"`
+}
+
+// Ramps returns every traffic ramp
+func (c *Client) Ramps() ([]TrafficRamp, error) {
+	var resp struct {
+		Ramps []TrafficRamp `json:"ramps"`
+	}
+	err := c.do(http.MethodGet, "/admin/ramps", nil, &resp)
+	return resp.Ramps, err
+}
+
+// StartRamp starts ramping traffic to a canary group; unset fields of
+// ramp take the proxy's defaults
+func (c *Client) StartRamp(ramp TrafficRamp) (TrafficRamp, error) {
+	var resp TrafficRamp
+	body := map[string]interface{}{
+		"prefix":          ramp.Prefix,
+		"canary":          ramp.Canary,
+		"steps":           ramp.Steps,
+		"step_duration":   ramp.StepDuration,
+		"max_error_rate":  ramp.MaxErrorRate,
+		"max_p99_latency": ramp.MaxP99Latency,
+		"min_requests":    ramp.MinRequests,
+		"on_breach":       ramp.OnBreach,
+	}
+	err := c.do(http.MethodPost, "/admin/ramps", body, &resp)
+	return resp, err
+}
+
+// RampAction pauses, resumes or rolls back the ramp for a prefix
+func (c *Client) RampAction(prefix, action string) (TrafficRamp, error) {
+	var resp TrafficRamp
+	body := map[string]interface{}{"prefix": prefix, "action": action}
+	err := c.do(http.MethodPost, "/admin/ramps/action", body, &resp)
+	return resp, err
+}
+
+// AccessLogEntry mirrors app.RecentAccessLogEntry
+type AccessLogEntry struct {
+	Seq        int64         `json:"seq"`
+	Time       time.Time     `json:"time"`
+	RequestID  string        `json:"request_id"`
+	ClientIP   string        `json:"client_ip"`
+	Method     string        `json:"method"`
+	Path       string        `json:"path"`
+	Status     int           `json:"status"`
+	BytesOut   int64         `json:"bytes_out"`
+	Duration   time.Duration `json:"duration"`
+	Experiment string        `json:"experiment,omitempty"`
+}
+
+// AccessLog returns up to limit access log entries after the given sequence number
+func (c *Client) AccessLog(after int64, limit int) ([]AccessLogEntry, error) {
+	var resp struct {
+		Entries []AccessLogEntry `json:"entries"`
+	}
+	path := fmt.Sprintf("/admin/accesslog?after=%d&limit=%d", after, limit)
+	err := c.do(http.MethodGet, path, nil, &resp)
+	return resp.Entries, err
+}
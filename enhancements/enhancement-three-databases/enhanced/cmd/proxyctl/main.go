@@ -0,0 +1,564 @@
+// Command proxyctl operates a running go-reverse-proxy through its registry
+// and admin API.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `Usage: proxyctl [flags] <command> [args]
+
+Commands:
+  services list                                   list registered services
+  services register -name N -url U -routes /a,/b
+                    [-zone Z] [-service S]        register a service
+  services deregister NAME                        deregister a service
+  health                                          show backend health
+  breakers                                        show circuit breaker states
+  breakers reset NAME                             close a server's circuit breaker
+  cache purge [KEY]                               purge one cache key or the whole cache
+  ratelimit                                       show rate limiter settings
+  ratelimit set [-enabled B] [-rps R] [-burst N] [-algorithm A]
+                [-soft-rps R] [-soft-burst N]     adjust rate limiter settings
+  bluegreen                                       show blue/green routes and their active groups
+  bluegreen cutover [-force] PREFIX [GROUP]       switch a prefix to GROUP, or to the other group
+  ramps                                           show traffic ramps and their progress
+  ramps start [-steps 5,25,50,100] [-step-duration D] [-max-error-rate F]
+              [-max-p99 D] [-min-requests N] [-on-breach rollback|pause]
+              PREFIX CANARY                       ramp a prefix's traffic to a canary group
+  ramps pause|resume|rollback PREFIX              control a running ramp
+  experiments                                     show A/B experiments and their variant assignments
+  logs [-f] [-n N]                                show (and follow) recent access logs
+
+Flags:
+`
+
+// cli holds global options shared by every command
+type cli struct {
+	client *Client
+	output string
+}
+
+func main() {
+	fs := flag.NewFlagSet("proxyctl", flag.ExitOnError)
+	addr := fs.String("addr", envOr("PROXYCTL_ADDR", "https://localhost:8443"), "proxy base URL (env PROXYCTL_ADDR)")
+	token := fs.String("token", os.Getenv("ADMIN_TOKEN"), "admin bearer token (env ADMIN_TOKEN)")
+	insecure := fs.Bool("insecure", false, "skip TLS certificate verification")
+	output := fs.String("o", "table", "output format: table or json")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(os.Args[1:])
+
+	if *output != "table" && *output != "json" {
+		fatalf("unknown output format %q", *output)
+	}
+
+	args := fs.Args()
+	if len(args) == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	c := &cli{client: NewClient(*addr, *token, *insecure), output: *output}
+
+	var err error
+	switch args[0] {
+	case "services":
+		err = c.services(args[1:])
+	case "health":
+		err = c.health()
+	case "breakers":
+		err = c.breakers(args[1:])
+	case "cache":
+		err = c.cache(args[1:])
+	case "ratelimit":
+		err = c.ratelimit(args[1:])
+	case "bluegreen":
+		err = c.bluegreen(args[1:])
+	case "ramps":
+		err = c.ramps(args[1:])
+	case "experiments":
+		err = c.experiments()
+	case "logs":
+		err = c.logs(args[1:])
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fatalf("%v", err)
+	}
+}
+
+func (c *cli) services(args []string) error {
+	if len(args) == 0 || args[0] == "list" {
+		servers, err := c.client.ListServers()
+		if err != nil {
+			return err
+		}
+		if c.output == "json" {
+			return printJSON(servers)
+		}
+
+		sort.Slice(servers, func(i, j int) bool { return servers[i].Name < servers[j].Name })
+		tw := newTable("NAME", "URL", "ROUTES", "ZONE", "SERVICE", "REGISTERED")
+		for _, s := range servers {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", s.Name, s.BaseURL, strings.Join(s.Prefixes, ","), s.Zone, s.Service, s.RegisteredAt.Format(time.RFC3339))
+		}
+		return tw.Flush()
+	}
+
+	switch args[0] {
+	case "register":
+		fs := flag.NewFlagSet("services register", flag.ExitOnError)
+		name := fs.String("name", "", "service name")
+		baseURL := fs.String("url", "", "service base URL")
+		routes := fs.String("routes", "", "comma separated route prefixes")
+		zone := fs.String("zone", "", "zone or region the service runs in")
+		service := fs.String("service", "", "logical service this instance belongs to")
+		fs.Parse(args[1:])
+
+		if *name == "" || *baseURL == "" || *routes == "" {
+			return fmt.Errorf("-name, -url and -routes are required")
+		}
+
+		if err := c.client.Register(Server{Name: *name, BaseURL: *baseURL, Prefixes: strings.Split(*routes, ","), Zone: *zone, Service: *service}); err != nil {
+			return err
+		}
+		return c.done(map[string]string{"status": "registered", "server": *name})
+
+	case "deregister":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: proxyctl services deregister NAME")
+		}
+		if err := c.client.Deregister(args[1]); err != nil {
+			return err
+		}
+		return c.done(map[string]string{"status": "deregistered", "server": args[1]})
+	}
+
+	return fmt.Errorf("unknown services command %q", args[0])
+}
+
+func (c *cli) health() error {
+	statuses, err := c.client.Health()
+	if err != nil {
+		return err
+	}
+	if c.output == "json" {
+		return printJSON(statuses)
+	}
+
+	tw := newTable("SERVER", "READY", "LIVE", "FAILURES", "RESPONSE TIME", "LAST CHECKED")
+	for _, name := range sortedKeys(statuses) {
+		s := statuses[name]
+		fmt.Fprintf(tw, "%s\t%t\t%t\t%d\t%s\t%s\n", name, s.IsHealthy, s.IsLive, s.ConsecutiveFailures, s.LastResponseTime, s.LastChecked.Format(time.RFC3339))
+	}
+	return tw.Flush()
+}
+
+func (c *cli) breakers(args []string) error {
+	if len(args) > 0 {
+		if args[0] != "reset" || len(args) != 2 {
+			return fmt.Errorf("usage: proxyctl breakers reset NAME")
+		}
+		if err := c.client.ResetBreaker(args[1]); err != nil {
+			return err
+		}
+		return c.done(map[string]string{"status": "reset", "server": args[1]})
+	}
+
+	breakers, err := c.client.Breakers()
+	if err != nil {
+		return err
+	}
+	if c.output == "json" {
+		return printJSON(breakers)
+	}
+
+	tw := newTable("SERVER", "STATE", "FAILURES", "IN FLIGHT", "LAST OPENED")
+	for _, name := range sortedKeys(breakers) {
+		b := breakers[name]
+		lastOpen := "-"
+		if !b.LastOpenTime.IsZero() {
+			lastOpen = b.LastOpenTime.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\n", name, b.State, b.Failures, b.InFlight, lastOpen)
+	}
+	return tw.Flush()
+}
+
+func (c *cli) cache(args []string) error {
+	if len(args) == 0 || args[0] != "purge" || len(args) > 2 {
+		return fmt.Errorf("usage: proxyctl cache purge [KEY]")
+	}
+
+	key := ""
+	if len(args) == 2 {
+		key = args[1]
+	}
+
+	purged, err := c.client.PurgeCache(key)
+	if err != nil {
+		return err
+	}
+	return c.done(map[string]interface{}{"status": "purged", "entries": purged})
+}
+
+func (c *cli) ratelimit(args []string) error {
+	var (
+		rl  RateLimit
+		err error
+	)
+
+	switch {
+	case len(args) == 0:
+		rl, err = c.client.GetRateLimit()
+
+	case args[0] == "set":
+		fs := flag.NewFlagSet("ratelimit set", flag.ExitOnError)
+		enabled := fs.String("enabled", "", "enable or disable rate limiting (true/false)")
+		rps := fs.Float64("rps", 0, "requests per second")
+		burst := fs.Int("burst", 0, "burst size")
+		algorithm := fs.String("algorithm", "", "token_bucket, fixed_window, sliding_log or gcra")
+		softRPS := fs.Float64("soft-rps", -1, "soft limit in requests per second (0 disables)")
+		softBurst := fs.Int("soft-burst", -1, "soft limit burst size (0 scales the hard burst)")
+		fs.Parse(args[1:])
+
+		var update RateLimit
+		if *enabled != "" {
+			b, perr := strconv.ParseBool(*enabled)
+			if perr != nil {
+				return fmt.Errorf("invalid -enabled value %q", *enabled)
+			}
+			update.Enabled = &b
+		}
+		if *rps != 0 {
+			update.RPS = rps
+		}
+		if *burst != 0 {
+			update.Burst = burst
+		}
+		if *algorithm != "" {
+			update.Algorithm = algorithm
+		}
+		if *softRPS >= 0 {
+			update.SoftRPS = softRPS
+		}
+		if *softBurst >= 0 {
+			update.SoftBurst = softBurst
+		}
+		if update.Enabled == nil && update.RPS == nil && update.Burst == nil && update.Algorithm == nil &&
+			update.SoftRPS == nil && update.SoftBurst == nil {
+			return fmt.Errorf("nothing to update; pass -enabled, -rps, -burst, -algorithm, -soft-rps or -soft-burst")
+		}
+
+		rl, err = c.client.SetRateLimit(update)
+
+	default:
+		return fmt.Errorf("unknown ratelimit command %q", args[0])
+	}
+
+	if err != nil {
+		return err
+	}
+	if c.output == "json" {
+		return printJSON(rl)
+	}
+
+	tw := newTable("ENABLED", "RPS", "BURST", "ALGORITHM", "SOFT RPS", "SOFT BURST")
+	fmt.Fprintf(tw, "%t\t%g\t%d\t%s\t%g\t%d\n", deref(rl.Enabled), deref(rl.RPS), deref(rl.Burst), deref(rl.Algorithm), deref(rl.SoftRPS), deref(rl.SoftBurst))
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	var prefixes []string
+	for prefix := range rl.Routes {
+		seen[prefix] = true
+		prefixes = append(prefixes, prefix)
+	}
+	for prefix := range rl.Costs {
+		if !seen[prefix] {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	if len(prefixes) == 0 {
+		return nil
+	}
+	sort.Strings(prefixes)
+
+	fmt.Println()
+	tw = newTable("ROUTE", "ALGORITHM", "COST")
+	for _, prefix := range prefixes {
+		algorithm, cost := rl.Routes[prefix], "1"
+		if algorithm == "" {
+			algorithm = deref(rl.Algorithm)
+		}
+		if n, ok := rl.Costs[prefix]; ok {
+			cost = strconv.Itoa(n)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", prefix, algorithm, cost)
+	}
+	return tw.Flush()
+}
+
+func (c *cli) bluegreen(args []string) error {
+	if len(args) == 0 {
+		routes, err := c.client.BlueGreenRoutes()
+		if err != nil {
+			return err
+		}
+		if c.output == "json" {
+			return printJSON(routes)
+		}
+
+		tw := newTable("PREFIX", "ACTIVE", "GROUP", "SERVERS", "SWITCHED")
+		for _, route := range routes {
+			switched := "-"
+			if route.SwitchedAt != nil {
+				switched = route.SwitchedAt.Format(time.RFC3339)
+			}
+			for _, group := range sortedKeys(route.Groups) {
+				active := ""
+				if group == route.Active {
+					active = "*"
+				} else if group == route.Canary {
+					active = fmt.Sprintf("%d%%", route.CanaryWeight)
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", route.Prefix, active, group, strings.Join(route.Groups[group], ","), switched)
+			}
+		}
+		return tw.Flush()
+	}
+
+	if args[0] != "cutover" {
+		return fmt.Errorf("unknown bluegreen command %q", args[0])
+	}
+
+	fs := flag.NewFlagSet("bluegreen cutover", flag.ExitOnError)
+	force := fs.Bool("force", false, "switch without health checking the group first")
+	fs.Parse(args[1:])
+
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		return fmt.Errorf("usage: proxyctl bluegreen cutover [-force] PREFIX [GROUP]")
+	}
+
+	cutover, err := c.client.Cutover(fs.Arg(0), fs.Arg(1), *force)
+	if err != nil {
+		return err
+	}
+	if c.output == "json" {
+		return printJSON(cutover)
+	}
+	if cutover.Status == "unchanged" {
+		fmt.Printf("%s already on %s\n", cutover.Prefix, cutover.Active)
+		return nil
+	}
+	fmt.Printf("%s switched from %s to %s, %d cached responses purged\n", cutover.Prefix, cutover.From, cutover.To, cutover.Purged)
+	return nil
+}
+
+func (c *cli) ramps(args []string) error {
+	if len(args) == 0 {
+		ramps, err := c.client.Ramps()
+		if err != nil {
+			return err
+		}
+		if c.output == "json" {
+			return printJSON(ramps)
+		}
+
+		tw := newTable("PREFIX", "CANARY", "STATE", "WEIGHT", "REQUESTS", "ERROR RATE", "P99", "REASON")
+		for _, ramp := range ramps {
+			p99 := time.Duration(ramp.Window.P99Seconds * float64(time.Second)).Round(time.Millisecond)
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d%%\t%d\t%.3f\t%s\t%s\n", ramp.Prefix, ramp.Canary, ramp.State,
+				ramp.Weight, ramp.Window.Requests, ramp.Window.ErrorRate, p99, ramp.Reason)
+		}
+		return tw.Flush()
+	}
+
+	var (
+		ramp TrafficRamp
+		err  error
+	)
+	switch args[0] {
+	case "start":
+		fs := flag.NewFlagSet("ramps start", flag.ExitOnError)
+		steps := fs.String("steps", "", "comma separated canary weights ending at 100 (default 5,25,50,100)")
+		stepDuration := fs.String("step-duration", "", "how long each step lasts (default 5m)")
+		maxErrorRate := fs.Float64("max-error-rate", 0, "highest canary error rate (default 0.05)")
+		maxP99 := fs.String("max-p99", "", "highest canary p99 latency (default unchecked)")
+		minRequests := fs.Uint64("min-requests", 0, "canary requests each step needs (default 20)")
+		onBreach := fs.String("on-breach", "", "rollback or pause when a limit is exceeded (default rollback)")
+		fs.Parse(args[1:])
+
+		if fs.NArg() != 2 {
+			return fmt.Errorf("usage: proxyctl ramps start [flags] PREFIX CANARY")
+		}
+
+		req := TrafficRamp{
+			Prefix:        fs.Arg(0),
+			Canary:        fs.Arg(1),
+			StepDuration:  *stepDuration,
+			MaxErrorRate:  *maxErrorRate,
+			MaxP99Latency: *maxP99,
+			MinRequests:   *minRequests,
+			OnBreach:      *onBreach,
+		}
+		if *steps != "" {
+			for _, step := range strings.Split(*steps, ",") {
+				weight, err := strconv.Atoi(strings.TrimSpace(step))
+				if err != nil {
+					return fmt.Errorf("invalid step %q", step)
+				}
+				req.Steps = append(req.Steps, weight)
+			}
+		}
+		ramp, err = c.client.StartRamp(req)
+
+	case "pause", "resume", "rollback":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: proxyctl ramps %s PREFIX", args[0])
+		}
+		ramp, err = c.client.RampAction(args[1], args[0])
+
+	default:
+		return fmt.Errorf("unknown ramps command %q", args[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	if c.output == "json" {
+		return printJSON(ramp)
+	}
+	fmt.Printf("%s ramp to %s %s at %d%%\n", ramp.Prefix, ramp.Canary, strings.ReplaceAll(ramp.State, "_", " "), ramp.Weight)
+	return nil
+}
+
+func (c *cli) experiments() error {
+	experiments, err := c.client.Experiments()
+	if err != nil {
+		return err
+	}
+	if c.output == "json" {
+		return printJSON(experiments)
+	}
+
+	tw := newTable("NAME", "PREFIX", "VARIANT", "WEIGHT", "SERVERS", "ASSIGNED")
+	for _, e := range experiments {
+		for _, variant := range e.Variants {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%d\n",
+				e.Name, e.Prefix, variant.Name, variant.Weight, strings.Join(variant.Servers, ","), e.Assigned[variant.Name])
+		}
+	}
+	return tw.Flush()
+}
+
+func (c *cli) logs(args []string) error {
+	fs := flag.NewFlagSet("logs", flag.ExitOnError)
+	follow := fs.Bool("f", false, "follow new entries")
+	limit := fs.Int("n", 20, "number of recent entries to show")
+	interval := fs.Duration("interval", time.Second, "poll interval when following")
+	fs.Parse(args)
+
+	entries, err := c.client.AccessLog(0, *limit)
+	if err != nil {
+		return err
+	}
+
+	var tw *tabwriter.Writer
+	if c.output == "table" {
+		tw = newTable("TIME", "STATUS", "METHOD", "PATH", "DURATION", "BYTES", "CLIENT", "REQUEST ID", "EXPERIMENT")
+	}
+
+	var last int64
+	for {
+		for _, e := range entries {
+			last = e.Seq
+			if tw == nil {
+				if err := json.NewEncoder(os.Stdout).Encode(e); err != nil {
+					return err
+				}
+				continue
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+				e.Time.Format(time.RFC3339), e.Status, e.Method, e.Path, e.Duration, e.BytesOut, e.ClientIP, e.RequestID, e.Experiment)
+		}
+		if tw != nil {
+			tw.Flush()
+		}
+
+		if !*follow {
+			return nil
+		}
+
+		time.Sleep(*interval)
+		entries, err = c.client.AccessLog(last, 1000)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// done prints the result of a mutating command
+func (c *cli) done(v interface{}) error {
+	if c.output == "json" {
+		return printJSON(v)
+	}
+	fmt.Println("ok")
+	return nil
+}
+
+func newTable(headers ...string) *tabwriter.Writer {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(headers, "\t"))
+	return tw
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func deref[T any](p *T) T {
+	var zero T
+	if p == nil {
+		return zero
+	}
+	return *p
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "proxyctl: "+format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,133 @@
+// Command replay loads a snapshot taken from GET /admin/snapshot into a local
+// in-process proxy and reports how it routes request paths, so a routing
+// decision seen in production can be reproduced offline.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/codytheroux96/go-reverse-proxy/internal/app"
+)
+
+const usage = `Usage: replay -snapshot FILE [flags] [PATH...]
+
+Loads a snapshot and prints the route, server and target URL each PATH is
+sent to, as the proxy would have routed it when the snapshot was taken.
+
+Examples:
+  curl -k -H "Authorization: Bearer $ADMIN_TOKEN" https://localhost:8443/admin/snapshot > snap.json
+  replay -snapshot snap.json /api/users /v1/orders
+  replay -snapshot snap.json -n 4 /api/users
+  replay -snapshot snap.json -serve :9090
+
+Flags:
+`
+
+func main() {
+	file := flag.String("snapshot", "", "snapshot file, - for stdin")
+	repeat := flag.Int("n", 1, "resolve each path this many times, to show round robin spread")
+	serve := flag.String("serve", "", "also serve the loaded proxy, admin API included, over plaintext on this address")
+	output := flag.String("o", "table", "output format: table or json")
+	verbose := flag.Bool("v", false, "log routing decisions")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *file == "" || (flag.NArg() == 0 && *serve == "") {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *output != "table" && *output != "json" {
+		fatalf("unknown output format %q", *output)
+	}
+
+	snap, err := readSnapshot(*file)
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	level := slog.LevelError
+	if *verbose {
+		level = slog.LevelDebug
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+
+	application, err := app.NewReplayApplication(snap, logger)
+	if err != nil {
+		fatalf("failed to load snapshot: %v", err)
+	}
+
+	var decisions []app.RouteDecision
+	for _, path := range flag.Args() {
+		for i := 0; i < *repeat; i++ {
+			decisions = append(decisions, application.ReplayRoute(context.Background(), path))
+		}
+	}
+	if err := printDecisions(decisions, *output); err != nil {
+		fatalf("%v", err)
+	}
+
+	if *serve != "" {
+		fmt.Fprintf(os.Stderr, "serving the snapshot taken at %s on %s\n", snap.TakenAt, *serve)
+		if err := http.ListenAndServe(*serve, application.Handler()); err != nil {
+			fatalf("%v", err)
+		}
+	}
+}
+
+// readSnapshot decodes a snapshot file, or stdin for -
+func readSnapshot(file string) (app.Snapshot, error) {
+	var r io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return app.Snapshot{}, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var snap app.Snapshot
+	if err := json.NewDecoder(r).Decode(&snap); err != nil {
+		return app.Snapshot{}, fmt.Errorf("failed to parse snapshot: %w", err)
+	}
+	return snap, nil
+}
+
+func printDecisions(decisions []app.RouteDecision, output string) error {
+	if len(decisions) == 0 {
+		return nil
+	}
+	if output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(decisions)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PATH\tROUTE\tSERVER\tTARGET")
+	for _, d := range decisions {
+		if d.Error != "" {
+			fmt.Fprintf(tw, "%s\t-\t-\t%s\n", d.Path, strconv.Quote(d.Error))
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", d.Path, d.Prefix, d.Server, d.TargetURL)
+	}
+	return tw.Flush()
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "replay: "+format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,14 @@
+-- +goose Up
+-- Health check results written by the elected leader for other instances
+CREATE TABLE IF NOT EXISTS backend_health (
+    server_name VARCHAR(255) PRIMARY KEY,
+    is_healthy BOOLEAN NOT NULL,
+    consecutive_failures INTEGER NOT NULL DEFAULT 0,
+    consecutive_successes INTEGER NOT NULL DEFAULT 0,
+    last_checked TIMESTAMP WITH TIME ZONE NOT NULL,
+    last_response_time_ms BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS backend_health;
@@ -0,0 +1,6 @@
+-- +goose Up
+-- Zone or region label used to keep traffic local to the proxy's zone
+ALTER TABLE services ADD COLUMN IF NOT EXISTS zone VARCHAR(64) NOT NULL DEFAULT '';
+
+-- +goose Down
+ALTER TABLE services DROP COLUMN IF EXISTS zone;
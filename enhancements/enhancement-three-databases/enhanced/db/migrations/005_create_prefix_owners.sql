@@ -0,0 +1,10 @@
+-- +goose Up
+-- Route prefixes claimed by a team; only the owner may register under them
+CREATE TABLE IF NOT EXISTS prefix_owners (
+    prefix VARCHAR(255) PRIMARY KEY,
+    owner VARCHAR(255) NOT NULL,
+    claimed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS prefix_owners;
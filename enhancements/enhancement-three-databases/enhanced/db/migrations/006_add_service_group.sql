@@ -0,0 +1,6 @@
+-- +goose Up
+-- Logical service an instance belongs to; empty makes the instance its own service
+ALTER TABLE services ADD COLUMN IF NOT EXISTS service_group VARCHAR(255) NOT NULL DEFAULT '';
+
+-- +goose Down
+ALTER TABLE services DROP COLUMN IF EXISTS service_group;
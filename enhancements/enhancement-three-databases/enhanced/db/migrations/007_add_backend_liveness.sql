@@ -0,0 +1,10 @@
+-- +goose Up
+-- Liveness is tracked apart from readiness, which is_healthy records
+ALTER TABLE backend_health ADD COLUMN IF NOT EXISTS is_live BOOLEAN NOT NULL DEFAULT TRUE;
+ALTER TABLE backend_health ADD COLUMN IF NOT EXISTS consecutive_liveness_failures INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE backend_health ADD COLUMN IF NOT EXISTS not_live_since TIMESTAMP WITH TIME ZONE;
+
+-- +goose Down
+ALTER TABLE backend_health DROP COLUMN IF EXISTS not_live_since;
+ALTER TABLE backend_health DROP COLUMN IF EXISTS consecutive_liveness_failures;
+ALTER TABLE backend_health DROP COLUMN IF EXISTS is_live;
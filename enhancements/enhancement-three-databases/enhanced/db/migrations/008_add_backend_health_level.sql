@@ -0,0 +1,9 @@
+-- +goose Up
+-- Structured health a ready backend reports: healthy or degraded, and its
+-- own detail ('null' when it reports none)
+ALTER TABLE backend_health ADD COLUMN IF NOT EXISTS level TEXT NOT NULL DEFAULT '';
+ALTER TABLE backend_health ADD COLUMN IF NOT EXISTS details JSONB NOT NULL DEFAULT 'null';
+
+-- +goose Down
+ALTER TABLE backend_health DROP COLUMN IF EXISTS details;
+ALTER TABLE backend_health DROP COLUMN IF EXISTS level;
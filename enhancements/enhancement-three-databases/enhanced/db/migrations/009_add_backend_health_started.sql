@@ -0,0 +1,7 @@
+-- +goose Up
+-- Whether a backend has passed its first checks since it was registered,
+-- ending the longer timeout its checks get while it starts up
+ALTER TABLE backend_health ADD COLUMN IF NOT EXISTS started BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- +goose Down
+ALTER TABLE backend_health DROP COLUMN IF EXISTS started;
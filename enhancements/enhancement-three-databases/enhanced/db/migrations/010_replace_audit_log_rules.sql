@@ -0,0 +1,9 @@
+-- +goose Up
+-- Recreate the append-only rules idempotently, for audit logs created
+-- before migrations were tracked whose rules may be missing
+CREATE OR REPLACE RULE audit_log_no_update AS ON UPDATE TO audit_log DO INSTEAD NOTHING;
+CREATE OR REPLACE RULE audit_log_no_delete AS ON DELETE TO audit_log DO INSTEAD NOTHING;
+
+-- +goose Down
+-- The rules belong to 002_create_audit_log, which drops them with the table
+SELECT 1;
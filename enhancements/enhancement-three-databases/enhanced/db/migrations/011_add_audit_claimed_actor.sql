@@ -0,0 +1,6 @@
+-- +goose Up
+-- The unverified X-Admin-Actor header, kept apart from the authenticated actor
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS claimed_actor VARCHAR(255) NOT NULL DEFAULT '';
+
+-- +goose Down
+ALTER TABLE audit_log DROP COLUMN IF EXISTS claimed_actor;
@@ -0,0 +1,51 @@
+package migrations
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"testing"
+)
+
+// applied are the checksums of migrations that may already have run
+// somewhere. They must never change: goose does not rerun an applied
+// migration, so an edit would leave databases disagreeing about the schema.
+// Change the schema with a new migration and add its checksum here.
+var applied = map[string]string{
+	"001_create_services.sql":            "ff9787b4b4f2eca4074c8e7b6677ff30456f497d1ff6590b4d355d1015eba5c6",
+	"002_create_audit_log.sql":           "464ca658d7ada84c20f2c16392a8ccdb2a014ef8565e79eaf358d580dc37b6b1",
+	"003_create_backend_health.sql":      "fa5102b42a1b6f37f5bc34d08a33f39fc1678622bad2d3d44e3d1ce4f53fc093",
+	"004_add_service_zone.sql":           "d685ce9e53d05056ec5fcd7142ea5c439965250c5e6e3c1cd4c74ed7b611de82",
+	"005_create_prefix_owners.sql":       "347720e82e302f35a045bee568ca41f5cdaae7fa2fd8097d20f00fceea1980c2",
+	"006_add_service_group.sql":          "9810a6d974f7a92397cd6a8e788c3b8271f131c450168dbcd1ff265a3be3ebb7",
+	"007_add_backend_liveness.sql":       "2c4cb3ff83dc94b3678a65b5ca388abab6a277b8ec9dd7ac3bc9c24163cddd9e",
+	"008_add_backend_health_level.sql":   "fdee40e562ec781c27f174b128ce242c9ebb51ed9f81ba3a8d493eee50ae091a",
+	"009_add_backend_health_started.sql": "8e7ecf25668d2da2fc66bcb50631a56f200bf5c4b90b40efa2d20872ce7e10e9",
+	"010_replace_audit_log_rules.sql":    "c2366f4424b84f772bd5ee88483179f82f14f82183a0fb8205855dd83b36b4a1",
+	"011_add_audit_claimed_actor.sql":    "40517476f9c0b0bf236a5f760ec7c23be22a24b403dd96184bc7951cc59b0f62",
+}
+
+func TestAppliedMigrationsUnchanged(t *testing.T) {
+	files, err := fs.Glob(FS, "*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		data, err := fs.ReadFile(FS, file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256(data)
+
+		want, ok := applied[file]
+		switch {
+		case !ok:
+			t.Errorf("%s has no recorded checksum; add %s", file, hex.EncodeToString(sum[:]))
+		case hex.EncodeToString(sum[:]) != want:
+			t.Errorf("%s was edited; add a new migration instead", file)
+		}
+	}
+	if len(files) < len(applied) {
+		t.Errorf("found %d migrations, want %d; applied migrations must not be removed", len(files), len(applied))
+	}
+}
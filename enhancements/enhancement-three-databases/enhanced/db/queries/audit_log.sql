@@ -0,0 +1,12 @@
+-- name: InsertAuditEvent :one
+INSERT INTO audit_log (action, actor, claimed_actor, target, payload)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING *;
+
+-- name: ListAuditEvents :many
+SELECT * FROM audit_log
+WHERE (sqlc.narg('action')::text IS NULL OR action = sqlc.narg('action'))
+  AND (sqlc.narg('actor')::text IS NULL OR actor = sqlc.narg('actor'))
+  AND (sqlc.narg('since')::timestamptz IS NULL OR created_at >= sqlc.narg('since'))
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('limit');
@@ -0,0 +1,22 @@
+-- name: UpsertBackendHealth :exec
+INSERT INTO backend_health (server_name, is_healthy, consecutive_failures, consecutive_successes, last_checked, last_response_time_ms, is_live, consecutive_liveness_failures, not_live_since, level, details, started)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+ON CONFLICT (server_name) DO UPDATE SET
+    is_healthy = EXCLUDED.is_healthy,
+    consecutive_failures = EXCLUDED.consecutive_failures,
+    consecutive_successes = EXCLUDED.consecutive_successes,
+    last_checked = EXCLUDED.last_checked,
+    last_response_time_ms = EXCLUDED.last_response_time_ms,
+    is_live = EXCLUDED.is_live,
+    consecutive_liveness_failures = EXCLUDED.consecutive_liveness_failures,
+    not_live_since = EXCLUDED.not_live_since,
+    level = EXCLUDED.level,
+    details = EXCLUDED.details,
+    started = EXCLUDED.started,
+    updated_at = NOW();
+
+-- name: ListBackendHealth :many
+SELECT * FROM backend_health ORDER BY server_name;
+
+-- name: PruneBackendHealth :exec
+DELETE FROM backend_health WHERE updated_at < $1;
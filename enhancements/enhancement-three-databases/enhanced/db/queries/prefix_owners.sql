@@ -0,0 +1,12 @@
+-- name: ListPrefixOwners :many
+SELECT * FROM prefix_owners ORDER BY prefix;
+
+-- name: ClaimPrefix :exec
+INSERT INTO prefix_owners (prefix, owner)
+VALUES ($1, $2)
+ON CONFLICT (prefix) DO UPDATE SET
+    owner = EXCLUDED.owner,
+    claimed_at = NOW();
+
+-- name: ReleasePrefix :exec
+DELETE FROM prefix_owners WHERE prefix = $1;
@@ -1,9 +1,11 @@
 -- name: RegisterService :one
-INSERT INTO services (name, base_url, prefixes)
-VALUES ($1, $2, $3)
+INSERT INTO services (name, base_url, prefixes, zone, service_group)
+VALUES ($1, $2, $3, $4, $5)
 ON CONFLICT (name) DO UPDATE SET
     base_url = EXCLUDED.base_url,
     prefixes = EXCLUDED.prefixes,
+    zone = EXCLUDED.zone,
+    service_group = EXCLUDED.service_group,
     updated_at = NOW()
 RETURNING *;
 
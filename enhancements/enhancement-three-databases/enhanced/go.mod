@@ -2,6 +2,17 @@ module github.com/codytheroux96/go-reverse-proxy
 
 go 1.24.0
 
-require golang.org/x/time v0.11.0
+require (
+	github.com/lib/pq v1.10.9
+	github.com/pressly/goose/v3 v3.26.0
+	github.com/tetratelabs/wazero v1.9.0
+	golang.org/x/time v0.11.0
+	google.golang.org/protobuf v1.36.5
+)
 
-require github.com/lib/pq v1.10.9 // indirect
+require (
+	github.com/mfridman/interpolate v0.0.2 // indirect
+	github.com/sethvargo/go-retry v0.3.0 // indirect
+	go.uber.org/multierr v1.11.0 // indirect
+	golang.org/x/sync v0.16.0 // indirect
+)
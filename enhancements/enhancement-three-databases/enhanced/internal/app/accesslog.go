@@ -0,0 +1,288 @@
+package app
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	AccessLogBufferSize     = 4096
+	AccessLogBatchSize      = 100
+	AccessLogFlushInterval  = 1 * time.Second
+	AccessLogRecentCapacity = 1000
+)
+
+// AccessLogEntry represents a single completed request handled by the proxy
+type AccessLogEntry struct {
+	Time       time.Time     `json:"time"`
+	RequestID  string        `json:"request_id,omitempty"`
+	ClientIP   string        `json:"client_ip"`
+	Method     string        `json:"method"`
+	Path       string        `json:"path"`
+	Query      string        `json:"query,omitempty"`
+	Status     int           `json:"status"`
+	BytesOut   int64         `json:"bytes_out"`
+	Duration   time.Duration `json:"duration"`
+	UserAgent  string        `json:"user_agent,omitempty"`
+	Referer    string        `json:"referer,omitempty"`
+	Proto      string        `json:"proto"`
+	RemoteAddr string        `json:"remote_addr"`
+	Experiment string        `json:"experiment,omitempty"`
+	// Upstream breaks down the backend request's time; nil when the request
+	// was not forwarded
+	Upstream *UpstreamTiming `json:"upstream,omitempty"`
+}
+
+// AccessLogSink is a destination that access log batches are shipped to
+type AccessLogSink interface {
+	Name() string
+	WriteBatch(entries []AccessLogEntry) error
+	Close() error
+}
+
+// AccessLogger buffers access log entries and ships them to sinks in batches.
+// Entries are dropped rather than blocking when the buffer is full, so a slow
+// sink never holds up request handling.
+type AccessLogger struct {
+	sinks         []AccessLogSink
+	entries       chan AccessLogEntry
+	batchSize     int
+	flushInterval time.Duration
+	logger        *slog.Logger
+	dropped       atomic.Int64
+	shipped       atomic.Int64
+	failed        atomic.Int64
+	stopOnce      sync.Once
+	// started is set by the first Start, or by a Stop before any Start
+	started atomic.Bool
+	stopCh  chan struct{}
+	stopped chan struct{}
+}
+
+// NewAccessLogger creates a new access logger shipping to the given sinks
+func NewAccessLogger(logger *slog.Logger, sinks ...AccessLogSink) *AccessLogger {
+	return &AccessLogger{
+		sinks:         sinks,
+		entries:       make(chan AccessLogEntry, AccessLogBufferSize),
+		batchSize:     AccessLogBatchSize,
+		flushInterval: AccessLogFlushInterval,
+		logger:        logger,
+		stopCh:        make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+}
+
+// Start runs the batching loop until Stop is called. It returns at once if
+// the logger was already started or stopped.
+func (al *AccessLogger) Start() {
+	if !al.started.CompareAndSwap(false, true) {
+		return
+	}
+	defer close(al.stopped)
+
+	ticker := time.NewTicker(al.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]AccessLogEntry, 0, al.batchSize)
+
+	for {
+		select {
+		case entry := <-al.entries:
+			batch = append(batch, entry)
+			if len(batch) >= al.batchSize {
+				al.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				al.flush(batch)
+				batch = batch[:0]
+			}
+		case <-al.stopCh:
+			// Drain whatever is still buffered before exiting
+			if len(batch) > 0 {
+				al.flush(batch)
+			}
+			al.drain()
+			return
+		}
+	}
+}
+
+// Stop flushes buffered entries and closes all sinks. Without a running
+// Start it does so itself, so it never waits on a loop that will not run.
+func (al *AccessLogger) Stop() {
+	al.stopOnce.Do(func() {
+		close(al.stopCh)
+		if al.started.CompareAndSwap(false, true) {
+			al.drain()
+			close(al.stopped)
+		}
+	})
+	<-al.stopped
+}
+
+// drain ships the buffered entries in batches and closes the sinks
+func (al *AccessLogger) drain() {
+	batch := make([]AccessLogEntry, 0, al.batchSize)
+	for {
+		select {
+		case entry := <-al.entries:
+			batch = append(batch, entry)
+			if len(batch) >= al.batchSize {
+				al.flush(batch)
+				batch = batch[:0]
+			}
+		default:
+			if len(batch) > 0 {
+				al.flush(batch)
+			}
+			al.closeSinks()
+			return
+		}
+	}
+}
+
+// Log enqueues an entry without blocking, dropping it if the buffer is full
+func (al *AccessLogger) Log(entry AccessLogEntry) {
+	select {
+	case al.entries <- entry:
+	default:
+		if al.dropped.Add(1)%1000 == 1 {
+			al.logger.Warn("access log buffer full, dropping entries",
+				"dropped_total", al.dropped.Load())
+		}
+	}
+}
+
+// flush ships a batch to every sink, logging but not retrying failures
+func (al *AccessLogger) flush(batch []AccessLogEntry) {
+	for _, sink := range al.sinks {
+		if err := sink.WriteBatch(batch); err != nil {
+			al.failed.Add(int64(len(batch)))
+			al.logger.Error("failed to ship access log batch",
+				"sink", sink.Name(),
+				"entries", len(batch),
+				"error", err)
+			continue
+		}
+		al.shipped.Add(int64(len(batch)))
+	}
+}
+
+// closeSinks closes every configured sink
+func (al *AccessLogger) closeSinks() {
+	for _, sink := range al.sinks {
+		if err := sink.Close(); err != nil {
+			al.logger.Error("failed to close access log sink", "sink", sink.Name(), "error", err)
+		}
+	}
+}
+
+// GetStats returns access log statistics for monitoring
+func (al *AccessLogger) GetStats() map[string]interface{} {
+	names := make([]string, 0, len(al.sinks))
+	for _, sink := range al.sinks {
+		names = append(names, sink.Name())
+	}
+
+	return map[string]interface{}{
+		"sinks":    names,
+		"buffered": len(al.entries),
+		"shipped":  al.shipped.Load(),
+		"failed":   al.failed.Load(),
+		"dropped":  al.dropped.Load(),
+	}
+}
+
+// AddSinks adds sinks to the logger; it must be called before Start
+func (al *AccessLogger) AddSinks(sinks ...AccessLogSink) {
+	al.sinks = append(al.sinks, sinks...)
+}
+
+// requestRecordKey is the context key of a request's requestRecord
+type requestRecordKey struct{}
+
+// requestRecord collects what the access log and traffic accounting need to
+// know about a request as it is handled
+type requestRecord struct {
+	mu       sync.Mutex
+	route    string
+	backend  string
+	upstream *UpstreamTiming
+	cache    string
+}
+
+// requestRecordFrom returns the request's record, or nil outside AccessLog
+func requestRecordFrom(ctx context.Context) *requestRecord {
+	record, _ := ctx.Value(requestRecordKey{}).(*requestRecord)
+	return record
+}
+
+// recordBackend records the route and backend a request was sent to
+func recordBackend(r *http.Request, backend *BackendInfo) {
+	if record := requestRecordFrom(r.Context()); record != nil {
+		record.mu.Lock()
+		record.route, record.backend = backend.Prefix, backend.Server.Name
+		record.mu.Unlock()
+	}
+}
+
+// AccessLog records every request that passes through the handler
+func (app *Application) AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: w}
+
+		// Request bodies are counted as they are read, and the route and
+		// backend filled in once the request is routed
+		var body *countingBody
+		if r.Body != nil && r.Body != http.NoBody {
+			body = &countingBody{ReadCloser: r.Body}
+			r.Body = body
+		}
+		record := &requestRecord{}
+		r = r.WithContext(context.WithValue(r.Context(), requestRecordKey{}, record))
+
+		next.ServeHTTP(rec, r)
+
+		rec.finish()
+
+		clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			clientIP = r.RemoteAddr
+		}
+		record.mu.Lock()
+		route, backend, upstream := record.route, record.backend, record.upstream
+		record.mu.Unlock()
+
+		app.AccessLogger.Log(AccessLogEntry{
+			Time:       start,
+			RequestID:  RequestIDFromContext(r.Context()),
+			ClientIP:   clientIP,
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Query:      r.URL.RawQuery,
+			Status:     rec.status,
+			BytesOut:   rec.bytes,
+			Duration:   time.Since(start),
+			UserAgent:  r.UserAgent(),
+			Referer:    r.Referer(),
+			Proto:      r.Proto,
+			RemoteAddr: r.RemoteAddr,
+			Experiment: rec.Header().Get(ExperimentHeader),
+			Upstream:   upstream,
+		})
+
+		bytesIn := int64(0)
+		if body != nil {
+			bytesIn = body.n.Load()
+		}
+		app.Traffic.Observe(route, backend, app.trafficClient(r, clientIP), bytesIn, rec.bytes)
+	})
+}
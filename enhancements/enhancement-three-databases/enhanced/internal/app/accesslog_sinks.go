@@ -0,0 +1,375 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/syslog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const AccessLogSinkTimeout = 5 * time.Second
+
+// WriterSink writes access log entries as JSON lines to stdout or a file
+type WriterSink struct {
+	name   string
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+// NewStdoutSink creates a sink writing JSON lines to stdout
+func NewStdoutSink() *WriterSink {
+	return &WriterSink{name: "stdout", w: os.Stdout}
+}
+
+// NewFileSink creates a sink appending JSON lines to the given file
+func NewFileSink(path string) (*WriterSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open access log file: %w", err)
+	}
+	return &WriterSink{name: "file:" + path, w: f, closer: f}, nil
+}
+
+func (s *WriterSink) Name() string { return s.name }
+
+func (s *WriterSink) WriteBatch(entries []AccessLogEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	enc := json.NewEncoder(s.w)
+	for _, entry := range entries {
+		if err := enc.Encode(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *WriterSink) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
+
+// RecentSink keeps the most recent access log entries in memory so they can
+// be tailed through the admin API
+type RecentSink struct {
+	mu       sync.RWMutex
+	entries  []RecentAccessLogEntry
+	capacity int
+	nextSeq  int64
+}
+
+// RecentAccessLogEntry is an access log entry with a sequence number for tailing
+type RecentAccessLogEntry struct {
+	Seq int64 `json:"seq"`
+	AccessLogEntry
+}
+
+// NewRecentSink creates a sink keeping up to capacity entries
+func NewRecentSink(capacity int) *RecentSink {
+	return &RecentSink{
+		entries:  make([]RecentAccessLogEntry, 0, capacity),
+		capacity: capacity,
+	}
+}
+
+func (s *RecentSink) Name() string { return "recent" }
+
+func (s *RecentSink) WriteBatch(entries []AccessLogEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, entry := range entries {
+		s.nextSeq++
+		if len(s.entries) >= s.capacity {
+			s.entries = s.entries[1:]
+		}
+		s.entries = append(s.entries, RecentAccessLogEntry{Seq: s.nextSeq, AccessLogEntry: entry})
+	}
+	return nil
+}
+
+func (s *RecentSink) Close() error { return nil }
+
+// After returns up to limit entries with a sequence number greater than seq
+func (s *RecentSink) After(seq int64, limit int) []RecentAccessLogEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := []RecentAccessLogEntry{}
+	for _, entry := range s.entries {
+		if entry.Seq > seq {
+			result = append(result, entry)
+		}
+	}
+	if len(result) > limit {
+		result = result[len(result)-limit:]
+	}
+	return result
+}
+
+// SyslogSink writes access log entries to a local or remote syslog daemon
+type SyslogSink struct {
+	name   string
+	writer *syslog.Writer
+}
+
+// NewSyslogSink dials syslog; an empty network and addr uses the local daemon
+func NewSyslogSink(network, addr string) (*SyslogSink, error) {
+	writer, err := syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_LOCAL0, "go-reverse-proxy")
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to syslog: %w", err)
+	}
+
+	name := "syslog"
+	if addr != "" {
+		name = "syslog:" + network + "://" + addr
+	}
+	return &SyslogSink{name: name, writer: writer}, nil
+}
+
+func (s *SyslogSink) Name() string { return s.name }
+
+func (s *SyslogSink) WriteBatch(entries []AccessLogEntry) error {
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		if err := s.writer.Info(string(line)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SyslogSink) Close() error {
+	return s.writer.Close()
+}
+
+// KafkaSink produces access log entries to a Kafka topic through a Kafka REST
+// Proxy endpoint such as http://rest-proxy:8082/topics/access-logs
+type KafkaSink struct {
+	endpoint string
+	client   *http.Client
+}
+
+// NewKafkaSink creates a sink producing to the given REST Proxy topic URL
+func NewKafkaSink(endpoint string) *KafkaSink {
+	return &KafkaSink{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: AccessLogSinkTimeout},
+	}
+}
+
+func (s *KafkaSink) Name() string { return "kafka:" + s.endpoint }
+
+func (s *KafkaSink) WriteBatch(entries []AccessLogEntry) error {
+	type record struct {
+		Value AccessLogEntry `json:"value"`
+	}
+
+	payload := struct {
+		Records []record `json:"records"`
+	}{
+		Records: make([]record, 0, len(entries)),
+	}
+	for _, entry := range entries {
+		payload.Records = append(payload.Records, record{Value: entry})
+	}
+
+	return postJSON(s.client, s.endpoint, "application/vnd.kafka.json.v2+json", payload)
+}
+
+func (s *KafkaSink) Close() error { return nil }
+
+// OTLPSink exports access log entries as OTLP logs over HTTP/JSON to an
+// endpoint such as http://collector:4318/v1/logs
+type OTLPSink struct {
+	endpoint string
+	client   *http.Client
+}
+
+// NewOTLPSink creates a sink exporting to the given OTLP/HTTP logs endpoint
+func NewOTLPSink(endpoint string) *OTLPSink {
+	return &OTLPSink{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: AccessLogSinkTimeout},
+	}
+}
+
+func (s *OTLPSink) Name() string { return "otlp:" + s.endpoint }
+
+func (s *OTLPSink) WriteBatch(entries []AccessLogEntry) error {
+	type anyValue struct {
+		StringValue string `json:"stringValue,omitempty"`
+		IntValue    string `json:"intValue,omitempty"`
+	}
+	type keyValue struct {
+		Key   string   `json:"key"`
+		Value anyValue `json:"value"`
+	}
+	type logRecord struct {
+		TimeUnixNano   string     `json:"timeUnixNano"`
+		SeverityNumber int        `json:"severityNumber"`
+		SeverityText   string     `json:"severityText"`
+		Body           anyValue   `json:"body"`
+		Attributes     []keyValue `json:"attributes"`
+	}
+
+	str := func(k, v string) keyValue { return keyValue{Key: k, Value: anyValue{StringValue: v}} }
+	num := func(k string, v int64) keyValue {
+		return keyValue{Key: k, Value: anyValue{IntValue: strconv.FormatInt(v, 10)}}
+	}
+
+	records := make([]logRecord, 0, len(entries))
+	for _, entry := range entries {
+		attributes := []keyValue{
+			str("client.address", entry.ClientIP),
+			str("http.request.method", entry.Method),
+			str("url.path", entry.Path),
+			str("url.query", entry.Query),
+			num("http.response.status_code", int64(entry.Status)),
+			num("http.response.body.size", entry.BytesOut),
+			num("duration_ms", entry.Duration.Milliseconds()),
+			str("user_agent.original", entry.UserAgent),
+			str("network.protocol.name", entry.Proto),
+		}
+		if entry.Experiment != "" {
+			attributes = append(attributes, str("experiment", entry.Experiment))
+		}
+		if up := entry.Upstream; up != nil {
+			attributes = append(attributes,
+				num("upstream.dns_us", up.DNS.Microseconds()),
+				num("upstream.connect_us", up.Connect.Microseconds()),
+				num("upstream.tls_us", up.TLS.Microseconds()),
+				num("upstream.ttfb_us", up.TTFB.Microseconds()),
+				num("upstream.body_read_us", up.BodyRead.Microseconds()),
+			)
+		}
+
+		records = append(records, logRecord{
+			TimeUnixNano:   strconv.FormatInt(entry.Time.UnixNano(), 10),
+			SeverityNumber: 9, // INFO
+			SeverityText:   "INFO",
+			Body:           anyValue{StringValue: entry.Method + " " + entry.Path},
+			Attributes:     attributes,
+		})
+	}
+
+	payload := map[string]interface{}{
+		"resourceLogs": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []keyValue{str("service.name", "go-reverse-proxy")},
+				},
+				"scopeLogs": []interface{}{
+					map[string]interface{}{
+						"scope":      map[string]string{"name": "access_log"},
+						"logRecords": records,
+					},
+				},
+			},
+		},
+	}
+
+	return postJSON(s.client, s.endpoint, "application/json", payload)
+}
+
+func (s *OTLPSink) Close() error { return nil }
+
+// postJSON sends a JSON payload and treats any non-2xx status as an error
+func postJSON(client *http.Client, endpoint, contentType string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Post(endpoint, contentType, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("sink returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// ParseAccessLogSinks builds sinks from a comma separated list of specs:
+//
+//	stdout
+//	file:/var/log/proxy/access.log
+//	syslog                      (local daemon)
+//	syslog:udp://host:514
+//	kafka:http://rest-proxy:8082/topics/access-logs
+//	otlp:http://collector:4318/v1/logs
+func ParseAccessLogSinks(spec string) ([]AccessLogSink, error) {
+	var sinks []AccessLogSink
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		kind, target, _ := strings.Cut(part, ":")
+
+		switch kind {
+		case "stdout":
+			sinks = append(sinks, NewStdoutSink())
+
+		case "file":
+			sink, err := NewFileSink(target)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, sink)
+
+		case "syslog":
+			network, addr := "", ""
+			if target != "" {
+				u, err := url.Parse(target)
+				if err != nil {
+					return nil, fmt.Errorf("invalid syslog address %q: %w", target, err)
+				}
+				network, addr = u.Scheme, u.Host
+			}
+			sink, err := NewSyslogSink(network, addr)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, sink)
+
+		case "kafka":
+			if target == "" {
+				return nil, fmt.Errorf("kafka sink requires a REST proxy topic URL")
+			}
+			sinks = append(sinks, NewKafkaSink(target))
+
+		case "otlp":
+			if target == "" {
+				return nil, fmt.Errorf("otlp sink requires a logs endpoint URL")
+			}
+			sinks = append(sinks, NewOTLPSink(target))
+
+		default:
+			return nil, fmt.Errorf("unknown access log sink %q", kind)
+		}
+	}
+
+	return sinks, nil
+}
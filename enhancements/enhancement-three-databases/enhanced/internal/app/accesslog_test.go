@@ -0,0 +1,63 @@
+package app
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+// memorySink collects shipped entries
+type memorySink struct {
+	entries []AccessLogEntry
+	closed  bool
+}
+
+func (s *memorySink) Name() string { return "memory" }
+
+func (s *memorySink) WriteBatch(entries []AccessLogEntry) error {
+	s.entries = append(s.entries, entries...)
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestAccessLoggerStopWithoutStart(t *testing.T) {
+	sink := &memorySink{}
+	al := NewAccessLogger(slog.New(slog.NewTextHandler(io.Discard, nil)), sink)
+	al.Log(AccessLogEntry{Path: "/a"})
+
+	done := make(chan struct{})
+	go func() {
+		al.Stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop blocked without Start")
+	}
+
+	if len(sink.entries) != 1 || !sink.closed {
+		t.Fatalf("got %d entries, closed %v; want the buffered entry shipped and the sink closed", len(sink.entries), sink.closed)
+	}
+
+	// A Start after Stop must not run the loop again
+	al.Start()
+}
+
+func TestAccessLoggerStopAfterStart(t *testing.T) {
+	sink := &memorySink{}
+	al := NewAccessLogger(slog.New(slog.NewTextHandler(io.Discard, nil)), sink)
+	go al.Start()
+	al.Log(AccessLogEntry{Path: "/a"})
+	al.Log(AccessLogEntry{Path: "/b"})
+	al.Stop()
+
+	if len(sink.entries) != 2 || !sink.closed {
+		t.Fatalf("got %d entries, closed %v; want both entries shipped and the sink closed", len(sink.entries), sink.closed)
+	}
+}
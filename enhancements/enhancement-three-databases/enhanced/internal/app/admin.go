@@ -0,0 +1,313 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// writeJSON writes a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// HandleBreakerReset serves POST /admin/breakers/reset with {"server": "<name>"},
+// or {"service": "<name>"} to reset every instance of a logical service
+func (app *Application) HandleBreakerReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Server  string `json:"server"`
+		Service string `json:"service"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.Server == "") == (req.Service == "") {
+		http.Error(w, "invalid payload in request", http.StatusBadRequest)
+		return
+	}
+
+	if req.Service != "" {
+		app.resetServiceBreakers(w, req.Service)
+		return
+	}
+
+	if _, exists := app.CircuitBreaker.GetBreakerInfo(req.Server); !exists {
+		http.Error(w, "no circuit breaker for server", http.StatusNotFound)
+		return
+	}
+
+	app.CircuitBreaker.ResetBreaker(req.Server)
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "reset", "server": req.Server})
+}
+
+// resetServiceBreakers resets the breaker of every instance of a logical
+// service
+func (app *Application) resetServiceBreakers(w http.ResponseWriter, service string) {
+	servers, err := app.Registry.GetServers()
+	if err != nil {
+		http.Error(w, "failed to list servers", http.StatusInternalServerError)
+		return
+	}
+
+	reset := []string{}
+	for _, server := range servers {
+		if server.ServiceName() != service {
+			continue
+		}
+		if _, exists := app.CircuitBreaker.GetBreakerInfo(server.Name); exists {
+			app.CircuitBreaker.ResetBreaker(server.Name)
+			reset = append(reset, server.Name)
+		}
+	}
+	if len(reset) == 0 {
+		http.Error(w, "no circuit breakers for service", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": "reset", "service": service, "servers": reset})
+}
+
+// HandleCachePurge serves POST /admin/cache/purge. With {"key": "<path>"} a
+// single entry is purged, otherwise every cached response is dropped.
+func (app *Application) HandleCachePurge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Key string `json:"key"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid payload in request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Purged responses must not come back as degraded responses either, and
+	// a key purges every encoding it is cached in
+	if req.Key != "" {
+		purged := 0
+		for _, encoding := range CachedEncodings {
+			variant := encodingKey(req.Key, encoding)
+			degraded := app.Degrader != nil && app.Degrader.stale.Delete(variant)
+			if app.Cache.Delete(variant) || degraded {
+				purged++
+			}
+		}
+		if purged == 0 {
+			http.Error(w, "cache key not found", http.StatusNotFound)
+			return
+		}
+		app.Events.Publish(Event{Type: EventCachePurged, Data: map[string]interface{}{"entries": purged, "key": req.Key}})
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "purged", "entries": purged, "key": req.Key})
+		return
+	}
+
+	purged := app.Cache.Purge()
+	if app.Degrader != nil {
+		app.Degrader.stale.Purge()
+	}
+	app.Events.Publish(Event{Type: EventCachePurged, Data: map[string]interface{}{"entries": purged}})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": "purged", "entries": purged})
+}
+
+// HandleHealthList serves GET /admin/health with every backend's health status
+func (app *Application) HandleHealthList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"servers": app.HealthMonitor.GetAllHealthStatuses()})
+}
+
+// HandleBreakerList serves GET /admin/breakers with every circuit breaker's state
+func (app *Application) HandleBreakerList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	type breakerView struct {
+		State        string    `json:"state"`
+		Failures     int       `json:"failures"`
+		LastOpenTime time.Time `json:"last_open_time"`
+		InFlight     int       `json:"in_flight"`
+	}
+
+	breakers := make(map[string]breakerView)
+	for name, b := range app.CircuitBreaker.GetAllBreakers() {
+		breakers[name] = breakerView{
+			State:        b.State.String(),
+			Failures:     b.Failures,
+			LastOpenTime: b.LastOpenTime,
+			InFlight:     b.InFlight,
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"breakers": breakers})
+}
+
+// HandleAccessLogTail serves GET /admin/accesslog?after=<seq>&limit=<n> with
+// the most recent access log entries
+func (app *Application) HandleAccessLogTail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var after int64
+	if v := r.URL.Query().Get("after"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			http.Error(w, "after must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		after = n
+	}
+
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"entries": app.RecentRequests.After(after, limit)})
+}
+
+// HandleCoalescing serves GET /admin/coalescing with request coalescing counters
+func (app *Application) HandleCoalescing(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if app.Coalescer == nil {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": false})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": true, "stats": app.Coalescer.Stats()})
+}
+
+// rateLimitView is the /admin/ratelimit representation of a limiter config
+func rateLimitView(cfg RateLimiterConfig) map[string]interface{} {
+	routes := cfg.routes
+	if routes == nil {
+		routes = map[string]string{}
+	}
+	costs := cfg.costs
+	if costs == nil {
+		costs = map[string]int{}
+	}
+	return map[string]interface{}{
+		"enabled":    cfg.enabled,
+		"rps":        cfg.rps,
+		"burst":      cfg.burst,
+		"algorithm":  cfg.algorithm,
+		"routes":     routes,
+		"soft_rps":   cfg.softRPS,
+		"soft_burst": cfg.softBurst,
+		"costs":      costs,
+	}
+}
+
+// HandleRateLimit serves GET and PUT /admin/ratelimit for the client rate limiter
+func (app *Application) HandleRateLimit(w http.ResponseWriter, r *http.Request) {
+	type rateLimitPayload struct {
+		Enabled   *bool             `json:"enabled"`
+		RPS       *float64          `json:"rps"`
+		Burst     *int              `json:"burst"`
+		Algorithm *string           `json:"algorithm"`
+		Routes    map[string]string `json:"routes"`
+		SoftRPS   *float64          `json:"soft_rps"`
+		SoftBurst *int              `json:"soft_burst"`
+		Costs     map[string]int    `json:"costs"`
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, rateLimitView(app.LimiterConfig()))
+
+	case http.MethodPut:
+		var req rateLimitPayload
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid payload in request", http.StatusBadRequest)
+			return
+		}
+
+		cfg := app.LimiterConfig()
+		if req.Enabled != nil {
+			cfg.enabled = *req.Enabled
+		}
+		if req.RPS != nil {
+			if *req.RPS <= 0 {
+				http.Error(w, "rps must be positive", http.StatusBadRequest)
+				return
+			}
+			cfg.rps = *req.RPS
+		}
+		if req.Burst != nil {
+			if *req.Burst <= 0 {
+				http.Error(w, "burst must be positive", http.StatusBadRequest)
+				return
+			}
+			cfg.burst = *req.Burst
+		}
+		if req.Algorithm != nil {
+			if !ValidRateLimitAlgorithm(*req.Algorithm) {
+				http.Error(w, "unknown rate limit algorithm", http.StatusBadRequest)
+				return
+			}
+			cfg.algorithm = *req.Algorithm
+		}
+		if req.Routes != nil {
+			for _, algorithm := range req.Routes {
+				if !ValidRateLimitAlgorithm(algorithm) {
+					http.Error(w, "unknown rate limit algorithm", http.StatusBadRequest)
+					return
+				}
+			}
+			cfg.routes = req.Routes
+		}
+		if req.SoftRPS != nil {
+			cfg.softRPS = *req.SoftRPS
+		}
+		if req.SoftBurst != nil {
+			cfg.softBurst = *req.SoftBurst
+		}
+		if req.Costs != nil {
+			cfg.costs = req.Costs
+		}
+		if err := cfg.validateSoftLimit(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := cfg.validateCosts(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		app.SetLimiterConfig(cfg)
+		app.configReloaded("ratelimit", nil)
+		app.Logger.InfoContext(r.Context(), "rate limiter updated", "enabled", cfg.enabled, "rps", cfg.rps, "burst", cfg.burst, "algorithm", cfg.algorithm, "soft_rps", cfg.softRPS)
+
+		writeJSON(w, http.StatusOK, rateLimitView(cfg))
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,306 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AdmissionConfig bounds how many requests the proxy works on at once. When
+// the limit is reached requests wait in per-class queues and free slots go to
+// the highest priority class first.
+type AdmissionConfig struct {
+	// MaxInFlight caps concurrently handled requests; 0 disables admission control
+	MaxInFlight int
+	// MaxQueue caps waiting requests across all classes; 0 rejects instead of queueing
+	MaxQueue int
+	// QueueTimeout is how long a request may wait for a slot before it is rejected
+	QueueTimeout time.Duration
+	// Classes lists priority classes from highest to lowest; requests whose
+	// class is unknown or unset get the last one
+	Classes []string
+}
+
+// DefaultPriorityClasses are used when no classes are configured
+var DefaultPriorityClasses = []string{"gold", "silver", "free"}
+
+// admissionWaiter is a request queued for a slot
+type admissionWaiter struct {
+	ready   chan struct{}
+	granted bool
+	shed    bool
+}
+
+// admissionClass holds a priority class's queue and counters
+type admissionClass struct {
+	name  string
+	queue []*admissionWaiter
+
+	admitted atomic.Uint64
+	queued   atomic.Uint64
+	rejected atomic.Uint64
+	shed     atomic.Uint64
+	timedOut atomic.Uint64
+	waitNano atomic.Int64
+}
+
+// AdmissionController admits requests up to a concurrency limit, queueing
+// the rest by priority class
+type AdmissionController struct {
+	cfg     AdmissionConfig
+	logger  *slog.Logger
+	mu      sync.Mutex
+	active  int
+	waiting int
+	classes []*admissionClass
+	byName  map[string]int
+}
+
+// AdmissionClassStats reports a priority class's queue and counters
+type AdmissionClassStats struct {
+	Class      string  `json:"class"`
+	QueueDepth int     `json:"queue_depth"`
+	Admitted   uint64  `json:"admitted"`
+	Queued     uint64  `json:"queued"`
+	Rejected   uint64  `json:"rejected"`
+	Shed       uint64  `json:"shed"`
+	TimedOut   uint64  `json:"timed_out"`
+	AvgWaitMs  float64 `json:"avg_wait_ms"`
+}
+
+// AdmissionStats is a point-in-time view of the admission controller
+type AdmissionStats struct {
+	MaxInFlight int                   `json:"max_in_flight"`
+	MaxQueue    int                   `json:"max_queue"`
+	InFlight    int                   `json:"in_flight"`
+	Waiting     int                   `json:"waiting"`
+	Classes     []AdmissionClassStats `json:"classes"`
+}
+
+// NewAdmissionController creates an admission controller from cfg
+func NewAdmissionController(cfg AdmissionConfig, logger *slog.Logger) *AdmissionController {
+	if len(cfg.Classes) == 0 {
+		cfg.Classes = DefaultPriorityClasses
+	}
+
+	ac := &AdmissionController{cfg: cfg, logger: logger, byName: make(map[string]int)}
+	for i, name := range cfg.Classes {
+		ac.classes = append(ac.classes, &admissionClass{name: name})
+		ac.byName[strings.ToLower(name)] = i
+	}
+	return ac
+}
+
+// classIndex maps a class name to its priority, unknown names getting the lowest
+func (ac *AdmissionController) classIndex(name string) int {
+	if i, exists := ac.byName[strings.ToLower(name)]; exists {
+		return i
+	}
+	return len(ac.classes) - 1
+}
+
+// Acquire waits for a slot for a request of the given class. It returns
+// false when the request was rejected, shed for a higher priority request,
+// timed out in the queue, or ctx was done first.
+func (ac *AdmissionController) Acquire(ctx context.Context, class string) bool {
+	idx := ac.classIndex(class)
+	cls := ac.classes[idx]
+
+	ac.mu.Lock()
+	if ac.active < ac.cfg.MaxInFlight {
+		ac.active++
+		ac.mu.Unlock()
+		cls.admitted.Add(1)
+		return true
+	}
+
+	if ac.waiting >= ac.cfg.MaxQueue && !ac.shedBelow(idx) {
+		ac.mu.Unlock()
+		cls.rejected.Add(1)
+		return false
+	}
+
+	waiter := &admissionWaiter{ready: make(chan struct{}, 1)}
+	cls.queue = append(cls.queue, waiter)
+	ac.waiting++
+	ac.mu.Unlock()
+	cls.queued.Add(1)
+
+	start := time.Now()
+	var timeout <-chan time.Time
+	if ac.cfg.QueueTimeout > 0 {
+		timer := time.NewTimer(ac.cfg.QueueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-waiter.ready:
+	case <-timeout:
+	case <-ctx.Done():
+	}
+
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	cls.waitNano.Add(int64(time.Since(start)))
+
+	switch {
+	case waiter.granted:
+		cls.admitted.Add(1)
+		return true
+	case waiter.shed:
+		cls.shed.Add(1)
+		return false
+	}
+
+	ac.remove(cls, waiter)
+	if ctx.Err() == nil {
+		cls.timedOut.Add(1)
+	}
+	return false
+}
+
+// Release frees a slot, handing it to the oldest waiter of the highest
+// priority class with one
+func (ac *AdmissionController) Release() {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	for _, cls := range ac.classes {
+		if len(cls.queue) == 0 {
+			continue
+		}
+		waiter := cls.queue[0]
+		cls.queue = cls.queue[1:]
+		ac.waiting--
+		waiter.granted = true
+		waiter.ready <- struct{}{}
+		return
+	}
+
+	ac.active--
+}
+
+// shedBelow makes room in a full queue by dropping the newest waiter of the
+// lowest class below idx. The caller holds ac.mu.
+func (ac *AdmissionController) shedBelow(idx int) bool {
+	for i := len(ac.classes) - 1; i > idx; i-- {
+		cls := ac.classes[i]
+		if len(cls.queue) == 0 {
+			continue
+		}
+		waiter := cls.queue[len(cls.queue)-1]
+		cls.queue = cls.queue[:len(cls.queue)-1]
+		ac.waiting--
+		waiter.shed = true
+		waiter.ready <- struct{}{}
+		ac.logger.Info("queued request shed for a higher priority request", "class", cls.name)
+		return true
+	}
+	return false
+}
+
+// remove drops a waiter that gave up from its class queue. The caller holds ac.mu.
+func (ac *AdmissionController) remove(cls *admissionClass, waiter *admissionWaiter) {
+	for i, w := range cls.queue {
+		if w == waiter {
+			cls.queue = append(cls.queue[:i:i], cls.queue[i+1:]...)
+			ac.waiting--
+			return
+		}
+	}
+}
+
+// Stats returns the controller's configuration, queue depths and counters
+func (ac *AdmissionController) Stats() AdmissionStats {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	stats := AdmissionStats{
+		MaxInFlight: ac.cfg.MaxInFlight,
+		MaxQueue:    ac.cfg.MaxQueue,
+		InFlight:    ac.active,
+		Waiting:     ac.waiting,
+	}
+
+	for _, cls := range ac.classes {
+		cs := AdmissionClassStats{
+			Class:      cls.name,
+			QueueDepth: len(cls.queue),
+			Admitted:   cls.admitted.Load(),
+			Queued:     cls.queued.Load(),
+			Rejected:   cls.rejected.Load(),
+			Shed:       cls.shed.Load(),
+			TimedOut:   cls.timedOut.Load(),
+		}
+		if cs.Queued > 0 {
+			cs.AvgWaitMs = float64(cls.waitNano.Load()) / float64(cs.Queued) / float64(time.Millisecond)
+		}
+		stats.Classes = append(stats.Classes, cs)
+	}
+	return stats
+}
+
+// ParsePriorityClasses parses a comma separated class list, highest first
+func ParsePriorityClasses(spec string) ([]string, error) {
+	var classes []string
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		classes = append(classes, name)
+	}
+	if len(classes) == 0 {
+		return nil, fmt.Errorf("no priority classes in %q", spec)
+	}
+	return classes, nil
+}
+
+// SetAdmission enables admission control and installs its middleware; it
+// must be called before Handler
+func (app *Application) SetAdmission(cfg AdmissionConfig) {
+	app.Admission = NewAdmissionController(cfg, app.Logger)
+	app.Use(app.AdmissionControl)
+}
+
+// AdmissionControl holds requests over the concurrency limit in priority
+// queues, answering 503 when a request cannot be admitted
+func (app *Application) AdmissionControl(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		class := app.priorityClass(r)
+
+		if !app.Admission.Acquire(r.Context(), class) {
+			if r.Context().Err() != nil {
+				w.WriteHeader(StatusClientClosedRequest)
+				return
+			}
+			app.Logger.InfoContext(r.Context(), "request not admitted", "class", class, "path", r.URL.Path)
+			app.writeError(w, r, CodeOverloaded, "the proxy is overloaded, try again later")
+			return
+		}
+		defer app.Admission.Release()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// HandleAdmissionMetrics serves GET /admin/metrics/admission
+func (app *Application) HandleAdmissionMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if app.Admission == nil {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": false})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": true, "stats": app.Admission.Stats()})
+}
@@ -0,0 +1,365 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Aggregate failure policies
+const (
+	// AggregateFailAll answers 502 when any backend call fails
+	AggregateFailAll = "fail"
+	// AggregatePartial answers with null for failed backends unless one of
+	// them is required
+	AggregatePartial = "partial"
+)
+
+// AggregatePartialHeader lists the backends whose calls failed in a partial
+// aggregate response
+const AggregatePartialHeader = "X-Proxy-Partial-Response"
+
+// AggregateConfig is the on-disk aggregate route config
+type AggregateConfig struct {
+	Routes []AggregateRouteConfig `json:"routes"`
+}
+
+// AggregateRouteConfig fans a request out to several backends and merges
+// their JSON responses. Each string in Template of the form "$name" or
+// "$name.field.0.sub" is replaced with that part of the named backend's
+// response; without a template the responses are returned keyed by name.
+type AggregateRouteConfig struct {
+	Path      string                   `json:"path"`
+	Method    string                   `json:"method"`
+	Backends  []AggregateBackendConfig `json:"backends"`
+	Template  json.RawMessage          `json:"template,omitempty"`
+	OnFailure string                   `json:"on_failure"`
+	Timeout   string                   `json:"timeout,omitempty"`
+}
+
+// AggregateBackendConfig is one call of an aggregate route. URL is a proxy
+// route path such as /s1/profile, routed like any other request, or an
+// absolute http(s) URL.
+type AggregateBackendConfig struct {
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+	Required bool   `json:"required"`
+}
+
+// aggregateRoute is a validated aggregate route
+type aggregateRoute struct {
+	path      string
+	method    string
+	backends  []AggregateBackendConfig
+	template  interface{}
+	onFailure string
+	timeout   time.Duration
+}
+
+// AggregateRoutes holds the configured aggregate routes by path
+type AggregateRoutes struct {
+	routes map[string]*aggregateRoute
+}
+
+// LoadAggregateRoutes reads an aggregate route config file
+func LoadAggregateRoutes(path string) (*AggregateRoutes, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read aggregate config: %w", err)
+	}
+
+	var cfg AggregateConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse aggregate config: %w", err)
+	}
+
+	return NewAggregateRoutes(cfg.Routes)
+}
+
+// NewAggregateRoutes validates aggregate route configs
+func NewAggregateRoutes(configs []AggregateRouteConfig) (*AggregateRoutes, error) {
+	ar := &AggregateRoutes{routes: make(map[string]*aggregateRoute)}
+
+	for _, cfg := range configs {
+		route := &aggregateRoute{
+			path:      cfg.Path,
+			method:    strings.ToUpper(cfg.Method),
+			backends:  cfg.Backends,
+			onFailure: cfg.OnFailure,
+		}
+
+		if !strings.HasPrefix(route.path, "/") {
+			return nil, fmt.Errorf("aggregate route %q: path must start with /", cfg.Path)
+		}
+		if route.method == "" {
+			route.method = http.MethodGet
+		}
+		if route.method != http.MethodGet && route.method != http.MethodPost {
+			return nil, fmt.Errorf("aggregate route %s: method must be GET or POST", cfg.Path)
+		}
+		if route.onFailure == "" {
+			route.onFailure = AggregateFailAll
+		}
+		if route.onFailure != AggregateFailAll && route.onFailure != AggregatePartial {
+			return nil, fmt.Errorf("aggregate route %s: unknown failure policy %q", cfg.Path, cfg.OnFailure)
+		}
+		if len(route.backends) == 0 {
+			return nil, fmt.Errorf("aggregate route %s: no backends", cfg.Path)
+		}
+
+		seen := make(map[string]bool)
+		for _, b := range route.backends {
+			if b.Name == "" || b.URL == "" {
+				return nil, fmt.Errorf("aggregate route %s: backends need a name and url", cfg.Path)
+			}
+			if seen[b.Name] {
+				return nil, fmt.Errorf("aggregate route %s: duplicate backend %q", cfg.Path, b.Name)
+			}
+			seen[b.Name] = true
+		}
+
+		if cfg.Timeout != "" {
+			timeout, err := time.ParseDuration(cfg.Timeout)
+			if err != nil || timeout <= 0 {
+				return nil, fmt.Errorf("aggregate route %s: invalid timeout %q", cfg.Path, cfg.Timeout)
+			}
+			route.timeout = timeout
+		}
+
+		if len(cfg.Template) > 0 {
+			tmpl, err := decodeJSON(cfg.Template)
+			if err != nil {
+				return nil, fmt.Errorf("aggregate route %s: invalid template: %w", cfg.Path, err)
+			}
+			route.template = tmpl
+		}
+
+		ar.routes[route.path] = route
+	}
+
+	return ar, nil
+}
+
+// aggregateResult is the outcome of one backend call
+type aggregateResult struct {
+	value interface{}
+	err   error
+}
+
+// callAggregateBackend performs one backend call of an aggregate route and
+// decodes its JSON response
+func (app *Application) callAggregateBackend(ctx context.Context, r *http.Request, b AggregateBackendConfig, body []byte) (interface{}, error) {
+	target := b.URL
+	var backend *BackendInfo
+
+	if strings.HasPrefix(b.URL, "/") {
+		var err error
+		backend, err = app.Router.ResolveBackend(ctx, b.URL)
+		if err != nil {
+			return nil, fmt.Errorf("no backend for %s: %w", b.URL, err)
+		}
+		if !app.Drain.acquire(backend.Server.Name) {
+			return nil, fmt.Errorf("backend for %s is being drained", b.URL)
+		}
+		defer app.Drain.release(backend.Server.Name)
+		target = backend.TargetURL
+	}
+	if r.URL.RawQuery != "" {
+		if strings.Contains(target, "?") {
+			target += "&" + r.URL.RawQuery
+		} else {
+			target += "?" + r.URL.RawQuery
+		}
+	}
+
+	name := ""
+	if backend != nil {
+		name = backend.Server.Name
+	}
+	upstreamStart := time.Now()
+	resp, err := app.performRequest(name, r.Method, target, r.WithContext(ctx), body)
+	if backend != nil {
+		app.Latency.Observe(backend.Server.Name, backend.Prefix, time.Since(upstreamStart), upstreamFailed(r, resp, err))
+	}
+
+	if err == nil && resp.StatusCode >= 500 {
+		resp.Body.Close()
+		err = fmt.Errorf("backend answered %d", resp.StatusCode)
+	}
+	if backend != nil {
+		switch {
+		case err == nil:
+			app.CircuitBreaker.OnSuccess(backend.Server.Name)
+		case ctx.Err() == nil || ctx.Err() == context.DeadlineExceeded:
+			app.CircuitBreaker.OnUpstreamFailure(backend.Server.Name, err)
+		}
+		app.CircuitBreaker.OnRequestComplete(backend.Server.Name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("backend answered %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	value, err := decodeJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("backend response is not JSON: %w", err)
+	}
+	return value, nil
+}
+
+// serveAggregate fans a request for an aggregate route out to its backends
+// in parallel and merges the responses. It reports whether the request was
+// handled.
+func (app *Application) serveAggregate(w http.ResponseWriter, r *http.Request) bool {
+	if app.Aggregates == nil {
+		return false
+	}
+
+	route, exists := app.Aggregates.routes[r.URL.Path]
+	if !exists {
+		return false
+	}
+	if r.Method != route.method {
+		app.writeError(w, r, CodeMethodNotAllowed, "unsupported http method")
+		return true
+	}
+
+	var body []byte
+	if r.Method == http.MethodPost {
+		var err error
+		if body, err = io.ReadAll(r.Body); err != nil {
+			app.writeError(w, r, CodeBadRequest, "invalid request body")
+			return true
+		}
+	}
+
+	ctx := r.Context()
+	if route.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, route.timeout)
+		defer cancel()
+	}
+
+	results := make(map[string]aggregateResult, len(route.backends))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, b := range route.backends {
+		wg.Add(1)
+		go func(b AggregateBackendConfig) {
+			defer wg.Done()
+			value, err := app.callAggregateBackend(ctx, r, b, body)
+			mu.Lock()
+			results[b.Name] = aggregateResult{value: value, err: err}
+			mu.Unlock()
+		}(b)
+	}
+	wg.Wait()
+
+	if r.Context().Err() != nil {
+		w.WriteHeader(StatusClientClosedRequest)
+		return true
+	}
+
+	var failed []string
+	values := make(map[string]interface{}, len(results))
+	for _, b := range route.backends {
+		result := results[b.Name]
+		if result.err == nil {
+			values[b.Name] = result.value
+			continue
+		}
+
+		app.Logger.WarnContext(r.Context(), "aggregate backend call failed", "route", route.path, "backend", b.Name, "error", result.err)
+		failed = append(failed, b.Name)
+		values[b.Name] = nil
+
+		if route.onFailure == AggregateFailAll || b.Required {
+			if ctx.Err() == context.DeadlineExceeded {
+				app.writeError(w, r, CodeUpstreamTimeout, "the backend did not respond in time")
+				return true
+			}
+			app.runErrorPlugins(r, result.err)
+			app.writeError(w, r, CodeUpstreamError, fmt.Sprintf("aggregate backend %q failed", b.Name))
+			return true
+		}
+	}
+
+	var merged interface{} = values
+	if route.template != nil {
+		merged = expandAggregateTemplate(route.template, values)
+	}
+
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		w.Header().Set(AggregatePartialHeader, strings.Join(failed, ","))
+	}
+	writeJSON(w, http.StatusOK, merged)
+
+	app.Logger.InfoContext(r.Context(), "aggregate request completed", "route", route.path, "backends", len(route.backends), "failed", len(failed))
+	return true
+}
+
+// expandAggregateTemplate replaces "$name.path" strings in a decoded template
+// with values from the backend responses; "$$" escapes a literal "$"
+func expandAggregateTemplate(tmpl interface{}, values map[string]interface{}) interface{} {
+	switch t := tmpl.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for key, v := range t {
+			out[key] = expandAggregateTemplate(v, values)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, v := range t {
+			out[i] = expandAggregateTemplate(v, values)
+		}
+		return out
+	case string:
+		if strings.HasPrefix(t, "$$") {
+			return t[1:]
+		}
+		if !strings.HasPrefix(t, "$") {
+			return t
+		}
+		return lookupJSONPath(values, strings.Split(t[1:], "."))
+	default:
+		return t
+	}
+}
+
+// lookupJSONPath walks decoded JSON by object keys and array indexes,
+// returning nil when the path does not exist
+func lookupJSONPath(v interface{}, path []string) interface{} {
+	for _, key := range path {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			v = node[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil
+			}
+			v = node[i]
+		default:
+			return nil
+		}
+	}
+	return v
+}
@@ -2,9 +2,12 @@ package app
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/codytheroux96/go-reverse-proxy/internal/registry"
@@ -23,15 +26,26 @@ type RegistryInterface interface {
 }
 
 type RateLimiterConfig struct {
-	enabled bool
-	rps     float64
-	burst   int
+	enabled   bool
+	rps       float64
+	burst     int
+	algorithm string
+	// routes maps a route prefix to the algorithm used for it
+	routes map[string]string
+	// softRPS and softBurst are a lower limit past which requests are still
+	// served but warned; a softRPS of 0 disables it
+	softRPS   float64
+	softBurst int
+	// costs maps a route prefix to how many units of the budget its requests
+	// consume; other requests cost 1
+	costs map[string]int
 }
 
 type Application struct {
 	Logger *slog.Logger
 	Cache  *ResponseCache
 	config struct {
+		mu      sync.RWMutex
 		Limiter RateLimiterConfig
 	}
 	Client         *http.Client
@@ -39,8 +53,149 @@ type Application struct {
 	HealthMonitor  *HealthMonitor
 	CircuitBreaker *CircuitBreakerManager
 	Router         *ResilientRouter
-	ctx            context.Context
-	cancelFunc     context.CancelFunc
+	// RouteMisses remembers paths no route matched; nil looks every path
+	// up in the registry
+	RouteMisses    *RouteMissCache
+	AccessLogger   *AccessLogger
+	RecentRequests *RecentSink
+	// Sampler records the full detail of sampled requests; nil disables
+	// sampling
+	Sampler *RequestSampler
+	Audit   *AuditLog
+	Probes  *Probes
+	// RouteTemplates are the route labels per-route metrics may use
+	RouteTemplates *RouteTemplates
+	Latency        *LatencyMetrics
+	Traffic        *TrafficMetrics
+	UpstreamTiming *UpstreamTimingMetrics
+	UpstreamErrors *UpstreamErrorMetrics
+	// Reliability holds the kill switches and metrics of retries and
+	// coalescing
+	Reliability *ReliabilityFeatures
+	// RetryPolicy decides which requests are safe to retry
+	RetryPolicy *RetryPolicy
+	// RouteLogging quiets or samples the log lines of noisy routes
+	RouteLogging *RouteLogging
+	// Drain counts in-flight requests per backend and holds backends being
+	// deregistered out of selection until those requests complete
+	Drain        *BackendDrainer
+	Counters     *RequestCounters
+	Events       *EventBus
+	EventMetrics *EventMetrics
+	// Leader elects one of several instances to run active health checks;
+	// nil means this instance always runs them
+	Leader *LeaderElection
+	// Cluster gossips backend failures with other replicas; nil disables it
+	Cluster *Cluster
+	// TLSPolicies enforces per-route TLS requirements; nil allows every
+	// request however it arrived
+	TLSPolicies *TLSPolicies
+	// ResponseAssertions checks backend responses per route; nil disables
+	// response assertions
+	ResponseAssertions *ResponseAssertions
+	// Connections limits listener connections; nil means unlimited
+	Connections *ConnLimiter
+	// ClientStreams caps concurrent requests per client; nil means unlimited
+	ClientStreams *ClientStreamLimiter
+	// Resolver resolves backend hostnames with caching and fallback
+	// nameservers; nil dials with the system resolver
+	Resolver *Resolver
+	// HeaderLimits strips dangerous request headers and rejects requests
+	// whose headers would overwhelm a backend; nil forwards them unchecked
+	HeaderLimits *HeaderLimits
+	// Enrichment adds geo, TLS, device and subject headers for backends;
+	// nil forwards none
+	Enrichment *Enrichment
+	// UpstreamConns retires pooled backend connections by age and counts
+	// their churn; nil leaves them to the transport defaults
+	UpstreamConns *UpstreamConns
+	// RateLimitSchedule overrides the limiter by time of day; nil disables it
+	RateLimitSchedule *RateLimitScheduler
+	// Penalties tarpits and bans clients that keep exceeding the rate limit;
+	// nil disables escalation
+	Penalties *PenaltyBox
+	// Admission limits concurrent requests by priority class; nil means unlimited
+	Admission   *AdmissionController
+	WasmFilters *WasmFilterManager
+	ErrorPages  *ErrorPages
+	Static      *StaticRoutes
+	OpenAPI     *OpenAPIRoutes
+	BlueGreen   *BlueGreenRoutes
+	Ramps       *TrafficRamps
+	// Schedule applies routing changes at set times
+	Schedule    *RouteSchedule
+	Experiments *Experiments
+	DarkLaunch  *DarkLaunch
+	// ServiceGroups splits traffic between the logical services sharing a
+	// route
+	ServiceGroups *ServiceGroups
+	// RegistryWebhooks notifies external systems of registry changes; nil
+	// disables them
+	RegistryWebhooks *RegistryWebhooks
+	// Ownership restricts registry changes under a prefix to the team that
+	// claimed it; nil lets anyone register any prefix
+	Ownership *PrefixOwnership
+	// HealthWeights favours backends with faster health checks and fewer
+	// errors; nil selects round-robin
+	HealthWeights *HealthWeights
+	// Zones keeps traffic on backends in the proxy's zone; nil ignores zones
+	Zones *ZoneRouting
+	// Degrader serves expired cached responses when a degradable route has
+	// no backend; nil returns the error instead
+	Degrader *Degrader
+	// Coalescer deduplicates identical in-flight GETs; nil disables coalescing
+	Coalescer *Coalescer
+	// Aggregates fans composite routes out to several backends; nil disables them
+	Aggregates *AggregateRoutes
+	// GRPC translates JSON requests for gRPC routes; nil disables translation
+	GRPC *GRPCTranslator
+	// Schemas validates JSON request bodies per route; nil disables validation
+	Schemas *SchemaValidator
+	// StageShed skips optional stages while the proxy is overloaded; nil
+	// always runs them
+	StageShed *StageShedder
+	// Certificates tracks the expiry of the serving and CA certificates
+	Certificates *CertMonitor
+	// SelfMonitor samples the proxy's own memory, GC and CPU use and turns
+	// away requests near the memory limit
+	SelfMonitor *SelfMonitor
+	// Prewarm opens backend connections ahead of traffic; nil disables it
+	Prewarm *Prewarmer
+	// Bots classifies requests as likely bots and acts on them per route;
+	// nil disables bot detection
+	Bots *BotDetector
+	// middleware is the global chain, adminMiddleware applies to /admin/ only
+	middleware      []Middleware
+	adminMiddleware []Middleware
+	errorReporter   ErrorReporter
+	plugins         []Plugin
+	policies        atomic.Pointer[Policies]
+	timeouts        TimeoutConfig
+	postCacheRoutes map[string]string
+	// cacheAuthRoutes sets how route prefixes cache credentialed requests
+	cacheAuthRoutes map[string]cacheAuthRule
+	// requestCompression maps route prefixes to the request body encoding
+	// their backends accept
+	requestCompression map[string]RequestCompressionRoute
+	normalize          NormalizeConfig
+	// rangeFill fetches whole objects for range requests on its routes; nil
+	// passes ranges through on a miss
+	rangeFill *RangeFillConfig
+	// signedURLs requires signed URLs on its routes; nil disables it
+	signedURLs *signedURLs
+	// drainTimeout bounds how long deregistration waits for in-flight
+	// requests
+	drainTimeout time.Duration
+	// sessionDrain is how long DrainSessions announces a shutdown
+	sessionDrain     time.Duration
+	sessionsDraining atomic.Bool
+	// trafficSummary logs traffic per route periodically; nil disables it
+	trafficSummary *TrafficSummaryConfig
+	// clock times retry backoff; SetClock also hands it to the cache,
+	// breakers and health monitor
+	clock      Clock
+	ctx        context.Context
+	cancelFunc context.CancelFunc
 }
 
 func NewApplication() *Application {
@@ -53,16 +208,41 @@ func NewApplicationWithInMemoryRegistry() *Application {
 	return newApplication(logger, registry)
 }
 
-func NewApplicationWithPostgreSQL(databaseURL string) (*Application, error) {
+func NewApplicationWithPostgreSQL(databaseURL string, pool registry.PoolConfig) (*Application, error) {
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
-	registry, err := registry.NewPostgreSQLRegistry(databaseURL, logger)
+	registry, err := registry.NewPostgreSQLRegistryWithPool(databaseURL, logger, pool)
 	if err != nil {
 		return nil, err
 	}
-	return newApplication(logger, registry), nil
+	app := newApplication(logger, registry)
+	app.Audit = NewAuditLog(logger, NewPostgresAuditStore(registry.DB()))
+	return app, nil
+}
+
+// NewApplicationWithFailoverRegistry is NewApplicationWithPostgreSQL with an
+// in-memory mirror that serves, and accepts registrations, while PostgreSQL
+// is unreachable
+func NewApplicationWithFailoverRegistry(databaseURL string, pool registry.PoolConfig) (*Application, error) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	primary, err := registry.NewPostgreSQLRegistryWithPool(databaseURL, logger, pool)
+	if err != nil {
+		return nil, err
+	}
+	app := newApplication(logger, registry.NewFailoverRegistry(primary, logger))
+	app.Audit = NewAuditLog(logger, NewPostgresAuditStore(primary.DB()))
+	return app, nil
+}
+
+// NewApplicationWithRegistry creates an application around an existing logger
+// and registry, for embedding the proxy in another program
+func NewApplicationWithRegistry(logger *slog.Logger, reg RegistryInterface) *Application {
+	return newApplication(logger, reg)
 }
 
 func newApplication(logger *slog.Logger, reg RegistryInterface) *Application {
+	// Tag request-scoped log lines with the request ID, and filter them by
+	// the request's route log rule
+	logger = slog.New(NewRouteLogHandler(NewRequestIDLogHandler(logger.Handler())))
 
 	// Create context for the application lifecycle
 	ctx, cancel := context.WithCancel(context.Background())
@@ -71,38 +251,288 @@ func newApplication(logger *slog.Logger, reg RegistryInterface) *Application {
 	cacheTTL := 30 * time.Second
 	cacheMaxBytes := 10 * 1024 * 1024 // 10 MB cache capacity
 
+	// Per-route metrics are labelled by route template, never raw paths
+	templates := NewRouteTemplates()
+
 	app := &Application{
-		Logger: logger,
-		Cache:  NewResponseCache(cacheTTL, cacheMaxBytes, logger),
-		Client: &http.Client{
-			Timeout: 10 * time.Second,
-		},
+		Logger:         logger,
+		Cache:          NewResponseCache(cacheTTL, cacheMaxBytes, logger),
+		Client:         &http.Client{},
 		Registry:       reg,
 		HealthMonitor:  NewHealthMonitor(reg, logger),
 		CircuitBreaker: NewCircuitBreakerManager(logger),
+		Audit:          NewAuditLog(logger, NewMemoryAuditStore(AuditMemoryCapacity)),
+		Probes:         NewProbes(),
+		RouteTemplates: templates,
+		Latency:        NewLatencyMetrics(templates),
+		Traffic:        NewTrafficMetrics(templates),
+		UpstreamTiming: NewUpstreamTimingMetrics(),
+		UpstreamErrors: NewUpstreamErrorMetrics(),
+		Reliability:    NewReliabilityFeatures(),
+		RetryPolicy:    NewRetryPolicy(),
+		RouteLogging:   NewRouteLogging(),
+		Drain:          NewBackendDrainer(),
+		Counters:       &RequestCounters{},
+		WasmFilters:    NewWasmFilterManager(logger),
+		ErrorPages:     NewErrorPages(),
+		Static:         NewStaticRoutes(),
+		OpenAPI:        NewOpenAPIRoutes(reg, logger),
+		BlueGreen:      NewBlueGreenRoutes(),
+		ServiceGroups:  NewServiceGroups(),
+		Experiments:    NewExperiments(),
+		DarkLaunch:     NewDarkLaunch(),
+		Ramps:          NewTrafficRamps(),
+		Schedule:       NewRouteSchedule(),
+		clock:          SystemClock,
+		drainTimeout:   DefaultDrainTimeout,
+		sessionDrain:   DefaultSessionDrainPeriod,
 		ctx:            ctx,
 		cancelFunc:     cancel,
 	}
 
 	app.Router = NewResilientRouter(app)
 
-	go app.Cache.Cleanup(app, 15*time.Second)
+	// The default self-monitor config is always valid
+	app.SelfMonitor, _ = NewSelfMonitor(DefaultSelfMonitorConfig(), logger)
+
+	app.Certificates = NewCertMonitor(logger)
+
+	// Backend attempts are bounded by BackendTimeout and the transport's
+	// header timeout rather than a client timeout, which would also cut off
+	// streamed responses
+	app.Client.Transport = app.upstreamTransport()
+
+	app.Events = NewEventBus(logger)
+	app.EventMetrics = NewEventMetrics()
+	app.HealthMonitor.events = app.Events
+	app.CircuitBreaker.events = app.Events
+	app.OpenAPI.events = app.Events
+	app.Events.Subscribe("metrics", app.EventMetrics.Observe)
+	app.subscribeRecovery()
+	app.subscribeRegistry()
+
+	// Recent requests are always kept in memory for tailing via the admin API
+	app.RecentRequests = NewRecentSink(AccessLogRecentCapacity)
+	app.AccessLogger = NewAccessLogger(logger, app.RecentRequests)
 
 	app.config.Limiter = RateLimiterConfig{
-		enabled: true,
-		rps:     50,
-		burst:   250,
+		enabled:   true,
+		rps:       50,
+		burst:     250,
+		algorithm: RateLimitTokenBucket,
 	}
 
+	app.Use(app.RequestID, app.RouteLogs, app.AnnounceDrain, app.Recover, app.AccessLog, app.SampleRequests, app.Normalize, app.RateLimit)
+
 	return app
 }
 
+// SetClock replaces the clock behind cache expiry, breaker cooldowns, health
+// check intervals, DNS caching, route misses, upstream connection lifetimes and retry
+// backoff. It must be called before
+// Start, and again after replacing Cache.
+func (app *Application) SetClock(clock Clock) {
+	app.clock = clock
+	app.Cache.clock = clock
+	app.CircuitBreaker.clock = clock
+	app.HealthMonitor.clock = clock
+	if app.Resolver != nil {
+		app.Resolver.clock = clock
+	}
+	if app.UpstreamConns != nil {
+		app.UpstreamConns.clock = clock
+	}
+	if app.Degrader != nil {
+		app.Degrader.stale.clock = clock
+	}
+	if app.RouteMisses != nil {
+		app.RouteMisses.clock = clock
+	}
+}
+
 func (app *Application) Start() {
 	app.Logger.Info("starting application components")
 
+	if app.Leader != nil {
+		app.Leader.events = app.Events
+		app.HealthMonitor.leader = app.Leader
+		go app.Leader.Run(app.ctx, LeaderElectionInterval)
+	}
+
+	if app.Cluster != nil {
+		app.Cluster.attach(app)
+		go app.Cluster.Run(app.ctx)
+	}
+
+	// Health checks go through the same transport as proxied requests, so
+	// they use its TLS settings, protocol negotiation, resolver and pooled
+	// connections and fail when real traffic would
+	app.HealthMonitor.client.Transport = app.Client.Transport
+
+	if app.Prewarm != nil {
+		app.subscribePrewarm()
+	}
+
 	go func() {
 		app.HealthMonitor.Start(app.ctx)
 	}()
+
+	go app.AccessLogger.Start()
+
+	app.addGRPCTemplates()
+
+	go app.Cache.Cleanup(app, 15*time.Second)
+	go app.Cache.RunWriter(app.ctx)
+	if app.Degrader != nil {
+		go app.Degrader.stale.Cleanup(app, time.Minute)
+		go app.Degrader.stale.RunWriter(app.ctx)
+	}
+	go app.Ramps.Run(app, RampEvaluateInterval)
+	go app.Schedule.Run(app, RouteScheduleInterval)
+
+	go app.OpenAPI.Watch(app.ctx, SchemaReloadInterval)
+
+	if app.Schemas != nil {
+		go app.Schemas.Watch(app.ctx, SchemaReloadInterval)
+	}
+
+	if app.Penalties != nil {
+		go app.Penalties.Cleanup(app.ctx, time.Minute)
+	}
+
+	if app.RateLimitSchedule != nil {
+		go app.RateLimitSchedule.Run(app.ctx, RateLimitScheduleInterval)
+	}
+
+	go app.SelfMonitor.Run(app.ctx)
+	go app.Certificates.Run(app.ctx, DefaultCertCheckInterval)
+
+	if app.trafficSummary != nil {
+		go app.Traffic.RunSummary(app.ctx, app.Logger, *app.trafficSummary)
+	}
+
+	if app.StageShed != nil {
+		app.StageShed.monitor = app.SelfMonitor
+		go app.StageShed.Run(app.ctx)
+	}
+
+	if app.Bots != nil {
+		go app.Bots.Run(app.ctx)
+	}
+
+	app.Probes.MarkStarted()
+}
+
+// ConfigureAuditFile additionally appends audit events to the given file
+func (app *Application) ConfigureAuditFile(path string) error {
+	store, err := NewFileAuditStore(path)
+	if err != nil {
+		return err
+	}
+
+	app.Audit.stores = append(app.Audit.stores, store)
+	return nil
+}
+
+// LimiterConfig returns the current rate limiter configuration
+func (app *Application) LimiterConfig() RateLimiterConfig {
+	app.config.mu.RLock()
+	defer app.config.mu.RUnlock()
+
+	return app.config.Limiter
+}
+
+// SetLimiterConfig replaces the rate limiter configuration at runtime
+func (app *Application) SetLimiterConfig(cfg RateLimiterConfig) {
+	app.config.mu.Lock()
+	defer app.config.mu.Unlock()
+
+	app.config.Limiter = cfg
+}
+
+// SetRateLimitAlgorithms selects the default rate limiting algorithm and
+// per-route overrides keyed by prefix
+func (app *Application) SetRateLimitAlgorithms(algorithm string, routes map[string]string) error {
+	if !ValidRateLimitAlgorithm(algorithm) {
+		return fmt.Errorf("unknown rate limit algorithm %q", algorithm)
+	}
+	for prefix, name := range routes {
+		if !ValidRateLimitAlgorithm(name) {
+			return fmt.Errorf("unknown rate limit algorithm %q for %s", name, prefix)
+		}
+	}
+
+	app.config.mu.Lock()
+	defer app.config.mu.Unlock()
+
+	app.config.Limiter.algorithm = algorithm
+	app.config.Limiter.routes = routes
+	return nil
+}
+
+// SetRateLimit sets the per-client rate limit; rps 0 disables rate limiting
+func (app *Application) SetRateLimit(rps float64, burst int) error {
+	if rps < 0 || (rps > 0 && burst <= 0) {
+		return fmt.Errorf("rate limit rps must not be negative and burst must be positive")
+	}
+
+	app.config.mu.Lock()
+	defer app.config.mu.Unlock()
+
+	cfg := app.config.Limiter
+	cfg.enabled = rps > 0
+	if cfg.enabled {
+		cfg.rps, cfg.burst = rps, burst
+	}
+	if err := cfg.validateCosts(); err != nil {
+		return err
+	}
+	if err := cfg.validateSoftLimit(); err != nil {
+		return err
+	}
+	app.config.Limiter = cfg
+	return nil
+}
+
+// SetSoftRateLimit sets the soft rate limit, above which requests are served
+// with a warning header; rps 0 disables it and burst 0 scales the hard burst
+func (app *Application) SetSoftRateLimit(rps float64, burst int) error {
+	app.config.mu.Lock()
+	defer app.config.mu.Unlock()
+
+	cfg := app.config.Limiter
+	cfg.softRPS, cfg.softBurst = rps, burst
+	if err := cfg.validateSoftLimit(); err != nil {
+		return err
+	}
+	app.config.Limiter = cfg
+	return nil
+}
+
+// SetRateLimitCosts sets how many units of a client's rate limit budget
+// requests to each route prefix consume
+func (app *Application) SetRateLimitCosts(costs map[string]int) error {
+	app.config.mu.Lock()
+	defer app.config.mu.Unlock()
+
+	cfg := app.config.Limiter
+	cfg.costs = costs
+	if err := cfg.validateCosts(); err != nil {
+		return err
+	}
+	app.config.Limiter = cfg
+	return nil
+}
+
+// ConfigureAccessLog sets up access log shipping from a comma separated sink spec
+func (app *Application) ConfigureAccessLog(spec string) error {
+	sinks, err := ParseAccessLogSinks(spec)
+	if err != nil {
+		return err
+	}
+
+	app.AccessLogger.AddSinks(sinks...)
+	return nil
 }
 
 func (app *Application) Shutdown() {
@@ -111,8 +541,18 @@ func (app *Application) Shutdown() {
 	app.cancelFunc()
 
 	app.HealthMonitor.Stop()
+
+	app.AccessLogger.Stop()
+
+	app.WasmFilters.Close()
+
+	app.Events.Close()
+
+	if app.RegistryWebhooks != nil {
+		app.RegistryWebhooks.Close()
+	}
 }
 
 func (app *Application) LogRequest(r *http.Request) {
-	app.Logger.Info("Incoming Request", "method", r.Method, "path", r.URL.Path)
+	app.Logger.InfoContext(r.Context(), "Incoming Request", "method", r.Method, "path", r.URL.Path)
 }
@@ -0,0 +1,41 @@
+package app
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/codytheroux96/go-reverse-proxy/internal/registry"
+)
+
+// newTestApp creates an application with an in-memory registry and
+// discarded logs
+func newTestApp(t testing.TB) *Application {
+	t.Helper()
+
+	logger := slog.New(slog.DiscardHandler)
+	return NewApplicationWithRegistry(logger, registry.NewRegistry(logger))
+}
+
+// addTestBackend serves handler as a backend registered for prefixes, and
+// runs a health check so it takes traffic at once
+func addTestBackend(t testing.TB, app *Application, name string, handler http.HandlerFunc, prefixes ...string) *httptest.Server {
+	t.Helper()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == HealthCheckPath {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		handler(w, r)
+	}))
+	t.Cleanup(backend.Close)
+
+	if err := app.Registry.Register(registry.Server{Name: name, BaseURL: backend.URL, Prefixes: prefixes}); err != nil {
+		t.Fatalf("failed to register %s: %v", name, err)
+	}
+	app.HealthMonitor.CheckAll(context.Background())
+	return backend
+}
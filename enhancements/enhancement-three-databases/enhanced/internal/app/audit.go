@@ -0,0 +1,463 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/codytheroux96/go-reverse-proxy/internal/db"
+)
+
+// Audit actions recorded for admin and registry mutations
+const (
+	AuditActionRegister        = "register"
+	AuditActionDeregister      = "deregister"
+	AuditActionBreakerReset    = "breaker_reset"
+	AuditActionRoutingReset    = "routing_reset"
+	AuditActionCachePurge      = "cache_purge"
+	AuditActionConfigReload    = "config_reload"
+	AuditActionRateLimitChange = "rate_limit_change"
+	AuditActionFilterChange    = "filter_change"
+	AuditActionRouteImport     = "route_import"
+	AuditActionRouteSwitch     = "route_switch"
+	AuditActionOwnershipChange = "ownership_change"
+	AuditActionFeatureToggle   = "feature_toggle"
+	AuditActionLoggingChange   = "logging_change"
+	AuditActionRetryChange     = "retry_change"
+	AuditActionSignedURLIssue  = "signed_url_issue"
+	AuditActionSamplesClear    = "samples_clear"
+	AuditActionMetricsReset    = "metrics_reset"
+)
+
+const (
+	AuditMemoryCapacity = 1000
+	AuditMaxPayload     = 64 * 1024
+	AuditDefaultLimit   = 100
+	AuditActorHeader    = "X-Admin-Actor"
+	// AdminTokenPrincipal is the actor recorded for requests authenticated
+	// with the admin bearer token
+	AdminTokenPrincipal = "admin-token"
+)
+
+// AuditEvent is a single recorded admin or registry mutation
+type AuditEvent struct {
+	ID           int64           `json:"id,omitempty"`
+	Action       string          `json:"action"`
+	Actor        string          `json:"actor"`
+	ClaimedActor string          `json:"claimed_actor,omitempty"` // unverified X-Admin-Actor header
+	Target       string          `json:"target,omitempty"`
+	Payload      json.RawMessage `json:"payload"`
+	Timestamp    time.Time       `json:"timestamp"`
+}
+
+// AuditQuery filters audit events; zero values match everything
+type AuditQuery struct {
+	Action string
+	Actor  string
+	Since  time.Time
+	Limit  int
+}
+
+// matches reports whether an event satisfies the query filters
+func (q AuditQuery) matches(e AuditEvent) bool {
+	if q.Action != "" && e.Action != q.Action {
+		return false
+	}
+	if q.Actor != "" && e.Actor != q.Actor {
+		return false
+	}
+	if !q.Since.IsZero() && e.Timestamp.Before(q.Since) {
+		return false
+	}
+	return true
+}
+
+// AuditStore is an append-only destination for audit events
+type AuditStore interface {
+	Append(ctx context.Context, event AuditEvent) error
+	Query(ctx context.Context, q AuditQuery) ([]AuditEvent, error)
+}
+
+// AuditLog records admin actions to one or more stores and answers queries
+// from the first store configured
+type AuditLog struct {
+	stores []AuditStore
+	logger *slog.Logger
+}
+
+// NewAuditLog creates an audit log writing to the given stores
+func NewAuditLog(logger *slog.Logger, stores ...AuditStore) *AuditLog {
+	return &AuditLog{
+		stores: stores,
+		logger: logger,
+	}
+}
+
+// Record appends an event to every store, logging any store failures
+func (al *AuditLog) Record(action, actor, claimedActor, target string, payload json.RawMessage) {
+	if len(payload) == 0 {
+		payload = json.RawMessage("{}")
+	}
+
+	event := AuditEvent{
+		Action:       action,
+		Actor:        actor,
+		ClaimedActor: claimedActor,
+		Target:       target,
+		Payload:      payload,
+		Timestamp:    time.Now().UTC(),
+	}
+
+	for _, store := range al.stores {
+		if err := store.Append(context.Background(), event); err != nil {
+			al.logger.Error("failed to record audit event",
+				"action", action,
+				"actor", actor,
+				"target", target,
+				"error", err)
+		}
+	}
+
+	al.logger.Info("audit event recorded", "action", action, "actor", actor, "claimed_actor", claimedActor, "target", target)
+}
+
+// Query returns events matching the query, newest first
+func (al *AuditLog) Query(ctx context.Context, q AuditQuery) ([]AuditEvent, error) {
+	if len(al.stores) == 0 {
+		return nil, nil
+	}
+	if q.Limit <= 0 {
+		q.Limit = AuditDefaultLimit
+	}
+	return al.stores[0].Query(ctx, q)
+}
+
+// MemoryAuditStore keeps the most recent audit events in memory
+type MemoryAuditStore struct {
+	mu       sync.RWMutex
+	events   []AuditEvent
+	capacity int
+	nextID   int64
+}
+
+// NewMemoryAuditStore creates an in-memory store holding up to capacity events
+func NewMemoryAuditStore(capacity int) *MemoryAuditStore {
+	return &MemoryAuditStore{
+		events:   make([]AuditEvent, 0, capacity),
+		capacity: capacity,
+	}
+}
+
+func (s *MemoryAuditStore) Append(ctx context.Context, event AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	event.ID = s.nextID
+
+	if len(s.events) >= s.capacity {
+		s.events = s.events[1:]
+	}
+	s.events = append(s.events, event)
+	return nil
+}
+
+func (s *MemoryAuditStore) Query(ctx context.Context, q AuditQuery) ([]AuditEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var result []AuditEvent
+	for i := len(s.events) - 1; i >= 0 && len(result) < q.Limit; i-- {
+		if q.matches(s.events[i]) {
+			result = append(result, s.events[i])
+		}
+	}
+	return result, nil
+}
+
+// FileAuditStore appends audit events as JSON lines to a file
+type FileAuditStore struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+// NewFileAuditStore opens (or creates) the audit file in append-only mode
+func NewFileAuditStore(path string) (*FileAuditStore, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log file: %w", err)
+	}
+	return &FileAuditStore{path: path, file: f}, nil
+}
+
+func (s *FileAuditStore) Append(ctx context.Context, event AuditEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+func (s *FileAuditStore) Query(ctx context.Context, q AuditQuery) ([]AuditEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log file: %w", err)
+	}
+	defer f.Close()
+
+	var matched []AuditEvent
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 2*AuditMaxPayload)
+	for scanner.Scan() {
+		var event AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			continue
+		}
+		if q.matches(event) {
+			matched = append(matched, event)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// Newest first, capped at the limit
+	result := make([]AuditEvent, 0, q.Limit)
+	for i := len(matched) - 1; i >= 0 && len(result) < q.Limit; i-- {
+		result = append(result, matched[i])
+	}
+	return result, nil
+}
+
+// Close closes the underlying file
+func (s *FileAuditStore) Close() error {
+	return s.file.Close()
+}
+
+// PostgresAuditStore writes audit events to the audit_log table
+type PostgresAuditStore struct {
+	queries *db.Queries
+}
+
+// NewPostgresAuditStore creates a store backed by the given database
+func NewPostgresAuditStore(database *sql.DB) *PostgresAuditStore {
+	return &PostgresAuditStore{queries: db.New(database)}
+}
+
+func (s *PostgresAuditStore) Append(ctx context.Context, event AuditEvent) error {
+	_, err := s.queries.InsertAuditEvent(ctx, db.InsertAuditEventParams{
+		Action:       event.Action,
+		Actor:        event.Actor,
+		ClaimedActor: event.ClaimedActor,
+		Target:       event.Target,
+		Payload:      event.Payload,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to insert audit event: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresAuditStore) Query(ctx context.Context, q AuditQuery) ([]AuditEvent, error) {
+	rows, err := s.queries.ListAuditEvents(ctx, db.ListAuditEventsParams{
+		Action: sql.NullString{String: q.Action, Valid: q.Action != ""},
+		Actor:  sql.NullString{String: q.Actor, Valid: q.Actor != ""},
+		Since:  sql.NullTime{Time: q.Since, Valid: !q.Since.IsZero()},
+		Limit:  int32(q.Limit),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit events: %w", err)
+	}
+
+	events := make([]AuditEvent, len(rows))
+	for i, row := range rows {
+		events[i] = AuditEvent{
+			ID:           row.ID,
+			Action:       row.Action,
+			Actor:        row.Actor,
+			ClaimedActor: row.ClaimedActor,
+			Target:       row.Target,
+			Payload:      row.Payload,
+			Timestamp:    row.CreatedAt,
+		}
+	}
+	return events, nil
+}
+
+// Audited wraps a mutating handler and records an audit event when it
+// succeeds. The event keeps the first AuditMaxPayload bytes of the request
+// body; the handler still reads all of it.
+func (app *Application) Audited(action string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if app.Audit == nil || r.Method == http.MethodGet {
+			next(w, r)
+			return
+		}
+
+		var buf bytes.Buffer
+		if _, err := buf.ReadFrom(io.LimitReader(r.Body, AuditMaxPayload+1)); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buf.Bytes()), r.Body), r.Body}
+
+		rec := &responseRecorder{ResponseWriter: w}
+		next(rec, r)
+		rec.finish()
+
+		if rec.status < 200 || rec.status > 299 {
+			return
+		}
+
+		body := buf.Bytes()
+		payload := json.RawMessage(body)
+		if len(body) > AuditMaxPayload {
+			body = body[:AuditMaxPayload]
+			payload, _ = json.Marshal(map[string]interface{}{"raw": string(body), "truncated": true})
+		} else if !json.Valid(body) {
+			payload, _ = json.Marshal(map[string]string{"raw": string(body)})
+		}
+
+		actor, claimed := auditActor(r)
+		app.Audit.Record(action, actor, claimed, auditTarget(r, body), payload)
+	}
+}
+
+type adminPrincipalKey struct{}
+
+// ContextWithAdminPrincipal records who an admin request authenticated as,
+// so admin auth middleware can attribute audited actions to them
+func ContextWithAdminPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, adminPrincipalKey{}, principal)
+}
+
+// AdminPrincipalFromContext returns the principal set with
+// ContextWithAdminPrincipal
+func AdminPrincipalFromContext(ctx context.Context) string {
+	principal, _ := ctx.Value(adminPrincipalKey{}).(string)
+	return principal
+}
+
+// auditActor identifies who performed an admin action: the authenticated
+// principal, or the client IP when admin auth is off. The X-Admin-Actor
+// header is returned apart as claimed, since any client can set it.
+func auditActor(r *http.Request) (actor, claimed string) {
+	claimed = r.Header.Get(AuditActorHeader)
+	if principal := AdminPrincipalFromContext(r.Context()); principal != "" {
+		return principal, claimed
+	}
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr, claimed
+	}
+	return ip, claimed
+}
+
+// auditTarget extracts the server name or route prefix an admin action
+// applies to, if any
+func auditTarget(r *http.Request, body []byte) string {
+	if name := r.URL.Query().Get("name"); name != "" {
+		return name
+	}
+	if prefix := r.URL.Query().Get("prefix"); prefix != "" {
+		return prefix
+	}
+	if name := r.PathValue("name"); name != "" {
+		return name
+	}
+
+	var fields struct {
+		Name    string `json:"name"`
+		Server  string `json:"server"`
+		Service string `json:"service"`
+		Prefix  string `json:"prefix"`
+	}
+	if err := json.Unmarshal(body, &fields); err == nil {
+		if fields.Name != "" {
+			return fields.Name
+		}
+		if fields.Server != "" {
+			return fields.Server
+		}
+		if fields.Service != "" {
+			return fields.Service
+		}
+		return fields.Prefix
+	}
+	return ""
+}
+
+// HandleAuditList serves GET /admin/audit?action=&actor=&since=&limit=
+func (app *Application) HandleAuditList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := AuditQuery{
+		Action: r.URL.Query().Get("action"),
+		Actor:  r.URL.Query().Get("actor"),
+	}
+
+	if since := r.URL.Query().Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			http.Error(w, "since must be an RFC3339 timestamp", http.StatusBadRequest)
+			return
+		}
+		query.Since = t
+	}
+
+	if limit := r.URL.Query().Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		query.Limit = n
+	}
+
+	events, err := app.Audit.Query(r.Context(), query)
+	if err != nil {
+		app.Logger.ErrorContext(r.Context(), "failed to query audit log", "error", err)
+		http.Error(w, "failed to query audit log", http.StatusInternalServerError)
+		return
+	}
+
+	if events == nil {
+		events = []AuditEvent{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(struct {
+		Events []AuditEvent `json:"events"`
+	}{
+		Events: events,
+	})
+}
@@ -0,0 +1,107 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAuditedPassesWholeBody(t *testing.T) {
+	app := newTestApp(t)
+
+	var received int
+	handler := app.Audited(AuditActionRouteImport, func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		received = len(body)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	size := 3 * AuditMaxPayload
+	r := httptest.NewRequest(http.MethodPost, "/admin/openapi", strings.NewReader(strings.Repeat("x", size)))
+	handler(httptest.NewRecorder(), r)
+
+	if received != size {
+		t.Fatalf("handler read %d bytes, want %d", received, size)
+	}
+
+	events, err := app.Audit.Query(context.Background(), AuditQuery{Action: AuditActionRouteImport})
+	if err != nil || len(events) != 1 {
+		t.Fatalf("got %d events, err %v; want 1", len(events), err)
+	}
+	var payload struct {
+		Raw       string `json:"raw"`
+		Truncated bool   `json:"truncated"`
+	}
+	if err := json.Unmarshal(events[0].Payload, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if len(payload.Raw) != AuditMaxPayload || !payload.Truncated {
+		t.Fatalf("recorded %d bytes, truncated %v; want %d bytes marked truncated", len(payload.Raw), payload.Truncated, AuditMaxPayload)
+	}
+}
+
+func TestAuditedKeepsSmallJSONPayload(t *testing.T) {
+	app := newTestApp(t)
+
+	handler := app.Audited(AuditActionCachePurge, func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusOK)
+	})
+	r := httptest.NewRequest(http.MethodPost, "/admin/cache/purge", strings.NewReader(`{"prefix": "/api"}`))
+	handler(httptest.NewRecorder(), r)
+
+	events, err := app.Audit.Query(context.Background(), AuditQuery{Action: AuditActionCachePurge})
+	if err != nil || len(events) != 1 {
+		t.Fatalf("got %d events, err %v; want 1", len(events), err)
+	}
+	if got := string(events[0].Payload); got != `{"prefix": "/api"}` || events[0].Target != "/api" {
+		t.Fatalf("payload %s, target %q", got, events[0].Target)
+	}
+}
+
+func TestAuditActorIsAuthenticatedPrincipal(t *testing.T) {
+	app := newTestApp(t)
+	handler := RequireBearerToken("s3cret")(app.Audited(AuditActionCachePurge, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	r := httptest.NewRequest(http.MethodPost, "/admin/cache/purge", strings.NewReader(`{}`))
+	r.Header.Set("Authorization", "Bearer s3cret")
+	r.Header.Set(AuditActorHeader, "alice")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	events, err := app.Audit.Query(context.Background(), AuditQuery{Action: AuditActionCachePurge})
+	if err != nil || len(events) != 1 {
+		t.Fatalf("got %d events, err %v; want 1", len(events), err)
+	}
+	if events[0].Actor != AdminTokenPrincipal || events[0].ClaimedActor != "alice" {
+		t.Fatalf("actor %q, claimed %q; want %q claiming alice", events[0].Actor, events[0].ClaimedActor, AdminTokenPrincipal)
+	}
+}
+
+func TestAuditActorWithoutAuthIsClientIP(t *testing.T) {
+	app := newTestApp(t)
+	handler := app.Audited(AuditActionCachePurge, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	r := httptest.NewRequest(http.MethodPost, "/admin/cache/purge", strings.NewReader(`{}`))
+	r.RemoteAddr = "192.0.2.7:51234"
+	r.Header.Set(AuditActorHeader, "admin-token")
+	handler(httptest.NewRecorder(), r)
+
+	events, err := app.Audit.Query(context.Background(), AuditQuery{Action: AuditActionCachePurge})
+	if err != nil || len(events) != 1 {
+		t.Fatalf("got %d events, err %v; want 1", len(events), err)
+	}
+	if events[0].Actor != "192.0.2.7" || events[0].ClaimedActor != "admin-token" {
+		t.Fatalf("actor %q, claimed %q; want the client IP", events[0].Actor, events[0].ClaimedActor)
+	}
+}
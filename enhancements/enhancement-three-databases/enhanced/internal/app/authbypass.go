@@ -0,0 +1,198 @@
+package app
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/codytheroux96/go-reverse-proxy/internal/expr"
+)
+
+// MaxSignedBodyBytes bounds the request body read to verify a webhook
+// signature; larger bodies fail verification
+const MaxSignedBodyBytes = 10 << 20
+
+// AuthBypassConfig lets requests under a route prefix through the global
+// auth middleware, such as health endpoints, public assets, or webhook
+// receivers that cannot send credentials
+type AuthBypassConfig struct {
+	Prefix string `json:"prefix"`
+	// When narrows the rule with a policy expression; empty matches every
+	// request under Prefix
+	When string `json:"when"`
+	// Signature, when set, replaces credentials with a signature over the
+	// request body instead of skipping authentication outright
+	Signature *SignatureConfig `json:"signature"`
+}
+
+// SignatureConfig verifies a hex HMAC-SHA256 of the request body, the
+// scheme GitHub and many other webhook senders use
+type SignatureConfig struct {
+	// Header carries the signature, such as X-Hub-Signature-256
+	Header string `json:"header"`
+	// Prefix is stripped from the header value before decoding, such as
+	// "sha256="
+	Prefix string `json:"prefix"`
+	// SecretEnv names the environment variable holding the shared secret,
+	// so secrets stay out of policy files
+	SecretEnv string `json:"secret_env"`
+}
+
+// authBypassRule is a compiled AuthBypassConfig
+type authBypassRule struct {
+	prefix    string
+	when      *expr.Program
+	signature *signatureCheck
+}
+
+type signatureCheck struct {
+	header string
+	prefix string
+	secret []byte
+}
+
+// compileAuthBypass compiles a bypass rule, reading its signature secret
+func compileAuthBypass(cfg AuthBypassConfig) (authBypassRule, error) {
+	if !strings.HasPrefix(cfg.Prefix, "/") {
+		return authBypassRule{}, fmt.Errorf("prefix %q must start with /", cfg.Prefix)
+	}
+
+	when := "true"
+	if cfg.When != "" {
+		when = cfg.When
+	}
+	prog, err := expr.Compile(when)
+	if err != nil {
+		return authBypassRule{}, err
+	}
+	rule := authBypassRule{prefix: cfg.Prefix, when: prog}
+
+	if sig := cfg.Signature; sig != nil {
+		if sig.Header == "" || sig.SecretEnv == "" {
+			return authBypassRule{}, fmt.Errorf("signature needs a header and a secret_env")
+		}
+		secret := os.Getenv(sig.SecretEnv)
+		if secret == "" {
+			return authBypassRule{}, fmt.Errorf("signature secret %s is not set", sig.SecretEnv)
+		}
+		rule.signature = &signatureCheck{header: sig.Header, prefix: sig.Prefix, secret: []byte(secret)}
+	}
+	return rule, nil
+}
+
+// matchAuthBypass returns the first bypass rule matching the request, or nil.
+// Evaluation errors skip the rule, so a broken rule falls back to requiring
+// credentials.
+func (app *Application) matchAuthBypass(r *http.Request) *authBypassRule {
+	policies := app.policies.Load()
+	if policies == nil || len(policies.authBypass) == 0 {
+		return nil
+	}
+	return policies.matchAuthBypass(r, func(rule *authBypassRule, err error) {
+		app.Logger.WarnContext(r.Context(), "auth bypass rule failed to evaluate", "prefix", rule.prefix, "error", err)
+	})
+}
+
+// matchAuthBypass returns the first bypass rule matching the request,
+// reporting evaluation errors to onErr. Rules match the cleaned path, so
+// dot segments cannot climb out of a bypassed prefix, and a path with
+// encoded traversal never bypasses auth.
+func (p *Policies) matchAuthBypass(r *http.Request, onErr func(*authBypassRule, error)) *authBypassRule {
+	if hasEncodedTraversal(r.URL.EscapedPath()) {
+		return nil
+	}
+	path := cleanPath(r.URL.Path)
+
+	var vars map[string]interface{}
+	for i := range p.authBypass {
+		rule := &p.authBypass[i]
+		if !underPrefix(path, rule.prefix) {
+			continue
+		}
+		if vars == nil {
+			vars = requestVars(r)
+		}
+		match, err := rule.when.EvalBool(vars)
+		if err != nil {
+			if onErr != nil {
+				onErr(rule, err)
+			}
+			continue
+		}
+		if match {
+			return rule
+		}
+	}
+	return nil
+}
+
+// underPrefix reports whether path lies under prefix by whole segments, so
+// /health covers /health and /health/live but not /healthz
+func underPrefix(path, prefix string) bool {
+	if strings.HasSuffix(prefix, "/") {
+		return strings.HasPrefix(path, prefix)
+	}
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// verify checks the request body against the signature header and restores
+// the body for the backend
+func (sc *signatureCheck) verify(r *http.Request) bool {
+	provided, found := strings.CutPrefix(r.Header.Get(sc.header), sc.prefix)
+	if !found || provided == "" {
+		return false
+	}
+	signature, err := hex.DecodeString(provided)
+	if err != nil {
+		return false
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, MaxSignedBodyBytes+1))
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil || len(body) > MaxSignedBodyBytes {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, sc.secret)
+	mac.Write(body)
+	return hmac.Equal(signature, mac.Sum(nil))
+}
+
+// Authenticate applies auth to proxied requests except those matching an
+// auth_bypass policy rule. Requests matching a rule with a signature must
+// carry a valid body signature instead. The /admin/ routes are left to the
+// admin middleware, which has its own credentials.
+//
+//	application.Use(application.Authenticate(app.RequireBearerToken(token)))
+func (app *Application) Authenticate(auth Middleware) Middleware {
+	return func(next http.Handler) http.Handler {
+		authenticated := auth(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasPrefix(r.URL.Path, "/admin/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			rule := app.matchAuthBypass(r)
+			if rule == nil {
+				authenticated.ServeHTTP(w, r)
+				return
+			}
+
+			if rule.signature != nil && !rule.signature.verify(r) {
+				app.Logger.WarnContext(r.Context(), "webhook signature rejected", "path", r.URL.Path, "prefix", rule.prefix)
+				app.writeError(w, r, CodeInvalidSignature, "invalid signature")
+				return
+			}
+			app.Logger.DebugContext(r.Context(), "auth bypassed", "path", r.URL.Path, "prefix", rule.prefix)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package app
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthBypassMatchesWholeSegments(t *testing.T) {
+	var policies Policies
+	for _, prefix := range []string{"/health", "/public/"} {
+		rule, err := compileAuthBypass(AuthBypassConfig{Prefix: prefix})
+		if err != nil {
+			t.Fatal(err)
+		}
+		policies.authBypass = append(policies.authBypass, rule)
+	}
+
+	tests := []struct {
+		path     string
+		bypassed bool
+	}{
+		{"/health", true},
+		{"/health/live", true},
+		{"/healthz-internal", false},
+		{"/health/../api", false},
+		{"/health/./live", true},
+		{"//health", true},
+		{"/health/%2e%2e/api", false},
+		{"/public/app.js", true},
+		{"/public", false},
+		{"/publicity", false},
+		{"/api", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "http://proxy"+tt.path, nil)
+		if got := policies.matchAuthBypass(r, nil) != nil; got != tt.bypassed {
+			t.Errorf("%s: bypassed = %v, want %v", tt.path, got, tt.bypassed)
+		}
+	}
+}
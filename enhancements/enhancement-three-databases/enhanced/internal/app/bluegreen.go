@@ -0,0 +1,386 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// BlueGreenRoute splits the backends registered for a prefix into named
+// groups, only one of which receives the prefix's traffic at a time, apart
+// from an optional canary group receiving a percentage of it. Backends
+// registered for the prefix but in no group receive none.
+type BlueGreenRoute struct {
+	Prefix string `json:"prefix"`
+	// Groups maps a group name such as "blue" to its server names
+	Groups map[string][]string `json:"groups"`
+	// Active is the group receiving traffic
+	Active string `json:"active"`
+	// Canary receives CanaryWeight percent of requests; when it has no
+	// healthy servers they go to the active group instead
+	Canary       string `json:"canary,omitempty"`
+	CanaryWeight int    `json:"canary_weight,omitempty"`
+	// Previous is the group that was active before the last cutover
+	Previous   string     `json:"previous,omitempty"`
+	SwitchedAt *time.Time `json:"switched_at,omitempty"`
+}
+
+// validate checks the route's groups and active group
+func (route *BlueGreenRoute) validate() error {
+	if route.Prefix == "" {
+		return fmt.Errorf("prefix is required")
+	}
+	if len(route.Groups) < 2 {
+		return fmt.Errorf("at least two groups are required")
+	}
+
+	owner := make(map[string]string)
+	for name, servers := range route.Groups {
+		if name == "" {
+			return fmt.Errorf("group names must not be empty")
+		}
+		if len(servers) == 0 {
+			return fmt.Errorf("group %q has no servers", name)
+		}
+		for _, server := range servers {
+			if other, taken := owner[server]; taken && other != name {
+				return fmt.Errorf("server %q is in groups %q and %q", server, other, name)
+			}
+			owner[server] = name
+		}
+	}
+
+	if _, exists := route.Groups[route.Active]; !exists {
+		return fmt.Errorf("active group %q is not defined", route.Active)
+	}
+	if route.Canary != "" {
+		if _, exists := route.Groups[route.Canary]; !exists {
+			return fmt.Errorf("canary group %q is not defined", route.Canary)
+		}
+		if route.Canary == route.Active {
+			return fmt.Errorf("the canary group must not be the active group")
+		}
+	}
+	if route.CanaryWeight < 0 || route.CanaryWeight > 100 {
+		return fmt.Errorf("canary_weight must be between 0 and 100")
+	}
+	return nil
+}
+
+// other returns the group to switch to when none is named: the only group
+// besides the active one, or the previously active group
+func (route *BlueGreenRoute) other() (string, bool) {
+	if route.Previous != "" {
+		return route.Previous, true
+	}
+	if len(route.Groups) != 2 {
+		return "", false
+	}
+	for name := range route.Groups {
+		if name != route.Active {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// BlueGreenRoutes holds the blue/green routes by prefix
+type BlueGreenRoutes struct {
+	mu     sync.RWMutex
+	routes map[string]*BlueGreenRoute
+}
+
+// NewBlueGreenRoutes creates an empty set of blue/green routes
+func NewBlueGreenRoutes() *BlueGreenRoutes {
+	return &BlueGreenRoutes{routes: make(map[string]*BlueGreenRoute)}
+}
+
+// LoadBlueGreenRoutes reads a JSON array of blue/green routes
+func LoadBlueGreenRoutes(path string) ([]BlueGreenRoute, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blue/green routes: %w", err)
+	}
+
+	var routes []BlueGreenRoute
+	if err := json.Unmarshal(data, &routes); err != nil {
+		return nil, fmt.Errorf("failed to parse blue/green routes: %w", err)
+	}
+	return routes, nil
+}
+
+// Set adds or replaces the blue/green route for a prefix
+func (bg *BlueGreenRoutes) Set(route BlueGreenRoute) error {
+	if err := route.validate(); err != nil {
+		return err
+	}
+
+	bg.mu.Lock()
+	defer bg.mu.Unlock()
+
+	bg.routes[route.Prefix] = &route
+	return nil
+}
+
+// Remove drops the blue/green route for a prefix and reports whether it existed
+func (bg *BlueGreenRoutes) Remove(prefix string) bool {
+	bg.mu.Lock()
+	defer bg.mu.Unlock()
+
+	_, exists := bg.routes[prefix]
+	delete(bg.routes, prefix)
+	return exists
+}
+
+// Get returns a copy of the blue/green route for a prefix
+func (bg *BlueGreenRoutes) Get(prefix string) (BlueGreenRoute, bool) {
+	bg.mu.RLock()
+	defer bg.mu.RUnlock()
+
+	route, exists := bg.routes[prefix]
+	if !exists {
+		return BlueGreenRoute{}, false
+	}
+	return *route, true
+}
+
+// List returns every blue/green route ordered by prefix
+func (bg *BlueGreenRoutes) List() []BlueGreenRoute {
+	bg.mu.RLock()
+	defer bg.mu.RUnlock()
+
+	routes := make([]BlueGreenRoute, 0, len(bg.routes))
+	for _, route := range bg.routes {
+		routes = append(routes, *route)
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Prefix < routes[j].Prefix })
+	return routes
+}
+
+// selectGroups picks the groups a request for prefix may go to, in order
+// of preference: the canary group for its share of requests, backed by the
+// active group, and otherwise the active group alone
+func (bg *BlueGreenRoutes) selectGroups(prefix string) ([]map[string]bool, bool) {
+	bg.mu.RLock()
+	defer bg.mu.RUnlock()
+
+	route, exists := bg.routes[prefix]
+	if !exists {
+		return nil, false
+	}
+
+	names := []string{route.Active}
+	if route.Canary != "" && rand.IntN(100) < route.CanaryWeight {
+		names = []string{route.Canary, route.Active}
+	}
+
+	groups := make([]map[string]bool, len(names))
+	for i, name := range names {
+		groups[i] = make(map[string]bool, len(route.Groups[name]))
+		for _, server := range route.Groups[name] {
+			groups[i][server] = true
+		}
+	}
+	return groups, true
+}
+
+// setCanary sends weight percent of a prefix's requests to a canary group;
+// an empty group stops the canary
+func (bg *BlueGreenRoutes) setCanary(prefix, group string, weight int) error {
+	bg.mu.Lock()
+	defer bg.mu.Unlock()
+
+	route, exists := bg.routes[prefix]
+	if !exists {
+		return fmt.Errorf("blue/green route %s not found", prefix)
+	}
+
+	updated := *route
+	updated.Canary, updated.CanaryWeight = group, weight
+	if group == "" {
+		updated.CanaryWeight = 0
+	}
+	if err := updated.validate(); err != nil {
+		return err
+	}
+	*route = updated
+	return nil
+}
+
+// errCutoverConflict reports that the active group changed during a cutover
+var errCutoverConflict = errors.New("the active group changed during the cutover")
+
+// cutover switches a prefix from group from to group to, failing if
+// another cutover got there first
+func (bg *BlueGreenRoutes) cutover(prefix, from, to string) error {
+	bg.mu.Lock()
+	defer bg.mu.Unlock()
+
+	route, exists := bg.routes[prefix]
+	if !exists || route.Active != from {
+		return errCutoverConflict
+	}
+
+	now := time.Now()
+	route.Previous, route.Active, route.SwitchedAt = from, to, &now
+	route.Canary, route.CanaryWeight = "", 0
+	return nil
+}
+
+// verifyGroup runs a fresh health check of every server in a group and
+// returns the ones that are not registered, not healthy, or have an open
+// breaker
+func (app *Application) verifyGroup(ctx context.Context, servers []string) []string {
+	var failing []string
+	for _, name := range servers {
+		if server, err := app.Registry.GetServer(name); err != nil || server == nil {
+			failing = append(failing, name)
+			continue
+		}
+
+		// A healthy server that just failed its fresh check has failures
+		// recorded but is not yet marked unhealthy
+		app.HealthMonitor.CheckNow(ctx, name)
+		status, _ := app.HealthMonitor.GetHealthStatus(name)
+		if !status.IsHealthy || status.ConsecutiveFailures > 0 || app.CircuitBreaker.GetBreakerState(name) == Open {
+			failing = append(failing, name)
+		}
+	}
+	return failing
+}
+
+// switchBlueGreen makes group to the active group of a prefix in place of
+// group from and purges the prefix's cached responses, returning how many
+func (app *Application) switchBlueGreen(prefix, from, to string) (int, error) {
+	if err := app.BlueGreen.cutover(prefix, from, to); err != nil {
+		return 0, err
+	}
+
+	purged := app.Cache.PurgePrefix(prefix)
+	if app.Degrader != nil {
+		app.Degrader.stale.PurgePrefix(prefix)
+	}
+	app.Events.Publish(Event{Type: EventCachePurged, Data: map[string]interface{}{"entries": purged, "prefix": prefix}})
+	app.configReloaded("bluegreen", map[string]interface{}{"prefix": prefix, "from": from, "to": to})
+	return purged, nil
+}
+
+// HandleBlueGreen serves GET, PUT and DELETE /admin/bluegreen. GET lists the
+// routes, PUT adds or replaces one and DELETE removes one with ?prefix=.
+func (app *Application) HandleBlueGreen(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]interface{}{"routes": app.BlueGreen.List()})
+
+	case http.MethodPut:
+		var route BlueGreenRoute
+		if err := json.NewDecoder(r.Body).Decode(&route); err != nil {
+			http.Error(w, "invalid payload in request", http.StatusBadRequest)
+			return
+		}
+		route.Previous, route.SwitchedAt = "", nil
+		if err := app.BlueGreen.Set(route); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		app.Logger.InfoContext(r.Context(), "blue/green route set", "prefix", route.Prefix, "active", route.Active)
+		app.configReloaded("bluegreen", map[string]interface{}{"prefix": route.Prefix, "active": route.Active})
+		writeJSON(w, http.StatusOK, route)
+
+	case http.MethodDelete:
+		prefix := r.URL.Query().Get("prefix")
+		if !app.BlueGreen.Remove(prefix) {
+			http.Error(w, "blue/green route not found", http.StatusNotFound)
+			return
+		}
+
+		app.Logger.InfoContext(r.Context(), "blue/green route removed", "prefix", prefix)
+		app.configReloaded("bluegreen", map[string]interface{}{"prefix": prefix})
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "removed", "prefix": prefix})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleBlueGreenCutover serves POST /admin/bluegreen/cutover. It switches
+// {"prefix": "/api", "to": "green"} to the named group, or back to the other
+// group when "to" is left out. Unless "force" is set every server in the
+// target group must pass a fresh health check first. The prefix's cached
+// responses are purged once the switch is made.
+func (app *Application) HandleBlueGreenCutover(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Prefix string `json:"prefix"`
+		To     string `json:"to"`
+		Force  bool   `json:"force"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid payload in request", http.StatusBadRequest)
+		return
+	}
+
+	route, exists := app.BlueGreen.Get(req.Prefix)
+	if !exists {
+		http.Error(w, "blue/green route not found", http.StatusNotFound)
+		return
+	}
+
+	to := req.To
+	if to == "" {
+		var ok bool
+		if to, ok = route.other(); !ok {
+			http.Error(w, "the route has more than two groups, name the group to switch to", http.StatusBadRequest)
+			return
+		}
+	}
+	servers, exists := route.Groups[to]
+	if !exists {
+		http.Error(w, fmt.Sprintf("group %q is not defined", to), http.StatusBadRequest)
+		return
+	}
+	if to == route.Active {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "unchanged", "prefix": route.Prefix, "active": to})
+		return
+	}
+
+	if !req.Force {
+		if failing := app.verifyGroup(r.Context(), servers); len(failing) > 0 {
+			app.Logger.WarnContext(r.Context(), "blue/green cutover refused, target group unhealthy",
+				"prefix", route.Prefix, "to", to, "failing", failing)
+			writeJSON(w, http.StatusConflict, map[string]interface{}{
+				"error":   fmt.Sprintf("group %q is not healthy", to),
+				"failing": failing,
+			})
+			return
+		}
+	}
+
+	purged, err := app.switchBlueGreen(route.Prefix, route.Active, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	app.Logger.InfoContext(r.Context(), "blue/green cutover",
+		"prefix", route.Prefix, "from", route.Active, "to", to, "forced", req.Force, "purged", purged)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "switched",
+		"prefix": route.Prefix,
+		"from":   route.Active,
+		"to":     to,
+		"purged": purged,
+	})
+}
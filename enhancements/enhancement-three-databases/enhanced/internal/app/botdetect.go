@@ -0,0 +1,588 @@
+package app
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Bot actions a route may take on requests classified as likely bots
+const (
+	BotActionAllow     = "allow"
+	BotActionRateLimit = "rate_limit"
+	BotActionChallenge = "challenge"
+	BotActionBlock     = "block"
+)
+
+// Bot verdicts counted per route. Requests classified as bots are counted
+// under the verdict their route's action reached.
+const (
+	// BotVerdictHuman is a request scored below its route's threshold
+	BotVerdictHuman = "human"
+	// BotVerdictAllowed is a bot let through by the allow action, or by the
+	// rate_limit action while under its limit
+	BotVerdictAllowed = "allowed"
+	// BotVerdictRateLimited is a bot over its route's stricter rate limit
+	BotVerdictRateLimited = "rate_limited"
+	// BotVerdictChallenged is a bot served the challenge page
+	BotVerdictChallenged = "challenged"
+	// BotVerdictChallengePassed is a request carrying a valid challenge
+	// cookie, which is let through without scoring
+	BotVerdictChallengePassed = "challenge_passed"
+	// BotVerdictBlocked is a bot rejected by the block action, or a
+	// challenged request that cannot run the challenge, such as a POST
+	BotVerdictBlocked = "blocked"
+)
+
+// Bot detection defaults applied to zero config fields
+const (
+	DefaultBotThreshold     = 0.7
+	DefaultBotRateWindow    = 10 * time.Second
+	DefaultBotRateThreshold = 50
+	DefaultBotChallengeTTL  = time.Hour
+	DefaultBotRPS           = 1
+	DefaultBotBurst         = 5
+)
+
+// BotScoreHeader carries a classified request's bot score to the backend
+const BotScoreHeader = "X-Bot-Score"
+
+// BotChallengeCookie holds the token a client earns by running the challenge
+const BotChallengeCookie = "proxy_bot_challenge"
+
+// BotScorer classifies requests as likely bots. Scorers are consulted in
+// order and their scores added, capped at 1. Register custom scorers with
+// BotDetector.AddScorer.
+type BotScorer interface {
+	Name() string
+	// Score returns how likely the request is to come from a bot, between 0
+	// and 1, and a short reason when it is above 0
+	Score(r *http.Request) (float64, string)
+}
+
+// BotDetectionConfig is the on-disk bot detection file
+type BotDetectionConfig struct {
+	// Threshold is the score at which a request counts as a bot; 0 uses
+	// DefaultBotThreshold. Routes may override it.
+	Threshold float64 `json:"threshold"`
+	// Routes are the route prefixes classified and what they do with bots;
+	// other routes are not scored
+	Routes []BotRouteConfig `json:"routes"`
+	// RateWindow and RateThreshold tune the request rate heuristic: a client
+	// sending more than RateThreshold requests in RateWindow starts scoring,
+	// up to 1 at twice that
+	RateWindow    string `json:"rate_window"`
+	RateThreshold int    `json:"rate_threshold"`
+	// ChallengeSecretEnv names the environment variable holding the key that
+	// signs challenge cookies; without it a random key is used, so cookies
+	// do not survive restarts or carry over between instances
+	ChallengeSecretEnv string `json:"challenge_secret_env"`
+	// ChallengeTTL is how long a passed challenge is honored; empty uses
+	// DefaultBotChallengeTTL
+	ChallengeTTL string `json:"challenge_ttl"`
+}
+
+// BotRouteConfig sets what a route does with requests classified as bots
+type BotRouteConfig struct {
+	Prefix string `json:"prefix"`
+	// Action is allow, rate_limit, challenge or block
+	Action string `json:"action"`
+	// Threshold overrides the detection threshold for the route
+	Threshold float64 `json:"threshold"`
+	// RPS and Burst are the per-client limit the rate_limit action applies
+	// to bots; 0 uses DefaultBotRPS and DefaultBotBurst
+	RPS   float64 `json:"rps"`
+	Burst int     `json:"burst"`
+}
+
+// LoadBotDetection reads a JSON bot detection file
+func LoadBotDetection(path string) (BotDetectionConfig, error) {
+	var cfg BotDetectionConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read bot detection file: %w", err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse bot detection file: %w", err)
+	}
+	return cfg, nil
+}
+
+// botLimiter is a client's stricter limit on a rate_limit route
+type botLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// BotDetector scores requests on its routes with header, cookie and request
+// rate heuristics plus any registered scorers, and applies the route's
+// action to likely bots
+type BotDetector struct {
+	routes       []BotRouteConfig
+	scorers      []BotScorer
+	secret       []byte
+	challengeTTL time.Duration
+	logger       *slog.Logger
+
+	mu       sync.Mutex
+	limiters map[string]*botLimiter
+	// verdicts counts requests per route and verdict
+	verdicts map[string]map[string]uint64
+}
+
+// NewBotDetector validates cfg and creates a detector with the built-in
+// heuristics
+func NewBotDetector(cfg BotDetectionConfig, logger *slog.Logger) (*BotDetector, error) {
+	if len(cfg.Routes) == 0 {
+		return nil, fmt.Errorf("bot detection needs at least one route")
+	}
+	if cfg.Threshold < 0 || cfg.Threshold > 1 {
+		return nil, fmt.Errorf("bot threshold must be between 0 and 1")
+	}
+	if cfg.Threshold == 0 {
+		cfg.Threshold = DefaultBotThreshold
+	}
+
+	routes := make([]BotRouteConfig, 0, len(cfg.Routes))
+	for _, route := range cfg.Routes {
+		if !strings.HasPrefix(route.Prefix, "/") {
+			return nil, fmt.Errorf("bot route %q must start with /", route.Prefix)
+		}
+		switch route.Action {
+		case BotActionAllow, BotActionRateLimit, BotActionChallenge, BotActionBlock:
+		default:
+			return nil, fmt.Errorf("unknown bot action %q for %s", route.Action, route.Prefix)
+		}
+		if route.Threshold < 0 || route.Threshold > 1 || route.RPS < 0 || route.Burst < 0 {
+			return nil, fmt.Errorf("bot threshold, rps and burst for %s are out of range", route.Prefix)
+		}
+		if route.Threshold == 0 {
+			route.Threshold = cfg.Threshold
+		}
+		if route.RPS == 0 {
+			route.RPS = DefaultBotRPS
+		}
+		if route.Burst == 0 {
+			route.Burst = DefaultBotBurst
+		}
+		routes = append(routes, route)
+	}
+
+	window := DefaultBotRateWindow
+	if cfg.RateWindow != "" {
+		d, err := time.ParseDuration(cfg.RateWindow)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid rate_window %q", cfg.RateWindow)
+		}
+		window = d
+	}
+	if cfg.RateThreshold < 0 {
+		return nil, fmt.Errorf("rate_threshold must not be negative")
+	}
+	if cfg.RateThreshold == 0 {
+		cfg.RateThreshold = DefaultBotRateThreshold
+	}
+
+	challengeTTL := DefaultBotChallengeTTL
+	if cfg.ChallengeTTL != "" {
+		d, err := time.ParseDuration(cfg.ChallengeTTL)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid challenge_ttl %q", cfg.ChallengeTTL)
+		}
+		challengeTTL = d
+	}
+
+	var secret []byte
+	if cfg.ChallengeSecretEnv != "" {
+		if secret = []byte(os.Getenv(cfg.ChallengeSecretEnv)); len(secret) == 0 {
+			return nil, fmt.Errorf("challenge secret %s is not set", cfg.ChallengeSecretEnv)
+		}
+	} else {
+		secret = make([]byte, 32)
+		rand.Read(secret)
+	}
+
+	return &BotDetector{
+		routes: routes,
+		scorers: []BotScorer{
+			headerScorer{},
+			cookieScorer{},
+			newRateScorer(window, cfg.RateThreshold),
+		},
+		secret:       secret,
+		challengeTTL: challengeTTL,
+		logger:       logger,
+		limiters:     make(map[string]*botLimiter),
+		verdicts:     make(map[string]map[string]uint64),
+	}, nil
+}
+
+// AddScorer adds a scorer to the built-in heuristics. It must be called
+// before the proxy starts serving.
+func (bd *BotDetector) AddScorer(s BotScorer) {
+	bd.scorers = append(bd.scorers, s)
+	bd.logger.Info("bot scorer registered", "scorer", s.Name())
+}
+
+// route returns the longest bot route prefix matching path, or nil
+func (bd *BotDetector) route(path string) *BotRouteConfig {
+	var match *BotRouteConfig
+	for i := range bd.routes {
+		route := &bd.routes[i]
+		if strings.HasPrefix(path, route.Prefix) && (match == nil || len(route.Prefix) > len(match.Prefix)) {
+			match = route
+		}
+	}
+	return match
+}
+
+// score adds up every scorer's score, capped at 1, with the reasons of the
+// scorers that contributed
+func (bd *BotDetector) score(r *http.Request) (float64, []string) {
+	var (
+		total   float64
+		reasons []string
+	)
+	for _, s := range bd.scorers {
+		score, reason := s.Score(r)
+		if score <= 0 {
+			continue
+		}
+		total += min(score, 1)
+		if reason == "" {
+			reason = s.Name()
+		}
+		reasons = append(reasons, reason)
+	}
+	return min(total, 1), reasons
+}
+
+// count records a verdict for a route
+func (bd *BotDetector) count(prefix, verdict string) {
+	bd.mu.Lock()
+	defer bd.mu.Unlock()
+
+	counts, exists := bd.verdicts[prefix]
+	if !exists {
+		counts = make(map[string]uint64)
+		bd.verdicts[prefix] = counts
+	}
+	counts[verdict]++
+}
+
+// allow applies a rate_limit route's stricter limit to a client
+func (bd *BotDetector) allow(route *BotRouteConfig, client string, now time.Time) bool {
+	bd.mu.Lock()
+	defer bd.mu.Unlock()
+
+	key := client + "|" + route.Prefix
+	l, exists := bd.limiters[key]
+	if !exists {
+		l = &botLimiter{limiter: rate.NewLimiter(rate.Limit(route.RPS), route.Burst)}
+		bd.limiters[key] = l
+	}
+	l.lastSeen = now
+	return l.limiter.AllowN(now, 1)
+}
+
+// challengeToken signs a client's challenge cookie value expiring at expires
+func (bd *BotDetector) challengeToken(client string, expires int64) string {
+	mac := hmac.New(sha256.New, bd.secret)
+	mac.Write([]byte(client + "|" + strconv.FormatInt(expires, 10)))
+	return strconv.FormatInt(expires, 10) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// passedChallenge reports whether the request carries an unexpired challenge
+// cookie issued to its client
+func (bd *BotDetector) passedChallenge(r *http.Request, client string, now time.Time) bool {
+	cookie, err := r.Cookie(BotChallengeCookie)
+	if err != nil {
+		return false
+	}
+	expiresText, _, _ := strings.Cut(cookie.Value, ".")
+	expires, err := strconv.ParseInt(expiresText, 10, 64)
+	if err != nil || now.Unix() >= expires {
+		return false
+	}
+	return hmac.Equal([]byte(cookie.Value), []byte(bd.challengeToken(client, expires)))
+}
+
+// Stats returns request counts per route and verdict
+func (bd *BotDetector) Stats() map[string]map[string]uint64 {
+	bd.mu.Lock()
+	defer bd.mu.Unlock()
+
+	stats := make(map[string]map[string]uint64, len(bd.verdicts))
+	for prefix, counts := range bd.verdicts {
+		copied := make(map[string]uint64, len(counts))
+		for verdict, n := range counts {
+			copied[verdict] = n
+		}
+		stats[prefix] = copied
+	}
+	return stats
+}
+
+// Run forgets the limits of clients idle for a few minutes until ctx is
+// canceled
+func (bd *BotDetector) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			bd.mu.Lock()
+			for key, l := range bd.limiters {
+				if now.Sub(l.lastSeen) > 3*time.Minute {
+					delete(bd.limiters, key)
+				}
+			}
+			bd.mu.Unlock()
+		}
+	}
+}
+
+// SetBotDetector classifies requests on the detector's routes. It must be
+// called before Start.
+func (app *Application) SetBotDetector(bd *BotDetector) {
+	app.Bots = bd
+}
+
+// detectBots scores requests on bot routes and applies the route's action to
+// likely bots. Requests let through carry their score to the backend in
+// X-Bot-Score. It reports whether the request may continue.
+func (app *Application) detectBots(w http.ResponseWriter, r *http.Request) bool {
+	bd := app.Bots
+	if bd == nil {
+		return true
+	}
+	route := bd.route(r.URL.Path)
+	if route == nil {
+		return true
+	}
+
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		client = r.RemoteAddr
+	}
+	now := time.Now()
+
+	if bd.passedChallenge(r, client, now) {
+		bd.count(route.Prefix, BotVerdictChallengePassed)
+		removeCookie(r, BotChallengeCookie)
+		r.Header.Del(BotScoreHeader)
+		return true
+	}
+
+	score, reasons := bd.score(r)
+	r.Header.Set(BotScoreHeader, strconv.FormatFloat(score, 'f', 2, 64))
+	if score < route.Threshold {
+		bd.count(route.Prefix, BotVerdictHuman)
+		return true
+	}
+
+	verdict := BotVerdictAllowed
+	switch route.Action {
+	case BotActionRateLimit:
+		if !bd.allow(route, client, now) {
+			verdict = BotVerdictRateLimited
+		}
+	case BotActionChallenge:
+		verdict = BotVerdictChallenged
+		// The challenge reloads the page, which only replays safe requests
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			verdict = BotVerdictBlocked
+		}
+	case BotActionBlock:
+		verdict = BotVerdictBlocked
+	}
+	bd.count(route.Prefix, verdict)
+
+	log := app.Logger.DebugContext
+	if verdict != BotVerdictAllowed {
+		log = app.Logger.InfoContext
+	}
+	log(r.Context(), "bot detected", "path", r.URL.Path, "client_ip", client, "score", score,
+		"reasons", strings.Join(reasons, ","), "verdict", verdict)
+
+	switch verdict {
+	case BotVerdictRateLimited:
+		app.writeError(w, r, CodeRateLimited, "too many requests")
+		return false
+	case BotVerdictChallenged:
+		bd.writeChallenge(w, client, now)
+		return false
+	case BotVerdictBlocked:
+		app.writeError(w, r, CodeBotBlocked, "automated requests are not allowed on this route")
+		return false
+	}
+	return true
+}
+
+// removeCookie drops one cookie from a request's Cookie header, so the
+// backend and the cache never see it
+func removeCookie(r *http.Request, name string) {
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, cookie := range cookies {
+		if cookie.Name != name {
+			r.AddCookie(cookie)
+		}
+	}
+}
+
+// challengePage sets the challenge cookie from script and reloads, so only
+// clients that run JavaScript and keep cookies get through
+var challengePage = template.Must(template.New("challenge").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Checking your browser</title></head>
+<body>
+<p>Checking your browser before continuing&hellip;</p>
+<noscript><p>Please enable JavaScript and cookies to continue.</p></noscript>
+<script>
+document.cookie = {{.Cookie}};
+location.reload();
+</script>
+</body>
+</html>
+`))
+
+// writeChallenge serves the challenge page with a cookie token for client
+func (bd *BotDetector) writeChallenge(w http.ResponseWriter, client string, now time.Time) {
+	expires := now.Add(bd.challengeTTL)
+	cookie := &http.Cookie{
+		Name:     BotChallengeCookie,
+		Value:    bd.challengeToken(client, expires.Unix()),
+		Path:     "/",
+		MaxAge:   int(bd.challengeTTL.Seconds()),
+		SameSite: http.SameSiteLaxMode,
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusForbidden)
+	challengePage.Execute(w, map[string]string{"Cookie": cookie.String()})
+}
+
+// HandleBotMetrics serves GET /admin/metrics/bots with request counts per
+// bot route and verdict
+func (app *Application) HandleBotMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if app.Bots == nil {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": false})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": true, "routes": app.Bots.Stats()})
+}
+
+// automationAgents are user agent fragments of HTTP libraries, crawlers and
+// headless browsers
+var automationAgents = []string{
+	"bot", "crawler", "spider", "curl", "wget", "python-requests", "python-urllib",
+	"go-http-client", "java/", "okhttp", "libwww-perl", "scrapy", "headlesschrome", "phantomjs",
+}
+
+// headerScorer flags requests missing headers every browser sends, or with
+// the user agent of an automation tool
+type headerScorer struct{}
+
+func (headerScorer) Name() string { return "headers" }
+
+func (headerScorer) Score(r *http.Request) (float64, string) {
+	agent := strings.ToLower(r.Header.Get("User-Agent"))
+	if agent == "" {
+		return 0.6, "no_user_agent"
+	}
+	for _, fragment := range automationAgents {
+		if strings.Contains(agent, fragment) {
+			return 0.7, "automation_user_agent"
+		}
+	}
+
+	var score float64
+	var missing []string
+	for _, header := range []string{"Accept", "Accept-Language", "Accept-Encoding"} {
+		if r.Header.Get(header) == "" {
+			score += 0.2
+			missing = append(missing, strings.ToLower(header))
+		}
+	}
+	if score == 0 {
+		return 0, ""
+	}
+	return score, "missing_" + strings.Join(missing, "_")
+}
+
+// cookieScorer flags requests carrying no cookies at all, which browsers
+// returning to a site rarely do
+type cookieScorer struct{}
+
+func (cookieScorer) Name() string { return "cookies" }
+
+func (cookieScorer) Score(r *http.Request) (float64, string) {
+	if r.Header.Get("Cookie") != "" {
+		return 0, ""
+	}
+	return 0.2, "no_cookies"
+}
+
+// rateScorer flags clients sending more than threshold requests in a window,
+// scoring up to 1 at twice the threshold
+type rateScorer struct {
+	window    time.Duration
+	threshold int
+
+	mu      sync.Mutex
+	started time.Time
+	counts  map[string]int
+}
+
+func newRateScorer(window time.Duration, threshold int) *rateScorer {
+	return &rateScorer{window: window, threshold: threshold, counts: make(map[string]int)}
+}
+
+func (rs *rateScorer) Name() string { return "request_rate" }
+
+func (rs *rateScorer) Score(r *http.Request) (float64, string) {
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		client = r.RemoteAddr
+	}
+
+	rs.mu.Lock()
+	now := time.Now()
+	// Counting in fixed windows keeps the map to the clients of one window
+	if now.Sub(rs.started) >= rs.window {
+		rs.started = now
+		clear(rs.counts)
+	}
+	rs.counts[client]++
+	n := rs.counts[client]
+	rs.mu.Unlock()
+
+	if n <= rs.threshold {
+		return 0, ""
+	}
+	return min(float64(n-rs.threshold)/float64(rs.threshold), 1), "request_rate"
+}
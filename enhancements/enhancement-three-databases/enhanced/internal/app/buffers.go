@@ -0,0 +1,47 @@
+package app
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// maxPooledBufferSize keeps unusually large response buffers out of the pool so
+// one huge body does not pin its memory for the life of the process
+const maxPooledBufferSize = 1 << 20 // 1 MB
+
+// copyBufferSize matches the buffer io.Copy would otherwise allocate per call
+const copyBufferSize = 32 * 1024
+
+var bodyBufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+var copyBufferPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, copyBufferSize)
+		return &buf
+	},
+}
+
+// getBodyBuffer returns an empty buffer from the pool
+func getBodyBuffer() *bytes.Buffer {
+	return bodyBufferPool.Get().(*bytes.Buffer)
+}
+
+// putBodyBuffer returns a buffer to the pool; its contents must no longer be used
+func putBodyBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
+	}
+	buf.Reset()
+	bodyBufferPool.Put(buf)
+}
+
+// copyPooled copies src to dst using a pooled intermediate buffer
+func copyPooled(dst io.Writer, src io.Reader) (int64, error) {
+	buf := copyBufferPool.Get().(*[]byte)
+	defer copyBufferPool.Put(buf)
+
+	return io.CopyBuffer(dst, src, *buf)
+}
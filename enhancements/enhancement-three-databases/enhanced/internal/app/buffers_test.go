@@ -0,0 +1,62 @@
+package app
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// benchmarkBody is a typical JSON response size
+var benchmarkBody = bytes.Repeat([]byte(`{"id":1,"name":"item"},`), 1024)
+
+func BenchmarkBufferBodyPooled(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchmarkBody)))
+	for b.Loop() {
+		buf := getBodyBuffer()
+		buf.ReadFrom(bytes.NewReader(benchmarkBody))
+		putBodyBuffer(buf)
+	}
+}
+
+func BenchmarkBufferBodyReadAll(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchmarkBody)))
+	for b.Loop() {
+		io.ReadAll(bytes.NewReader(benchmarkBody))
+	}
+}
+
+func BenchmarkCopyPooled(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchmarkBody)))
+	for b.Loop() {
+		// Hide WriterTo and ReaderFrom so the copy needs a buffer
+		copyPooled(struct{ io.Writer }{io.Discard}, struct{ io.Reader }{bytes.NewReader(benchmarkBody)})
+	}
+}
+
+func BenchmarkCopyUnpooled(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchmarkBody)))
+	for b.Loop() {
+		io.Copy(struct{ io.Writer }{io.Discard}, struct{ io.Reader }{bytes.NewReader(benchmarkBody)})
+	}
+}
+
+func TestPutBodyBufferDropsLargeBuffers(t *testing.T) {
+	buf := getBodyBuffer()
+	buf.Grow(maxPooledBufferSize + 1)
+	buf.WriteString("x")
+	putBodyBuffer(buf)
+	if buf.Len() != 1 {
+		t.Fatal("oversized buffer was reset and pooled")
+	}
+
+	small := getBodyBuffer()
+	small.WriteString("x")
+	putBodyBuffer(small)
+	if small.Len() != 0 {
+		t.Fatal("pooled buffer was not reset")
+	}
+}
@@ -2,7 +2,9 @@ package app
 
 import (
 	"log/slog"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -26,6 +28,17 @@ type ResponseCache struct {
 	usedBytes int
 	ttl       time.Duration
 	Logger    *slog.Logger
+	clock     Clock
+
+	// writes queues values for the write-behind writer; see StoreAsync
+	writes        chan cacheWrite
+	writerRunning atomic.Bool
+	writerStopped chan struct{}
+	pendingBytes  atomic.Int64
+	writesDropped atomic.Uint64
+	// generation is bumped by every purge, so writes queued before it are
+	// discarded
+	generation atomic.Uint64
 }
 
 // NewResponseCache creates a new LRU cache with TTL and byte capacity
@@ -38,11 +51,30 @@ func NewResponseCache(ttl time.Duration, maxBytes int, logger *slog.Logger) *Res
 		usedBytes: 0,
 		ttl:       ttl,
 		Logger:    logger,
+		clock:     SystemClock,
+
+		writes:        make(chan cacheWrite, CacheWriteQueueSize),
+		writerStopped: make(chan struct{}),
 	}
 }
 
-// Get retrieves a value from the cache and moves it to MRU position
+// Get retrieves a copy of a value from the cache and moves it to MRU position
 func (rc *ResponseCache) Get(key string) ([]byte, bool) {
+	value, found := rc.Lookup(key)
+	if !found {
+		return nil, false
+	}
+
+	// Return a copy of the value to prevent external modification
+	valueCopy := make([]byte, len(value))
+	copy(valueCopy, value)
+	return valueCopy, true
+}
+
+// Lookup retrieves a value without copying it and moves it to MRU position.
+// Stored values are never modified in place, so the returned slice stays
+// valid, but callers must treat it as read-only.
+func (rc *ResponseCache) Lookup(key string) ([]byte, bool) {
 	rc.mu.Lock()
 	defer rc.mu.Unlock()
 
@@ -53,7 +85,7 @@ func (rc *ResponseCache) Get(key string) ([]byte, bool) {
 	}
 
 	// Check if expired
-	if time.Now().After(node.expiresAt) {
+	if rc.clock.Now().After(node.expiresAt) {
 		rc.Logger.Debug("Cache miss", "key", key, "reason", "expired")
 		rc.detachNode(node)
 		delete(rc.items, key)
@@ -64,21 +96,26 @@ func (rc *ResponseCache) Get(key string) ([]byte, bool) {
 	// Move to head (MRU position)
 	rc.moveToHead(node)
 
-	// Return a copy of the value to prevent external modification
-	valueCopy := make([]byte, len(node.value))
-	copy(valueCopy, node.value)
-
 	rc.Logger.Debug("Cache hit", "key", key, "size", node.sizeBytes)
-	return valueCopy, true
+	return node.value, true
 }
 
 // Store adds or updates a value in the cache
 func (rc *ResponseCache) Store(key string, value []byte) {
+	// Copy the value before taking the lock, so the copy never holds up
+	// readers
+	valueCopy := make([]byte, len(value))
+	copy(valueCopy, value)
+	rc.store(key, valueCopy)
+}
+
+// store adds or updates a value the cache takes ownership of
+func (rc *ResponseCache) store(key string, value []byte) {
 	rc.mu.Lock()
 	defer rc.mu.Unlock()
 
 	size := rc.approximateSize(key, value)
-	now := time.Now()
+	now := rc.clock.Now()
 
 	if existingNode, exists := rc.items[key]; exists {
 		// Update existing node
@@ -86,9 +123,7 @@ func (rc *ResponseCache) Store(key string, value []byte) {
 
 		rc.usedBytes -= existingNode.sizeBytes
 
-		// Create a copy of the value
-		existingNode.value = make([]byte, len(value))
-		copy(existingNode.value, value)
+		existingNode.value = value
 		existingNode.sizeBytes = size
 		existingNode.expiresAt = now.Add(rc.ttl)
 
@@ -98,13 +133,9 @@ func (rc *ResponseCache) Store(key string, value []byte) {
 		// Create new node
 		rc.Logger.Debug("Cache store", "key", key, "size", size)
 
-		// Create a copy of the value
-		valueCopy := make([]byte, len(value))
-		copy(valueCopy, value)
-
 		newNode := &Node{
 			key:       key,
-			value:     valueCopy,
+			value:     value,
 			sizeBytes: size,
 			expiresAt: now.Add(rc.ttl),
 		}
@@ -118,14 +149,99 @@ func (rc *ResponseCache) Store(key string, value []byte) {
 	rc.evictToCapacity()
 }
 
+// Delete removes a single entry and reports whether it existed
+func (rc *ResponseCache) Delete(key string) bool {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.generation.Add(1)
+
+	node, exists := rc.items[key]
+	if !exists {
+		return false
+	}
+
+	rc.detachNode(node)
+	delete(rc.items, key)
+	rc.usedBytes -= node.sizeBytes
+
+	rc.Logger.Info("Cache entry purged", "key", key)
+	return true
+}
+
+// PurgePrefix removes the entries for request paths under prefix, GET and
+// POST alike, and returns how many were dropped
+func (rc *ResponseCache) PurgePrefix(prefix string) int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.generation.Add(1)
+
+	purged := 0
+	for key, node := range rc.items {
+		if !strings.HasPrefix(strings.TrimPrefix(key, "POST "), prefix) {
+			continue
+		}
+		rc.detachNode(node)
+		delete(rc.items, key)
+		rc.usedBytes -= node.sizeBytes
+		purged++
+	}
+
+	rc.Logger.Info("Cache purged for prefix", "prefix", prefix, "purged_entries", purged)
+	return purged
+}
+
+// Purge removes every entry from the cache and returns how many were dropped
+func (rc *ResponseCache) Purge() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.generation.Add(1)
+
+	purged := len(rc.items)
+	rc.items = make(map[string]*Node)
+	rc.head = nil
+	rc.tail = nil
+	rc.usedBytes = 0
+
+	rc.Logger.Info("Cache purged", "purged_entries", purged)
+	return purged
+}
+
+// CacheStats reports a response cache's size and limits
+type CacheStats struct {
+	Entries   int    `json:"entries"`
+	UsedBytes int    `json:"used_bytes"`
+	MaxBytes  int    `json:"max_bytes"`
+	TTL       string `json:"ttl"`
+	// QueuedWrites is how many responses wait for the write-behind writer
+	QueuedWrites int `json:"queued_writes"`
+	// DroppedWrites is how many responses were not cached because the
+	// write-behind queue was full
+	DroppedWrites uint64 `json:"dropped_writes"`
+}
+
+// Stats returns the cache's entry count, size and limits
+func (rc *ResponseCache) Stats() CacheStats {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+
+	return CacheStats{
+		Entries:       len(rc.items),
+		UsedBytes:     rc.usedBytes,
+		MaxBytes:      rc.maxBytes,
+		TTL:           rc.ttl.String(),
+		QueuedWrites:  len(rc.writes),
+		DroppedWrites: rc.writesDropped.Load(),
+	}
+}
+
 // Cleanup periodically removes expired entries (for compatibility)
 func (rc *ResponseCache) Cleanup(app *Application, interval time.Duration) {
-	ticker := time.NewTicker(interval)
+	ticker := rc.clock.NewTicker(interval)
 	defer ticker.Stop()
 
 	for {
 		select {
-		case <-ticker.C:
+		case <-ticker.C():
 			rc.cleanupExpired()
 		case <-app.ctx.Done():
 			rc.Logger.Info("Cache cleanup stopped")
@@ -139,7 +255,7 @@ func (rc *ResponseCache) cleanupExpired() {
 	rc.mu.Lock()
 	defer rc.mu.Unlock()
 
-	now := time.Now()
+	now := rc.clock.Now()
 	expiredCount := 0
 
 	// Walk from tail (LRU) towards head, removing expired entries
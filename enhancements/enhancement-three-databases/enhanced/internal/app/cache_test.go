@@ -0,0 +1,79 @@
+package app
+
+import (
+	"fmt"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+// newBenchmarkCache returns a cache holding n entries of benchmarkBody
+func newBenchmarkCache(n int) (*ResponseCache, []string) {
+	rc := NewResponseCache(time.Hour, 1<<30, slog.New(slog.DiscardHandler))
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("GET /api/items/%d", i)
+		rc.Store(keys[i], benchmarkBody)
+	}
+	return rc, keys
+}
+
+func BenchmarkCacheLookup(b *testing.B) {
+	rc, keys := newBenchmarkCache(1000)
+	b.ReportAllocs()
+	i := 0
+	for b.Loop() {
+		if _, ok := rc.Lookup(keys[i%len(keys)]); !ok {
+			b.Fatal("cache miss")
+		}
+		i++
+	}
+}
+
+func BenchmarkCacheGet(b *testing.B) {
+	rc, keys := newBenchmarkCache(1000)
+	b.ReportAllocs()
+	i := 0
+	for b.Loop() {
+		if _, ok := rc.Get(keys[i%len(keys)]); !ok {
+			b.Fatal("cache miss")
+		}
+		i++
+	}
+}
+
+func BenchmarkCacheLookupParallel(b *testing.B) {
+	rc, keys := newBenchmarkCache(1000)
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			rc.Lookup(keys[i%len(keys)])
+			i++
+		}
+	})
+}
+
+func BenchmarkCacheStore(b *testing.B) {
+	rc := NewResponseCache(time.Hour, 1<<30, slog.New(slog.DiscardHandler))
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchmarkBody)))
+	for b.Loop() {
+		rc.Store("GET /api/items", benchmarkBody)
+	}
+}
+
+func TestCacheLookupSharesStoredValue(t *testing.T) {
+	rc, keys := newBenchmarkCache(1)
+
+	looked, _ := rc.Lookup(keys[0])
+	again, _ := rc.Lookup(keys[0])
+	if &looked[0] != &again[0] {
+		t.Fatal("Lookup copied the stored value")
+	}
+
+	got, _ := rc.Get(keys[0])
+	if &got[0] == &looked[0] {
+		t.Fatal("Get returned the stored value instead of a copy")
+	}
+}
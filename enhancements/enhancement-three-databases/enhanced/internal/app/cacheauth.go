@@ -0,0 +1,150 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Cache modes for requests carrying credentials
+const (
+	// CacheAuthSkip neither serves nor stores cached responses for requests
+	// with an Authorization or Cookie header. Routes without a mode use it.
+	CacheAuthSkip = "skip"
+	// CacheAuthShared caches responses for all clients alike, for routes
+	// whose responses do not depend on who is asking
+	CacheAuthShared = "shared"
+	// CacheAuthUser keeps a separate cache entry per user, identified by
+	// the Authorization header and cookies, or by one named cookie with
+	// "user:<cookie>"
+	CacheAuthUser = "user"
+)
+
+// cacheAuthRule is a route's cache mode for credentialed requests
+type cacheAuthRule struct {
+	mode string
+	// cookie identifies users in CacheAuthUser mode instead of every cookie
+	cookie string
+}
+
+// ParseCacheAuthRoutes parses a comma separated list of prefix=mode pairs,
+// for example "/catalog=shared,/me=user,/feed=user:session_id"
+func ParseCacheAuthRoutes(spec string) (map[string]string, error) {
+	routes := make(map[string]string)
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		prefix, mode, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid cache auth route %q, expected prefix=mode", part)
+		}
+		routes[prefix] = mode
+	}
+
+	return routes, nil
+}
+
+// SetCacheAuthRoutes sets how each route prefix caches responses to
+// requests carrying credentials; routes maps each prefix to a CacheAuth mode
+func (app *Application) SetCacheAuthRoutes(routes map[string]string) error {
+	rules := make(map[string]cacheAuthRule, len(routes))
+
+	for prefix, spec := range routes {
+		mode, cookie, _ := strings.Cut(spec, ":")
+		switch {
+		case mode == CacheAuthSkip || mode == CacheAuthShared:
+			if cookie != "" {
+				return fmt.Errorf("cache auth mode %q for %s takes no cookie", mode, prefix)
+			}
+		case mode == CacheAuthUser:
+		default:
+			return fmt.Errorf("unknown cache auth mode %q for %s", spec, prefix)
+		}
+		rules[prefix] = cacheAuthRule{mode: mode, cookie: cookie}
+	}
+
+	app.cacheAuthRoutes = rules
+	return nil
+}
+
+// cacheAuthRule returns the cache mode for a path
+func (app *Application) cacheAuthRule(path string) cacheAuthRule {
+	longest := ""
+	for prefix := range app.cacheAuthRoutes {
+		if strings.HasPrefix(path, prefix) && len(prefix) > len(longest) {
+			longest = prefix
+		}
+	}
+	if longest == "" {
+		return cacheAuthRule{mode: CacheAuthSkip}
+	}
+	return app.cacheAuthRoutes[longest]
+}
+
+// hasCredentials reports whether a request identifies its user
+func hasCredentials(r *http.Request) bool {
+	return r.Header.Get("Authorization") != "" || r.Header.Get("Cookie") != ""
+}
+
+// credentialedCacheKey returns the cache key for a request under its route's
+// cache mode and whether it is a per-user key, or false when the request must
+// not use the cache. Requests without credentials keep key unchanged.
+func (app *Application) credentialedCacheKey(r *http.Request, key string) (string, bool, bool) {
+	if !hasCredentials(r) {
+		return key, false, true
+	}
+
+	rule := app.cacheAuthRule(r.URL.Path)
+	switch rule.mode {
+	case CacheAuthShared:
+		return key, false, true
+
+	case CacheAuthUser:
+		identity := r.Header.Get("Authorization")
+		if rule.cookie == "" {
+			identity += "\n" + r.Header.Get("Cookie")
+		} else if cookie, err := r.Cookie(rule.cookie); err == nil {
+			identity += "\n" + cookie.Value
+		}
+		if identity == "" {
+			// Cookies other than the identifying one do not partition the
+			// cache, so such requests share the anonymous entry
+			return key, false, true
+		}
+
+		sum := sha256.Sum256([]byte(identity))
+		return key + "#user=" + hex.EncodeToString(sum[:16]), true, true
+
+	default:
+		return "", false, false
+	}
+}
+
+// responseStorable reports whether a response may be stored in the cache.
+// Responses setting cookies or marked no-store never are, and private
+// responses only under a per-user key.
+func responseStorable(resp *http.Response, perUser bool) bool {
+	if len(resp.Header.Values("Set-Cookie")) > 0 {
+		return false
+	}
+
+	cacheControl := strings.ToLower(strings.Join(resp.Header.Values("Cache-Control"), ","))
+	for _, directive := range strings.Split(cacheControl, ",") {
+		name, _, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch name {
+		case "no-store":
+			return false
+		case "private":
+			if !perUser {
+				return false
+			}
+		}
+	}
+	return true
+}
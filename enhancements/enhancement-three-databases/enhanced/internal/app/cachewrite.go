@@ -0,0 +1,111 @@
+package app
+
+import "context"
+
+// CacheWriteQueueSize bounds how many responses may wait to be written to a
+// cache
+const CacheWriteQueueSize = 256
+
+// cacheWrite is a response waiting for the write-behind writer. A write with
+// done set carries no value and marks a Flush.
+type cacheWrite struct {
+	key        string
+	value      []byte
+	generation uint64
+	done       chan struct{}
+}
+
+// StoreAsync queues a value to be stored by the cache's writer, so the
+// request that produced it never waits on the cache lock. When the queue is
+// full, or holds as many bytes as the cache itself, the value is dropped and
+// counted instead. Without a running writer it stores synchronously.
+func (rc *ResponseCache) StoreAsync(key string, value []byte) {
+	if !rc.writerRunning.Load() {
+		rc.Store(key, value)
+		return
+	}
+
+	size := int64(len(value))
+	if rc.pendingBytes.Add(size) > int64(rc.maxBytes) {
+		rc.pendingBytes.Add(-size)
+		rc.dropWrite(key)
+		return
+	}
+
+	valueCopy := make([]byte, len(value))
+	copy(valueCopy, value)
+	select {
+	case rc.writes <- cacheWrite{key: key, value: valueCopy, generation: rc.generation.Load()}:
+	default:
+		rc.pendingBytes.Add(-size)
+		rc.dropWrite(key)
+	}
+}
+
+// dropWrite counts a value the write-behind queue had no room for, warning
+// on the first drop and every thousandth after it
+func (rc *ResponseCache) dropWrite(key string) {
+	if dropped := rc.writesDropped.Add(1); dropped%1000 == 1 {
+		rc.Logger.Warn("Cache write queue full, response not cached",
+			"key", key, "dropped_writes", dropped)
+	}
+	rc.Logger.Debug("Cache write dropped", "key", key)
+}
+
+// RunWriter applies queued writes until ctx is done. Writes queued before a
+// purge are discarded, so a purge is never undone by a response fetched
+// before it.
+func (rc *ResponseCache) RunWriter(ctx context.Context) {
+	if !rc.writerRunning.CompareAndSwap(false, true) {
+		return
+	}
+	defer close(rc.writerStopped)
+	defer rc.writerRunning.Store(false)
+
+	for {
+		select {
+		case write := <-rc.writes:
+			if write.done != nil {
+				close(write.done)
+				continue
+			}
+			rc.pendingBytes.Add(-int64(len(write.value)))
+			if write.generation != rc.generation.Load() {
+				rc.Logger.Debug("Cache write discarded after purge", "key", write.key)
+				continue
+			}
+			rc.store(write.key, write.value)
+		case <-ctx.Done():
+			rc.Logger.Info("Cache writer stopped", "queued_writes", len(rc.writes))
+			return
+		}
+	}
+}
+
+// Flush waits until every write queued before it has been applied, or the
+// writer stops
+func (rc *ResponseCache) Flush() {
+	if !rc.writerRunning.Load() {
+		return
+	}
+
+	done := make(chan struct{})
+	select {
+	case rc.writes <- cacheWrite{done: done}:
+	case <-rc.writerStopped:
+		return
+	}
+	select {
+	case <-done:
+	case <-rc.writerStopped:
+	}
+}
+
+// FlushCaches waits for the response cache's queued writes, and the
+// degraded copies', to be applied
+func (app *Application) FlushCaches() {
+	app.Cache.Flush()
+	if app.Degrader != nil {
+		app.Degrader.stale.Flush()
+	}
+}
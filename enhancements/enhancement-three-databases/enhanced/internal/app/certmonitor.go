@@ -0,0 +1,227 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultCertCheckInterval is how often tracked certificates are checked
+const DefaultCertCheckInterval = time.Hour
+
+// Certificate roles
+const (
+	// CertRoleServing is a certificate the proxy presents to clients
+	CertRoleServing = "serving"
+	// CertRoleClientCA is a CA that client certificates are verified against
+	CertRoleClientCA = "client_ca"
+)
+
+// Expiry levels, from furthest to nearest expiry
+const (
+	CertLevelOK       = "ok"
+	CertLevelNotice   = "notice"
+	CertLevelWarning  = "warning"
+	CertLevelCritical = "critical"
+	CertLevelExpired  = "expired"
+)
+
+// certLevels are the thresholds below which a certificate's remaining
+// lifetime escalates, nearest first
+var certLevels = []struct {
+	level  string
+	within time.Duration
+}{
+	{CertLevelCritical, 3 * 24 * time.Hour},
+	{CertLevelWarning, 14 * 24 * time.Hour},
+	{CertLevelNotice, 30 * 24 * time.Hour},
+}
+
+// CertExpiry reports a tracked certificate's remaining lifetime
+type CertExpiry struct {
+	Name     string    `json:"name"`
+	Role     string    `json:"role"`
+	Subject  string    `json:"subject"`
+	NotAfter time.Time `json:"not_after"`
+	// DaysToExpiry is negative once the certificate has expired
+	DaysToExpiry float64 `json:"days_to_expiry"`
+	Level        string  `json:"level"`
+}
+
+// trackedCert is a certificate and the last level logged for it
+type trackedCert struct {
+	role   string
+	cert   *x509.Certificate
+	logged string
+}
+
+// CertMonitor tracks the expiry of the proxy's certificates and logs
+// warnings that escalate as each one approaches expiry, so an expiring
+// certificate is noticed before clients start failing
+type CertMonitor struct {
+	logger *slog.Logger
+
+	mu    sync.Mutex
+	certs map[string]*trackedCert
+}
+
+// NewCertMonitor creates a monitor tracking no certificates
+func NewCertMonitor(logger *slog.Logger) *CertMonitor {
+	return &CertMonitor{logger: logger, certs: make(map[string]*trackedCert)}
+}
+
+// Track starts tracking cert under name, replacing any certificate tracked
+// under it, and checks it at once
+func (cm *CertMonitor) Track(name, role string, cert *x509.Certificate) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	tracked := &trackedCert{role: role, cert: cert}
+	cm.certs[name] = tracked
+	cm.checkCert(name, tracked, time.Now())
+}
+
+// TrackPair tracks the leaf of a loaded certificate and key pair
+func (cm *CertMonitor) TrackPair(name, role string, pair *tls.Certificate) error {
+	leaf := pair.Leaf
+	if leaf == nil {
+		if len(pair.Certificate) == 0 {
+			return fmt.Errorf("tls certificate has no data")
+		}
+		parsed, err := x509.ParseCertificate(pair.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("failed to parse tls certificate: %w", err)
+		}
+		leaf = parsed
+	}
+	cm.Track(name, role, leaf)
+	return nil
+}
+
+// TrackPEM tracks every certificate in a PEM bundle, naming each after name
+// and its position in the bundle
+func (cm *CertMonitor) TrackPEM(name, role string, data []byte) error {
+	for i := 0; ; i++ {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("failed to parse certificate %d of %s: %w", i, name, err)
+		}
+		cm.Track(fmt.Sprintf("%s[%d]", name, i), role, cert)
+	}
+}
+
+// Run checks the tracked certificates every interval until ctx is done
+func (cm *CertMonitor) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			cm.check(now)
+		}
+	}
+}
+
+// certLevel returns how close a certificate is to expiring at now
+func certLevel(cert *x509.Certificate, now time.Time) string {
+	remaining := cert.NotAfter.Sub(now)
+	if remaining <= 0 {
+		return CertLevelExpired
+	}
+	for _, l := range certLevels {
+		if remaining < l.within {
+			return l.level
+		}
+	}
+	return CertLevelOK
+}
+
+// check checks every tracked certificate
+func (cm *CertMonitor) check(now time.Time) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	for name, tracked := range cm.certs {
+		cm.checkCert(name, tracked, now)
+	}
+}
+
+// checkCert logs a certificate whose level escalated since it was last
+// checked. Critical and expired certificates are logged on every check.
+// cm.mu must be held.
+func (cm *CertMonitor) checkCert(name string, tracked *trackedCert, now time.Time) {
+	level := certLevel(tracked.cert, now)
+	if level == tracked.logged && level != CertLevelCritical && level != CertLevelExpired {
+		return
+	}
+	tracked.logged = level
+
+	attrs := []interface{}{"name", name, "role", tracked.role, "subject", tracked.cert.Subject.String(),
+		"not_after", tracked.cert.NotAfter, "days_to_expiry", daysUntil(tracked.cert.NotAfter, now)}
+	switch level {
+	case CertLevelNotice:
+		cm.logger.Info("certificate expires within 30 days", attrs...)
+	case CertLevelWarning:
+		cm.logger.Warn("certificate expires within 14 days", attrs...)
+	case CertLevelCritical:
+		cm.logger.Error("certificate expires within 3 days", attrs...)
+	case CertLevelExpired:
+		cm.logger.Error("certificate has expired", attrs...)
+	}
+}
+
+// daysUntil is the time from now to t in days, rounded to a hundredth
+func daysUntil(t, now time.Time) float64 {
+	return float64(t.Sub(now).Round(864*time.Second)) / float64(24*time.Hour)
+}
+
+// Expiries returns every tracked certificate, soonest to expire first
+func (cm *CertMonitor) Expiries() []CertExpiry {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	now := time.Now()
+	expiries := make([]CertExpiry, 0, len(cm.certs))
+	for name, tracked := range cm.certs {
+		expiries = append(expiries, CertExpiry{
+			Name:         name,
+			Role:         tracked.role,
+			Subject:      tracked.cert.Subject.String(),
+			NotAfter:     tracked.cert.NotAfter,
+			DaysToExpiry: daysUntil(tracked.cert.NotAfter, now),
+			Level:        certLevel(tracked.cert, now),
+		})
+	}
+	sort.Slice(expiries, func(i, j int) bool {
+		return expiries[i].NotAfter.Before(expiries[j].NotAfter)
+	})
+	return expiries
+}
+
+// HandleCertificateMetrics serves GET /admin/metrics/certificates, each
+// tracked certificate's days to expiry
+func (app *Application) HandleCertificateMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"certificates": app.Certificates.Expiries()})
+}
@@ -3,6 +3,7 @@ package app
 import (
 	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -47,61 +48,131 @@ type Breaker struct {
 	InFlight     int          `json:"in_flight"` // Number of requests currently in flight during HalfOpen
 }
 
+// breakerEntry is the live state behind a Breaker. State and failures are
+// atomics so the common Closed-state checks never take a lock; mu serializes
+// state transitions and guards lastOpenTime and inFlight.
+type breakerEntry struct {
+	mu       sync.Mutex
+	state    atomic.Int32
+	failures atomic.Int64
+	// total counts every failure, unlike failures which resets on success
+	total        atomic.Uint64
+	lastOpenTime time.Time
+	inFlight     int
+}
+
+func (b *breakerEntry) loadState() BreakerState {
+	return BreakerState(b.state.Load())
+}
+
+func (b *breakerEntry) setState(state BreakerState) {
+	b.state.Store(int32(state))
+}
+
+// snapshot returns a consistent copy of the breaker's state
+func (b *breakerEntry) snapshot() Breaker {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return Breaker{
+		State:        b.loadState(),
+		Failures:     int(b.failures.Load()),
+		LastOpenTime: b.lastOpenTime,
+		InFlight:     b.inFlight,
+	}
+}
+
 // CircuitBreakerManager manages circuit breakers for all backend servers
 type CircuitBreakerManager struct {
-	breakers map[string]*Breaker
-	mu       sync.RWMutex
+	breakers map[string]*breakerEntry
+	mu       sync.RWMutex // guards the breakers map only
 	logger   *slog.Logger
+	// events receives breaker transitions; nil discards them
+	events *EventBus
+	// observe receives every recorded request outcome; nil discards them
+	observe func(serverName string, success bool)
+	clock   Clock
+}
+
+// transitioned publishes a breaker state change
+func (cbm *CircuitBreakerManager) transitioned(serverName string, from, to BreakerState) {
+	cbm.events.Publish(Event{Type: EventBreakerChanged, Server: serverName, From: from.String(), To: to.String()})
 }
 
 // NewCircuitBreakerManager creates a new circuit breaker manager
 func NewCircuitBreakerManager(logger *slog.Logger) *CircuitBreakerManager {
 	return &CircuitBreakerManager{
-		breakers: make(map[string]*Breaker),
+		breakers: make(map[string]*breakerEntry),
 		logger:   logger,
+		clock:    SystemClock,
 	}
 }
 
-// AllowRequest checks if a request should be allowed through the circuit breaker
-func (cbm *CircuitBreakerManager) AllowRequest(serverName string) bool {
+// lookup returns the breaker for a server, if one exists
+func (cbm *CircuitBreakerManager) lookup(serverName string) (*breakerEntry, bool) {
+	cbm.mu.RLock()
+	defer cbm.mu.RUnlock()
+
+	breaker, exists := cbm.breakers[serverName]
+	return breaker, exists
+}
+
+// getOrCreate returns the breaker for a server, creating a Closed one for
+// unknown servers
+func (cbm *CircuitBreakerManager) getOrCreate(serverName string) *breakerEntry {
+	if breaker, exists := cbm.lookup(serverName); exists {
+		return breaker
+	}
+
 	cbm.mu.Lock()
 	defer cbm.mu.Unlock()
 
 	breaker, exists := cbm.breakers[serverName]
 	if !exists {
-		// Create a new breaker in Closed state for unknown servers
-		breaker = &Breaker{
-			State:    Closed,
-			Failures: 0,
-		}
+		breaker = &breakerEntry{}
 		cbm.breakers[serverName] = breaker
 	}
+	return breaker
+}
 
-	switch breaker.State {
+// AllowRequest checks if a request should be allowed through the circuit breaker
+func (cbm *CircuitBreakerManager) AllowRequest(serverName string) bool {
+	breaker := cbm.getOrCreate(serverName)
+
+	// Fast path: closed breakers allow everything without locking
+	if breaker.loadState() == Closed {
+		return true
+	}
+
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	switch breaker.loadState() {
 	case Closed:
-		// Allow all requests when closed
+		// Closed by a concurrent success or reset
 		return true
 
 	case Open:
 		// Check if we should transition to half-open
-		if time.Since(breaker.LastOpenTime) >= OpenCooldown {
+		if cbm.clock.Since(breaker.lastOpenTime) >= OpenCooldown {
 			cbm.logger.Info("transitioning breaker to half-open",
 				"server", serverName,
-				"cooldown_elapsed", time.Since(breaker.LastOpenTime))
-			breaker.State = HalfOpen
-			breaker.InFlight = 0
+				"cooldown_elapsed", cbm.clock.Since(breaker.lastOpenTime))
+			breaker.setState(HalfOpen)
+			breaker.inFlight = 0
+			cbm.transitioned(serverName, Open, HalfOpen)
 			return true
 		}
 		// Block requests during open state
 		cbm.logger.Debug("breaker open, blocking request",
 			"server", serverName,
-			"time_remaining", OpenCooldown-time.Since(breaker.LastOpenTime))
+			"time_remaining", OpenCooldown-cbm.clock.Since(breaker.lastOpenTime))
 		return false
 
 	case HalfOpen:
 		// Allow only one probe request at a time
-		if breaker.InFlight == 0 {
-			breaker.InFlight++
+		if breaker.inFlight == 0 {
+			breaker.inFlight++
 			cbm.logger.Debug("allowing probe request in half-open state", "server", serverName)
 			return true
 		}
@@ -115,25 +186,35 @@ func (cbm *CircuitBreakerManager) AllowRequest(serverName string) bool {
 
 // OnSuccess records a successful request and potentially closes the breaker
 func (cbm *CircuitBreakerManager) OnSuccess(serverName string) {
-	cbm.mu.Lock()
-	defer cbm.mu.Unlock()
+	if cbm.observe != nil {
+		cbm.observe(serverName, true)
+	}
 
-	breaker, exists := cbm.breakers[serverName]
+	breaker, exists := cbm.lookup(serverName)
 	if !exists {
 		return
 	}
 
+	// Fast path: nothing to reset on a healthy closed breaker
+	if breaker.loadState() == Closed && breaker.failures.Load() == 0 {
+		return
+	}
+
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
 	// Reset failure count on success
-	breaker.Failures = 0
+	breaker.failures.Store(0)
 
 	// Handle state transitions based on current state
-	switch breaker.State {
+	switch breaker.loadState() {
 	case HalfOpen:
 		// Transition back to closed on successful probe
-		breaker.State = Closed
-		breaker.InFlight = 0
+		breaker.setState(Closed)
+		breaker.inFlight = 0
 		cbm.logger.Info("breaker closed after successful probe",
 			"server", serverName)
+		cbm.transitioned(serverName, HalfOpen, Closed)
 
 	case Open:
 		// This shouldn't happen if AllowRequest is working correctly
@@ -147,43 +228,49 @@ func (cbm *CircuitBreakerManager) OnSuccess(serverName string) {
 
 // OnFailure records a failed request and potentially opens the breaker
 func (cbm *CircuitBreakerManager) OnFailure(serverName string) {
-	cbm.mu.Lock()
-	defer cbm.mu.Unlock()
+	cbm.onFailure(serverName, 1)
+}
 
-	breaker, exists := cbm.breakers[serverName]
-	if !exists {
-		breaker = &Breaker{
-			State:    Closed,
-			Failures: 0,
-		}
-		cbm.breakers[serverName] = breaker
+// onFailure records a failed request that counts as weight failures
+// towards opening the breaker
+func (cbm *CircuitBreakerManager) onFailure(serverName string, weight int64) {
+	if cbm.observe != nil {
+		cbm.observe(serverName, false)
 	}
 
-	breaker.Failures++
+	breaker := cbm.getOrCreate(serverName)
+
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
 
-	switch breaker.State {
+	failures := breaker.failures.Add(weight)
+	breaker.total.Add(1)
+
+	switch breaker.loadState() {
 	case HalfOpen:
 		// Failed probe - go back to open
-		breaker.State = Open
-		breaker.LastOpenTime = time.Now()
-		breaker.InFlight = 0
+		breaker.setState(Open)
+		breaker.lastOpenTime = cbm.clock.Now()
+		breaker.inFlight = 0
 		cbm.logger.Warn("probe failed, breaker opened",
 			"server", serverName,
-			"failures", breaker.Failures)
+			"failures", failures)
+		cbm.transitioned(serverName, HalfOpen, Open)
 
 	case Closed:
 		// Check if we should transition to open
-		if breaker.Failures >= FailuresToOpen {
-			breaker.State = Open
-			breaker.LastOpenTime = time.Now()
+		if failures >= FailuresToOpen {
+			breaker.setState(Open)
+			breaker.lastOpenTime = cbm.clock.Now()
 			cbm.logger.Warn("breaker opened due to failures",
 				"server", serverName,
-				"failures", breaker.Failures,
+				"failures", failures,
 				"threshold", FailuresToOpen)
+			cbm.transitioned(serverName, Closed, Open)
 		} else {
 			cbm.logger.Debug("failure recorded",
 				"server", serverName,
-				"failures", breaker.Failures,
+				"failures", failures,
 				"threshold", FailuresToOpen)
 		}
 
@@ -191,53 +278,59 @@ func (cbm *CircuitBreakerManager) OnFailure(serverName string) {
 		// Already open, just log the additional failure
 		cbm.logger.Debug("additional failure on open breaker",
 			"server", serverName,
-			"failures", breaker.Failures)
+			"failures", failures)
 	}
 }
 
 // OnRequestComplete should be called when a request completes in HalfOpen state
 func (cbm *CircuitBreakerManager) OnRequestComplete(serverName string) {
-	cbm.mu.Lock()
-	defer cbm.mu.Unlock()
-
-	breaker, exists := cbm.breakers[serverName]
-	if !exists {
+	breaker, exists := cbm.lookup(serverName)
+	if !exists || breaker.loadState() != HalfOpen {
 		return
 	}
 
-	if breaker.State == HalfOpen && breaker.InFlight > 0 {
-		breaker.InFlight--
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	if breaker.loadState() == HalfOpen && breaker.inFlight > 0 {
+		breaker.inFlight--
 		cbm.logger.Debug("request completed in half-open state",
 			"server", serverName,
-			"in_flight", breaker.InFlight)
+			"in_flight", breaker.inFlight)
 	}
 }
 
 // GetBreakerState returns the current state of a circuit breaker
 func (cbm *CircuitBreakerManager) GetBreakerState(serverName string) BreakerState {
-	cbm.mu.RLock()
-	defer cbm.mu.RUnlock()
-
-	breaker, exists := cbm.breakers[serverName]
+	breaker, exists := cbm.lookup(serverName)
 	if !exists {
 		return Closed // Default state for unknown servers
 	}
 
-	return breaker.State
+	return breaker.loadState()
+}
+
+// Cooldown returns how long an open breaker keeps blocking requests before
+// it lets a probe through, or 0 if it is not open
+func (cbm *CircuitBreakerManager) Cooldown(serverName string) time.Duration {
+	breaker, exists := cbm.lookup(serverName)
+	if !exists || breaker.loadState() != Open {
+		return 0
+	}
+
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	return max(OpenCooldown-cbm.clock.Since(breaker.lastOpenTime), 0)
 }
 
 // GetBreakerInfo returns detailed information about a circuit breaker
 func (cbm *CircuitBreakerManager) GetBreakerInfo(serverName string) (Breaker, bool) {
-	cbm.mu.RLock()
-	defer cbm.mu.RUnlock()
-
-	breaker, exists := cbm.breakers[serverName]
+	breaker, exists := cbm.lookup(serverName)
 	if !exists {
 		return Breaker{}, false
 	}
 
-	// Return a copy to avoid race conditions
-	return *breaker, true
+	return breaker.snapshot(), true
 }
 
 // GetAllBreakers returns the state of all circuit breakers
@@ -247,12 +340,21 @@ func (cbm *CircuitBreakerManager) GetAllBreakers() map[string]Breaker {
 
 	result := make(map[string]Breaker)
 	for name, breaker := range cbm.breakers {
-		result[name] = *breaker
+		result[name] = breaker.snapshot()
 	}
 
 	return result
 }
 
+// FailureCount returns the total failures recorded for a server
+func (cbm *CircuitBreakerManager) FailureCount(serverName string) uint64 {
+	breaker, exists := cbm.lookup(serverName)
+	if !exists {
+		return 0
+	}
+	return breaker.total.Load()
+}
+
 // RemoveBreaker removes a circuit breaker for a server (useful when deregistering)
 func (cbm *CircuitBreakerManager) RemoveBreaker(serverName string) {
 	cbm.mu.Lock()
@@ -264,21 +366,72 @@ func (cbm *CircuitBreakerManager) RemoveBreaker(serverName string) {
 
 // ResetBreaker manually resets a circuit breaker to closed state
 func (cbm *CircuitBreakerManager) ResetBreaker(serverName string) {
-	cbm.mu.Lock()
-	defer cbm.mu.Unlock()
-
-	breaker, exists := cbm.breakers[serverName]
+	breaker, exists := cbm.lookup(serverName)
 	if !exists {
 		return
 	}
 
-	oldState := breaker.State
-	breaker.State = Closed
-	breaker.Failures = 0
-	breaker.InFlight = 0
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	oldState := breaker.loadState()
+	breaker.setState(Closed)
+	breaker.failures.Store(0)
+	breaker.inFlight = 0
 
 	cbm.logger.Info("manually reset circuit breaker",
 		"server", serverName,
 		"old_state", oldState.String(),
-		"new_state", breaker.State.String())
+		"new_state", Closed.String())
+	if oldState != Closed {
+		cbm.transitioned(serverName, oldState, Closed)
+	}
+}
+
+// HalfOpenBreaker moves an open breaker to half-open ahead of its cooldown,
+// so the next request probes the backend. It reports whether the breaker was open.
+func (cbm *CircuitBreakerManager) HalfOpenBreaker(serverName string) bool {
+	breaker, exists := cbm.lookup(serverName)
+	if !exists || breaker.loadState() != Open {
+		return false
+	}
+
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	if breaker.loadState() != Open {
+		return false
+	}
+	breaker.setState(HalfOpen)
+	breaker.inFlight = 0
+
+	cbm.logger.Info("breaker half-opened early after backend recovered",
+		"server", serverName,
+		"cooldown_remaining", OpenCooldown-cbm.clock.Since(breaker.lastOpenTime))
+	cbm.transitioned(serverName, Open, HalfOpen)
+	return true
+}
+
+// OpenBreaker opens a server's breaker because another cluster member
+// opened its own, starting a fresh cooldown. It reports whether the breaker
+// was not already open.
+func (cbm *CircuitBreakerManager) OpenBreaker(serverName, origin string) bool {
+	breaker := cbm.getOrCreate(serverName)
+
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	oldState := breaker.loadState()
+	if oldState == Open {
+		return false
+	}
+	breaker.setState(Open)
+	breaker.lastOpenTime = cbm.clock.Now()
+	breaker.inFlight = 0
+
+	cbm.logger.Warn("breaker opened by cluster member",
+		"server", serverName,
+		"member", origin)
+	cbm.events.Publish(Event{Type: EventBreakerChanged, Server: serverName, From: oldState.String(), To: Open.String(), Data: map[string]interface{}{"origin": origin}})
+	return true
 }
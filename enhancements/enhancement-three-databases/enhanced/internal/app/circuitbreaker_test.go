@@ -0,0 +1,77 @@
+package app
+
+import (
+	"fmt"
+	"log/slog"
+	"testing"
+)
+
+func newBenchmarkBreakers() *CircuitBreakerManager {
+	return NewCircuitBreakerManager(slog.New(slog.DiscardHandler))
+}
+
+func BenchmarkAllowRequestClosedParallel(b *testing.B) {
+	cbm := newBenchmarkBreakers()
+	cbm.AllowRequest("api")
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			cbm.AllowRequest("api")
+		}
+	})
+}
+
+func BenchmarkAllowRequestOpenParallel(b *testing.B) {
+	cbm := newBenchmarkBreakers()
+	cbm.OpenBreaker("api", "benchmark")
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			cbm.AllowRequest("api")
+		}
+	})
+}
+
+func BenchmarkAllowRequestManyBackendsParallel(b *testing.B) {
+	cbm := newBenchmarkBreakers()
+	servers := make([]string, 64)
+	for i := range servers {
+		servers[i] = fmt.Sprintf("server-%d", i)
+		cbm.AllowRequest(servers[i])
+	}
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			cbm.AllowRequest(servers[i%len(servers)])
+			i++
+		}
+	})
+}
+
+func BenchmarkRequestCycleParallel(b *testing.B) {
+	cbm := newBenchmarkBreakers()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if cbm.AllowRequest("api") {
+				cbm.OnSuccess("api")
+			}
+		}
+	})
+}
+
+func TestOpenBreakerBlocksRequests(t *testing.T) {
+	cbm := newBenchmarkBreakers()
+	if !cbm.AllowRequest("api") {
+		t.Fatal("closed breaker blocked a request")
+	}
+	cbm.OpenBreaker("api", "test")
+	if cbm.AllowRequest("api") {
+		t.Fatal("open breaker allowed a request")
+	}
+	cbm.ResetBreaker("api")
+	if !cbm.AllowRequest("api") {
+		t.Fatal("reset breaker blocked a request")
+	}
+}
@@ -0,0 +1,247 @@
+package app
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultStreamClass is the class of requests matching no configured class
+const DefaultStreamClass = "default"
+
+// ClientStreamConfig caps how many requests, HTTP/1.1 connections and HTTP/2
+// streams alike, one client may have in flight at once, so a single
+// misbehaving client cannot monopolize the proxy. Clients are keyed by the
+// policy's rate_limit_key, or the client IP.
+type ClientStreamConfig struct {
+	// MaxStreams caps each client's in-flight requests outside any class; 0
+	// leaves them unlimited
+	MaxStreams int
+	// Classes give groups of routes their own per-client cap; the class with
+	// the longest matching prefix applies
+	Classes []ClientStreamClass
+	// MaxStreamsPerConn caps concurrent HTTP/2 streams on one connection;
+	// 0 keeps the server default
+	MaxStreamsPerConn int
+}
+
+// ClientStreamClass is a route class with its own per-client cap
+type ClientStreamClass struct {
+	Name       string
+	Prefixes   []string
+	MaxStreams int
+}
+
+// ClientStreamClassStats reports a route class's limit and rejections
+type ClientStreamClassStats struct {
+	Class      string `json:"class"`
+	MaxStreams int    `json:"max_streams"`
+	// Clients counts clients with requests in flight, and InFlight those
+	// requests
+	Clients  int    `json:"clients"`
+	InFlight int    `json:"in_flight"`
+	Admitted uint64 `json:"admitted"`
+	Rejected uint64 `json:"rejected"`
+}
+
+// streamClass holds a route class's per-client counts
+type streamClass struct {
+	name       string
+	maxStreams int
+
+	// inFlight counts each client's requests; guarded by the limiter's mu
+	inFlight map[string]int
+	admitted atomic.Uint64
+	rejected atomic.Uint64
+}
+
+// ClientStreamLimiter enforces per-client concurrency caps per route class
+type ClientStreamLimiter struct {
+	cfg      ClientStreamConfig
+	logger   *slog.Logger
+	prefixes map[string]*streamClass
+	fallback *streamClass
+	// classes lists every class for stats, the default class last
+	classes []*streamClass
+
+	mu sync.Mutex
+}
+
+// NewClientStreamLimiter validates cfg and creates a limiter
+func NewClientStreamLimiter(cfg ClientStreamConfig, logger *slog.Logger) (*ClientStreamLimiter, error) {
+	if cfg.MaxStreams < 0 || cfg.MaxStreamsPerConn < 0 {
+		return nil, fmt.Errorf("stream limits must not be negative")
+	}
+
+	sl := &ClientStreamLimiter{
+		cfg:      cfg,
+		logger:   logger,
+		prefixes: make(map[string]*streamClass),
+		fallback: &streamClass{name: DefaultStreamClass, maxStreams: cfg.MaxStreams, inFlight: make(map[string]int)},
+	}
+	for _, c := range cfg.Classes {
+		if c.Name == "" || c.Name == DefaultStreamClass {
+			return nil, fmt.Errorf("stream class needs a name other than %q", DefaultStreamClass)
+		}
+		if c.MaxStreams <= 0 {
+			return nil, fmt.Errorf("stream class %s needs a positive max streams", c.Name)
+		}
+		if len(c.Prefixes) == 0 {
+			return nil, fmt.Errorf("stream class %s needs at least one route prefix", c.Name)
+		}
+		class := &streamClass{name: c.Name, maxStreams: c.MaxStreams, inFlight: make(map[string]int)}
+		for _, prefix := range c.Prefixes {
+			if _, exists := sl.prefixes[prefix]; exists {
+				return nil, fmt.Errorf("route prefix %s is in more than one stream class", prefix)
+			}
+			sl.prefixes[prefix] = class
+		}
+		sl.classes = append(sl.classes, class)
+	}
+	sl.classes = append(sl.classes, sl.fallback)
+	return sl, nil
+}
+
+// ParseStreamClasses parses a comma separated list of name:max=prefixes
+// classes, with prefixes separated by |, for example
+// "uploads:2=/upload|/files,search:10=/search"
+func ParseStreamClasses(spec string) ([]ClientStreamClass, error) {
+	var classes []ClientStreamClass
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		head, prefixes, ok := strings.Cut(part, "=")
+		name, value, ok2 := strings.Cut(head, ":")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("invalid stream class %q, expected name:max=prefixes", part)
+		}
+		max, err := strconv.Atoi(value)
+		if err != nil || max <= 0 {
+			return nil, fmt.Errorf("invalid max streams %q for class %s", value, name)
+		}
+		classes = append(classes, ClientStreamClass{Name: name, Prefixes: strings.Split(prefixes, "|"), MaxStreams: max})
+	}
+
+	return classes, nil
+}
+
+// class returns the class of the longest prefix matching path
+func (sl *ClientStreamLimiter) class(path string) *streamClass {
+	longest := ""
+	for prefix := range sl.prefixes {
+		if strings.HasPrefix(path, prefix) && len(prefix) > len(longest) {
+			longest = prefix
+		}
+	}
+	if longest == "" {
+		return sl.fallback
+	}
+	return sl.prefixes[longest]
+}
+
+// acquire reserves one of client's streams in class
+func (sl *ClientStreamLimiter) acquire(class *streamClass, client string) bool {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	if class.maxStreams > 0 && class.inFlight[client] >= class.maxStreams {
+		class.rejected.Add(1)
+		return false
+	}
+	class.inFlight[client]++
+	class.admitted.Add(1)
+	return true
+}
+
+func (sl *ClientStreamLimiter) release(class *streamClass, client string) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	class.inFlight[client]--
+	if class.inFlight[client] <= 0 {
+		delete(class.inFlight, client)
+	}
+}
+
+// Stats returns each class's limit, in-flight requests and counters
+func (sl *ClientStreamLimiter) Stats() []ClientStreamClassStats {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	stats := make([]ClientStreamClassStats, 0, len(sl.classes))
+	for _, class := range sl.classes {
+		s := ClientStreamClassStats{
+			Class:      class.name,
+			MaxStreams: class.maxStreams,
+			Clients:    len(class.inFlight),
+			Admitted:   class.admitted.Load(),
+			Rejected:   class.rejected.Load(),
+		}
+		for _, n := range class.inFlight {
+			s.InFlight += n
+		}
+		stats = append(stats, s)
+	}
+	return stats
+}
+
+// ConfigureServer applies MaxStreamsPerConn to a server's HTTP/2 settings
+func (sl *ClientStreamLimiter) ConfigureServer(server *http.Server) {
+	if sl.cfg.MaxStreamsPerConn == 0 {
+		return
+	}
+	if server.HTTP2 == nil {
+		server.HTTP2 = &http.HTTP2Config{}
+	}
+	server.HTTP2.MaxConcurrentStreams = sl.cfg.MaxStreamsPerConn
+}
+
+// SetClientStreamLimits caps concurrent requests per client and installs its
+// middleware; it must be called before Handler
+func (app *Application) SetClientStreamLimits(cfg ClientStreamConfig) error {
+	limiter, err := NewClientStreamLimiter(cfg, app.Logger)
+	if err != nil {
+		return err
+	}
+	app.ClientStreams = limiter
+	app.Use(app.LimitClientStreams)
+	return nil
+}
+
+// LimitClientStreams rejects a request with 429 while its client already
+// has its class's cap of requests in flight
+func (app *Application) LimitClientStreams(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sl := app.ClientStreams
+		if strings.HasPrefix(r.URL.Path, "/admin/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		client := app.trafficClient(r, ip)
+		class := sl.class(r.URL.Path)
+
+		if !sl.acquire(class, client) {
+			app.Logger.WarnContext(r.Context(), "request rejected, per-client stream limit reached",
+				"client", client, "class", class.name, "max_streams", class.maxStreams, "path", r.URL.Path)
+			app.writeError(w, r, CodeTooManyStreams, "too many concurrent requests from this client, limit is "+strconv.Itoa(class.maxStreams))
+			return
+		}
+		defer sl.release(class, client)
+
+		next.ServeHTTP(w, r)
+	})
+}
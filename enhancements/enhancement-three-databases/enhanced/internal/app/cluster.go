@@ -0,0 +1,448 @@
+package app
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultGossipInterval is how often members exchange heartbeats
+	DefaultGossipInterval = time.Second
+	// DefaultMemberDeadAfter is how long a silent member is kept
+	DefaultMemberDeadAfter = 10 * time.Second
+	// clusterMaxPacket bounds a gossip datagram
+	clusterMaxPacket = 64 * 1024
+	// clusterRetransmits is how many heartbeats repeat an update, so a
+	// lost datagram does not lose the update
+	clusterRetransmits = 3
+)
+
+// ClusterConfig joins proxy replicas into a cluster that gossips backend
+// failures over UDP
+type ClusterConfig struct {
+	// BindAddr is the UDP address to gossip on, for example ":7946"
+	BindAddr string
+	// AdvertiseAddr is the address other members reach this one at; by
+	// default they use the address its datagrams come from
+	AdvertiseAddr string
+	// Peers are the gossip addresses of some other members to join through
+	Peers []string
+	// NodeName identifies this member; default hostname and pid
+	NodeName string
+	// Secret signs every datagram with HMAC-SHA256; members must share it.
+	// It is required unless Insecure is set.
+	Secret string
+	// Insecure allows gossip without a Secret, so anyone who can reach
+	// BindAddr can mark backends down
+	Insecure bool
+	// GossipInterval is how often heartbeats are sent
+	GossipInterval time.Duration
+	// DeadAfter is how long a member may stay silent before it is dropped
+	DeadAfter time.Duration
+}
+
+// ClusterMember is another proxy instance in the cluster
+type ClusterMember struct {
+	Name     string    `json:"name"`
+	Addr     string    `json:"addr"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// clusterUpdate is a backend failure detected by one member. Seq counts
+// up within one Incarnation, a number picked each time the member starts,
+// so a member restarted under the same name is not mistaken for the old one.
+type clusterUpdate struct {
+	Origin      string    `json:"origin"`
+	Incarnation uint64    `json:"incarnation"`
+	Seq         uint64    `json:"seq"`
+	At          time.Time `json:"at"`
+	Type        EventType `json:"type"`
+	Server      string    `json:"server"`
+}
+
+// clusterMessage is one gossip datagram
+type clusterMessage struct {
+	Node    string          `json:"node"`
+	Addr    string          `json:"addr,omitempty"`
+	Members []ClusterMember `json:"members,omitempty"`
+	Updates []clusterUpdate `json:"updates,omitempty"`
+}
+
+// Cluster gossips backend failures between proxy replicas. When one member
+// marks a backend unhealthy or opens its breaker, the update is sent to
+// every member straight away and repeated in the next few heartbeats; the
+// others mark the backend unhealthy or open their own breaker for it, so
+// they stop routing to it without waiting to rediscover the failure.
+// Recovery is not gossiped: each member confirms it with its own health
+// checks and breaker probes.
+type Cluster struct {
+	cfg         ClusterConfig
+	conn        *net.UDPConn
+	logger      *slog.Logger
+	incarnation uint64
+	seq         atomic.Uint64
+
+	health   *HealthMonitor
+	breakers *CircuitBreakerManager
+
+	mu      sync.Mutex
+	members map[string]*ClusterMember // by gossip address
+	applied map[string]appliedSeq     // latest update applied per origin
+	recent  []clusterUpdate
+}
+
+// appliedSeq is the highest update sequence applied from one incarnation
+// of a member
+type appliedSeq struct {
+	incarnation uint64
+	seq         uint64
+}
+
+// NewCluster binds the gossip socket for cfg, filling in defaults. It
+// refuses to gossip unsigned unless cfg.Insecure is set.
+func NewCluster(cfg ClusterConfig, logger *slog.Logger) (*Cluster, error) {
+	if cfg.Secret == "" && !cfg.Insecure {
+		return nil, errors.New("cluster gossip requires a secret; set Insecure to gossip unsigned")
+	}
+	if cfg.NodeName == "" {
+		host, _ := os.Hostname()
+		cfg.NodeName = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+	if cfg.GossipInterval <= 0 {
+		cfg.GossipInterval = DefaultGossipInterval
+	}
+	if cfg.DeadAfter <= 0 {
+		cfg.DeadAfter = DefaultMemberDeadAfter
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", cfg.BindAddr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cluster bind address: %w", err)
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to bind cluster address: %w", err)
+	}
+
+	var incarnation [8]byte
+	if _, err := rand.Read(incarnation[:]); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to pick cluster incarnation: %w", err)
+	}
+
+	c := &Cluster{
+		cfg:         cfg,
+		conn:        conn,
+		logger:      logger,
+		incarnation: binary.BigEndian.Uint64(incarnation[:]),
+		members:     make(map[string]*ClusterMember),
+		applied:     make(map[string]appliedSeq),
+	}
+	return c, nil
+}
+
+// Addr returns the address the cluster socket is bound to
+func (c *Cluster) Addr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// Run receives and sends gossip until ctx is done
+func (c *Cluster) Run(ctx context.Context) {
+	c.logger.Info("joining cluster", "node", c.cfg.NodeName, "addr", c.conn.LocalAddr(), "peers", c.cfg.Peers)
+
+	go func() {
+		<-ctx.Done()
+		c.conn.Close()
+	}()
+	go c.receive()
+
+	ticker := time.NewTicker(c.cfg.GossipInterval)
+	defer ticker.Stop()
+
+	c.heartbeat(time.Now())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			c.expire(now)
+			c.heartbeat(now)
+		}
+	}
+}
+
+// attach applies gossiped failures to the application's health monitor
+// and breakers and gossips the failures they detect
+func (c *Cluster) attach(app *Application) {
+	c.health = app.HealthMonitor
+	c.breakers = app.CircuitBreaker
+	app.Events.Subscribe("cluster", c.localEvent, EventHealthChanged, EventBreakerChanged)
+}
+
+// localEvent gossips a backend failure detected by this member. Events
+// caused by gossip carry an origin and are not sent on again.
+func (c *Cluster) localEvent(e Event) {
+	if _, remote := e.Data["origin"]; remote {
+		return
+	}
+	switch {
+	case e.Type == EventHealthChanged && e.To == "unhealthy":
+	case e.Type == EventBreakerChanged && e.To == Open.String():
+	default:
+		return
+	}
+
+	u := clusterUpdate{
+		Origin:      c.cfg.NodeName,
+		Incarnation: c.incarnation,
+		Seq:         c.seq.Add(1),
+		At:          e.Time,
+		Type:        e.Type,
+		Server:      e.Server,
+	}
+
+	c.mu.Lock()
+	c.recent = append(c.recent, u)
+	targets := c.targetsLocked()
+	c.mu.Unlock()
+
+	c.send(targets, clusterMessage{Node: c.cfg.NodeName, Addr: c.cfg.AdvertiseAddr, Updates: []clusterUpdate{u}})
+}
+
+// heartbeat sends the member list and recent updates to every member and
+// seed peer
+func (c *Cluster) heartbeat(now time.Time) {
+	c.mu.Lock()
+	msg := clusterMessage{Node: c.cfg.NodeName, Addr: c.cfg.AdvertiseAddr}
+	for _, m := range c.members {
+		if now.Sub(m.LastSeen) < c.cfg.DeadAfter {
+			msg.Members = append(msg.Members, ClusterMember{Name: m.Name, Addr: m.Addr})
+		}
+	}
+
+	// Keep updates for a few heartbeats only, so members joining later do
+	// not act on stale failures
+	cutoff := now.Add(-clusterRetransmits * c.cfg.GossipInterval)
+	kept := c.recent[:0]
+	for _, u := range c.recent {
+		if u.At.After(cutoff) {
+			kept = append(kept, u)
+		}
+	}
+	c.recent = kept
+	msg.Updates = append(msg.Updates, kept...)
+
+	targets := c.targetsLocked()
+	c.mu.Unlock()
+
+	c.send(targets, msg)
+}
+
+// targetsLocked returns every member and seed peer address
+func (c *Cluster) targetsLocked() []string {
+	seen := make(map[string]bool, len(c.members)+len(c.cfg.Peers))
+	targets := make([]string, 0, len(seen))
+	for _, addr := range c.cfg.Peers {
+		if !seen[addr] {
+			seen[addr] = true
+			targets = append(targets, addr)
+		}
+	}
+	for addr := range c.members {
+		if !seen[addr] {
+			seen[addr] = true
+			targets = append(targets, addr)
+		}
+	}
+	return targets
+}
+
+// expire drops members that have been silent for DeadAfter
+func (c *Cluster) expire(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for addr, m := range c.members {
+		if now.Sub(m.LastSeen) >= c.cfg.DeadAfter {
+			delete(c.members, addr)
+			c.logger.Warn("cluster member left", "member", m.Name, "addr", addr)
+		}
+	}
+}
+
+func (c *Cluster) send(targets []string, msg clusterMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		c.logger.Error("failed to encode gossip", "error", err)
+		return
+	}
+	packet := c.sign(data)
+
+	for _, target := range targets {
+		addr, err := net.ResolveUDPAddr("udp", target)
+		if err != nil {
+			c.logger.Debug("failed to resolve cluster member", "addr", target, "error", err)
+			continue
+		}
+		if _, err := c.conn.WriteToUDP(packet, addr); err != nil {
+			c.logger.Debug("failed to send gossip", "addr", target, "error", err)
+		}
+	}
+}
+
+// sign prefixes data with its HMAC unless the cluster is insecure
+func (c *Cluster) sign(data []byte) []byte {
+	if c.cfg.Secret == "" {
+		return data
+	}
+	mac := hmac.New(sha256.New, []byte(c.cfg.Secret))
+	mac.Write(data)
+	return append(mac.Sum(nil), data...)
+}
+
+// verify strips and checks the HMAC prefix unless the cluster is insecure
+func (c *Cluster) verify(packet []byte) ([]byte, bool) {
+	if c.cfg.Secret == "" {
+		return packet, true
+	}
+	if len(packet) < sha256.Size {
+		return nil, false
+	}
+	sum, data := packet[:sha256.Size], packet[sha256.Size:]
+	mac := hmac.New(sha256.New, []byte(c.cfg.Secret))
+	mac.Write(data)
+	return data, hmac.Equal(sum, mac.Sum(nil))
+}
+
+func (c *Cluster) receive() {
+	buf := make([]byte, clusterMaxPacket)
+	for {
+		n, from, err := c.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			c.logger.Debug("failed to read gossip", "error", err)
+			continue
+		}
+
+		data, ok := c.verify(buf[:n])
+		if !ok {
+			c.logger.Warn("dropping gossip with a bad signature", "from", from)
+			continue
+		}
+
+		var msg clusterMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			c.logger.Warn("dropping malformed gossip", "from", from, "error", err)
+			continue
+		}
+		if msg.Node == c.cfg.NodeName {
+			continue
+		}
+		c.handle(msg, from)
+	}
+}
+
+func (c *Cluster) handle(msg clusterMessage, from *net.UDPAddr) {
+	addr := msg.Addr
+	if addr == "" {
+		addr = from.String()
+	}
+
+	c.mu.Lock()
+	member, exists := c.members[addr]
+	if !exists {
+		member = &ClusterMember{Addr: addr}
+		c.members[addr] = member
+		c.logger.Info("cluster member joined", "member", msg.Node, "addr", addr)
+	}
+	member.Name = msg.Node
+	member.LastSeen = time.Now()
+
+	// Learn about members through others. Only unknown ones are added, so a
+	// dead member that others still list is dropped after DeadAfter.
+	for _, other := range msg.Members {
+		if _, known := c.members[other.Addr]; !known && other.Name != c.cfg.NodeName {
+			c.members[other.Addr] = &ClusterMember{Name: other.Name, Addr: other.Addr, LastSeen: time.Now()}
+		}
+	}
+
+	// A new incarnation of an origin restarts its sequence
+	var updates []clusterUpdate
+	for _, u := range msg.Updates {
+		last, seen := c.applied[u.Origin]
+		if u.Origin == c.cfg.NodeName || seen && u.Incarnation == last.incarnation && u.Seq <= last.seq {
+			continue
+		}
+		c.applied[u.Origin] = appliedSeq{incarnation: u.Incarnation, seq: u.Seq}
+		updates = append(updates, u)
+	}
+	c.mu.Unlock()
+
+	for _, u := range updates {
+		c.apply(u)
+	}
+}
+
+// apply acts on a failure gossiped by another member
+func (c *Cluster) apply(u clusterUpdate) {
+	switch u.Type {
+	case EventHealthChanged:
+		if c.health != nil {
+			c.health.markUnhealthyRemote(u.Server, u.Origin)
+		}
+	case EventBreakerChanged:
+		if c.breakers != nil {
+			c.breakers.OpenBreaker(u.Server, u.Origin)
+		}
+	}
+}
+
+// Members returns the live members other than this one
+func (c *Cluster) Members() []ClusterMember {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	members := make([]ClusterMember, 0, len(c.members))
+	for _, m := range c.members {
+		members = append(members, *m)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Addr < members[j].Addr })
+	return members
+}
+
+// HandleCluster serves GET /admin/cluster
+func (app *Application) HandleCluster(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	c := app.Cluster
+	if c == nil {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": false})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"enabled": true,
+		"node":    c.cfg.NodeName,
+		"addr":    c.conn.LocalAddr().String(),
+		"members": c.Members(),
+	})
+}
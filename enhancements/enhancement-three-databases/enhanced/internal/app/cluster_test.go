@@ -0,0 +1,85 @@
+package app
+
+import (
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+)
+
+// newTestCluster binds a cluster member to a loopback port
+func newTestCluster(t *testing.T, name string) *Cluster {
+	t.Helper()
+
+	c, err := NewCluster(ClusterConfig{BindAddr: "127.0.0.1:0", NodeName: name, Secret: "s3cret"}, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.conn.Close() })
+	return c
+}
+
+func TestNewClusterRequiresSecret(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	if _, err := NewCluster(ClusterConfig{BindAddr: "127.0.0.1:0"}, logger); err == nil {
+		t.Fatal("cluster without a secret started")
+	}
+
+	c, err := NewCluster(ClusterConfig{BindAddr: "127.0.0.1:0", Insecure: true}, logger)
+	if err != nil {
+		t.Fatalf("insecure cluster failed to start: %v", err)
+	}
+	c.conn.Close()
+}
+
+func TestClusterVerifiesSignature(t *testing.T) {
+	c := newTestCluster(t, "a")
+
+	if data, ok := c.verify(c.sign([]byte("hello"))); !ok || string(data) != "hello" {
+		t.Fatalf("own signature rejected")
+	}
+	if _, ok := c.verify([]byte(`{"node":"forged"}`)); ok {
+		t.Fatal("unsigned datagram accepted")
+	}
+}
+
+func TestClusterAppliesUpdatesAfterRestart(t *testing.T) {
+	receiver := newTestCluster(t, "receiver")
+	from := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 7946}
+
+	var applied []string
+	deliver := func(u clusterUpdate) {
+		receiver.mu.Lock()
+		last := receiver.applied[u.Origin]
+		receiver.mu.Unlock()
+		receiver.handle(clusterMessage{Node: u.Origin, Updates: []clusterUpdate{u}}, from)
+		receiver.mu.Lock()
+		if receiver.applied[u.Origin] != last {
+			applied = append(applied, u.Server)
+		}
+		receiver.mu.Unlock()
+	}
+
+	first := newTestCluster(t, "node-b")
+	update := func(c *Cluster, server string) clusterUpdate {
+		return clusterUpdate{Origin: "node-b", Incarnation: c.incarnation, Seq: c.seq.Add(1), At: time.Now(), Type: EventHealthChanged, Server: server}
+	}
+	u1 := update(first, "one")
+	u2 := update(first, "two")
+	deliver(u1)
+	deliver(u2)
+	deliver(u1)
+
+	restarted := newTestCluster(t, "node-b")
+	deliver(update(restarted, "three"))
+
+	want := []string{"one", "two", "three"}
+	if len(applied) != len(want) {
+		t.Fatalf("applied %v, want %v", applied, want)
+	}
+	for i := range want {
+		if applied[i] != want[i] {
+			t.Fatalf("applied %v, want %v", applied, want)
+		}
+	}
+}
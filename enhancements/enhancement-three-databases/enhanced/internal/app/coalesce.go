@@ -0,0 +1,270 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// MaxCoalescedBodyBytes bounds the response body buffered to share among
+// coalesced requests; a larger response goes to the leading request alone
+const MaxCoalescedBodyBytes = 10 << 20
+
+// errNotCoalesced tells a waiting request that the shared response was a
+// stream or too large to buffer, so it must go upstream itself
+var errNotCoalesced = errors.New("response cannot be shared")
+
+// Coalescer deduplicates identical in-flight GET requests to a backend: the
+// first request for a URL goes upstream and later ones wait for and share its
+// response instead of hitting the backend again
+type Coalescer struct {
+	mu       sync.Mutex
+	inflight map[string]*coalescedCall
+
+	leaders   atomic.Int64
+	followers atomic.Int64
+}
+
+// coalescedCall is an upstream GET shared by every waiting request
+type coalescedCall struct {
+	done   chan struct{}
+	status int
+	header http.Header
+	body   []byte
+	err    error
+
+	// live is a response that cannot be shared, kept for the leading
+	// request; whoever of the fetch and the leader finishes last closes it
+	// if the leader gave up
+	mu         sync.Mutex
+	live       *http.Response
+	leaderGone bool
+}
+
+// CoalescerStats reports how many requests went upstream and how many shared
+// another request's response
+type CoalescerStats struct {
+	Upstream  int64 `json:"upstream"`
+	Coalesced int64 `json:"coalesced"`
+}
+
+// NewCoalescer creates an empty coalescer
+func NewCoalescer() *Coalescer {
+	return &Coalescer{inflight: make(map[string]*coalescedCall)}
+}
+
+// Do runs fetch once per key among concurrent callers. Every caller gets its
+// own copy of the response; shared is true for callers that joined another
+// request's fetch. The fetch runs in its own goroutine so each caller can stop
+// waiting when its ctx is done without failing the others. A streamed or
+// oversized response is not buffered: the leading caller gets it as it
+// arrives, closed when its ctx is done, and the others get errNotCoalesced.
+func (c *Coalescer) Do(ctx context.Context, key string, fetch func() (*http.Response, error)) (resp *http.Response, shared bool, err error) {
+	c.mu.Lock()
+	call, shared := c.inflight[key]
+	if !shared {
+		call = &coalescedCall{done: make(chan struct{})}
+		c.inflight[key] = call
+		c.leaders.Add(1)
+
+		go func() {
+			call.fill(fetch())
+
+			c.mu.Lock()
+			delete(c.inflight, key)
+			c.mu.Unlock()
+			close(call.done)
+		}()
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-call.done:
+		if !shared {
+			if live := call.claim(); live != nil {
+				return leaderResponse(ctx, live), false, nil
+			}
+		}
+		resp, err := call.response()
+		if shared && !errors.Is(err, errNotCoalesced) {
+			c.followers.Add(1)
+		}
+		return resp, shared, err
+	case <-ctx.Done():
+		if shared {
+			c.followers.Add(1)
+		} else {
+			call.abandon()
+		}
+		return nil, shared, ctx.Err()
+	}
+}
+
+// claim hands the live response to the leader, or nil when there is none
+func (call *coalescedCall) claim() *http.Response {
+	call.mu.Lock()
+	defer call.mu.Unlock()
+
+	live := call.live
+	call.live = nil
+	return live
+}
+
+// abandon records that the leader stopped waiting, closing the live
+// response if it already arrived
+func (call *coalescedCall) abandon() {
+	call.mu.Lock()
+	defer call.mu.Unlock()
+
+	call.leaderGone = true
+	if call.live != nil {
+		call.live.Body.Close()
+		call.live = nil
+	}
+}
+
+// leaderResponse ties a live response fetched on a detached context to the
+// leader's ctx, closing its body when ctx is done
+func leaderResponse(ctx context.Context, resp *http.Response) *http.Response {
+	body := resp.Body
+	stop := context.AfterFunc(ctx, func() { body.Close() })
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{body, closerFunc(func() error {
+		stop()
+		return body.Close()
+	})}
+	return resp
+}
+
+// closerFunc adapts a function to io.Closer
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
+
+// Stats returns the coalescer's counters
+func (c *Coalescer) Stats() CoalescerStats {
+	return CoalescerStats{
+		Upstream:  c.leaders.Load(),
+		Coalesced: c.followers.Load(),
+	}
+}
+
+// fill buffers the upstream response so it can be replayed to every waiter.
+// A stream, or a body over MaxCoalescedBodyBytes, is kept live for the
+// leader instead, with what was read of it put back in front.
+func (call *coalescedCall) fill(resp *http.Response, err error) {
+	if err != nil {
+		call.err = err
+		return
+	}
+
+	if isStreamingResponse(resp) {
+		call.keepLive(resp)
+		return
+	}
+
+	var buf bytes.Buffer
+	n, err := buf.ReadFrom(io.LimitReader(resp.Body, MaxCoalescedBodyBytes+1))
+	if err != nil {
+		resp.Body.Close()
+		call.err = err
+		return
+	}
+	if n > MaxCoalescedBodyBytes {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buf.Bytes()), resp.Body), resp.Body}
+		call.keepLive(resp)
+		return
+	}
+	resp.Body.Close()
+
+	call.status = resp.StatusCode
+	call.header = resp.Header
+	call.body = buf.Bytes()
+}
+
+// keepLive holds a response that cannot be shared for the leader, closing
+// it at once if the leader already stopped waiting
+func (call *coalescedCall) keepLive(resp *http.Response) {
+	call.err = errNotCoalesced
+
+	call.mu.Lock()
+	defer call.mu.Unlock()
+
+	if call.leaderGone {
+		resp.Body.Close()
+		return
+	}
+	call.live = resp
+}
+
+// response builds an independent copy of the shared response
+func (call *coalescedCall) response() (*http.Response, error) {
+	if call.err != nil {
+		return nil, call.err
+	}
+
+	return &http.Response{
+		StatusCode:    call.status,
+		Header:        call.header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(call.body)),
+		ContentLength: int64(len(call.body)),
+	}, nil
+}
+
+// coalescable reports whether a GET may share a response with other clients.
+// Credentialed requests are never coalesced so one client's response is not
+// served to another, range requests are not since each wants its own slice,
+// and requests for event streams are not since they must be relayed as they
+// arrive rather than buffered. Streams a request did not ask for are caught
+// by Do once their headers arrive.
+func coalescable(r *http.Request) bool {
+	return r.Header.Get("Authorization") == "" &&
+		r.Header.Get("Cookie") == "" &&
+		r.Header.Get("Range") == "" &&
+		!strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// forwardGet sends a GET upstream, coalescing it with identical in-flight
+// requests when enabled. The shared upstream call is detached from the leading
+// client's context so its disconnect does not fail the waiters.
+func (app *Application) forwardGet(backend *BackendInfo, r *http.Request) (*http.Response, bool, error) {
+	if app.Coalescer == nil || !coalescable(r) || !app.Reliability.Enabled(FeatureCoalescing) {
+		resp, err := app.performRequest(backend.Server.Name, http.MethodGet, backend.TargetURL, r, nil)
+		return resp, false, err
+	}
+
+	detached := r.WithContext(context.WithoutCancel(r.Context()))
+	// Requests asking for different encodings get different responses
+	key := backend.TargetURL + "\n" + r.Header.Get("Accept-Encoding")
+	resp, shared, err := app.Coalescer.Do(r.Context(), key, func() (*http.Response, error) {
+		return app.performRequest(backend.Server.Name, http.MethodGet, backend.TargetURL, detached, nil)
+	})
+	if errors.Is(err, errNotCoalesced) {
+		app.Logger.DebugContext(r.Context(), "in-flight response cannot be shared, sending own request", "url", backend.TargetURL)
+		resp, err := app.performRequest(backend.Server.Name, http.MethodGet, backend.TargetURL, r, nil)
+		return resp, false, err
+	}
+	if shared {
+		app.Logger.DebugContext(r.Context(), "GET coalesced with in-flight request", "url", backend.TargetURL)
+		coalescing := app.Reliability.feature(FeatureCoalescing)
+		coalescing.activations.Add(1)
+		coalescing.saved.Add(1)
+		switch {
+		case err == nil:
+			coalescing.wins.Add(1)
+		case r.Context().Err() != nil:
+			coalescing.cancellations.Add(1)
+		}
+	}
+	return resp, shared, err
+}
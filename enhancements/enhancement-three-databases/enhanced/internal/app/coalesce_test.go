@@ -0,0 +1,148 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// coalesceResponse runs one leader and followers for key against fetch,
+// holding fetch until every follower has joined
+func coalesceResponse(t *testing.T, followers int, build func() *http.Response) (leader *http.Response, errs []error) {
+	t.Helper()
+
+	c := NewCoalescer()
+	release := make(chan struct{})
+	fetch := func() (*http.Response, error) {
+		<-release
+		return build(), nil
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	leaderDone := make(chan struct{})
+	go func() {
+		defer close(leaderDone)
+		resp, shared, err := c.Do(context.Background(), "k", fetch)
+		if err != nil || shared {
+			t.Errorf("leader: shared %v, err %v", shared, err)
+			return
+		}
+		leader = resp
+	}()
+	for !c.inflightKey("k") {
+		runtime.Gosched()
+	}
+
+	for i := 0; i < followers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, shared, err := c.Do(context.Background(), "k", fetch)
+			if !shared {
+				t.Error("follower led the call")
+			}
+			if resp != nil {
+				resp.Body.Close()
+			}
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+		}()
+	}
+	// give the followers time to join before the fetch returns
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	<-leaderDone
+	return leader, errs
+}
+
+// inflightKey reports whether a fetch for key is in progress
+func (c *Coalescer) inflightKey(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.inflight[key]
+	return ok
+}
+
+func TestCoalescerSharesBufferedResponse(t *testing.T) {
+	leader, errs := coalesceResponse(t, 3, func() *http.Response {
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("hello"))}
+	})
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("follower err %v, want the shared response", err)
+		}
+	}
+	body, _ := io.ReadAll(leader.Body)
+	if string(body) != "hello" {
+		t.Fatalf("leader body %q", body)
+	}
+}
+
+func TestCoalescerHandsStreamToLeaderOnly(t *testing.T) {
+	pr, pw := io.Pipe()
+	leader, errs := coalesceResponse(t, 3, func() *http.Response {
+		header := http.Header{"Content-Type": []string{"text/event-stream"}}
+		return &http.Response{StatusCode: http.StatusOK, Header: header, Body: pr}
+	})
+	for _, err := range errs {
+		if !errors.Is(err, errNotCoalesced) {
+			t.Fatalf("follower err %v, want errNotCoalesced", err)
+		}
+	}
+
+	go func() {
+		pw.Write([]byte("data: one\n\n"))
+		pw.Close()
+	}()
+	body, err := io.ReadAll(leader.Body)
+	if err != nil || string(body) != "data: one\n\n" {
+		t.Fatalf("leader read %q, err %v", body, err)
+	}
+	leader.Body.Close()
+}
+
+func TestCoalescerHandsOversizedBodyToLeaderOnly(t *testing.T) {
+	want := bytes.Repeat([]byte("x"), MaxCoalescedBodyBytes+10)
+	leader, errs := coalesceResponse(t, 2, func() *http.Response {
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(bytes.NewReader(want))}
+	})
+	for _, err := range errs {
+		if !errors.Is(err, errNotCoalesced) {
+			t.Fatalf("follower err %v, want errNotCoalesced", err)
+		}
+	}
+	body, _ := io.ReadAll(leader.Body)
+	if !bytes.Equal(body, want) {
+		t.Fatalf("leader read %d bytes, want %d", len(body), len(want))
+	}
+}
+
+func TestCoalescerClosesStreamWhenLeaderLeaves(t *testing.T) {
+	c := NewCoalescer()
+	ctx, cancel := context.WithCancel(context.Background())
+	pr, _ := io.Pipe()
+
+	resp, _, err := c.Do(ctx, "k", func() (*http.Response, error) {
+		header := http.Header{"Content-Type": []string{"text/event-stream"}}
+		return &http.Response{StatusCode: http.StatusOK, Header: header, Body: pr}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	cancel()
+	if _, err := resp.Body.Read(make([]byte, 1)); !errors.Is(err, io.ErrClosedPipe) {
+		t.Fatalf("read after leader left returned %v, want the stream closed", err)
+	}
+}
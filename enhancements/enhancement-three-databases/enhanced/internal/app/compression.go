@@ -0,0 +1,216 @@
+package app
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Request body encodings
+const (
+	EncodingIdentity = "identity"
+	EncodingGzip     = "gzip"
+	EncodingDeflate  = "deflate"
+)
+
+const (
+	// DefaultCompressMinSize is the smallest request body compressed toward
+	// a backend
+	DefaultCompressMinSize = 1024
+	// DefaultDecompressMaxSize bounds a decompressed request body
+	DefaultDecompressMaxSize = 10 << 20
+)
+
+var (
+	// errBodyTooLarge reports a request body that decompresses past its limit
+	errBodyTooLarge = errors.New("decompressed request body exceeds the limit")
+	// errUnsupportedEncoding reports a request body encoding the proxy cannot decode
+	errUnsupportedEncoding = errors.New("unsupported request body encoding")
+)
+
+// RequestCompressionRoute sets the request body encoding a route's backends
+// accept. Bodies clients send gzip or deflate encoded are decompressed at the
+// proxy, bounded by MaxDecompressedSize, then re-encoded as Encoding.
+type RequestCompressionRoute struct {
+	Prefix string `json:"prefix"`
+	// Encoding is "identity" to forward bodies uncompressed, or "gzip" or
+	// "deflate" to compress bodies of at least MinSize bytes
+	Encoding string `json:"encoding"`
+	// MinSize defaults to DefaultCompressMinSize
+	MinSize int `json:"min_size,omitempty"`
+	// MaxDecompressedSize defaults to DefaultDecompressMaxSize; larger
+	// bodies are rejected with 413
+	MaxDecompressedSize int64 `json:"max_decompressed_size,omitempty"`
+}
+
+// LoadRequestCompression reads a JSON array of request compression routes
+func LoadRequestCompression(path string) ([]RequestCompressionRoute, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request compression routes: %w", err)
+	}
+
+	var routes []RequestCompressionRoute
+	if err := json.Unmarshal(data, &routes); err != nil {
+		return nil, fmt.Errorf("failed to parse request compression routes: %w", err)
+	}
+	return routes, nil
+}
+
+// SetRequestCompression sets the request body encoding per route prefix
+func (app *Application) SetRequestCompression(routes []RequestCompressionRoute) error {
+	compression := make(map[string]RequestCompressionRoute, len(routes))
+
+	for _, route := range routes {
+		switch route.Encoding {
+		case EncodingIdentity, EncodingGzip, EncodingDeflate:
+		default:
+			return fmt.Errorf("unknown request encoding %q for %s", route.Encoding, route.Prefix)
+		}
+		if route.MinSize < 0 || route.MaxDecompressedSize < 0 {
+			return fmt.Errorf("request compression sizes for %s must not be negative", route.Prefix)
+		}
+		if route.MinSize == 0 {
+			route.MinSize = DefaultCompressMinSize
+		}
+		if route.MaxDecompressedSize == 0 {
+			route.MaxDecompressedSize = DefaultDecompressMaxSize
+		}
+		compression[route.Prefix] = route
+	}
+
+	app.requestCompression = compression
+	return nil
+}
+
+// requestCompressionRoute returns the compression settings for a path
+func (app *Application) requestCompressionRoute(path string) (RequestCompressionRoute, bool) {
+	longest := ""
+	for prefix := range app.requestCompression {
+		if strings.HasPrefix(path, prefix) && len(prefix) > len(longest) {
+			longest = prefix
+		}
+	}
+	if longest == "" {
+		return RequestCompressionRoute{}, false
+	}
+	return app.requestCompression[longest], true
+}
+
+// recodeRequestBody converts a request body to the encoding its route's
+// backends accept. It returns the decoded body, for validation and cache
+// keys, and the body to forward, and updates the request's Content-Encoding
+// to match. Unsupported encodings get 415, corrupt bodies 400 and oversized
+// bodies 413. It reports whether the request may continue.
+func (app *Application) recodeRequestBody(w http.ResponseWriter, r *http.Request, body []byte) ([]byte, []byte, bool) {
+	route, exists := app.requestCompressionRoute(r.URL.Path)
+	if !exists {
+		return body, body, true
+	}
+
+	received := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	switch received {
+	case "":
+		received = EncodingIdentity
+	case "x-gzip":
+		received = EncodingGzip
+	}
+
+	plain := body
+	if received != EncodingIdentity {
+		var err error
+		plain, err = decompressBody(received, body, route.MaxDecompressedSize)
+		switch {
+		case errors.Is(err, errBodyTooLarge):
+			app.Logger.InfoContext(r.Context(), "decompressed request body too large", "path", r.URL.Path, "limit", route.MaxDecompressedSize)
+			app.writeError(w, r, CodeBodyTooLarge, "the decompressed request body is too large")
+			return nil, nil, false
+		case errors.Is(err, errUnsupportedEncoding):
+			app.Logger.InfoContext(r.Context(), "unsupported request body encoding", "path", r.URL.Path, "encoding", received)
+			w.Header().Set("Accept-Encoding", "gzip, deflate")
+			app.writeError(w, r, CodeUnsupportedEncoding, "the request body encoding is not supported")
+			return nil, nil, false
+		case err != nil:
+			app.Logger.InfoContext(r.Context(), "failed to decompress request body", "path", r.URL.Path, "encoding", received, "error", err)
+			app.writeError(w, r, CodeBadRequest, "the request body could not be decompressed")
+			return nil, nil, false
+		}
+	}
+
+	// A body already in the backend's encoding is forwarded as sent
+	if received == route.Encoding {
+		return plain, body, true
+	}
+
+	r.Header.Del("Content-Length")
+	if route.Encoding == EncodingIdentity || len(plain) < route.MinSize {
+		r.Header.Del("Content-Encoding")
+		return plain, plain, true
+	}
+
+	compressed, err := compressBody(route.Encoding, plain)
+	if err != nil {
+		app.Logger.WarnContext(r.Context(), "failed to compress request body", "path", r.URL.Path, "error", err)
+		r.Header.Del("Content-Encoding")
+		return plain, plain, true
+	}
+	r.Header.Set("Content-Encoding", route.Encoding)
+	app.Logger.DebugContext(r.Context(), "compressed request body", "path", r.URL.Path, "encoding", route.Encoding,
+		"size", len(plain), "compressed", len(compressed))
+	return plain, compressed, true
+}
+
+// decompressBody decodes a gzip or deflate body, failing with
+// errBodyTooLarge once it exceeds limit bytes
+func decompressBody(encoding string, body []byte, limit int64) ([]byte, error) {
+	var reader io.ReadCloser
+	var err error
+	switch encoding {
+	case EncodingGzip:
+		reader, err = gzip.NewReader(bytes.NewReader(body))
+	case EncodingDeflate:
+		reader, err = zlib.NewReader(bytes.NewReader(body))
+	default:
+		return nil, errUnsupportedEncoding
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var buf bytes.Buffer
+	n, err := buf.ReadFrom(io.LimitReader(reader, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if n > limit {
+		return nil, errBodyTooLarge
+	}
+	return buf.Bytes(), nil
+}
+
+// compressBody encodes a body as gzip or deflate
+func compressBody(encoding string, body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var writer io.WriteCloser
+	if encoding == EncodingGzip {
+		writer = gzip.NewWriter(&buf)
+	} else {
+		writer = zlib.NewWriter(&buf)
+	}
+
+	if _, err := writer.Write(body); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
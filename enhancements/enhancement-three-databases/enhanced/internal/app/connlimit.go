@@ -0,0 +1,217 @@
+package app
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConnLimitConfig bounds the connections accepted by the proxy listener
+type ConnLimitConfig struct {
+	// MaxConns caps concurrent connections; 0 means unlimited
+	MaxConns int
+	// MaxConnsPerIP caps concurrent connections from one client IP; 0 means unlimited
+	MaxConnsPerIP int
+	// QueueTimeout is how long an accepted connection waits for a free slot
+	// when MaxConns is reached before it is rejected; 0 rejects immediately
+	QueueTimeout time.Duration
+}
+
+// ConnLimiter enforces connection limits on listeners it wraps and counts
+// rejected connections
+type ConnLimiter struct {
+	cfg    ConnLimitConfig
+	slots  chan struct{}
+	logger *slog.Logger
+
+	mu    sync.Mutex
+	perIP map[string]int
+
+	active        atomic.Int64
+	accepted      atomic.Uint64
+	rejectedMax   atomic.Uint64
+	rejectedPerIP atomic.Uint64
+}
+
+// ConnLimitStats is a point-in-time view of connection limiter counters
+type ConnLimitStats struct {
+	MaxConns      int    `json:"max_conns"`
+	MaxConnsPerIP int    `json:"max_conns_per_ip"`
+	Active        int64  `json:"active"`
+	Accepted      uint64 `json:"accepted"`
+	RejectedMax   uint64 `json:"rejected_max_conns"`
+	RejectedPerIP uint64 `json:"rejected_per_ip"`
+}
+
+// NewConnLimiter creates a connection limiter from cfg
+func NewConnLimiter(cfg ConnLimitConfig, logger *slog.Logger) *ConnLimiter {
+	cl := &ConnLimiter{
+		cfg:    cfg,
+		logger: logger,
+		perIP:  make(map[string]int),
+	}
+	if cfg.MaxConns > 0 {
+		cl.slots = make(chan struct{}, cfg.MaxConns)
+	}
+	return cl
+}
+
+// Wrap returns a listener that enforces the limiter's limits
+func (cl *ConnLimiter) Wrap(ln net.Listener) net.Listener {
+	return &limitListener{Listener: ln, limiter: cl}
+}
+
+// Stats returns the limiter's configuration and counters
+func (cl *ConnLimiter) Stats() ConnLimitStats {
+	return ConnLimitStats{
+		MaxConns:      cl.cfg.MaxConns,
+		MaxConnsPerIP: cl.cfg.MaxConnsPerIP,
+		Active:        cl.active.Load(),
+		Accepted:      cl.accepted.Load(),
+		RejectedMax:   cl.rejectedMax.Load(),
+		RejectedPerIP: cl.rejectedPerIP.Load(),
+	}
+}
+
+// acquireSlot reserves a global connection slot, waiting up to QueueTimeout
+func (cl *ConnLimiter) acquireSlot() bool {
+	if cl.slots == nil {
+		return true
+	}
+
+	select {
+	case cl.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	if cl.cfg.QueueTimeout <= 0 {
+		return false
+	}
+
+	timer := time.NewTimer(cl.cfg.QueueTimeout)
+	defer timer.Stop()
+
+	select {
+	case cl.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func (cl *ConnLimiter) releaseSlot() {
+	if cl.slots != nil {
+		<-cl.slots
+	}
+}
+
+// acquireIP reserves a per-IP connection slot
+func (cl *ConnLimiter) acquireIP(ip string) bool {
+	if cl.cfg.MaxConnsPerIP <= 0 {
+		return true
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if cl.perIP[ip] >= cl.cfg.MaxConnsPerIP {
+		return false
+	}
+	cl.perIP[ip]++
+	return true
+}
+
+func (cl *ConnLimiter) releaseIP(ip string) {
+	if cl.cfg.MaxConnsPerIP <= 0 {
+		return
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	cl.perIP[ip]--
+	if cl.perIP[ip] <= 0 {
+		delete(cl.perIP, ip)
+	}
+}
+
+// limitListener rejects connections over the limiter's limits by closing them
+// right after accept
+type limitListener struct {
+	net.Listener
+	limiter *ConnLimiter
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	cl := l.limiter
+
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		ip, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+		if err != nil {
+			ip = conn.RemoteAddr().String()
+		}
+
+		if !cl.acquireSlot() {
+			cl.rejectedMax.Add(1)
+			cl.logger.Warn("connection rejected, max connections reached", "client_ip", ip, "max_conns", cl.cfg.MaxConns)
+			conn.Close()
+			continue
+		}
+
+		if !cl.acquireIP(ip) {
+			cl.releaseSlot()
+			cl.rejectedPerIP.Add(1)
+			cl.logger.Warn("connection rejected, per-IP limit reached", "client_ip", ip, "max_conns_per_ip", cl.cfg.MaxConnsPerIP)
+			conn.Close()
+			continue
+		}
+
+		cl.accepted.Add(1)
+		cl.active.Add(1)
+
+		return &limitedConn{Conn: conn, release: func() {
+			cl.active.Add(-1)
+			cl.releaseIP(ip)
+			cl.releaseSlot()
+		}}, nil
+	}
+}
+
+// limitedConn releases its limiter slots exactly once when closed
+type limitedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
+
+// HandleConnectionMetrics serves GET /admin/metrics/connections
+func (app *Application) HandleConnectionMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	resp := map[string]interface{}{"enabled": app.Connections != nil}
+	if app.Connections != nil {
+		resp["stats"] = app.Connections.Stats()
+	}
+	if app.ClientStreams != nil {
+		resp["client_streams"] = app.ClientStreams.Stats()
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
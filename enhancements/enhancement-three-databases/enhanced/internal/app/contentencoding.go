@@ -0,0 +1,141 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// MaxDecodedResponseSize bounds a backend response decoded at the proxy
+// because it came back in an encoding that was not asked for
+const MaxDecodedResponseSize = 10 << 20
+
+// CachedEncodings are the content encodings responses are cached in
+var CachedEncodings = []string{EncodingIdentity, EncodingGzip}
+
+// acceptsEncoding reports whether an Accept-Encoding header value allows
+// encoding. Identity is acceptable unless refused explicitly or through "*".
+func acceptsEncoding(header, encoding string) bool {
+	wildcard := -1.0
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		switch {
+		case name == encoding || (encoding == EncodingGzip && name == "x-gzip"):
+			return q > 0
+		case name == "*":
+			wildcard = q
+		}
+	}
+	if wildcard >= 0 {
+		return wildcard > 0
+	}
+	return encoding == EncodingIdentity
+}
+
+// negotiateEncoding returns the encoding a cacheable response is fetched in:
+// gzip when the client accepts it, identity otherwise. Range requests use
+// identity so their offsets are in the bytes the client gets.
+func negotiateEncoding(r *http.Request) string {
+	if r.Header.Get("Range") == "" && acceptsEncoding(r.Header.Get("Accept-Encoding"), EncodingGzip) {
+		return EncodingGzip
+	}
+	return EncodingIdentity
+}
+
+// normalizeAcceptEncoding asks the backend for exactly the encoding the
+// response will be cached and served in, returning that encoding and the
+// client's own Accept-Encoding. Setting the header also stops the transport
+// from decoding gzip on its own.
+func normalizeAcceptEncoding(r *http.Request) (string, string) {
+	accept := r.Header.Get("Accept-Encoding")
+	encoding := negotiateEncoding(r)
+	r.Header.Set("Accept-Encoding", encoding)
+	return encoding, accept
+}
+
+// encodingKey returns the cache key of key's variant in encoding. The
+// identity variant keeps the plain key.
+func encodingKey(key, encoding string) string {
+	if encoding == EncodingIdentity {
+		return key
+	}
+	return key + "#encoding=" + encoding
+}
+
+// responseEncoding returns a response's content encoding, identity when it
+// has none
+func responseEncoding(header http.Header) string {
+	encoding := strings.ToLower(strings.TrimSpace(header.Get("Content-Encoding")))
+	if encoding == "" {
+		return EncodingIdentity
+	}
+	if encoding == "x-gzip" {
+		return EncodingGzip
+	}
+	return encoding
+}
+
+// matchEncoding checks a buffered response body against the encoding asked
+// of the backend. A body in another encoding the proxy can decode is decoded
+// to identity, and the headers already copied to w are corrected. A body it
+// cannot decode is still served, uncached, when the client's accept header
+// allows its encoding. It returns the body, whether it may be cached, and an
+// error when it cannot be served at all.
+func matchEncoding(w http.ResponseWriter, body []byte, wanted, accept string) ([]byte, bool, error) {
+	got := responseEncoding(w.Header())
+	if got == wanted || got == EncodingIdentity {
+		return body, true, nil
+	}
+
+	decoded, err := decompressBody(got, body, MaxDecodedResponseSize)
+	if err != nil && acceptsEncoding(accept, got) {
+		return body, false, nil
+	}
+	// Either way the body leaving the proxy is no longer the backend's
+	w.Header().Del("Content-Encoding")
+	w.Header().Del("Content-Length")
+	switch {
+	case errors.Is(err, errUnsupportedEncoding):
+		return nil, false, fmt.Errorf("backend responded with unsupported content encoding %q instead of %q", got, wanted)
+	case err != nil:
+		return nil, false, fmt.Errorf("decoding backend %s response: %w", got, err)
+	}
+	return decoded, true, nil
+}
+
+// lookupEncoded returns the cached variant of key the client accepts,
+// preferring gzip, with its encoding
+func lookupEncoded(cache *ResponseCache, r *http.Request, key string) ([]byte, string, bool) {
+	if negotiateEncoding(r) == EncodingGzip {
+		if body, found := cache.Lookup(encodingKey(key, EncodingGzip)); found {
+			return body, EncodingGzip, true
+		}
+	}
+	if !acceptsEncoding(r.Header.Get("Accept-Encoding"), EncodingIdentity) {
+		return nil, "", false
+	}
+	body, found := cache.Lookup(key)
+	return body, EncodingIdentity, found
+}
+
+// writeCached answers a request with a cached body in encoding, or with the
+// part of it the request's range asks for
+func writeCached(w http.ResponseWriter, r *http.Request, body []byte, encoding string) {
+	w.Header().Add("Vary", "Accept-Encoding")
+	if encoding != EncodingIdentity {
+		w.Header().Set("Content-Encoding", encoding)
+	} else if serveRange(w, requestedRange(r), body) {
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
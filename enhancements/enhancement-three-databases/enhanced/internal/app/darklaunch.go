@@ -0,0 +1,479 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultDarkLaunchDuration is how long a dark launch evaluates its
+	// candidate rules when no duration is given
+	DefaultDarkLaunchDuration = time.Hour
+	// DarkLaunchRecentCapacity bounds the differing decisions kept for the
+	// admin API
+	DarkLaunchRecentCapacity = 100
+)
+
+// Decisions compared by a dark launch
+const (
+	DecisionRouteAllowed  = "route_allowed"
+	DecisionCache         = "cache"
+	DecisionRateLimitKey  = "rate_limit_key"
+	DecisionPriorityClass = "priority_class"
+	DecisionHeaders       = "headers"
+	DecisionBackend       = "backend"
+	DecisionAuthBypass    = "auth_bypass"
+
+	// decisionCacheBypass is compared through DecisionCache
+	decisionCacheBypass = "cache_bypass"
+)
+
+// DarkLaunchConfig is a set of pending rule changes to evaluate alongside
+// the active ones without applying them
+type DarkLaunchConfig struct {
+	// Policies replaces the whole policy file; nil keeps the active policies
+	Policies *PolicyConfig `json:"policies,omitempty"`
+	// BlueGreen adds or replaces blue/green routes by prefix
+	BlueGreen []BlueGreenRoute `json:"bluegreen,omitempty"`
+	// Duration is a duration string such as "30m"; default 1h
+	Duration string `json:"duration,omitempty"`
+}
+
+// DecisionPair is what the active and the candidate rules decided
+type DecisionPair struct {
+	Actual string `json:"actual"`
+	Shadow string `json:"shadow"`
+}
+
+// DarkLaunchDifference is a request the candidate rules would have handled
+// differently
+type DarkLaunchDifference struct {
+	Time        time.Time               `json:"time"`
+	RequestID   string                  `json:"request_id,omitempty"`
+	Method      string                  `json:"method"`
+	Path        string                  `json:"path"`
+	Prefix      string                  `json:"prefix,omitempty"`
+	Differences map[string]DecisionPair `json:"differences"`
+}
+
+// DarkLaunchStatus reports a dark launch for the admin API
+type DarkLaunchStatus struct {
+	DarkLaunchConfig
+	Active     bool                   `json:"active"`
+	StartedAt  time.Time              `json:"started_at"`
+	ExpiresAt  time.Time              `json:"expires_at"`
+	Evaluated  uint64                 `json:"evaluated"`
+	Differed   uint64                 `json:"differed"`
+	ByDecision map[string]uint64      `json:"by_decision"`
+	Recent     []DarkLaunchDifference `json:"recent"`
+}
+
+// darkLaunch is a validated dark launch and its results
+type darkLaunch struct {
+	cfg       DarkLaunchConfig
+	policies  *Policies
+	blueGreen map[string]BlueGreenRoute
+	startedAt time.Time
+	expiresAt time.Time
+
+	evaluated  atomic.Uint64
+	differed   atomic.Uint64
+	mu         sync.Mutex
+	byDecision map[string]uint64
+	recent     []DarkLaunchDifference
+}
+
+// DarkLaunch shadow-evaluates at most one set of candidate rules at a time
+type DarkLaunch struct {
+	current atomic.Pointer[darkLaunch]
+}
+
+// NewDarkLaunch creates an idle dark launch
+func NewDarkLaunch() *DarkLaunch {
+	return &DarkLaunch{}
+}
+
+// newDarkLaunch compiles and validates candidate rules
+func newDarkLaunch(cfg DarkLaunchConfig) (*darkLaunch, error) {
+	duration := DefaultDarkLaunchDuration
+	if cfg.Duration != "" {
+		d, err := time.ParseDuration(cfg.Duration)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid duration %q", cfg.Duration)
+		}
+		duration = d
+	}
+	if cfg.Policies == nil && len(cfg.BlueGreen) == 0 {
+		return nil, fmt.Errorf("policies or bluegreen routes are required")
+	}
+
+	now := time.Now()
+	dl := &darkLaunch{
+		cfg:        cfg,
+		blueGreen:  make(map[string]BlueGreenRoute, len(cfg.BlueGreen)),
+		startedAt:  now,
+		expiresAt:  now.Add(duration),
+		byDecision: make(map[string]uint64),
+	}
+	if cfg.Policies != nil {
+		policies, err := CompilePolicies(*cfg.Policies)
+		if err != nil {
+			return nil, err
+		}
+		dl.policies = policies
+	}
+	for _, route := range cfg.BlueGreen {
+		if err := route.validate(); err != nil {
+			return nil, fmt.Errorf("blue/green route %s: %w", route.Prefix, err)
+		}
+		dl.blueGreen[route.Prefix] = route
+	}
+	return dl, nil
+}
+
+// active returns the running dark launch, or nil once it has expired
+func (d *DarkLaunch) active() *darkLaunch {
+	dl := d.current.Load()
+	if dl == nil || time.Now().After(dl.expiresAt) {
+		return nil
+	}
+	return dl
+}
+
+// Status returns the current or last dark launch
+func (d *DarkLaunch) Status() (DarkLaunchStatus, bool) {
+	dl := d.current.Load()
+	if dl == nil {
+		return DarkLaunchStatus{}, false
+	}
+
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+
+	byDecision := make(map[string]uint64, len(dl.byDecision))
+	for decision, count := range dl.byDecision {
+		byDecision[decision] = count
+	}
+	return DarkLaunchStatus{
+		DarkLaunchConfig: dl.cfg,
+		Active:           time.Now().Before(dl.expiresAt),
+		StartedAt:        dl.startedAt,
+		ExpiresAt:        dl.expiresAt,
+		Evaluated:        dl.evaluated.Load(),
+		Differed:         dl.differed.Load(),
+		ByDecision:       byDecision,
+		Recent:           append([]DarkLaunchDifference(nil), dl.recent...),
+	}, true
+}
+
+// record keeps a difference for the admin API
+func (dl *darkLaunch) record(diff DarkLaunchDifference) {
+	dl.differed.Add(1)
+
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+
+	for decision := range diff.Differences {
+		dl.byDecision[decision]++
+	}
+	dl.recent = append(dl.recent, diff)
+	if len(dl.recent) > DarkLaunchRecentCapacity {
+		dl.recent = dl.recent[len(dl.recent)-DarkLaunchRecentCapacity:]
+	}
+}
+
+// darkLaunchKey is the context key for a request's observed decisions
+type darkLaunchKey struct{}
+
+// observedDecisions are decisions only known once a request is handled
+type observedDecisions struct {
+	mu     sync.Mutex
+	prefix string
+	server string
+	cache  string
+}
+
+// observeBackend records the backend a request was routed to
+func observeBackend(r *http.Request, backend *BackendInfo) {
+	if observed, ok := r.Context().Value(darkLaunchKey{}).(*observedDecisions); ok {
+		observed.mu.Lock()
+		observed.prefix, observed.server = backend.Prefix, backend.Server.Name
+		observed.mu.Unlock()
+	}
+}
+
+// observeCache records a request's cache result: "hit", "miss", "bypass"
+// when the cache bypass policy skipped it, or "skip" for other reasons
+func observeCache(r *http.Request, result string) {
+	if record := requestRecordFrom(r.Context()); record != nil {
+		record.mu.Lock()
+		record.cache = result
+		record.mu.Unlock()
+	}
+	if observed, ok := r.Context().Value(darkLaunchKey{}).(*observedDecisions); ok {
+		observed.mu.Lock()
+		observed.cache = result
+		observed.mu.Unlock()
+	}
+}
+
+// shadowEvaluate prepares a request for comparison against a running dark
+// launch. The returned function compares the candidate rules' decisions with
+// the active ones once the request has been handled.
+func (app *Application) shadowEvaluate(r *http.Request) (*http.Request, func()) {
+	dl := app.DarkLaunch.active()
+	if dl == nil {
+		return r, func() {}
+	}
+
+	// Header rules modify the request, so decisions are made on a copy of it
+	// as it arrived
+	original := r.Clone(r.Context())
+	observed := &observedDecisions{}
+	r = r.WithContext(context.WithValue(r.Context(), darkLaunchKey{}, observed))
+
+	return r, func() { app.compareDarkLaunch(dl, original, observed) }
+}
+
+// compareDarkLaunch logs and records where the candidate rules would have
+// decided differently
+func (app *Application) compareDarkLaunch(dl *darkLaunch, r *http.Request, observed *observedDecisions) {
+	dl.evaluated.Add(1)
+
+	observed.mu.Lock()
+	prefix, server, cache := observed.prefix, observed.server, observed.cache
+	observed.mu.Unlock()
+	if prefix == "" {
+		prefix, _, _ = app.Registry.ServersForPath(r.URL.Path)
+	}
+
+	activePolicies := app.policies.Load()
+	candidatePolicies := activePolicies
+	if dl.policies != nil {
+		candidatePolicies = dl.policies
+	}
+	actual := policyDecisions(activePolicies, r, prefix)
+	shadow := policyDecisions(candidatePolicies, r, prefix)
+
+	differences := make(map[string]DecisionPair)
+	for decision, value := range actual {
+		if shadow[decision] != value {
+			differences[decision] = DecisionPair{Actual: value, Shadow: shadow[decision]}
+		}
+	}
+
+	// The cache result is observed; the candidate only changes whether the
+	// bypass policy skips the cache
+	if cache != "" && cache != "skip" {
+		shadowCache := "lookup"
+		if shadow[decisionCacheBypass] == "true" {
+			shadowCache = "bypass"
+		}
+		if (cache == "bypass") != (shadowCache == "bypass") {
+			differences[DecisionCache] = DecisionPair{Actual: cache, Shadow: shadowCache}
+		}
+	}
+	delete(differences, decisionCacheBypass)
+
+	if server != "" {
+		if route, exists := dl.blueGreen[prefix]; exists {
+			group := route.Active
+			inCanary := route.Canary != "" && route.CanaryWeight > 0 && containsString(route.Groups[route.Canary], server)
+			if !containsString(route.Groups[group], server) && !inCanary {
+				differences[DecisionBackend] = DecisionPair{Actual: server, Shadow: "group " + group}
+			}
+		}
+	}
+
+	if len(differences) == 0 {
+		app.Logger.DebugContext(r.Context(), "dark launch decisions match", "path", r.URL.Path)
+		return
+	}
+
+	dl.record(DarkLaunchDifference{
+		Time:        time.Now(),
+		RequestID:   RequestIDFromContext(r.Context()),
+		Method:      r.Method,
+		Path:        r.URL.Path,
+		Prefix:      prefix,
+		Differences: differences,
+	})
+
+	attrs := []interface{}{"method", r.Method, "path", r.URL.Path, "prefix", prefix}
+	for _, decision := range sortedDecisions(differences) {
+		pair := differences[decision]
+		attrs = append(attrs, decision, pair.Actual+" -> "+pair.Shadow)
+	}
+	app.Logger.InfoContext(r.Context(), "dark launch decision differs", attrs...)
+}
+
+// policyDecisions evaluates a policy set against a request the way the
+// request path does, with the same fallbacks on evaluation errors
+func policyDecisions(policies *Policies, r *http.Request, prefix string) map[string]string {
+	decisions := map[string]string{
+		DecisionRouteAllowed:  "true",
+		decisionCacheBypass:   "false",
+		DecisionRateLimitKey:  "",
+		DecisionPriorityClass: "",
+		DecisionHeaders:       "",
+		DecisionAuthBypass:    "",
+	}
+	if policies == nil {
+		return decisions
+	}
+
+	vars := requestVars(r)
+	if prog, exists := policies.routeConditions[prefix]; exists && prefix != "" {
+		ok, err := prog.EvalBool(vars)
+		decisions[DecisionRouteAllowed] = fmt.Sprint(ok && err == nil)
+	}
+	if policies.cacheBypass != nil {
+		bypass, err := policies.cacheBypass.EvalBool(vars)
+		decisions[decisionCacheBypass] = fmt.Sprint(bypass && err == nil)
+	}
+	if policies.rateLimitKey != nil {
+		if key, err := policies.rateLimitKey.EvalString(vars); err == nil {
+			decisions[DecisionRateLimitKey] = key
+		}
+	}
+	if policies.priorityClass != nil {
+		if class, err := policies.priorityClass.EvalString(vars); err == nil {
+			decisions[DecisionPriorityClass] = class
+		}
+	}
+
+	// Header rules are summarised as the headers they would set and remove
+	var changes []string
+	for _, rule := range policies.headerRules {
+		if match, err := rule.when.EvalBool(vars); err != nil || !match {
+			continue
+		}
+		for key, value := range rule.set {
+			changes = append(changes, http.CanonicalHeaderKey(key)+"="+value)
+		}
+		for _, key := range rule.remove {
+			changes = append(changes, "-"+http.CanonicalHeaderKey(key))
+		}
+	}
+	sort.Strings(changes)
+	decisions[DecisionHeaders] = strings.Join(changes, ",")
+
+	// Auth bypass is summarised as the prefix of the matching rule
+	if rule := policies.matchAuthBypass(r, nil); rule != nil {
+		decisions[DecisionAuthBypass] = rule.prefix
+	}
+
+	return decisions
+}
+
+// sortedDecisions returns the names of differing decisions in order
+func sortedDecisions(differences map[string]DecisionPair) []string {
+	names := make([]string, 0, len(differences))
+	for name := range differences {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// containsString reports whether values contains value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// promoteDarkLaunch activates the candidate rules of the current dark launch
+// and ends it
+func (app *Application) promoteDarkLaunch() (DarkLaunchConfig, error) {
+	dl := app.DarkLaunch.current.Load()
+	if dl == nil {
+		return DarkLaunchConfig{}, fmt.Errorf("no dark launch to promote")
+	}
+
+	for _, route := range dl.cfg.BlueGreen {
+		if err := app.BlueGreen.Set(route); err != nil {
+			return DarkLaunchConfig{}, fmt.Errorf("blue/green route %s: %w", route.Prefix, err)
+		}
+		app.configReloaded("bluegreen", map[string]interface{}{"prefix": route.Prefix, "active": route.Active})
+	}
+	if dl.policies != nil {
+		app.policies.Store(dl.policies)
+		app.configReloaded("policies", map[string]interface{}{"source": "dark_launch"})
+	}
+
+	app.DarkLaunch.current.CompareAndSwap(dl, nil)
+	return dl.cfg, nil
+}
+
+// HandleDarkLaunch serves GET, PUT and DELETE /admin/darklaunch. GET reports
+// the current or last dark launch, PUT starts evaluating candidate rules in
+// place of any running dark launch and DELETE stops it.
+func (app *Application) HandleDarkLaunch(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		status, exists := app.DarkLaunch.Status()
+		if !exists {
+			writeJSON(w, http.StatusOK, map[string]interface{}{"active": false})
+			return
+		}
+		writeJSON(w, http.StatusOK, status)
+
+	case http.MethodPut:
+		var cfg DarkLaunchConfig
+		if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+			http.Error(w, "invalid payload in request", http.StatusBadRequest)
+			return
+		}
+		dl, err := newDarkLaunch(cfg)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		app.DarkLaunch.current.Store(dl)
+
+		app.Logger.InfoContext(r.Context(), "dark launch started",
+			"policies", cfg.Policies != nil, "bluegreen_routes", len(cfg.BlueGreen), "expires_at", dl.expiresAt)
+		status, _ := app.DarkLaunch.Status()
+		writeJSON(w, http.StatusOK, status)
+
+	case http.MethodDelete:
+		if app.DarkLaunch.current.Swap(nil) == nil {
+			http.Error(w, "no dark launch is running", http.StatusNotFound)
+			return
+		}
+		app.Logger.InfoContext(r.Context(), "dark launch stopped")
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "stopped"})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleDarkLaunchPromote serves POST /admin/darklaunch/promote, activating
+// the dark launch's candidate rules
+func (app *Application) HandleDarkLaunchPromote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cfg, err := app.promoteDarkLaunch()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	app.Logger.InfoContext(r.Context(), "dark launch promoted", "policies", cfg.Policies != nil, "bluegreen_routes", len(cfg.BlueGreen))
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": "promoted", "config": cfg})
+}
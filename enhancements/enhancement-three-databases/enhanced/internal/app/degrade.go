@@ -0,0 +1,162 @@
+package app
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Degrader defaults applied to zero DegradeConfig fields
+const (
+	DefaultDegradeMaxStale = 24 * time.Hour
+	DefaultDegradeMaxBytes = 10 * 1024 * 1024
+)
+
+// StaleWarning is the Warning header sent with a degraded response
+const StaleWarning = `110 go-reverse-proxy "Response is Stale"`
+
+// DegradeConfig marks routes whose cached responses may be served after they
+// expire when every backend for the route is down
+type DegradeConfig struct {
+	// Routes are the degradable route prefixes
+	Routes []string
+	// MaxStale is the oldest response served; 0 uses DefaultDegradeMaxStale
+	MaxStale time.Duration
+	// MaxBytes bounds the stale copies kept; 0 uses DefaultDegradeMaxBytes
+	MaxBytes int
+}
+
+// Degrader keeps the last cached response of every request on a degradable
+// route, beyond the cache TTL, and serves it when the route has no backend
+type Degrader struct {
+	cfg   DegradeConfig
+	stale *ResponseCache
+	// served counts degraded responses by route; its keys are fixed at
+	// creation
+	served map[string]*atomic.Uint64
+}
+
+// NewDegrader validates cfg and creates a degrader
+func NewDegrader(cfg DegradeConfig, logger *slog.Logger) (*Degrader, error) {
+	if len(cfg.Routes) == 0 {
+		return nil, fmt.Errorf("at least one degradable route is required")
+	}
+	if cfg.MaxStale < 0 || cfg.MaxBytes < 0 {
+		return nil, fmt.Errorf("degrade max stale and max bytes must not be negative")
+	}
+	if cfg.MaxStale == 0 {
+		cfg.MaxStale = DefaultDegradeMaxStale
+	}
+	if cfg.MaxBytes == 0 {
+		cfg.MaxBytes = DefaultDegradeMaxBytes
+	}
+
+	d := &Degrader{
+		cfg:    cfg,
+		stale:  NewResponseCache(cfg.MaxStale, cfg.MaxBytes, logger),
+		served: make(map[string]*atomic.Uint64, len(cfg.Routes)),
+	}
+	for _, prefix := range cfg.Routes {
+		if !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("degradable route %q must start with /", prefix)
+		}
+		d.served[prefix] = &atomic.Uint64{}
+	}
+	return d, nil
+}
+
+// SetDegrader serves expired responses on degradable routes when they have
+// no backend. It must be called before Start.
+func (app *Application) SetDegrader(d *Degrader) {
+	d.stale.clock = app.clock
+	app.Degrader = d
+}
+
+// route returns the longest degradable prefix matching path, or ""
+func (d *Degrader) route(path string) string {
+	longest := ""
+	for prefix := range d.served {
+		if strings.HasPrefix(path, prefix) && len(prefix) > len(longest) {
+			longest = prefix
+		}
+	}
+	return longest
+}
+
+// rememberDegraded keeps a copy of a response just queued for the cache when
+// its route is degradable
+func (app *Application) rememberDegraded(r *http.Request, key string, body []byte) {
+	if d := app.Degrader; d != nil && d.route(r.URL.Path) != "" {
+		d.stale.StoreAsync(key, body)
+	}
+}
+
+// serveDegraded answers a request whose route has no available backend with
+// the last response cached for key, and reports whether it did
+func (app *Application) serveDegraded(w http.ResponseWriter, r *http.Request, key string) bool {
+	d := app.Degrader
+	if d == nil || key == "" {
+		return false
+	}
+	prefix := d.route(r.URL.Path)
+	if prefix == "" {
+		return false
+	}
+
+	body, encoding, found := lookupEncoded(d.stale, r, key)
+	if !found {
+		return false
+	}
+
+	d.served[prefix].Add(1)
+	app.Logger.WarnContext(r.Context(), "serving degraded response", "path", r.URL.Path, "route", prefix)
+	w.Header().Set("Warning", StaleWarning)
+	writeCached(w, r, body, encoding)
+	return true
+}
+
+// DegradeStats reports the degradable routes and how often each was served
+// from a stale response
+type DegradeStats struct {
+	Routes     []string          `json:"routes"`
+	MaxStale   string            `json:"max_stale"`
+	MaxBytes   int               `json:"max_bytes"`
+	StoredKeys int               `json:"stored_keys"`
+	Served     map[string]uint64 `json:"served_degraded"`
+}
+
+// Stats returns the degrader's configuration and counters
+func (d *Degrader) Stats() DegradeStats {
+	d.stale.mu.RLock()
+	stored := len(d.stale.items)
+	d.stale.mu.RUnlock()
+
+	served := make(map[string]uint64, len(d.served))
+	for prefix, count := range d.served {
+		served[prefix] = count.Load()
+	}
+	return DegradeStats{
+		Routes:     d.cfg.Routes,
+		MaxStale:   d.cfg.MaxStale.String(),
+		MaxBytes:   d.cfg.MaxBytes,
+		StoredKeys: stored,
+		Served:     served,
+	}
+}
+
+// HandleDegradeMetrics serves GET /admin/metrics/degraded
+func (app *Application) HandleDegradeMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if app.Degrader == nil {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": false})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": true, "stats": app.Degrader.Stats()})
+}
@@ -0,0 +1,225 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultDrainTimeout bounds how long a deregistered backend's in-flight
+// requests are waited for
+const DefaultDrainTimeout = 30 * time.Second
+
+// ErrAlreadyDraining is returned when deregistering a backend that is
+// already being drained
+var ErrAlreadyDraining = errors.New("backend is already draining")
+
+// DrainResult reports how a backend's deregistration drained
+type DrainResult struct {
+	// Drained reports whether every in-flight request completed before the
+	// backend was removed
+	Drained bool `json:"drained"`
+	// Abandoned counts requests still in flight when the drain timed out
+	Abandoned int           `json:"abandoned"`
+	Duration  time.Duration `json:"duration"`
+}
+
+// drainState is a backend being drained
+type drainState struct {
+	since time.Time
+	// done is closed when the backend's last in-flight request completes
+	done chan struct{}
+}
+
+// BackendDrainer counts in-flight requests per backend and holds backends
+// being drained out of selection until those requests complete
+type BackendDrainer struct {
+	mu       sync.Mutex
+	inFlight map[string]int
+	draining map[string]*drainState
+}
+
+// NewBackendDrainer creates a drainer with no backends draining
+func NewBackendDrainer() *BackendDrainer {
+	return &BackendDrainer{
+		inFlight: make(map[string]int),
+		draining: make(map[string]*drainState),
+	}
+}
+
+// acquire counts a request to a backend, refusing one to a backend that
+// started draining since it was selected
+func (d *BackendDrainer) acquire(name string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, draining := d.draining[name]; draining {
+		return false
+	}
+	d.inFlight[name]++
+	return true
+}
+
+// release ends a request counted by acquire, completing the backend's drain
+// when it was the last one
+func (d *BackendDrainer) release(name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.inFlight[name]--
+	if d.inFlight[name] > 0 {
+		return
+	}
+	delete(d.inFlight, name)
+	if state, draining := d.draining[name]; draining {
+		close(state.done)
+	}
+}
+
+// Draining reports whether a backend is being drained, and since when
+func (d *BackendDrainer) Draining(name string) (time.Time, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	state, draining := d.draining[name]
+	if !draining {
+		return time.Time{}, false
+	}
+	return state.since, true
+}
+
+// InFlight returns how many requests a backend is serving
+func (d *BackendDrainer) InFlight(name string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.inFlight[name]
+}
+
+// start marks a backend as draining from now and returns a channel closed
+// once it has no requests in flight
+func (d *BackendDrainer) start(name string, now time.Time) (<-chan struct{}, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, draining := d.draining[name]; draining {
+		return nil, ErrAlreadyDraining
+	}
+	state := &drainState{since: now, done: make(chan struct{})}
+	if d.inFlight[name] == 0 {
+		close(state.done)
+	}
+	d.draining[name] = state
+	return state.done, nil
+}
+
+// finish forgets a drained backend
+func (d *BackendDrainer) finish(name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.draining, name)
+}
+
+// SetDrainTimeout bounds how long deregistration waits for a backend's
+// in-flight requests; 0 uses DefaultDrainTimeout
+func (app *Application) SetDrainTimeout(timeout time.Duration) error {
+	if timeout < 0 {
+		return fmt.Errorf("drain timeout must not be negative")
+	}
+	if timeout == 0 {
+		timeout = DefaultDrainTimeout
+	}
+	app.drainTimeout = timeout
+	return nil
+}
+
+// DeregisterGracefully removes a backend in two phases. It first marks the
+// backend as draining, so it is no longer selected, and waits up to the
+// drain timeout for its in-flight requests to complete. It then deregisters
+// the backend, drops its health, breaker and metrics state and purges its
+// routes' cached responses.
+func (app *Application) DeregisterGracefully(ctx context.Context, name string) (DrainResult, error) {
+	server, err := app.Registry.GetServer(name)
+	if err != nil || server == nil {
+		return DrainResult{}, fmt.Errorf("server '%s' does not exist... cannot deregister", name)
+	}
+
+	start := app.clock.Now()
+	done, err := app.Drain.start(name, start)
+	if err != nil {
+		return DrainResult{}, err
+	}
+	defer app.Drain.finish(name)
+
+	app.Logger.InfoContext(ctx, "backend draining", "server", name, "in_flight", app.Drain.InFlight(name), "timeout", app.drainTimeout)
+	app.Events.Publish(Event{Type: EventServerDraining, Server: name})
+
+	timer := app.clock.NewTimer(app.drainTimeout)
+	defer timer.Stop()
+
+	result := DrainResult{Drained: true}
+	select {
+	case <-done:
+	case <-timer.C():
+		result.Drained = false
+	case <-ctx.Done():
+		result.Drained = false
+	}
+	result.Duration = app.clock.Since(start)
+
+	if !result.Drained {
+		result.Abandoned = app.Drain.InFlight(name)
+		app.Logger.WarnContext(ctx, "backend drain timed out, deregistering with requests in flight",
+			"server", name, "in_flight", result.Abandoned, "duration", result.Duration)
+	}
+
+	if err := app.Registry.Deregister(name); err != nil {
+		return result, err
+	}
+	app.forgetBackend(name)
+	for _, prefix := range server.Prefixes {
+		purged := app.Cache.PurgePrefix(prefix)
+		if app.Degrader != nil {
+			app.Degrader.stale.PurgePrefix(prefix)
+		}
+		app.Events.Publish(Event{Type: EventCachePurged, Server: name, Data: map[string]interface{}{"entries": purged, "prefix": prefix}})
+	}
+	app.Logger.InfoContext(ctx, "backend drained and deregistered", "server", name, "drained", result.Drained, "duration", result.Duration)
+	return result, nil
+}
+
+// HandleDeregister serves POST /deregister, draining the backend before it
+// is removed. The response reports whether every in-flight request
+// completed.
+func (app *Application) HandleDeregister(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+		http.Error(w, "invalid payload in request", http.StatusBadRequest)
+		return
+	}
+
+	result, err := app.DeregisterGracefully(r.Context(), req.Name)
+	switch {
+	case errors.Is(err, ErrAlreadyDraining):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "server deregistered successfully",
+		"drain":   result,
+	})
+}
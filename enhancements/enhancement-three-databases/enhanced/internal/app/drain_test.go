@@ -0,0 +1,55 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDeregisterGracefullyTimesOutOnClock(t *testing.T) {
+	app := newTestApp(t)
+	clock := NewFakeClock(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	app.SetClock(clock)
+	addTestBackend(t, app, "api", func(w http.ResponseWriter, r *http.Request) {}, "/api")
+	app.CircuitBreaker.OpenBreaker("api", "test")
+
+	app.Cache.Store("/api/items", []byte("items"))
+	app.Cache.Store("/other/items", []byte("other"))
+
+	// A request still in flight holds the drain open until the timeout
+	app.Drain.acquire("api")
+	defer app.Drain.release("api")
+
+	results := make(chan DrainResult, 1)
+	go func() {
+		result, err := app.DeregisterGracefully(context.Background(), "api")
+		if err != nil {
+			t.Error(err)
+		}
+		results <- result
+	}()
+
+	for clock.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(DefaultDrainTimeout)
+
+	result := <-results
+	if result.Drained || result.Abandoned != 1 || result.Duration != DefaultDrainTimeout {
+		t.Fatalf("drain result %+v, want abandoned 1 after %v", result, DefaultDrainTimeout)
+	}
+
+	if _, ok := app.HealthMonitor.GetHealthStatus("api"); ok {
+		t.Error("health state survived deregistration")
+	}
+	if _, ok := app.CircuitBreaker.GetBreakerInfo("api"); ok {
+		t.Error("breaker state survived deregistration")
+	}
+	if _, ok := app.Cache.Get("/api/items"); ok {
+		t.Error("cached response for the backend's route survived deregistration")
+	}
+	if _, ok := app.Cache.Get("/other/items"); !ok {
+		t.Error("cached response for another route was purged")
+	}
+}
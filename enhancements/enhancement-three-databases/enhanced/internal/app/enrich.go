@@ -0,0 +1,268 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Context headers sent to backends by request enrichment. Inbound copies are
+// always removed so clients cannot forge them.
+const (
+	HeaderClientCountry = "X-Client-Country"
+	HeaderClientRegion  = "X-Client-Region"
+	HeaderClientCity    = "X-Client-City"
+	HeaderClientASN     = "X-Client-ASN"
+	HeaderClientASOrg   = "X-Client-AS-Org"
+	HeaderTLSVersion    = "X-Client-TLS-Version"
+	HeaderTLSCipher     = "X-Client-TLS-Cipher"
+	HeaderDeviceClass   = "X-Client-Device"
+	HeaderSubject       = "X-Authenticated-Subject"
+)
+
+var enrichmentHeaders = []string{
+	HeaderClientCountry, HeaderClientRegion, HeaderClientCity, HeaderClientASN, HeaderClientASOrg,
+	HeaderTLSVersion, HeaderTLSCipher, HeaderDeviceClass, HeaderSubject,
+}
+
+// Enrichments
+const (
+	EnrichGeo     = "geo"
+	EnrichTLS     = "tls"
+	EnrichDevice  = "device"
+	EnrichSubject = "subject"
+)
+
+// Device classes sent in X-Client-Device
+const (
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceDesktop = "desktop"
+	DeviceBot     = "bot"
+	DeviceUnknown = "unknown"
+)
+
+// GeoInfo is what a GeoLookup knows about a client address; empty fields are
+// not sent
+type GeoInfo struct {
+	Country string `json:"country"`
+	Region  string `json:"region"`
+	City    string `json:"city"`
+	ASN     uint32 `json:"asn"`
+	ASOrg   string `json:"as_org"`
+}
+
+// GeoLookup resolves a client address to its location and network. Embedders
+// can plug in a MaxMind or other database; LoadGeoRanges provides a static
+// table.
+type GeoLookup interface {
+	Lookup(addr netip.Addr) (GeoInfo, bool)
+}
+
+// Enrichment adds context headers to requests forwarded to backends, so
+// backends don't each parse client addresses, TLS state and user agents
+type Enrichment struct {
+	geo     GeoLookup
+	tls     bool
+	device  bool
+	subject bool
+}
+
+// NewEnrichment creates an enrichment from a list of enrichments; geo needs
+// a lookup
+func NewEnrichment(enrichments []string, geo GeoLookup) (*Enrichment, error) {
+	e := &Enrichment{}
+	for _, name := range enrichments {
+		switch strings.TrimSpace(name) {
+		case EnrichGeo:
+			if geo == nil {
+				return nil, fmt.Errorf("geo enrichment needs a geo lookup")
+			}
+			e.geo = geo
+		case EnrichTLS:
+			e.tls = true
+		case EnrichDevice:
+			e.device = true
+		case EnrichSubject:
+			e.subject = true
+		default:
+			return nil, fmt.Errorf("unknown enrichment %q", name)
+		}
+	}
+	return e, nil
+}
+
+type subjectKey struct{}
+
+// ContextWithSubject records the authenticated subject of a request, so auth
+// middleware can pass it to backends through the subject enrichment
+func ContextWithSubject(ctx context.Context, subject string) context.Context {
+	return context.WithValue(ctx, subjectKey{}, subject)
+}
+
+// SubjectFromContext returns the subject set with ContextWithSubject
+func SubjectFromContext(ctx context.Context) string {
+	subject, _ := ctx.Value(subjectKey{}).(string)
+	return subject
+}
+
+// requestSubject is the subject set by auth middleware or, failing that, the
+// common name of a verified client certificate
+func requestSubject(r *http.Request) string {
+	if subject := SubjectFromContext(r.Context()); subject != "" {
+		return subject
+	}
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return r.TLS.VerifiedChains[0][0].Subject.CommonName
+	}
+	return ""
+}
+
+// enrichRequest replaces any inbound context headers with the proxy's own
+func (app *Application) enrichRequest(r *http.Request) {
+	e := app.Enrichment
+	if e == nil || app.skipStage(r, StageEnrichment) {
+		return
+	}
+
+	for _, name := range enrichmentHeaders {
+		r.Header.Del(name)
+	}
+	set := func(name, value string) {
+		if value != "" {
+			r.Header.Set(name, value)
+		}
+	}
+
+	if e.geo != nil {
+		if addr, ok := remoteAddr(r); ok {
+			if info, found := e.geo.Lookup(addr); found {
+				set(HeaderClientCountry, info.Country)
+				set(HeaderClientRegion, info.Region)
+				set(HeaderClientCity, info.City)
+				if info.ASN != 0 {
+					set(HeaderClientASN, strconv.FormatUint(uint64(info.ASN), 10))
+				}
+				set(HeaderClientASOrg, info.ASOrg)
+			}
+		}
+	}
+	if e.tls && r.TLS != nil {
+		set(HeaderTLSVersion, tls.VersionName(r.TLS.Version))
+		set(HeaderTLSCipher, tls.CipherSuiteName(r.TLS.CipherSuite))
+	}
+	if e.device {
+		set(HeaderDeviceClass, deviceClass(r.UserAgent()))
+	}
+	if e.subject {
+		set(HeaderSubject, requestSubject(r))
+	}
+}
+
+// remoteAddr returns the client address of a request
+func remoteAddr(r *http.Request) (netip.Addr, bool) {
+	if addrPort, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+	addr, err := netip.ParseAddr(r.RemoteAddr)
+	return addr.Unmap(), err == nil
+}
+
+// deviceClass classifies a User-Agent. It looks for the tokens browsers and
+// crawlers conventionally send rather than parsing the full string.
+func deviceClass(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	switch {
+	case ua == "":
+		return DeviceUnknown
+	case containsAny(ua, "bot", "crawler", "spider", "slurp", "curl/", "wget/", "python-requests", "go-http-client"):
+		return DeviceBot
+	case containsAny(ua, "ipad", "tablet", "kindle", "silk/") ||
+		strings.Contains(ua, "android") && !strings.Contains(ua, "mobile"):
+		return DeviceTablet
+	case containsAny(ua, "mobi", "iphone", "ipod", "android", "windows phone"):
+		return DeviceMobile
+	case containsAny(ua, "windows", "macintosh", "x11", "linux", "cros"):
+		return DeviceDesktop
+	default:
+		return DeviceUnknown
+	}
+}
+
+// containsAny reports whether s contains any of substrs
+func containsAny(s string, substrs ...string) bool {
+	for _, sub := range substrs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// GeoRange maps an address range to what is known about it
+type GeoRange struct {
+	CIDR string `json:"cidr"`
+	GeoInfo
+}
+
+// GeoRanges is a GeoLookup over a static table of address ranges; the most
+// specific matching range wins
+type GeoRanges struct {
+	prefixes []netip.Prefix
+	info     []GeoInfo
+}
+
+// NewGeoRanges validates and indexes a table of address ranges
+func NewGeoRanges(ranges []GeoRange) (*GeoRanges, error) {
+	sorted := make([]GeoRange, len(ranges))
+	copy(sorted, ranges)
+	parsed := make(map[string]netip.Prefix, len(ranges))
+	for _, rng := range sorted {
+		prefix, err := netip.ParsePrefix(rng.CIDR)
+		if err != nil {
+			return nil, fmt.Errorf("invalid geo range %q: %w", rng.CIDR, err)
+		}
+		parsed[rng.CIDR] = prefix.Masked()
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return parsed[sorted[i].CIDR].Bits() > parsed[sorted[j].CIDR].Bits()
+	})
+
+	g := &GeoRanges{}
+	for _, rng := range sorted {
+		g.prefixes = append(g.prefixes, parsed[rng.CIDR])
+		g.info = append(g.info, rng.GeoInfo)
+	}
+	return g, nil
+}
+
+// LoadGeoRanges reads a JSON array of GeoRange
+func LoadGeoRanges(path string) (*GeoRanges, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read geo ranges: %w", err)
+	}
+
+	var ranges []GeoRange
+	if err := json.Unmarshal(data, &ranges); err != nil {
+		return nil, fmt.Errorf("failed to parse geo ranges: %w", err)
+	}
+	return NewGeoRanges(ranges)
+}
+
+// Lookup returns the most specific range containing addr
+func (g *GeoRanges) Lookup(addr netip.Addr) (GeoInfo, bool) {
+	for i, prefix := range g.prefixes {
+		if prefix.Contains(addr) {
+			return g.info[i], true
+		}
+	}
+	return GeoInfo{}, false
+}
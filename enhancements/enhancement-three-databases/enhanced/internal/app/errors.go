@@ -0,0 +1,293 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Error response formats
+const (
+	ErrorFormatJSON = "json"
+	ErrorFormatHTML = "html"
+)
+
+// ErrorCode classifies an error response so clients can branch on it
+// without parsing messages. Error responses are counted by code in the
+// request metrics.
+type ErrorCode struct {
+	Name   string
+	Status int
+	// Retryable reports whether the same request may succeed later
+	Retryable bool
+}
+
+// Error codes for errors produced by the proxy itself
+var (
+	CodeBadRequest          = ErrorCode{Name: "bad_request", Status: http.StatusBadRequest}
+	CodeSchemaViolation     = ErrorCode{Name: "schema_violation", Status: http.StatusBadRequest}
+	CodeInvalidSignature    = ErrorCode{Name: "invalid_signature", Status: http.StatusUnauthorized}
+	CodeClientCertRequired  = ErrorCode{Name: "client_certificate_required", Status: http.StatusForbidden}
+	CodeClientBanned        = ErrorCode{Name: "client_banned", Status: http.StatusForbidden, Retryable: true}
+	CodeInvalidSignedURL    = ErrorCode{Name: "invalid_signed_url", Status: http.StatusForbidden}
+	CodeSignedURLExpired    = ErrorCode{Name: "signed_url_expired", Status: http.StatusForbidden}
+	CodeBotBlocked          = ErrorCode{Name: "bot_blocked", Status: http.StatusForbidden}
+	CodeNoRoute             = ErrorCode{Name: "no_route", Status: http.StatusNotFound}
+	CodeMisdirectedRequest  = ErrorCode{Name: "misdirected_request", Status: http.StatusMisdirectedRequest}
+	CodeMethodNotAllowed    = ErrorCode{Name: "method_not_allowed", Status: http.StatusMethodNotAllowed}
+	CodeBodyTooLarge        = ErrorCode{Name: "body_too_large", Status: http.StatusRequestEntityTooLarge}
+	CodeUnsupportedEncoding = ErrorCode{Name: "unsupported_encoding", Status: http.StatusUnsupportedMediaType}
+	CodeTLSRequired         = ErrorCode{Name: "tls_required", Status: http.StatusUpgradeRequired}
+	CodeRateLimited         = ErrorCode{Name: "rate_limited", Status: http.StatusTooManyRequests, Retryable: true}
+	CodeTooManyStreams      = ErrorCode{Name: "too_many_concurrent_requests", Status: http.StatusTooManyRequests, Retryable: true}
+	CodeHeadersTooLarge     = ErrorCode{Name: "headers_too_large", Status: http.StatusRequestHeaderFieldsTooLarge}
+	CodeInternal            = ErrorCode{Name: "internal_error", Status: http.StatusInternalServerError}
+	CodeUpstreamError       = ErrorCode{Name: "upstream_error", Status: http.StatusBadGateway, Retryable: true}
+	CodeUpstreamRejected    = ErrorCode{Name: "upstream_response_rejected", Status: http.StatusBadGateway}
+	CodeNoHealthyBackends   = ErrorCode{Name: "no_healthy_backends", Status: http.StatusServiceUnavailable, Retryable: true}
+	CodeBreakerOpen         = ErrorCode{Name: "breaker_open", Status: http.StatusServiceUnavailable, Retryable: true}
+	CodeOverloaded          = ErrorCode{Name: "overloaded", Status: http.StatusServiceUnavailable, Retryable: true}
+	CodeUpstreamTimeout     = ErrorCode{Name: "upstream_timeout", Status: http.StatusGatewayTimeout, Retryable: true}
+)
+
+// codeForStatus is the generic code for a status chosen at runtime, such as
+// by a plugin or a gRPC status mapping
+func codeForStatus(status int) ErrorCode {
+	name := "error"
+	if text := http.StatusText(status); text != "" {
+		name = strings.ReplaceAll(strings.ToLower(text), " ", "_")
+	}
+	return ErrorCode{
+		Name:      name,
+		Status:    status,
+		Retryable: status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable,
+	}
+}
+
+// routingErrorCode maps a ResolveBackend error to its code
+func routingErrorCode(err error) ErrorCode {
+	switch {
+	case errors.Is(err, ErrNoRoute):
+		return CodeNoRoute
+	case errors.Is(err, ErrBreakerOpen):
+		return CodeBreakerOpen
+	default:
+		return CodeNoHealthyBackends
+	}
+}
+
+// writeUpstreamError answers a request whose backend call failed: 504 when
+// the backend timed out, otherwise 502
+func (app *Application) writeUpstreamError(w http.ResponseWriter, r *http.Request, err error) {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		app.writeError(w, r, CodeUpstreamTimeout, "the backend did not respond in time")
+		return
+	}
+	app.writeError(w, r, CodeUpstreamError, "the backend request failed")
+}
+
+// ErrorPageConfig is the on-disk error page configuration
+type ErrorPageConfig struct {
+	// Routes maps a route prefix to the error format used for it ("json" or
+	// "html"); unmatched requests negotiate on the Accept header
+	Routes map[string]string `json:"routes"`
+	// Templates maps a status code (or "default") to an HTML template file
+	Templates map[string]string `json:"templates"`
+	// RetryAfter maps a status code to a Retry-After hint in seconds
+	RetryAfter map[string]int `json:"retry_after"`
+}
+
+// ErrorPages renders error responses as JSON envelopes or HTML pages
+type ErrorPages struct {
+	routes     map[string]string
+	templates  map[int]*template.Template
+	fallback   *template.Template
+	retryAfter map[int]int
+}
+
+// ErrorPageData is passed to HTML error templates
+type ErrorPageData struct {
+	Status     int
+	StatusText string
+	Code       string
+	Message    string
+	RequestID  string
+	Retryable  bool
+	RetryAfter int
+	Details    []string
+}
+
+// ErrorEnvelope is the JSON error body returned to clients
+type ErrorEnvelope struct {
+	Error ErrorBody `json:"error"`
+}
+
+// ErrorBody describes an error in a JSON envelope
+type ErrorBody struct {
+	Status     int      `json:"status"`
+	Code       string   `json:"code"`
+	Message    string   `json:"message"`
+	RequestID  string   `json:"request_id,omitempty"`
+	Retryable  bool     `json:"retryable"`
+	RetryAfter int      `json:"retry_after,omitempty"`
+	Details    []string `json:"details,omitempty"`
+}
+
+var defaultErrorTemplate = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><title>{{.Status}} {{.StatusText}}</title></head>
+<body>
+<h1>{{.Status}} {{.StatusText}}</h1>
+<p>{{.Message}}</p>
+{{if .Details}}<ul>{{range .Details}}<li>{{.}}</li>{{end}}</ul>{{end}}
+{{if .RetryAfter}}<p>Please try again in {{.RetryAfter}} seconds.</p>{{end}}
+{{if .RequestID}}<p><small>Request ID: {{.RequestID}}</small></p>{{end}}
+</body>
+</html>
+`))
+
+// NewErrorPages creates error pages with default templates and retry hints
+func NewErrorPages() *ErrorPages {
+	return &ErrorPages{
+		routes:    make(map[string]string),
+		templates: make(map[int]*template.Template),
+		fallback:  defaultErrorTemplate,
+		retryAfter: map[int]int{
+			http.StatusTooManyRequests:    1,
+			http.StatusServiceUnavailable: 5,
+		},
+	}
+}
+
+// LoadErrorPages builds error pages from a JSON config file
+func LoadErrorPages(path string) (*ErrorPages, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read error page config: %w", err)
+	}
+
+	var cfg ErrorPageConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse error page config: %w", err)
+	}
+
+	pages := NewErrorPages()
+
+	for prefix, format := range cfg.Routes {
+		if format != ErrorFormatJSON && format != ErrorFormatHTML {
+			return nil, fmt.Errorf("unknown error format %q for %s", format, prefix)
+		}
+		pages.routes[prefix] = format
+	}
+
+	for key, file := range cfg.Templates {
+		tmpl, err := template.ParseFiles(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse error template %s: %w", file, err)
+		}
+		if key == "default" {
+			pages.fallback = tmpl
+			continue
+		}
+		status, err := strconv.Atoi(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid status code %q in templates", key)
+		}
+		pages.templates[status] = tmpl
+	}
+
+	for key, seconds := range cfg.RetryAfter {
+		status, err := strconv.Atoi(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid status code %q in retry_after", key)
+		}
+		pages.retryAfter[status] = seconds
+	}
+
+	return pages, nil
+}
+
+// format picks JSON or HTML for a request based on its route and Accept header
+func (ep *ErrorPages) format(r *http.Request) string {
+	longest := ""
+	for prefix := range ep.routes {
+		if strings.HasPrefix(r.URL.Path, prefix) && len(prefix) > len(longest) {
+			longest = prefix
+		}
+	}
+	if longest != "" {
+		return ep.routes[longest]
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		return ErrorFormatHTML
+	}
+	return ErrorFormatJSON
+}
+
+// writeError writes an error response for code in the format configured for
+// the route, including the request ID and a Retry-After hint where configured
+func (app *Application) writeError(w http.ResponseWriter, r *http.Request, code ErrorCode, message string) {
+	app.writeErrorDetails(w, r, code, message, nil)
+}
+
+// writeErrorDetails is writeError with a list of detailed error messages,
+// such as schema violations
+func (app *Application) writeErrorDetails(w http.ResponseWriter, r *http.Request, code ErrorCode, message string, details []string) {
+	ep := app.ErrorPages
+	status := code.Status
+	app.Counters.countError(code.Name)
+
+	data := ErrorPageData{
+		Status:     status,
+		StatusText: http.StatusText(status),
+		Code:       code.Name,
+		Message:    message,
+		RequestID:  RequestIDFromContext(r.Context()),
+		Retryable:  code.Retryable,
+		RetryAfter: ep.retryAfter[status],
+		Details:    details,
+	}
+
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Cache-Control", "no-store")
+	h.Set("X-Content-Type-Options", "nosniff")
+	// A Retry-After set by the caller, such as a breaker's remaining
+	// cooldown, takes precedence over the configured hint
+	if seconds, err := strconv.Atoi(h.Get("Retry-After")); err == nil {
+		data.RetryAfter = seconds
+	} else if data.RetryAfter > 0 {
+		h.Set("Retry-After", strconv.Itoa(data.RetryAfter))
+	}
+
+	if ep.format(r) == ErrorFormatHTML {
+		tmpl, exists := ep.templates[status]
+		if !exists {
+			tmpl = ep.fallback
+		}
+		h.Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+		if err := tmpl.Execute(w, data); err != nil {
+			app.Logger.ErrorContext(r.Context(), "failed to render error template", "status", status, "error", err)
+		}
+		return
+	}
+
+	writeJSON(w, status, ErrorEnvelope{Error: ErrorBody{
+		Status:     data.Status,
+		Code:       data.Code,
+		Message:    data.Message,
+		RequestID:  data.RequestID,
+		Retryable:  data.Retryable,
+		RetryAfter: data.RetryAfter,
+		Details:    data.Details,
+	}})
+}
@@ -0,0 +1,210 @@
+package app
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codytheroux96/go-reverse-proxy/internal/registry"
+)
+
+// EventWebhookTimeout bounds each event webhook delivery
+const EventWebhookTimeout = 5 * time.Second
+
+// EventTypeStats counts the events of one type
+type EventTypeStats struct {
+	Count uint64    `json:"count"`
+	Last  time.Time `json:"last"`
+}
+
+// EventMetrics counts published events by type
+type EventMetrics struct {
+	mu     sync.Mutex
+	counts map[EventType]*EventTypeStats
+}
+
+// NewEventMetrics creates an empty event counter
+func NewEventMetrics() *EventMetrics {
+	return &EventMetrics{counts: make(map[EventType]*EventTypeStats)}
+}
+
+// Observe counts an event
+func (em *EventMetrics) Observe(e Event) {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+
+	stats, exists := em.counts[e.Type]
+	if !exists {
+		stats = &EventTypeStats{}
+		em.counts[e.Type] = stats
+	}
+	stats.Count++
+	stats.Last = e.Time
+}
+
+// Snapshot returns the counts for every event type
+func (em *EventMetrics) Snapshot() map[EventType]EventTypeStats {
+	em.mu.Lock()
+	defer em.mu.Unlock()
+
+	result := make(map[EventType]EventTypeStats, len(EventTypes))
+	for _, t := range EventTypes {
+		result[t] = EventTypeStats{}
+	}
+	for t, stats := range em.counts {
+		result[t] = *stats
+	}
+	return result
+}
+
+// EventSink receives every published event
+type EventSink interface {
+	Name() string
+	Handle(e Event)
+}
+
+// LogEventSink writes events to a logger
+type LogEventSink struct {
+	logger *slog.Logger
+}
+
+// NewLogEventSink creates a sink logging events at info level
+func NewLogEventSink(logger *slog.Logger) *LogEventSink {
+	return &LogEventSink{logger: logger}
+}
+
+func (s *LogEventSink) Name() string { return "log" }
+
+func (s *LogEventSink) Handle(e Event) {
+	s.logger.Info("event", "type", e.Type, "server", e.Server, "from", e.From, "to", e.To, "data", e.Data)
+}
+
+// WebhookEventSink POSTs each event as JSON to a URL
+type WebhookEventSink struct {
+	url    string
+	client *http.Client
+	logger *slog.Logger
+}
+
+// NewWebhookEventSink creates a sink delivering events to url
+func NewWebhookEventSink(url string, logger *slog.Logger) *WebhookEventSink {
+	return &WebhookEventSink{
+		url:    url,
+		client: &http.Client{Timeout: EventWebhookTimeout},
+		logger: logger,
+	}
+}
+
+func (s *WebhookEventSink) Name() string { return "webhook:" + s.url }
+
+func (s *WebhookEventSink) Handle(e Event) {
+	if err := postJSON(s.client, s.url, "application/json", e); err != nil {
+		s.logger.Warn("event webhook delivery failed", "url", s.url, "type", e.Type, "error", err)
+	}
+}
+
+// ParseEventSinks parses a comma separated event sink spec such as
+// "log,webhook:https://hooks.example.com/proxy"
+func ParseEventSinks(spec string, logger *slog.Logger) ([]EventSink, error) {
+	var sinks []EventSink
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		kind, target, _ := strings.Cut(part, ":")
+
+		switch kind {
+		case "log":
+			sinks = append(sinks, NewLogEventSink(logger))
+
+		case "webhook":
+			if target == "" {
+				return nil, fmt.Errorf("webhook sink requires a URL")
+			}
+			sinks = append(sinks, NewWebhookEventSink(target, logger))
+
+		default:
+			return nil, fmt.Errorf("unknown event sink %q", kind)
+		}
+	}
+
+	return sinks, nil
+}
+
+// ConfigureEventSinks subscribes external sinks to every event
+func (app *Application) ConfigureEventSinks(spec string) error {
+	sinks, err := ParseEventSinks(spec, app.Logger)
+	if err != nil {
+		return err
+	}
+
+	for _, sink := range sinks {
+		app.Events.Subscribe(sink.Name(), sink.Handle)
+	}
+	return nil
+}
+
+// subscribeRegistry publishes registry changes as events, clears remembered
+// route misses, and drops the health, breaker and metrics state of
+// deregistered backends. The state is dropped in the registry's callback,
+// before the change is published, since the event bus may drop events and
+// delivers them after a later registration under the same name.
+func (app *Application) subscribeRegistry() {
+	if notifier, ok := app.Registry.(interface{ OnChange(func(registry.Change)) }); ok {
+		notifier.OnChange(func(c registry.Change) {
+			// A new route may match paths that just missed
+			if app.RouteMisses != nil {
+				app.RouteMisses.Clear()
+			}
+			if c.Kind == registry.ChangeDeregistered {
+				app.forgetBackend(c.Server.Name)
+			}
+			e := Event{Type: EventServerRegistered, Server: c.Server.Name}
+			if c.Kind == registry.ChangeDeregistered {
+				e.Type = EventServerDeregistered
+			}
+			if c.Server.BaseURL != "" {
+				e.Data = map[string]interface{}{"base_url": c.Server.BaseURL, "routes": c.Server.Prefixes}
+			}
+			app.Events.Publish(e)
+		})
+	}
+}
+
+// forgetBackend drops the health, breaker and metrics state of a
+// deregistered backend
+func (app *Application) forgetBackend(name string) {
+	app.HealthMonitor.RemoveServer(name)
+	app.CircuitBreaker.RemoveBreaker(name)
+	app.Latency.RemoveBackend(name)
+	app.UpstreamTiming.RemoveBackend(name)
+	app.UpstreamErrors.RemoveBackend(name)
+	if app.HealthWeights != nil {
+		app.HealthWeights.remove(name)
+	}
+}
+
+// configReloaded publishes a config change
+func (app *Application) configReloaded(config string, data map[string]interface{}) {
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+	data["config"] = config
+	app.Events.Publish(Event{Type: EventConfigReloaded, Data: data})
+}
+
+// HandleEventMetrics serves GET /admin/metrics/events
+func (app *Application) HandleEventMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, app.EventMetrics.Snapshot())
+}
@@ -0,0 +1,38 @@
+package app
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/codytheroux96/go-reverse-proxy/internal/registry"
+)
+
+func TestDeregisterDropsBackendStateSynchronously(t *testing.T) {
+	app := newTestApp(t)
+	backend := addTestBackend(t, app, "api", func(w http.ResponseWriter, r *http.Request) {}, "/api")
+	app.CircuitBreaker.OpenBreaker("api", "test")
+
+	if err := app.Registry.Deregister("api"); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := app.HealthMonitor.GetHealthStatus("api"); exists {
+		t.Error("health status kept after deregistration")
+	}
+	if _, exists := app.CircuitBreaker.GetBreakerInfo("api"); exists {
+		t.Error("breaker kept after deregistration")
+	}
+
+	// State built up by a backend registered again under the same name
+	// must not be dropped by the earlier deregistration
+	if err := app.Registry.Register(registry.Server{Name: "api", BaseURL: backend.URL, Prefixes: []string{"/api"}}); err != nil {
+		t.Fatal(err)
+	}
+	app.HealthMonitor.CheckAll(t.Context())
+	app.CircuitBreaker.OpenBreaker("api", "test")
+	if _, exists := app.HealthMonitor.GetHealthStatus("api"); !exists {
+		t.Error("health status of the re-registered backend was dropped")
+	}
+	if state := app.CircuitBreaker.GetBreakerState("api"); state != Open {
+		t.Errorf("breaker of the re-registered backend is %s, want Open", state)
+	}
+}
@@ -0,0 +1,168 @@
+package app
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// EventType identifies what an event reports
+type EventType string
+
+const (
+	// EventHealthChanged reports a backend turning healthy or unhealthy
+	EventHealthChanged EventType = "health_changed"
+	// EventLivenessChanged reports a backend process going down or coming
+	// back, whether or not it was ready for traffic
+	EventLivenessChanged EventType = "liveness_changed"
+	// EventBackendRecovered reports a backend passing RecoveryThreshold
+	// consecutive health checks
+	EventBackendRecovered EventType = "backend_recovered"
+	// EventBreakerChanged reports a circuit breaker state transition
+	EventBreakerChanged EventType = "breaker_changed"
+	// EventServerRegistered reports a backend added to the registry
+	EventServerRegistered EventType = "server_registered"
+	// EventServerDeregistered reports a backend removed from the registry
+	EventServerDeregistered EventType = "server_deregistered"
+	// EventServerDraining reports a backend taken out of selection while its
+	// in-flight requests complete, ahead of its deregistration
+	EventServerDraining EventType = "server_draining"
+	// EventConfigReloaded reports a configuration change taking effect; Data
+	// names the config
+	EventConfigReloaded EventType = "config_reloaded"
+	// EventCachePurged reports cached responses being purged
+	EventCachePurged EventType = "cache_purged"
+	// EventLeadershipChanged reports this instance becoming leader or follower
+	EventLeadershipChanged EventType = "leadership_changed"
+	// EventTrafficRamp reports a traffic ramp starting, advancing, pausing,
+	// rolling back or completing; Data holds its prefix, state and weight
+	EventTrafficRamp EventType = "traffic_ramp"
+	// EventStagesShed reports optional stages being skipped under load or
+	// running again; Data holds the stages and the load that triggered it
+	EventStagesShed EventType = "stages_shed"
+)
+
+// EventTypes lists every event type
+var EventTypes = []EventType{
+	EventHealthChanged,
+	EventLivenessChanged,
+	EventBackendRecovered,
+	EventBreakerChanged,
+	EventServerRegistered,
+	EventServerDeregistered,
+	EventServerDraining,
+	EventConfigReloaded,
+	EventCachePurged,
+	EventLeadershipChanged,
+	EventTrafficRamp,
+	EventStagesShed,
+}
+
+// EventBufferSize is how many events a subscriber may fall behind by before
+// further events to it are dropped
+const EventBufferSize = 256
+
+// Event is something that happened in one subsystem that others may react to
+type Event struct {
+	Type   EventType `json:"type"`
+	Time   time.Time `json:"time"`
+	Server string    `json:"server,omitempty"`
+	From   string    `json:"from,omitempty"`
+	To     string    `json:"to,omitempty"`
+	// Data holds details specific to the event type
+	Data map[string]interface{} `json:"data,omitempty"`
+}
+
+// eventSubscription delivers events of some types to one handler
+type eventSubscription struct {
+	name    string
+	types   map[EventType]bool
+	events  chan Event
+	handler func(Event)
+}
+
+// EventBus delivers events from the subsystems that publish them to the
+// subsystems that subscribe to them. Each subscriber receives its events in
+// order on its own goroutine, so publishers never block on, or hold locks
+// while running, another subsystem's handler.
+type EventBus struct {
+	mu     sync.RWMutex
+	subs   []*eventSubscription
+	logger *slog.Logger
+	done   chan struct{}
+	closed bool
+}
+
+// NewEventBus creates an event bus
+func NewEventBus(logger *slog.Logger) *EventBus {
+	return &EventBus{logger: logger, done: make(chan struct{})}
+}
+
+// Subscribe runs handler for every published event of the given types, or
+// of every type when none are given
+func (eb *EventBus) Subscribe(name string, handler func(Event), types ...EventType) {
+	sub := &eventSubscription{
+		name:    name,
+		types:   make(map[EventType]bool),
+		events:  make(chan Event, EventBufferSize),
+		handler: handler,
+	}
+	for _, t := range types {
+		sub.types[t] = true
+	}
+
+	eb.mu.Lock()
+	eb.subs = append(eb.subs, sub)
+	eb.mu.Unlock()
+
+	go func() {
+		for {
+			select {
+			case <-eb.done:
+				return
+			case e := <-sub.events:
+				sub.handler(e)
+			}
+		}
+	}()
+}
+
+// Publish delivers an event to its subscribers without waiting for them. A
+// nil bus discards events.
+func (eb *EventBus) Publish(e Event) {
+	if eb == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	eb.mu.RLock()
+	defer eb.mu.RUnlock()
+
+	if eb.closed {
+		return
+	}
+
+	for _, sub := range eb.subs {
+		if len(sub.types) > 0 && !sub.types[e.Type] {
+			continue
+		}
+		select {
+		case sub.events <- e:
+		default:
+			eb.logger.Warn("event subscriber is behind, dropping event", "subscriber", sub.name, "type", e.Type)
+		}
+	}
+}
+
+// Close stops delivering events
+func (eb *EventBus) Close() {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+
+	if !eb.closed {
+		eb.closed = true
+		close(eb.done)
+	}
+}
@@ -0,0 +1,343 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ExperimentHeader tags requests toward backends and responses toward
+// clients with "<experiment>=<variant>"
+const ExperimentHeader = "X-Experiment"
+
+// ExperimentCookieMaxAge is how long a client keeps its assigned variant
+const ExperimentCookieMaxAge = 30 * 24 * time.Hour
+
+// ExperimentVariant is one arm of an experiment, served by its own servers
+type ExperimentVariant struct {
+	Name string `json:"name"`
+	// Weight is the variant's share of assignments relative to the others
+	Weight int `json:"weight"`
+	// Servers are the registered servers the variant's requests go to
+	Servers []string `json:"servers"`
+}
+
+// Experiment splits a route's requests between variants. A request is
+// assigned by a hash of its UserHeader value when present, so a user keeps
+// their variant across devices; otherwise by its experiment cookie, which is
+// set on first assignment.
+type Experiment struct {
+	Name   string `json:"name"`
+	Prefix string `json:"prefix"`
+	// Cookie holds a client's variant; it defaults to "exp_<name>"
+	Cookie string `json:"cookie,omitempty"`
+	// UserHeader names a header carrying a user ID, such as X-User-ID
+	UserHeader string              `json:"user_header,omitempty"`
+	Variants   []ExperimentVariant `json:"variants"`
+}
+
+// validate checks the experiment's variants and fills in its cookie
+func (e *Experiment) validate() error {
+	if e.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if !strings.HasPrefix(e.Prefix, "/") {
+		return fmt.Errorf("prefix %q must start with /", e.Prefix)
+	}
+	if e.Cookie == "" {
+		e.Cookie = "exp_" + e.Name
+	}
+	if len(e.Variants) < 2 {
+		return fmt.Errorf("at least two variants are required")
+	}
+
+	names := make(map[string]bool)
+	owner := make(map[string]string)
+	total := 0
+	for _, variant := range e.Variants {
+		if variant.Name == "" || strings.ContainsAny(variant.Name, "=;, ") {
+			return fmt.Errorf("invalid variant name %q", variant.Name)
+		}
+		if names[variant.Name] {
+			return fmt.Errorf("duplicate variant %q", variant.Name)
+		}
+		names[variant.Name] = true
+		if variant.Weight < 0 {
+			return fmt.Errorf("variant %q has a negative weight", variant.Name)
+		}
+		total += variant.Weight
+		if len(variant.Servers) == 0 {
+			return fmt.Errorf("variant %q has no servers", variant.Name)
+		}
+		for _, server := range variant.Servers {
+			if other, taken := owner[server]; taken {
+				return fmt.Errorf("server %q is in variants %q and %q", server, other, variant.Name)
+			}
+			owner[server] = variant.Name
+		}
+	}
+	if total == 0 {
+		return fmt.Errorf("at least one variant needs a weight")
+	}
+	return nil
+}
+
+// experiment is a validated Experiment with its assignment counters
+type experiment struct {
+	Experiment
+	totalWeight int
+	servers     []map[string]bool
+	assigned    []atomic.Uint64
+}
+
+// ExperimentStatus reports an experiment and how many requests each variant
+// has been assigned
+type ExperimentStatus struct {
+	Experiment
+	Assigned map[string]uint64 `json:"assigned"`
+}
+
+// Experiments holds the A/B experiments by name
+type Experiments struct {
+	mu          sync.RWMutex
+	experiments map[string]*experiment
+}
+
+// NewExperiments creates an empty set of experiments
+func NewExperiments() *Experiments {
+	return &Experiments{experiments: make(map[string]*experiment)}
+}
+
+// LoadExperiments reads a JSON array of experiments
+func LoadExperiments(path string) ([]Experiment, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read experiments: %w", err)
+	}
+
+	var experiments []Experiment
+	if err := json.Unmarshal(data, &experiments); err != nil {
+		return nil, fmt.Errorf("failed to parse experiments: %w", err)
+	}
+	return experiments, nil
+}
+
+// Set adds or replaces an experiment; replacing one resets its counters
+func (ex *Experiments) Set(e Experiment) (Experiment, error) {
+	if err := e.validate(); err != nil {
+		return Experiment{}, err
+	}
+
+	compiled := &experiment{
+		Experiment: e,
+		servers:    make([]map[string]bool, len(e.Variants)),
+		assigned:   make([]atomic.Uint64, len(e.Variants)),
+	}
+	for i, variant := range e.Variants {
+		compiled.totalWeight += variant.Weight
+		compiled.servers[i] = make(map[string]bool, len(variant.Servers))
+		for _, server := range variant.Servers {
+			compiled.servers[i][server] = true
+		}
+	}
+
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+
+	for name, other := range ex.experiments {
+		if name != e.Name && other.Prefix == e.Prefix {
+			return Experiment{}, fmt.Errorf("experiment %q already runs on %s", name, e.Prefix)
+		}
+	}
+	ex.experiments[e.Name] = compiled
+	return e, nil
+}
+
+// Remove drops an experiment and reports whether it existed
+func (ex *Experiments) Remove(name string) bool {
+	ex.mu.Lock()
+	defer ex.mu.Unlock()
+
+	_, exists := ex.experiments[name]
+	delete(ex.experiments, name)
+	return exists
+}
+
+// List returns every experiment with its assignment counts, ordered by name
+func (ex *Experiments) List() []ExperimentStatus {
+	ex.mu.RLock()
+	defer ex.mu.RUnlock()
+
+	statuses := make([]ExperimentStatus, 0, len(ex.experiments))
+	for _, e := range ex.experiments {
+		assigned := make(map[string]uint64, len(e.Variants))
+		for i, variant := range e.Variants {
+			assigned[variant.Name] = e.assigned[i].Load()
+		}
+		statuses = append(statuses, ExperimentStatus{Experiment: e.Experiment, Assigned: assigned})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// match returns the experiment on the longest prefix of path
+func (ex *Experiments) match(path string) *experiment {
+	ex.mu.RLock()
+	defer ex.mu.RUnlock()
+
+	var best *experiment
+	for _, e := range ex.experiments {
+		if strings.HasPrefix(path, e.Prefix) && (best == nil || len(e.Prefix) > len(best.Prefix)) {
+			best = e
+		}
+	}
+	return best
+}
+
+// pick returns the variant at a point in [0, totalWeight)
+func (e *experiment) pick(point int) int {
+	for i, variant := range e.Variants {
+		if point < variant.Weight {
+			return i
+		}
+		point -= variant.Weight
+	}
+	return len(e.Variants) - 1
+}
+
+// variant returns the index of a named variant
+func (e *experiment) variant(name string) (int, bool) {
+	for i, variant := range e.Variants {
+		if variant.Name == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// assign picks a request's variant and reports whether the client needs an
+// experiment cookie to keep it
+func (e *experiment) assign(r *http.Request) (int, bool) {
+	if e.UserHeader != "" {
+		if user := r.Header.Get(e.UserHeader); user != "" {
+			sum := sha256.Sum256([]byte(e.Name + "\n" + user))
+			return e.pick(int(binary.BigEndian.Uint64(sum[:8]) % uint64(e.totalWeight))), false
+		}
+	}
+
+	if cookie, err := r.Cookie(e.Cookie); err == nil {
+		if i, ok := e.variant(cookie.Value); ok {
+			return i, false
+		}
+	}
+	return e.pick(rand.IntN(e.totalWeight)), true
+}
+
+// experimentKey is the context key for a request's experiment assignment
+type experimentKey struct{}
+
+// experimentAssignment is the variant a request was assigned
+type experimentAssignment struct {
+	experiment string
+	variant    string
+	prefix     string
+	servers    map[string]bool
+}
+
+// tag is the ExperimentHeader value for the assignment
+func (a *experimentAssignment) tag() string {
+	return a.experiment + "=" + a.variant
+}
+
+// experimentFromContext returns the request's experiment assignment, if any
+func experimentFromContext(ctx context.Context) *experimentAssignment {
+	assignment, _ := ctx.Value(experimentKey{}).(*experimentAssignment)
+	return assignment
+}
+
+// assignExperiment assigns a request on an experiment's route to a variant,
+// tags the request and response with it, and returns the request carrying
+// the assignment for routing and cache keys
+func (app *Application) assignExperiment(w http.ResponseWriter, r *http.Request) *http.Request {
+	e := app.Experiments.match(r.URL.Path)
+	if e == nil {
+		return r
+	}
+
+	i, setCookie := e.assign(r)
+	e.assigned[i].Add(1)
+
+	assignment := &experimentAssignment{
+		experiment: e.Name,
+		variant:    e.Variants[i].Name,
+		prefix:     e.Prefix,
+		servers:    e.servers[i],
+	}
+	if setCookie {
+		http.SetCookie(w, &http.Cookie{
+			Name:     e.Cookie,
+			Value:    assignment.variant,
+			Path:     e.Prefix,
+			MaxAge:   int(ExperimentCookieMaxAge.Seconds()),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+
+	r.Header.Set(ExperimentHeader, assignment.tag())
+	w.Header().Set(ExperimentHeader, assignment.tag())
+	app.Logger.DebugContext(r.Context(), "experiment variant assigned",
+		"experiment", e.Name, "variant", assignment.variant, "new", setCookie)
+
+	return r.WithContext(context.WithValue(r.Context(), experimentKey{}, assignment))
+}
+
+// HandleExperiments serves GET, PUT and DELETE /admin/experiments. GET lists
+// the experiments with their assignment counts, PUT adds or replaces one and
+// DELETE removes one with ?name=.
+func (app *Application) HandleExperiments(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]interface{}{"experiments": app.Experiments.List()})
+
+	case http.MethodPut:
+		var e Experiment
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			http.Error(w, "invalid payload in request", http.StatusBadRequest)
+			return
+		}
+		e, err := app.Experiments.Set(e)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		app.Logger.InfoContext(r.Context(), "experiment set", "name", e.Name, "prefix", e.Prefix)
+		app.configReloaded("experiments", map[string]interface{}{"name": e.Name, "prefix": e.Prefix})
+		writeJSON(w, http.StatusOK, e)
+
+	case http.MethodDelete:
+		name := r.URL.Query().Get("name")
+		if !app.Experiments.Remove(name) {
+			http.Error(w, "experiment not found", http.StatusNotFound)
+			return
+		}
+
+		app.Logger.InfoContext(r.Context(), "experiment removed", "name", name)
+		app.configReloaded("experiments", map[string]interface{}{"name": name})
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "removed", "name": name})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,568 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// GRPCMetadataPrefix marks request headers forwarded to gRPC backends as
+// metadata, with the prefix removed
+const GRPCMetadataPrefix = "Grpc-Metadata-"
+
+// GRPCConfig is the on-disk JSON to gRPC translation config. Descriptors are
+// FileDescriptorSet files, as written by
+// `protoc --include_imports --descriptor_set_out=api.pb`; relative paths are
+// resolved against the config file's directory.
+type GRPCConfig struct {
+	Descriptors []string          `json:"descriptors"`
+	Routes      []GRPCRouteConfig `json:"routes"`
+}
+
+// GRPCRouteConfig maps an HTTP method and path template to a unary gRPC
+// method. Path parameters such as {id} or {user.id} and query parameters set
+// fields of the request message; Body selects what the JSON body decodes into:
+// "*" for the whole message, a field name, or "" for no body. Backend is
+// http://host:port for cleartext HTTP/2 or https://host:port for TLS.
+type GRPCRouteConfig struct {
+	HTTPMethod string `json:"http_method"`
+	Path       string `json:"path"`
+	Method     string `json:"method"`
+	Backend    string `json:"backend"`
+	Body       string `json:"body"`
+}
+
+// grpcRoute is a compiled translation route
+type grpcRoute struct {
+	httpMethod string
+	path       string
+	segments   []string
+	method     protoreflect.MethodDescriptor
+	target     string
+	backend    string
+	body       string
+}
+
+// GRPCTranslator serves REST/JSON requests from gRPC backends
+type GRPCTranslator struct {
+	routes []*grpcRoute
+	client *http.Client
+	tls    *http.Client
+	logger *slog.Logger
+}
+
+// LoadGRPCTranslator reads a translation config and its descriptor sets
+func LoadGRPCTranslator(path string, logger *slog.Logger) (*GRPCTranslator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read grpc config: %w", err)
+	}
+
+	var cfg GRPCConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse grpc config: %w", err)
+	}
+
+	set := &descriptorpb.FileDescriptorSet{}
+	for _, file := range cfg.Descriptors {
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(filepath.Dir(path), file)
+		}
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read descriptor set: %w", err)
+		}
+		var fds descriptorpb.FileDescriptorSet
+		if err := proto.Unmarshal(raw, &fds); err != nil {
+			return nil, fmt.Errorf("failed to parse descriptor set %s: %w", file, err)
+		}
+		set.File = append(set.File, fds.File...)
+	}
+
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, fmt.Errorf("invalid descriptor set: %w", err)
+	}
+
+	return NewGRPCTranslator(files, cfg.Routes, logger)
+}
+
+// NewGRPCTranslator compiles translation routes against a set of descriptors
+func NewGRPCTranslator(files *protoregistry.Files, routes []GRPCRouteConfig, logger *slog.Logger) (*GRPCTranslator, error) {
+	h2c := &http.Protocols{}
+	h2c.SetUnencryptedHTTP2(true)
+	h2 := &http.Protocols{}
+	h2.SetHTTP2(true)
+
+	gt := &GRPCTranslator{
+		client: &http.Client{Transport: &http.Transport{Protocols: h2c}},
+		tls:    &http.Client{Transport: &http.Transport{Protocols: h2}},
+		logger: logger,
+	}
+
+	for _, cfg := range routes {
+		route, err := compileGRPCRoute(files, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("grpc route %s %s: %w", cfg.HTTPMethod, cfg.Path, err)
+		}
+		gt.routes = append(gt.routes, route)
+	}
+
+	return gt, nil
+}
+
+func compileGRPCRoute(files *protoregistry.Files, cfg GRPCRouteConfig) (*grpcRoute, error) {
+	service, name, ok := strings.Cut(strings.TrimPrefix(cfg.Method, "/"), "/")
+	if !ok {
+		return nil, fmt.Errorf("method must be package.Service/Method, got %q", cfg.Method)
+	}
+
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil, fmt.Errorf("service %s not found in descriptors", service)
+	}
+	sd, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a service", service)
+	}
+	md := sd.Methods().ByName(protoreflect.Name(name))
+	if md == nil {
+		return nil, fmt.Errorf("method %s not found on %s", name, service)
+	}
+	if md.IsStreamingClient() || md.IsStreamingServer() {
+		return nil, fmt.Errorf("streaming method %s is not supported", cfg.Method)
+	}
+
+	u, err := url.Parse(cfg.Backend)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("backend must be http://host:port or https://host:port")
+	}
+
+	if !strings.HasPrefix(cfg.Path, "/") {
+		return nil, fmt.Errorf("path must start with /")
+	}
+
+	httpMethod := strings.ToUpper(cfg.HTTPMethod)
+	if httpMethod == "" {
+		httpMethod = http.MethodPost
+	}
+
+	route := &grpcRoute{
+		httpMethod: httpMethod,
+		path:       cfg.Path,
+		segments:   strings.Split(strings.Trim(cfg.Path, "/"), "/"),
+		method:     md,
+		target:     strings.TrimSuffix(cfg.Backend, "/") + "/" + string(sd.FullName()) + "/" + string(md.Name()),
+		backend:    u.Host,
+		body:       cfg.Body,
+	}
+
+	if route.body != "" && route.body != "*" {
+		fd := md.Input().Fields().ByName(protoreflect.Name(route.body))
+		if fd == nil || fd.Message() == nil {
+			return nil, fmt.Errorf("body field %q is not a message field of %s", route.body, md.Input().FullName())
+		}
+	}
+
+	for _, seg := range route.segments {
+		if field, ok := pathParam(seg); ok {
+			if _, err := lookupField(md.Input(), field); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return route, nil
+}
+
+// pathParam returns the field named by a {field} template segment
+func pathParam(segment string) (string, bool) {
+	if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+		return segment[1 : len(segment)-1], true
+	}
+	return "", false
+}
+
+// match reports whether a request matches the route, returning the path
+// parameters it binds
+func (gr *grpcRoute) match(method, path string) (map[string]string, bool) {
+	if method != gr.httpMethod {
+		return nil, false
+	}
+
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != len(gr.segments) {
+		return nil, false
+	}
+
+	params := make(map[string]string)
+	for i, seg := range gr.segments {
+		if field, ok := pathParam(seg); ok {
+			if parts[i] == "" {
+				return nil, false
+			}
+			params[field] = parts[i]
+			continue
+		}
+		if seg != parts[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// lookupField resolves a dotted field path such as user.id to the fields
+// along the way
+func lookupField(md protoreflect.MessageDescriptor, path string) ([]protoreflect.FieldDescriptor, error) {
+	var fields []protoreflect.FieldDescriptor
+	names := strings.Split(path, ".")
+
+	for i, name := range names {
+		fd := md.Fields().ByName(protoreflect.Name(name))
+		if fd == nil {
+			fd = md.Fields().ByJSONName(name)
+		}
+		if fd == nil {
+			return nil, fmt.Errorf("field %q not found in %s", path, md.FullName())
+		}
+		fields = append(fields, fd)
+
+		if i < len(names)-1 {
+			if fd.Message() == nil || fd.IsList() || fd.IsMap() {
+				return nil, fmt.Errorf("field %q is not a message in %s", name, md.FullName())
+			}
+			md = fd.Message()
+		}
+	}
+	return fields, nil
+}
+
+// setField sets a scalar field of msg, possibly nested, from its string form
+func setField(msg protoreflect.Message, path, value string) error {
+	fields, err := lookupField(msg.Descriptor(), path)
+	if err != nil {
+		return err
+	}
+
+	for _, fd := range fields[:len(fields)-1] {
+		msg = msg.Mutable(fd).Message()
+	}
+	fd := fields[len(fields)-1]
+	if fd.IsMap() || fd.Message() != nil {
+		return fmt.Errorf("field %q cannot be set from a string", path)
+	}
+
+	v, err := parseScalar(fd, value)
+	if err != nil {
+		return fmt.Errorf("field %q: %w", path, err)
+	}
+
+	if fd.IsList() {
+		msg.Mutable(fd).List().Append(v)
+		return nil
+	}
+	msg.Set(fd, v)
+	return nil
+}
+
+// parseScalar converts a path or query parameter to a field value
+func parseScalar(fd protoreflect.FieldDescriptor, s string) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(s), nil
+	case protoreflect.BytesKind:
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			b, err = base64.URLEncoding.DecodeString(s)
+		}
+		return protoreflect.ValueOfBytes(b), err
+	case protoreflect.BoolKind:
+		b, err := strconv.ParseBool(s)
+		return protoreflect.ValueOfBool(b), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		n, err := strconv.ParseInt(s, 10, 32)
+		return protoreflect.ValueOfInt32(int32(n)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		n, err := strconv.ParseInt(s, 10, 64)
+		return protoreflect.ValueOfInt64(n), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		n, err := strconv.ParseUint(s, 10, 32)
+		return protoreflect.ValueOfUint32(uint32(n)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		n, err := strconv.ParseUint(s, 10, 64)
+		return protoreflect.ValueOfUint64(n), err
+	case protoreflect.FloatKind:
+		n, err := strconv.ParseFloat(s, 32)
+		return protoreflect.ValueOfFloat32(float32(n)), err
+	case protoreflect.DoubleKind:
+		n, err := strconv.ParseFloat(s, 64)
+		return protoreflect.ValueOfFloat64(n), err
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByName(protoreflect.Name(s)); ev != nil {
+			return protoreflect.ValueOfEnum(ev.Number()), nil
+		}
+		n, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			return protoreflect.Value{}, fmt.Errorf("unknown enum value %q", s)
+		}
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(n)), nil
+	default:
+		return protoreflect.Value{}, fmt.Errorf("unsupported field kind %s", fd.Kind())
+	}
+}
+
+// grpcError is a non-OK status returned by a gRPC backend
+type grpcError struct {
+	code    int
+	message string
+}
+
+func (e *grpcError) Error() string {
+	return fmt.Sprintf("grpc status %d: %s", e.code, e.message)
+}
+
+// grpcHTTPStatus maps gRPC status codes to HTTP statuses the way grpc-gateway does
+var grpcHTTPStatus = map[int]int{
+	1:  StatusClientClosedRequest,      // CANCELLED
+	2:  http.StatusInternalServerError, // UNKNOWN
+	3:  http.StatusBadRequest,          // INVALID_ARGUMENT
+	4:  http.StatusGatewayTimeout,      // DEADLINE_EXCEEDED
+	5:  http.StatusNotFound,            // NOT_FOUND
+	6:  http.StatusConflict,            // ALREADY_EXISTS
+	7:  http.StatusForbidden,           // PERMISSION_DENIED
+	8:  http.StatusTooManyRequests,     // RESOURCE_EXHAUSTED
+	9:  http.StatusBadRequest,          // FAILED_PRECONDITION
+	10: http.StatusConflict,            // ABORTED
+	11: http.StatusBadRequest,          // OUT_OF_RANGE
+	12: http.StatusNotImplemented,      // UNIMPLEMENTED
+	13: http.StatusInternalServerError, // INTERNAL
+	14: http.StatusServiceUnavailable,  // UNAVAILABLE
+	15: http.StatusInternalServerError, // DATA_LOSS
+	16: http.StatusUnauthorized,        // UNAUTHENTICATED
+}
+
+// invoke sends a unary call to the backend and decodes its reply
+func (gt *GRPCTranslator) invoke(ctx context.Context, route *grpcRoute, in proto.Message, header http.Header) (proto.Message, error) {
+	payload, err := proto.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+
+	frame := make([]byte, 5+len(payload))
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(payload)))
+	copy(frame[5:], payload)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, route.target, bytes.NewReader(frame))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/grpc+proto")
+	req.Header.Set("TE", "trailers")
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Header.Set("Grpc-Timeout", strconv.FormatInt(time.Until(deadline).Milliseconds(), 10)+"m")
+	}
+	for key, values := range header {
+		if strings.HasPrefix(key, GRPCMetadataPrefix) {
+			for _, value := range values {
+				req.Header.Add(strings.TrimPrefix(key, GRPCMetadataPrefix), value)
+			}
+		}
+	}
+	if auth := header.Get("Authorization"); auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	if id := RequestIDFromContext(ctx); id != "" {
+		req.Header.Set(RequestIDHeader, upstreamRequestID(id, 1))
+	}
+
+	client := gt.client
+	if req.URL.Scheme == "https" {
+		client = gt.tls
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("grpc backend answered http status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Trailers-only responses carry the status in the headers
+	status := resp.Trailer.Get("Grpc-Status")
+	if status == "" {
+		status = resp.Header.Get("Grpc-Status")
+	}
+	message := resp.Trailer.Get("Grpc-Message")
+	if message == "" {
+		message = resp.Header.Get("Grpc-Message")
+	}
+	if code, _ := strconv.Atoi(status); code != 0 {
+		if decoded, err := url.PathUnescape(message); err == nil {
+			message = decoded
+		}
+		return nil, &grpcError{code: code, message: message}
+	}
+
+	if len(body) < 5 {
+		return nil, fmt.Errorf("grpc backend sent no response message")
+	}
+	if body[0] != 0 {
+		return nil, fmt.Errorf("compressed grpc responses are not supported")
+	}
+	size := binary.BigEndian.Uint32(body[1:5])
+	if int(size) > len(body)-5 {
+		return nil, fmt.Errorf("truncated grpc response message")
+	}
+
+	out := dynamicpb.NewMessage(route.method.Output())
+	if err := proto.Unmarshal(body[5:5+size], out); err != nil {
+		return nil, fmt.Errorf("invalid grpc response message: %w", err)
+	}
+	return out, nil
+}
+
+// serveGRPC translates a request matching a gRPC route. It reports whether
+// the request was handled.
+func (app *Application) serveGRPC(w http.ResponseWriter, r *http.Request) bool {
+	if app.GRPC == nil {
+		return false
+	}
+
+	var route *grpcRoute
+	var params map[string]string
+	for _, candidate := range app.GRPC.routes {
+		if p, ok := candidate.match(r.Method, r.URL.Path); ok {
+			route, params = candidate, p
+			break
+		}
+	}
+	if route == nil {
+		return false
+	}
+
+	in := dynamicpb.NewMessage(route.method.Input())
+
+	if route.body != "" {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			app.writeError(w, r, CodeBadRequest, "invalid request body")
+			return true
+		}
+		if len(bytes.TrimSpace(body)) > 0 {
+			target := in.ProtoReflect()
+			if route.body != "*" {
+				fd := route.method.Input().Fields().ByName(protoreflect.Name(route.body))
+				target = target.Mutable(fd).Message()
+			}
+			if err := protojson.Unmarshal(body, target.Interface()); err != nil {
+				app.writeError(w, r, CodeBadRequest, "the request body does not match the grpc request message: "+err.Error())
+				return true
+			}
+		}
+	}
+
+	for field, values := range r.URL.Query() {
+		for _, value := range values {
+			if err := setField(in, field, value); err != nil {
+				app.writeError(w, r, CodeBadRequest, "invalid query parameter: "+err.Error())
+				return true
+			}
+		}
+	}
+
+	for field, value := range params {
+		if err := setField(in, field, value); err != nil {
+			app.writeError(w, r, CodeBadRequest, "invalid path parameter: "+err.Error())
+			return true
+		}
+	}
+
+	if !app.CircuitBreaker.AllowRequest(route.backend) {
+		app.Counters.BreakerRejected.Add(1)
+		w.Header().Set("Retry-After", retryAfterSeconds(max(app.CircuitBreaker.Cooldown(route.backend), time.Second)))
+		app.writeError(w, r, CodeBreakerOpen, "no backend is available to handle the request")
+		return true
+	}
+	app.Counters.BreakerAllowed.Add(1)
+
+	upstreamStart := time.Now()
+	out, err := app.GRPC.invoke(r.Context(), route, in, r.Header)
+
+	// Application errors from a reachable backend are not upstream failures
+	var gerr *grpcError
+	failed := err != nil && (!errors.As(err, &gerr) || gerr.code == 14)
+	app.Latency.Observe(route.backend, route.path, time.Since(upstreamStart), failed)
+
+	switch {
+	case err == nil:
+		app.CircuitBreaker.OnSuccess(route.backend)
+	case errors.As(err, &gerr):
+		// Application errors from a reachable backend do not trip the breaker
+		if gerr.code == 14 {
+			app.CircuitBreaker.OnFailure(route.backend)
+		} else {
+			app.CircuitBreaker.OnSuccess(route.backend)
+		}
+	case r.Context().Err() == nil:
+		app.CircuitBreaker.OnFailure(route.backend)
+	}
+	app.CircuitBreaker.OnRequestComplete(route.backend)
+
+	if err != nil {
+		app.Logger.WarnContext(r.Context(), "grpc call failed", "method", route.method.FullName(), "backend", route.backend, "error", err)
+		if gerr != nil {
+			status, exists := grpcHTTPStatus[gerr.code]
+			if !exists {
+				status = http.StatusInternalServerError
+			}
+			app.writeError(w, r, codeForStatus(status), gerr.message)
+			return true
+		}
+		app.runErrorPlugins(r, err)
+		app.writeError(w, r, CodeUpstreamError, "the grpc backend call failed")
+		return true
+	}
+
+	data, err := protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(out)
+	if err != nil {
+		app.Logger.ErrorContext(r.Context(), "failed to encode grpc response", "method", route.method.FullName(), "error", err)
+		app.writeError(w, r, CodeUpstreamError, "failed to encode the grpc response")
+		return true
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+
+	app.Logger.InfoContext(r.Context(), "grpc request completed", "method", route.method.FullName(), "backend", route.backend, "path", r.URL.Path)
+	return true
+}
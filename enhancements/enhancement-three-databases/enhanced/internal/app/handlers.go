@@ -2,151 +2,544 @@ package app
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"io"
 	"net/http"
+	"net/http/httptrace"
 	"time"
 )
 
+// StatusClientClosedRequest is logged when the client disconnects before the
+// backend responds (the nginx convention)
+const StatusClientClosedRequest = 499
+
 func (app *Application) reverseProxyHandler(w http.ResponseWriter, r *http.Request) {
+	app.Counters.Requests.Add(1)
+
+	// New work is turned away early near the memory limit, before its body
+	// is read or buffered
+	if app.SelfMonitor.underMemoryPressure() {
+		app.writeError(w, r, CodeOverloaded, "the proxy is near its memory limit, try again later")
+		return
+	}
+
+	if app.StageShed != nil {
+		start := time.Now()
+		defer func() { app.StageShed.observe(time.Since(start)) }()
+	}
+
+	r, compareDarkLaunch := app.shadowEvaluate(r)
+	defer compareDarkLaunch()
+
+	if !app.enforceTLSPolicy(w, r) {
+		return
+	}
+
+	if !app.verifySignedURL(w, r) {
+		return
+	}
+
+	if !app.detectBots(w, r) {
+		return
+	}
+
+	if !app.runRequestPlugins(w, r) {
+		return
+	}
+
+	if !app.runWasmFilters(w, r) {
+		return
+	}
+
+	app.applyHeaderRules(r)
+	app.enrichRequest(r)
+
+	if !app.enforceHeaderLimits(w, r) {
+		return
+	}
+
+	if app.serveStatic(w, r) {
+		return
+	}
+
+	if app.serveGRPC(w, r) {
+		return
+	}
+
+	if app.serveAggregate(w, r) {
+		return
+	}
+
+	r = app.assignExperiment(w, r)
+
 	switch r.Method {
 	case http.MethodGet:
 		app.HandleGetRequest(w, r)
 	case http.MethodPost:
 		app.HandlePostRequest(w, r)
 	default:
-		http.Error(w, "unsupported http method", http.StatusMethodNotAllowed)
+		app.writeError(w, r, CodeMethodNotAllowed, "unsupported http method")
 	}
 }
 
 func (app *Application) HandleGetRequest(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Path
 
-	if cachedResp, found := app.Cache.Get(path); found {
-		w.WriteHeader(http.StatusOK)
-		w.Write(cachedResp)
-		app.Logger.Info("Cache hit", "path", path)
-		return
+	// Credentialed requests skip or partition the cache per their route
+	key, perUser, useCache := app.credentialedCacheKey(r, cacheKey(r))
+	switch {
+	case !useCache:
+		observeCache(r, "skip")
+	case app.bypassCache(r):
+		observeCache(r, "bypass")
+		useCache = false
 	}
 
-	backend, err := app.Router.ResolveBackend(path)
-	if err != nil {
-		app.Logger.Warn("backend resolution failed", "path", path, "error", err)
-		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+	// Cached responses are fetched and stored per content encoding, so a
+	// client is only ever served an encoding it accepts
+	encoding, accept, fillRange := "", "", ""
+	if useCache {
+		if cachedResp, cachedEncoding, found := lookupEncoded(app.Cache, r, key); found {
+			app.Counters.CacheHits.Add(1)
+			observeCache(r, "hit")
+			writeCached(w, r, cachedResp, cachedEncoding)
+			app.Logger.InfoContext(r.Context(), "Cache hit", "path", path, "encoding", cachedEncoding)
+			return
+		}
+		app.Counters.CacheMisses.Add(1)
+		observeCache(r, "miss")
+		encoding, accept = normalizeAcceptEncoding(r)
+
+		// Range fill routes fetch the whole object so it can be cached, then
+		// answer with the requested slice
+		if spec := requestedRange(r); spec != "" && app.rangeFillRoute(path) {
+			fillRange = spec
+			r.Header.Del("Range")
+		}
+	}
+
+	staleKey := ""
+	if useCache {
+		staleKey = key
+	}
+	backend, ok := app.resolveBackend(w, r, staleKey)
+	if !ok {
 		return
 	}
+	defer app.Drain.release(backend.Server.Name)
 
-	resp, err := app.performRequest(http.MethodGet, backend.TargetURL, r, nil)
+	upstreamStart := time.Now()
+	resp, shared, err := app.forwardGet(backend, r)
+	app.Latency.Observe(backend.Server.Name, backend.Prefix, time.Since(upstreamStart), upstreamFailed(r, resp, err))
+	if app.clientGone(w, r, backend, err) {
+		return
+	}
+	if app.upstreamTimedOut(w, r, backend, err, !shared) {
+		return
+	}
 	if err != nil {
-		app.CircuitBreaker.OnFailure(backend.Server.Name)
-		app.Logger.Error("GET request failed", "server", backend.Server.Name, "url", backend.TargetURL, "error", err)
-		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		// Only the request that went upstream counts against the breaker
+		if !shared {
+			app.CircuitBreaker.OnUpstreamFailure(backend.Server.Name, err)
+		}
+		app.Logger.ErrorContext(r.Context(), "GET request failed", "server", backend.Server.Name, "url", backend.TargetURL, "error", err)
+		app.runErrorPlugins(r, err)
+		app.writeUpstreamError(w, r, err)
 		return
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode >= 500 && resp.StatusCode <= 599 {
+	violation := app.assertResponse(r, backend, resp)
+
+	switch {
+	case shared:
+	case resp.StatusCode >= 500 && resp.StatusCode <= 599:
+		app.CircuitBreaker.OnFailure(backend.Server.Name)
+		app.Logger.WarnContext(r.Context(), "server error from backend", "server", backend.Server.Name, "status", resp.StatusCode)
+	case violation != nil && violation.assertion.CountAsFailure:
 		app.CircuitBreaker.OnFailure(backend.Server.Name)
-		app.Logger.Warn("server error from backend", "server", backend.Server.Name, "status", resp.StatusCode)
-	} else {
+	default:
 		app.CircuitBreaker.OnSuccess(backend.Server.Name)
 	}
 
 	app.CircuitBreaker.OnRequestComplete(backend.Server.Name)
 
+	if violation != nil && violation.assertion.Reject {
+		app.writeError(w, r, CodeUpstreamRejected, "the backend response failed validation")
+		return
+	}
+
+	if err := app.runResponsePlugins(r, resp); err != nil {
+		app.writeError(w, r, CodeUpstreamRejected, "the backend response was rejected")
+		return
+	}
+
 	for key, values := range resp.Header {
 		for _, value := range values {
 			w.Header().Add(key, value)
 		}
 	}
 
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		app.Logger.Error("Failed to read response body", "error", err)
-		http.Error(w, "Failed to read response", http.StatusInternalServerError)
+	// Streams are relayed as they arrive and never cached
+	if isStreamingResponse(resp) {
+		if err := streamResponse(w, resp); err != nil {
+			app.Logger.WarnContext(r.Context(), "streaming response interrupted", "server", backend.Server.Name, "path", path, "error", err)
+		}
+		return
+	}
+
+	// The body is buffered in a pooled buffer so it can be cached; Store
+	// makes the only copy
+	buf := getBodyBuffer()
+	defer putBodyBuffer(buf)
+
+	if _, err := buf.ReadFrom(resp.Body); err != nil {
+		app.Logger.ErrorContext(r.Context(), "Failed to read response body", "error", err)
+		if errors.Is(r.Context().Err(), context.DeadlineExceeded) {
+			app.writeError(w, r, CodeUpstreamTimeout, "the backend did not respond in time")
+			return
+		}
+		app.writeError(w, r, CodeUpstreamError, "failed to read the backend response")
 		return
 	}
 
-	w.WriteHeader(resp.StatusCode)
-	w.Write(bodyBytes)
+	body, storable := buf.Bytes(), true
+	if useCache {
+		var err error
+		if body, storable, err = matchEncoding(w, body, encoding, accept); err != nil {
+			app.Logger.WarnContext(r.Context(), "unusable backend content encoding", "server", backend.Server.Name, "path", path, "error", err)
+			app.writeError(w, r, CodeUpstreamError, "the backend response uses an unsupported content encoding")
+			return
+		}
+	}
 
-	app.Logger.Info("GET request completed",
+	if fillRange == "" || resp.StatusCode != http.StatusOK || !serveRange(w, fillRange, body) {
+		w.WriteHeader(resp.StatusCode)
+		w.Write(body)
+	}
+
+	app.Logger.InfoContext(r.Context(), "GET request completed",
 		"server", backend.Server.Name,
 		"status", resp.StatusCode,
-		"path", path)
+		"path", path,
+		upstreamTimingAttr(r.Context()))
 
-	if resp.StatusCode == http.StatusOK {
-		app.Cache.Store(path, bodyBytes)
-		app.Logger.Debug("Response cached", "path", path)
+	if fillRange != "" && len(body) > app.rangeFill.MaxBytes {
+		storable = false
+	}
+	if resp.StatusCode == http.StatusOK && useCache && storable && responseStorable(resp, perUser) {
+		variant := encodingKey(key, responseEncoding(w.Header()))
+		app.Cache.StoreAsync(variant, body)
+		app.rememberDegraded(r, variant, body)
+		app.Logger.DebugContext(r.Context(), "Response queued for cache", "key", variant)
 	}
 }
 
 func (app *Application) HandlePostRequest(w http.ResponseWriter, r *http.Request) {
-	backend, err := app.Router.ResolveBackend(r.URL.Path)
-	if err != nil {
-		app.Logger.Warn("backend resolution failed", "path", r.URL.Path, "error", err)
-		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+	reqBuf := getBodyBuffer()
+	defer putBodyBuffer(reqBuf)
+
+	if _, err := reqBuf.ReadFrom(r.Body); err != nil {
+		app.Logger.ErrorContext(r.Context(), "failed to read request body", "error", err)
+		app.writeError(w, r, CodeBadRequest, "invalid request body")
 		return
 	}
+	defer r.Body.Close()
 
-	bodyBytes, err := io.ReadAll(r.Body)
-	if err != nil {
-		app.Logger.Error("failed to read request body", "error", err)
-		http.Error(w, "invalid request body", http.StatusBadRequest)
+	bodyBytes, forwardBytes, ok := app.recodeRequestBody(w, r, reqBuf.Bytes())
+	if !ok {
 		return
 	}
-	defer r.Body.Close()
 
-	resp, err := app.performRequest(http.MethodPost, backend.TargetURL, r, bodyBytes)
+	if !app.validateRequestBody(w, r, bodyBytes) {
+		return
+	}
+
+	// Routes opted into POST caching are keyed by a hash of the body, or in
+	// header mode by the key the backend gave the last response to the same
+	// body
+	cacheMode := ""
+	cacheKey := ""
+	entryKey := ""
+	perUser := false
+	if mode := app.postCacheMode(r.URL.Path); mode != "" && !app.bypassCache(r) {
+		var ok bool
+		if cacheKey, perUser, ok = app.credentialedCacheKey(r, postCacheKey(r, bodyBytes)); ok {
+			cacheMode = mode
+			entryKey = cacheKey
+			if mode == PostCacheHeader {
+				entryKey = app.postCacheEntry(cacheKey)
+			}
+		}
+	}
+	encoding, accept := "", ""
+	if entryKey != "" {
+		w.Header().Set(PostCacheKeyHeader, entryKey)
+
+		if cachedResp, cachedEncoding, found := lookupEncoded(app.Cache, r, entryKey); found {
+			app.Counters.CacheHits.Add(1)
+			writeCached(w, r, cachedResp, cachedEncoding)
+			app.Logger.InfoContext(r.Context(), "Cache hit", "path", r.URL.Path, "key", entryKey, "encoding", cachedEncoding)
+			return
+		}
+	}
+	if cacheMode != "" {
+		app.Counters.CacheMisses.Add(1)
+		encoding, accept = normalizeAcceptEncoding(r)
+	}
+
+	backend, ok := app.resolveBackend(w, r, entryKey)
+	if !ok {
+		return
+	}
+	defer app.Drain.release(backend.Server.Name)
+
+	upstreamStart := time.Now()
+	resp, err := app.performRequest(backend.Server.Name, http.MethodPost, backend.TargetURL, r, forwardBytes)
+	app.Latency.Observe(backend.Server.Name, backend.Prefix, time.Since(upstreamStart), upstreamFailed(r, resp, err))
+	if app.clientGone(w, r, backend, err) {
+		return
+	}
+	if app.upstreamTimedOut(w, r, backend, err, true) {
+		return
+	}
 	if err != nil {
-		app.CircuitBreaker.OnFailure(backend.Server.Name)
-		app.Logger.Error("POST request failed", "server", backend.Server.Name, "url", backend.TargetURL, "error", err)
-		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		app.CircuitBreaker.OnUpstreamFailure(backend.Server.Name, err)
+		app.Logger.ErrorContext(r.Context(), "POST request failed", "server", backend.Server.Name, "url", backend.TargetURL, "error", err)
+		app.runErrorPlugins(r, err)
+		app.writeUpstreamError(w, r, err)
 		return
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode >= 500 && resp.StatusCode <= 599 {
+	violation := app.assertResponse(r, backend, resp)
+
+	switch {
+	case resp.StatusCode >= 500 && resp.StatusCode <= 599:
+		app.CircuitBreaker.OnFailure(backend.Server.Name)
+		app.Logger.WarnContext(r.Context(), "server error from backend", "server", backend.Server.Name, "status", resp.StatusCode)
+	case violation != nil && violation.assertion.CountAsFailure:
 		app.CircuitBreaker.OnFailure(backend.Server.Name)
-		app.Logger.Warn("server error from backend", "server", backend.Server.Name, "status", resp.StatusCode)
-	} else {
+	default:
 		app.CircuitBreaker.OnSuccess(backend.Server.Name)
 	}
 
 	app.CircuitBreaker.OnRequestComplete(backend.Server.Name)
 
+	if violation != nil && violation.assertion.Reject {
+		app.writeError(w, r, CodeUpstreamRejected, "the backend response failed validation")
+		return
+	}
+
+	if err := app.runResponsePlugins(r, resp); err != nil {
+		app.writeError(w, r, CodeUpstreamRejected, "the backend response was rejected")
+		return
+	}
+
 	for key, values := range resp.Header {
 		for _, value := range values {
 			w.Header().Add(key, value)
 		}
 	}
 
-	w.WriteHeader(resp.StatusCode)
-	if _, err := io.Copy(w, resp.Body); err != nil {
-		app.Logger.Error("failed to copy response body", "error", err)
+	switch {
+	case isStreamingResponse(resp):
+		if err := streamResponse(w, resp); err != nil {
+			app.Logger.WarnContext(r.Context(), "streaming response interrupted", "server", backend.Server.Name, "path", r.URL.Path, "error", err)
+		}
+
+	case cacheMode != "" && postCacheable(cacheMode, r, resp) && responseStorable(resp, perUser):
+		respBuf := getBodyBuffer()
+		defer putBodyBuffer(respBuf)
+
+		if _, err := respBuf.ReadFrom(resp.Body); err != nil {
+			app.Logger.ErrorContext(r.Context(), "Failed to read response body", "error", err)
+			app.writeError(w, r, CodeUpstreamError, "failed to read the backend response")
+			return
+		}
+
+		body, storable, err := matchEncoding(w, respBuf.Bytes(), encoding, accept)
+		if err != nil {
+			app.Logger.WarnContext(r.Context(), "unusable backend content encoding", "server", backend.Server.Name, "path", r.URL.Path, "error", err)
+			app.writeError(w, r, CodeUpstreamError, "the backend response uses an unsupported content encoding")
+			return
+		}
+
+		// Header mode entries are keyed by the backend, and the request's
+		// body key becomes an alias for the entry
+		if cacheMode == PostCacheHeader {
+			entryKey, _, _ = app.credentialedCacheKey(r, backendCacheKey(r, resp))
+			w.Header().Set(PostCacheKeyHeader, entryKey)
+		}
+
+		w.WriteHeader(resp.StatusCode)
+		w.Write(body)
+
+		if storable {
+			variant := encodingKey(entryKey, responseEncoding(w.Header()))
+			app.Cache.StoreAsync(variant, body)
+			app.rememberDegraded(r, variant, body)
+			if cacheMode == PostCacheHeader {
+				app.Cache.StoreAsync(cacheKey+postCacheAliasSuffix, []byte(entryKey))
+				app.rememberDegraded(r, cacheKey+postCacheAliasSuffix, []byte(entryKey))
+			}
+			app.Logger.DebugContext(r.Context(), "Response queued for cache", "path", r.URL.Path, "key", variant)
+		}
+
+	default:
+		w.WriteHeader(resp.StatusCode)
+		if _, err := copyPooled(w, resp.Body); err != nil {
+			app.Logger.ErrorContext(r.Context(), "failed to copy response body", "error", err)
+		}
 	}
 
-	app.Logger.Info("POST request completed",
+	app.Logger.InfoContext(r.Context(), "POST request completed",
 		"server", backend.Server.Name,
 		"status", resp.StatusCode,
-		"path", r.URL.Path)
+		"path", r.URL.Path,
+		upstreamTimingAttr(r.Context()))
 }
 
-func (app *Application) performRequest(method, url string, originalReq *http.Request, body []byte) (*http.Response, error) {
+// resolveBackend picks a backend for the request, enforcing route conditions,
+// and writes an error response when none is available. A degradable route
+// with no backend is answered with the stale response for staleKey instead.
+// The returned backend counts the request as in flight until the caller
+// releases it from app.Drain.
+func (app *Application) resolveBackend(w http.ResponseWriter, r *http.Request, staleKey string) (*BackendInfo, bool) {
+	backend, err := app.Router.ResolveBackend(r.Context(), r.URL.Path)
+	if err != nil {
+		app.Logger.WarnContext(r.Context(), "backend resolution failed", "path", r.URL.Path, "error", err)
+		app.runErrorPlugins(r, err)
+		if !errors.Is(err, ErrNoRoute) && app.serveDegraded(w, r, staleKey) {
+			return nil, false
+		}
+		var open *BreakerOpenError
+		if errors.As(err, &open) {
+			w.Header().Set("Retry-After", retryAfterSeconds(max(open.RetryAfter, time.Second)))
+		}
+		app.writeError(w, r, routingErrorCode(err), err.Error())
+		return nil, false
+	}
+	observeBackend(r, backend)
+	recordBackend(r, backend)
+
+	if !app.routeAllowed(r, backend.Prefix) {
+		app.Logger.InfoContext(r.Context(), "route condition not met", "path", r.URL.Path, "prefix", backend.Prefix)
+		app.writeError(w, r, CodeNoRoute, "no route matches the request")
+		return nil, false
+	}
+
+	if status, ok := app.OpenAPI.allows(backend.Server.Name, backend.Prefix, r.URL.Path, r.Method); !ok {
+		app.Logger.InfoContext(r.Context(), "request is not part of the imported api contract", "path", r.URL.Path, "method", r.Method, "server", backend.Server.Name)
+		app.CircuitBreaker.OnRequestComplete(backend.Server.Name)
+		app.writeError(w, r, codeForStatus(status), "the api does not define this operation")
+		return nil, false
+	}
+
+	if r.URL.RawQuery != "" {
+		backend.TargetURL += "?" + r.URL.RawQuery
+	}
+
+	// A backend that started draining after it was selected takes no new
+	// requests; the caller releases the request once it is done
+	if !app.Drain.acquire(backend.Server.Name) {
+		app.CircuitBreaker.OnRequestComplete(backend.Server.Name)
+		app.writeError(w, r, CodeNoHealthyBackends, "the selected backend is being drained, try again")
+		return nil, false
+	}
+
+	return backend, true
+}
+
+// upstreamFailed reports whether a forwarded request failed at the backend:
+// a transport error not caused by the client going away, or a 5xx answer
+func upstreamFailed(r *http.Request, resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(r.Context().Err(), context.Canceled)
+	}
+	return resp.StatusCode >= 500
+}
+
+// clientGone reports whether a forwarding error was caused by the client
+// disconnecting. Such errors are not the backend's fault, so they release the
+// breaker slot without counting as a failure and are logged with status 499.
+func (app *Application) clientGone(w http.ResponseWriter, r *http.Request, backend *BackendInfo, err error) bool {
+	if err == nil || !errors.Is(r.Context().Err(), context.Canceled) {
+		return false
+	}
+
+	app.CircuitBreaker.OnRequestComplete(backend.Server.Name)
+	app.Logger.InfoContext(r.Context(), "client went away before the backend responded",
+		"server", backend.Server.Name,
+		"path", r.URL.Path,
+		"error", err)
+	w.WriteHeader(StatusClientClosedRequest)
+	return true
+}
+
+// performRequest forwards a request to backend, retrying 5xx responses and
+// transport errors whose class is retryable. Failed attempts are counted per
+// class against backend, unless it is empty, and the error returned for a
+// failed request is an *UpstreamError. The inbound request's context bounds
+// every attempt and backoff wait, so retries stop as soon as the client goes
+// away. Each attempt carries the request ID suffixed with its attempt number.
+// Retries are counted against their kill switch, which turns them off when
+// disabled. A request that is not idempotent under the RetryPolicy is only
+// retried when its failed attempt never got a connection.
+func (app *Application) performRequest(backend, method, url string, originalReq *http.Request, body []byte) (*http.Response, error) {
+	retries := app.Reliability.feature(FeatureRetries)
 	maxRetries := 3
+	if !retries.enabled.Load() {
+		maxRetries = 1
+	}
 	backoffTimes := []time.Duration{100 * time.Millisecond, 500 * time.Millisecond, 2 * time.Second}
 
+	ctx := originalReq.Context()
+	idempotent := app.RetryPolicy.Idempotent(method, originalReq.URL.Path, originalReq.Header)
+
+	// unsafeRetry reports whether retrying would risk the backend processing
+	// the request twice, counting and logging the retry it prevents
+	unsafeRetry := func(attempt int, sent bool) bool {
+		if idempotent || !sent || attempt >= maxRetries {
+			return false
+		}
+		app.RetryPolicy.unsafe.Add(1)
+		app.Logger.WarnContext(ctx, "Not retrying non-idempotent request", "method", method, "url", url, "attempt", attempt)
+		return true
+	}
+
+	// backoff waits before the attempt after attempt, counting the request
+	// as retried on its first wait
+	backoff := func(attempt int) error {
+		if attempt == 1 {
+			retries.activations.Add(1)
+		}
+		if err := sleepCtx(ctx, app.clock, backoffTimes[attempt-1]); err != nil {
+			retries.cancellations.Add(1)
+			return err
+		}
+		return nil
+	}
+
 	var resp *http.Response
 	var err error
 
 	for attempt := 1; attempt <= maxRetries; attempt++ {
+		if attempt > 1 {
+			retries.added.Add(1)
+		}
+
 		var reqBody io.Reader
 		if body != nil {
 			reqBody = bytes.NewReader(body)
 		}
 
-		req, createErr := http.NewRequest(method, url, reqBody)
+		attemptCtx := newBackendAttempt(ctx)
+		req, createErr := http.NewRequestWithContext(attemptCtx.ctx, method, url, reqBody)
 		if createErr != nil {
-			app.Logger.Error("Failed to create request", "method", method, "url", url, "error", createErr)
+			attemptCtx.end()
+			app.Logger.ErrorContext(ctx, "Failed to create request", "method", method, "url", url, "error", createErr)
 			return nil, createErr
 		}
 
@@ -155,31 +548,80 @@ func (app *Application) performRequest(method, url string, originalReq *http.Req
 				req.Header.Add(key, value)
 			}
 		}
+		upstreamID := ""
+		if id := RequestIDFromContext(ctx); id != "" {
+			upstreamID = upstreamRequestID(id, attempt)
+			req.Header.Set(RequestIDHeader, upstreamID)
+		}
 
-		app.Logger.Debug("Forwarding request",
+		app.Logger.DebugContext(ctx, "Forwarding request",
 			"method", method,
 			"url", url,
-			"attempt", attempt)
+			"attempt", attempt,
+			"upstream_request_id", upstreamID)
+
+		timer := &upstreamTimer{}
+		req = req.WithContext(httptrace.WithClientTrace(attemptCtx.ctx, timer.trace()))
 
 		resp, err = app.Client.Do(req)
 		if err != nil {
-			app.Logger.Warn("Request failed", "url", url, "error", err, "attempt", attempt)
-			if attempt < maxRetries {
-				time.Sleep(backoffTimes[attempt-1])
+			attemptCtx.end()
+			if ctx.Err() != nil {
+				if attempt > 1 {
+					retries.cancellations.Add(1)
+				}
+				// A route timeout is the backend's doing, a client going
+				// away is not
+				if backend != "" && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+					app.UpstreamErrors.Observe(backend, classifyUpstreamError(ctx.Err(), timer.connected()))
+				}
+				return nil, ctx.Err()
+			}
+			class := classifyUpstreamError(err, timer.connected())
+			if backend != "" {
+				app.UpstreamErrors.Observe(backend, class)
+			}
+			app.Logger.WarnContext(ctx, "Request failed", "url", url, "error", err, "class", class, "attempt", attempt)
+			if attempt < maxRetries && class.Retryable() && !unsafeRetry(attempt, timer.connected()) {
+				if waitErr := backoff(attempt); waitErr != nil {
+					return nil, waitErr
+				}
 				continue
 			}
-			return nil, err
+			return nil, &UpstreamError{Class: class, Err: err}
 		}
 
-		if resp.StatusCode >= 500 && resp.StatusCode <= 504 && attempt < maxRetries {
-			app.Logger.Warn("Server error from backend", "status", resp.StatusCode, "attempt", attempt)
+		if resp.StatusCode >= 500 && resp.StatusCode <= 504 && attempt < maxRetries && !unsafeRetry(attempt, true) {
+			app.Logger.WarnContext(ctx, "Server error from backend", "status", resp.StatusCode, "attempt", attempt)
 			resp.Body.Close()
-			time.Sleep(backoffTimes[attempt-1])
+			attemptCtx.end()
+			if waitErr := backoff(attempt); waitErr != nil {
+				return nil, waitErr
+			}
 			continue
 		}
 
+		if attempt > 1 && resp.StatusCode < 500 {
+			retries.wins.Add(1)
+		}
+		attemptCtx.hold(resp)
+		app.timeResponse(originalReq, timer, resp)
 		break
 	}
 
 	return resp, err
 }
+
+// sleepCtx waits for d on clock or until ctx is done, returning ctx's error
+// in the latter case
+func sleepCtx(ctx context.Context, clock Clock, d time.Duration) error {
+	timer := clock.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
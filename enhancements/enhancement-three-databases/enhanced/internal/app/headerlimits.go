@@ -0,0 +1,156 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+// HeaderLimitConfig bounds the request headers forwarded to backends, so
+// clients cannot push a backend with a strict header parser into 431s
+type HeaderLimitConfig struct {
+	// MaxCount is the most header lines forwarded; 0 is unlimited
+	MaxCount int `json:"max_count,omitempty"`
+	// MaxBytes bounds the total size of the forwarded headers, counted as
+	// they are sent ("Name: value\r\n"); 0 is unlimited
+	MaxBytes int `json:"max_bytes,omitempty"`
+	// MaxValueBytes strips a header whose values together exceed its limit,
+	// such as {"Cookie": 4096}
+	MaxValueBytes map[string]int `json:"max_value_bytes,omitempty"`
+	// Strip lists headers never forwarded
+	Strip []string `json:"strip,omitempty"`
+}
+
+// HeaderLimits strips dangerous headers and rejects requests whose
+// remaining headers exceed the limits
+type HeaderLimits struct {
+	cfg      HeaderLimitConfig
+	strip    map[string]bool
+	maxValue map[string]int
+	// stripped counts removals by header; its keys are fixed at creation
+	stripped      map[string]*atomic.Uint64
+	rejectedCount atomic.Uint64
+	rejectedBytes atomic.Uint64
+}
+
+// NewHeaderLimits validates cfg and creates the header limits
+func NewHeaderLimits(cfg HeaderLimitConfig) (*HeaderLimits, error) {
+	if cfg.MaxCount < 0 || cfg.MaxBytes < 0 {
+		return nil, fmt.Errorf("header count and size limits must not be negative")
+	}
+
+	hl := &HeaderLimits{
+		cfg:      cfg,
+		strip:    make(map[string]bool, len(cfg.Strip)),
+		maxValue: make(map[string]int, len(cfg.MaxValueBytes)),
+		stripped: make(map[string]*atomic.Uint64),
+	}
+	for _, name := range cfg.Strip {
+		if name == "" {
+			return nil, fmt.Errorf("empty header name in strip list")
+		}
+		name = http.CanonicalHeaderKey(name)
+		hl.strip[name] = true
+		hl.stripped[name] = &atomic.Uint64{}
+	}
+	for name, limit := range cfg.MaxValueBytes {
+		if name == "" || limit <= 0 {
+			return nil, fmt.Errorf("header %q needs a positive size limit", name)
+		}
+		name = http.CanonicalHeaderKey(name)
+		hl.maxValue[name] = limit
+		hl.stripped[name] = &atomic.Uint64{}
+	}
+	return hl, nil
+}
+
+// headerSize returns the size of a header's values alone and of its lines
+// on the wire
+func headerSize(name string, values []string) (valueSize, lineSize int) {
+	for _, value := range values {
+		valueSize += len(value)
+		lineSize += len(name) + len(value) + len(": \r\n")
+	}
+	return valueSize, lineSize
+}
+
+// enforceHeaderLimits strips the headers the limits remove, then answers 431
+// and returns false when what remains is still over the count or size limit
+func (app *Application) enforceHeaderLimits(w http.ResponseWriter, r *http.Request) bool {
+	hl := app.HeaderLimits
+	if hl == nil {
+		return true
+	}
+
+	count, size := 0, 0
+	for name, values := range r.Header {
+		if hl.strip[name] {
+			r.Header.Del(name)
+			hl.stripped[name].Add(1)
+			continue
+		}
+		valueSize, lineSize := headerSize(name, values)
+		if limit, ok := hl.maxValue[name]; ok && valueSize > limit {
+			r.Header.Del(name)
+			hl.stripped[name].Add(1)
+			app.Logger.WarnContext(r.Context(), "oversized request header stripped",
+				"header", name, "size", valueSize, "limit", limit)
+			continue
+		}
+		count += len(values)
+		size += lineSize
+	}
+
+	switch {
+	case hl.cfg.MaxCount > 0 && count > hl.cfg.MaxCount:
+		hl.rejectedCount.Add(1)
+	case hl.cfg.MaxBytes > 0 && size > hl.cfg.MaxBytes:
+		hl.rejectedBytes.Add(1)
+	default:
+		return true
+	}
+
+	app.Logger.WarnContext(r.Context(), "request headers exceed upstream limits",
+		"path", r.URL.Path, "count", count, "bytes", size)
+	app.writeError(w, r, CodeHeadersTooLarge, "request headers too large")
+	return false
+}
+
+// HeaderLimitStats reports the header limits and how often they applied
+type HeaderLimitStats struct {
+	HeaderLimitConfig
+	// Stripped counts removals by header
+	Stripped map[string]uint64 `json:"stripped"`
+	// RejectedCount and RejectedBytes count requests answered 431 for too
+	// many headers and for too large headers
+	RejectedCount uint64 `json:"rejected_count"`
+	RejectedBytes uint64 `json:"rejected_bytes"`
+}
+
+// Stats returns the limits with their counters
+func (hl *HeaderLimits) Stats() HeaderLimitStats {
+	stripped := make(map[string]uint64, len(hl.stripped))
+	for name, count := range hl.stripped {
+		stripped[name] = count.Load()
+	}
+	return HeaderLimitStats{
+		HeaderLimitConfig: hl.cfg,
+		Stripped:          stripped,
+		RejectedCount:     hl.rejectedCount.Load(),
+		RejectedBytes:     hl.rejectedBytes.Load(),
+	}
+}
+
+// HandleHeaderLimits serves GET /admin/header-limits
+func (app *Application) HandleHeaderLimits(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if app.HeaderLimits == nil {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": false})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": true, "limits": app.HeaderLimits.Stats()})
+}
@@ -2,9 +2,13 @@ package app
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/codytheroux96/go-reverse-proxy/internal/registry"
@@ -15,14 +19,67 @@ const (
 	UnhealthyThreshold = 3
 	HealthCheckTimeout = 1 * time.Second
 	HealthCheckPath    = "/health"
+	// RecoveryThreshold is the number of consecutive passing checks after
+	// which a backend counts as recovered and its open breaker is half-opened
+	RecoveryThreshold = 3
+	// DefaultStartupPasses is how many consecutive passing checks end a new
+	// backend's startup grace
+	DefaultStartupPasses = 2
 )
 
+// HealthCheckConfig maps backend readiness and liveness to endpoints
+type HealthCheckConfig struct {
+	// ReadinessPath answers 2xx while the backend can take traffic; empty
+	// uses HealthCheckPath. Only readiness gates routing.
+	ReadinessPath string
+	// LivenessPath answers 2xx while the backend process is up; empty
+	// counts any HTTP response to the readiness check as live
+	LivenessPath string
+	// DeregisterAfter deregisters a backend that has not been live for this
+	// long; 0 keeps it registered
+	DeregisterAfter time.Duration
+	// DegradedWeight is the share of a healthy backend's traffic sent to one
+	// reporting itself degraded, between 0 and 1; 0 uses
+	// DefaultDegradedWeight
+	DegradedWeight float64
+	// StartupTimeout is the check timeout of a backend still starting up,
+	// for services that answer slowly while they warm up; 0 gives them
+	// HealthCheckTimeout like any other backend. It may not exceed
+	// HealthInterval.
+	StartupTimeout time.Duration
+	// StartupPasses is how many consecutive passing checks end a backend's
+	// startup, after which its checks time out after HealthCheckTimeout; 0
+	// uses DefaultStartupPasses
+	StartupPasses int
+}
+
 // HealthStatus represents the health state of a backend server
 type HealthStatus struct {
-	IsHealthy           bool          `json:"is_healthy"`
-	LastChecked         time.Time     `json:"last_checked"`
-	ConsecutiveFailures int           `json:"consecutive_failures"`
-	LastResponseTime    time.Duration `json:"last_response_time"`
+	// IsHealthy is readiness: whether the backend can take traffic
+	IsHealthy           bool      `json:"is_healthy"`
+	LastChecked         time.Time `json:"last_checked"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	// ConsecutiveSuccesses counts passing checks since the last failure or
+	// breaker opening
+	ConsecutiveSuccesses int           `json:"consecutive_successes"`
+	LastResponseTime     time.Duration `json:"last_response_time"`
+	// IsLive is liveness: whether the backend process is up. A live backend
+	// that is not ready, such as one warming up, gets no traffic but is not
+	// failing.
+	IsLive                      bool `json:"is_live"`
+	ConsecutiveLivenessFailures int  `json:"consecutive_liveness_failures"`
+	// NotLiveSince is when the backend was marked not live
+	NotLiveSince *time.Time `json:"not_live_since,omitempty"`
+	// Level is the health a ready backend reported in its readiness
+	// response, healthy or degraded; empty when it reports none
+	Level string `json:"level,omitempty"`
+	// Details is the backend-provided detail of its last structured report
+	Details json.RawMessage `json:"details,omitempty"`
+	// Prewarm is this instance's last prewarm of the backend's connections
+	Prewarm *PrewarmResult `json:"prewarm,omitempty"`
+	// Started is set once the backend has passed its first StartupPasses
+	// checks in a row since it was registered
+	Started bool `json:"started"`
 }
 
 // HealthMonitor manages health checking for all registered backends
@@ -34,6 +91,18 @@ type HealthMonitor struct {
 	client    *http.Client
 	stopCh    chan struct{}
 	stopped   chan struct{}
+	// events receives health transitions; nil discards them
+	events *EventBus
+	// leader decides whether this instance runs checks or follows the
+	// leader's results; nil means it always runs them
+	leader *LeaderElection
+	// firstRound is set once the first round of health checks has finished
+	firstRound atomic.Bool
+	clock      Clock
+	checks     HealthCheckConfig
+	// prewarm holds prewarm results apart from healthMap, which followers
+	// replace with the leader's statuses
+	prewarm map[string]PrewarmResult
 }
 
 // NewHealthMonitor creates a new health monitor instance
@@ -41,20 +110,61 @@ func NewHealthMonitor(reg RegistryInterface, logger *slog.Logger) *HealthMonitor
 	return &HealthMonitor{
 		registry:  reg,
 		healthMap: make(map[string]*HealthStatus),
+		prewarm:   make(map[string]PrewarmResult),
 		logger:    logger,
-		client: &http.Client{
-			Timeout: HealthCheckTimeout,
-		},
+		// Checks are bounded per request instead, see checkTimeout
+		client:  &http.Client{},
 		stopCh:  make(chan struct{}),
 		stopped: make(chan struct{}),
+		clock:   SystemClock,
+		checks: HealthCheckConfig{
+			ReadinessPath:  HealthCheckPath,
+			DegradedWeight: DefaultDegradedWeight,
+			StartupPasses:  DefaultStartupPasses,
+		},
 	}
 }
 
+// SetHealthChecks sets the readiness and liveness endpoints, the
+// deregistration grace period and the startup check timeout. It must be
+// called before Start.
+func (app *Application) SetHealthChecks(cfg HealthCheckConfig) error {
+	if cfg.ReadinessPath == "" {
+		cfg.ReadinessPath = HealthCheckPath
+	}
+	for _, path := range []string{cfg.ReadinessPath, cfg.LivenessPath} {
+		if path != "" && path[0] != '/' {
+			return fmt.Errorf("health check path %q must start with /", path)
+		}
+	}
+	if cfg.DeregisterAfter < 0 {
+		return fmt.Errorf("deregistration grace period must not be negative")
+	}
+	if cfg.DegradedWeight < 0 || cfg.DegradedWeight > 1 {
+		return fmt.Errorf("degraded weight must be between 0 and 1")
+	}
+	if cfg.DegradedWeight == 0 {
+		cfg.DegradedWeight = DefaultDegradedWeight
+	}
+	if cfg.StartupTimeout < 0 || cfg.StartupTimeout > HealthInterval {
+		return fmt.Errorf("startup health check timeout must be between 0 and %s", HealthInterval)
+	}
+	if cfg.StartupPasses < 0 {
+		return fmt.Errorf("startup passes must not be negative")
+	}
+	if cfg.StartupPasses == 0 {
+		cfg.StartupPasses = DefaultStartupPasses
+	}
+
+	app.HealthMonitor.checks = cfg
+	return nil
+}
+
 // Start begins the health monitoring process
 func (hm *HealthMonitor) Start(ctx context.Context) {
 	hm.logger.Info("starting health monitor", "interval", HealthInterval)
 
-	ticker := time.NewTicker(HealthInterval)
+	ticker := hm.clock.NewTicker(HealthInterval)
 	defer ticker.Stop()
 	defer close(hm.stopped)
 
@@ -66,10 +176,20 @@ func (hm *HealthMonitor) Start(ctx context.Context) {
 		case <-hm.stopCh:
 			hm.logger.Info("health monitor stopped")
 			return
-		case <-ticker.C:
+		case <-ticker.C():
+			if !hm.leader.IsLeader() {
+				if hm.followLeader(ctx) {
+					hm.firstRound.Store(true)
+				}
+				continue
+			}
 			if hm.registry != nil {
 				hm.checkAllServers(ctx)
 			}
+			if hm.leader != nil {
+				hm.shareHealth(ctx)
+			}
+			hm.firstRound.Store(true)
 		}
 	}
 }
@@ -80,6 +200,11 @@ func (hm *HealthMonitor) Stop() {
 	<-hm.stopped
 }
 
+// HasCompletedRound reports whether at least one round of health checks has run
+func (hm *HealthMonitor) HasCompletedRound() bool {
+	return hm.firstRound.Load()
+}
+
 // checkAllServers performs health checks on all registered servers
 func (hm *HealthMonitor) checkAllServers(ctx context.Context) {
 	servers, err := hm.registry.GetServers()
@@ -106,45 +231,177 @@ func (hm *HealthMonitor) checkAllServers(ctx context.Context) {
 	wg.Wait()
 }
 
+// shareHealth publishes this leader's health statuses to the followers
+func (hm *HealthMonitor) shareHealth(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, LeaderRenewTimeout)
+	defer cancel()
+
+	if err := hm.leader.backend.StoreHealth(ctx, hm.GetAllHealthStatuses()); err != nil {
+		hm.logger.Warn("failed to share health statuses", "error", err)
+	}
+}
+
+// followLeader replaces this instance's health statuses with the leader's,
+// publishing the same transitions a local check would, and reports whether
+// it succeeded
+func (hm *HealthMonitor) followLeader(ctx context.Context) bool {
+	ctx, cancel := context.WithTimeout(ctx, LeaderRenewTimeout)
+	defer cancel()
+
+	statuses, err := hm.leader.backend.LoadHealth(ctx)
+	if err != nil {
+		hm.logger.Warn("failed to load leader health statuses", "error", err)
+		return false
+	}
+
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+
+	for name, status := range statuses {
+		prev, exists := hm.healthMap[name]
+		switch {
+		case exists && prev.IsHealthy != status.IsHealthy:
+			from, to := "healthy", "unhealthy"
+			if status.IsHealthy {
+				from, to = to, from
+			}
+			hm.events.Publish(Event{Type: EventHealthChanged, Server: name, From: from, To: to})
+		case !exists && status.IsHealthy:
+			hm.events.Publish(Event{Type: EventHealthChanged, Server: name, From: "unhealthy", To: "healthy"})
+		}
+		if exists && prev.IsLive != status.IsLive {
+			hm.publishLiveness(name, status.IsLive)
+		}
+		if exists && prev.IsHealthy && status.IsHealthy && prev.Level != status.Level {
+			hm.publishLevel(name, prev.Level, status.Level)
+		}
+		if status.ConsecutiveSuccesses >= RecoveryThreshold && (!exists || prev.ConsecutiveSuccesses < RecoveryThreshold) {
+			hm.events.Publish(Event{Type: EventBackendRecovered, Server: name})
+		}
+
+		status := status
+		hm.healthMap[name] = &status
+	}
+	for name := range hm.healthMap {
+		if _, exists := statuses[name]; !exists {
+			delete(hm.healthMap, name)
+		}
+	}
+	return true
+}
+
 // checkServerHealth performs a health check on a single server
 func (hm *HealthMonitor) checkServerHealth(ctx context.Context, server registry.Server) {
-	start := time.Now()
-	healthURL := server.BaseURL + HealthCheckPath
+	ctx, cancel := context.WithTimeout(ctx, hm.checkTimeout(server.Name))
+	defer cancel()
+
+	start := hm.clock.Now()
+	status, body, err := hm.probe(ctx, server.BaseURL+hm.checks.ReadinessPath)
+	result := healthCheckResult{
+		ready:        err == nil && status >= 200 && status < 300,
+		live:         err == nil,
+		responseTime: hm.clock.Since(start),
+	}
+
+	level, details, failed := parseHealthDetail(body)
+	result.details = details
+	if result.ready {
+		result.ready = !failed
+		result.level = level
+	}
+
+	if hm.checks.LivenessPath != "" {
+		liveStatus, _, liveErr := hm.probe(ctx, server.BaseURL+hm.checks.LivenessPath)
+		result.live = liveErr == nil && liveStatus >= 200 && liveStatus < 300
+	}
+
+	if hm.updateHealthStatus(server.Name, result) {
+		hm.deregisterDead(server.Name)
+	}
+
+	switch {
+	case err != nil:
+		hm.logger.Debug("health check failed",
+			"server", server.Name, "error", err, "response_time", result.responseTime, "live", result.live)
+	case result.ready:
+		hm.logger.Debug("health check passed",
+			"server", server.Name, "status", status, "level", result.level, "response_time", result.responseTime)
+	default:
+		hm.logger.Warn("health check failed",
+			"server", server.Name, "status", status, "response_time", result.responseTime, "live", result.live)
+	}
+}
 
+// checkTimeout returns how long a server's checks may take: StartupTimeout
+// until it has started, when one is set, and HealthCheckTimeout after
+func (hm *HealthMonitor) checkTimeout(serverName string) time.Duration {
+	if hm.checks.StartupTimeout == 0 {
+		return HealthCheckTimeout
+	}
+
+	hm.mu.RLock()
+	defer hm.mu.RUnlock()
+
+	if status, exists := hm.healthMap[serverName]; exists && status.Started {
+		return HealthCheckTimeout
+	}
+	return hm.checks.StartupTimeout
+}
+
+// probe GETs a health endpoint and returns its status code and the start of
+// its body
+func (hm *HealthMonitor) probe(ctx context.Context, url string) (int, []byte, error) {
 	// Create request with context for timeout
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, healthURL, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		hm.updateHealthStatus(server.Name, false, time.Since(start))
-		hm.logger.Error("failed to create health check request",
-			"server", server.Name, "error", err)
-		return
+		return 0, nil, err
 	}
 
 	resp, err := hm.client.Do(req)
-	responseTime := time.Since(start)
-
 	if err != nil {
-		hm.updateHealthStatus(server.Name, false, responseTime)
-		hm.logger.Debug("health check failed",
-			"server", server.Name, "error", err, "response_time", responseTime)
-		return
+		return 0, nil, err
 	}
 	defer resp.Body.Close()
 
-	isHealthy := resp.StatusCode >= 200 && resp.StatusCode < 300
-	hm.updateHealthStatus(server.Name, isHealthy, responseTime)
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, MaxHealthDetailBytes))
+	return resp.StatusCode, body, nil
+}
 
-	if isHealthy {
-		hm.logger.Debug("health check passed",
-			"server", server.Name, "status", resp.StatusCode, "response_time", responseTime)
-	} else {
-		hm.logger.Warn("health check failed",
-			"server", server.Name, "status", resp.StatusCode, "response_time", responseTime)
+// deregisterDead removes a backend that stayed not live past the grace period
+func (hm *HealthMonitor) deregisterDead(serverName string) {
+	hm.logger.Warn("deregistering server that is not live",
+		"server", serverName, "grace_period", hm.checks.DeregisterAfter)
+	if err := hm.registry.Deregister(serverName); err != nil {
+		hm.logger.Error("failed to deregister server that is not live", "server", serverName, "error", err)
 	}
 }
 
-// updateHealthStatus updates the health status for a server
-func (hm *HealthMonitor) updateHealthStatus(serverName string, isHealthy bool, responseTime time.Duration) {
+// publishLiveness reports a backend becoming live or not live
+func (hm *HealthMonitor) publishLiveness(serverName string, isLive bool) {
+	from, to := "live", "not_live"
+	if isLive {
+		from, to = to, from
+	}
+	hm.events.Publish(Event{Type: EventLivenessChanged, Server: serverName, From: from, To: to})
+}
+
+// publishLevel reports a ready backend changing its reported health level
+func (hm *HealthMonitor) publishLevel(serverName, from, to string) {
+	if from == "" {
+		from = HealthLevelHealthy
+	}
+	if to == "" {
+		to = HealthLevelHealthy
+	}
+	if from != to {
+		hm.events.Publish(Event{Type: EventHealthChanged, Server: serverName, From: from, To: to})
+	}
+}
+
+// updateHealthStatus updates the health status for a server and reports
+// whether it has been not live for longer than the deregistration grace
+// period
+func (hm *HealthMonitor) updateHealthStatus(serverName string, result healthCheckResult) bool {
 	hm.mu.Lock()
 	defer hm.mu.Unlock()
 
@@ -153,24 +410,68 @@ func (hm *HealthMonitor) updateHealthStatus(serverName string, isHealthy bool, r
 		status = &HealthStatus{
 			IsHealthy:           false,
 			ConsecutiveFailures: 0,
+			// Liveness must fail UnhealthyThreshold checks before a new
+			// backend counts as down
+			IsLive: true,
 		}
 		hm.healthMap[serverName] = status
 	}
 
-	status.LastChecked = time.Now()
+	isHealthy, isLive, responseTime := result.ready, result.live, result.responseTime
+	now := hm.clock.Now()
+	status.LastChecked = now
 	status.LastResponseTime = responseTime
-
+	status.Details = result.details
+
+	if isLive {
+		status.ConsecutiveLivenessFailures = 0
+		status.NotLiveSince = nil
+		if !status.IsLive {
+			status.IsLive = true
+			hm.logger.Info("server live again", "server", serverName)
+			hm.publishLiveness(serverName, true)
+		}
+	} else {
+		status.ConsecutiveLivenessFailures++
+		if status.IsLive && status.ConsecutiveLivenessFailures >= UnhealthyThreshold {
+			status.IsLive = false
+			status.NotLiveSince = &now
+			hm.logger.Error("server not live",
+				"server", serverName,
+				"consecutive_failures", status.ConsecutiveLivenessFailures)
+			hm.publishLiveness(serverName, false)
+		}
+	}
 	if isHealthy {
 		status.ConsecutiveFailures = 0
+		status.ConsecutiveSuccesses++
 		wasUnhealthy := !status.IsHealthy
 		status.IsHealthy = true
 
+		if status.Level != result.level {
+			hm.logger.Info("server health level changed",
+				"server", serverName, "from", status.Level, "to", result.level)
+			if !wasUnhealthy {
+				hm.publishLevel(serverName, status.Level, result.level)
+			}
+			status.Level = result.level
+		}
 		if wasUnhealthy {
 			hm.logger.Info("server recovered",
 				"server", serverName, "response_time", responseTime)
+			hm.events.Publish(Event{Type: EventHealthChanged, Server: serverName, From: "unhealthy", To: "healthy"})
+		}
+		if status.ConsecutiveSuccesses == RecoveryThreshold {
+			hm.events.Publish(Event{Type: EventBackendRecovered, Server: serverName})
+		}
+		if !status.Started && status.ConsecutiveSuccesses >= hm.checks.StartupPasses {
+			status.Started = true
+			hm.logger.Info("server started",
+				"server", serverName, "passing_checks", status.ConsecutiveSuccesses)
 		}
 	} else {
 		status.ConsecutiveFailures++
+		status.ConsecutiveSuccesses = 0
 		wasHealthy := status.IsHealthy
 
 		if status.ConsecutiveFailures >= UnhealthyThreshold {
@@ -179,6 +480,7 @@ func (hm *HealthMonitor) updateHealthStatus(serverName string, isHealthy bool, r
 				hm.logger.Warn("server marked unhealthy",
 					"server", serverName,
 					"consecutive_failures", status.ConsecutiveFailures)
+				hm.events.Publish(Event{Type: EventHealthChanged, Server: serverName, From: "healthy", To: "unhealthy"})
 			}
 		}
 	}
@@ -187,7 +489,66 @@ func (hm *HealthMonitor) updateHealthStatus(serverName string, isHealthy bool, r
 		"server", serverName,
 		"healthy", status.IsHealthy,
 		"failures", status.ConsecutiveFailures,
+		"live", status.IsLive,
 		"response_time", responseTime)
+
+	return hm.checks.DeregisterAfter > 0 && status.NotLiveSince != nil &&
+		now.Sub(*status.NotLiveSince) >= hm.checks.DeregisterAfter
+}
+
+// markUnhealthyRemote marks a server unhealthy because another cluster
+// member found it failing. This instance's own checks mark it healthy again
+// once it passes one.
+func (hm *HealthMonitor) markUnhealthyRemote(serverName, origin string) {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+
+	status, exists := hm.healthMap[serverName]
+	if !exists {
+		status = &HealthStatus{}
+		hm.healthMap[serverName] = status
+	} else if !status.IsHealthy {
+		return
+	}
+
+	status.IsHealthy = false
+	status.ConsecutiveFailures = UnhealthyThreshold
+	status.ConsecutiveSuccesses = 0
+
+	hm.logger.Warn("server marked unhealthy by cluster member", "server", serverName, "member", origin)
+	hm.events.Publish(Event{Type: EventHealthChanged, Server: serverName, From: "healthy", To: "unhealthy", Data: map[string]interface{}{"origin": origin}})
+}
+
+// CheckNow runs an out-of-band health check for a server. Its passing
+// streak restarts, so it must pass RecoveryThreshold checks from now on to
+// count as recovered. Followers leave checks to the leader.
+func (hm *HealthMonitor) CheckNow(ctx context.Context, serverName string) {
+	if hm.registry == nil || !hm.leader.IsLeader() {
+		return
+	}
+
+	server, err := hm.registry.GetServer(serverName)
+	if err != nil || server == nil {
+		return
+	}
+
+	hm.mu.Lock()
+	if status, exists := hm.healthMap[serverName]; exists {
+		status.ConsecutiveSuccesses = 0
+	}
+	hm.mu.Unlock()
+
+	hm.logger.Info("running out-of-band health check", "server", serverName)
+	hm.checkServerHealth(ctx, *server)
+}
+
+// CheckAll runs a round of health checks on every registered server without
+// waiting for the next interval. Followers leave checks to the leader.
+func (hm *HealthMonitor) CheckAll(ctx context.Context) {
+	if hm.registry == nil || !hm.leader.IsLeader() {
+		return
+	}
+	hm.checkAllServers(ctx)
 }
 
 // IsHealthy returns whether a server is currently healthy
@@ -204,6 +565,16 @@ func (hm *HealthMonitor) IsHealthy(serverName string) bool {
 	return status.IsHealthy
 }
 
+// IsLive returns whether a server's process is up, whether or not it can take
+// traffic
+func (hm *HealthMonitor) IsLive(serverName string) bool {
+	hm.mu.RLock()
+	defer hm.mu.RUnlock()
+
+	status, exists := hm.healthMap[serverName]
+	return exists && status.IsLive
+}
+
 // GetHealthStatus returns the complete health status for a server
 func (hm *HealthMonitor) GetHealthStatus(serverName string) (HealthStatus, bool) {
 	hm.mu.RLock()
@@ -215,7 +586,7 @@ func (hm *HealthMonitor) GetHealthStatus(serverName string) (HealthStatus, bool)
 	}
 
 	// Return a copy to avoid race conditions
-	return *status, true
+	return hm.withPrewarm(serverName, *status), true
 }
 
 // GetAllHealthStatuses returns health status for all servers
@@ -225,17 +596,50 @@ func (hm *HealthMonitor) GetAllHealthStatuses() map[string]HealthStatus {
 
 	result := make(map[string]HealthStatus)
 	for name, status := range hm.healthMap {
-		result[name] = *status
+		result[name] = hm.withPrewarm(name, *status)
 	}
 
 	return result
 }
 
+// setPrewarm records the last prewarm of a server
+func (hm *HealthMonitor) setPrewarm(serverName string, result PrewarmResult) {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+
+	hm.prewarm[serverName] = result
+}
+
+// withPrewarm adds a server's last prewarm to a copy of its status; hm.mu
+// must be held
+func (hm *HealthMonitor) withPrewarm(serverName string, status HealthStatus) HealthStatus {
+	if result, exists := hm.prewarm[serverName]; exists {
+		status.Prewarm = &result
+	}
+	return status
+}
+
 // RemoveServer removes health tracking for a server (useful when deregistering)
 func (hm *HealthMonitor) RemoveServer(serverName string) {
 	hm.mu.Lock()
 	defer hm.mu.Unlock()
 
 	delete(hm.healthMap, serverName)
+	delete(hm.prewarm, serverName)
 	hm.logger.Info("removed health tracking for server", "server", serverName)
 }
+
+// subscribeRecovery ties health checks and circuit breakers together: a
+// backend that recovers half-opens its open breaker without waiting for
+// OpenCooldown, and a breaker that opens triggers an immediate health check
+func (app *Application) subscribeRecovery() {
+	app.Events.Subscribe("breaker-recovery", func(e Event) {
+		app.CircuitBreaker.HalfOpenBreaker(e.Server)
+	}, EventBackendRecovered)
+
+	app.Events.Subscribe("breaker-health-check", func(e Event) {
+		if e.To == Open.String() {
+			app.HealthMonitor.CheckNow(app.ctx, e.Server)
+		}
+	}, EventBreakerChanged)
+}
@@ -0,0 +1,101 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"github.com/codytheroux96/go-reverse-proxy/internal/registry"
+)
+
+// Backend-reported health levels of a ready backend
+const (
+	HealthLevelHealthy  = "healthy"
+	HealthLevelDegraded = "degraded"
+)
+
+// DefaultDegradedWeight is the share of a healthy backend's traffic that a
+// degraded one gets
+const DefaultDegradedWeight = 0.25
+
+// MaxHealthDetailBytes bounds the health check body read for structured
+// health
+const MaxHealthDetailBytes = 16 << 10
+
+// healthCheckResult is the outcome of one health check
+type healthCheckResult struct {
+	ready        bool
+	live         bool
+	level        string
+	details      json.RawMessage
+	responseTime time.Duration
+}
+
+// parseHealthDetail reads structured health such as
+// {"status": "degraded", "details": {...}} from a readiness response body.
+// A "fail" or "down" status fails the check even with a 2xx response; a body
+// that is not a JSON object leaves the status code to decide.
+func parseHealthDetail(body []byte) (level string, details json.RawMessage, failed bool) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || body[0] != '{' {
+		return "", nil, false
+	}
+
+	var report struct {
+		Status  string          `json:"status"`
+		Details json.RawMessage `json:"details"`
+	}
+	if err := json.Unmarshal(body, &report); err != nil {
+		return "", nil, false
+	}
+
+	switch strings.ToLower(report.Status) {
+	case "ok", "pass", "up", "healthy":
+		level = HealthLevelHealthy
+	case "warn", "degraded":
+		level = HealthLevelDegraded
+	case "fail", "down", "unhealthy":
+		failed = true
+	}
+	return level, report.Details, failed
+}
+
+// levelWeight returns a server's traffic weight from its reported health
+// level: 1, or the degraded weight while it reports degraded
+func (hm *HealthMonitor) levelWeight(serverName string) float64 {
+	hm.mu.RLock()
+	defer hm.mu.RUnlock()
+
+	if status, exists := hm.healthMap[serverName]; exists && status.Level == HealthLevelDegraded {
+		return hm.checks.DegradedWeight
+	}
+	return 1
+}
+
+// pickByLevel chooses a server at random in proportion to its level weight,
+// and reports false when no server is degraded so the caller can keep its
+// round-robin order
+func (hm *HealthMonitor) pickByLevel(servers []registry.Server) (registry.Server, bool) {
+	weights := make([]float64, len(servers))
+	total := 0.0
+	degraded := false
+	for i, server := range servers {
+		weights[i] = hm.levelWeight(server.Name)
+		degraded = degraded || weights[i] != 1
+		total += weights[i]
+	}
+	if !degraded || total == 0 {
+		return registry.Server{}, false
+	}
+
+	target := rand.Float64() * total
+	for i, weight := range weights {
+		if target < weight {
+			return servers[i], true
+		}
+		target -= weight
+	}
+	return servers[len(servers)-1], true
+}
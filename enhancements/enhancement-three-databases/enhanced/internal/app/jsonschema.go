@@ -0,0 +1,459 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MaxSchemaErrors caps how many validation errors are reported per request
+const MaxSchemaErrors = 20
+
+// jsonSchema is a compiled JSON Schema. It supports the subset used by request
+// body validation: type, enum, const, properties, required,
+// additionalProperties, items, length, range and item count bounds, pattern,
+// allOf/anyOf/oneOf, local $ref pointers and the OpenAPI nullable keyword.
+type jsonSchema struct {
+	types            []string
+	nullable         bool
+	enum             []interface{}
+	constant         *interface{}
+	properties       map[string]*jsonSchema
+	required         []string
+	additional       *jsonSchema
+	noAdditional     bool
+	items            *jsonSchema
+	minLength        *int
+	maxLength        *int
+	minimum          *float64
+	maximum          *float64
+	exclusiveMinimum *float64
+	exclusiveMaximum *float64
+	minItems         *int
+	maxItems         *int
+	pattern          *regexp.Regexp
+	allOf            []*jsonSchema
+	anyOf            []*jsonSchema
+	oneOf            []*jsonSchema
+}
+
+// schemaCompiler compiles a schema, resolving $ref pointers against the
+// document it came from
+type schemaCompiler struct {
+	root interface{}
+	refs map[string]*jsonSchema
+}
+
+// compileJSONSchema compiles the schema found at pointer (e.g. "" or
+// "#/components/schemas/Order") inside a decoded JSON document
+func compileJSONSchema(doc interface{}, pointer string) (*jsonSchema, error) {
+	c := &schemaCompiler{root: doc, refs: make(map[string]*jsonSchema)}
+
+	node, err := c.resolve(pointer)
+	if err != nil {
+		return nil, err
+	}
+	return c.compile(node)
+}
+
+// resolve follows a local JSON pointer such as "#/components/schemas/Order"
+func (c *schemaCompiler) resolve(ref string) (interface{}, error) {
+	if ref != "" && !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("only local $ref pointers are supported, got %q", ref)
+	}
+
+	node := c.root
+	pointer := strings.TrimPrefix(strings.TrimPrefix(ref, "#"), "/")
+	if pointer == "" {
+		return node, nil
+	}
+
+	for _, token := range strings.Split(pointer, "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		switch v := node.(type) {
+		case map[string]interface{}:
+			next, exists := v[token]
+			if !exists {
+				return nil, fmt.Errorf("$ref %q not found", ref)
+			}
+			node = next
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, fmt.Errorf("$ref %q not found", ref)
+			}
+			node = v[i]
+		default:
+			return nil, fmt.Errorf("$ref %q not found", ref)
+		}
+	}
+	return node, nil
+}
+
+func (c *schemaCompiler) compile(node interface{}) (*jsonSchema, error) {
+	// true and false are valid schemas accepting everything and nothing
+	if b, ok := node.(bool); ok {
+		if b {
+			return &jsonSchema{}, nil
+		}
+		return &jsonSchema{enum: []interface{}{}}, nil
+	}
+
+	m, ok := node.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("schema must be an object, got %s", jsonType(node))
+	}
+
+	if ref, ok := m["$ref"].(string); ok {
+		// Refs are compiled once so recursive schemas terminate
+		if s, exists := c.refs[ref]; exists {
+			return s, nil
+		}
+		s := &jsonSchema{}
+		c.refs[ref] = s
+
+		target, err := c.resolve(ref)
+		if err != nil {
+			return nil, err
+		}
+		compiled, err := c.compile(target)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", ref, err)
+		}
+		*s = *compiled
+		return s, nil
+	}
+
+	s := &jsonSchema{}
+
+	switch t := m["type"].(type) {
+	case string:
+		s.types = []string{t}
+	case []interface{}:
+		for _, v := range t {
+			name, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("type must be a string or list of strings")
+			}
+			s.types = append(s.types, name)
+		}
+	case nil:
+	default:
+		return nil, fmt.Errorf("type must be a string or list of strings")
+	}
+
+	s.nullable, _ = m["nullable"].(bool)
+
+	if enum, ok := m["enum"].([]interface{}); ok {
+		s.enum = enum
+	}
+	if constant, exists := m["const"]; exists {
+		s.constant = &constant
+	}
+
+	if props, ok := m["properties"].(map[string]interface{}); ok {
+		s.properties = make(map[string]*jsonSchema, len(props))
+		for name, prop := range props {
+			compiled, err := c.compile(prop)
+			if err != nil {
+				return nil, fmt.Errorf("properties.%s: %w", name, err)
+			}
+			s.properties[name] = compiled
+		}
+	}
+
+	if required, ok := m["required"].([]interface{}); ok {
+		for _, v := range required {
+			if name, ok := v.(string); ok {
+				s.required = append(s.required, name)
+			}
+		}
+	}
+
+	switch additional := m["additionalProperties"].(type) {
+	case bool:
+		s.noAdditional = !additional
+	case map[string]interface{}:
+		compiled, err := c.compile(additional)
+		if err != nil {
+			return nil, fmt.Errorf("additionalProperties: %w", err)
+		}
+		s.additional = compiled
+	}
+
+	if items, exists := m["items"]; exists {
+		compiled, err := c.compile(items)
+		if err != nil {
+			return nil, fmt.Errorf("items: %w", err)
+		}
+		s.items = compiled
+	}
+
+	s.minLength = intKeyword(m, "minLength")
+	s.maxLength = intKeyword(m, "maxLength")
+	s.minItems = intKeyword(m, "minItems")
+	s.maxItems = intKeyword(m, "maxItems")
+	s.minimum = numberKeyword(m, "minimum")
+	s.maximum = numberKeyword(m, "maximum")
+	s.exclusiveMinimum = numberKeyword(m, "exclusiveMinimum")
+	s.exclusiveMaximum = numberKeyword(m, "exclusiveMaximum")
+
+	// OpenAPI 3.0 spells exclusive bounds as booleans next to minimum/maximum
+	if exclusive, _ := m["exclusiveMinimum"].(bool); exclusive {
+		s.exclusiveMinimum, s.minimum = s.minimum, nil
+	}
+	if exclusive, _ := m["exclusiveMaximum"].(bool); exclusive {
+		s.exclusiveMaximum, s.maximum = s.maximum, nil
+	}
+
+	if pattern, ok := m["pattern"].(string); ok {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		s.pattern = re
+	}
+
+	for _, kw := range []struct {
+		name string
+		dst  *[]*jsonSchema
+	}{{"allOf", &s.allOf}, {"anyOf", &s.anyOf}, {"oneOf", &s.oneOf}} {
+		list, ok := m[kw.name].([]interface{})
+		if !ok {
+			continue
+		}
+		for i, sub := range list {
+			compiled, err := c.compile(sub)
+			if err != nil {
+				return nil, fmt.Errorf("%s[%d]: %w", kw.name, i, err)
+			}
+			*kw.dst = append(*kw.dst, compiled)
+		}
+	}
+
+	return s, nil
+}
+
+func intKeyword(m map[string]interface{}, name string) *int {
+	if v, ok := m[name].(float64); ok {
+		n := int(v)
+		return &n
+	}
+	return nil
+}
+
+func numberKeyword(m map[string]interface{}, name string) *float64 {
+	if v, ok := m[name].(float64); ok {
+		return &v
+	}
+	return nil
+}
+
+// jsonType names the JSON type of a decoded value
+func jsonType(v interface{}) string {
+	switch n := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if n == math.Trunc(n) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+// typeMatches reports whether a value's JSON type satisfies a schema type;
+// integers are also numbers
+func typeMatches(actual, want string) bool {
+	return actual == want || (want == "number" && actual == "integer")
+}
+
+// validate checks a decoded JSON value against the schema, returning one
+// message per violation prefixed with the JSON pointer of the offending value
+func (s *jsonSchema) validate(v interface{}) []string {
+	var errs []string
+	s.check(v, "", &errs)
+	if len(errs) > MaxSchemaErrors {
+		errs = append(errs[:MaxSchemaErrors], fmt.Sprintf("and %d more errors", len(errs)-MaxSchemaErrors))
+	}
+	return errs
+}
+
+func (s *jsonSchema) check(v interface{}, path string, errs *[]string) {
+	fail := func(format string, args ...interface{}) {
+		at := path
+		if at == "" {
+			at = "/"
+		}
+		*errs = append(*errs, at+": "+fmt.Sprintf(format, args...))
+	}
+
+	actual := jsonType(v)
+
+	if v == nil && s.nullable {
+		return
+	}
+
+	if len(s.types) > 0 {
+		matched := false
+		for _, want := range s.types {
+			if typeMatches(actual, want) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("expected %s, got %s", strings.Join(s.types, " or "), actual)
+			return
+		}
+	}
+
+	if s.enum != nil {
+		found := false
+		for _, allowed := range s.enum {
+			if reflect.DeepEqual(v, allowed) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			if len(s.enum) == 0 {
+				fail("no value is allowed here")
+			} else {
+				fail("value is not one of the allowed values")
+			}
+		}
+	}
+
+	if s.constant != nil && !reflect.DeepEqual(v, *s.constant) {
+		fail("value does not match the required constant")
+	}
+
+	switch value := v.(type) {
+	case string:
+		length := len([]rune(value))
+		if s.minLength != nil && length < *s.minLength {
+			fail("must be at least %d characters long", *s.minLength)
+		}
+		if s.maxLength != nil && length > *s.maxLength {
+			fail("must be at most %d characters long", *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(value) {
+			fail("does not match pattern %q", s.pattern.String())
+		}
+
+	case float64:
+		if s.minimum != nil && value < *s.minimum {
+			fail("must be >= %v", *s.minimum)
+		}
+		if s.maximum != nil && value > *s.maximum {
+			fail("must be <= %v", *s.maximum)
+		}
+		if s.exclusiveMinimum != nil && value <= *s.exclusiveMinimum {
+			fail("must be > %v", *s.exclusiveMinimum)
+		}
+		if s.exclusiveMaximum != nil && value >= *s.exclusiveMaximum {
+			fail("must be < %v", *s.exclusiveMaximum)
+		}
+
+	case []interface{}:
+		if s.minItems != nil && len(value) < *s.minItems {
+			fail("must have at least %d items", *s.minItems)
+		}
+		if s.maxItems != nil && len(value) > *s.maxItems {
+			fail("must have at most %d items", *s.maxItems)
+		}
+		if s.items != nil {
+			for i, item := range value {
+				s.items.check(item, path+"/"+strconv.Itoa(i), errs)
+			}
+		}
+
+	case map[string]interface{}:
+		for _, name := range s.required {
+			if _, exists := value[name]; !exists {
+				fail("missing required property %q", name)
+			}
+		}
+
+		names := make([]string, 0, len(value))
+		for name := range value {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			childPath := path + "/" + strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+			if prop, exists := s.properties[name]; exists {
+				prop.check(value[name], childPath, errs)
+				continue
+			}
+			if s.noAdditional {
+				fail("unexpected property %q", name)
+				continue
+			}
+			if s.additional != nil {
+				s.additional.check(value[name], childPath, errs)
+			}
+		}
+	}
+
+	for _, sub := range s.allOf {
+		sub.check(v, path, errs)
+	}
+
+	if len(s.anyOf) > 0 {
+		matched := false
+		for _, sub := range s.anyOf {
+			if len(sub.validate(v)) == 0 {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("does not match any of the allowed schemas")
+		}
+	}
+
+	if len(s.oneOf) > 0 {
+		matches := 0
+		for _, sub := range s.oneOf {
+			if len(sub.validate(v)) == 0 {
+				matches++
+			}
+		}
+		if matches != 1 {
+			fail("must match exactly one schema, matched %d", matches)
+		}
+	}
+}
+
+// decodeJSON decodes a JSON document, rejecting trailing data
+func decodeJSON(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("unexpected data after the JSON value")
+	}
+	return v, nil
+}
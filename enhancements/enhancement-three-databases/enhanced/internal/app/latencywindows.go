@@ -0,0 +1,226 @@
+package app
+
+import (
+	"math"
+	"math/bits"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LatencyWindows are the rolling windows GET /admin/metrics/latency reports
+// over with ?window=
+var LatencyWindows = []time.Duration{time.Minute, 5 * time.Minute, 15 * time.Minute, time.Hour}
+
+const (
+	// latencySlotWidth is the granularity of the rolling windows
+	latencySlotWidth = 10 * time.Second
+	// latencySlots covers the longest window
+	latencySlots = int(time.Hour / latencySlotWidth)
+
+	// hdrSubBits sets the precision of the HDR histograms: each power of two
+	// is split into 64 sub-buckets, so a recorded value is within 1% of the
+	// latency it stands for
+	hdrSubBits  = 7
+	hdrSubCount = 1 << hdrSubBits
+	hdrHalf     = hdrSubCount / 2
+	// hdrMaxMicros is the largest latency recorded; longer ones are clamped
+	hdrMaxMicros = uint64(time.Hour / time.Microsecond)
+)
+
+// hdrBuckets is the number of HDR buckets needed to reach hdrMaxMicros
+var hdrBuckets = hdrIndex(hdrMaxMicros) + 1
+
+// hdrIndex returns the HDR bucket of a latency in microseconds. Values
+// below hdrSubCount get a bucket each; above that, every power of two is
+// split into hdrHalf equal buckets.
+func hdrIndex(micros uint64) int {
+	micros = min(micros, hdrMaxMicros)
+	if micros < hdrSubCount {
+		return int(micros)
+	}
+	shift := bits.Len64(micros) - hdrSubBits
+	return hdrSubCount + (shift-1)*hdrHalf + int(micros>>shift) - hdrHalf
+}
+
+// hdrSeconds returns the midpoint of an HDR bucket in seconds
+func hdrSeconds(idx int) float64 {
+	if idx < hdrSubCount {
+		return float64(idx) / 1e6
+	}
+	k := idx - hdrSubCount
+	shift := k/hdrHalf + 1
+	lower := uint64(k%hdrHalf+hdrHalf) << shift
+	width := uint64(1) << shift
+	return float64(lower+width/2) / 1e6
+}
+
+// hdrQuantile returns the q quantile of total observations spread over HDR
+// buckets; count returns the observations in a bucket
+func hdrQuantile(indexes []int, count func(idx int) uint64, total uint64, q float64) float64 {
+	if total == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(total)))
+	var cumulative uint64
+	for _, idx := range indexes {
+		cumulative += count(idx)
+		if cumulative >= rank {
+			return hdrSeconds(idx)
+		}
+	}
+	return hdrSeconds(indexes[len(indexes)-1])
+}
+
+// hdrHistogram is an HDR latency histogram updated with atomics
+type hdrHistogram struct {
+	counts []atomic.Uint64
+}
+
+func newHDRHistogram() *hdrHistogram {
+	return &hdrHistogram{counts: make([]atomic.Uint64, hdrBuckets)}
+}
+
+func (h *hdrHistogram) observe(d time.Duration) {
+	h.counts[hdrIndex(uint64(max(d, 0)/time.Microsecond))].Add(1)
+}
+
+// quantiles returns the given quantiles of the observations so far
+func (h *hdrHistogram) quantiles(qs ...float64) []float64 {
+	counts := make([]uint64, len(h.counts))
+	var total uint64
+	var indexes []int
+	for i := range h.counts {
+		if counts[i] = h.counts[i].Load(); counts[i] > 0 {
+			total += counts[i]
+			indexes = append(indexes, i)
+		}
+	}
+
+	out := make([]float64, len(qs))
+	for i, q := range qs {
+		out[i] = hdrQuantile(indexes, func(idx int) uint64 { return counts[idx] }, total, q)
+	}
+	return out
+}
+
+// latencySlot holds the observations of one latencySlotWidth interval,
+// with HDR buckets kept sparsely since most latencies fall in few of them
+type latencySlot struct {
+	epoch         int64 // interval number since the Unix epoch
+	count, errors uint64
+	sum, min, max float64
+	buckets       map[int]uint64
+}
+
+// rollingLatency keeps an hour of latency and errors in
+// latencySlotWidth slots, so any of LatencyWindows can be summarised
+type rollingLatency struct {
+	mu    sync.Mutex
+	slots [latencySlots]latencySlot
+}
+
+func (rl *rollingLatency) observe(now time.Time, d time.Duration, failed bool) {
+	epoch := now.UnixNano() / int64(latencySlotWidth)
+	seconds := d.Seconds()
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	s := &rl.slots[epoch%int64(latencySlots)]
+	if s.epoch != epoch || s.buckets == nil {
+		buckets := s.buckets
+		if buckets == nil {
+			buckets = make(map[int]uint64)
+		}
+		clear(buckets)
+		*s = latencySlot{epoch: epoch, min: seconds, max: seconds, buckets: buckets}
+	}
+
+	s.count++
+	if failed {
+		s.errors++
+	}
+	s.sum += seconds
+	s.min = min(s.min, seconds)
+	s.max = max(s.max, seconds)
+	s.buckets[hdrIndex(uint64(max(d, 0)/time.Microsecond))]++
+}
+
+// LatencyWindowSnapshot summarises latency and errors over a rolling window
+type LatencyWindowSnapshot struct {
+	Count     uint64  `json:"count"`
+	Errors    uint64  `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+	MinSecs   float64 `json:"min_seconds"`
+	MaxSecs   float64 `json:"max_seconds"`
+	AvgSecs   float64 `json:"avg_seconds"`
+	P50Secs   float64 `json:"p50_seconds"`
+	P90Secs   float64 `json:"p90_seconds"`
+	P99Secs   float64 `json:"p99_seconds"`
+	P999Secs  float64 `json:"p999_seconds"`
+}
+
+// summary merges the slots that fall within window of now
+func (rl *rollingLatency) summary(now time.Time, window time.Duration) LatencyWindowSnapshot {
+	current := now.UnixNano() / int64(latencySlotWidth)
+	oldest := current - int64(window/latencySlotWidth) + 1
+
+	var snap LatencyWindowSnapshot
+	var sum float64
+	buckets := make(map[int]uint64)
+
+	rl.mu.Lock()
+	for i := range rl.slots {
+		s := &rl.slots[i]
+		if s.count == 0 || s.epoch < oldest || s.epoch > current {
+			continue
+		}
+		if snap.Count == 0 || s.min < snap.MinSecs {
+			snap.MinSecs = s.min
+		}
+		snap.MaxSecs = max(snap.MaxSecs, s.max)
+		snap.Count += s.count
+		snap.Errors += s.errors
+		sum += s.sum
+		for idx, c := range s.buckets {
+			buckets[idx] += c
+		}
+	}
+	rl.mu.Unlock()
+
+	if snap.Count == 0 {
+		return snap
+	}
+
+	indexes := make([]int, 0, len(buckets))
+	for idx := range buckets {
+		indexes = append(indexes, idx)
+	}
+	sort.Ints(indexes)
+	count := func(idx int) uint64 { return buckets[idx] }
+
+	snap.ErrorRate = float64(snap.Errors) / float64(snap.Count)
+	snap.AvgSecs = sum / float64(snap.Count)
+	snap.P50Secs = hdrQuantile(indexes, count, snap.Count, 0.50)
+	snap.P90Secs = hdrQuantile(indexes, count, snap.Count, 0.90)
+	snap.P99Secs = hdrQuantile(indexes, count, snap.Count, 0.99)
+	snap.P999Secs = hdrQuantile(indexes, count, snap.Count, 0.999)
+	return snap
+}
+
+// parseLatencyWindow reads ?window=, which must be one of LatencyWindows
+func parseLatencyWindow(r *http.Request) (time.Duration, bool) {
+	window, err := time.ParseDuration(r.URL.Query().Get("window"))
+	if err != nil {
+		return 0, false
+	}
+	for _, w := range LatencyWindows {
+		if w == window {
+			return window, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,111 @@
+package app
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHDRIndexPrecision(t *testing.T) {
+	for micros := uint64(1); micros <= hdrMaxMicros; micros = micros*3/2 + 1 {
+		got := hdrSeconds(hdrIndex(micros)) * 1e6
+		if diff := math.Abs(got-float64(micros)) / float64(micros); diff > 0.01 {
+			t.Fatalf("%dµs recorded as %.1fµs, off by %.2f%%", micros, got, diff*100)
+		}
+	}
+	if hdrIndex(hdrMaxMicros*10) != hdrBuckets-1 {
+		t.Fatal("latency above the maximum was not clamped")
+	}
+}
+
+func TestLatencyPercentiles(t *testing.T) {
+	lm := NewLatencyMetrics(NewRouteTemplates())
+	for i := 1; i <= 1000; i++ {
+		lm.Observe("one", "/api", time.Duration(i)*time.Millisecond, i > 990)
+	}
+
+	backends, _ := lm.Snapshot()
+	snap := backends["one"]
+	for _, tt := range []struct {
+		name string
+		got  float64
+		want float64
+	}{
+		{"p50", snap.P50Secs, 0.500},
+		{"p90", snap.P90Secs, 0.900},
+		{"p99", snap.P99Secs, 0.990},
+		{"p999", snap.P999Secs, 0.999},
+	} {
+		if math.Abs(tt.got-tt.want)/tt.want > 0.01 {
+			t.Errorf("%s = %.4fs, want %.3fs within 1%%", tt.name, tt.got, tt.want)
+		}
+	}
+	if snap.Errors != 10 || snap.ErrorRate != 0.01 {
+		t.Errorf("errors %d, rate %v; want 10 and 0.01", snap.Errors, snap.ErrorRate)
+	}
+}
+
+func TestRollingLatencyWindows(t *testing.T) {
+	var rl rollingLatency
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// 40 minutes ago: slow and failing; 3 minutes ago: fast and healthy
+	for i := 0; i < 10; i++ {
+		rl.observe(now.Add(-40*time.Minute), 2*time.Second, true)
+		rl.observe(now.Add(-3*time.Minute), 10*time.Millisecond, false)
+	}
+
+	if snap := rl.summary(now, time.Minute); snap.Count != 0 {
+		t.Fatalf("1m window counted %d requests, want 0", snap.Count)
+	}
+
+	snap := rl.summary(now, 5*time.Minute)
+	if snap.Count != 10 || snap.Errors != 0 || snap.MaxSecs != 0.01 {
+		t.Fatalf("5m window: %+v", snap)
+	}
+
+	snap = rl.summary(now, time.Hour)
+	if snap.Count != 20 || snap.Errors != 10 || snap.ErrorRate != 0.5 {
+		t.Fatalf("1h window: %+v", snap)
+	}
+	if math.Abs(snap.P99Secs-2)/2 > 0.01 {
+		t.Fatalf("1h p99 = %vs, want 2s", snap.P99Secs)
+	}
+
+	// An hour later the slot is reused for new observations only
+	rl.observe(now.Add(20*time.Minute), time.Millisecond, false)
+	if snap := rl.summary(now.Add(20*time.Minute), time.Hour); snap.Count != 11 || snap.Errors != 0 {
+		t.Fatalf("after the old slot was reused: %+v", snap)
+	}
+}
+
+func TestHandleLatencyMetricsWindow(t *testing.T) {
+	app := newTestApp(t)
+	app.Latency.Observe("one", "/api", 5*time.Millisecond, false)
+	app.Latency.Observe("one", "/api", 7*time.Millisecond, true)
+
+	rec := httptest.NewRecorder()
+	app.HandleLatencyMetrics(rec, httptest.NewRequest(http.MethodGet, "/admin/metrics/latency?window=5m", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+	var body struct {
+		Window   string                           `json:"window"`
+		Backends map[string]LatencyWindowSnapshot `json:"backends"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if got := body.Backends["one"]; body.Window != "5m0s" || got.Count != 2 || got.ErrorRate != 0.5 {
+		t.Fatalf("window %q, backend %+v", body.Window, got)
+	}
+
+	rec = httptest.NewRecorder()
+	app.HandleLatencyMetrics(rec, httptest.NewRequest(http.MethodGet, "/admin/metrics/latency?window=7m", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("unsupported window answered %d, want 400", rec.Code)
+	}
+}
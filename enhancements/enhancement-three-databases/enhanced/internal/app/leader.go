@@ -0,0 +1,294 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/codytheroux96/go-reverse-proxy/internal/db"
+)
+
+const (
+	// LeaderElectionInterval is how often leadership is acquired or renewed
+	LeaderElectionInterval = 5 * time.Second
+	// LeaderLeaseTTL is how long a leader keeps its lease without renewing
+	// it, bounding how long instances go without a leader after it fails
+	LeaderLeaseTTL = 3 * LeaderElectionInterval
+	// LeaderRenewTimeout bounds each acquire, renew or release call
+	LeaderRenewTimeout = 2 * time.Second
+	// leaderLockKey is the Postgres advisory lock key for leadership
+	leaderLockKey int64 = 0x7270726f78790001
+)
+
+// LeaderBackend coordinates leadership between proxy instances and shares
+// the leader's health check results with the others
+type LeaderBackend interface {
+	// Name identifies the backend in logs and the admin API
+	Name() string
+	// Acquire takes or renews leadership for id and reports whether id leads
+	Acquire(ctx context.Context, id string) (bool, error)
+	// Release gives up leadership if id holds it
+	Release(ctx context.Context, id string) error
+	// StoreHealth publishes the leader's health statuses
+	StoreHealth(ctx context.Context, statuses map[string]HealthStatus) error
+	// LoadHealth returns the health statuses last published by the leader
+	LoadHealth(ctx context.Context) (map[string]HealthStatus, error)
+}
+
+// LeaderElection decides which of several proxy instances runs active
+// health checks. Every instance serves traffic; followers route using the
+// health statuses the leader publishes.
+type LeaderElection struct {
+	backend LeaderBackend
+	id      string
+	leader  atomic.Bool
+	since   atomic.Pointer[time.Time]
+	logger  *slog.Logger
+	// events receives leadership changes; nil discards them
+	events *EventBus
+}
+
+// NewLeaderElection creates an election for this instance under id, or a
+// hostname and pid based id when empty
+func NewLeaderElection(backend LeaderBackend, id string, logger *slog.Logger) *LeaderElection {
+	if id == "" {
+		host, _ := os.Hostname()
+		id = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+	return &LeaderElection{backend: backend, id: id, logger: logger}
+}
+
+// IsLeader reports whether this instance leads. Without an election every
+// instance acts as its own leader.
+func (le *LeaderElection) IsLeader() bool {
+	return le == nil || le.leader.Load()
+}
+
+// Run acquires and renews leadership every interval until ctx is done, then
+// releases it
+func (le *LeaderElection) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	le.campaign(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			if le.leader.Load() {
+				releaseCtx, cancel := context.WithTimeout(context.Background(), LeaderRenewTimeout)
+				if err := le.backend.Release(releaseCtx, le.id); err != nil {
+					le.logger.Warn("failed to release leadership", "backend", le.backend.Name(), "error", err)
+				}
+				cancel()
+				le.setLeader(false)
+			}
+			return
+		case <-ticker.C:
+			le.campaign(ctx)
+		}
+	}
+}
+
+// campaign makes one attempt to acquire or renew leadership. An instance
+// that cannot reach the backend steps down, since it can no longer be sure
+// no other instance leads.
+func (le *LeaderElection) campaign(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, LeaderRenewTimeout)
+	defer cancel()
+
+	leader, err := le.backend.Acquire(ctx, le.id)
+	if err != nil {
+		le.logger.Warn("leader election failed", "backend", le.backend.Name(), "error", err)
+		leader = false
+	}
+	le.setLeader(leader)
+}
+
+func (le *LeaderElection) setLeader(leader bool) {
+	if le.leader.Swap(leader) == leader {
+		return
+	}
+
+	now := time.Now()
+	le.since.Store(&now)
+
+	from, to := "leader", "follower"
+	if leader {
+		from, to = to, from
+		le.logger.Info("acquired leadership", "instance", le.id, "backend", le.backend.Name())
+	} else {
+		le.logger.Info("lost leadership", "instance", le.id, "backend", le.backend.Name())
+	}
+	le.events.Publish(Event{Type: EventLeadershipChanged, From: from, To: to, Data: map[string]interface{}{"instance": le.id}})
+}
+
+// PostgresLeaderBackend elects the instance holding a session advisory lock
+// as leader and shares health through the backend_health table. The lock is
+// held on a dedicated connection, so it is released as soon as a failed
+// leader's connection drops.
+type PostgresLeaderBackend struct {
+	db      *sql.DB
+	queries *db.Queries
+	mu      sync.Mutex
+	conn    *sql.Conn
+	held    bool
+}
+
+// NewPostgresLeaderBackend creates a leader backend on the given database
+func NewPostgresLeaderBackend(database *sql.DB) *PostgresLeaderBackend {
+	return &PostgresLeaderBackend{db: database, queries: db.New(database)}
+}
+
+func (b *PostgresLeaderBackend) Name() string { return "postgres" }
+
+func (b *PostgresLeaderBackend) Acquire(ctx context.Context, id string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.conn == nil {
+		conn, err := b.db.Conn(ctx)
+		if err != nil {
+			return false, fmt.Errorf("failed to open leader connection: %w", err)
+		}
+		b.conn = conn
+	}
+
+	// The lock lasts as long as the session, so renewing only means checking
+	// the connection is still alive
+	if b.held {
+		if err := b.conn.PingContext(ctx); err != nil {
+			b.closeConn()
+			return false, fmt.Errorf("lost leader connection: %w", err)
+		}
+		return true, nil
+	}
+
+	held, err := db.New(b.conn).TryAdvisoryLock(ctx, leaderLockKey)
+	if err != nil {
+		b.closeConn()
+		return false, fmt.Errorf("failed to try leader lock: %w", err)
+	}
+	b.held = held
+	return held, nil
+}
+
+func (b *PostgresLeaderBackend) Release(ctx context.Context, id string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.conn == nil {
+		return nil
+	}
+	defer b.closeConn()
+
+	if b.held {
+		if _, err := db.New(b.conn).AdvisoryUnlock(ctx, leaderLockKey); err != nil {
+			return fmt.Errorf("failed to release leader lock: %w", err)
+		}
+	}
+	return nil
+}
+
+// closeConn drops the leader connection and with it any lock it held
+func (b *PostgresLeaderBackend) closeConn() {
+	b.conn.Close()
+	b.conn = nil
+	b.held = false
+}
+
+func (b *PostgresLeaderBackend) StoreHealth(ctx context.Context, statuses map[string]HealthStatus) error {
+	for name, status := range statuses {
+		params := db.UpsertBackendHealthParams{
+			ServerName:                  name,
+			IsHealthy:                   status.IsHealthy,
+			ConsecutiveFailures:         int32(status.ConsecutiveFailures),
+			ConsecutiveSuccesses:        int32(status.ConsecutiveSuccesses),
+			LastChecked:                 status.LastChecked,
+			LastResponseTimeMs:          status.LastResponseTime.Milliseconds(),
+			IsLive:                      status.IsLive,
+			ConsecutiveLivenessFailures: int32(status.ConsecutiveLivenessFailures),
+			Level:                       status.Level,
+			Details:                     status.Details,
+			Started:                     status.Started,
+		}
+		if params.Details == nil {
+			params.Details = json.RawMessage("null")
+		}
+		if status.NotLiveSince != nil {
+			params.NotLiveSince = sql.NullTime{Time: *status.NotLiveSince, Valid: true}
+		}
+		err := b.queries.UpsertBackendHealth(ctx, params)
+		if err != nil {
+			return fmt.Errorf("failed to store health for %s: %w", name, err)
+		}
+	}
+
+	// Rows the leader stopped updating belong to deregistered backends
+	if err := b.queries.PruneBackendHealth(ctx, time.Now().Add(-LeaderLeaseTTL)); err != nil {
+		return fmt.Errorf("failed to prune backend health: %w", err)
+	}
+	return nil
+}
+
+func (b *PostgresLeaderBackend) LoadHealth(ctx context.Context) (map[string]HealthStatus, error) {
+	rows, err := b.queries.ListBackendHealth(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load backend health: %w", err)
+	}
+
+	statuses := make(map[string]HealthStatus, len(rows))
+	for _, row := range rows {
+		status := HealthStatus{
+			IsHealthy:                   row.IsHealthy,
+			LastChecked:                 row.LastChecked,
+			ConsecutiveFailures:         int(row.ConsecutiveFailures),
+			ConsecutiveSuccesses:        int(row.ConsecutiveSuccesses),
+			LastResponseTime:            time.Duration(row.LastResponseTimeMs) * time.Millisecond,
+			IsLive:                      row.IsLive,
+			ConsecutiveLivenessFailures: int(row.ConsecutiveLivenessFailures),
+			Level:                       row.Level,
+			Started:                     row.Started,
+		}
+		if string(row.Details) != "null" {
+			status.Details = row.Details
+		}
+		if row.NotLiveSince.Valid {
+			since := row.NotLiveSince.Time
+			status.NotLiveSince = &since
+		}
+		statuses[row.ServerName] = status
+	}
+	return statuses, nil
+}
+
+// HandleLeader serves GET /admin/leader
+func (app *Application) HandleLeader(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	le := app.Leader
+	if le == nil {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": false, "leader": true})
+		return
+	}
+
+	resp := map[string]interface{}{
+		"enabled":  true,
+		"backend":  le.backend.Name(),
+		"instance": le.id,
+		"leader":   le.IsLeader(),
+	}
+	if since := le.since.Load(); since != nil {
+		resp["since"] = *since
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
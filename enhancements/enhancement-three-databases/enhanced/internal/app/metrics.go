@@ -0,0 +1,425 @@
+package app
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds, in seconds, of the latency histogram buckets
+var LatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Histogram is a fixed-bucket latency histogram. Observations are recorded
+// with atomics so concurrent requests never contend on a lock.
+type Histogram struct {
+	bounds  []float64
+	counts  []atomic.Uint64 // counts[i] observations <= bounds[i]; last slot is +Inf
+	sumBits atomic.Uint64   // float64 bits of the sum in seconds
+	minBits atomic.Uint64   // float64 bits of the minimum in seconds
+	maxBits atomic.Uint64   // float64 bits of the maximum in seconds
+}
+
+// NewHistogram creates a histogram with the given bucket upper bounds
+func NewHistogram(bounds []float64) *Histogram {
+	h := &Histogram{
+		bounds: bounds,
+		counts: make([]atomic.Uint64, len(bounds)+1),
+	}
+	h.minBits.Store(math.Float64bits(math.Inf(1)))
+	return h
+}
+
+// Observe records a single latency. The minimum and maximum are updated
+// before the count, so a Snapshot that sees the count also sees them.
+func (h *Histogram) Observe(d time.Duration) {
+	seconds := d.Seconds()
+	idx := sort.SearchFloat64s(h.bounds, seconds)
+
+	atomicUpdateFloat(&h.minBits, func(old float64) (float64, bool) { return seconds, seconds < old })
+	atomicUpdateFloat(&h.maxBits, func(old float64) (float64, bool) { return seconds, seconds > old })
+	atomicUpdateFloat(&h.sumBits, func(old float64) (float64, bool) { return old + seconds, true })
+	h.counts[idx].Add(1)
+}
+
+// atomicUpdateFloat applies update to a float64 stored as bits, retrying on
+// concurrent modification; update reports false to leave the value unchanged
+func atomicUpdateFloat(bits *atomic.Uint64, update func(old float64) (float64, bool)) {
+	for {
+		oldBits := bits.Load()
+		next, ok := update(math.Float64frombits(oldBits))
+		if !ok || bits.CompareAndSwap(oldBits, math.Float64bits(next)) {
+			return
+		}
+	}
+}
+
+// HistogramBucket is a cumulative bucket in a histogram snapshot
+type HistogramBucket struct {
+	UpperBound string `json:"le"`
+	Count      uint64 `json:"count"`
+}
+
+// HistogramSnapshot is a point-in-time copy of a histogram for the admin API
+type HistogramSnapshot struct {
+	Count   uint64            `json:"count"`
+	SumSecs float64           `json:"sum_seconds"`
+	MinSecs float64           `json:"min_seconds"`
+	MaxSecs float64           `json:"max_seconds"`
+	AvgSecs float64           `json:"avg_seconds"`
+	P50Secs float64           `json:"p50_seconds"`
+	P90Secs float64           `json:"p90_seconds"`
+	P99Secs float64           `json:"p99_seconds"`
+	Buckets []HistogramBucket `json:"buckets"`
+}
+
+// Snapshot returns cumulative bucket counts and estimated percentiles. The
+// total count is derived from the buckets so the two always agree even while
+// observations are being recorded. Counts are read before the minimum, so a
+// non-zero count never comes with the +Inf minimum of an empty histogram,
+// which JSON cannot encode.
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	counts := make([]uint64, len(h.counts))
+	var total uint64
+	for i := range h.counts {
+		counts[i] = h.counts[i].Load()
+		total += counts[i]
+	}
+
+	sum := math.Float64frombits(h.sumBits.Load())
+	maxSecs := math.Float64frombits(h.maxBits.Load())
+
+	snap := HistogramSnapshot{
+		Count:   total,
+		SumSecs: sum,
+		MaxSecs: maxSecs,
+		Buckets: make([]HistogramBucket, 0, len(counts)),
+	}
+
+	if total > 0 {
+		snap.MinSecs = math.Float64frombits(h.minBits.Load())
+		snap.AvgSecs = sum / float64(total)
+		snap.P50Secs = h.quantile(counts, total, maxSecs, 0.50)
+		snap.P90Secs = h.quantile(counts, total, maxSecs, 0.90)
+		snap.P99Secs = h.quantile(counts, total, maxSecs, 0.99)
+	}
+
+	var cumulative uint64
+	for i, c := range counts {
+		cumulative += c
+		bound := "+Inf"
+		if i < len(h.bounds) {
+			bound = formatBound(h.bounds[i])
+		}
+		snap.Buckets = append(snap.Buckets, HistogramBucket{UpperBound: bound, Count: cumulative})
+	}
+
+	return snap
+}
+
+// quantile estimates a quantile from a snapshot of bucket counts by linear
+// interpolation within its bucket
+func (h *Histogram) quantile(counts []uint64, total uint64, maxSecs, q float64) float64 {
+	rank := q * float64(total)
+
+	var cumulative uint64
+	for i, c := range counts {
+		if float64(cumulative+c) >= rank && c > 0 {
+			lower := 0.0
+			if i > 0 {
+				lower = h.bounds[i-1]
+			}
+			upper := maxSecs
+			if i < len(h.bounds) && h.bounds[i] < upper {
+				upper = h.bounds[i]
+			}
+			if upper < lower {
+				return lower
+			}
+			fraction := (rank - float64(cumulative)) / float64(c)
+			return lower + (upper-lower)*fraction
+		}
+		cumulative += c
+	}
+
+	return maxSecs
+}
+
+// formatBound renders a bucket bound given in seconds as a duration string
+func formatBound(b float64) string {
+	return time.Duration(b * float64(time.Second)).String()
+}
+
+// LatencyMetrics tracks upstream latency and errors per backend and per
+// route, both since start and over rolling windows
+type LatencyMetrics struct {
+	mu        sync.RWMutex
+	backends  map[string]*latencySeries
+	routes    map[string]*latencySeries
+	templates *RouteTemplates
+}
+
+// latencySeries is the latency record of one backend or route. hist keeps
+// the fixed buckets the ramp controller and Prometheus-style output use; hdr
+// gives the percentiles.
+type latencySeries struct {
+	hist   *Histogram
+	hdr    *hdrHistogram
+	errors atomic.Uint64
+	window rollingLatency
+}
+
+func (s *latencySeries) observe(now time.Time, d time.Duration, failed bool) {
+	s.hist.Observe(d)
+	s.hdr.observe(d)
+	if failed {
+		s.errors.Add(1)
+	}
+	s.window.observe(now, d, failed)
+}
+
+// LatencySnapshot is the latency and errors of a backend or route since
+// start. Its percentiles come from an HDR histogram, so they are within 1%
+// of the observed latencies rather than estimated within a bucket.
+type LatencySnapshot struct {
+	HistogramSnapshot
+	P999Secs  float64 `json:"p999_seconds"`
+	Errors    uint64  `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+}
+
+func (s *latencySeries) snapshot() LatencySnapshot {
+	snap := LatencySnapshot{HistogramSnapshot: s.hist.Snapshot(), Errors: s.errors.Load()}
+	if snap.Count > 0 {
+		q := s.hdr.quantiles(0.50, 0.90, 0.99, 0.999)
+		snap.P50Secs, snap.P90Secs, snap.P99Secs, snap.P999Secs = q[0], q[1], q[2], q[3]
+		snap.ErrorRate = min(float64(snap.Errors)/float64(snap.Count), 1)
+	}
+	return snap
+}
+
+// NewLatencyMetrics creates an empty set of latency histograms, labelling
+// routes by templates
+func NewLatencyMetrics(templates *RouteTemplates) *LatencyMetrics {
+	return &LatencyMetrics{
+		backends:  make(map[string]*latencySeries),
+		routes:    make(map[string]*latencySeries),
+		templates: templates,
+	}
+}
+
+// Observe records a request latency against its backend and route
+// template; failed marks a transport error or 5xx answer
+func (lm *LatencyMetrics) Observe(backend, route string, d time.Duration, failed bool) {
+	now := time.Now()
+	lm.series(lm.backends, backend).observe(now, d, failed)
+	lm.series(lm.routes, lm.templates.Label(route)).observe(now, d, failed)
+}
+
+// series returns the series for key, creating it if needed
+func (lm *LatencyMetrics) series(m map[string]*latencySeries, key string) *latencySeries {
+	lm.mu.RLock()
+	s, exists := m[key]
+	lm.mu.RUnlock()
+	if exists {
+		return s
+	}
+
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	if s, exists = m[key]; !exists {
+		s = &latencySeries{hist: NewHistogram(LatencyBuckets), hdr: newHDRHistogram()}
+		m[key] = s
+	}
+	return s
+}
+
+// RemoveBackend drops the histogram for a backend (useful when deregistering)
+func (lm *LatencyMetrics) RemoveBackend(backend string) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	delete(lm.backends, backend)
+}
+
+// backendCounts returns the bucket counts of the given backends' histograms
+// summed together
+func (lm *LatencyMetrics) backendCounts(backends []string) []uint64 {
+	lm.mu.RLock()
+	defer lm.mu.RUnlock()
+
+	counts := make([]uint64, len(LatencyBuckets)+1)
+	for _, backend := range backends {
+		if s, exists := lm.backends[backend]; exists {
+			for i := range s.hist.counts {
+				counts[i] += s.hist.counts[i].Load()
+			}
+		}
+	}
+	return counts
+}
+
+// latencyQuantile estimates a quantile from LatencyBuckets bucket counts,
+// treating the +Inf bucket as ending at the largest bound
+func latencyQuantile(counts []uint64, q float64) float64 {
+	var total uint64
+	for _, c := range counts {
+		total += c
+	}
+	if total == 0 {
+		return 0
+	}
+
+	h := &Histogram{bounds: LatencyBuckets}
+	return h.quantile(counts, total, LatencyBuckets[len(LatencyBuckets)-1], q)
+}
+
+// Snapshot returns every backend and route since start
+func (lm *LatencyMetrics) Snapshot() (map[string]LatencySnapshot, map[string]LatencySnapshot) {
+	lm.mu.RLock()
+	defer lm.mu.RUnlock()
+
+	backends := make(map[string]LatencySnapshot, len(lm.backends))
+	for name, s := range lm.backends {
+		backends[name] = s.snapshot()
+	}
+
+	routes := make(map[string]LatencySnapshot, len(lm.routes))
+	for prefix, s := range lm.routes {
+		routes[prefix] = s.snapshot()
+	}
+
+	return backends, routes
+}
+
+// WindowSnapshot returns every backend and route over the window ending at
+// now, leaving out those with no requests in it
+func (lm *LatencyMetrics) WindowSnapshot(now time.Time, window time.Duration) (map[string]LatencyWindowSnapshot, map[string]LatencyWindowSnapshot) {
+	lm.mu.RLock()
+	defer lm.mu.RUnlock()
+
+	summarise := func(m map[string]*latencySeries) map[string]LatencyWindowSnapshot {
+		out := make(map[string]LatencyWindowSnapshot, len(m))
+		for key, s := range m {
+			if snap := s.window.summary(now, window); snap.Count > 0 {
+				out[key] = snap
+			}
+		}
+		return out
+	}
+	return summarise(lm.backends), summarise(lm.routes)
+}
+
+// HandleLatencyMetrics serves GET /admin/metrics/latency; ?window=5m
+// reports over a rolling window instead of since start
+func (app *Application) HandleLatencyMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if r.URL.Query().Has("window") {
+		window, ok := parseLatencyWindow(r)
+		if !ok {
+			http.Error(w, "window must be one of 1m, 5m, 15m or 1h", http.StatusBadRequest)
+			return
+		}
+
+		backends, routes := app.Latency.WindowSnapshot(time.Now(), window)
+		writeJSON(w, http.StatusOK, struct {
+			Window   string                           `json:"window"`
+			Backends map[string]LatencyWindowSnapshot `json:"backends"`
+			Routes   map[string]LatencyWindowSnapshot `json:"routes"`
+		}{
+			Window:   window.String(),
+			Backends: backends,
+			Routes:   routes,
+		})
+		return
+	}
+
+	backends, routes := app.Latency.Snapshot()
+
+	writeJSON(w, http.StatusOK, struct {
+		Backends map[string]LatencySnapshot `json:"backends"`
+		Routes   map[string]LatencySnapshot `json:"routes"`
+	}{
+		Backends: backends,
+		Routes:   routes,
+	})
+}
+
+// RequestCounters are hot-path request counters, updated with atomics
+type RequestCounters struct {
+	Requests        atomic.Uint64
+	CacheHits       atomic.Uint64
+	CacheMisses     atomic.Uint64
+	BreakerAllowed  atomic.Uint64
+	BreakerRejected atomic.Uint64
+
+	// errors counts error responses by code; its keys are added on first use
+	errorsMu sync.RWMutex
+	errors   map[string]*atomic.Uint64
+}
+
+// countError counts an error response with the given code
+func (rc *RequestCounters) countError(code string) {
+	rc.errorsMu.RLock()
+	counter, exists := rc.errors[code]
+	rc.errorsMu.RUnlock()
+	if !exists {
+		rc.errorsMu.Lock()
+		if counter, exists = rc.errors[code]; !exists {
+			if rc.errors == nil {
+				rc.errors = make(map[string]*atomic.Uint64)
+			}
+			counter = &atomic.Uint64{}
+			rc.errors[code] = counter
+		}
+		rc.errorsMu.Unlock()
+	}
+	counter.Add(1)
+}
+
+// RequestCountersSnapshot is a point-in-time copy of the request counters
+type RequestCountersSnapshot struct {
+	Requests        uint64 `json:"requests"`
+	CacheHits       uint64 `json:"cache_hits"`
+	CacheMisses     uint64 `json:"cache_misses"`
+	BreakerAllowed  uint64 `json:"breaker_allowed"`
+	BreakerRejected uint64 `json:"breaker_rejected"`
+	// Errors counts error responses by code
+	Errors map[string]uint64 `json:"errors"`
+}
+
+// Snapshot returns the current counter values
+func (rc *RequestCounters) Snapshot() RequestCountersSnapshot {
+	rc.errorsMu.RLock()
+	errors := make(map[string]uint64, len(rc.errors))
+	for code, counter := range rc.errors {
+		errors[code] = counter.Load()
+	}
+	rc.errorsMu.RUnlock()
+
+	return RequestCountersSnapshot{
+		Requests:        rc.Requests.Load(),
+		CacheHits:       rc.CacheHits.Load(),
+		CacheMisses:     rc.CacheMisses.Load(),
+		BreakerAllowed:  rc.BreakerAllowed.Load(),
+		BreakerRejected: rc.BreakerRejected.Load(),
+		Errors:          errors,
+	}
+}
+
+// HandleRequestMetrics serves GET /admin/metrics/requests
+func (app *Application) HandleRequestMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, app.Counters.Snapshot())
+}
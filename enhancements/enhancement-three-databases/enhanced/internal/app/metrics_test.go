@@ -0,0 +1,71 @@
+package app
+
+import (
+	"encoding/json"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+func BenchmarkHistogramObserveParallel(b *testing.B) {
+	h := NewHistogram(LatencyBuckets)
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		d := time.Duration(0)
+		for pb.Next() {
+			h.Observe(d)
+			d = (d + 37*time.Millisecond) % (3 * time.Second)
+		}
+	})
+}
+
+func BenchmarkLatencyObserveParallel(b *testing.B) {
+	lm := NewLatencyMetrics(NewRouteTemplates())
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			lm.Observe("api", "/api", 12*time.Millisecond, false)
+		}
+	})
+}
+
+func BenchmarkRequestCountersParallel(b *testing.B) {
+	var rc RequestCounters
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			rc.Requests.Add(1)
+			rc.CacheHits.Add(1)
+			rc.countError("upstream_error")
+		}
+	})
+}
+
+// TestHistogramSnapshotWhileObserving takes snapshots as the first
+// observations land; each must encode as JSON, so none may report the
+// +Inf minimum of an empty histogram
+func TestHistogramSnapshotWhileObserving(t *testing.T) {
+	for round := 0; round < 200; round++ {
+		h := NewHistogram(LatencyBuckets)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				h.Observe(time.Duration(i+1) * time.Millisecond)
+			}()
+		}
+		for i := 0; i < 20; i++ {
+			snap := h.Snapshot()
+			if math.IsInf(snap.MinSecs, 0) {
+				t.Fatalf("snapshot with count %d has min %v", snap.Count, snap.MinSecs)
+			}
+			if _, err := json.Marshal(snap); err != nil {
+				t.Fatal(err)
+			}
+		}
+		wg.Wait()
+	}
+}
@@ -0,0 +1,125 @@
+package app
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Middleware wraps an http.Handler with additional behavior
+type Middleware func(http.Handler) http.Handler
+
+// Chain wraps h with middleware so the first middleware is the outermost,
+// i.e. Chain(h, a, b) handles a request as a -> b -> h
+func Chain(h http.Handler, middleware ...Middleware) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+// Use appends middleware to the global chain applied to every request.
+// Middleware runs in the order it was added.
+func (app *Application) Use(middleware ...Middleware) {
+	app.middleware = append(app.middleware, middleware...)
+}
+
+// UseAdmin appends middleware applied only to the /admin/ routes
+func (app *Application) UseAdmin(middleware ...Middleware) {
+	app.adminMiddleware = append(app.adminMiddleware, middleware...)
+}
+
+// Handler returns the proxy's routes wrapped in the global middleware chain
+func (app *Application) Handler() http.Handler {
+	return Chain(app.Routes(), app.middleware...)
+}
+
+// handle registers a handler on the mux with optional per-route middleware
+func handle(mux *http.ServeMux, pattern string, handler http.HandlerFunc, middleware ...Middleware) {
+	mux.Handle(pattern, Chain(handler, middleware...))
+}
+
+// LogRequests logs every incoming request
+func (app *Application) LogRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		app.LogRequest(r)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireBearerToken rejects requests that don't carry the given bearer token
+// and records the others as made by AdminTokenPrincipal
+func RequireBearerToken(token string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			provided, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !found || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="go-reverse-proxy"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(ContextWithAdminPrincipal(r.Context(), AdminTokenPrincipal)))
+		})
+	}
+}
+
+// CORSConfig controls the CORS middleware
+type CORSConfig struct {
+	AllowedOrigins   []string // "*" allows any origin
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	AllowCredentials bool
+	MaxAge           int // seconds
+}
+
+// CORS answers preflight requests and adds CORS headers for allowed origins
+func CORS(cfg CORSConfig) Middleware {
+	if len(cfg.AllowedMethods) == 0 {
+		cfg.AllowedMethods = []string{http.MethodGet, http.MethodPost}
+	}
+
+	allowed := make(map[string]bool, len(cfg.AllowedOrigins))
+	for _, origin := range cfg.AllowedOrigins {
+		allowed[origin] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" || (!allowed["*"] && !allowed[origin]) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			h := w.Header()
+			h.Add("Vary", "Origin")
+			if allowed["*"] && !cfg.AllowCredentials {
+				h.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				h.Set("Access-Control-Allow-Origin", origin)
+			}
+			if cfg.AllowCredentials {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			// Preflight
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				h.Set("Access-Control-Allow-Methods", strings.Join(cfg.AllowedMethods, ", "))
+				if len(cfg.AllowedHeaders) > 0 {
+					h.Set("Access-Control-Allow-Headers", strings.Join(cfg.AllowedHeaders, ", "))
+				} else if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+					h.Set("Access-Control-Allow-Headers", requested)
+				}
+				if cfg.MaxAge > 0 {
+					h.Set("Access-Control-Max-Age", strconv.Itoa(cfg.MaxAge))
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
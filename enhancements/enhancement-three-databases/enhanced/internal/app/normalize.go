@@ -0,0 +1,106 @@
+package app
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// NormalizeConfig enables optional normalization steps; duplicate slashes,
+// dot segments and encoded traversal are always handled
+type NormalizeConfig struct {
+	// LowercasePaths lowercases request paths before routing
+	LowercasePaths bool
+	// SortQuery sorts query parameters so equivalent queries share cache keys
+	SortQuery bool
+}
+
+// SetNormalization configures the optional normalization steps
+func (app *Application) SetNormalization(cfg NormalizeConfig) {
+	app.normalize = cfg
+}
+
+// encodedTraversal lists escape sequences that could smuggle path separators
+// or dot segments past routing
+var encodedTraversal = []string{"%2e", "%2f", "%5c", "%00"}
+
+// hasEncodedTraversal reports whether an escaped path contains encoded dots,
+// slashes, backslashes or NUL bytes
+func hasEncodedTraversal(escapedPath string) bool {
+	lower := strings.ToLower(escapedPath)
+	for _, seq := range encodedTraversal {
+		if strings.Contains(lower, seq) {
+			return true
+		}
+	}
+	return strings.Contains(escapedPath, "\\")
+}
+
+// cleanPath collapses duplicate slashes and resolves . and .. segments,
+// keeping a trailing slash
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	if p[0] != '/' {
+		p = "/" + p
+	}
+
+	cleaned := path.Clean(p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+// Normalize canonicalizes request paths before routing so requests such as
+// /api/../admin or //admin cannot bypass route matching, and rejects paths
+// carrying encoded traversal sequences
+func (app *Application) Normalize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hasEncodedTraversal(r.URL.EscapedPath()) {
+			app.Logger.WarnContext(r.Context(), "rejected path with encoded traversal", "path", r.URL.EscapedPath())
+			app.writeError(w, r, CodeBadRequest, "the request path contains encoded traversal sequences")
+			return
+		}
+
+		cleaned := cleanPath(r.URL.Path)
+		if app.normalize.LowercasePaths {
+			cleaned = strings.ToLower(cleaned)
+		}
+
+		query := r.URL.RawQuery
+		if app.normalize.SortQuery && query != "" {
+			query = r.URL.Query().Encode()
+		}
+
+		if cleaned == r.URL.Path && query == r.URL.RawQuery {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Copy the request so outer middleware still sees the original URL
+		normalized := new(http.Request)
+		*normalized = *r
+		u := *r.URL
+		u.Path = cleaned
+		u.RawPath = ""
+		u.RawQuery = query
+		normalized.URL = &u
+
+		next.ServeHTTP(w, normalized)
+	})
+}
+
+// cacheKey is the response cache key for a request: its path plus query,
+// and its experiment variant so variants never share cached responses
+func cacheKey(r *http.Request) string {
+	key := r.URL.Path
+	if r.URL.RawQuery != "" {
+		key += "?" + r.URL.RawQuery
+	}
+	if assignment := experimentFromContext(r.Context()); assignment != nil {
+		key += "#experiment=" + assignment.tag()
+	}
+	return key
+}
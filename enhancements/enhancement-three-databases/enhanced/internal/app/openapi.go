@@ -0,0 +1,413 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codytheroux96/go-reverse-proxy/internal/registry"
+)
+
+// openAPIMethods are the operation keys of an OpenAPI path item
+var openAPIMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// OpenAPIImportConfig describes a spec to import. The spec is a file path or
+// an inline JSON document; Upstream overrides the spec's first server URL and
+// Mount is the route prefix the API is served under, default "/<name>".
+type OpenAPIImportConfig struct {
+	Name     string          `json:"name"`
+	Spec     string          `json:"spec,omitempty"`
+	Document json.RawMessage `json:"document,omitempty"`
+	Upstream string          `json:"upstream,omitempty"`
+	Mount    string          `json:"mount,omitempty"`
+}
+
+// OpenAPIOperation is a path and method taken from a spec
+type OpenAPIOperation struct {
+	Method      string `json:"method"`
+	Path        string `json:"path"`
+	OperationID string `json:"operation_id,omitempty"`
+}
+
+// OpenAPIImport is a spec imported as a route: requests under Mount are
+// forwarded to Upstream if they match one of its operations
+type OpenAPIImport struct {
+	Name       string             `json:"name"`
+	Spec       string             `json:"spec,omitempty"`
+	Upstream   string             `json:"upstream"`
+	Mount      string             `json:"mount"`
+	Operations []OpenAPIOperation `json:"operations"`
+	ImportedAt time.Time          `json:"imported_at"`
+	config     OpenAPIImportConfig
+	modTime    time.Time
+	matchers   []openAPIMatcher
+}
+
+// openAPIMatcher matches request paths against a templated spec path
+type openAPIMatcher struct {
+	pattern *regexp.Regexp
+	methods map[string]bool
+}
+
+// OpenAPIRoutes keeps registry routes in sync with imported OpenAPI specs
+type OpenAPIRoutes struct {
+	mu       sync.RWMutex
+	imports  map[string]*OpenAPIImport // name -> import
+	registry RegistryInterface
+	logger   *slog.Logger
+	// events receives import changes; nil discards them
+	events *EventBus
+}
+
+// NewOpenAPIRoutes creates an empty set of imported specs
+func NewOpenAPIRoutes(reg RegistryInterface, logger *slog.Logger) *OpenAPIRoutes {
+	return &OpenAPIRoutes{
+		imports:  make(map[string]*OpenAPIImport),
+		registry: reg,
+		logger:   logger,
+	}
+}
+
+// Import parses a spec and registers or updates its route, replacing any
+// previous import of the same name
+func (oa *OpenAPIRoutes) Import(cfg OpenAPIImportConfig) (*OpenAPIImport, error) {
+	if cfg.Name == "" {
+		return nil, fmt.Errorf("openapi import is missing a name")
+	}
+
+	imp, err := buildOpenAPIImport(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("openapi import %s: %w", cfg.Name, err)
+	}
+
+	oa.mu.Lock()
+	defer oa.mu.Unlock()
+
+	previous := oa.imports[cfg.Name]
+	if previous == nil {
+		if _, err := oa.registry.GetServer(cfg.Name); err == nil {
+			return nil, fmt.Errorf("openapi import %s: a server with that name is already registered", cfg.Name)
+		}
+	}
+
+	// The registry has no update, so a changed upstream or mount re-registers
+	if previous == nil || previous.Upstream != imp.Upstream || previous.Mount != imp.Mount {
+		if previous != nil {
+			if err := oa.registry.Deregister(cfg.Name); err != nil {
+				return nil, err
+			}
+		}
+		err := oa.registry.Register(registry.Server{
+			Name:         cfg.Name,
+			BaseURL:      imp.Upstream,
+			Prefixes:     []string{imp.Mount},
+			RegisteredAt: imp.ImportedAt,
+		})
+		if err != nil {
+			delete(oa.imports, cfg.Name)
+			return nil, err
+		}
+	}
+
+	oa.imports[cfg.Name] = imp
+	oa.logger.Info("openapi spec imported",
+		"name", imp.Name,
+		"upstream", imp.Upstream,
+		"mount", imp.Mount,
+		"operations", len(imp.Operations))
+	oa.events.Publish(Event{Type: EventConfigReloaded, Data: map[string]interface{}{"config": "openapi", "name": imp.Name}})
+	return imp, nil
+}
+
+// Remove deregisters an imported spec's route
+func (oa *OpenAPIRoutes) Remove(name string) error {
+	oa.mu.Lock()
+	defer oa.mu.Unlock()
+
+	if _, exists := oa.imports[name]; !exists {
+		return fmt.Errorf("openapi import '%s' does not exist", name)
+	}
+
+	delete(oa.imports, name)
+	if err := oa.registry.Deregister(name); err != nil {
+		return err
+	}
+
+	oa.logger.Info("openapi import removed", "name", name)
+	oa.events.Publish(Event{Type: EventConfigReloaded, Data: map[string]interface{}{"config": "openapi", "name": name, "removed": true}})
+	return nil
+}
+
+// List returns every import ordered by name
+func (oa *OpenAPIRoutes) List() []OpenAPIImport {
+	oa.mu.RLock()
+	defer oa.mu.RUnlock()
+
+	result := make([]OpenAPIImport, 0, len(oa.imports))
+	for _, imp := range oa.imports {
+		result = append(result, *imp)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// Watch re-imports file-based specs whenever their file changes, until ctx
+// is done
+func (oa *OpenAPIRoutes) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, cfg := range oa.changed() {
+				if _, err := oa.Import(cfg); err != nil {
+					oa.logger.Error("failed to re-import openapi spec, keeping previous routes", "name", cfg.Name, "error", err)
+				}
+			}
+		}
+	}
+}
+
+// changed returns the configs of file-based imports whose spec was modified
+func (oa *OpenAPIRoutes) changed() []OpenAPIImportConfig {
+	oa.mu.RLock()
+	defer oa.mu.RUnlock()
+
+	var stale []OpenAPIImportConfig
+	for _, imp := range oa.imports {
+		if imp.Spec == "" {
+			continue
+		}
+		info, err := os.Stat(imp.Spec)
+		if err != nil || !info.ModTime().Equal(imp.modTime) {
+			stale = append(stale, imp.config)
+		}
+	}
+	return stale
+}
+
+// allows reports whether a request may be routed to an imported spec's
+// route. Routes that were not imported are always allowed; for imported ones
+// the status to answer with is returned when the path or method is not part
+// of the contract.
+func (oa *OpenAPIRoutes) allows(serverName, prefix, path, method string) (int, bool) {
+	oa.mu.RLock()
+	imp, exists := oa.imports[serverName]
+	oa.mu.RUnlock()
+
+	if !exists || imp.Mount != prefix {
+		return 0, true
+	}
+
+	rest := strings.TrimPrefix(path, strings.TrimSuffix(prefix, "/"))
+	if rest == "" {
+		rest = "/"
+	}
+
+	pathMatched := false
+	for _, m := range imp.matchers {
+		if !m.pattern.MatchString(rest) {
+			continue
+		}
+		if m.methods[method] {
+			return 0, true
+		}
+		pathMatched = true
+	}
+
+	if pathMatched {
+		return http.StatusMethodNotAllowed, false
+	}
+	return http.StatusNotFound, false
+}
+
+// buildOpenAPIImport reads a spec and extracts its upstream and operations
+func buildOpenAPIImport(cfg OpenAPIImportConfig) (*OpenAPIImport, error) {
+	imp := &OpenAPIImport{
+		Name:       cfg.Name,
+		Spec:       cfg.Spec,
+		Mount:      cfg.Mount,
+		ImportedAt: time.Now(),
+		config:     cfg,
+	}
+
+	var data []byte
+	switch {
+	case cfg.Spec != "" && len(cfg.Document) == 0:
+		info, err := os.Stat(cfg.Spec)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = os.ReadFile(cfg.Spec); err != nil {
+			return nil, err
+		}
+		imp.modTime = info.ModTime()
+	case len(cfg.Document) > 0 && cfg.Spec == "":
+		data = cfg.Document
+	default:
+		return nil, fmt.Errorf("exactly one of spec or document is required")
+	}
+
+	doc, err := decodeJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse spec: %w", err)
+	}
+	root, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("spec must be a JSON object")
+	}
+	if _, ok := root["openapi"].(string); !ok {
+		return nil, fmt.Errorf("spec is not an OpenAPI 3 document")
+	}
+
+	if imp.Mount == "" {
+		imp.Mount = "/" + cfg.Name
+	}
+	if !strings.HasPrefix(imp.Mount, "/") {
+		return nil, fmt.Errorf("mount %q must start with /", imp.Mount)
+	}
+
+	imp.Upstream = cfg.Upstream
+	if imp.Upstream == "" {
+		imp.Upstream = openAPIServerURL(root)
+	}
+	u, err := url.Parse(imp.Upstream)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("no absolute http(s) upstream in spec servers, pass one explicitly")
+	}
+	imp.Upstream = strings.TrimSuffix(imp.Upstream, "/")
+
+	paths, _ := root["paths"].(map[string]interface{})
+	specPaths := make([]string, 0, len(paths))
+	for p := range paths {
+		specPaths = append(specPaths, p)
+	}
+	sort.Strings(specPaths)
+
+	for _, p := range specPaths {
+		item, _ := paths[p].(map[string]interface{})
+		m := openAPIMatcher{pattern: openAPIPathPattern(p), methods: make(map[string]bool)}
+
+		for _, method := range openAPIMethods {
+			op, exists := item[method].(map[string]interface{})
+			if !exists {
+				continue
+			}
+			id, _ := op["operationId"].(string)
+			upper := strings.ToUpper(method)
+			m.methods[upper] = true
+			imp.Operations = append(imp.Operations, OpenAPIOperation{Method: upper, Path: p, OperationID: id})
+		}
+
+		if len(m.methods) > 0 {
+			imp.matchers = append(imp.matchers, m)
+		}
+	}
+
+	if len(imp.Operations) == 0 {
+		return nil, fmt.Errorf("spec defines no operations")
+	}
+	return imp, nil
+}
+
+// openAPIServerURL returns the first server URL with its variables replaced
+// by their defaults
+func openAPIServerURL(root map[string]interface{}) string {
+	servers, _ := root["servers"].([]interface{})
+	if len(servers) == 0 {
+		return ""
+	}
+
+	server, _ := servers[0].(map[string]interface{})
+	serverURL, _ := server["url"].(string)
+
+	vars, _ := server["variables"].(map[string]interface{})
+	for name, v := range vars {
+		variable, _ := v.(map[string]interface{})
+		def, _ := variable["default"].(string)
+		serverURL = strings.ReplaceAll(serverURL, "{"+name+"}", def)
+	}
+	return serverURL
+}
+
+// openAPIPathPattern turns a templated path such as /orders/{id} into a
+// regular expression matching one segment per parameter
+func openAPIPathPattern(p string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	for {
+		start := strings.Index(p, "{")
+		end := strings.Index(p, "}")
+		if start < 0 || end < start {
+			break
+		}
+		b.WriteString(regexp.QuoteMeta(p[:start]))
+		b.WriteString("[^/]+")
+		p = p[end+1:]
+	}
+	b.WriteString(regexp.QuoteMeta(p))
+	b.WriteString("/?$")
+	return regexp.MustCompile(b.String())
+}
+
+// ImportOpenAPI imports a spec at startup; see OpenAPIRoutes.Import
+func (app *Application) ImportOpenAPI(cfg OpenAPIImportConfig) error {
+	_, err := app.OpenAPI.Import(cfg)
+	return err
+}
+
+// HandleOpenAPIImports serves /admin/openapi:
+//
+//	GET                                                              list imported specs
+//	POST   {"name": "billing", "spec": "billing.json", "upstream": "http://localhost:9000"}
+//	                                                                 import or re-import a spec
+//	DELETE ?name=billing                                             remove an imported spec
+//
+// POST also accepts the spec inline as "document" instead of "spec".
+func (app *Application) HandleOpenAPIImports(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]interface{}{"imports": app.OpenAPI.List()})
+
+	case http.MethodPost:
+		var cfg OpenAPIImportConfig
+		if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil || cfg.Name == "" {
+			http.Error(w, "missing a required field in payload", http.StatusBadRequest)
+			return
+		}
+
+		imp, err := app.OpenAPI.Import(cfg)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusCreated, imp)
+
+	case http.MethodDelete:
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			http.Error(w, "name parameter required", http.StatusBadRequest)
+			return
+		}
+		if err := app.OpenAPI.Remove(name); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "removed", "name": name})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
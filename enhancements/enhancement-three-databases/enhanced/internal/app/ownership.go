@@ -0,0 +1,447 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codytheroux96/go-reverse-proxy/internal/db"
+)
+
+// OwnerTokenHeader carries the team token on registry requests when prefix
+// ownership is enabled
+const OwnerTokenHeader = "X-Registry-Token"
+
+// TakeoverHeader asks to take over prefixes owned by another team under the
+// takeover conflict policy
+const TakeoverHeader = "X-Prefix-Takeover"
+
+// Conflict policies for registrations under another team's prefix
+const (
+	// ConflictReject rejects them; only an admin can reassign a prefix
+	ConflictReject = "reject"
+	// ConflictTakeover rejects them unless the request sets TakeoverHeader,
+	// which moves the prefixes to the requesting team
+	ConflictTakeover = "takeover"
+)
+
+// PrefixClaim records the team that owns a route prefix
+type PrefixClaim struct {
+	Prefix    string    `json:"prefix"`
+	Owner     string    `json:"owner"`
+	ClaimedAt time.Time `json:"claimed_at"`
+}
+
+// OwnershipStore persists prefix claims
+type OwnershipStore interface {
+	Claims(ctx context.Context) ([]PrefixClaim, error)
+	Claim(ctx context.Context, prefix, owner string) error
+	Release(ctx context.Context, prefix string) error
+}
+
+// OwnershipConfig maps team tokens to team names and sets the conflict policy
+type OwnershipConfig struct {
+	// Teams maps each team name to the token it registers with
+	Teams map[string]string
+	// Policy is ConflictReject or ConflictTakeover; empty means ConflictReject
+	Policy string
+}
+
+// PrefixOwnership lets teams claim route prefixes. The first team to register
+// a prefix owns it, and registrations or deregistrations by other teams that
+// touch the prefix, or a prefix nested in or around it, are refused.
+type PrefixOwnership struct {
+	teams  map[string]string // token -> team
+	policy string
+	store  OwnershipStore
+	// mu serializes ownership checks with the registrations they guard
+	mu sync.Mutex
+}
+
+// NewPrefixOwnership validates cfg and creates prefix ownership backed by store
+func NewPrefixOwnership(cfg OwnershipConfig, store OwnershipStore) (*PrefixOwnership, error) {
+	if len(cfg.Teams) == 0 {
+		return nil, fmt.Errorf("at least one team is required")
+	}
+	switch cfg.Policy {
+	case "":
+		cfg.Policy = ConflictReject
+	case ConflictReject, ConflictTakeover:
+	default:
+		return nil, fmt.Errorf("unknown conflict policy %q", cfg.Policy)
+	}
+
+	po := &PrefixOwnership{teams: make(map[string]string, len(cfg.Teams)), policy: cfg.Policy, store: store}
+	for team, token := range cfg.Teams {
+		if team == "" || token == "" {
+			return nil, fmt.Errorf("teams need a name and a token")
+		}
+		if other, exists := po.teams[token]; exists {
+			return nil, fmt.Errorf("teams %s and %s share a token", other, team)
+		}
+		po.teams[token] = team
+	}
+	return po, nil
+}
+
+// team returns the team whose token a request carries
+func (po *PrefixOwnership) team(r *http.Request) (string, bool) {
+	team, ok := po.teams[r.Header.Get(OwnerTokenHeader)]
+	return team, ok
+}
+
+// known reports whether team is configured
+func (po *PrefixOwnership) known(team string) bool {
+	for _, name := range po.teams {
+		if name == team {
+			return true
+		}
+	}
+	return false
+}
+
+// prefixesOverlap reports whether a request matching one prefix can match the
+// other
+func prefixesOverlap(a, b string) bool {
+	return strings.HasPrefix(a, b) || strings.HasPrefix(b, a)
+}
+
+// conflicts returns the claims of other teams that overlap prefixes
+func conflicts(claims []PrefixClaim, team string, prefixes []string) []PrefixClaim {
+	var found []PrefixClaim
+	for _, claim := range claims {
+		if claim.Owner == team {
+			continue
+		}
+		for _, prefix := range prefixes {
+			if prefixesOverlap(prefix, claim.Prefix) {
+				found = append(found, claim)
+				break
+			}
+		}
+	}
+	return found
+}
+
+// Owned guards a registry handler with prefix ownership. Registrations name
+// their prefixes in the body; deregistrations name a server, whose registered
+// prefixes are checked. Prefixes are claimed for the requesting team once the
+// handler succeeds.
+func (app *Application) Owned(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		po := app.Ownership
+		if po == nil || r.Method == http.MethodGet {
+			next(w, r)
+			return
+		}
+
+		fail := func(code ErrorCode, message string) {
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				app.writeAPIError(w, r, code, message)
+				return
+			}
+			http.Error(w, message, code.Status)
+		}
+
+		team, ok := po.team(r)
+		if !ok {
+			fail(CodeUnauthorized, "a valid "+OwnerTokenHeader+" header is required")
+			return
+		}
+
+		// The whole body is read, since a prefix past any limit must be
+		// checked as well
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			fail(CodeBadRequest, "invalid request body")
+			return
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		prefixes := requestedPrefixes(body)
+		registering := len(prefixes) > 0
+		if !registering {
+			if server, err := app.Registry.GetServer(auditTarget(r, body)); err == nil && server != nil {
+				prefixes = server.Prefixes
+			}
+		}
+
+		po.mu.Lock()
+		defer po.mu.Unlock()
+
+		claims, err := po.store.Claims(r.Context())
+		if err != nil {
+			app.Logger.ErrorContext(r.Context(), "failed to load prefix claims", "error", err)
+			fail(CodeInternal, "failed to check prefix ownership")
+			return
+		}
+
+		taken := conflicts(claims, team, prefixes)
+		takeover := po.policy == ConflictTakeover && r.Header.Get(TakeoverHeader) == "true"
+		if len(taken) > 0 && !takeover {
+			owned := make([]string, len(taken))
+			for i, claim := range taken {
+				owned[i] = claim.Prefix + " (" + claim.Owner + ")"
+			}
+			app.Logger.WarnContext(r.Context(), "registry change refused by prefix ownership",
+				"team", team, "prefixes", prefixes, "owned", owned)
+			fail(CodeConflict, "prefixes owned by other teams: "+strings.Join(owned, ", "))
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w}
+		next(rec, r)
+		rec.finish()
+		if rec.status < 200 || rec.status > 299 {
+			return
+		}
+
+		for _, claim := range taken {
+			if err := po.store.Claim(r.Context(), claim.Prefix, team); err != nil {
+				app.Logger.ErrorContext(r.Context(), "failed to take over prefix", "prefix", claim.Prefix, "error", err)
+				continue
+			}
+			app.Logger.WarnContext(r.Context(), "prefix taken over", "prefix", claim.Prefix, "from", claim.Owner, "to", team)
+			app.recordOwnershipChange(r, claim.Prefix, claim.Owner, team)
+		}
+		if !registering {
+			return
+		}
+		for _, prefix := range prefixes {
+			if owner := ownerOf(claims, prefix); owner == team || owner != "" && takeover {
+				continue
+			}
+			if err := po.store.Claim(r.Context(), prefix, team); err != nil {
+				app.Logger.ErrorContext(r.Context(), "failed to claim prefix", "prefix", prefix, "team", team, "error", err)
+			}
+		}
+	}
+}
+
+// ownerOf returns the team that claimed exactly prefix, or ""
+func ownerOf(claims []PrefixClaim, prefix string) string {
+	for _, claim := range claims {
+		if claim.Prefix == prefix {
+			return claim.Owner
+		}
+	}
+	return ""
+}
+
+// recordOwnershipChange audits a prefix moving between teams
+func (app *Application) recordOwnershipChange(r *http.Request, prefix, from, to string) {
+	if app.Audit == nil {
+		return
+	}
+	payload, _ := json.Marshal(map[string]string{"prefix": prefix, "from": from, "to": to})
+	actor, claimed := auditActor(r)
+	app.Audit.Record(AuditActionOwnershipChange, actor, claimed, prefix, payload)
+}
+
+// OwnedPrefix is a claim with the servers currently registered under it
+type OwnedPrefix struct {
+	PrefixClaim
+	Servers []string `json:"servers"`
+}
+
+// requestedPrefixes returns the routes a registration body asks for, from a
+// single server or a batch of them
+func requestedPrefixes(body []byte) []string {
+	var servers []struct {
+		Routes []string `json:"routes"`
+	}
+	if err := json.Unmarshal(body, &servers); err != nil {
+		var server struct {
+			Routes []string `json:"routes"`
+		}
+		json.Unmarshal(body, &server)
+		return server.Routes
+	}
+
+	var prefixes []string
+	for _, server := range servers {
+		for _, route := range server.Routes {
+			if !slices.Contains(prefixes, route) {
+				prefixes = append(prefixes, route)
+			}
+		}
+	}
+	return prefixes
+}
+
+// HandlePrefixOwners serves GET /admin/prefixes, POST /admin/prefixes with
+// {"prefix": "/api", "team": "payments"} to assign or reassign a prefix, and
+// DELETE /admin/prefixes?prefix=/api to release one
+func (app *Application) HandlePrefixOwners(w http.ResponseWriter, r *http.Request) {
+	po := app.Ownership
+	if po == nil {
+		if r.Method != http.MethodGet {
+			http.Error(w, "prefix ownership is disabled", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": false})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		claims, err := po.store.Claims(r.Context())
+		if err != nil {
+			http.Error(w, "failed to list prefix claims", http.StatusInternalServerError)
+			return
+		}
+		servers, err := app.Registry.GetServers()
+		if err != nil {
+			http.Error(w, "failed to list servers", http.StatusInternalServerError)
+			return
+		}
+
+		owned := make([]OwnedPrefix, len(claims))
+		for i, claim := range claims {
+			owned[i] = OwnedPrefix{PrefixClaim: claim, Servers: []string{}}
+			for _, server := range servers {
+				for _, prefix := range server.Prefixes {
+					if prefix == claim.Prefix {
+						owned[i].Servers = append(owned[i].Servers, server.Name)
+						break
+					}
+				}
+			}
+			sort.Strings(owned[i].Servers)
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": true, "policy": po.policy, "prefixes": owned})
+
+	case http.MethodPost:
+		var req struct {
+			Prefix string `json:"prefix"`
+			Team   string `json:"team"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !strings.HasPrefix(req.Prefix, "/") {
+			http.Error(w, "a prefix starting with / is required", http.StatusBadRequest)
+			return
+		}
+		if !po.known(req.Team) {
+			http.Error(w, "unknown team", http.StatusBadRequest)
+			return
+		}
+
+		po.mu.Lock()
+		defer po.mu.Unlock()
+		claims, err := po.store.Claims(r.Context())
+		if err != nil {
+			http.Error(w, "failed to list prefix claims", http.StatusInternalServerError)
+			return
+		}
+		if err := po.store.Claim(r.Context(), req.Prefix, req.Team); err != nil {
+			http.Error(w, "failed to claim prefix", http.StatusInternalServerError)
+			return
+		}
+		if previous := ownerOf(claims, req.Prefix); previous != "" && previous != req.Team {
+			app.Logger.Info("prefix reassigned", "prefix", req.Prefix, "from", previous, "to", req.Team)
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "claimed", "prefix": req.Prefix, "team": req.Team})
+
+	case http.MethodDelete:
+		prefix := r.URL.Query().Get("prefix")
+		if prefix == "" {
+			http.Error(w, "prefix parameter required", http.StatusBadRequest)
+			return
+		}
+
+		po.mu.Lock()
+		defer po.mu.Unlock()
+		if err := po.store.Release(r.Context(), prefix); err != nil {
+			http.Error(w, "failed to release prefix", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "released", "prefix": prefix})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// MemoryOwnershipStore keeps prefix claims in memory
+type MemoryOwnershipStore struct {
+	mu     sync.RWMutex
+	claims map[string]PrefixClaim
+}
+
+// NewMemoryOwnershipStore creates an empty in-memory ownership store
+func NewMemoryOwnershipStore() *MemoryOwnershipStore {
+	return &MemoryOwnershipStore{claims: make(map[string]PrefixClaim)}
+}
+
+func (s *MemoryOwnershipStore) Claims(ctx context.Context) ([]PrefixClaim, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	claims := make([]PrefixClaim, 0, len(s.claims))
+	for _, claim := range s.claims {
+		claims = append(claims, claim)
+	}
+	sort.Slice(claims, func(i, j int) bool { return claims[i].Prefix < claims[j].Prefix })
+	return claims, nil
+}
+
+func (s *MemoryOwnershipStore) Claim(ctx context.Context, prefix, owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.claims[prefix] = PrefixClaim{Prefix: prefix, Owner: owner, ClaimedAt: time.Now().UTC()}
+	return nil
+}
+
+func (s *MemoryOwnershipStore) Release(ctx context.Context, prefix string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.claims, prefix)
+	return nil
+}
+
+// PostgresOwnershipStore keeps prefix claims in the prefix_owners table, so
+// every proxy instance sharing the registry enforces the same ownership
+type PostgresOwnershipStore struct {
+	queries *db.Queries
+}
+
+// NewPostgresOwnershipStore creates a store backed by the given database
+func NewPostgresOwnershipStore(database *sql.DB) *PostgresOwnershipStore {
+	return &PostgresOwnershipStore{queries: db.New(database)}
+}
+
+func (s *PostgresOwnershipStore) Claims(ctx context.Context) ([]PrefixClaim, error) {
+	rows, err := s.queries.ListPrefixOwners(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list prefix owners: %w", err)
+	}
+
+	claims := make([]PrefixClaim, len(rows))
+	for i, row := range rows {
+		claims[i] = PrefixClaim{Prefix: row.Prefix, Owner: row.Owner, ClaimedAt: row.ClaimedAt}
+	}
+	return claims, nil
+}
+
+func (s *PostgresOwnershipStore) Claim(ctx context.Context, prefix, owner string) error {
+	if err := s.queries.ClaimPrefix(ctx, db.ClaimPrefixParams{Prefix: prefix, Owner: owner}); err != nil {
+		return fmt.Errorf("failed to claim prefix: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresOwnershipStore) Release(ctx context.Context, prefix string) error {
+	if err := s.queries.ReleasePrefix(ctx, prefix); err != nil {
+		return fmt.Errorf("failed to release prefix: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,323 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// PenaltyConfig escalates the response to clients that keep exceeding the
+// rate limit: plain 429s first, then 429s delayed by a tarpit, then
+// temporary bans. Delays and bans double with each escalation.
+type PenaltyConfig struct {
+	// TarpitAfter is the number of violations before responses are delayed; 0 disables tarpitting
+	TarpitAfter int
+	// TarpitDelay is the first tarpit delay
+	TarpitDelay time.Duration
+	// MaxTarpitDelay caps the tarpit delay
+	MaxTarpitDelay time.Duration
+	// BanAfter is the number of violations before the client is banned; 0 disables bans
+	BanAfter int
+	// BanDuration is the length of the first ban
+	BanDuration time.Duration
+	// MaxBanDuration caps the ban length
+	MaxBanDuration time.Duration
+	// Window is how long violations count toward escalation
+	Window time.Duration
+	// ForgetAfter is how long a client must behave before its ban history is dropped
+	ForgetAfter time.Duration
+}
+
+// Penalty defaults applied to unset config fields
+const (
+	DefaultTarpitDelay    = 500 * time.Millisecond
+	DefaultMaxTarpitDelay = 10 * time.Second
+	DefaultBanDuration    = time.Minute
+	DefaultMaxBanDuration = time.Hour
+	DefaultPenaltyWindow  = time.Minute
+	DefaultForgetAfter    = 24 * time.Hour
+)
+
+// penaltyAction is what the rate limiter does with a violating request
+type penaltyAction int
+
+const (
+	penaltyReject penaltyAction = iota
+	penaltyTarpit
+	penaltyBan
+)
+
+// penaltyState tracks one client key's violations
+type penaltyState struct {
+	violations    int
+	bans          int
+	lastViolation time.Time
+	bannedUntil   time.Time
+}
+
+// PenaltyEntry reports a client key's escalation state
+type PenaltyEntry struct {
+	Key           string     `json:"key"`
+	Violations    int        `json:"violations"`
+	Bans          int        `json:"bans"`
+	LastViolation time.Time  `json:"last_violation"`
+	BannedUntil   *time.Time `json:"banned_until,omitempty"`
+}
+
+// PenaltyBox tracks rate limit violations per client key
+type PenaltyBox struct {
+	cfg     PenaltyConfig
+	mu      sync.Mutex
+	clients map[string]*penaltyState
+}
+
+// NewPenaltyBox creates a penalty box from cfg, filling in defaults
+func NewPenaltyBox(cfg PenaltyConfig) *PenaltyBox {
+	if cfg.TarpitDelay <= 0 {
+		cfg.TarpitDelay = DefaultTarpitDelay
+	}
+	if cfg.MaxTarpitDelay <= 0 {
+		cfg.MaxTarpitDelay = DefaultMaxTarpitDelay
+	}
+	if cfg.BanDuration <= 0 {
+		cfg.BanDuration = DefaultBanDuration
+	}
+	if cfg.MaxBanDuration <= 0 {
+		cfg.MaxBanDuration = DefaultMaxBanDuration
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = DefaultPenaltyWindow
+	}
+	if cfg.ForgetAfter <= 0 {
+		cfg.ForgetAfter = DefaultForgetAfter
+	}
+
+	return &PenaltyBox{cfg: cfg, clients: make(map[string]*penaltyState)}
+}
+
+// Banned returns how long a client key remains banned, or 0 when it is not
+func (pb *PenaltyBox) Banned(key string, now time.Time) time.Duration {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+
+	state, exists := pb.clients[key]
+	if !exists || !now.Before(state.bannedUntil) {
+		return 0
+	}
+	return state.bannedUntil.Sub(now)
+}
+
+// Violation records a rate limit violation and returns the escalation for it
+// along with the tarpit delay or ban length
+func (pb *PenaltyBox) Violation(key string, now time.Time) (penaltyAction, time.Duration) {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+
+	state, exists := pb.clients[key]
+	if !exists {
+		state = &penaltyState{}
+		pb.clients[key] = state
+	}
+	if now.Sub(state.lastViolation) > pb.cfg.Window {
+		state.violations = 0
+	}
+	state.violations++
+	state.lastViolation = now
+
+	if pb.cfg.BanAfter > 0 && state.violations >= pb.cfg.BanAfter {
+		ban := escalate(pb.cfg.BanDuration, state.bans, pb.cfg.MaxBanDuration)
+		state.bans++
+		state.violations = 0
+		state.bannedUntil = now.Add(ban)
+		return penaltyBan, ban
+	}
+
+	if pb.cfg.TarpitAfter > 0 && state.violations >= pb.cfg.TarpitAfter {
+		delay := escalate(pb.cfg.TarpitDelay, state.violations-pb.cfg.TarpitAfter, pb.cfg.MaxTarpitDelay)
+		return penaltyTarpit, delay
+	}
+
+	return penaltyReject, 0
+}
+
+// escalate doubles base n times, capped at max
+func escalate(base time.Duration, n int, max time.Duration) time.Duration {
+	d := base
+	for i := 0; i < n && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		return max
+	}
+	return d
+}
+
+// Clear forgets a client key's violations and lifts its ban. It reports
+// whether the key was tracked.
+func (pb *PenaltyBox) Clear(key string) bool {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+
+	_, exists := pb.clients[key]
+	delete(pb.clients, key)
+	return exists
+}
+
+// ClearAll forgets every client key and returns how many were tracked
+func (pb *PenaltyBox) ClearAll() int {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+
+	n := len(pb.clients)
+	pb.clients = make(map[string]*penaltyState)
+	return n
+}
+
+// List returns the tracked client keys, banned ones first
+func (pb *PenaltyBox) List(now time.Time) []PenaltyEntry {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+
+	entries := make([]PenaltyEntry, 0, len(pb.clients))
+	for key, state := range pb.clients {
+		entry := PenaltyEntry{
+			Key:           key,
+			Violations:    state.violations,
+			Bans:          state.bans,
+			LastViolation: state.lastViolation,
+		}
+		if now.Before(state.bannedUntil) {
+			until := state.bannedUntil
+			entry.BannedUntil = &until
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		bi, bj := entries[i].BannedUntil != nil, entries[j].BannedUntil != nil
+		if bi != bj {
+			return bi
+		}
+		return entries[i].Key < entries[j].Key
+	})
+	return entries
+}
+
+// Cleanup periodically drops client keys that have not violated the limit
+// for ForgetAfter and are not banned
+func (pb *PenaltyBox) Cleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			pb.mu.Lock()
+			for key, state := range pb.clients {
+				if now.Sub(state.lastViolation) > pb.cfg.ForgetAfter && !now.Before(state.bannedUntil) {
+					delete(pb.clients, key)
+				}
+			}
+			pb.mu.Unlock()
+		}
+	}
+}
+
+// retryAfterSeconds formats d as a Retry-After value, rounding up
+func retryAfterSeconds(d time.Duration) string {
+	return strconv.Itoa(int((d + time.Second - 1) / time.Second))
+}
+
+// rejectBanned answers a request from a banned client key with 403 and
+// reports whether it did
+func (app *Application) rejectBanned(w http.ResponseWriter, r *http.Request, key string) bool {
+	if app.Penalties == nil {
+		return false
+	}
+
+	remaining := app.Penalties.Banned(key, time.Now())
+	if remaining <= 0 {
+		return false
+	}
+
+	w.Header().Set("Retry-After", retryAfterSeconds(remaining))
+	app.writeError(w, r, CodeClientBanned, "client temporarily banned for exceeding the rate limit")
+	return true
+}
+
+// rejectRateLimited answers a request over the rate limit, escalating to a
+// tarpit or a ban for clients that keep exceeding it
+func (app *Application) rejectRateLimited(w http.ResponseWriter, r *http.Request, key, algorithm string) {
+	if app.Penalties == nil {
+		app.Logger.InfoContext(r.Context(), "rate limit exceeded", "client_ip", key, "algorithm", algorithm)
+		app.writeError(w, r, CodeRateLimited, "rate limit exceeded")
+		return
+	}
+
+	action, d := app.Penalties.Violation(key, time.Now())
+	switch action {
+	case penaltyBan:
+		app.Logger.WarnContext(r.Context(), "client banned for exceeding the rate limit", "client_ip", key, "duration", d)
+		w.Header().Set("Retry-After", retryAfterSeconds(d))
+		app.writeError(w, r, CodeClientBanned, "client temporarily banned for exceeding the rate limit")
+		return
+
+	case penaltyTarpit:
+		app.Logger.InfoContext(r.Context(), "rate limit exceeded, tarpitting", "client_ip", key, "algorithm", algorithm, "delay", d)
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-r.Context().Done():
+			w.WriteHeader(StatusClientClosedRequest)
+			return
+		}
+
+	default:
+		app.Logger.InfoContext(r.Context(), "rate limit exceeded", "client_ip", key, "algorithm", algorithm)
+	}
+
+	app.writeError(w, r, CodeRateLimited, "rate limit exceeded")
+}
+
+// HandlePenalties serves GET and DELETE /admin/ratelimit/penalties. GET lists
+// tracked client keys; DELETE clears one with ?key= or all of them.
+func (app *Application) HandlePenalties(w http.ResponseWriter, r *http.Request) {
+	if app.Penalties == nil {
+		if r.Method != http.MethodGet {
+			http.Error(w, "rate limit penalties are not enabled", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": false})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": true, "clients": app.Penalties.List(time.Now())})
+
+	case http.MethodDelete:
+		key := r.URL.Query().Get("key")
+		if key == "" {
+			cleared := app.Penalties.ClearAll()
+			app.Logger.InfoContext(r.Context(), "rate limit penalties cleared", "clients", cleared)
+			writeJSON(w, http.StatusOK, map[string]interface{}{"status": "cleared", "clients": cleared})
+			return
+		}
+
+		if !app.Penalties.Clear(key) {
+			http.Error(w, "client key not found", http.StatusNotFound)
+			return
+		}
+		app.Logger.InfoContext(r.Context(), "rate limit penalties cleared", "key", key)
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "cleared", "key": key})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,118 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"plugin"
+)
+
+// Plugin intercepts proxied requests. Plugins are registered at startup and
+// run in registration order. Embed BasePlugin to implement only the hooks
+// you need.
+type Plugin interface {
+	Name() string
+	// OnRequest runs before the request is routed and may modify its headers.
+	// Returning an error rejects the request; use a *PluginError to choose
+	// the status code.
+	OnRequest(r *http.Request) error
+	// OnResponse runs after a backend responds and before the response is
+	// written to the client, and may modify the response headers.
+	OnResponse(r *http.Request, resp *http.Response) error
+	// OnError runs when a request could not be served by any backend.
+	OnError(r *http.Request, err error)
+}
+
+// BasePlugin provides no-op hooks for embedding in plugins
+type BasePlugin struct{}
+
+func (BasePlugin) OnRequest(r *http.Request) error                       { return nil }
+func (BasePlugin) OnResponse(r *http.Request, resp *http.Response) error { return nil }
+func (BasePlugin) OnError(r *http.Request, err error)                    {}
+
+// PluginError lets a plugin reject a request with a specific status code
+type PluginError struct {
+	Status  int
+	Message string
+}
+
+func (e *PluginError) Error() string {
+	return e.Message
+}
+
+// RegisterPlugin adds a plugin to the interceptor chain. It must be called
+// before the proxy starts serving.
+func (app *Application) RegisterPlugin(p Plugin) {
+	app.plugins = append(app.plugins, p)
+	app.Logger.Info("plugin registered", "plugin", p.Name())
+}
+
+// LoadPlugin opens a Go plugin (.so built with -buildmode=plugin) that
+// exports a variable named Plugin implementing the Plugin interface
+func (app *Application) LoadPlugin(path string) error {
+	so, err := plugin.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open plugin %s: %w", path, err)
+	}
+
+	sym, err := so.Lookup("Plugin")
+	if err != nil {
+		return fmt.Errorf("plugin %s does not export Plugin: %w", path, err)
+	}
+
+	var p Plugin
+	switch v := sym.(type) {
+	case Plugin:
+		p = v
+	case *Plugin:
+		p = *v
+	default:
+		return fmt.Errorf("plugin %s: exported Plugin has type %T", path, sym)
+	}
+
+	app.RegisterPlugin(p)
+	return nil
+}
+
+// runRequestPlugins runs OnRequest hooks and writes a rejection if one fails.
+// It reports whether the request may continue.
+func (app *Application) runRequestPlugins(w http.ResponseWriter, r *http.Request) bool {
+	for _, p := range app.plugins {
+		if err := p.OnRequest(r); err != nil {
+			status := http.StatusForbidden
+			message := err.Error()
+
+			var pluginErr *PluginError
+			if errors.As(err, &pluginErr) && pluginErr.Status != 0 {
+				status = pluginErr.Status
+			}
+
+			app.Logger.InfoContext(r.Context(), "request rejected by plugin",
+				"plugin", p.Name(),
+				"path", r.URL.Path,
+				"status", status,
+				"reason", message)
+			app.writeError(w, r, codeForStatus(status), message)
+			return false
+		}
+	}
+	return true
+}
+
+// runResponsePlugins runs OnResponse hooks, stopping at the first failure
+func (app *Application) runResponsePlugins(r *http.Request, resp *http.Response) error {
+	for _, p := range app.plugins {
+		if err := p.OnResponse(r, resp); err != nil {
+			app.Logger.WarnContext(r.Context(), "plugin failed response", "plugin", p.Name(), "path", r.URL.Path, "error", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// runErrorPlugins notifies every plugin of a failed request
+func (app *Application) runErrorPlugins(r *http.Request, err error) {
+	for _, p := range app.plugins {
+		p.OnError(r, err)
+	}
+}
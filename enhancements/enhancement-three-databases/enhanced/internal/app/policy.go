@@ -0,0 +1,274 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/codytheroux96/go-reverse-proxy/internal/expr"
+)
+
+// PolicyConfig is the on-disk policy file. Every condition is an expression
+// over the `request` variable, for example
+//
+//	request.header["x-tier"] == "gold" && request.path.startsWith("/api")
+//
+// Available attributes: request.method, request.path, request.host,
+// request.proto, request.client_ip, request.header["name"] (lowercase names)
+// and request.query["param"].
+type PolicyConfig struct {
+	// RouteConditions maps a route prefix to a condition that must hold for
+	// requests to be routed to it
+	RouteConditions map[string]string `json:"route_conditions"`
+	// CacheBypass skips the response cache when it evaluates to true
+	CacheBypass string `json:"cache_bypass"`
+	// RateLimitKey selects the rate limiting key; an empty result falls back
+	// to the client IP
+	RateLimitKey string `json:"rate_limit_key"`
+	// PriorityClass selects the admission priority class; an empty or unknown
+	// result gets the lowest class
+	PriorityClass string `json:"priority_class"`
+	// HeaderRules modify request headers before forwarding
+	HeaderRules []HeaderRuleConfig `json:"header_rules"`
+	// AuthBypass lists routes the Authenticate middleware lets through; the
+	// first matching rule applies
+	AuthBypass []AuthBypassConfig `json:"auth_bypass"`
+}
+
+// HeaderRuleConfig sets and removes request headers when a condition holds
+type HeaderRuleConfig struct {
+	When   string            `json:"when"`
+	Set    map[string]string `json:"set"`
+	Remove []string          `json:"remove"`
+}
+
+// Policies holds compiled policy expressions
+type Policies struct {
+	routeConditions map[string]*expr.Program
+	cacheBypass     *expr.Program
+	rateLimitKey    *expr.Program
+	priorityClass   *expr.Program
+	headerRules     []headerRule
+	authBypass      []authBypassRule
+}
+
+type headerRule struct {
+	when   *expr.Program
+	set    map[string]string
+	remove []string
+}
+
+// CompilePolicies compiles every expression in a policy config
+func CompilePolicies(cfg PolicyConfig) (*Policies, error) {
+	p := &Policies{routeConditions: make(map[string]*expr.Program)}
+
+	for prefix, source := range cfg.RouteConditions {
+		prog, err := expr.Compile(source)
+		if err != nil {
+			return nil, fmt.Errorf("route condition for %s: %w", prefix, err)
+		}
+		p.routeConditions[prefix] = prog
+	}
+
+	if cfg.CacheBypass != "" {
+		prog, err := expr.Compile(cfg.CacheBypass)
+		if err != nil {
+			return nil, fmt.Errorf("cache bypass: %w", err)
+		}
+		p.cacheBypass = prog
+	}
+
+	if cfg.RateLimitKey != "" {
+		prog, err := expr.Compile(cfg.RateLimitKey)
+		if err != nil {
+			return nil, fmt.Errorf("rate limit key: %w", err)
+		}
+		p.rateLimitKey = prog
+	}
+
+	if cfg.PriorityClass != "" {
+		prog, err := expr.Compile(cfg.PriorityClass)
+		if err != nil {
+			return nil, fmt.Errorf("priority class: %w", err)
+		}
+		p.priorityClass = prog
+	}
+
+	for i, rule := range cfg.HeaderRules {
+		when := "true"
+		if rule.When != "" {
+			when = rule.When
+		}
+		prog, err := expr.Compile(when)
+		if err != nil {
+			return nil, fmt.Errorf("header rule %d: %w", i, err)
+		}
+		p.headerRules = append(p.headerRules, headerRule{when: prog, set: rule.Set, remove: rule.Remove})
+	}
+
+	for i, cfg := range cfg.AuthBypass {
+		rule, err := compileAuthBypass(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("auth bypass rule %d: %w", i, err)
+		}
+		p.authBypass = append(p.authBypass, rule)
+	}
+
+	return p, nil
+}
+
+// LoadPolicies reads, compiles and activates a JSON policy file
+func (app *Application) LoadPolicies(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read policy file: %w", err)
+	}
+
+	var cfg PolicyConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("failed to parse policy file: %w", err)
+	}
+
+	policies, err := CompilePolicies(cfg)
+	if err != nil {
+		return err
+	}
+
+	app.policies.Store(policies)
+	app.Logger.Info("policies loaded",
+		"path", path,
+		"route_conditions", len(policies.routeConditions),
+		"header_rules", len(policies.headerRules),
+		"auth_bypass_rules", len(policies.authBypass))
+	app.configReloaded("policies", map[string]interface{}{"path": path})
+	return nil
+}
+
+// requestVars exposes request attributes to policy expressions
+func requestVars(r *http.Request) map[string]interface{} {
+	headers := make(map[string]string, len(r.Header))
+	for key := range r.Header {
+		headers[strings.ToLower(key)] = r.Header.Get(key)
+	}
+
+	query := make(map[string]string)
+	for key, values := range r.URL.Query() {
+		if len(values) > 0 {
+			query[key] = values[0]
+		}
+	}
+
+	clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		clientIP = r.RemoteAddr
+	}
+
+	return map[string]interface{}{
+		"request": map[string]interface{}{
+			"method":    r.Method,
+			"path":      r.URL.Path,
+			"host":      r.Host,
+			"proto":     r.Proto,
+			"client_ip": clientIP,
+			"header":    headers,
+			"query":     query,
+		},
+	}
+}
+
+// routeAllowed evaluates the route condition for a prefix; evaluation errors
+// deny the route
+func (app *Application) routeAllowed(r *http.Request, prefix string) bool {
+	policies := app.policies.Load()
+	if policies == nil {
+		return true
+	}
+
+	prog, exists := policies.routeConditions[prefix]
+	if !exists {
+		return true
+	}
+
+	ok, err := prog.EvalBool(requestVars(r))
+	if err != nil {
+		app.Logger.WarnContext(r.Context(), "route condition failed to evaluate", "prefix", prefix, "error", err)
+		return false
+	}
+	return ok
+}
+
+// bypassCache reports whether the cache bypass condition holds
+func (app *Application) bypassCache(r *http.Request) bool {
+	policies := app.policies.Load()
+	if policies == nil || policies.cacheBypass == nil {
+		return false
+	}
+
+	bypass, err := policies.cacheBypass.EvalBool(requestVars(r))
+	if err != nil {
+		app.Logger.WarnContext(r.Context(), "cache bypass condition failed to evaluate", "error", err)
+		return false
+	}
+	return bypass
+}
+
+// rateLimitKey returns the policy-selected rate limiting key, or "" to use
+// the client IP
+func (app *Application) rateLimitKey(r *http.Request) string {
+	policies := app.policies.Load()
+	if policies == nil || policies.rateLimitKey == nil {
+		return ""
+	}
+
+	key, err := policies.rateLimitKey.EvalString(requestVars(r))
+	if err != nil {
+		app.Logger.WarnContext(r.Context(), "rate limit key failed to evaluate", "error", err)
+		return ""
+	}
+	return key
+}
+
+// priorityClass returns the policy-selected admission priority class, or ""
+// for the lowest class
+func (app *Application) priorityClass(r *http.Request) string {
+	policies := app.policies.Load()
+	if policies == nil || policies.priorityClass == nil {
+		return ""
+	}
+
+	class, err := policies.priorityClass.EvalString(requestVars(r))
+	if err != nil {
+		app.Logger.WarnContext(r.Context(), "priority class failed to evaluate", "error", err)
+		return ""
+	}
+	return class
+}
+
+// applyHeaderRules modifies request headers according to matching rules
+func (app *Application) applyHeaderRules(r *http.Request) {
+	policies := app.policies.Load()
+	if policies == nil || len(policies.headerRules) == 0 {
+		return
+	}
+
+	vars := requestVars(r)
+	for _, rule := range policies.headerRules {
+		match, err := rule.when.EvalBool(vars)
+		if err != nil {
+			app.Logger.WarnContext(r.Context(), "header rule failed to evaluate", "rule", rule.when.String(), "error", err)
+			continue
+		}
+		if !match {
+			continue
+		}
+		for key, value := range rule.set {
+			r.Header.Set(key, value)
+		}
+		for _, key := range rule.remove {
+			r.Header.Del(key)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// POST cache modes
+const (
+	// PostCacheBody caches every 200 response for the route, keyed by a hash
+	// of the request body
+	PostCacheBody = "body"
+	// PostCacheHeader caches a 200 response only when the backend opts in by
+	// setting the X-Cache-Key response header, keyed by that header's value
+	PostCacheHeader = "header"
+)
+
+// MaxPostCacheKeyLength bounds X-Cache-Key values; responses with longer
+// ones are not cached
+const MaxPostCacheKeyLength = 256
+
+// postCacheAliasSuffix marks the entries of header mode routes that point a
+// request body's key at the entry stored under the backend's key
+const postCacheAliasSuffix = "#alias"
+
+// PostCacheKeyHeader is set on responses for cacheable POST routes with the
+// proxy's cache key, which can be passed to /admin/cache/purge
+const PostCacheKeyHeader = "X-Proxy-Cache-Key"
+
+// SetPostCacheRoutes opts route prefixes into POST response caching; routes
+// maps each prefix to PostCacheBody or PostCacheHeader
+func (app *Application) SetPostCacheRoutes(routes map[string]string) error {
+	for prefix, mode := range routes {
+		if mode != PostCacheBody && mode != PostCacheHeader {
+			return fmt.Errorf("unknown post cache mode %q for %s", mode, prefix)
+		}
+	}
+
+	app.postCacheRoutes = routes
+	return nil
+}
+
+// ParsePostCacheRoutes parses a comma separated list of prefix=mode pairs,
+// for example "/search=body,/reports=header"
+func ParsePostCacheRoutes(spec string) (map[string]string, error) {
+	routes := make(map[string]string)
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		prefix, mode, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid post cache route %q, expected prefix=mode", part)
+		}
+		routes[prefix] = mode
+	}
+
+	return routes, nil
+}
+
+// postCacheMode returns the caching mode for a POST path, or "" when the
+// route has not opted in
+func (app *Application) postCacheMode(path string) string {
+	longest := ""
+	for prefix := range app.postCacheRoutes {
+		if strings.HasPrefix(path, prefix) && len(prefix) > len(longest) {
+			longest = prefix
+		}
+	}
+	if longest == "" {
+		return ""
+	}
+	return app.postCacheRoutes[longest]
+}
+
+// postCacheKey derives the cache key for a POST from its path, query and body
+func postCacheKey(r *http.Request, body []byte) string {
+	sum := sha256.Sum256(body)
+	return "POST " + cacheKey(r) + "#" + hex.EncodeToString(sum[:])
+}
+
+// backendCacheKey derives the cache key of a header mode response from the
+// backend's X-Cache-Key value, scoped to the request path, or returns ""
+// when the backend set no usable key
+func backendCacheKey(r *http.Request, resp *http.Response) string {
+	value := strings.TrimSpace(resp.Header.Get("X-Cache-Key"))
+	if value == "" || len(value) > MaxPostCacheKeyLength {
+		return ""
+	}
+	return "POST " + r.URL.Path + "#key=" + value
+}
+
+// postCacheable reports whether a backend response to a cacheable POST may be
+// stored under the route's mode
+func postCacheable(mode string, r *http.Request, resp *http.Response) bool {
+	if resp.StatusCode != http.StatusOK || isStreamingResponse(resp) {
+		return false
+	}
+	if mode == PostCacheHeader {
+		return backendCacheKey(r, resp) != ""
+	}
+	return true
+}
+
+// postCacheEntry returns the key a header mode request's response was last
+// stored under, following the alias of the request's body key, or "" when
+// the backend has not keyed such a request yet
+func (app *Application) postCacheEntry(bodyKey string) string {
+	if entry, found := app.Cache.Get(bodyKey + postCacheAliasSuffix); found {
+		return string(entry)
+	}
+	if d := app.Degrader; d != nil {
+		if entry, found := d.stale.Get(bodyKey + postCacheAliasSuffix); found {
+			return string(entry)
+		}
+	}
+	return ""
+}
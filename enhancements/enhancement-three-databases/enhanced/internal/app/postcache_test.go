@@ -0,0 +1,86 @@
+package app
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestPostCacheHeaderModeKeysByBackendKey(t *testing.T) {
+	app := newTestApp(t)
+	if err := app.SetPostCacheRoutes(map[string]string{"/search": PostCacheHeader}); err != nil {
+		t.Fatal(err)
+	}
+
+	var requests atomic.Int32
+	addTestBackend(t, app, "search", func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		query := strings.ToLower(strings.TrimSpace(string(body)))
+		// The backend knows that case and spacing do not change the answer
+		w.Header().Set("X-Cache-Key", "q="+query)
+		io.WriteString(w, "results for "+query)
+	}, "/search")
+	handler := app.Handler()
+
+	post := func(body string) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/search", strings.NewReader(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("POST %q: status %d", body, rec.Code)
+		}
+		app.FlushCaches()
+		return rec
+	}
+
+	first := post("Shoes")
+	if got, want := first.Header().Get(PostCacheKeyHeader), "POST /search#key=q=shoes"; got != want {
+		t.Fatalf("%s = %q, want %q", PostCacheKeyHeader, got, want)
+	}
+
+	// The same body is answered from the entry the backend keyed
+	if rec := post("Shoes"); requests.Load() != 1 || rec.Body.String() != "results for shoes" {
+		t.Fatalf("repeat went upstream (%d requests), body %q", requests.Load(), rec.Body.String())
+	}
+
+	// A body the backend maps to the same key goes upstream once, then
+	// shares the entry
+	post(" shoes ")
+	if requests.Load() != 2 {
+		t.Fatalf("got %d backend requests, want 2", requests.Load())
+	}
+
+	// Purging the backend's key invalidates every body aliased to it
+	app.Cache.Delete("POST /search#key=q=shoes")
+	post("Shoes")
+	if requests.Load() != 3 {
+		t.Fatalf("got %d backend requests after purge, want 3", requests.Load())
+	}
+}
+
+func TestPostCacheHeaderModeWithoutKeyIsNotCached(t *testing.T) {
+	app := newTestApp(t)
+	if err := app.SetPostCacheRoutes(map[string]string{"/search": PostCacheHeader}); err != nil {
+		t.Fatal(err)
+	}
+
+	var requests atomic.Int32
+	addTestBackend(t, app, "search", func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		io.WriteString(w, "uncacheable")
+	}, "/search")
+	handler := app.Handler()
+
+	for range 2 {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/search", strings.NewReader("q")))
+		app.FlushCaches()
+	}
+	if requests.Load() != 2 {
+		t.Fatalf("got %d backend requests, want 2", requests.Load())
+	}
+}
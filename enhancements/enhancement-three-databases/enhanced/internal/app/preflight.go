@@ -0,0 +1,217 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/codytheroux96/go-reverse-proxy/internal/migrate"
+)
+
+// Preflight thresholds applied to zero values
+const (
+	DefaultCertExpiryWarning = 14 * 24 * time.Hour
+	DefaultMaxClockSkew      = 30 * time.Second
+)
+
+// PreflightStatus is the outcome of one preflight check
+type PreflightStatus string
+
+const (
+	PreflightOK   PreflightStatus = "ok"
+	PreflightWarn PreflightStatus = "warn"
+	// PreflightFatal stops the proxy from starting
+	PreflightFatal PreflightStatus = "fatal"
+)
+
+// PreflightResult is one check's outcome and what it found
+type PreflightResult struct {
+	Check  string          `json:"check"`
+	Status PreflightStatus `json:"status"`
+	Detail string          `json:"detail"`
+}
+
+// Preflight collects startup checks run before the proxy listens, so
+// problems are reported together rather than after a partial start
+type Preflight struct {
+	// CertExpiryWarning warns about certificates expiring within it
+	CertExpiryWarning time.Duration
+	// MaxClockSkew is the largest difference from the database clock allowed
+	MaxClockSkew time.Duration
+
+	results []PreflightResult
+	// ports maps each checked address to its listener
+	ports map[string]string
+}
+
+// NewPreflight creates a preflight with the default thresholds
+func NewPreflight() *Preflight {
+	return &Preflight{
+		CertExpiryWarning: DefaultCertExpiryWarning,
+		MaxClockSkew:      DefaultMaxClockSkew,
+		ports:             make(map[string]string),
+	}
+}
+
+func (p *Preflight) record(check string, status PreflightStatus, format string, args ...interface{}) {
+	p.results = append(p.results, PreflightResult{Check: check, Status: status, Detail: fmt.Sprintf(format, args...)})
+}
+
+// Fail records a fatal problem found outside the built-in checks, such as
+// invalid configuration
+func (p *Preflight) Fail(check string, err error) {
+	p.record(check, PreflightFatal, "%v", err)
+}
+
+// Warn records a problem that does not stop the proxy from starting
+func (p *Preflight) Warn(check, detail string) {
+	p.record(check, PreflightWarn, "%s", detail)
+}
+
+// CheckCertificate verifies the certificate and key files are readable, form
+// a pair, and are currently valid. It returns the loaded pair, or nil when
+// it cannot be used.
+func (p *Preflight) CheckCertificate(certFile, keyFile string) *tls.Certificate {
+	for _, file := range []string{certFile, keyFile} {
+		if _, err := os.ReadFile(file); err != nil {
+			p.record("tls certificate", PreflightFatal, "cannot read %s: %v", file, err)
+			return nil
+		}
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		p.record("tls certificate", PreflightFatal, "%s and %s are not a valid pair: %v", certFile, keyFile, err)
+		return nil
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		p.record("tls certificate", PreflightFatal, "cannot parse %s: %v", certFile, err)
+		return nil
+	}
+	cert.Leaf = leaf
+
+	now := time.Now()
+	switch {
+	case now.Before(leaf.NotBefore):
+		p.record("tls certificate", PreflightFatal, "%s is not valid until %s", certFile, leaf.NotBefore.Format(time.RFC3339))
+		return nil
+	case now.After(leaf.NotAfter):
+		p.record("tls certificate", PreflightFatal, "%s expired at %s", certFile, leaf.NotAfter.Format(time.RFC3339))
+		return nil
+	case leaf.NotAfter.Sub(now) < p.CertExpiryWarning:
+		p.record("tls certificate", PreflightWarn, "%s expires in %s, at %s", certFile, leaf.NotAfter.Sub(now).Round(time.Hour), leaf.NotAfter.Format(time.RFC3339))
+	default:
+		p.record("tls certificate", PreflightOK, "%s valid until %s", certFile, leaf.NotAfter.Format(time.RFC3339))
+	}
+	return &cert
+}
+
+// CheckDatabase verifies the database is reachable, that its schema is at
+// the version of the embedded migrations, and that its clock agrees with
+// ours
+func (p *Preflight) CheckDatabase(ctx context.Context, database *sql.DB, fsys fs.FS) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if err := database.PingContext(ctx); err != nil {
+		p.record("database", PreflightFatal, "unreachable: %v", err)
+		return
+	}
+	p.record("database", PreflightOK, "reachable")
+
+	status, err := migrate.GetStatus(ctx, database, fsys)
+	switch {
+	case err != nil:
+		p.record("schema version", PreflightFatal, "%v", err)
+	case status.Pending > 0:
+		p.record("schema version", PreflightFatal, "at version %d, %d migrations pending up to %d; run with -migrate", status.Current, status.Pending, status.Latest)
+	case status.Current > status.Latest:
+		p.record("schema version", PreflightWarn, "at version %d, newer than this build's %d", status.Current, status.Latest)
+	default:
+		p.record("schema version", PreflightOK, "at version %d", status.Current)
+	}
+
+	var dbNow time.Time
+	before := time.Now()
+	if err := database.QueryRowContext(ctx, `SELECT now()`).Scan(&dbNow); err != nil {
+		p.record("clock skew", PreflightWarn, "cannot read database clock: %v", err)
+		return
+	}
+	// Compare against the middle of the round trip
+	local := before.Add(time.Since(before) / 2)
+	skew := local.Sub(dbNow)
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > p.MaxClockSkew {
+		p.record("clock skew", PreflightFatal, "%s from the database clock, more than %s", skew.Round(time.Millisecond), p.MaxClockSkew)
+		return
+	}
+	p.record("clock skew", PreflightOK, "%s from the database clock", skew.Round(time.Millisecond))
+}
+
+// CheckPort verifies a listener's address is valid, free, and not shared
+// with another listener. An empty address is a disabled listener.
+func (p *Preflight) CheckPort(name, addr string) {
+	if addr == "" {
+		return
+	}
+	check := "port " + name
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		p.record(check, PreflightFatal, "invalid address %q: %v", addr, err)
+		return
+	}
+	if other, taken := p.ports[addr]; taken {
+		p.record(check, PreflightFatal, "%s is also the %s address", addr, other)
+		return
+	}
+	p.ports[addr] = name
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		p.record(check, PreflightFatal, "%s is not available: %v", addr, err)
+		return
+	}
+	listener.Close()
+	p.record(check, PreflightOK, "%s is available", addr)
+}
+
+// Results returns every recorded check in order
+func (p *Preflight) Results() []PreflightResult {
+	return p.results
+}
+
+// Fatal reports whether any check found a problem that must stop startup
+func (p *Preflight) Fatal() bool {
+	for _, r := range p.results {
+		if r.Status == PreflightFatal {
+			return true
+		}
+	}
+	return false
+}
+
+// Report writes the results as a table followed by a verdict line
+func (p *Preflight) Report(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PREFLIGHT\tSTATUS\tDETAIL")
+	for _, r := range p.results {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", r.Check, r.Status, r.Detail)
+	}
+	tw.Flush()
+
+	if p.Fatal() {
+		fmt.Fprintln(w, "preflight failed: fix the fatal problems above before starting the proxy")
+		return
+	}
+	fmt.Fprintln(w, "preflight passed")
+}
@@ -0,0 +1,178 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/codytheroux96/go-reverse-proxy/internal/registry"
+)
+
+// Prewarm defaults applied to zero PrewarmConfig fields
+const (
+	DefaultPrewarmConnections = 2
+	DefaultPrewarmTimeout     = 5 * time.Second
+)
+
+// PrewarmConfig controls opening connections to backends ahead of traffic
+type PrewarmConfig struct {
+	// Connections is how many connections are opened to each backend; 0
+	// uses DefaultPrewarmConnections. Connections past the transport's idle
+	// limit per host are closed again once warmed.
+	Connections int
+	// Timeout bounds prewarming one backend; 0 uses DefaultPrewarmTimeout
+	Timeout time.Duration
+}
+
+// PrewarmResult reports the last prewarm of a backend
+type PrewarmResult struct {
+	Time time.Time `json:"time"`
+	// Opened counts new connections, Reused pooled ones that were already
+	// warm and Failed requests that got no response
+	Opened int `json:"opened"`
+	Reused int `json:"reused"`
+	Failed int `json:"failed"`
+	// Handshake is the slowest connect and TLS handshake of the new
+	// connections
+	Handshake time.Duration `json:"handshake"`
+	Duration  time.Duration `json:"duration"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// Prewarmer opens a small pool of connections, with their TLS sessions, to
+// each backend when it is registered and when config is reloaded, so the
+// first real requests do not pay for the handshakes
+type Prewarmer struct {
+	cfg    PrewarmConfig
+	logger *slog.Logger
+}
+
+// NewPrewarmer validates cfg and creates a prewarmer
+func NewPrewarmer(cfg PrewarmConfig, logger *slog.Logger) (*Prewarmer, error) {
+	if cfg.Connections < 0 || cfg.Timeout < 0 {
+		return nil, fmt.Errorf("prewarm connections and timeout must not be negative")
+	}
+	if cfg.Connections == 0 {
+		cfg.Connections = DefaultPrewarmConnections
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = DefaultPrewarmTimeout
+	}
+	return &Prewarmer{cfg: cfg, logger: logger}, nil
+}
+
+// SetPrewarmer prewarms backend connections on registration and config
+// reload. It must be called before Start.
+func (app *Application) SetPrewarmer(p *Prewarmer) {
+	app.Prewarm = p
+}
+
+// warm opens the configured number of connections to server by sending that
+// many concurrent requests to its readiness path through client. The
+// responses are drained so their connections go back to the pool.
+func (p *Prewarmer) warm(ctx context.Context, client *http.Client, server registry.Server, path string) PrewarmResult {
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.Timeout)
+	defer cancel()
+
+	result := PrewarmResult{Time: time.Now()}
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		lastErr error
+	)
+	for i := 0; i < p.cfg.Connections; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			timer := &upstreamTimer{}
+			req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, timer.trace()), http.MethodGet, server.BaseURL+path, nil)
+			if err == nil {
+				var resp *http.Response
+				if resp, err = client.Do(req); err == nil {
+					io.Copy(io.Discard, io.LimitReader(resp.Body, MaxHealthDetailBytes))
+					resp.Body.Close()
+				}
+			}
+			timing := timer.timing(time.Time{})
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err != nil:
+				result.Failed++
+				lastErr = err
+			case timing.Reused:
+				result.Reused++
+			default:
+				result.Opened++
+				result.Handshake = max(result.Handshake, timing.Connect+timing.TLS)
+			}
+		}()
+	}
+	wg.Wait()
+
+	result.Duration = time.Since(result.Time)
+	if lastErr != nil {
+		result.Error = lastErr.Error()
+	}
+	return result
+}
+
+// prewarmServer prewarms one backend, logging and recording the result in
+// its health status
+func (app *Application) prewarmServer(ctx context.Context, server registry.Server) {
+	result := app.Prewarm.warm(ctx, app.Client, server, app.HealthMonitor.checks.ReadinessPath)
+	app.HealthMonitor.setPrewarm(server.Name, result)
+
+	if result.Failed > 0 {
+		app.Logger.Warn("backend prewarm failed",
+			"server", server.Name, "opened", result.Opened, "reused", result.Reused, "failed", result.Failed, "error", result.Error)
+		return
+	}
+	app.Logger.Info("backend connections prewarmed",
+		"server", server.Name, "opened", result.Opened, "reused", result.Reused,
+		"handshake", result.Handshake, "duration", result.Duration)
+}
+
+// prewarmAll prewarms every registered backend concurrently
+func (app *Application) prewarmAll(ctx context.Context) {
+	servers, err := app.Registry.GetServers()
+	if err != nil {
+		app.Logger.Warn("failed to list backends to prewarm", "error", err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			app.prewarmServer(ctx, server)
+		}()
+	}
+	wg.Wait()
+}
+
+// subscribePrewarm prewarms registered backends now, each backend as it is
+// registered, and every backend when config is reloaded
+func (app *Application) subscribePrewarm() {
+	go app.prewarmAll(app.ctx)
+
+	app.Events.Subscribe("prewarm", func(e Event) {
+		if e.Type == EventConfigReloaded {
+			app.prewarmAll(app.ctx)
+			return
+		}
+		server, err := app.Registry.GetServer(e.Server)
+		if err != nil || server == nil {
+			return
+		}
+		app.prewarmServer(app.ctx, *server)
+	}, EventServerRegistered, EventConfigReloaded)
+}
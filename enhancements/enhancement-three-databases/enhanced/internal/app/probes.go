@@ -0,0 +1,200 @@
+package app
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProbeCheck is the result of a single readiness or startup check
+type ProbeCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// ProbeResult is the response body returned by the self-health endpoints
+type ProbeResult struct {
+	Status string       `json:"status"`
+	Checks []ProbeCheck `json:"checks,omitempty"`
+}
+
+// Probes tracks the proxy's own lifecycle state for liveness, readiness and
+// startup probes
+type Probes struct {
+	mu               sync.RWMutex
+	started          bool
+	startedAt        time.Time
+	tlsRequired      bool
+	tlsCert          *x509.Certificate
+	criticalPrefixes []string
+}
+
+// NewProbes creates a probe tracker with no critical routes configured
+func NewProbes() *Probes {
+	return &Probes{}
+}
+
+// MarkStarted records that all application components have been started
+func (p *Probes) MarkStarted() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.started = true
+	p.startedAt = time.Now()
+}
+
+// SetTLSCertificate records the certificate served by the proxy listener so
+// readiness can verify TLS material is loaded and unexpired
+func (p *Probes) SetTLSCertificate(cert *tls.Certificate) error {
+	leaf := cert.Leaf
+	if leaf == nil {
+		if len(cert.Certificate) == 0 {
+			return fmt.Errorf("tls certificate has no data")
+		}
+		parsed, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("failed to parse tls certificate: %w", err)
+		}
+		leaf = parsed
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.tlsRequired = true
+	p.tlsCert = leaf
+	return nil
+}
+
+// SetCriticalPrefixes sets the route prefixes that must have at least one
+// healthy backend for the proxy to report ready
+func (p *Probes) SetCriticalPrefixes(prefixes []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.criticalPrefixes = p.criticalPrefixes[:0]
+	for _, prefix := range prefixes {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			p.criticalPrefixes = append(p.criticalPrefixes, prefix)
+		}
+	}
+}
+
+// startupChecks reports whether the application and health monitor have
+// finished starting up
+func (app *Application) startupChecks() []ProbeCheck {
+	app.Probes.mu.RLock()
+	started := app.Probes.started
+	app.Probes.mu.RUnlock()
+
+	checks := []ProbeCheck{{Name: "components_started", OK: started}}
+
+	firstRound := app.HealthMonitor.HasCompletedRound()
+	check := ProbeCheck{Name: "initial_health_checks", OK: firstRound}
+	if !firstRound {
+		check.Detail = "waiting for first round of backend health checks"
+	}
+	checks = append(checks, check)
+
+	return checks
+}
+
+// readinessChecks verifies the proxy can usefully serve traffic
+func (app *Application) readinessChecks() []ProbeCheck {
+	checks := app.startupChecks()
+
+	// Not shutting down, so load balancers move traffic away while sessions
+	// drain
+	if app.SessionsDraining() {
+		checks = append(checks, ProbeCheck{Name: "draining", Detail: "shutting down, client sessions are draining"})
+	}
+
+	// Registry reachable
+	registryCheck := ProbeCheck{Name: "registry", OK: true}
+	if _, err := app.Registry.GetServers(); err != nil {
+		registryCheck.OK = false
+		registryCheck.Detail = err.Error()
+	}
+	checks = append(checks, registryCheck)
+
+	app.Probes.mu.RLock()
+	tlsRequired := app.Probes.tlsRequired
+	tlsCert := app.Probes.tlsCert
+	prefixes := append([]string(nil), app.Probes.criticalPrefixes...)
+	app.Probes.mu.RUnlock()
+
+	// TLS material loaded and unexpired
+	if tlsRequired {
+		tlsCheck := ProbeCheck{Name: "tls", OK: true}
+		if tlsCert == nil {
+			tlsCheck.OK = false
+			tlsCheck.Detail = "certificate not loaded"
+		} else if time.Now().After(tlsCert.NotAfter) {
+			tlsCheck.OK = false
+			tlsCheck.Detail = fmt.Sprintf("certificate expired at %s", tlsCert.NotAfter.Format(time.RFC3339))
+		}
+		checks = append(checks, tlsCheck)
+	}
+
+	// At least one healthy backend per critical route
+	for _, prefix := range prefixes {
+		routeCheck := ProbeCheck{Name: "route:" + prefix}
+
+		_, candidates, found := app.Registry.ServersForPath(prefix)
+		healthy := 0
+		if found {
+			for _, server := range candidates {
+				if app.HealthMonitor.IsHealthy(server.Name) {
+					healthy++
+				}
+			}
+		}
+
+		routeCheck.OK = healthy > 0
+		routeCheck.Detail = fmt.Sprintf("%d/%d backends healthy", healthy, len(candidates))
+		checks = append(checks, routeCheck)
+	}
+
+	return checks
+}
+
+// writeProbe writes a probe result, using 503 when any check failed
+func writeProbe(w http.ResponseWriter, r *http.Request, checks []ProbeCheck) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	result := ProbeResult{Status: "ok", Checks: checks}
+	status := http.StatusOK
+	for _, check := range checks {
+		if !check.OK {
+			result.Status = "fail"
+			status = http.StatusServiceUnavailable
+			break
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, status, result)
+}
+
+// HandleLivez reports whether the proxy process is up and serving requests
+func (app *Application) HandleLivez(w http.ResponseWriter, r *http.Request) {
+	writeProbe(w, r, nil)
+}
+
+// HandleStartupz reports whether the proxy has finished starting up
+func (app *Application) HandleStartupz(w http.ResponseWriter, r *http.Request) {
+	writeProbe(w, r, app.startupChecks())
+}
+
+// HandleReadyz reports whether the proxy is ready to receive traffic
+func (app *Application) HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	writeProbe(w, r, app.readinessChecks())
+}
@@ -0,0 +1,505 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RampEvaluateInterval is how often running traffic ramps are evaluated
+const RampEvaluateInterval = 10 * time.Second
+
+// Traffic ramp defaults, used when a ramp leaves the setting out
+var (
+	DefaultRampSteps        = []int{5, 25, 50, 100}
+	DefaultRampStepDuration = 5 * time.Minute
+)
+
+const (
+	DefaultRampMaxErrorRate = 0.05
+	DefaultRampMinRequests  = 20
+)
+
+// Traffic ramp states
+const (
+	RampRunning    = "running"
+	RampPaused     = "paused"
+	RampCompleted  = "completed"
+	RampRolledBack = "rolled_back"
+	RampCancelled  = "cancelled"
+)
+
+// TrafficRampConfig describes a progressive rollout of a blue/green route's
+// canary group. The canary receives each step's percentage of the prefix's
+// traffic for StepDuration, and is promoted to the active group once the
+// last step passes. A step passes when the canary served at least
+// MinRequests during it within the error rate and p99 latency limits.
+type TrafficRampConfig struct {
+	Prefix string `json:"prefix"`
+	Canary string `json:"canary"`
+	// Steps are canary weights in percent, ending at 100; default 5,25,50,100
+	Steps []int `json:"steps,omitempty"`
+	// StepDuration is a duration string such as "5m"; default 5m
+	StepDuration string `json:"step_duration,omitempty"`
+	// MaxErrorRate is the highest fraction of failed canary requests; default 0.05
+	MaxErrorRate float64 `json:"max_error_rate,omitempty"`
+	// MaxP99Latency is a duration string; empty disables the latency check
+	MaxP99Latency string `json:"max_p99_latency,omitempty"`
+	// MinRequests is how many canary requests a step needs; default 20
+	MinRequests uint64 `json:"min_requests,omitempty"`
+	// OnBreach is "rollback" (default) to send all traffic back to the
+	// active group, or "pause" to hold the current weight
+	OnBreach string `json:"on_breach,omitempty"`
+}
+
+// RampWindow is what the canary served during the current step
+type RampWindow struct {
+	Requests   uint64  `json:"requests"`
+	Failures   uint64  `json:"failures"`
+	ErrorRate  float64 `json:"error_rate"`
+	P99Seconds float64 `json:"p99_seconds"`
+}
+
+// TrafficRampStatus reports a ramp's progress for the admin API
+type TrafficRampStatus struct {
+	TrafficRampConfig
+	State         string     `json:"state"`
+	Weight        int        `json:"weight"`
+	Step          int        `json:"step"`
+	StartedAt     time.Time  `json:"started_at"`
+	StepStartedAt time.Time  `json:"step_started_at"`
+	Reason        string     `json:"reason,omitempty"`
+	Window        RampWindow `json:"window"`
+}
+
+// trafficRamp is a validated ramp and its progress
+type trafficRamp struct {
+	cfg          TrafficRampConfig
+	stepDuration time.Duration
+	maxP99       time.Duration
+
+	mu            sync.Mutex
+	state         string
+	step          int
+	startedAt     time.Time
+	stepStartedAt time.Time
+	reason        string
+	window        RampWindow
+	// latency and failures are the canary's totals when the step started
+	latency  []uint64
+	failures uint64
+}
+
+// TrafficRamps runs at most one ramp per blue/green prefix
+type TrafficRamps struct {
+	mu    sync.Mutex
+	ramps map[string]*trafficRamp
+}
+
+// NewTrafficRamps creates an empty set of traffic ramps
+func NewTrafficRamps() *TrafficRamps {
+	return &TrafficRamps{ramps: make(map[string]*trafficRamp)}
+}
+
+// newTrafficRamp validates a ramp config and fills in its defaults
+func newTrafficRamp(cfg TrafficRampConfig) (*trafficRamp, error) {
+	if cfg.Prefix == "" || cfg.Canary == "" {
+		return nil, fmt.Errorf("prefix and canary are required")
+	}
+
+	if len(cfg.Steps) == 0 {
+		cfg.Steps = DefaultRampSteps
+	}
+	for i, weight := range cfg.Steps {
+		if weight <= 0 || weight > 100 || (i > 0 && weight <= cfg.Steps[i-1]) {
+			return nil, fmt.Errorf("steps must increase from above 0 to at most 100")
+		}
+	}
+	if cfg.Steps[len(cfg.Steps)-1] != 100 {
+		return nil, fmt.Errorf("the last step must be 100")
+	}
+
+	ramp := &trafficRamp{cfg: cfg, stepDuration: DefaultRampStepDuration}
+	if cfg.StepDuration != "" {
+		d, err := time.ParseDuration(cfg.StepDuration)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid step_duration %q", cfg.StepDuration)
+		}
+		ramp.stepDuration = d
+	}
+	if cfg.MaxP99Latency != "" {
+		d, err := time.ParseDuration(cfg.MaxP99Latency)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid max_p99_latency %q", cfg.MaxP99Latency)
+		}
+		ramp.maxP99 = d
+	}
+
+	if cfg.MaxErrorRate < 0 || cfg.MaxErrorRate > 1 {
+		return nil, fmt.Errorf("max_error_rate must be between 0 and 1")
+	}
+	if cfg.MaxErrorRate == 0 {
+		ramp.cfg.MaxErrorRate = DefaultRampMaxErrorRate
+	}
+	if cfg.MinRequests == 0 {
+		ramp.cfg.MinRequests = DefaultRampMinRequests
+	}
+	switch cfg.OnBreach {
+	case "":
+		ramp.cfg.OnBreach = "rollback"
+	case "rollback", "pause":
+	default:
+		return nil, fmt.Errorf("on_breach must be rollback or pause")
+	}
+
+	return ramp, nil
+}
+
+func (ramp *trafficRamp) status() TrafficRampStatus {
+	ramp.mu.Lock()
+	defer ramp.mu.Unlock()
+
+	weight := 0
+	if ramp.state == RampRunning || ramp.state == RampPaused {
+		weight = ramp.cfg.Steps[ramp.step]
+	} else if ramp.state == RampCompleted {
+		weight = 100
+	}
+
+	return TrafficRampStatus{
+		TrafficRampConfig: ramp.cfg,
+		State:             ramp.state,
+		Weight:            weight,
+		Step:              ramp.step,
+		StartedAt:         ramp.startedAt,
+		StepStartedAt:     ramp.stepStartedAt,
+		Reason:            ramp.reason,
+		Window:            ramp.window,
+	}
+}
+
+// List returns the status of every ramp, sorted by prefix
+func (tr *TrafficRamps) List() []TrafficRampStatus {
+	tr.mu.Lock()
+	ramps := make([]*trafficRamp, 0, len(tr.ramps))
+	for _, ramp := range tr.ramps {
+		ramps = append(ramps, ramp)
+	}
+	tr.mu.Unlock()
+
+	statuses := make([]TrafficRampStatus, 0, len(ramps))
+	for _, ramp := range ramps {
+		statuses = append(statuses, ramp.status())
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Prefix < statuses[j].Prefix })
+	return statuses
+}
+
+func (tr *TrafficRamps) get(prefix string) (*trafficRamp, bool) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	ramp, exists := tr.ramps[prefix]
+	return ramp, exists
+}
+
+// Run evaluates the running ramps every interval until the application stops
+func (tr *TrafficRamps) Run(app *Application, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			tr.mu.Lock()
+			ramps := make([]*trafficRamp, 0, len(tr.ramps))
+			for _, ramp := range tr.ramps {
+				ramps = append(ramps, ramp)
+			}
+			tr.mu.Unlock()
+
+			for _, ramp := range ramps {
+				app.evaluateRamp(ramp, time.Now())
+			}
+		case <-app.ctx.Done():
+			return
+		}
+	}
+}
+
+// StartRamp starts ramping a blue/green route's traffic to a canary group,
+// replacing any finished ramp for the prefix
+func (app *Application) StartRamp(cfg TrafficRampConfig) (TrafficRampStatus, error) {
+	ramp, err := newTrafficRamp(cfg)
+	if err != nil {
+		return TrafficRampStatus{}, err
+	}
+
+	route, exists := app.BlueGreen.Get(cfg.Prefix)
+	if !exists {
+		return TrafficRampStatus{}, fmt.Errorf("blue/green route %s not found", cfg.Prefix)
+	}
+	if _, exists := route.Groups[cfg.Canary]; !exists || cfg.Canary == route.Active {
+		return TrafficRampStatus{}, fmt.Errorf("canary must be a group of the route other than the active one")
+	}
+
+	app.Ramps.mu.Lock()
+	defer app.Ramps.mu.Unlock()
+
+	if existing, exists := app.Ramps.ramps[cfg.Prefix]; exists {
+		if state := existing.status().State; state == RampRunning || state == RampPaused {
+			return TrafficRampStatus{}, fmt.Errorf("a ramp is already %s for %s", state, cfg.Prefix)
+		}
+	}
+
+	if err := app.BlueGreen.setCanary(cfg.Prefix, cfg.Canary, ramp.cfg.Steps[0]); err != nil {
+		return TrafficRampStatus{}, err
+	}
+
+	now := time.Now()
+	ramp.state, ramp.startedAt = RampRunning, now
+	app.beginRampStep(ramp, 0, now)
+	app.Ramps.ramps[cfg.Prefix] = ramp
+
+	app.Logger.Info("traffic ramp started", "prefix", cfg.Prefix, "canary", cfg.Canary, "weight", ramp.cfg.Steps[0])
+	app.rampChanged(ramp, "")
+	return ramp.status(), nil
+}
+
+// canaryTotals returns the canary group's latency bucket counts and failures
+func (app *Application) canaryTotals(ramp *trafficRamp) ([]uint64, uint64) {
+	route, _ := app.BlueGreen.Get(ramp.cfg.Prefix)
+	servers := route.Groups[ramp.cfg.Canary]
+
+	var failures uint64
+	for _, server := range servers {
+		failures += app.CircuitBreaker.FailureCount(server)
+	}
+	return app.Latency.backendCounts(servers), failures
+}
+
+// beginRampStep starts measuring a ramp step from the canary's current
+// totals; the caller holds ramp.mu or owns the ramp
+func (app *Application) beginRampStep(ramp *trafficRamp, step int, now time.Time) {
+	ramp.step, ramp.stepStartedAt, ramp.window = step, now, RampWindow{}
+	ramp.latency, ramp.failures = app.canaryTotals(ramp)
+}
+
+// measureRamp computes the canary's window since the step started. Totals
+// can drop when a canary backend is deregistered, so deltas floor at zero.
+func (app *Application) measureRamp(ramp *trafficRamp) RampWindow {
+	latency, failures := app.canaryTotals(ramp)
+
+	var window RampWindow
+	for i := range latency {
+		if latency[i] < ramp.latency[i] {
+			latency[i] = 0
+			continue
+		}
+		latency[i] -= ramp.latency[i]
+		window.Requests += latency[i]
+	}
+	if failures > ramp.failures {
+		window.Failures = failures - ramp.failures
+	}
+
+	if window.Requests > 0 {
+		window.ErrorRate = float64(window.Failures) / float64(window.Requests)
+		window.P99Seconds = latencyQuantile(latency, 0.99)
+	}
+	return window
+}
+
+// evaluateRamp checks a running ramp's current step against its limits,
+// then advances, promotes, pauses or rolls it back
+func (app *Application) evaluateRamp(ramp *trafficRamp, now time.Time) {
+	ramp.mu.Lock()
+	defer ramp.mu.Unlock()
+
+	if ramp.state != RampRunning {
+		return
+	}
+
+	// A manual cutover or route change takes the route out of the ramp's hands
+	route, exists := app.BlueGreen.Get(ramp.cfg.Prefix)
+	if !exists || route.Canary != ramp.cfg.Canary || route.CanaryWeight != ramp.cfg.Steps[ramp.step] {
+		ramp.state, ramp.reason = RampCancelled, "the blue/green route was changed"
+		app.Logger.Warn("traffic ramp cancelled", "prefix", ramp.cfg.Prefix, "reason", ramp.reason)
+		app.rampChanged(ramp, "")
+		return
+	}
+
+	ramp.window = app.measureRamp(ramp)
+	weight := ramp.cfg.Steps[ramp.step]
+
+	if reason := ramp.breach(); reason != "" {
+		ramp.reason = reason
+		if ramp.cfg.OnBreach == "pause" {
+			ramp.state = RampPaused
+		} else {
+			ramp.state = RampRolledBack
+			if err := app.BlueGreen.setCanary(ramp.cfg.Prefix, "", 0); err != nil {
+				app.Logger.Error("failed to roll back traffic ramp", "prefix", ramp.cfg.Prefix, "error", err)
+			}
+		}
+		app.Logger.Warn("traffic ramp "+ramp.state,
+			"prefix", ramp.cfg.Prefix, "canary", ramp.cfg.Canary, "weight", weight, "reason", reason)
+		app.rampChanged(ramp, "")
+		return
+	}
+
+	if now.Sub(ramp.stepStartedAt) < ramp.stepDuration {
+		return
+	}
+	if ramp.window.Requests < ramp.cfg.MinRequests {
+		ramp.reason = fmt.Sprintf("waiting for %d canary requests", ramp.cfg.MinRequests)
+		return
+	}
+	ramp.reason = ""
+
+	if ramp.step == len(ramp.cfg.Steps)-1 {
+		purged, err := app.switchBlueGreen(ramp.cfg.Prefix, route.Active, ramp.cfg.Canary)
+		if err != nil {
+			ramp.state, ramp.reason = RampCancelled, err.Error()
+			app.rampChanged(ramp, "")
+			return
+		}
+		ramp.state = RampCompleted
+		app.Logger.Info("traffic ramp completed",
+			"prefix", ramp.cfg.Prefix, "from", route.Active, "to", ramp.cfg.Canary, "purged", purged)
+		app.rampChanged(ramp, route.Active)
+		return
+	}
+
+	next := ramp.cfg.Steps[ramp.step+1]
+	if err := app.BlueGreen.setCanary(ramp.cfg.Prefix, ramp.cfg.Canary, next); err != nil {
+		app.Logger.Error("failed to advance traffic ramp", "prefix", ramp.cfg.Prefix, "error", err)
+		return
+	}
+	app.beginRampStep(ramp, ramp.step+1, now)
+	app.Logger.Info("traffic ramp advanced", "prefix", ramp.cfg.Prefix, "canary", ramp.cfg.Canary, "weight", next)
+	app.rampChanged(ramp, "")
+}
+
+// breach explains why the current window exceeds the ramp's limits, or
+// returns "" when it does not. Too few requests cannot breach, so a single
+// early failure does not abort the ramp.
+func (ramp *trafficRamp) breach() string {
+	if ramp.window.Requests < ramp.cfg.MinRequests {
+		return ""
+	}
+	if ramp.window.ErrorRate > ramp.cfg.MaxErrorRate {
+		return fmt.Sprintf("error rate %.3f exceeds %.3f", ramp.window.ErrorRate, ramp.cfg.MaxErrorRate)
+	}
+	if ramp.maxP99 > 0 && ramp.window.P99Seconds > ramp.maxP99.Seconds() {
+		return fmt.Sprintf("p99 latency %.3fs exceeds %s", ramp.window.P99Seconds, ramp.maxP99)
+	}
+	return ""
+}
+
+// rampChanged publishes a ramp's new state; the caller holds ramp.mu.
+// promotedFrom names the group the canary replaced when a ramp completes.
+func (app *Application) rampChanged(ramp *trafficRamp, promotedFrom string) {
+	data := map[string]interface{}{
+		"prefix": ramp.cfg.Prefix,
+		"canary": ramp.cfg.Canary,
+		"state":  ramp.state,
+		"step":   ramp.step,
+		"weight": ramp.cfg.Steps[ramp.step],
+	}
+	if ramp.reason != "" {
+		data["reason"] = ramp.reason
+	}
+	if promotedFrom != "" {
+		data["promoted_from"] = promotedFrom
+	}
+	app.Events.Publish(Event{Type: EventTrafficRamp, Data: data})
+}
+
+// HandleRamps serves GET and POST /admin/ramps. GET lists the ramps and
+// POST starts one from a TrafficRampConfig.
+func (app *Application) HandleRamps(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]interface{}{"ramps": app.Ramps.List()})
+
+	case http.MethodPost:
+		var cfg TrafficRampConfig
+		if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+			http.Error(w, "invalid payload in request", http.StatusBadRequest)
+			return
+		}
+
+		status, err := app.StartRamp(cfg)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, status)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleRampAction serves POST /admin/ramps/action with
+// {"prefix": "/api", "action": "pause"}. Actions are pause, resume, which
+// restarts the current step's measurement, and rollback.
+func (app *Application) HandleRampAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Prefix string `json:"prefix"`
+		Action string `json:"action"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid payload in request", http.StatusBadRequest)
+		return
+	}
+
+	ramp, exists := app.Ramps.get(req.Prefix)
+	if !exists {
+		http.Error(w, "traffic ramp not found", http.StatusNotFound)
+		return
+	}
+
+	ramp.mu.Lock()
+	switch {
+	case req.Action == "pause" && ramp.state == RampRunning:
+		ramp.state, ramp.reason = RampPaused, "paused by an operator"
+
+	case req.Action == "resume" && ramp.state == RampPaused:
+		ramp.state, ramp.reason = RampRunning, ""
+		app.beginRampStep(ramp, ramp.step, time.Now())
+
+	case req.Action == "rollback" && (ramp.state == RampRunning || ramp.state == RampPaused):
+		if err := app.BlueGreen.setCanary(ramp.cfg.Prefix, "", 0); err != nil {
+			ramp.mu.Unlock()
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		ramp.state, ramp.reason = RampRolledBack, "rolled back by an operator"
+
+	case req.Action != "pause" && req.Action != "resume" && req.Action != "rollback":
+		ramp.mu.Unlock()
+		http.Error(w, "action must be pause, resume or rollback", http.StatusBadRequest)
+		return
+
+	default:
+		state := ramp.state
+		ramp.mu.Unlock()
+		http.Error(w, fmt.Sprintf("cannot %s a %s ramp", req.Action, state), http.StatusConflict)
+		return
+	}
+	app.rampChanged(ramp, "")
+	app.Logger.InfoContext(r.Context(), "traffic ramp "+ramp.state, "prefix", ramp.cfg.Prefix, "action", req.Action)
+	ramp.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, ramp.status())
+}
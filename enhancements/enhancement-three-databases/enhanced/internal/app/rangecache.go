@@ -0,0 +1,132 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultRangeFillMaxBytes bounds the objects range fill caches when
+// RangeFillConfig.MaxBytes is zero
+const DefaultRangeFillMaxBytes = 32 << 20
+
+// RangeFillConfig makes range requests on cacheable routes, such as ones
+// serving media files, fetch and cache the whole object on a miss so later
+// ranges are sliced from the cache
+type RangeFillConfig struct {
+	// Routes are the route prefixes that fill on a range request
+	Routes []string
+	// MaxBytes is the largest object cached; bigger ones are still sliced
+	// for the request but not stored. 0 uses DefaultRangeFillMaxBytes.
+	MaxBytes int
+}
+
+// SetRangeFill fetches whole objects for range requests on the given routes
+func (app *Application) SetRangeFill(cfg RangeFillConfig) error {
+	if len(cfg.Routes) == 0 {
+		return fmt.Errorf("range fill needs at least one route prefix")
+	}
+	if cfg.MaxBytes < 0 {
+		return fmt.Errorf("range fill max bytes must not be negative")
+	}
+	if cfg.MaxBytes == 0 {
+		cfg.MaxBytes = DefaultRangeFillMaxBytes
+	}
+	app.rangeFill = &cfg
+	return nil
+}
+
+// rangeFillRoute reports whether a range request for path fetches the whole
+// object
+func (app *Application) rangeFillRoute(path string) bool {
+	if app.rangeFill == nil {
+		return false
+	}
+	for _, prefix := range app.rangeFill.Routes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestedRange returns the Range header a response may honor. Cached
+// entries carry no validators to check If-Range against, so a conditional
+// range gets the full response, as RFC 9110 allows.
+func requestedRange(r *http.Request) string {
+	if r.Header.Get("If-Range") != "" {
+		return ""
+	}
+	return r.Header.Get("Range")
+}
+
+// parseByteRange resolves a single "bytes=" range against a body of size
+// bytes, returning the first and last byte offsets. ok is false for a range
+// that should be ignored, such as a multipart or malformed one, and
+// satisfiable is false for one that lies past the end of the body.
+func parseByteRange(spec string, size int) (first, last int, ok, satisfiable bool) {
+	spec, found := strings.CutPrefix(strings.TrimSpace(spec), "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false, false
+	}
+	startText, endText, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false, false
+	}
+
+	if startText == "" {
+		// A suffix range asks for the last n bytes
+		n, err := strconv.Atoi(endText)
+		if err != nil || n < 0 {
+			return 0, 0, false, false
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, true, false
+		}
+		return max(size-n, 0), size - 1, true, true
+	}
+
+	first, err := strconv.Atoi(startText)
+	if err != nil || first < 0 {
+		return 0, 0, false, false
+	}
+	last = size - 1
+	if endText != "" {
+		if last, err = strconv.Atoi(endText); err != nil || last < first {
+			return 0, 0, false, false
+		}
+		last = min(last, size-1)
+	}
+	if first >= size {
+		return 0, 0, true, false
+	}
+	return first, last, true, true
+}
+
+// serveRange answers with the slice of body that spec asks for, 206 or 416,
+// and reports whether it did; a range it cannot honor is left for the caller
+// to answer with the full body
+func serveRange(w http.ResponseWriter, spec string, body []byte) bool {
+	if spec == "" {
+		return false
+	}
+	first, last, ok, satisfiable := parseByteRange(spec, len(body))
+	if !ok {
+		return false
+	}
+
+	w.Header().Set("Accept-Ranges", "bytes")
+	if !satisfiable {
+		w.Header().Del("Content-Length")
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", len(body)))
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return true
+	}
+
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", first, last, len(body)))
+	w.Header().Set("Content-Length", strconv.Itoa(last-first+1))
+	w.WriteHeader(http.StatusPartialContent)
+	w.Write(body[first : last+1])
+	return true
+}
@@ -0,0 +1,223 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Rate limiting algorithms. Each one admits rps requests per second on
+// average and differs in how it treats bursts:
+//
+//   - token_bucket allows bursts of up to burst requests, refilling smoothly
+//   - fixed_window allows burst requests per window of burst/rps seconds,
+//     so up to twice that can pass around a window boundary
+//   - sliding_log allows burst requests in any window of burst/rps seconds
+//   - gcra spaces requests 1/rps apart with a tolerance of burst-1 early
+//     requests, the smoothest of the four
+//
+// A request with a cost of n counts as n requests under every algorithm.
+const (
+	RateLimitTokenBucket = "token_bucket"
+	RateLimitFixedWindow = "fixed_window"
+	RateLimitSlidingLog  = "sliding_log"
+	RateLimitGCRA        = "gcra"
+)
+
+// RateLimitAlgorithms lists the supported algorithm names
+var RateLimitAlgorithms = []string{RateLimitTokenBucket, RateLimitFixedWindow, RateLimitSlidingLog, RateLimitGCRA}
+
+// clientLimiter is one client's limiter state under some algorithm. Requests
+// consume cost units of the burst budget.
+type clientLimiter interface {
+	// Allow reports whether a request of the given cost at now is within the
+	// limit, consuming the cost if it is
+	Allow(now time.Time, cost int) bool
+	// Remaining is how many cost units could be spent at now
+	Remaining(now time.Time) int
+	// Configure applies a new rate and burst, keeping state where possible
+	Configure(rps float64, burst int)
+}
+
+// newClientLimiter creates limiter state for an algorithm
+func newClientLimiter(algorithm string, rps float64, burst int) clientLimiter {
+	var l clientLimiter
+	switch algorithm {
+	case RateLimitFixedWindow:
+		l = &fixedWindowLimiter{}
+	case RateLimitSlidingLog:
+		l = &slidingLogLimiter{}
+	case RateLimitGCRA:
+		l = &gcraLimiter{}
+	default:
+		l = &tokenBucketLimiter{limiter: rate.NewLimiter(rate.Limit(rps), burst)}
+	}
+	l.Configure(rps, burst)
+	return l
+}
+
+// ValidRateLimitAlgorithm reports whether name is a supported algorithm
+func ValidRateLimitAlgorithm(name string) bool {
+	for _, algorithm := range RateLimitAlgorithms {
+		if name == algorithm {
+			return true
+		}
+	}
+	return false
+}
+
+// windowFor is the window in which burst requests are allowed at rps
+func windowFor(rps float64, burst int) time.Duration {
+	return time.Duration(float64(burst) / rps * float64(time.Second))
+}
+
+type tokenBucketLimiter struct {
+	limiter *rate.Limiter
+}
+
+func (l *tokenBucketLimiter) Allow(now time.Time, cost int) bool {
+	return l.limiter.AllowN(now, cost)
+}
+
+func (l *tokenBucketLimiter) Remaining(now time.Time) int {
+	return max(0, int(l.limiter.TokensAt(now)))
+}
+
+func (l *tokenBucketLimiter) Configure(rps float64, burst int) {
+	if l.limiter.Limit() != rate.Limit(rps) {
+		l.limiter.SetLimit(rate.Limit(rps))
+	}
+	if l.limiter.Burst() != burst {
+		l.limiter.SetBurst(burst)
+	}
+}
+
+type fixedWindowLimiter struct {
+	window time.Duration
+	limit  int
+	start  time.Time
+	count  int
+}
+
+func (l *fixedWindowLimiter) Allow(now time.Time, cost int) bool {
+	if now.Sub(l.start) >= l.window {
+		l.start = now.Truncate(l.window)
+		l.count = 0
+	}
+	if l.count+cost > l.limit {
+		return false
+	}
+	l.count += cost
+	return true
+}
+
+func (l *fixedWindowLimiter) Remaining(now time.Time) int {
+	if now.Sub(l.start) >= l.window {
+		return l.limit
+	}
+	return max(0, l.limit-l.count)
+}
+
+func (l *fixedWindowLimiter) Configure(rps float64, burst int) {
+	l.window = windowFor(rps, burst)
+	l.limit = burst
+}
+
+type slidingLogLimiter struct {
+	window time.Duration
+	limit  int
+	log    []slidingLogEntry
+	spent  int
+}
+
+// slidingLogEntry is an admitted request and its cost
+type slidingLogEntry struct {
+	at   time.Time
+	cost int
+}
+
+// expire drops log entries that fell out of the window ending at now
+func (l *slidingLogLimiter) expire(now time.Time) {
+	cutoff := now.Add(-l.window)
+	expired := 0
+	for expired < len(l.log) && !l.log[expired].at.After(cutoff) {
+		l.spent -= l.log[expired].cost
+		expired++
+	}
+	l.log = l.log[expired:]
+}
+
+func (l *slidingLogLimiter) Allow(now time.Time, cost int) bool {
+	l.expire(now)
+	if l.spent+cost > l.limit {
+		return false
+	}
+	l.log = append(l.log, slidingLogEntry{at: now, cost: cost})
+	l.spent += cost
+	return true
+}
+
+func (l *slidingLogLimiter) Remaining(now time.Time) int {
+	l.expire(now)
+	return max(0, l.limit-l.spent)
+}
+
+func (l *slidingLogLimiter) Configure(rps float64, burst int) {
+	l.window = windowFor(rps, burst)
+	l.limit = burst
+}
+
+type gcraLimiter struct {
+	interval time.Duration // emission interval, 1/rps
+	capacity time.Duration // how far ahead of now the schedule may run, burst/rps
+	tat      time.Time     // theoretical arrival time of the next request
+}
+
+func (l *gcraLimiter) Allow(now time.Time, cost int) bool {
+	tat := l.tat
+	if tat.Before(now) {
+		tat = now
+	}
+	tat = tat.Add(time.Duration(cost) * l.interval)
+	if tat.Sub(now) > l.capacity {
+		return false
+	}
+	l.tat = tat
+	return true
+}
+
+func (l *gcraLimiter) Remaining(now time.Time) int {
+	ahead := max(0, l.tat.Sub(now))
+	return int((l.capacity - ahead) / l.interval)
+}
+
+func (l *gcraLimiter) Configure(rps float64, burst int) {
+	l.interval = time.Duration(float64(time.Second) / rps)
+	l.capacity = time.Duration(burst) * l.interval
+}
+
+// ParseRateLimitRoutes parses a comma separated list of prefix=algorithm
+// pairs, for example "/webhooks=token_bucket,/api=gcra"
+func ParseRateLimitRoutes(spec string) (map[string]string, error) {
+	routes := make(map[string]string)
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		prefix, algorithm, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate limit route %q, expected prefix=algorithm", part)
+		}
+		if !ValidRateLimitAlgorithm(algorithm) {
+			return nil, fmt.Errorf("unknown rate limit algorithm %q for %s", algorithm, prefix)
+		}
+		routes[prefix] = algorithm
+	}
+
+	return routes, nil
+}
@@ -0,0 +1,170 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+// Every comparison runs at 10 requests per second with a burst of 5, so
+// fixed and sliding windows are 500ms long
+const (
+	compareRPS   = 10
+	compareBurst = 5
+)
+
+// compareStart is aligned to a fixed window boundary
+var compareStart = time.Unix(1_000_000, 0)
+
+// burst returns n arrivals at offset at
+func burst(at time.Duration, n int) []time.Duration {
+	arrivals := make([]time.Duration, n)
+	for i := range arrivals {
+		arrivals[i] = at
+	}
+	return arrivals
+}
+
+// steady returns arrivals every interval from start until end
+func steady(start, end, interval time.Duration) []time.Duration {
+	var arrivals []time.Duration
+	for at := start; at < end; at += interval {
+		arrivals = append(arrivals, at)
+	}
+	return arrivals
+}
+
+// admit runs arrivals of cost 1 through a fresh limiter and returns the
+// offsets of the requests it admitted
+func admit(algorithm string, arrivals []time.Duration) []time.Duration {
+	l := newClientLimiter(algorithm, compareRPS, compareBurst)
+	var admitted []time.Duration
+	for _, at := range arrivals {
+		if l.Allow(compareStart.Add(at), 1) {
+			admitted = append(admitted, at)
+		}
+	}
+	return admitted
+}
+
+// maxInSpan is the most admitted requests within any span
+func maxInSpan(admitted []time.Duration, span time.Duration) int {
+	most := 0
+	for i := range admitted {
+		n := 0
+		for j := i; j < len(admitted) && admitted[j]-admitted[i] < span; j++ {
+			n++
+		}
+		most = max(most, n)
+	}
+	return most
+}
+
+func TestRateLimitAlgorithmsCompared(t *testing.T) {
+	concat := func(parts ...[]time.Duration) []time.Duration {
+		var all []time.Duration
+		for _, p := range parts {
+			all = append(all, p...)
+		}
+		return all
+	}
+
+	tests := []struct {
+		name     string
+		arrivals []time.Duration
+		measure  func(admitted []time.Duration) int
+		want     map[string]int
+	}{
+		{
+			// Every algorithm allows one burst of up to burst requests
+			name:     "single burst",
+			arrivals: burst(0, 20),
+			measure:  func(a []time.Duration) int { return len(a) },
+			want:     map[string]int{RateLimitTokenBucket: 5, RateLimitFixedWindow: 5, RateLimitSlidingLog: 5, RateLimitGCRA: 5},
+		},
+		{
+			// Traffic at exactly the limit is never rejected
+			name:     "steady at the limit",
+			arrivals: steady(0, 10*time.Second, 100*time.Millisecond),
+			measure:  func(a []time.Duration) int { return len(a) },
+			want:     map[string]int{RateLimitTokenBucket: 100, RateLimitFixedWindow: 100, RateLimitSlidingLog: 100, RateLimitGCRA: 100},
+		},
+		{
+			// Bursts either side of a window boundary: only the fixed
+			// window lets both through, twice the burst within 50ms
+			name:     "bursts across a window boundary",
+			arrivals: concat(burst(450*time.Millisecond, 5), burst(500*time.Millisecond, 5)),
+			measure:  func(a []time.Duration) int { return len(a) },
+			want:     map[string]int{RateLimitTokenBucket: 5, RateLimitFixedWindow: 10, RateLimitSlidingLog: 5, RateLimitGCRA: 5},
+		},
+		{
+			// Five times the limit for 10 seconds: every algorithm holds
+			// the average, allowing the rate plus at most one burst
+			name:     "sustained overload",
+			arrivals: steady(0, 10*time.Second, 20*time.Millisecond),
+			measure:  func(a []time.Duration) int { return len(a) },
+			want:     map[string]int{RateLimitTokenBucket: 104, RateLimitFixedWindow: 100, RateLimitSlidingLog: 100, RateLimitGCRA: 104},
+		},
+		{
+			// Once a burst is spent under overload, the token bucket and
+			// GCRA admit requests one at a time as capacity returns, while
+			// the windows release a whole burst at once
+			name:     "smoothness after a burst",
+			arrivals: concat(burst(0, 5), steady(10*time.Millisecond, 2*time.Second, 10*time.Millisecond)),
+			measure: func(a []time.Duration) int {
+				var after []time.Duration
+				for _, at := range a {
+					if at > 0 {
+						after = append(after, at)
+					}
+				}
+				return maxInSpan(after, 100*time.Millisecond)
+			},
+			want: map[string]int{RateLimitTokenBucket: 1, RateLimitFixedWindow: 5, RateLimitSlidingLog: 5, RateLimitGCRA: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, algorithm := range RateLimitAlgorithms {
+				if got := tt.measure(admit(algorithm, tt.arrivals)); got != tt.want[algorithm] {
+					t.Errorf("%s: got %d, want %d", algorithm, got, tt.want[algorithm])
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimitAlgorithmsCountCost(t *testing.T) {
+	for _, algorithm := range RateLimitAlgorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			l := newClientLimiter(algorithm, compareRPS, compareBurst)
+			if !l.Allow(compareStart, 3) {
+				t.Fatal("request costing 3 of a burst of 5 was rejected")
+			}
+			if got := l.Remaining(compareStart); got != 2 {
+				t.Fatalf("remaining %d, want 2", got)
+			}
+			if l.Allow(compareStart, 3) {
+				t.Fatal("request costing more than the remaining burst was admitted")
+			}
+			if !l.Allow(compareStart, 2) {
+				t.Fatal("request costing the remaining burst was rejected")
+			}
+		})
+	}
+}
+
+func TestParseRateLimitRoutes(t *testing.T) {
+	routes, err := ParseRateLimitRoutes("/webhooks=token_bucket, /api=gcra")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if routes["/webhooks"] != RateLimitTokenBucket || routes["/api"] != RateLimitGCRA || len(routes) != 2 {
+		t.Fatalf("routes %v", routes)
+	}
+	for _, spec := range []string{"/api", "/api=leaky_bucket"} {
+		if _, err := ParseRateLimitRoutes(spec); err == nil {
+			t.Errorf("%q parsed without error", spec)
+		}
+	}
+}
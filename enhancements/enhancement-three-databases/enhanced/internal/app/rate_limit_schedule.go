@@ -0,0 +1,299 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// RateLimitScheduleInterval is how often the active profile is re-evaluated
+const RateLimitScheduleInterval = 15 * time.Second
+
+// RateLimitScheduleConfig is the on-disk rate limit schedule. Profiles are
+// checked in order and the first whose window contains the current time
+// overrides the configured limits; outside every window the configured
+// limits apply unchanged.
+type RateLimitScheduleConfig struct {
+	// Timezone is an IANA zone name such as "America/New_York"; default UTC
+	Timezone string                   `json:"timezone"`
+	Profiles []RateLimitProfileConfig `json:"profiles"`
+}
+
+// RateLimitProfileConfig overrides limiter settings during a daily window.
+// Start and End are "HH:MM" wall clock times in the schedule's timezone; an
+// End before Start wraps past midnight, and leaving both out covers the
+// whole day. Days limits the window to the days
+// it starts on ("mon" through "sun"); empty means every day.
+type RateLimitProfileConfig struct {
+	Name      string   `json:"name"`
+	Days      []string `json:"days,omitempty"`
+	Start     string   `json:"start,omitempty"`
+	End       string   `json:"end,omitempty"`
+	Enabled   *bool    `json:"enabled,omitempty"`
+	RPS       *float64 `json:"rps,omitempty"`
+	Burst     *int     `json:"burst,omitempty"`
+	Algorithm string   `json:"algorithm,omitempty"`
+	SoftRPS   *float64 `json:"soft_rps,omitempty"`
+}
+
+// rateLimitProfile is a validated profile
+type rateLimitProfile struct {
+	cfg   RateLimitProfileConfig
+	days  [7]bool
+	start int // minutes after midnight
+	end   int
+	// allDay profiles have no start or end
+	allDay bool
+}
+
+// RateLimitScheduler switches the rate limiter between profiles by time of day
+type RateLimitScheduler struct {
+	loc      *time.Location
+	profiles []*rateLimitProfile
+	active   atomic.Pointer[rateLimitProfile]
+	logger   *slog.Logger
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// LoadRateLimitSchedule reads a rate limit schedule file
+func LoadRateLimitSchedule(path string, logger *slog.Logger) (*RateLimitScheduler, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rate limit schedule: %w", err)
+	}
+
+	var cfg RateLimitScheduleConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse rate limit schedule: %w", err)
+	}
+
+	return NewRateLimitScheduler(cfg, logger)
+}
+
+// NewRateLimitScheduler validates a schedule and selects the profile active now
+func NewRateLimitScheduler(cfg RateLimitScheduleConfig, logger *slog.Logger) (*RateLimitScheduler, error) {
+	loc := time.UTC
+	if cfg.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(cfg.Timezone); err != nil {
+			return nil, fmt.Errorf("invalid rate limit schedule timezone: %w", err)
+		}
+	}
+
+	s := &RateLimitScheduler{loc: loc, logger: logger}
+	for _, pc := range cfg.Profiles {
+		p, err := newRateLimitProfile(pc)
+		if err != nil {
+			return nil, fmt.Errorf("rate limit profile %q: %w", pc.Name, err)
+		}
+		s.profiles = append(s.profiles, p)
+	}
+
+	s.active.Store(s.profileAt(time.Now()))
+	return s, nil
+}
+
+func newRateLimitProfile(cfg RateLimitProfileConfig) (*rateLimitProfile, error) {
+	if cfg.Name == "" {
+		return nil, fmt.Errorf("profiles need a name")
+	}
+
+	p := &rateLimitProfile{cfg: cfg}
+
+	if cfg.Start == "" && cfg.End == "" {
+		p.allDay = true
+	} else {
+		var err error
+		if p.start, err = parseClock(cfg.Start); err != nil {
+			return nil, err
+		}
+		if p.end, err = parseClock(cfg.End); err != nil {
+			return nil, err
+		}
+		if p.start == p.end {
+			return nil, fmt.Errorf("start and end must differ")
+		}
+	}
+
+	if len(cfg.Days) == 0 {
+		for i := range p.days {
+			p.days[i] = true
+		}
+	}
+	for _, day := range cfg.Days {
+		wd, exists := weekdays[strings.ToLower(day)]
+		if !exists {
+			return nil, fmt.Errorf("unknown day %q", day)
+		}
+		p.days[wd] = true
+	}
+
+	if cfg.RPS != nil && *cfg.RPS <= 0 {
+		return nil, fmt.Errorf("rps must be positive")
+	}
+	if cfg.Burst != nil && *cfg.Burst <= 0 {
+		return nil, fmt.Errorf("burst must be positive")
+	}
+	if cfg.SoftRPS != nil && *cfg.SoftRPS < 0 {
+		return nil, fmt.Errorf("soft_rps must not be negative")
+	}
+	if cfg.Algorithm != "" && !ValidRateLimitAlgorithm(cfg.Algorithm) {
+		return nil, fmt.Errorf("unknown rate limit algorithm %q", cfg.Algorithm)
+	}
+
+	return p, nil
+}
+
+// parseClock parses "HH:MM" into minutes after midnight
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// contains reports whether the profile's window contains local, a time in
+// the schedule's timezone
+func (p *rateLimitProfile) contains(local time.Time) bool {
+	minute := local.Hour()*60 + local.Minute()
+
+	if p.allDay {
+		return p.days[local.Weekday()]
+	}
+	if p.start < p.end {
+		return p.days[local.Weekday()] && minute >= p.start && minute < p.end
+	}
+
+	// The window wraps midnight; after midnight it belongs to the day before
+	if minute >= p.start {
+		return p.days[local.Weekday()]
+	}
+	return minute < p.end && p.days[(local.Weekday()+6)%7]
+}
+
+// apply overrides cfg with the profile's settings
+func (p *rateLimitProfile) apply(cfg RateLimiterConfig) RateLimiterConfig {
+	if p.cfg.Enabled != nil {
+		cfg.enabled = *p.cfg.Enabled
+	}
+	if p.cfg.RPS != nil {
+		cfg.rps = *p.cfg.RPS
+	}
+	if p.cfg.Burst != nil {
+		cfg.burst = *p.cfg.Burst
+	}
+	if p.cfg.Algorithm != "" {
+		cfg.algorithm = p.cfg.Algorithm
+	}
+	if p.cfg.SoftRPS != nil {
+		cfg.softRPS = *p.cfg.SoftRPS
+	}
+	// A soft limit at or above the profile's hard limit never applies
+	if cfg.softRPS >= cfg.rps {
+		cfg.softRPS = 0
+	}
+	return cfg
+}
+
+// profileAt returns the first profile whose window contains now, or nil
+func (s *RateLimitScheduler) profileAt(now time.Time) *rateLimitProfile {
+	local := now.In(s.loc)
+	for _, p := range s.profiles {
+		if p.contains(local) {
+			return p
+		}
+	}
+	return nil
+}
+
+// Active returns the name of the active profile, or "" outside every window
+func (s *RateLimitScheduler) Active() string {
+	if p := s.active.Load(); p != nil {
+		return p.cfg.Name
+	}
+	return ""
+}
+
+// Run re-evaluates the active profile every interval until ctx is done
+func (s *RateLimitScheduler) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			next := s.profileAt(now)
+			prev := s.active.Swap(next)
+			if prev == next {
+				continue
+			}
+
+			switch {
+			case next == nil:
+				s.logger.Info("rate limit profile ended", "profile", prev.cfg.Name)
+			case prev == nil:
+				s.logger.Info("rate limit profile started", "profile", next.cfg.Name)
+			default:
+				s.logger.Info("rate limit profile changed", "from", prev.cfg.Name, "to", next.cfg.Name)
+			}
+		}
+	}
+}
+
+// effectiveLimiterConfig returns the limiter configuration with the active
+// scheduled profile applied
+func (app *Application) effectiveLimiterConfig() RateLimiterConfig {
+	cfg := app.LimiterConfig()
+	if app.RateLimitSchedule == nil {
+		return cfg
+	}
+	if p := app.RateLimitSchedule.active.Load(); p != nil {
+		cfg = p.apply(cfg)
+	}
+	return cfg
+}
+
+// HandleRateLimitSchedule serves GET /admin/ratelimit/schedule
+func (app *Application) HandleRateLimitSchedule(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s := app.RateLimitSchedule
+	if s == nil {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": false})
+		return
+	}
+
+	profiles := make([]RateLimitProfileConfig, 0, len(s.profiles))
+	for _, p := range s.profiles {
+		profiles = append(profiles, p.cfg)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"enabled":  true,
+		"timezone": s.loc.String(),
+		"active":   s.Active(),
+		"profiles": profiles,
+		"limits":   rateLimitView(app.effectiveLimiterConfig()),
+	})
+}
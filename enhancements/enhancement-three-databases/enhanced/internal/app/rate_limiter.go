@@ -1,18 +1,136 @@
 package app
 
 import (
+	"fmt"
 	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+)
 
-	"golang.org/x/time/rate"
+// Rate limit response headers
+const (
+	// RateLimitWarningHeader is set on responses to clients over the soft rate limit
+	RateLimitWarningHeader = "X-RateLimit-Warning"
+	// RateLimitLimitHeader is the client's burst budget in cost units
+	RateLimitLimitHeader = "X-RateLimit-Limit"
+	// RateLimitRemainingHeader is how much of the budget is left after the request
+	RateLimitRemainingHeader = "X-RateLimit-Remaining"
+	// RateLimitCostHeader is what the request cost
+	RateLimitCostHeader = "X-RateLimit-Cost"
 )
 
+// routeCost returns the cost of a request to path, 1 unless a route prefix
+// declares otherwise
+func (cfg RateLimiterConfig) routeCost(path string) int {
+	longest := ""
+	for prefix := range cfg.costs {
+		if strings.HasPrefix(path, prefix) && len(prefix) > len(longest) {
+			longest = prefix
+		}
+	}
+	if longest == "" {
+		return 1
+	}
+	return cfg.costs[longest]
+}
+
+// validateCosts checks that every route cost is positive and fits in the burst
+func (cfg RateLimiterConfig) validateCosts() error {
+	for prefix, cost := range cfg.costs {
+		if cost <= 0 {
+			return fmt.Errorf("cost for %s must be positive", prefix)
+		}
+		if cost > cfg.burst {
+			return fmt.Errorf("cost %d for %s exceeds the burst %d", cost, prefix, cfg.burst)
+		}
+	}
+	return nil
+}
+
+// ParseRateLimitCosts parses a comma separated list of prefix=cost pairs,
+// for example "/export=10,/ping=1"
+func ParseRateLimitCosts(spec string) (map[string]int, error) {
+	costs := make(map[string]int)
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		prefix, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate limit cost %q, expected prefix=cost", part)
+		}
+		cost, err := strconv.Atoi(value)
+		if err != nil || cost <= 0 {
+			return nil, fmt.Errorf("invalid rate limit cost %q for %s", value, prefix)
+		}
+		costs[prefix] = cost
+	}
+
+	return costs, nil
+}
+
+// softLimit returns the soft rate and burst, and whether a soft limit is set
+func (cfg RateLimiterConfig) softLimit() (float64, int, bool) {
+	if cfg.softRPS <= 0 {
+		return 0, 0, false
+	}
+	burst := cfg.softBurst
+	if burst <= 0 {
+		burst = max(1, int(float64(cfg.burst)*cfg.softRPS/cfg.rps))
+	}
+	return cfg.softRPS, burst, true
+}
+
+// validateSoftLimit checks that the soft limit is below the hard limit
+func (cfg RateLimiterConfig) validateSoftLimit() error {
+	if cfg.softRPS < 0 || cfg.softBurst < 0 {
+		return fmt.Errorf("soft rate limit must not be negative")
+	}
+	if cfg.softRPS == 0 {
+		return nil
+	}
+	if cfg.softRPS >= cfg.rps {
+		return fmt.Errorf("soft rps %g must be below the hard rps %g", cfg.softRPS, cfg.rps)
+	}
+	if cfg.softBurst > cfg.burst {
+		return fmt.Errorf("soft burst %d must not exceed the hard burst %d", cfg.softBurst, cfg.burst)
+	}
+	return nil
+}
+
+// routeAlgorithm returns the rate limiting algorithm for a path and the route
+// prefix it was configured for, or "" when the default applies
+func (cfg RateLimiterConfig) routeAlgorithm(path string) (string, string) {
+	longest := ""
+	for prefix := range cfg.routes {
+		if strings.HasPrefix(path, prefix) && len(prefix) > len(longest) {
+			longest = prefix
+		}
+	}
+	if longest == "" {
+		return cfg.algorithm, ""
+	}
+	return cfg.routes[longest], longest
+}
+
+// RateLimit rejects clients over their request rate. It passes the handler's
+// ResponseWriter on unwrapped, only setting the rate limit headers, so the
+// status, streaming and the writer's optional interfaces reach the handler
+// unchanged. net/http serves the requests of a pipelined HTTP/1.1 connection
+// one at a time, so a rejection is answered in order; the body it leaves
+// unread is discarded, or the connection closed if the body is too large.
 func (app *Application) RateLimit(next http.Handler) http.Handler {
 	type client struct {
-		limiter  *rate.Limiter
-		lastSeen time.Time
+		limiter   clientLimiter
+		soft      clientLimiter
+		algorithm string
+		lastSeen  time.Time
 	}
 
 	var (
@@ -37,32 +155,78 @@ func (app *Application) RateLimit(next http.Handler) http.Handler {
 	}()
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if app.config.Limiter.enabled {
-			ip, _, err := net.SplitHostPort(r.RemoteAddr)
-			if err != nil {
-				app.Logger.Error("error getting client IP", "error", err)
-				http.Error(w, "internal server error", http.StatusInternalServerError)
+		cfg := app.effectiveLimiterConfig()
+
+		if cfg.enabled {
+			ip := app.rateLimitKey(r)
+			if ip == "" {
+				var err error
+				ip, _, err = net.SplitHostPort(r.RemoteAddr)
+				if err != nil {
+					app.Logger.ErrorContext(r.Context(), "error getting client IP", "error", err)
+					app.writeError(w, r, CodeInternal, "internal server error")
+					return
+				}
+			}
+
+			if app.rejectBanned(w, r, ip) {
 				return
 			}
 
+			// Routes with their own algorithm are limited separately
+			algorithm, prefix := cfg.routeAlgorithm(r.URL.Path)
+			key := ip
+			if prefix != "" {
+				key = ip + "|" + prefix
+			}
+
 			mu.Lock()
 
-			if _, found := clients[ip]; !found {
-				clients[ip] = &client{
-					limiter: rate.NewLimiter(rate.Limit(app.config.Limiter.rps), app.config.Limiter.burst),
+			now := time.Now()
+			c, found := clients[key]
+			if !found || c.algorithm != algorithm {
+				c = &client{
+					limiter:   newClientLimiter(algorithm, cfg.rps, cfg.burst),
+					algorithm: algorithm,
 				}
+				clients[key] = c
+			} else {
+				// Apply runtime limit changes to clients seen before the change
+				c.limiter.Configure(cfg.rps, cfg.burst)
+			}
+
+			softRPS, softBurst, hasSoft := cfg.softLimit()
+			switch {
+			case !hasSoft:
+				c.soft = nil
+			case c.soft == nil:
+				c.soft = newClientLimiter(algorithm, softRPS, softBurst)
+			default:
+				c.soft.Configure(softRPS, softBurst)
 			}
 
-			clients[ip].lastSeen = time.Now()
+			c.lastSeen = now
 
-			if !clients[ip].limiter.Allow() {
-				mu.Unlock()
-				app.Logger.Info("rate limit exceeded", "client_ip", ip)
-				http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			cost := cfg.routeCost(r.URL.Path)
+			allowed := c.limiter.Allow(now, cost)
+			remaining := c.limiter.Remaining(now)
+			overSoft := allowed && c.soft != nil && !c.soft.Allow(now, cost)
+
+			mu.Unlock()
+
+			w.Header().Set(RateLimitLimitHeader, strconv.Itoa(cfg.burst))
+			w.Header().Set(RateLimitRemainingHeader, strconv.Itoa(remaining))
+			w.Header().Set(RateLimitCostHeader, strconv.Itoa(cost))
+
+			if !allowed {
+				app.rejectRateLimited(w, r, ip, algorithm)
 				return
 			}
 
-			mu.Unlock()
+			if overSoft {
+				app.Logger.InfoContext(r.Context(), "soft rate limit exceeded", "client_ip", ip, "path", r.URL.Path)
+				w.Header().Set(RateLimitWarningHeader, fmt.Sprintf("soft limit of %g requests per second exceeded; requests over %g per second will be rejected", softRPS, cfg.rps))
+			}
 		}
 
 		next.ServeHTTP(w, r)
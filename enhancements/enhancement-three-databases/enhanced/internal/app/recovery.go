@@ -0,0 +1,107 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+// ErrorReport describes a recovered panic for an error reporting service
+type ErrorReport struct {
+	Err       error
+	Stack     []byte
+	RequestID string
+	Method    string
+	Path      string
+	ClientIP  string
+	Timestamp time.Time
+}
+
+// ErrorReporter receives recovered panics. Its shape mirrors Sentry-style
+// clients so an adapter is a few lines of code.
+type ErrorReporter interface {
+	CaptureException(report ErrorReport)
+}
+
+// ErrorReporterFunc adapts a function to the ErrorReporter interface
+type ErrorReporterFunc func(report ErrorReport)
+
+func (f ErrorReporterFunc) CaptureException(report ErrorReport) {
+	f(report)
+}
+
+// SetErrorReporter configures the hook called for every recovered panic
+func (app *Application) SetErrorReporter(reporter ErrorReporter) {
+	app.errorReporter = reporter
+}
+
+// Recover turns a handler panic into a 500 response, logs the stack trace
+// with the request ID and forwards the panic to the error reporter. The
+// connection is kept open so clients and their pools are unaffected. If the
+// handler had already sent its headers, a 500 can no longer be sent, so the
+// response is aborted with http.ErrAbortHandler instead; the client sees a
+// broken response rather than a truncated body that looks complete.
+func (app *Application) Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec, ok := w.(*responseRecorder)
+		if !ok {
+			rec = &responseRecorder{ResponseWriter: w}
+		}
+
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			// Let net/http handle deliberate aborts
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			err, ok := recovered.(error)
+			if !ok {
+				err = fmt.Errorf("%v", recovered)
+			}
+
+			report := ErrorReport{
+				Err:       err,
+				Stack:     debug.Stack(),
+				RequestID: RequestIDFromContext(r.Context()),
+				Method:    r.Method,
+				Path:      r.URL.Path,
+				ClientIP:  r.RemoteAddr,
+				Timestamp: time.Now(),
+			}
+
+			app.Logger.ErrorContext(r.Context(), "panic while handling request",
+				"request_id", report.RequestID,
+				"method", report.Method,
+				"path", report.Path,
+				"error", err,
+				"stack", string(report.Stack))
+
+			if app.errorReporter != nil {
+				app.reportError(report)
+			}
+
+			if rec.status != 0 {
+				panic(http.ErrAbortHandler)
+			}
+			app.writeError(w, r, CodeInternal, "internal server error")
+		}()
+
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// reportError calls the error reporter, guarding against a panicking hook
+func (app *Application) reportError(report ErrorReport) {
+	defer func() {
+		if hookPanic := recover(); hookPanic != nil {
+			app.Logger.Error("error reporter panicked", "request_id", report.RequestID, "panic", hookPanic)
+		}
+	}()
+
+	app.errorReporter.CaptureException(report)
+}
@@ -0,0 +1,89 @@
+package app
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// serveRecovered runs handler behind Recover and returns what it wrote and
+// anything Recover panicked with
+func serveRecovered(app *Application, handler http.HandlerFunc) (rec *httptest.ResponseRecorder, panicked any) {
+	rec = httptest.NewRecorder()
+	defer func() { panicked = recover() }()
+	app.Recover(handler).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/items", nil))
+	return rec, nil
+}
+
+func TestRecoverWritesInternalError(t *testing.T) {
+	app := newTestApp(t)
+	var reports []ErrorReport
+	app.SetErrorReporter(ErrorReporterFunc(func(r ErrorReport) { reports = append(reports, r) }))
+
+	rec, panicked := serveRecovered(app, func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	if panicked != nil {
+		t.Fatalf("Recover panicked with %v", panicked)
+	}
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status %d, want 500", rec.Code)
+	}
+	if len(reports) != 1 || reports[0].Err.Error() != "boom" {
+		t.Fatalf("reports %+v", reports)
+	}
+}
+
+func TestRecoverAbortsAfterHeadersSent(t *testing.T) {
+	app := newTestApp(t)
+	var reports []ErrorReport
+	app.SetErrorReporter(ErrorReporterFunc(func(r ErrorReport) { reports = append(reports, r) }))
+
+	rec, panicked := serveRecovered(app, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, `{"items": [`)
+		panic(errors.New("boom"))
+	})
+	if panicked != http.ErrAbortHandler {
+		t.Fatalf("Recover panicked with %v, want http.ErrAbortHandler", panicked)
+	}
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"items": [` {
+		t.Fatalf("status %d, body %q; want the partial response untouched", rec.Code, rec.Body)
+	}
+	if len(reports) != 1 {
+		t.Fatalf("got %d reports, want 1", len(reports))
+	}
+}
+
+func TestRecoverBreaksClientConnectionAfterHeadersSent(t *testing.T) {
+	app := newTestApp(t)
+	server := httptest.NewServer(app.Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, "partial")
+		w.(http.Flusher).Flush()
+		panic("boom")
+	})))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if _, err := io.ReadAll(resp.Body); err == nil {
+		t.Fatal("client read a complete response after the handler panicked")
+	}
+}
+
+func TestRecoverPassesAbortsThrough(t *testing.T) {
+	app := newTestApp(t)
+	_, panicked := serveRecovered(app, func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})
+	if panicked != http.ErrAbortHandler {
+		t.Fatalf("Recover panicked with %v, want http.ErrAbortHandler", panicked)
+	}
+}
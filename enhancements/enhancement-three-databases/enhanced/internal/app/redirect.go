@@ -0,0 +1,196 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Redirect rule match types
+const (
+	RedirectMatchExact  = "exact"
+	RedirectMatchPrefix = "prefix"
+	RedirectMatchRegex  = "regex"
+)
+
+// RedirectRule describes a single redirect. Rules are evaluated in order and
+// the first match wins.
+type RedirectRule struct {
+	Match string `json:"match"` // exact, prefix or regex
+	From  string `json:"from"`
+	// To is the target path. For prefix rules the unmatched remainder of the
+	// path is appended; for regex rules $1-style references are expanded. An
+	// empty To keeps the original path.
+	To     string `json:"to"`
+	Scheme string `json:"scheme"` // rewrite scheme, e.g. https
+	Host   string `json:"host"`   // rewrite host (and port)
+	Status int    `json:"status"` // 301, 302, 303, 307 or 308; defaults to 307
+	// PreserveQuery carries the original query string over; defaults to true
+	PreserveQuery *bool `json:"preserve_query"`
+}
+
+// RedirectConfig is the on-disk redirect rule file, with rules per listener
+type RedirectConfig struct {
+	HTTP  []RedirectRule `json:"http"`
+	HTTPS []RedirectRule `json:"https"`
+}
+
+type compiledRedirect struct {
+	RedirectRule
+	re *regexp.Regexp
+}
+
+// Redirector applies redirect rules to requests
+type Redirector struct {
+	rules []compiledRedirect
+}
+
+// HTTPSRedirectRules returns the rules for a blanket redirect to an HTTPS host
+func HTTPSRedirectRules(host string) []RedirectRule {
+	return []RedirectRule{{
+		Match:  RedirectMatchPrefix,
+		From:   "/",
+		Scheme: "https",
+		Host:   host,
+		Status: http.StatusTemporaryRedirect,
+	}}
+}
+
+// LoadRedirectConfig reads a redirect rule file
+func LoadRedirectConfig(path string) (*RedirectConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read redirect rules: %w", err)
+	}
+
+	var cfg RedirectConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse redirect rules: %w", err)
+	}
+	return &cfg, nil
+}
+
+// NewRedirector validates and compiles redirect rules
+func NewRedirector(rules []RedirectRule) (*Redirector, error) {
+	rd := &Redirector{}
+
+	for i, rule := range rules {
+		compiled := compiledRedirect{RedirectRule: rule}
+
+		switch rule.Match {
+		case RedirectMatchExact, RedirectMatchPrefix:
+		case RedirectMatchRegex:
+			re, err := regexp.Compile(rule.From)
+			if err != nil {
+				return nil, fmt.Errorf("redirect rule %d: invalid regex: %w", i, err)
+			}
+			compiled.re = re
+		default:
+			return nil, fmt.Errorf("redirect rule %d: unknown match type %q", i, rule.Match)
+		}
+
+		switch rule.Status {
+		case 0:
+			compiled.Status = http.StatusTemporaryRedirect
+		case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+			http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		default:
+			return nil, fmt.Errorf("redirect rule %d: invalid status %d", i, rule.Status)
+		}
+
+		rd.rules = append(rd.rules, compiled)
+	}
+
+	return rd, nil
+}
+
+// Target returns the redirect location and status for a request, if any
+// rule matches
+func (rd *Redirector) Target(r *http.Request) (string, int, bool) {
+	path := r.URL.Path
+
+	for _, rule := range rd.rules {
+		var target string
+
+		switch rule.Match {
+		case RedirectMatchExact:
+			if path != rule.From {
+				continue
+			}
+			target = rule.To
+			if target == "" {
+				target = path
+			}
+
+		case RedirectMatchPrefix:
+			if !strings.HasPrefix(path, rule.From) {
+				continue
+			}
+			if rule.To == "" {
+				target = path
+			} else {
+				target = rule.To + strings.TrimPrefix(path, rule.From)
+			}
+
+		case RedirectMatchRegex:
+			if !rule.re.MatchString(path) {
+				continue
+			}
+			if rule.To == "" {
+				target = path
+			} else {
+				target = rule.re.ReplaceAllString(path, rule.To)
+			}
+		}
+
+		// Absolute targets are used as-is apart from query handling
+		if !strings.Contains(target, "://") && (rule.Scheme != "" || rule.Host != "") {
+			scheme := rule.Scheme
+			if scheme == "" {
+				scheme = "http"
+				if r.TLS != nil {
+					scheme = "https"
+				}
+			}
+			host := rule.Host
+			if host == "" {
+				host = r.Host
+			}
+			target = scheme + "://" + host + target
+		}
+
+		preserveQuery := rule.PreserveQuery == nil || *rule.PreserveQuery
+		if preserveQuery && r.URL.RawQuery != "" {
+			separator := "?"
+			if strings.Contains(target, "?") {
+				separator = "&"
+			}
+			target += separator + r.URL.RawQuery
+		}
+
+		return target, rule.Status, true
+	}
+
+	return "", 0, false
+}
+
+// Middleware redirects matching requests and passes the rest through, so
+// redirect rules can be attached to any listener's chain
+func (rd *Redirector) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if target, status, ok := rd.Target(r); ok {
+			http.Redirect(w, r, target, status)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Handler redirects matching requests and returns 404 for everything else,
+// for listeners that exist only to redirect
+func (rd *Redirector) Handler() http.Handler {
+	return rd.Middleware(http.NotFoundHandler())
+}
@@ -0,0 +1,294 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redisClient is a minimal RESP client holding one connection, enough for
+// the handful of commands leader election needs
+type redisClient struct {
+	addr     string
+	username string
+	password string
+	db       int
+	tls      bool
+
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// redisError is an error reply from the server
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// newRedisClient parses a redis:// or rediss:// URL of the form
+// redis://[user:password@]host[:port][/db]
+func newRedisClient(rawURL string) (*redisClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis URL: %w", err)
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, fmt.Errorf("invalid redis URL scheme %q", u.Scheme)
+	}
+
+	c := &redisClient{addr: u.Host, tls: u.Scheme == "rediss"}
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.username = u.User.Username()
+		c.password, _ = u.User.Password()
+	}
+	if path := strings.TrimPrefix(u.Path, "/"); path != "" {
+		if c.db, err = strconv.Atoi(path); err != nil {
+			return nil, fmt.Errorf("invalid redis database %q", path)
+		}
+	}
+	return c, nil
+}
+
+// do sends a command and returns its reply: a string, int64, []byte, nil
+// or []interface{}. Error replies are returned as redisError.
+func (c *redisClient) do(ctx context.Context, args ...string) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		if err := c.connect(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	reply, err := c.roundTrip(ctx, args)
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		// The connection is in an unknown state; reconnect next time
+		c.conn.Close()
+		c.conn = nil
+	}
+	return reply, err
+}
+
+func (c *redisClient) connect(ctx context.Context) error {
+	var dialer interface {
+		DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+	} = &net.Dialer{}
+	if c.tls {
+		dialer = &tls.Dialer{}
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to redis: %w", err)
+	}
+	c.conn = conn
+	c.reader = bufio.NewReader(conn)
+
+	var setup [][]string
+	if c.password != "" {
+		if c.username != "" {
+			setup = append(setup, []string{"AUTH", c.username, c.password})
+		} else {
+			setup = append(setup, []string{"AUTH", c.password})
+		}
+	}
+	if c.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.db)})
+	}
+	for _, args := range setup {
+		if _, err := c.roundTrip(ctx, args); err != nil {
+			conn.Close()
+			c.conn = nil
+			return fmt.Errorf("failed to set up redis connection: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *redisClient) roundTrip(ctx context.Context, args []string) (interface{}, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(LeaderRenewTimeout)
+	}
+	c.conn.SetDeadline(deadline)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(c.conn, b.String()); err != nil {
+		return nil, err
+	}
+
+	return c.readReply()
+}
+
+func (c *redisClient) readReply() (interface{}, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.reader, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = c.readReply(); err != nil {
+				var replyErr redisError
+				if !errors.As(err, &replyErr) {
+					return nil, err
+				}
+				items[i] = err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply %q", line)
+	}
+}
+
+func (c *redisClient) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// Lua scripts run atomically, so a lease is only renewed or released by the
+// instance that holds it
+const (
+	redisAcquireScript = `
+local holder = redis.call('GET', KEYS[1])
+if holder == ARGV[1] then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	return 1
+end
+if not holder then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+	return 1
+end
+return 0`
+
+	redisReleaseScript = `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0`
+)
+
+// RedisLeaderBackend elects the instance holding a lease key as leader.
+// The lease expires LeaderLeaseTTL after its last renewal, so a failed
+// leader is replaced within that time. The leader shares health as a JSON
+// document under a second key with the same expiry.
+type RedisLeaderBackend struct {
+	client    *redisClient
+	leaseKey  string
+	healthKey string
+}
+
+// NewRedisLeaderBackend creates a leader backend on the Redis server at a
+// redis:// or rediss:// URL, keeping its keys under prefix
+func NewRedisLeaderBackend(rawURL, prefix string) (*RedisLeaderBackend, error) {
+	client, err := newRedisClient(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return &RedisLeaderBackend{
+		client:    client,
+		leaseKey:  prefix + "leader",
+		healthKey: prefix + "health",
+	}, nil
+}
+
+func (b *RedisLeaderBackend) Name() string { return "redis" }
+
+func (b *RedisLeaderBackend) Acquire(ctx context.Context, id string) (bool, error) {
+	ttl := strconv.FormatInt(LeaderLeaseTTL.Milliseconds(), 10)
+	reply, err := b.client.do(ctx, "EVAL", redisAcquireScript, "1", b.leaseKey, id, ttl)
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire leader lease: %w", err)
+	}
+	return reply == int64(1), nil
+}
+
+func (b *RedisLeaderBackend) Release(ctx context.Context, id string) error {
+	defer b.client.close()
+
+	if _, err := b.client.do(ctx, "EVAL", redisReleaseScript, "1", b.leaseKey, id); err != nil {
+		return fmt.Errorf("failed to release leader lease: %w", err)
+	}
+	return nil
+}
+
+func (b *RedisLeaderBackend) StoreHealth(ctx context.Context, statuses map[string]HealthStatus) error {
+	data, err := json.Marshal(statuses)
+	if err != nil {
+		return err
+	}
+
+	ttl := strconv.FormatInt(LeaderLeaseTTL.Milliseconds(), 10)
+	if _, err := b.client.do(ctx, "SET", b.healthKey, string(data), "PX", ttl); err != nil {
+		return fmt.Errorf("failed to store backend health: %w", err)
+	}
+	return nil
+}
+
+func (b *RedisLeaderBackend) LoadHealth(ctx context.Context) (map[string]HealthStatus, error) {
+	reply, err := b.client.do(ctx, "GET", b.healthKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load backend health: %w", err)
+	}
+
+	statuses := make(map[string]HealthStatus)
+	data, ok := reply.([]byte)
+	if !ok {
+		return statuses, nil
+	}
+	if err := json.Unmarshal(data, &statuses); err != nil {
+		return nil, fmt.Errorf("failed to parse backend health: %w", err)
+	}
+	return statuses, nil
+}
@@ -0,0 +1,125 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/codytheroux96/go-reverse-proxy/internal/registry"
+)
+
+// MaxRegisterBatch caps how many servers one batch registration may hold
+const MaxRegisterBatch = 500
+
+// Batch registration item statuses
+const (
+	BatchRegistered = "registered"
+	BatchInvalid    = "invalid"
+	BatchFailed     = "failed"
+	// BatchSkipped marks a valid server left unregistered because another
+	// server in the batch failed
+	BatchSkipped = "skipped"
+)
+
+// BatchResult is the outcome of registering one server of a batch
+type BatchResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// batchRegistry is implemented by registries that can register several
+// servers atomically
+type batchRegistry interface {
+	RegisterBatch(servers []registry.Server) error
+}
+
+// validateBatchServer checks one server of a batch
+func validateBatchServer(server registry.Server) error {
+	if server.Name == "" || server.BaseURL == "" || len(server.Prefixes) == 0 {
+		return fmt.Errorf("name, base_url and routes are required")
+	}
+	for _, route := range server.Prefixes {
+		if !strings.HasPrefix(route, "/") {
+			return fmt.Errorf("route %q must start with /", route)
+		}
+	}
+	return nil
+}
+
+// HandleRegisterBatch serves POST /register/batch with a JSON array of
+// servers. Either every server is registered or none is; the response lists
+// each server's outcome in request order.
+func (app *Application) HandleRegisterBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	batcher, ok := app.Registry.(batchRegistry)
+	if !ok {
+		http.Error(w, "registry does not support batch registration", http.StatusNotImplemented)
+		return
+	}
+
+	var servers []registry.Server
+	if err := json.NewDecoder(r.Body).Decode(&servers); err != nil {
+		http.Error(w, "invalid payload in request", http.StatusBadRequest)
+		return
+	}
+	if len(servers) == 0 || len(servers) > MaxRegisterBatch {
+		http.Error(w, fmt.Sprintf("a batch must hold between 1 and %d servers", MaxRegisterBatch), http.StatusBadRequest)
+		return
+	}
+
+	results := make([]BatchResult, len(servers))
+	seen := make(map[string]bool, len(servers))
+	invalid := false
+	now := time.Now()
+	for i := range servers {
+		servers[i].RegisteredAt = now
+		results[i] = BatchResult{Name: servers[i].Name, Status: BatchSkipped}
+
+		err := validateBatchServer(servers[i])
+		if err == nil && seen[servers[i].Name] {
+			err = fmt.Errorf("server %q appears more than once in the batch", servers[i].Name)
+		}
+		seen[servers[i].Name] = true
+		if err != nil {
+			results[i].Status = BatchInvalid
+			results[i].Error = err.Error()
+			invalid = true
+		}
+	}
+	if invalid {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"registered": 0, "results": results})
+		return
+	}
+
+	err := batcher.RegisterBatch(servers)
+	var batchErr *registry.BatchError
+	switch {
+	case err == nil:
+		for i := range results {
+			results[i].Status = BatchRegistered
+		}
+		app.Logger.InfoContext(r.Context(), "servers registered in batch", "count", len(servers))
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"registered": len(servers), "results": results})
+	case errors.As(err, &batchErr):
+		for i, itemErr := range batchErr.Failed {
+			results[i].Status = BatchFailed
+			results[i].Error = itemErr.Error()
+		}
+		writeJSON(w, http.StatusConflict, map[string]interface{}{"registered": 0, "results": results})
+	default:
+		app.Logger.ErrorContext(r.Context(), "failed to register batch", "error", err)
+		status := http.StatusInternalServerError
+		if errors.Is(err, registry.ErrDatabaseUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(w, "failed to register batch", status)
+	}
+}
@@ -0,0 +1,309 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// Registry webhook defaults applied to zero RegistryWebhookConfig fields
+const (
+	DefaultWebhookMaxAttempts    = 5
+	DefaultWebhookInitialBackoff = time.Second
+	DefaultWebhookMaxBackoff     = time.Minute
+)
+
+// RegistryWebhookSignatureHeader carries "sha256=" and the hex HMAC-SHA256 of
+// the request body under the shared secret
+const RegistryWebhookSignatureHeader = "X-Proxy-Signature-256"
+
+// maxDeadLetters bounds the dead letters kept in memory for the admin API
+const maxDeadLetters = 100
+
+// RegistryWebhookConfig sends registrations and deregistrations to external
+// systems such as a CMDB or a chat channel
+type RegistryWebhookConfig struct {
+	// URLs each receive every registry change
+	URLs []string
+	// Secret signs each delivery; empty sends them unsigned
+	Secret string
+	// MaxAttempts is how many times a delivery is tried before it is dead
+	// lettered; 0 uses DefaultWebhookMaxAttempts
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry, doubling after
+	// each; 0 uses DefaultWebhookInitialBackoff
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between retries; 0 uses
+	// DefaultWebhookMaxBackoff
+	MaxBackoff time.Duration
+	// DeadLetterFile, when set, appends each delivery that ran out of
+	// attempts as a JSON line
+	DeadLetterFile string
+}
+
+// RegistryWebhookPayload is the JSON body of a registry webhook
+type RegistryWebhookPayload struct {
+	// ID is the same for every attempt of a delivery, so receivers can
+	// drop duplicates
+	ID      string    `json:"id"`
+	Event   EventType `json:"event"`
+	Time    time.Time `json:"time"`
+	Server  string    `json:"server"`
+	BaseURL string    `json:"base_url,omitempty"`
+	Routes  []string  `json:"routes,omitempty"`
+}
+
+// DeadLetter is a delivery that failed every attempt
+type DeadLetter struct {
+	URL      string                 `json:"url"`
+	Payload  RegistryWebhookPayload `json:"payload"`
+	Attempts int                    `json:"attempts"`
+	Error    string                 `json:"error"`
+	Time     time.Time              `json:"time"`
+}
+
+// WebhookTargetStats counts deliveries to one URL
+type WebhookTargetStats struct {
+	URL          string `json:"url"`
+	Delivered    uint64 `json:"delivered"`
+	Retries      uint64 `json:"retries"`
+	DeadLettered uint64 `json:"dead_lettered"`
+	LastError    string `json:"last_error,omitempty"`
+}
+
+// RegistryWebhooks POSTs a signed payload to every configured URL when a
+// server registers or deregisters. Each URL gets its changes in order; a
+// failing delivery is retried with doubling backoff, holding back later
+// changes to that URL, and dead lettered once it runs out of attempts.
+type RegistryWebhooks struct {
+	cfg    RegistryWebhookConfig
+	client *http.Client
+	logger *slog.Logger
+
+	mu          sync.Mutex
+	stats       map[string]*WebhookTargetStats
+	deadLetters []DeadLetter
+	file        *os.File
+}
+
+// NewRegistryWebhooks validates cfg and opens the dead letter file
+func NewRegistryWebhooks(cfg RegistryWebhookConfig, logger *slog.Logger) (*RegistryWebhooks, error) {
+	if len(cfg.URLs) == 0 {
+		return nil, fmt.Errorf("at least one webhook URL is required")
+	}
+	for _, raw := range cfg.URLs {
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid webhook URL %q", raw)
+		}
+	}
+	if cfg.MaxAttempts < 0 || cfg.InitialBackoff < 0 || cfg.MaxBackoff < 0 {
+		return nil, fmt.Errorf("webhook attempts and backoff must not be negative")
+	}
+	if cfg.MaxAttempts == 0 {
+		cfg.MaxAttempts = DefaultWebhookMaxAttempts
+	}
+	if cfg.InitialBackoff == 0 {
+		cfg.InitialBackoff = DefaultWebhookInitialBackoff
+	}
+	if cfg.MaxBackoff == 0 {
+		cfg.MaxBackoff = DefaultWebhookMaxBackoff
+	}
+
+	rw := &RegistryWebhooks{
+		cfg:    cfg,
+		client: &http.Client{Timeout: EventWebhookTimeout},
+		logger: logger,
+		stats:  make(map[string]*WebhookTargetStats),
+	}
+	for _, u := range cfg.URLs {
+		rw.stats[u] = &WebhookTargetStats{URL: u}
+	}
+
+	if cfg.DeadLetterFile != "" {
+		file, err := os.OpenFile(cfg.DeadLetterFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("open dead letter file: %w", err)
+		}
+		rw.file = file
+	}
+	return rw, nil
+}
+
+// registryWebhookPayload builds the webhook body for a registry event
+func registryWebhookPayload(e Event) RegistryWebhookPayload {
+	p := RegistryWebhookPayload{ID: newRequestID(), Event: e.Type, Time: e.Time, Server: e.Server}
+	if baseURL, ok := e.Data["base_url"].(string); ok {
+		p.BaseURL = baseURL
+	}
+	if routes, ok := e.Data["routes"].([]string); ok {
+		p.Routes = routes
+	}
+	return p
+}
+
+// Sign returns the signature header value for body
+func (rw *RegistryWebhooks) Sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(rw.cfg.Secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliver sends one payload to target, retrying until it succeeds, runs out
+// of attempts or ctx is done
+func (rw *RegistryWebhooks) deliver(ctx context.Context, clock Clock, target string, p RegistryWebhookPayload) {
+	body, err := json.Marshal(p)
+	if err != nil {
+		rw.logger.Error("failed to encode registry webhook", "error", err)
+		return
+	}
+
+	backoff := rw.cfg.InitialBackoff
+	attempt := 1
+	for ; ; attempt++ {
+		err = rw.post(ctx, target, p, body)
+		if err == nil {
+			rw.mu.Lock()
+			rw.stats[target].Delivered++
+			rw.mu.Unlock()
+			return
+		}
+		if attempt == rw.cfg.MaxAttempts {
+			break
+		}
+
+		rw.mu.Lock()
+		rw.stats[target].Retries++
+		rw.stats[target].LastError = err.Error()
+		rw.mu.Unlock()
+		rw.logger.Warn("registry webhook failed, retrying", "url", target, "server", p.Server, "attempt", attempt, "retry_in", backoff, "error", err)
+
+		if waitErr := sleepCtx(ctx, clock, backoff); waitErr != nil {
+			break
+		}
+		backoff = min(backoff*2, rw.cfg.MaxBackoff)
+	}
+
+	rw.deadLetter(DeadLetter{URL: target, Payload: p, Attempts: attempt, Error: err.Error(), Time: clock.Now()})
+}
+
+// post makes one delivery attempt
+func (rw *RegistryWebhooks) post(ctx context.Context, target string, p RegistryWebhookPayload, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Proxy-Event", string(p.Event))
+	req.Header.Set("X-Proxy-Delivery", p.ID)
+	if rw.cfg.Secret != "" {
+		req.Header.Set(RegistryWebhookSignatureHeader, rw.Sign(body))
+	}
+
+	resp, err := rw.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// deadLetter records a delivery that ran out of attempts
+func (rw *RegistryWebhooks) deadLetter(dl DeadLetter) {
+	rw.logger.Error("registry webhook dead lettered", "url", dl.URL, "server", dl.Payload.Server, "event", dl.Payload.Event, "attempts", dl.Attempts, "error", dl.Error)
+
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	rw.stats[dl.URL].DeadLettered++
+	rw.stats[dl.URL].LastError = dl.Error
+	rw.deadLetters = append(rw.deadLetters, dl)
+	if len(rw.deadLetters) > maxDeadLetters {
+		rw.deadLetters = rw.deadLetters[len(rw.deadLetters)-maxDeadLetters:]
+	}
+
+	if rw.file != nil {
+		line, err := json.Marshal(dl)
+		if err == nil {
+			_, err = rw.file.Write(append(line, '\n'))
+		}
+		if err != nil {
+			rw.logger.Error("failed to write dead letter", "error", err)
+		}
+	}
+}
+
+// Stats returns each URL's delivery counters and the most recent dead
+// letters, oldest first
+func (rw *RegistryWebhooks) Stats() ([]WebhookTargetStats, []DeadLetter) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	targets := make([]WebhookTargetStats, len(rw.cfg.URLs))
+	for i, u := range rw.cfg.URLs {
+		targets[i] = *rw.stats[u]
+	}
+	return targets, append([]DeadLetter(nil), rw.deadLetters...)
+}
+
+// Close closes the dead letter file
+func (rw *RegistryWebhooks) Close() error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if rw.file == nil {
+		return nil
+	}
+	err := rw.file.Close()
+	rw.file = nil
+	return err
+}
+
+// SetRegistryWebhooks notifies rw's URLs of every registration and
+// deregistration. Each URL is its own event subscriber, so a slow one only
+// delays its own deliveries.
+func (app *Application) SetRegistryWebhooks(rw *RegistryWebhooks) {
+	app.RegistryWebhooks = rw
+	for _, target := range rw.cfg.URLs {
+		app.Events.Subscribe("registry-webhook:"+target, func(e Event) {
+			rw.deliver(app.ctx, app.clock, target, registryWebhookPayload(e))
+		}, EventServerRegistered, EventServerDeregistered)
+	}
+}
+
+// HandleRegistryWebhooks serves GET /admin/webhooks with delivery counters
+// per URL and the most recent dead letters
+func (app *Application) HandleRegistryWebhooks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if app.RegistryWebhooks == nil {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": false})
+		return
+	}
+
+	targets, deadLetters := app.RegistryWebhooks.Stats()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"enabled":      true,
+		"targets":      targets,
+		"dead_letters": deadLetters,
+	})
+}
@@ -0,0 +1,183 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Reliability features that can be switched off at runtime
+const (
+	// FeatureRetries retries failed and 5xx backend requests
+	FeatureRetries = "retries"
+	// FeatureCoalescing shares one upstream GET among identical in-flight
+	// requests; it only acts when a Coalescer is configured
+	FeatureCoalescing = "coalescing"
+)
+
+// ReliabilityFeatureNames lists the features ReliabilityFeatures tracks
+var ReliabilityFeatureNames = []string{FeatureRetries, FeatureCoalescing}
+
+// FeatureStats reports what a reliability feature did, so its benefit can be
+// weighed against the load it adds
+type FeatureStats struct {
+	Enabled bool `json:"enabled"`
+	// Activations counts requests the feature acted on: requests that were
+	// retried, or that joined another request's upstream GET
+	Activations uint64 `json:"activations"`
+	// Wins counts activations that paid off: a retried request that got a
+	// response below 500, or a coalesced request served from the shared
+	// response
+	Wins uint64 `json:"wins"`
+	// Cancellations counts activations abandoned because the client went
+	// away or its deadline passed
+	Cancellations uint64 `json:"cancellations"`
+	// AddedLoad counts upstream requests the feature sent on top of one per
+	// client request, and SavedLoad those it spared the backends
+	AddedLoad uint64 `json:"added_load"`
+	SavedLoad uint64 `json:"saved_load"`
+	// ChangedAt is when the feature was last switched at runtime
+	ChangedAt *time.Time `json:"changed_at,omitempty"`
+}
+
+// reliabilityFeature is a feature's kill switch and counters
+type reliabilityFeature struct {
+	enabled       atomic.Bool
+	activations   atomic.Uint64
+	wins          atomic.Uint64
+	cancellations atomic.Uint64
+	added         atomic.Uint64
+	saved         atomic.Uint64
+
+	mu        sync.Mutex
+	changedAt time.Time
+}
+
+// ReliabilityFeatures holds a kill switch and metrics for each advanced
+// reliability feature, so operators can disable one during an incident
+// without redeploying. Every feature starts enabled.
+type ReliabilityFeatures struct {
+	// features is fixed at creation, so it is read without locking
+	features map[string]*reliabilityFeature
+}
+
+// NewReliabilityFeatures creates the switches with every feature enabled
+func NewReliabilityFeatures() *ReliabilityFeatures {
+	rf := &ReliabilityFeatures{features: make(map[string]*reliabilityFeature, len(ReliabilityFeatureNames))}
+	for _, name := range ReliabilityFeatureNames {
+		f := &reliabilityFeature{}
+		f.enabled.Store(true)
+		rf.features[name] = f
+	}
+	return rf
+}
+
+// feature returns a feature's switch; name must be one of
+// ReliabilityFeatureNames
+func (rf *ReliabilityFeatures) feature(name string) *reliabilityFeature {
+	return rf.features[name]
+}
+
+// Enabled reports whether a feature is switched on
+func (rf *ReliabilityFeatures) Enabled(name string) bool {
+	f, exists := rf.features[name]
+	return exists && f.enabled.Load()
+}
+
+// SetEnabled switches a feature on or off
+func (rf *ReliabilityFeatures) SetEnabled(name string, enabled bool) error {
+	f, exists := rf.features[name]
+	if !exists {
+		return fmt.Errorf("unknown reliability feature %q", name)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.enabled.Swap(enabled) != enabled {
+		f.changedAt = time.Now()
+	}
+	return nil
+}
+
+// Stats returns every feature's switch and counters
+func (rf *ReliabilityFeatures) Stats() map[string]FeatureStats {
+	stats := make(map[string]FeatureStats, len(rf.features))
+	for name, f := range rf.features {
+		s := FeatureStats{
+			Enabled:       f.enabled.Load(),
+			Activations:   f.activations.Load(),
+			Wins:          f.wins.Load(),
+			Cancellations: f.cancellations.Load(),
+			AddedLoad:     f.added.Load(),
+			SavedLoad:     f.saved.Load(),
+		}
+		f.mu.Lock()
+		if !f.changedAt.IsZero() {
+			changedAt := f.changedAt
+			s.ChangedAt = &changedAt
+		}
+		f.mu.Unlock()
+		stats[name] = s
+	}
+	return stats
+}
+
+// HandleFeatures serves GET and PUT /admin/features. GET reports each
+// reliability feature's switch and metrics; PUT with
+// {"feature": "retries", "enabled": false} switches one.
+func (app *Application) HandleFeatures(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]interface{}{"features": app.featureView()})
+
+	case http.MethodPut:
+		var req struct {
+			Feature string `json:"feature"`
+			Enabled *bool  `json:"enabled"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Enabled == nil {
+			http.Error(w, "expected {\"feature\": \"<name>\", \"enabled\": true|false}", http.StatusBadRequest)
+			return
+		}
+		if err := app.Reliability.SetEnabled(req.Feature, *req.Enabled); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if *req.Enabled {
+			app.Logger.InfoContext(r.Context(), "reliability feature enabled", "feature", req.Feature)
+		} else {
+			app.Logger.WarnContext(r.Context(), "reliability feature disabled", "feature", req.Feature)
+		}
+		app.configReloaded("features", map[string]interface{}{"feature": req.Feature, "enabled": *req.Enabled})
+		writeJSON(w, http.StatusOK, map[string]interface{}{"features": app.featureView()})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// featureView is the /admin/features representation of the features, noting
+// the ones that are not configured and so never act
+func (app *Application) featureView() []map[string]interface{} {
+	stats := app.Reliability.Stats()
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	view := make([]map[string]interface{}, 0, len(names))
+	for _, name := range names {
+		view = append(view, map[string]interface{}{
+			"feature":    name,
+			"configured": name != FeatureCoalescing || app.Coalescer != nil,
+			"stats":      stats[name],
+		})
+	}
+	return view
+}
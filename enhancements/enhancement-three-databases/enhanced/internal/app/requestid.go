@@ -0,0 +1,87 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds inbound request IDs so clients can't inflate logs
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// RequestID assigns every request an ID, reusing a sane inbound X-Request-ID.
+// The ID is stored on the request context, echoed in the response and
+// forwarded to backends with the attempt number appended (see
+// upstreamRequestID).
+func (app *Application) RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if id == "" || len(id) > maxRequestIDLength {
+			id = newRequestID()
+		}
+
+		r.Header.Set(RequestIDHeader, id)
+		w.Header().Set(RequestIDHeader, id)
+
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequestIDFromContext returns the request ID assigned by the RequestID middleware
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// newRequestID returns a random 128-bit hex identifier
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// upstreamRequestID returns the request ID sent to a backend for one attempt,
+// "<id>.<attempt>", so backend logs show which proxy retry they served
+func upstreamRequestID(id string, attempt int) string {
+	return id + "." + strconv.Itoa(attempt)
+}
+
+// requestIDLogHandler adds the request ID from the context to every record
+// logged with one of the logger's Context methods
+type requestIDLogHandler struct {
+	slog.Handler
+}
+
+// NewRequestIDLogHandler wraps handler so log lines carry a request_id
+// attribute whenever they are logged with a request's context
+func NewRequestIDLogHandler(handler slog.Handler) slog.Handler {
+	if _, ok := handler.(requestIDLogHandler); ok {
+		return handler
+	}
+	return requestIDLogHandler{Handler: handler}
+}
+
+func (h requestIDLogHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestIDFromContext(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h requestIDLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDLogHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDLogHandler) WithGroup(name string) slog.Handler {
+	return requestIDLogHandler{Handler: h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,272 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Resolver defaults applied to zero ResolverConfig fields
+const (
+	DefaultDNSCacheTTL    = 30 * time.Second
+	DefaultDNSNegativeTTL = 5 * time.Second
+	DefaultDNSTimeout     = 2 * time.Second
+)
+
+// ResolverConfig configures how backend hostnames are resolved
+type ResolverConfig struct {
+	// CacheTTL is how long resolved addresses are reused
+	CacheTTL time.Duration
+	// NegativeTTL is how long a failed lookup is answered from the cache
+	// before it is retried
+	NegativeTTL time.Duration
+	// Nameservers are host:port DNS servers tried in order when the system
+	// resolver fails
+	Nameservers []string
+	// Timeout bounds each lookup attempt
+	Timeout time.Duration
+}
+
+// nameserver is the system resolver or one of the fallback nameservers
+type nameserver struct {
+	name     string
+	resolver *net.Resolver
+	failures atomic.Uint64
+}
+
+// dnsEntry is a cached lookup result. Expired successful entries are kept
+// so their addresses can be served when every nameserver fails.
+type dnsEntry struct {
+	addrs   []string
+	err     error
+	expires time.Time
+}
+
+// Resolver resolves backend hostnames for dialing and health checks, caching
+// answers and failures and falling back to other nameservers, so a DNS
+// hiccup shows up as a logged lookup failure rather than a failing backend
+type Resolver struct {
+	cfg         ResolverConfig
+	nameservers []*nameserver
+	logger      *slog.Logger
+	clock       Clock
+	dialer      *net.Dialer
+
+	mu    sync.Mutex
+	cache map[string]*dnsEntry
+
+	latency      *Histogram
+	lookups      atomic.Uint64
+	cacheHits    atomic.Uint64
+	negativeHits atomic.Uint64
+	staleHits    atomic.Uint64
+	failures     atomic.Uint64
+	failovers    atomic.Uint64
+}
+
+// NewResolver validates cfg and creates a resolver that tries the system
+// resolver first, then each fallback nameserver
+func NewResolver(cfg ResolverConfig, logger *slog.Logger) (*Resolver, error) {
+	if cfg.CacheTTL < 0 || cfg.NegativeTTL < 0 || cfg.Timeout < 0 {
+		return nil, fmt.Errorf("dns cache ttl, negative ttl and timeout must not be negative")
+	}
+	if cfg.CacheTTL == 0 {
+		cfg.CacheTTL = DefaultDNSCacheTTL
+	}
+	if cfg.NegativeTTL == 0 {
+		cfg.NegativeTTL = DefaultDNSNegativeTTL
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = DefaultDNSTimeout
+	}
+
+	res := &Resolver{
+		cfg:         cfg,
+		nameservers: []*nameserver{{name: "system", resolver: net.DefaultResolver}},
+		logger:      logger,
+		clock:       SystemClock,
+		dialer:      &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second},
+		cache:       make(map[string]*dnsEntry),
+		latency:     NewHistogram(LatencyBuckets),
+	}
+	for _, addr := range cfg.Nameservers {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, fmt.Errorf("invalid nameserver %q, expected host:port", addr)
+		}
+		res.nameservers = append(res.nameservers, &nameserver{
+			name: addr,
+			resolver: &net.Resolver{
+				PreferGo: true,
+				Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+					return res.dialer.DialContext(ctx, network, addr)
+				},
+			},
+		})
+	}
+	return res, nil
+}
+
+// LookupHost returns a host's addresses from the cache or, once its entry
+// has expired, from the first nameserver that answers
+func (res *Resolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+
+	res.mu.Lock()
+	entry, cached := res.cache[host]
+	res.mu.Unlock()
+	if cached && res.clock.Now().Before(entry.expires) {
+		if entry.err != nil {
+			res.negativeHits.Add(1)
+			return nil, entry.err
+		}
+		res.cacheHits.Add(1)
+		return entry.addrs, nil
+	}
+
+	res.lookups.Add(1)
+	var lastErr error
+	for i, ns := range res.nameservers {
+		lookupCtx, cancel := context.WithTimeout(ctx, res.cfg.Timeout)
+		start := res.clock.Now()
+		addrs, err := ns.resolver.LookupHost(lookupCtx, host)
+		cancel()
+		res.latency.Observe(res.clock.Since(start))
+
+		if err == nil {
+			if i > 0 {
+				res.failovers.Add(1)
+			}
+			res.store(host, &dnsEntry{addrs: addrs, expires: res.clock.Now().Add(res.cfg.CacheTTL)})
+			return addrs, nil
+		}
+
+		ns.failures.Add(1)
+		lastErr = err
+		res.logger.WarnContext(ctx, "dns lookup failed", "host", host, "nameserver", ns.name, "error", err)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+
+	res.failures.Add(1)
+	if cached && entry.err == nil {
+		res.staleHits.Add(1)
+		res.logger.WarnContext(ctx, "serving stale dns answer", "host", host, "addrs", entry.addrs)
+		return entry.addrs, nil
+	}
+
+	err := fmt.Errorf("dns lookup for %s failed: %w", host, lastErr)
+	res.store(host, &dnsEntry{err: err, expires: res.clock.Now().Add(res.cfg.NegativeTTL)})
+	return nil, err
+}
+
+// store caches a lookup result
+func (res *Resolver) store(host string, entry *dnsEntry) {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	res.cache[host] = entry
+}
+
+// DialContext resolves addr's host through the resolver and dials its
+// addresses in turn until one connects
+func (res *Resolver) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// The transport only traces lookups it makes itself, so report this one
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace != nil && trace.DNSStart != nil {
+		trace.DNSStart(httptrace.DNSStartInfo{Host: host})
+	}
+	addrs, err := res.LookupHost(ctx, host)
+	if trace != nil && trace.DNSDone != nil {
+		trace.DNSDone(httptrace.DNSDoneInfo{Err: err})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, ip := range addrs {
+		conn, err := res.dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+// ResolverStats reports lookup counts, failures and latency
+type ResolverStats struct {
+	Lookups      uint64 `json:"lookups"`
+	CacheHits    uint64 `json:"cache_hits"`
+	NegativeHits uint64 `json:"negative_hits"`
+	StaleHits    uint64 `json:"stale_hits"`
+	Failures     uint64 `json:"failures"`
+	Failovers    uint64 `json:"failovers"`
+	// NameserverFailures counts failed lookups by nameserver
+	NameserverFailures map[string]uint64 `json:"nameserver_failures"`
+	CachedHosts        int               `json:"cached_hosts"`
+	Latency            HistogramSnapshot `json:"latency"`
+}
+
+// Stats returns the resolver's counters
+func (res *Resolver) Stats() ResolverStats {
+	res.mu.Lock()
+	cached := len(res.cache)
+	res.mu.Unlock()
+
+	stats := ResolverStats{
+		Lookups:            res.lookups.Load(),
+		CacheHits:          res.cacheHits.Load(),
+		NegativeHits:       res.negativeHits.Load(),
+		StaleHits:          res.staleHits.Load(),
+		Failures:           res.failures.Load(),
+		Failovers:          res.failovers.Load(),
+		NameserverFailures: make(map[string]uint64, len(res.nameservers)),
+		CachedHosts:        cached,
+		Latency:            res.latency.Snapshot(),
+	}
+	for _, ns := range res.nameservers {
+		stats.NameserverFailures[ns.name] = ns.failures.Load()
+	}
+	return stats
+}
+
+// SetResolver makes backend requests and health checks dial through res. It
+// replaces the transport of Client, so it must be called before Start and
+// after any Client replacement.
+func (app *Application) SetResolver(res *Resolver) {
+	res.clock = app.clock
+	app.Resolver = res
+	app.Client.Transport = app.upstreamTransport()
+}
+
+// HandleDNSMetrics serves GET /admin/metrics/dns
+func (app *Application) HandleDNSMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if app.Resolver == nil {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": false})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": true, "stats": app.Resolver.Stats()})
+}
@@ -0,0 +1,257 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// DefaultAssertJSONMaxSize bounds the bodies checked for JSON well-formedness
+// on routes without a max_size; larger bodies are passed through unchecked
+const DefaultAssertJSONMaxSize = 10 << 20
+
+// Response assertion violation reasons
+const (
+	ViolationContentType = "content_type"
+	ViolationTooLarge    = "too_large"
+	ViolationInvalidJSON = "invalid_json"
+)
+
+// ResponseAssertion describes what a route's backend responses must look
+// like, catching backends that start answering API clients with HTML error
+// pages or runaway bodies
+type ResponseAssertion struct {
+	Prefix string `json:"prefix"`
+	// ContentTypes lists the accepted media types, such as
+	// "application/json" or "image/*"; empty accepts any
+	ContentTypes []string `json:"content_types,omitempty"`
+	// MaxSize bounds response bodies in bytes; 0 disables the check
+	MaxSize int64 `json:"max_size,omitempty"`
+	// ValidateJSON checks that non-empty bodies are well-formed JSON
+	ValidateJSON bool `json:"validate_json,omitempty"`
+	// Reject answers violating responses with 502 instead of relaying them
+	Reject bool `json:"reject,omitempty"`
+	// CountAsFailure reports violations to the backend's circuit breaker
+	CountAsFailure bool `json:"count_as_failure,omitempty"`
+}
+
+// responseAssertion is a ResponseAssertion with its violation counters
+type responseAssertion struct {
+	ResponseAssertion
+	contentTypes []string
+	violations   map[string]*atomic.Uint64
+}
+
+// ResponseAssertionStats reports a route's assertion and violation counts
+type ResponseAssertionStats struct {
+	ResponseAssertion
+	Violations map[string]uint64 `json:"violations"`
+}
+
+// ResponseAssertions checks backend responses against per-route assertions
+type ResponseAssertions struct {
+	routes map[string]*responseAssertion
+}
+
+// LoadResponseAssertions reads a JSON array of response assertions
+func LoadResponseAssertions(path string) ([]ResponseAssertion, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response assertions: %w", err)
+	}
+
+	var assertions []ResponseAssertion
+	if err := json.Unmarshal(data, &assertions); err != nil {
+		return nil, fmt.Errorf("failed to parse response assertions: %w", err)
+	}
+	return assertions, nil
+}
+
+// NewResponseAssertions validates a set of response assertions
+func NewResponseAssertions(assertions []ResponseAssertion) (*ResponseAssertions, error) {
+	ra := &ResponseAssertions{routes: make(map[string]*responseAssertion, len(assertions))}
+
+	for _, assertion := range assertions {
+		if !strings.HasPrefix(assertion.Prefix, "/") {
+			return nil, fmt.Errorf("response assertion prefix %q must start with /", assertion.Prefix)
+		}
+		if _, exists := ra.routes[assertion.Prefix]; exists {
+			return nil, fmt.Errorf("duplicate response assertion for %s", assertion.Prefix)
+		}
+		if assertion.MaxSize < 0 {
+			return nil, fmt.Errorf("response assertion for %s: max_size must not be negative", assertion.Prefix)
+		}
+
+		compiled := &responseAssertion{
+			ResponseAssertion: assertion,
+			violations: map[string]*atomic.Uint64{
+				ViolationContentType: {},
+				ViolationTooLarge:    {},
+				ViolationInvalidJSON: {},
+			},
+		}
+		for _, contentType := range assertion.ContentTypes {
+			mediaType := strings.ToLower(strings.TrimSpace(contentType))
+			if !strings.Contains(mediaType, "/") {
+				return nil, fmt.Errorf("response assertion for %s: invalid content type %q", assertion.Prefix, contentType)
+			}
+			compiled.contentTypes = append(compiled.contentTypes, mediaType)
+		}
+
+		ra.routes[assertion.Prefix] = compiled
+	}
+
+	return ra, nil
+}
+
+// match returns the assertion for the longest prefix of path
+func (ra *ResponseAssertions) match(path string) *responseAssertion {
+	longest := ""
+	for prefix := range ra.routes {
+		if strings.HasPrefix(path, prefix) && len(prefix) > len(longest) {
+			longest = prefix
+		}
+	}
+	if longest == "" {
+		return nil
+	}
+	return ra.routes[longest]
+}
+
+// Stats returns every assertion with its violation counts, ordered by prefix
+func (ra *ResponseAssertions) Stats() []ResponseAssertionStats {
+	prefixes := make([]string, 0, len(ra.routes))
+	for prefix := range ra.routes {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+
+	stats := make([]ResponseAssertionStats, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		route := ra.routes[prefix]
+		violations := make(map[string]uint64, len(route.violations))
+		for reason, count := range route.violations {
+			violations[reason] = count.Load()
+		}
+		stats = append(stats, ResponseAssertionStats{ResponseAssertion: route.ResponseAssertion, Violations: violations})
+	}
+	return stats
+}
+
+// acceptsContentType reports whether a Content-Type header is one of the
+// route's accepted media types
+func (a *responseAssertion) acceptsContentType(header string) bool {
+	if len(a.contentTypes) == 0 {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		return false
+	}
+	for _, accepted := range a.contentTypes {
+		if accepted == mediaType || accepted == "*/*" {
+			return true
+		}
+		if major, ok := strings.CutSuffix(accepted, "/*"); ok && strings.HasPrefix(mediaType, major+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// responseViolation is a backend response that failed its route's assertion
+type responseViolation struct {
+	reason    string
+	assertion *responseAssertion
+}
+
+// assertResponse checks a backend response against its route's assertion,
+// logging and counting a violation. Size and JSON checks buffer the body, so
+// resp.Body is replaced with one that replays it. Responses without a body
+// are not checked, and streamed ones only for their content type.
+func (app *Application) assertResponse(r *http.Request, backend *BackendInfo, resp *http.Response) *responseViolation {
+	ra := app.ResponseAssertions
+	if ra == nil {
+		return nil
+	}
+	assertion := ra.match(r.URL.Path)
+	if assertion == nil || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		return nil
+	}
+	if app.skipStage(r, StageAssertions) {
+		return nil
+	}
+
+	reason := ""
+	switch {
+	case !assertion.acceptsContentType(resp.Header.Get("Content-Type")):
+		reason = ViolationContentType
+	case assertion.MaxSize > 0 && resp.ContentLength > assertion.MaxSize:
+		reason = ViolationTooLarge
+	case isStreamingResponse(resp) || (assertion.MaxSize == 0 && !assertion.ValidateJSON):
+	default:
+		reason = assertion.checkBody(resp)
+	}
+	if reason == "" {
+		return nil
+	}
+
+	assertion.violations[reason].Add(1)
+	app.Logger.WarnContext(r.Context(), "backend response failed assertion",
+		"server", backend.Server.Name,
+		"path", r.URL.Path,
+		"prefix", assertion.Prefix,
+		"reason", reason,
+		"status", resp.StatusCode,
+		"content_type", resp.Header.Get("Content-Type"))
+	return &responseViolation{reason: reason, assertion: assertion}
+}
+
+// checkBody reads up to the size limit of the body to check its size and
+// JSON well-formedness, then puts what it read back in front of the rest
+func (a *responseAssertion) checkBody(resp *http.Response) string {
+	limit := a.MaxSize
+	if limit == 0 {
+		limit = DefaultAssertJSONMaxSize
+	}
+
+	var buf bytes.Buffer
+	n, _ := buf.ReadFrom(io.LimitReader(resp.Body, limit+1))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(buf.Bytes()), resp.Body), resp.Body}
+
+	if n > limit {
+		if a.MaxSize > 0 {
+			return ViolationTooLarge
+		}
+		return ""
+	}
+	if a.ValidateJSON && n > 0 && !json.Valid(buf.Bytes()) {
+		return ViolationInvalidJSON
+	}
+	return ""
+}
+
+// HandleResponseAssertions serves GET /admin/response-assertions
+func (app *Application) HandleResponseAssertions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if app.ResponseAssertions == nil {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": false, "assertions": []ResponseAssertionStats{}})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": true, "assertions": app.ResponseAssertions.Stats()})
+}
@@ -0,0 +1,150 @@
+package app
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// responseRecorder is the ResponseWriter wrapper shared by middleware that
+// needs to know what a handler wrote, such as whether it already sent its
+// headers. It records the final status, when the
+// headers were sent and the bytes written, optionally copying the body to
+// tee. It passes through the optional interfaces of the writer it wraps, so
+// streaming (http.Flusher), protocol upgrades such as websockets
+// (http.Hijacker), HTTP/2 push (http.Pusher) and sendfile (io.ReaderFrom)
+// keep working behind it. Unwrap lets http.ResponseController reach the
+// underlying writer for anything else.
+//
+// Informational 1xx responses such as 103 Early Hints are passed on without
+// being recorded, since the handler still writes its final status after
+// them; 101 Switching Protocols is final.
+type responseRecorder struct {
+	http.ResponseWriter
+	status    int
+	headersAt time.Time
+	bytes     int64
+	tee       io.Writer
+}
+
+// commit records status as the response's unless one was already sent
+func (rec *responseRecorder) commit(status int) {
+	if rec.status == 0 {
+		rec.status = status
+		rec.headersAt = time.Now()
+	}
+}
+
+// finish records 200 for a handler that returned without writing anything,
+// as net/http will send
+func (rec *responseRecorder) finish() {
+	rec.commit(http.StatusOK)
+}
+
+func (rec *responseRecorder) WriteHeader(code int) {
+	if code >= 200 || code == http.StatusSwitchingProtocols {
+		rec.commit(code)
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.commit(http.StatusOK)
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	if rec.tee != nil {
+		rec.tee.Write(b[:n])
+	}
+	return n, err
+}
+
+func (rec *responseRecorder) Flush() {
+	rec.commit(http.StatusOK)
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rec *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	rec.commit(http.StatusSwitchingProtocols)
+	return h.Hijack()
+}
+
+func (rec *responseRecorder) Push(target string, opts *http.PushOptions) error {
+	if p, ok := rec.ResponseWriter.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+func (rec *responseRecorder) ReadFrom(src io.Reader) (int64, error) {
+	rec.commit(http.StatusOK)
+	if rec.tee != nil {
+		src = io.TeeReader(src, rec.tee)
+	}
+	n, err := readFrom(rec.ResponseWriter, src)
+	rec.bytes += n
+	return n, err
+}
+
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// readFrom copies src into w, using w's ReadFrom when it has one
+func readFrom(w http.ResponseWriter, src io.Reader) (int64, error) {
+	if rf, ok := w.(io.ReaderFrom); ok {
+		return rf.ReadFrom(src)
+	}
+	// Hide any ReadFrom on w from io.Copy to avoid recursing into it
+	return copyPooled(struct{ io.Writer }{w}, src)
+}
+
+// isStreamingResponse reports whether a backend response should be relayed
+// as it arrives rather than buffered: server-sent events, newline-delimited
+// JSON, or responses that ask for it with X-Accel-Buffering: no
+func isStreamingResponse(resp *http.Response) bool {
+	contentType := resp.Header.Get("Content-Type")
+	return strings.HasPrefix(contentType, "text/event-stream") ||
+		strings.HasPrefix(contentType, "application/x-ndjson") ||
+		strings.EqualFold(resp.Header.Get("X-Accel-Buffering"), "no")
+}
+
+// flushWriter flushes after every write so streamed chunks reach the client
+// immediately
+type flushWriter struct {
+	w  io.Writer
+	rc *http.ResponseController
+}
+
+func (fw flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	if err := fw.rc.Flush(); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// streamResponse writes the backend status and headers, flushes them early,
+// and relays the body chunk by chunk as it arrives
+func streamResponse(w http.ResponseWriter, resp *http.Response) error {
+	rc := http.NewResponseController(w)
+
+	w.WriteHeader(resp.StatusCode)
+	if err := rc.Flush(); err != nil {
+		return err
+	}
+
+	_, err := copyPooled(flushWriter{w: w, rc: rc}, resp.Body)
+	return err
+}
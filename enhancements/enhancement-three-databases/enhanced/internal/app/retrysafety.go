@@ -0,0 +1,129 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// RetryPolicy decides which backend requests may be sent again. Following
+// RFC 9110, a request is retried after a response or after it may have
+// reached the backend only when its method is idempotent, it carries an
+// Idempotency-Key, or it is a POST under a prefix listed as idempotent. Any
+// request may be retried when its attempt never got a connection, since the
+// backend cannot have seen it.
+type RetryPolicy struct {
+	idempotentPosts atomic.Pointer[[]string]
+	// unsafe counts attempts not retried because the request might already
+	// have been processed
+	unsafe atomic.Uint64
+}
+
+// NewRetryPolicy creates a retry policy with no POST treated as idempotent
+func NewRetryPolicy() *RetryPolicy {
+	rp := &RetryPolicy{}
+	rp.idempotentPosts.Store(&[]string{})
+	return rp
+}
+
+// IdempotentPosts returns the path prefixes whose POSTs are retried like
+// idempotent requests
+func (rp *RetryPolicy) IdempotentPosts() []string {
+	return append([]string(nil), *rp.idempotentPosts.Load()...)
+}
+
+// SetIdempotentPosts validates and replaces the path prefixes whose POSTs
+// are retried like idempotent requests
+func (rp *RetryPolicy) SetIdempotentPosts(prefixes []string) error {
+	seen := make(map[string]bool, len(prefixes))
+	for _, prefix := range prefixes {
+		if prefix == "" || prefix[0] != '/' {
+			return fmt.Errorf("idempotent POST prefix %q must start with /", prefix)
+		}
+		if seen[prefix] {
+			return fmt.Errorf("idempotent POST prefix %s is listed more than once", prefix)
+		}
+		seen[prefix] = true
+	}
+	prefixes = append([]string(nil), prefixes...)
+	rp.idempotentPosts.Store(&prefixes)
+	return nil
+}
+
+// Unsafe returns how many attempts were not retried because the request
+// was not idempotent and might already have reached the backend
+func (rp *RetryPolicy) Unsafe() uint64 {
+	return rp.unsafe.Load()
+}
+
+// Idempotent reports whether a request may be sent to a backend more than
+// once: its method is idempotent in RFC 9110's terms, it carries an
+// Idempotency-Key or X-Idempotency-Key header, as net/http's Transport
+// honours, or it is a POST under an idempotent prefix
+func (rp *RetryPolicy) Idempotent(method, path string, header http.Header) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	if _, ok := header["Idempotency-Key"]; ok {
+		return true
+	}
+	if _, ok := header["X-Idempotency-Key"]; ok {
+		return true
+	}
+	if method != http.MethodPost {
+		return false
+	}
+	for _, prefix := range *rp.idempotentPosts.Load() {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseIdempotentPosts parses a comma separated list of path prefixes
+func ParseIdempotentPosts(spec string) []string {
+	var prefixes []string
+	for _, prefix := range strings.Split(spec, ",") {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes
+}
+
+// HandleRetryPolicy serves GET and PUT /admin/retries. PUT with
+// {"idempotent_posts": ["/api/search"]} replaces the prefixes whose POSTs
+// are retried.
+func (app *Application) HandleRetryPolicy(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"idempotent_posts": app.RetryPolicy.IdempotentPosts(),
+			"unsafe_skipped":   app.RetryPolicy.Unsafe(),
+		})
+
+	case http.MethodPut:
+		var req struct {
+			IdempotentPosts []string `json:"idempotent_posts"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid payload in request", http.StatusBadRequest)
+			return
+		}
+		if err := app.RetryPolicy.SetIdempotentPosts(req.IdempotentPosts); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		app.Logger.InfoContext(r.Context(), "idempotent POST prefixes updated", "prefixes", req.IdempotentPosts)
+		app.configReloaded("retries", map[string]interface{}{"idempotent_posts": len(req.IdempotentPosts)})
+		writeJSON(w, http.StatusOK, map[string]interface{}{"idempotent_posts": app.RetryPolicy.IdempotentPosts()})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
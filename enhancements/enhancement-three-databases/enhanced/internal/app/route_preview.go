@@ -0,0 +1,246 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/codytheroux96/go-reverse-proxy/internal/registry"
+)
+
+// maxPreviewReroutes caps the re-routed paths listed in a route preview
+const maxPreviewReroutes = 100
+
+// RoutePreviewRequest is a registry change to preview without applying it
+type RoutePreviewRequest struct {
+	// Register adds servers, or replaces the registered server of the same
+	// name
+	Register []registry.Server `json:"register"`
+	// Deregister removes servers by name
+	Deregister []string `json:"deregister"`
+	// Paths are request paths to check in addition to the recently proxied
+	// ones
+	Paths []string `json:"paths"`
+}
+
+// RouteTableEntry is a prefix and the servers it balances across
+type RouteTableEntry struct {
+	Prefix  string   `json:"prefix"`
+	Servers []string `json:"servers"`
+}
+
+// RouteTableChange is a prefix whose servers the change would alter
+type RouteTableChange struct {
+	Prefix  string   `json:"prefix"`
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+	// Updated lists servers that stay on the prefix with a new base URL
+	Updated []string `json:"updated,omitempty"`
+}
+
+// RouteMatch is the prefix and servers a path is routed to; an empty prefix
+// means no route matches
+type RouteMatch struct {
+	Prefix  string   `json:"prefix"`
+	Servers []string `json:"servers"`
+}
+
+// Reroute is a request path the change would send somewhere else
+type Reroute struct {
+	Path string `json:"path"`
+	// Requests counts the recently proxied requests for the path
+	Requests int        `json:"requests"`
+	From     RouteMatch `json:"from"`
+	To       RouteMatch `json:"to"`
+}
+
+// RoutePreview is the effect of a registry change on the route table
+type RoutePreview struct {
+	Added    []RouteTableEntry  `json:"added"`
+	Removed  []RouteTableEntry  `json:"removed"`
+	Changed  []RouteTableChange `json:"changed"`
+	Rerouted []Reroute          `json:"rerouted"`
+	// PathsChecked counts the distinct paths replayed against both tables
+	PathsChecked int `json:"paths_checked"`
+}
+
+// applyPreview returns servers with req's changes applied
+func applyPreview(servers []registry.Server, req RoutePreviewRequest) ([]registry.Server, error) {
+	byName := make(map[string]registry.Server, len(servers))
+	for _, server := range servers {
+		byName[server.Name] = server
+	}
+
+	for _, name := range req.Deregister {
+		if _, exists := byName[name]; !exists {
+			return nil, fmt.Errorf("server %q is not registered", name)
+		}
+		delete(byName, name)
+	}
+	for _, server := range req.Register {
+		if err := validateBatchServer(server); err != nil {
+			return nil, fmt.Errorf("server %q: %w", server.Name, err)
+		}
+		byName[server.Name] = server
+	}
+
+	result := make([]registry.Server, 0, len(byName))
+	for _, server := range byName {
+		result = append(result, server)
+	}
+	return result, nil
+}
+
+// routeTable maps each prefix to its servers, keyed by server name
+type routeTable map[string]map[string]registry.Server
+
+// newRouteTable builds the route table of servers
+func newRouteTable(servers []registry.Server) routeTable {
+	table := make(routeTable)
+	for _, server := range servers {
+		for _, prefix := range server.Prefixes {
+			if table[prefix] == nil {
+				table[prefix] = make(map[string]registry.Server)
+			}
+			table[prefix][server.Name] = server
+		}
+	}
+	return table
+}
+
+// match routes a path by longest prefix, as the registry does
+func (table routeTable) match(path string) RouteMatch {
+	longest := ""
+	for prefix := range table {
+		if strings.HasPrefix(path, prefix) && len(prefix) > len(longest) {
+			longest = prefix
+		}
+	}
+	return RouteMatch{Prefix: longest, Servers: serverNames(table[longest])}
+}
+
+// serverNames returns the sorted names of servers
+func serverNames(servers map[string]registry.Server) []string {
+	names := make([]string, 0, len(servers))
+	for name := range servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// previewRoutes diffs the route tables before and after a change and replays
+// paths with their request counts against both
+func previewRoutes(before, after []registry.Server, paths map[string]int) RoutePreview {
+	current, proposed := newRouteTable(before), newRouteTable(after)
+	preview := RoutePreview{
+		Added:    []RouteTableEntry{},
+		Removed:  []RouteTableEntry{},
+		Changed:  []RouteTableChange{},
+		Rerouted: []Reroute{},
+	}
+
+	for prefix, servers := range proposed {
+		if _, exists := current[prefix]; !exists {
+			preview.Added = append(preview.Added, RouteTableEntry{Prefix: prefix, Servers: serverNames(servers)})
+		}
+	}
+	for prefix, servers := range current {
+		next, exists := proposed[prefix]
+		if !exists {
+			preview.Removed = append(preview.Removed, RouteTableEntry{Prefix: prefix, Servers: serverNames(servers)})
+			continue
+		}
+
+		change := RouteTableChange{Prefix: prefix}
+		for name, server := range next {
+			old, had := servers[name]
+			switch {
+			case !had:
+				change.Added = append(change.Added, name)
+			case old.BaseURL != server.BaseURL:
+				change.Updated = append(change.Updated, name)
+			}
+		}
+		for name := range servers {
+			if _, kept := next[name]; !kept {
+				change.Removed = append(change.Removed, name)
+			}
+		}
+		if change.Added != nil || change.Removed != nil || change.Updated != nil {
+			sort.Strings(change.Added)
+			sort.Strings(change.Removed)
+			sort.Strings(change.Updated)
+			preview.Changed = append(preview.Changed, change)
+		}
+	}
+
+	for path, requests := range paths {
+		from, to := current.match(path), proposed.match(path)
+		if from.Prefix == "" && to.Prefix == "" {
+			continue
+		}
+		preview.PathsChecked++
+		if from.Prefix != to.Prefix || !slices.Equal(from.Servers, to.Servers) {
+			preview.Rerouted = append(preview.Rerouted, Reroute{Path: path, Requests: requests, From: from, To: to})
+		}
+	}
+
+	sort.Slice(preview.Added, func(i, j int) bool { return preview.Added[i].Prefix < preview.Added[j].Prefix })
+	sort.Slice(preview.Removed, func(i, j int) bool { return preview.Removed[i].Prefix < preview.Removed[j].Prefix })
+	sort.Slice(preview.Changed, func(i, j int) bool { return preview.Changed[i].Prefix < preview.Changed[j].Prefix })
+	sort.Slice(preview.Rerouted, func(i, j int) bool {
+		if preview.Rerouted[i].Requests != preview.Rerouted[j].Requests {
+			return preview.Rerouted[i].Requests > preview.Rerouted[j].Requests
+		}
+		return preview.Rerouted[i].Path < preview.Rerouted[j].Path
+	})
+	if len(preview.Rerouted) > maxPreviewReroutes {
+		preview.Rerouted = preview.Rerouted[:maxPreviewReroutes]
+	}
+	return preview
+}
+
+// HandleRoutePreview serves POST /admin/routes/preview. It applies the
+// proposed registrations and deregistrations to a copy of the registry and
+// returns the prefixes they add, remove and change, and which of the recently
+// proxied paths, plus any given, would be routed differently. Nothing is
+// applied.
+func (app *Application) HandleRoutePreview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req RoutePreviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid payload in request", http.StatusBadRequest)
+		return
+	}
+
+	servers, err := app.Registry.GetServers()
+	if err != nil {
+		http.Error(w, "failed to list servers", http.StatusInternalServerError)
+		return
+	}
+	proposed, err := applyPreview(servers, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	paths := make(map[string]int)
+	for _, entry := range app.RecentRequests.After(0, AccessLogRecentCapacity) {
+		paths[entry.Path]++
+	}
+	for _, path := range req.Paths {
+		if _, seen := paths[path]; !seen {
+			paths[path] = 0
+		}
+	}
+
+	writeJSON(w, http.StatusOK, previewRoutes(servers, proposed, paths))
+}
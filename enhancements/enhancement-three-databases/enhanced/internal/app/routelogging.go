@@ -0,0 +1,232 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// RouteLogRule quiets or raises the logging of requests under a route
+type RouteLogRule struct {
+	Prefix string `json:"prefix"`
+	// Level is the lowest level logged for the route's requests: debug,
+	// info, warn or error; empty keeps the logger's own
+	Level string `json:"level,omitempty"`
+	// SampleRate is the share of the route's requests, between 0 and 1,
+	// whose info and debug lines are logged; 0 logs every request
+	SampleRate float64 `json:"sample_rate,omitempty"`
+}
+
+// RouteLogging holds the per-route log rules. Warnings and errors are always
+// logged, whatever the rules say.
+type RouteLogging struct {
+	rules      atomic.Pointer[[]RouteLogRule]
+	suppressed atomic.Uint64
+}
+
+// NewRouteLogging creates route logging without rules
+func NewRouteLogging() *RouteLogging {
+	rl := &RouteLogging{}
+	rl.rules.Store(&[]RouteLogRule{})
+	return rl
+}
+
+// Rules returns the current rules
+func (rl *RouteLogging) Rules() []RouteLogRule {
+	return append([]RouteLogRule(nil), *rl.rules.Load()...)
+}
+
+// SetRules validates and replaces the rules
+func (rl *RouteLogging) SetRules(rules []RouteLogRule) error {
+	seen := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		if rule.Prefix == "" || rule.Prefix[0] != '/' {
+			return fmt.Errorf("route log prefix %q must start with /", rule.Prefix)
+		}
+		if seen[rule.Prefix] {
+			return fmt.Errorf("route log prefix %s is listed more than once", rule.Prefix)
+		}
+		seen[rule.Prefix] = true
+		if _, err := parseLogLevel(rule.Level); err != nil {
+			return err
+		}
+		if rule.SampleRate < 0 || rule.SampleRate > 1 {
+			return fmt.Errorf("route log sample rate for %s must be between 0 and 1", rule.Prefix)
+		}
+	}
+	rules = append([]RouteLogRule(nil), rules...)
+	rl.rules.Store(&rules)
+	return nil
+}
+
+// Suppressed returns how many info and debug lines the rules have dropped
+func (rl *RouteLogging) Suppressed() uint64 {
+	return rl.suppressed.Load()
+}
+
+// parseLogLevel parses a rule's level, where empty means no override
+func parseLogLevel(level string) (*slog.Level, error) {
+	if level == "" {
+		return nil, nil
+	}
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q, expected debug, info, warn or error", level)
+	}
+	return &l, nil
+}
+
+// ParseRouteLogRules parses a comma separated list of route log rules, each
+// an &-separated list of key=value settings, for example
+// "prefix=/api/items&sample=0.01,prefix=/health&level=warn"
+func ParseRouteLogRules(spec string) ([]RouteLogRule, error) {
+	var rules []RouteLogRule
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		var rule RouteLogRule
+		for _, setting := range strings.Split(part, "&") {
+			key, value, ok := strings.Cut(setting, "=")
+			if !ok || value == "" {
+				return nil, fmt.Errorf("invalid route log setting %q, expected key=value", setting)
+			}
+			switch key {
+			case "prefix":
+				rule.Prefix = value
+			case "level":
+				rule.Level = strings.ToLower(value)
+			case "sample":
+				rate, err := strconv.ParseFloat(value, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid route log sample rate %q", value)
+				}
+				rule.SampleRate = rate
+			default:
+				return nil, fmt.Errorf("unknown route log setting %q", key)
+			}
+		}
+		if rule.Prefix == "" {
+			return nil, fmt.Errorf("route log rule %q has no prefix", part)
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// routeLogKey is the context key of a request's routeLogDecision
+type routeLogKey struct{}
+
+// routeLogDecision is how a request's log lines are filtered, decided once
+// when the request arrives so a sampled request is logged in full
+type routeLogDecision struct {
+	level   *slog.Level
+	sampled bool
+	logging *RouteLogging
+}
+
+// RouteLogs applies the rule of the longest prefix matching the request to
+// the log lines logged with its context
+func (app *Application) RouteLogs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var rule *RouteLogRule
+		rules := *app.RouteLogging.rules.Load()
+		for i := range rules {
+			if strings.HasPrefix(r.URL.Path, rules[i].Prefix) && (rule == nil || len(rules[i].Prefix) > len(rule.Prefix)) {
+				rule = &rules[i]
+			}
+		}
+		if rule == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Levels were checked by SetRules
+		level, _ := parseLogLevel(rule.Level)
+		decision := &routeLogDecision{
+			level:   level,
+			sampled: rule.SampleRate == 0 || rand.Float64() < rule.SampleRate,
+			logging: app.RouteLogging,
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), routeLogKey{}, decision)))
+	})
+}
+
+// routeLogHandler filters the records logged with a request's context by the
+// request's route log rule
+type routeLogHandler struct {
+	slog.Handler
+}
+
+// NewRouteLogHandler wraps handler so per-route log rules apply to lines
+// logged with a request's context
+func NewRouteLogHandler(handler slog.Handler) slog.Handler {
+	if _, ok := handler.(routeLogHandler); ok {
+		return handler
+	}
+	return routeLogHandler{Handler: handler}
+}
+
+func (h routeLogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	decision, _ := ctx.Value(routeLogKey{}).(*routeLogDecision)
+	if decision == nil || level >= slog.LevelWarn {
+		return h.Handler.Enabled(ctx, level)
+	}
+	if !decision.sampled || (decision.level != nil && level < *decision.level) {
+		decision.logging.suppressed.Add(1)
+		return false
+	}
+	// A route's level may go below the logger's own, to debug one route
+	return decision.level != nil || h.Handler.Enabled(ctx, level)
+}
+
+func (h routeLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return routeLogHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h routeLogHandler) WithGroup(name string) slog.Handler {
+	return routeLogHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+// HandleRouteLogging serves GET and PUT /admin/logging/routes. PUT with
+// {"rules": [{"prefix": "/api/items", "sample_rate": 0.01}]} replaces the
+// rules.
+func (app *Application) HandleRouteLogging(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"rules":      app.RouteLogging.Rules(),
+			"suppressed": app.RouteLogging.Suppressed(),
+		})
+
+	case http.MethodPut:
+		var req struct {
+			Rules []RouteLogRule `json:"rules"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid payload in request", http.StatusBadRequest)
+			return
+		}
+		if err := app.RouteLogging.SetRules(req.Rules); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		app.Logger.InfoContext(r.Context(), "route log rules updated", "rules", len(req.Rules))
+		app.configReloaded("route_logging", map[string]interface{}{"rules": len(req.Rules)})
+		writeJSON(w, http.StatusOK, map[string]interface{}{"rules": app.RouteLogging.Rules()})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
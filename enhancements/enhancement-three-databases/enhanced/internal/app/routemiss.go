@@ -0,0 +1,163 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Route miss cache defaults
+const (
+	DefaultRouteMissTTL      = 2 * time.Second
+	DefaultRouteMissCapacity = 10000
+)
+
+// RouteMissConfig configures the cache of paths no route matched
+type RouteMissConfig struct {
+	// TTL is how long a miss is remembered; 0 uses DefaultRouteMissTTL. It
+	// bounds how long a route registered on another proxy sharing the
+	// PostgreSQL registry can go unnoticed for a path that just missed.
+	TTL time.Duration
+	// Capacity bounds the paths remembered; 0 uses DefaultRouteMissCapacity
+	Capacity int
+}
+
+// RouteMissCache remembers request paths that matched no registered route,
+// so repeated probes for paths that do not exist skip the registry lookup.
+// Any registration or deregistration seen by this proxy clears it.
+type RouteMissCache struct {
+	cfg   RouteMissConfig
+	clock Clock
+
+	mu     sync.Mutex
+	misses map[string]time.Time // path -> expiry
+
+	hits     atomic.Uint64
+	stored   atomic.Uint64
+	rejected atomic.Uint64
+}
+
+// NewRouteMissCache creates a route miss cache, applying defaults
+func NewRouteMissCache(cfg RouteMissConfig) (*RouteMissCache, error) {
+	if cfg.TTL < 0 || cfg.Capacity < 0 {
+		return nil, fmt.Errorf("route miss ttl and capacity must not be negative")
+	}
+	if cfg.TTL == 0 {
+		cfg.TTL = DefaultRouteMissTTL
+	}
+	if cfg.Capacity == 0 {
+		cfg.Capacity = DefaultRouteMissCapacity
+	}
+	return &RouteMissCache{cfg: cfg, clock: SystemClock, misses: make(map[string]time.Time)}, nil
+}
+
+// SetRouteMissCache remembers paths no route matched for a short while. It
+// must be called before Start.
+func (app *Application) SetRouteMissCache(cfg RouteMissConfig) error {
+	misses, err := NewRouteMissCache(cfg)
+	if err != nil {
+		return err
+	}
+	misses.clock = app.clock
+	app.RouteMisses = misses
+	return nil
+}
+
+// Missed reports whether path matched no route within the TTL
+func (mc *RouteMissCache) Missed(path string) bool {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	expiresAt, exists := mc.misses[path]
+	if !exists {
+		return false
+	}
+	if mc.clock.Now().After(expiresAt) {
+		delete(mc.misses, path)
+		return false
+	}
+	mc.hits.Add(1)
+	return true
+}
+
+// Remember records that path matched no route. When the cache is full it
+// first drops expired paths, and if none have expired the path is not
+// remembered, so a flood of distinct paths cannot grow it.
+func (mc *RouteMissCache) Remember(path string) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	now := mc.clock.Now()
+	if _, exists := mc.misses[path]; !exists && len(mc.misses) >= mc.cfg.Capacity {
+		for key, expiresAt := range mc.misses {
+			if now.After(expiresAt) {
+				delete(mc.misses, key)
+			}
+		}
+		if len(mc.misses) >= mc.cfg.Capacity {
+			mc.rejected.Add(1)
+			return
+		}
+	}
+	mc.misses[path] = now.Add(mc.cfg.TTL)
+	mc.stored.Add(1)
+}
+
+// Clear forgets every remembered miss
+func (mc *RouteMissCache) Clear() {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	mc.misses = make(map[string]time.Time)
+}
+
+// RouteMissStats reports the route miss cache's size and counters
+type RouteMissStats struct {
+	Entries  int    `json:"entries"`
+	Capacity int    `json:"capacity"`
+	TTL      string `json:"ttl"`
+	// Hits is how many lookups were answered from the cache
+	Hits uint64 `json:"hits"`
+	// Stored is how many misses were remembered
+	Stored uint64 `json:"stored"`
+	// Rejected is how many misses were not remembered because the cache
+	// was full
+	Rejected uint64 `json:"rejected"`
+}
+
+// Stats returns the cache's size and counters
+func (mc *RouteMissCache) Stats() RouteMissStats {
+	mc.mu.Lock()
+	entries := len(mc.misses)
+	mc.mu.Unlock()
+
+	return RouteMissStats{
+		Entries:  entries,
+		Capacity: mc.cfg.Capacity,
+		TTL:      mc.cfg.TTL.String(),
+		Hits:     mc.hits.Load(),
+		Stored:   mc.stored.Load(),
+		Rejected: mc.rejected.Load(),
+	}
+}
+
+// HandleRouteMissMetrics serves GET /admin/metrics/route-misses with the
+// route miss cache's counters, and DELETE to clear it
+func (app *Application) HandleRouteMissMetrics(w http.ResponseWriter, r *http.Request) {
+	if app.RouteMisses == nil {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": false})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": true, "cache": app.RouteMisses.Stats()})
+	case http.MethodDelete:
+		app.RouteMisses.Clear()
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "cleared"})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -9,9 +9,77 @@ func (app *Application) Routes() http.Handler {
 
 	mux.HandleFunc("/", app.reverseProxyHandler)
 
-	mux.HandleFunc("/register", app.Registry.HandleRegister)
-	mux.HandleFunc("/deregister", app.Registry.HandleDeregister)
+	mux.HandleFunc("/register", app.Audited(AuditActionRegister, app.Owned(app.Registry.HandleRegister)))
+	mux.HandleFunc("/register/batch", app.Audited(AuditActionRegister, app.Owned(app.HandleRegisterBatch)))
+	mux.HandleFunc("/deregister", app.Audited(AuditActionDeregister, app.Owned(app.HandleDeregister)))
 	mux.HandleFunc("/registry", app.Registry.HandleRegistryList)
+	mux.HandleFunc("/api/v2/services", app.Audited(AuditActionRegister, app.Owned(app.HandleServicesV2)))
+	mux.HandleFunc("/api/v2/services/{name}", app.Audited(AuditActionDeregister, app.Owned(app.HandleServiceV2)))
+	mux.HandleFunc("/api/v2/openapi.json", app.HandleServicesV2Spec)
+
+	mux.HandleFunc("/livez", app.HandleLivez)
+	mux.HandleFunc("/healthz", app.HandleLivez)
+	mux.HandleFunc("/readyz", app.HandleReadyz)
+	mux.HandleFunc("/startupz", app.HandleStartupz)
+
+	handle(mux, "/admin/audit", app.HandleAuditList, app.adminMiddleware...)
+	handle(mux, "/admin/metrics/latency", app.HandleLatencyMetrics, app.adminMiddleware...)
+	handle(mux, "/admin/metrics/requests", app.HandleRequestMetrics, app.adminMiddleware...)
+	handle(mux, "/admin/metrics/traffic", app.HandleTrafficMetrics, app.adminMiddleware...)
+	handle(mux, "/admin/metrics/bots", app.HandleBotMetrics, app.adminMiddleware...)
+	handle(mux, "/admin/metrics/upstream-timing", app.HandleUpstreamTimingMetrics, app.adminMiddleware...)
+	handle(mux, "/admin/metrics/upstream-errors", app.HandleUpstreamErrorMetrics, app.adminMiddleware...)
+	handle(mux, "/admin/metrics/routes", app.HandleRouteTemplates, app.adminMiddleware...)
+	handle(mux, "/admin/metrics/route-misses", app.Audited(AuditActionMetricsReset, app.HandleRouteMissMetrics), app.adminMiddleware...)
+	handle(mux, "/admin/metrics/connections", app.HandleConnectionMetrics, app.adminMiddleware...)
+	handle(mux, "/admin/metrics/events", app.HandleEventMetrics, app.adminMiddleware...)
+	handle(mux, "/admin/webhooks", app.HandleRegistryWebhooks, app.adminMiddleware...)
+	handle(mux, "/admin/metrics/admission", app.HandleAdmissionMetrics, app.adminMiddleware...)
+	handle(mux, "/admin/metrics/stages", app.HandleStageShedMetrics, app.adminMiddleware...)
+	handle(mux, "/admin/metrics/dns", app.HandleDNSMetrics, app.adminMiddleware...)
+	handle(mux, "/admin/metrics/upstream-connections", app.HandleUpstreamConnMetrics, app.adminMiddleware...)
+	handle(mux, "/admin/metrics/degraded", app.HandleDegradeMetrics, app.adminMiddleware...)
+	handle(mux, "/admin/metrics/zones", app.HandleZoneMetrics, app.adminMiddleware...)
+	handle(mux, "/admin/metrics/weights", app.HandleWeightMetrics, app.adminMiddleware...)
+	handle(mux, "/admin/metrics/database", app.HandleDatabaseMetrics, app.adminMiddleware...)
+	handle(mux, "/admin/metrics/certificates", app.HandleCertificateMetrics, app.adminMiddleware...)
+	handle(mux, "/admin/snapshot", app.HandleSnapshot, app.adminMiddleware...)
+	handle(mux, "/admin/status", app.HandleStatus, app.adminMiddleware...)
+	handle(mux, "/admin/routes/preview", app.HandleRoutePreview, app.adminMiddleware...)
+	handle(mux, "/admin/routing", app.HandleRoutingState, app.adminMiddleware...)
+	handle(mux, "/admin/health", app.HandleHealthList, app.adminMiddleware...)
+	handle(mux, "/admin/leader", app.HandleLeader, app.adminMiddleware...)
+	handle(mux, "/admin/cluster", app.HandleCluster, app.adminMiddleware...)
+	handle(mux, "/admin/tls-policies", app.HandleTLSPolicies, app.adminMiddleware...)
+	handle(mux, "/admin/response-assertions", app.HandleResponseAssertions, app.adminMiddleware...)
+	handle(mux, "/admin/header-limits", app.HandleHeaderLimits, app.adminMiddleware...)
+	handle(mux, "/admin/breakers", app.HandleBreakerList, app.adminMiddleware...)
+	handle(mux, "/admin/accesslog", app.HandleAccessLogTail, app.adminMiddleware...)
+	handle(mux, "/admin/samples", app.Audited(AuditActionSamplesClear, app.HandleSamples), app.adminMiddleware...)
+	handle(mux, "/admin/coalescing", app.HandleCoalescing, app.adminMiddleware...)
+	handle(mux, "/admin/signed-urls", app.Audited(AuditActionSignedURLIssue, app.HandleSignedURLs), app.adminMiddleware...)
+	handle(mux, "/admin/features", app.Audited(AuditActionFeatureToggle, app.HandleFeatures), app.adminMiddleware...)
+	handle(mux, "/admin/retries", app.Audited(AuditActionRetryChange, app.HandleRetryPolicy), app.adminMiddleware...)
+	handle(mux, "/admin/logging/routes", app.Audited(AuditActionLoggingChange, app.HandleRouteLogging), app.adminMiddleware...)
+	handle(mux, "/admin/breakers/reset", app.Audited(AuditActionBreakerReset, app.HandleBreakerReset), app.adminMiddleware...)
+	handle(mux, "/admin/routing/reset", app.Audited(AuditActionRoutingReset, app.HandleRoutingReset), app.adminMiddleware...)
+	handle(mux, "/admin/cache/purge", app.Audited(AuditActionCachePurge, app.HandleCachePurge), app.adminMiddleware...)
+	handle(mux, "/admin/filters", app.Audited(AuditActionFilterChange, app.HandleWasmFilters), app.adminMiddleware...)
+	handle(mux, "/admin/openapi", app.Audited(AuditActionRouteImport, app.HandleOpenAPIImports), app.adminMiddleware...)
+	handle(mux, "/admin/service-groups", app.Audited(AuditActionRouteSwitch, app.HandleServiceGroups), app.adminMiddleware...)
+	handle(mux, "/admin/bluegreen", app.Audited(AuditActionRouteSwitch, app.HandleBlueGreen), app.adminMiddleware...)
+	handle(mux, "/admin/bluegreen/cutover", app.Audited(AuditActionRouteSwitch, app.HandleBlueGreenCutover), app.adminMiddleware...)
+	handle(mux, "/admin/experiments", app.Audited(AuditActionRouteSwitch, app.HandleExperiments), app.adminMiddleware...)
+	handle(mux, "/admin/darklaunch", app.Audited(AuditActionConfigReload, app.HandleDarkLaunch), app.adminMiddleware...)
+	handle(mux, "/admin/darklaunch/promote", app.Audited(AuditActionConfigReload, app.HandleDarkLaunchPromote), app.adminMiddleware...)
+	handle(mux, "/admin/ramps", app.Audited(AuditActionRouteSwitch, app.HandleRamps), app.adminMiddleware...)
+	handle(mux, "/admin/ramps/action", app.Audited(AuditActionRouteSwitch, app.HandleRampAction), app.adminMiddleware...)
+	handle(mux, "/admin/schedule", app.Audited(AuditActionRouteSwitch, app.HandleSchedule), app.adminMiddleware...)
+	handle(mux, "/admin/schemas", app.Audited(AuditActionConfigReload, app.HandleSchemas), app.adminMiddleware...)
+	handle(mux, "/admin/ratelimit", app.Audited(AuditActionRateLimitChange, app.HandleRateLimit), app.adminMiddleware...)
+	handle(mux, "/admin/ratelimit/schedule", app.HandleRateLimitSchedule, app.adminMiddleware...)
+	handle(mux, "/admin/prefixes", app.Audited(AuditActionOwnershipChange, app.HandlePrefixOwners), app.adminMiddleware...)
+	handle(mux, "/admin/ratelimit/penalties", app.Audited(AuditActionRateLimitChange, app.HandlePenalties), app.adminMiddleware...)
 
 	return mux
 }
@@ -0,0 +1,114 @@
+package app
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// MaxRouteTemplates bounds the route labels metrics keep; routes past it
+// are counted under RouteLabelOther
+const MaxRouteTemplates = 1000
+
+// RouteLabelOther is the route label of observations whose route is not a
+// known route template, such as a raw request path
+const RouteLabelOther = "(other)"
+
+// RouteTemplates is the set of route templates metrics may be labelled
+// with: the prefixes the router resolved from registrations, the default
+// backend's "/" and gRPC translation paths. Per-route metrics label any
+// other route RouteLabelOther, so a caller passing a raw request path cannot
+// grow them without bound.
+type RouteTemplates struct {
+	mu        sync.RWMutex
+	templates map[string]bool
+	// rejected counts templates not added because the set was full
+	rejected atomic.Uint64
+	// overflowed counts observations labelled RouteLabelOther
+	overflowed atomic.Uint64
+}
+
+// NewRouteTemplates creates an empty set of route templates
+func NewRouteTemplates() *RouteTemplates {
+	return &RouteTemplates{templates: make(map[string]bool)}
+}
+
+// Add records a route template, unless the set already holds
+// MaxRouteTemplates
+func (rt *RouteTemplates) Add(template string) {
+	rt.mu.RLock()
+	exists := rt.templates[template]
+	rt.mu.RUnlock()
+	if exists {
+		return
+	}
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	if len(rt.templates) >= MaxRouteTemplates {
+		rt.rejected.Add(1)
+		return
+	}
+	rt.templates[template] = true
+}
+
+// Label returns the metric label of route: the route itself when it is a
+// known template, and RouteLabelOther otherwise. An empty route stays empty
+// for callers that label unrouted requests themselves.
+func (rt *RouteTemplates) Label(route string) string {
+	if route == "" {
+		return ""
+	}
+
+	rt.mu.RLock()
+	known := rt.templates[route]
+	rt.mu.RUnlock()
+	if known {
+		return route
+	}
+	rt.overflowed.Add(1)
+	return RouteLabelOther
+}
+
+// List returns the known templates in order
+func (rt *RouteTemplates) List() []string {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	templates := make([]string, 0, len(rt.templates))
+	for template := range rt.templates {
+		templates = append(templates, template)
+	}
+	sort.Strings(templates)
+	return templates
+}
+
+// addGRPCTemplates adds the path templates of the gRPC translation routes
+func (app *Application) addGRPCTemplates() {
+	if app.GRPC == nil {
+		return
+	}
+	for _, route := range app.GRPC.routes {
+		app.RouteTemplates.Add(route.path)
+	}
+}
+
+// HandleRouteTemplates serves GET /admin/metrics/routes with the route
+// templates metrics are labelled with and how many observations fell into
+// the overflow label
+func (app *Application) HandleRouteTemplates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"templates":     app.RouteTemplates.List(),
+		"max_templates": MaxRouteTemplates,
+		"overflow":      RouteLabelOther,
+		"overflowed":    app.RouteTemplates.overflowed.Load(),
+		"rejected":      app.RouteTemplates.rejected.Load(),
+	})
+}
@@ -1,9 +1,12 @@
 package app
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/codytheroux96/go-reverse-proxy/internal/registry"
 )
@@ -13,6 +16,7 @@ type ResilientRouter struct {
 	app             *Application
 	roundRobinIndex map[string]int // per-prefix round-robin counter
 	mu              sync.Mutex     // protects roundRobinIndex
+	defaultBackend  *registry.Server
 }
 
 // NewResilientRouter creates a new resilient router
@@ -23,6 +27,45 @@ func NewResilientRouter(app *Application) *ResilientRouter {
 	}
 }
 
+// DefaultBackendName is the server name used for the catch-all backend
+const DefaultBackendName = "default"
+
+// SetDefaultBackend configures a catch-all backend for requests that match no
+// registered route; an empty URL disables it
+func (rr *ResilientRouter) SetDefaultBackend(baseURL string) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	if baseURL == "" {
+		rr.defaultBackend = nil
+		return
+	}
+
+	rr.defaultBackend = &registry.Server{
+		Name:     DefaultBackendName,
+		BaseURL:  strings.TrimSuffix(baseURL, "/"),
+		Prefixes: []string{"/"},
+	}
+}
+
+// Routing failures returned by ResolveBackend
+var (
+	ErrNoRoute           = errors.New("no route matches the request")
+	ErrNoHealthyBackends = errors.New("no healthy backend is available")
+	ErrBreakerOpen       = errors.New("the circuit breakers of every healthy backend are open")
+)
+
+// BreakerOpenError is returned by ResolveBackend when the route's healthy
+// servers are all held back by their circuit breakers. It matches
+// ErrBreakerOpen with errors.Is.
+type BreakerOpenError struct {
+	// RetryAfter is the shortest remaining cooldown among those breakers
+	RetryAfter time.Duration
+}
+
+func (e *BreakerOpenError) Error() string { return ErrBreakerOpen.Error() }
+func (e *BreakerOpenError) Unwrap() error { return ErrBreakerOpen }
+
 // BackendInfo represents information about a selected backend
 type BackendInfo struct {
 	Server    registry.Server
@@ -30,56 +73,118 @@ type BackendInfo struct {
 	Prefix    string
 }
 
-// ResolveBackend finds a healthy backend for the given request path
-func (rr *ResilientRouter) ResolveBackend(requestPath string) (*BackendInfo, error) {
-	// 1) Find longest prefix match and candidate servers
-	prefix, candidates, found := rr.app.Registry.ServersForPath(requestPath)
+// ResolveBackend finds a healthy backend for the given request path; ctx
+// carries the request ID into the routing log lines
+func (rr *ResilientRouter) ResolveBackend(ctx context.Context, requestPath string) (*BackendInfo, error) {
+	// 1) Find longest prefix match and candidate servers, unless the path
+	// missed moments ago
+	var prefix string
+	var candidates []registry.Server
+	found := false
+	missed := rr.app.RouteMisses != nil && rr.app.RouteMisses.Missed(requestPath)
+	if !missed {
+		prefix, candidates, found = rr.app.Registry.ServersForPath(requestPath)
+	}
 	if prefix == "" || !found || len(candidates) == 0 {
-		rr.app.Logger.Debug("no route found", "path", requestPath)
-		return nil, fmt.Errorf("no_route")
+		if rr.app.RouteMisses != nil && !missed {
+			rr.app.RouteMisses.Remember(requestPath)
+		}
+		if fallback := rr.resolveDefault(ctx, requestPath); fallback != nil {
+			return fallback, nil
+		}
+		rr.app.Logger.DebugContext(ctx, "no route found", "path", requestPath)
+		return nil, ErrNoRoute
+	}
+	rr.app.RouteTemplates.Add(prefix)
+
+	// Blue/green routes only send traffic to the selected groups, trying
+	// each in turn until one has a healthy server
+	groups := [][]registry.Server{candidates}
+	if selected, ok := rr.app.BlueGreen.selectGroups(prefix); ok {
+		groups = groups[:0]
+		for _, members := range selected {
+			var grouped []registry.Server
+			for _, server := range candidates {
+				if members[server.Name] || members[server.Service] {
+					grouped = append(grouped, server)
+				}
+			}
+			groups = append(groups, grouped)
+		}
+	}
+
+	// Requests assigned to an experiment variant only go to its servers
+	if assignment := experimentFromContext(ctx); assignment != nil && strings.HasPrefix(requestPath, assignment.prefix) {
+		var grouped []registry.Server
+		for _, server := range candidates {
+			if assignment.servers[server.Name] || assignment.servers[server.Service] {
+				grouped = append(grouped, server)
+			}
+		}
+		groups = [][]registry.Server{grouped}
 	}
 
 	// 2) Filter for healthy servers that pass circuit breaker check
 	var healthyServers []registry.Server
-	for _, server := range candidates {
-		isHealthy := rr.app.HealthMonitor.IsHealthy(server.Name)
-		allowedByBreaker := rr.app.CircuitBreaker.AllowRequest(server.Name)
-
-		if isHealthy && allowedByBreaker {
-			healthyServers = append(healthyServers, server)
-			rr.app.Logger.Debug("server eligible",
-				"server", server.Name,
-				"healthy", isHealthy,
-				"breaker_allowed", allowedByBreaker)
-		} else {
-			rr.app.Logger.Debug("server filtered out",
-				"server", server.Name,
-				"healthy", isHealthy,
-				"breaker_allowed", allowedByBreaker)
+	var breakerOpen *BreakerOpenError
+	for _, group := range groups {
+		var open *BreakerOpenError
+		if healthyServers, open = rr.healthyServers(ctx, group); len(healthyServers) > 0 {
+			break
+		}
+		if open != nil && (breakerOpen == nil || open.RetryAfter < breakerOpen.RetryAfter) {
+			breakerOpen = open
 		}
 	}
 
 	if len(healthyServers) == 0 {
-		rr.app.Logger.Warn("no healthy backends available",
+		rr.app.Logger.WarnContext(ctx, "no healthy backends available",
 			"path", requestPath,
 			"prefix", prefix,
-			"total_candidates", len(candidates))
-		return nil, fmt.Errorf("no_healthy_backends")
+			"total_candidates", len(candidates),
+			"breaker_open", breakerOpen != nil)
+		if breakerOpen != nil {
+			return nil, breakerOpen
+		}
+		return nil, ErrNoHealthyBackends
 	}
 
-	// 3) Round-robin selection within healthy servers for this prefix
-	rr.mu.Lock()
-	index := rr.roundRobinIndex[prefix] % len(healthyServers)
-	rr.roundRobinIndex[prefix]++
-	rr.mu.Unlock()
+	// 3) Split traffic between the logical services on the route, then keep
+	// it in the proxy's zone while the service has enough healthy instances
+	pool := rr.app.ServiceGroups.pick(healthyServers)
+	if rr.app.Zones != nil {
+		pool = rr.app.Zones.prefer(pool)
+	}
 
-	chosen := healthyServers[index]
+	// 4) Weighted or round-robin selection within the pool for this prefix;
+	// without health weights, degraded servers still get a reduced share
+	var chosen registry.Server
+	if rr.app.HealthWeights != nil {
+		chosen = rr.app.HealthWeights.pick(pool, rr.app.HealthMonitor)
+	} else if server, ok := rr.app.HealthMonitor.pickByLevel(pool); ok {
+		chosen = server
+	} else {
+		// Registries list servers in no particular order, so round-robin
+		// walks them by name to visit each in turn
+		sort.Slice(pool, func(i, j int) bool { return pool[i].Name < pool[j].Name })
+		rr.mu.Lock()
+		index := rr.roundRobinIndex[prefix] % len(pool)
+		rr.roundRobinIndex[prefix]++
+		rr.mu.Unlock()
+		chosen = pool[index]
+	}
+	if rr.app.Zones != nil && rr.app.Zones.record(prefix, chosen) {
+		rr.app.Logger.DebugContext(ctx, "request spilled to another zone",
+			"prefix", prefix,
+			"server", chosen.Name,
+			"zone", chosen.Zone)
+	}
 
-	// 4) Construct target URL
+	// 5) Construct target URL
 	trimmedPath := strings.TrimPrefix(requestPath, prefix)
 	targetURL := chosen.BaseURL + trimmedPath
 
-	rr.app.Logger.Info("backend selected",
+	rr.app.Logger.InfoContext(ctx, "backend selected",
 		"path", requestPath,
 		"prefix", prefix,
 		"server", chosen.Name,
@@ -93,3 +198,68 @@ func (rr *ResilientRouter) ResolveBackend(requestPath string) (*BackendInfo, err
 		Prefix:    prefix,
 	}, nil
 }
+
+// healthyServers returns the servers that are healthy and allowed by their
+// circuit breakers and, when any healthy server was held back by its breaker,
+// a BreakerOpenError with the shortest cooldown
+func (rr *ResilientRouter) healthyServers(ctx context.Context, candidates []registry.Server) ([]registry.Server, *BreakerOpenError) {
+	var healthyServers []registry.Server
+	var breakerOpen *BreakerOpenError
+	for _, server := range candidates {
+		// Draining servers finish their requests but are not selected
+		if _, draining := rr.app.Drain.Draining(server.Name); draining {
+			rr.app.Logger.DebugContext(ctx, "server filtered out", "server", server.Name, "draining", true)
+			continue
+		}
+
+		isHealthy := rr.app.HealthMonitor.IsHealthy(server.Name)
+		allowedByBreaker := rr.app.CircuitBreaker.AllowRequest(server.Name)
+		if allowedByBreaker {
+			rr.app.Counters.BreakerAllowed.Add(1)
+		} else {
+			rr.app.Counters.BreakerRejected.Add(1)
+		}
+
+		if isHealthy && allowedByBreaker {
+			healthyServers = append(healthyServers, server)
+			rr.app.Logger.DebugContext(ctx, "server eligible",
+				"server", server.Name,
+				"healthy", isHealthy,
+				"breaker_allowed", allowedByBreaker)
+		} else {
+			if isHealthy {
+				cooldown := rr.app.CircuitBreaker.Cooldown(server.Name)
+				if breakerOpen == nil || cooldown < breakerOpen.RetryAfter {
+					breakerOpen = &BreakerOpenError{RetryAfter: cooldown}
+				}
+			}
+			rr.app.Logger.DebugContext(ctx, "server filtered out",
+				"server", server.Name,
+				"healthy", isHealthy,
+				"breaker_allowed", allowedByBreaker)
+		}
+	}
+	return healthyServers, breakerOpen
+}
+
+// resolveDefault routes a request with no registered route to the default
+// backend, bypassing health checks since it is not registered, but still
+// respecting its circuit breaker
+func (rr *ResilientRouter) resolveDefault(ctx context.Context, requestPath string) *BackendInfo {
+	rr.mu.Lock()
+	fallback := rr.defaultBackend
+	rr.mu.Unlock()
+
+	if fallback == nil || !rr.app.CircuitBreaker.AllowRequest(fallback.Name) {
+		return nil
+	}
+
+	rr.app.Logger.DebugContext(ctx, "routing to default backend", "path", requestPath, "target", fallback.BaseURL)
+	rr.app.RouteTemplates.Add("/")
+
+	return &BackendInfo{
+		Server:    *fallback,
+		TargetURL: fallback.BaseURL + requestPath,
+		Prefix:    "/",
+	}
+}
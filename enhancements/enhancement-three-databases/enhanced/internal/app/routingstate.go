@@ -0,0 +1,186 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+// Balancing modes reported by the routing state
+const (
+	BalanceRoundRobin     = "round_robin"
+	BalanceHealthWeighted = "health_weighted"
+)
+
+// RouteBalance is a route's round-robin position
+type RouteBalance struct {
+	Prefix string `json:"prefix"`
+	// Counter is how many requests round-robin has placed on the route
+	// since it was last reset
+	Counter int `json:"counter"`
+	// Eligible lists the route's servers that are healthy, not draining and
+	// not held back by an open breaker, in the name order round-robin
+	// walks them in
+	Eligible []string `json:"eligible"`
+	// Next is the server round-robin picks next if Eligible stays the same
+	// and no service group, zone or degraded server changes the pick; it is
+	// left out under health-weighted balancing
+	Next string `json:"next,omitempty"`
+}
+
+// RoutingState is the router's load balancing state
+type RoutingState struct {
+	Mode   string         `json:"mode"`
+	Routes []RouteBalance `json:"routes"`
+	// ErrorRates is each server's recent error rate under health-weighted
+	// balancing
+	ErrorRates map[string]float64 `json:"error_rates,omitempty"`
+}
+
+// ResetRoundRobin restarts a route's round-robin counter, or every route's
+// for an empty prefix
+func (rr *ResilientRouter) ResetRoundRobin(prefix string) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	if prefix == "" {
+		rr.roundRobinIndex = make(map[string]int)
+		return
+	}
+	delete(rr.roundRobinIndex, prefix)
+}
+
+// ErrorRates returns each server's recent error rate
+func (hw *HealthWeights) ErrorRates() map[string]float64 {
+	hw.mu.Lock()
+	defer hw.mu.Unlock()
+
+	rates := make(map[string]float64, len(hw.errorRates))
+	for name, rate := range hw.errorRates {
+		rates[name] = rate
+	}
+	return rates
+}
+
+// ResetErrorRates forgets a server's error rate, or every server's for an
+// empty name, so it is weighted on latency alone until requests rebuild it
+func (hw *HealthWeights) ResetErrorRates(serverName string) {
+	hw.mu.Lock()
+	defer hw.mu.Unlock()
+
+	if serverName == "" {
+		hw.errorRates = make(map[string]float64)
+		return
+	}
+	delete(hw.errorRates, serverName)
+}
+
+// RoutingState returns the round-robin position of every registered route
+// and, under health-weighted balancing, each server's error rate. Reading it
+// has no effect on routing.
+func (app *Application) RoutingState() (RoutingState, error) {
+	servers, err := app.Registry.GetServers()
+	if err != nil {
+		return RoutingState{}, err
+	}
+	sort.Slice(servers, func(i, j int) bool { return servers[i].Name < servers[j].Name })
+
+	eligible := make(map[string][]string)
+	for _, server := range servers {
+		_, draining := app.Drain.Draining(server.Name)
+		ok := !draining && app.HealthMonitor.IsHealthy(server.Name) &&
+			app.CircuitBreaker.GetBreakerState(server.Name) != Open
+		for _, prefix := range server.Prefixes {
+			if _, seen := eligible[prefix]; !seen {
+				eligible[prefix] = []string{}
+			}
+			if ok {
+				eligible[prefix] = append(eligible[prefix], server.Name)
+			}
+		}
+	}
+
+	app.Router.mu.Lock()
+	counters := make(map[string]int, len(app.Router.roundRobinIndex))
+	for prefix, counter := range app.Router.roundRobinIndex {
+		counters[prefix] = counter
+	}
+	app.Router.mu.Unlock()
+
+	state := RoutingState{Mode: BalanceRoundRobin, Routes: []RouteBalance{}}
+	for prefix, names := range eligible {
+		route := RouteBalance{Prefix: prefix, Counter: counters[prefix], Eligible: names}
+		if len(names) > 0 && app.HealthWeights == nil {
+			route.Next = names[route.Counter%len(names)]
+		}
+		state.Routes = append(state.Routes, route)
+	}
+	sort.Slice(state.Routes, func(i, j int) bool { return state.Routes[i].Prefix < state.Routes[j].Prefix })
+
+	if app.HealthWeights != nil {
+		state.Mode = BalanceHealthWeighted
+		state.ErrorRates = app.HealthWeights.ErrorRates()
+	}
+	return state, nil
+}
+
+// HandleRoutingState serves GET /admin/routing with the router's load
+// balancing state
+func (app *Application) HandleRoutingState(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	state, err := app.RoutingState()
+	if err != nil {
+		http.Error(w, "failed to list servers", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, state)
+}
+
+// HandleRoutingReset serves POST /admin/routing/reset. It restarts the
+// round-robin counter of prefix, or of every route when prefix is empty, and
+// with error_rates also forgets the health-weighted error rate of server, or
+// of every server when server is empty.
+func (app *Application) HandleRoutingReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Prefix     string `json:"prefix"`
+		ErrorRates bool   `json:"error_rates"`
+		Server     string `json:"server"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid payload in request", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Server != "" && !req.ErrorRates {
+		http.Error(w, "server only applies with error_rates", http.StatusBadRequest)
+		return
+	}
+	if req.ErrorRates && app.HealthWeights == nil {
+		http.Error(w, "health-weighted balancing is not enabled", http.StatusConflict)
+		return
+	}
+
+	app.Router.ResetRoundRobin(req.Prefix)
+	if req.ErrorRates {
+		app.HealthWeights.ResetErrorRates(req.Server)
+	}
+	app.Logger.InfoContext(r.Context(), "routing state reset",
+		"prefix", req.Prefix, "error_rates", req.ErrorRates, "server", req.Server)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":      "reset",
+		"prefix":      req.Prefix,
+		"error_rates": req.ErrorRates,
+		"server":      req.Server,
+	})
+}
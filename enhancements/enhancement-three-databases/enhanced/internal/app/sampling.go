@@ -0,0 +1,425 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultSampleCapacity is how many samples are kept when the sampling
+// config sets no capacity
+const DefaultSampleCapacity = 200
+
+// sampleRedacted replaces the values of secret headers in samples
+const sampleRedacted = "[redacted]"
+
+// sampleSecretHeaders are headers whose values never appear in samples
+var sampleSecretHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+
+// SamplingConfig selects requests to record in full for diagnostics
+type SamplingConfig struct {
+	// Rate is the fraction of requests sampled at random, e.g. 0.001 for
+	// 0.1%; 0 samples only requests matching a filter
+	Rate float64 `json:"rate"`
+	// Filters sample every request matching any of them
+	Filters []SampleFilter `json:"filters,omitempty"`
+	// Capacity is how many samples are kept, oldest dropped first; 0 uses
+	// DefaultSampleCapacity
+	Capacity int `json:"capacity"`
+	// MaxBodyBytes keeps up to this many bytes of each request and response
+	// body; 0 records no bodies
+	MaxBodyBytes int `json:"max_body_bytes"`
+}
+
+// SampleFilter matches requests by their conditions, all of which must hold;
+// an empty condition matches anything
+type SampleFilter struct {
+	Prefix string `json:"prefix,omitempty"`
+	Method string `json:"method,omitempty"`
+	// Header is a header name the request must carry, or name:value for a
+	// header it must carry with that value
+	Header string `json:"header,omitempty"`
+	// MinStatus matches responses with at least this status
+	MinStatus int `json:"min_status,omitempty"`
+}
+
+// ParseSampleFilters parses a comma separated list of filters, each a list
+// of key=value conditions joined by &, for example
+// "prefix=/api/orders&method=POST,min_status=500,header=X-Debug:1"
+func ParseSampleFilters(spec string) ([]SampleFilter, error) {
+	var filters []SampleFilter
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		var f SampleFilter
+		for _, cond := range strings.Split(part, "&") {
+			key, value, ok := strings.Cut(cond, "=")
+			if !ok || value == "" {
+				return nil, fmt.Errorf("invalid sample filter condition %q, expected key=value", cond)
+			}
+			switch key {
+			case "prefix":
+				f.Prefix = value
+			case "method":
+				f.Method = strings.ToUpper(value)
+			case "header":
+				f.Header = value
+			case "min_status":
+				status, err := strconv.Atoi(value)
+				if err != nil || status < 100 || status > 599 {
+					return nil, fmt.Errorf("invalid min_status %q in sample filter", value)
+				}
+				f.MinStatus = status
+			default:
+				return nil, fmt.Errorf("unknown sample filter condition %q", key)
+			}
+		}
+		filters = append(filters, f)
+	}
+
+	return filters, nil
+}
+
+// matchesRequest reports whether r meets the filter's request conditions
+func (f SampleFilter) matchesRequest(r *http.Request) bool {
+	if f.Prefix != "" && !strings.HasPrefix(r.URL.Path, f.Prefix) {
+		return false
+	}
+	if f.Method != "" && r.Method != f.Method {
+		return false
+	}
+	if f.Header != "" {
+		name, value, hasValue := strings.Cut(f.Header, ":")
+		got, present := r.Header[http.CanonicalHeaderKey(name)]
+		if !present || (hasValue && (len(got) == 0 || got[0] != value)) {
+			return false
+		}
+	}
+	return true
+}
+
+// SampleDecisions are the routing and caching decisions made for a request
+type SampleDecisions struct {
+	Route      string `json:"route,omitempty"`
+	Backend    string `json:"backend,omitempty"`
+	Cache      string `json:"cache,omitempty"`
+	Experiment string `json:"experiment,omitempty"`
+}
+
+// RequestSample is the full detail of one sampled request
+type RequestSample struct {
+	Seq       int64     `json:"seq"`
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id,omitempty"`
+	// Reason is "rate" for a random sample, or the index of the filter that
+	// matched
+	Reason         string      `json:"reason"`
+	Method         string      `json:"method"`
+	Path           string      `json:"path"`
+	Query          string      `json:"query,omitempty"`
+	Proto          string      `json:"proto"`
+	RemoteAddr     string      `json:"remote_addr"`
+	RequestHeaders http.Header `json:"request_headers"`
+	RequestBody    string      `json:"request_body,omitempty"`
+	// RequestBodyTruncated is set when more of the body was read than kept
+	RequestBodyTruncated  bool            `json:"request_body_truncated,omitempty"`
+	Status                int             `json:"status"`
+	ResponseHeaders       http.Header     `json:"response_headers"`
+	ResponseBody          string          `json:"response_body,omitempty"`
+	ResponseBodyTruncated bool            `json:"response_body_truncated,omitempty"`
+	Decisions             SampleDecisions `json:"decisions"`
+	// HeadersAfter is how long the proxy took to send the response headers
+	HeadersAfter time.Duration   `json:"headers_after"`
+	Duration     time.Duration   `json:"duration"`
+	Upstream     *UpstreamTiming `json:"upstream,omitempty"`
+}
+
+// RequestSampler records the full detail of a sample of requests into a
+// bounded ring buffer, for diagnosing problems the access log is too terse
+// for
+type RequestSampler struct {
+	cfg SamplingConfig
+
+	mu      sync.RWMutex
+	samples []RequestSample
+	nextSeq int64
+
+	sampled atomic.Int64
+}
+
+// NewRequestSampler validates cfg and creates a sampler
+func NewRequestSampler(cfg SamplingConfig) (*RequestSampler, error) {
+	if cfg.Rate < 0 || cfg.Rate > 1 {
+		return nil, fmt.Errorf("sample rate must be between 0 and 1")
+	}
+	if cfg.Capacity < 0 || cfg.MaxBodyBytes < 0 {
+		return nil, fmt.Errorf("sample capacity and body size must not be negative")
+	}
+	if cfg.Rate == 0 && len(cfg.Filters) == 0 {
+		return nil, fmt.Errorf("sampling needs a rate or at least one filter")
+	}
+	if cfg.Capacity == 0 {
+		cfg.Capacity = DefaultSampleCapacity
+	}
+	return &RequestSampler{cfg: cfg, samples: make([]RequestSample, 0, cfg.Capacity)}, nil
+}
+
+// add appends a sample, dropping the oldest when the buffer is full
+func (s *RequestSampler) add(sample RequestSample) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextSeq++
+	sample.Seq = s.nextSeq
+	if len(s.samples) >= s.cfg.Capacity {
+		s.samples = s.samples[1:]
+	}
+	s.samples = append(s.samples, sample)
+	s.sampled.Add(1)
+}
+
+// After returns up to limit samples with a sequence number greater than seq
+func (s *RequestSampler) After(seq int64, limit int) []RequestSample {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := []RequestSample{}
+	for _, sample := range s.samples {
+		if sample.Seq > seq {
+			result = append(result, sample)
+		}
+	}
+	if len(result) > limit {
+		result = result[len(result)-limit:]
+	}
+	return result
+}
+
+// Clear drops every kept sample
+func (s *RequestSampler) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.samples = s.samples[:0]
+}
+
+// SetSampling records requests selected by cfg for GET /admin/samples
+func (app *Application) SetSampling(cfg SamplingConfig) error {
+	sampler, err := NewRequestSampler(cfg)
+	if err != nil {
+		return err
+	}
+	app.Sampler = sampler
+	return nil
+}
+
+// redactHeaders copies h with the values of secret headers replaced
+func redactHeaders(h http.Header) http.Header {
+	clone := h.Clone()
+	if clone == nil {
+		clone = http.Header{}
+	}
+	for _, name := range sampleSecretHeaders {
+		if values, ok := clone[name]; ok {
+			for i := range values {
+				values[i] = sampleRedacted
+			}
+		}
+	}
+	return clone
+}
+
+// sampleBuffer keeps the first max bytes written to it and notes whether
+// more were written
+type sampleBuffer struct {
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *sampleBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if room := b.max - b.buf.Len(); room < len(p) {
+		b.truncated = true
+		b.buf.Write(p[:max(room, 0)])
+	} else {
+		b.buf.Write(p)
+	}
+	return len(p), nil
+}
+
+// contents returns the kept bytes and whether any were left out
+func (b *sampleBuffer) contents() (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String(), b.truncated
+}
+
+// sampledBody copies what the handler reads of a request body into a buffer
+type sampledBody struct {
+	io.ReadCloser
+	buf *sampleBuffer
+}
+
+func (b *sampledBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.buf.Write(p[:n])
+	return n, err
+}
+
+// sampleReason returns why a request is sampled, or "" if it is not. The
+// status conditions of filters are only known once the request completes,
+// so candidates lists the filters whose request conditions matched.
+func (s *RequestSampler) sampleReason(random bool, candidates []int, status int) string {
+	if random {
+		return "rate"
+	}
+	for _, i := range candidates {
+		if status >= s.cfg.Filters[i].MinStatus {
+			return "filter:" + strconv.Itoa(i)
+		}
+	}
+	return ""
+}
+
+// SampleRequests records the full detail of requests selected by the
+// sampling config. Admin requests are never sampled.
+func (app *Application) SampleRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := app.Sampler
+		if s == nil || strings.HasPrefix(r.URL.Path, "/admin/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		random := s.cfg.Rate > 0 && rand.Float64() < s.cfg.Rate
+		var candidates []int
+		for i, f := range s.cfg.Filters {
+			if f.matchesRequest(r) {
+				candidates = append(candidates, i)
+			}
+		}
+		if !random && len(candidates) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		// Headers are copied before later middleware normalizes, strips or
+		// enriches them, so the sample shows what the client sent
+		reqHeaders := redactHeaders(r.Header)
+		method, path, query := r.Method, r.URL.Path, r.URL.RawQuery
+
+		rec := &responseRecorder{ResponseWriter: w}
+		var reqBody, respBody *sampleBuffer
+		if s.cfg.MaxBodyBytes > 0 {
+			respBody = &sampleBuffer{max: s.cfg.MaxBodyBytes}
+			rec.tee = respBody
+			if r.Body != nil && r.Body != http.NoBody {
+				reqBody = &sampleBuffer{max: s.cfg.MaxBodyBytes}
+				r.Body = &sampledBody{ReadCloser: r.Body, buf: reqBody}
+			}
+		}
+
+		next.ServeHTTP(rec, r)
+
+		rec.finish()
+		reason := s.sampleReason(random, candidates, rec.status)
+		if reason == "" {
+			return
+		}
+
+		sample := RequestSample{
+			Time:            start,
+			RequestID:       RequestIDFromContext(r.Context()),
+			Reason:          reason,
+			Method:          method,
+			Path:            path,
+			Query:           query,
+			Proto:           r.Proto,
+			RemoteAddr:      r.RemoteAddr,
+			RequestHeaders:  reqHeaders,
+			Status:          rec.status,
+			ResponseHeaders: redactHeaders(rec.Header()),
+			HeadersAfter:    rec.headersAt.Sub(start),
+			Duration:        time.Since(start),
+		}
+		sample.Decisions.Experiment = rec.Header().Get(ExperimentHeader)
+		if record := requestRecordFrom(r.Context()); record != nil {
+			record.mu.Lock()
+			sample.Decisions.Route, sample.Decisions.Backend = record.route, record.backend
+			sample.Decisions.Cache = record.cache
+			sample.Upstream = record.upstream
+			record.mu.Unlock()
+		}
+		if reqBody != nil {
+			sample.RequestBody, sample.RequestBodyTruncated = reqBody.contents()
+		}
+		if respBody != nil {
+			sample.ResponseBody, sample.ResponseBodyTruncated = respBody.contents()
+		}
+		s.add(sample)
+	})
+}
+
+// HandleSamples serves GET /admin/samples?after=<seq>&limit=<n> with the
+// most recent request samples, and DELETE to clear them
+func (app *Application) HandleSamples(w http.ResponseWriter, r *http.Request) {
+	s := app.Sampler
+	if s == nil {
+		if r.Method != http.MethodGet {
+			http.Error(w, "request sampling is not enabled", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": false})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		var after int64
+		if v := r.URL.Query().Get("after"); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				http.Error(w, "after must be a non-negative integer", http.StatusBadRequest)
+				return
+			}
+			after = n
+		}
+
+		limit := 20
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"enabled": true,
+			"config":  s.cfg,
+			"sampled": s.sampled.Load(),
+			"samples": s.After(after, limit),
+		})
+	case http.MethodDelete:
+		s.Clear()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
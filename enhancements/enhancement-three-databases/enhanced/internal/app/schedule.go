@@ -0,0 +1,385 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RouteScheduleInterval is how often scheduled routing changes are checked
+const RouteScheduleInterval = time.Second
+
+// RouteScheduleHistory is how many finished changes are kept for listing
+const RouteScheduleHistory = 100
+
+// Kinds of scheduled routing change
+const (
+	// ScheduleCutover switches a blue/green route's active group
+	ScheduleCutover = "cutover"
+	// ScheduleCanary sets a blue/green route's canary group and weight
+	ScheduleCanary = "canary"
+	// ScheduleWeight sets a logical service's traffic weight
+	ScheduleWeight = "weight"
+)
+
+// Scheduled change states
+const (
+	SchedulePending = "pending"
+	// ScheduleActive is an applied change waiting to be reverted at Until
+	ScheduleActive    = "active"
+	ScheduleApplied   = "applied"
+	ScheduleReverted  = "reverted"
+	ScheduleFailed    = "failed"
+	ScheduleCancelled = "cancelled"
+)
+
+// ScheduledChange is a routing change applied automatically at At and, when
+// Until is set, reverted automatically then
+type ScheduledChange struct {
+	ID   string `json:"id"`
+	Kind string `json:"kind"`
+	// Prefix and To name the blue/green route and group for a cutover;
+	// without To the route switches to its other group. Force skips the
+	// health check of the group switched to.
+	Prefix string `json:"prefix,omitempty"`
+	To     string `json:"to,omitempty"`
+	Force  bool   `json:"force,omitempty"`
+	// Canary is the group given Weight percent of Prefix's traffic; empty
+	// stops the canary
+	Canary string `json:"canary,omitempty"`
+	// Service is the logical service given Weight
+	Service string `json:"service,omitempty"`
+	Weight  int    `json:"weight,omitempty"`
+
+	At    time.Time  `json:"at"`
+	Until *time.Time `json:"until,omitempty"`
+
+	Status     string     `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	AppliedAt  *time.Time `json:"applied_at,omitempty"`
+	RevertedAt *time.Time `json:"reverted_at,omitempty"`
+
+	// previous is what an applied change replaced, restored at Until
+	previous struct {
+		active string
+		canary string
+		weight int
+	}
+}
+
+// due returns when the change next needs acting on, and whether it does
+func (c *ScheduledChange) due() (time.Time, bool) {
+	switch c.Status {
+	case SchedulePending:
+		return c.At, true
+	case ScheduleActive:
+		return *c.Until, true
+	}
+	return time.Time{}, false
+}
+
+// RouteSchedule holds routing changes scheduled for later
+type RouteSchedule struct {
+	mu      sync.Mutex
+	changes []*ScheduledChange
+}
+
+// NewRouteSchedule creates a schedule with no changes
+func NewRouteSchedule() *RouteSchedule {
+	return &RouteSchedule{}
+}
+
+// validateScheduledChange checks a change against the current routing
+// config
+func (app *Application) validateScheduledChange(c *ScheduledChange) error {
+	if c.At.IsZero() {
+		return fmt.Errorf("at is required")
+	}
+	if c.Until != nil && !c.Until.After(c.At) {
+		return fmt.Errorf("until must be after at")
+	}
+
+	switch c.Kind {
+	case ScheduleCutover, ScheduleCanary:
+		route, exists := app.BlueGreen.Get(c.Prefix)
+		if !exists {
+			return fmt.Errorf("blue/green route %s not found", c.Prefix)
+		}
+		group := c.To
+		if c.Kind == ScheduleCanary {
+			group = c.Canary
+			if c.Weight < 0 || c.Weight > 100 {
+				return fmt.Errorf("canary weight must be between 0 and 100")
+			}
+		}
+		if _, defined := route.Groups[group]; group != "" && !defined {
+			return fmt.Errorf("group %q is not defined", group)
+		}
+	case ScheduleWeight:
+		if c.Service == "" {
+			return fmt.Errorf("service is required")
+		}
+		if c.Weight < 0 {
+			return fmt.Errorf("weight must not be negative")
+		}
+	default:
+		return fmt.Errorf("kind must be %s, %s or %s", ScheduleCutover, ScheduleCanary, ScheduleWeight)
+	}
+	return nil
+}
+
+// Add schedules a change, returning it with its ID and status set
+func (rs *RouteSchedule) Add(change ScheduledChange) ScheduledChange {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	change.ID = newRequestID()[:12]
+	change.Status = SchedulePending
+	rs.changes = append(rs.changes, &change)
+	return change
+}
+
+// Cancel stops a pending change from being applied, or an active one from
+// being reverted, leaving it in place
+func (rs *RouteSchedule) Cancel(id string) (ScheduledChange, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	for _, c := range rs.changes {
+		if c.ID != id {
+			continue
+		}
+		switch c.Status {
+		case SchedulePending:
+			c.Status = ScheduleCancelled
+		case ScheduleActive:
+			c.Status, c.Until = ScheduleApplied, nil
+		default:
+			return *c, fmt.Errorf("change %s is already %s", id, c.Status)
+		}
+		rs.prune()
+		return *c, nil
+	}
+	return ScheduledChange{}, errScheduledChangeNotFound
+}
+
+// errScheduledChangeNotFound reports a cancelled change ID that is unknown
+var errScheduledChangeNotFound = errors.New("scheduled change not found")
+
+// List returns the upcoming changes, soonest first, followed by finished
+// ones when history is set
+func (rs *RouteSchedule) List(history bool) []ScheduledChange {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	var upcoming, finished []ScheduledChange
+	for _, c := range rs.changes {
+		if _, due := c.due(); due {
+			upcoming = append(upcoming, *c)
+		} else if history {
+			finished = append(finished, *c)
+		}
+	}
+	sort.SliceStable(upcoming, func(i, j int) bool {
+		a, _ := upcoming[i].due()
+		b, _ := upcoming[j].due()
+		return a.Before(b)
+	})
+	return append(upcoming, finished...)
+}
+
+// prune drops the oldest finished changes beyond RouteScheduleHistory;
+// rs.mu must be held
+func (rs *RouteSchedule) prune() {
+	finished := 0
+	for _, c := range rs.changes {
+		if _, due := c.due(); !due {
+			finished++
+		}
+	}
+	kept := rs.changes[:0]
+	for _, c := range rs.changes {
+		if _, due := c.due(); !due && finished > RouteScheduleHistory {
+			finished--
+			continue
+		}
+		kept = append(kept, c)
+	}
+	rs.changes = kept
+}
+
+// Run applies and reverts changes as they fall due until the application
+// shuts down
+func (rs *RouteSchedule) Run(app *Application, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			rs.runDue(app, app.clock.Now())
+		case <-app.ctx.Done():
+			return
+		}
+	}
+}
+
+// runDue applies or reverts every change due at now. Changes are applied
+// one at a time in the order they fell due, with the schedule locked, so a
+// change cancelled concurrently is never applied.
+func (rs *RouteSchedule) runDue(app *Application, now time.Time) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	var due []*ScheduledChange
+	for _, c := range rs.changes {
+		if at, ok := c.due(); ok && !at.After(now) {
+			due = append(due, c)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		a, _ := due[i].due()
+		b, _ := due[j].due()
+		return a.Before(b)
+	})
+
+	for _, c := range due {
+		if c.Status == SchedulePending {
+			app.applyScheduledChange(app.ctx, c, now)
+		} else {
+			app.revertScheduledChange(c, now)
+		}
+	}
+	if len(due) > 0 {
+		rs.prune()
+	}
+}
+
+// applyScheduledChange makes a pending change, recording what it replaced
+func (app *Application) applyScheduledChange(ctx context.Context, c *ScheduledChange, now time.Time) {
+	err := app.validateScheduledChange(c)
+	if err == nil {
+		switch c.Kind {
+		case ScheduleCutover:
+			err = app.scheduledCutover(ctx, c)
+		case ScheduleCanary:
+			route, _ := app.BlueGreen.Get(c.Prefix)
+			c.previous.canary, c.previous.weight = route.Canary, route.CanaryWeight
+			err = app.BlueGreen.setCanary(c.Prefix, c.Canary, c.Weight)
+		case ScheduleWeight:
+			c.previous.weight = app.ServiceGroups.Weight(c.Service)
+			err = app.ServiceGroups.SetWeight(c.Service, c.Weight)
+		}
+	}
+	if err != nil {
+		c.Status, c.Error = ScheduleFailed, err.Error()
+		app.Logger.Error("scheduled routing change failed", "id", c.ID, "kind", c.Kind, "error", err)
+		return
+	}
+
+	c.AppliedAt = &now
+	c.Status = ScheduleApplied
+	if c.Until != nil {
+		c.Status = ScheduleActive
+	}
+	app.Logger.Info("scheduled routing change applied", "id", c.ID, "kind", c.Kind, "prefix", c.Prefix, "service", c.Service)
+	app.configReloaded("schedule", map[string]interface{}{"id": c.ID, "kind": c.Kind, "action": "applied"})
+}
+
+// scheduledCutover switches a blue/green route, refusing an unhealthy
+// group unless the change is forced
+func (app *Application) scheduledCutover(ctx context.Context, c *ScheduledChange) error {
+	route, _ := app.BlueGreen.Get(c.Prefix)
+	to := c.To
+	if to == "" {
+		var ok bool
+		if to, ok = route.other(); !ok {
+			return fmt.Errorf("the route has more than two groups, name the group to switch to")
+		}
+	}
+	c.To, c.previous.active = to, route.Active
+	if to == route.Active {
+		return nil
+	}
+
+	if !c.Force {
+		if failing := app.verifyGroup(ctx, route.Groups[to]); len(failing) > 0 {
+			return fmt.Errorf("group %q is not healthy: %v", to, failing)
+		}
+	}
+	_, err := app.switchBlueGreen(c.Prefix, route.Active, to)
+	return err
+}
+
+// revertScheduledChange restores what an active change replaced
+func (app *Application) revertScheduledChange(c *ScheduledChange, now time.Time) {
+	var err error
+	switch c.Kind {
+	case ScheduleCutover:
+		if c.To != c.previous.active {
+			_, err = app.switchBlueGreen(c.Prefix, c.To, c.previous.active)
+		}
+	case ScheduleCanary:
+		err = app.BlueGreen.setCanary(c.Prefix, c.previous.canary, c.previous.weight)
+	case ScheduleWeight:
+		err = app.ServiceGroups.SetWeight(c.Service, c.previous.weight)
+	}
+	if err != nil {
+		c.Status, c.Error = ScheduleFailed, "revert: "+err.Error()
+		app.Logger.Error("scheduled routing change revert failed", "id", c.ID, "kind", c.Kind, "error", err)
+		return
+	}
+
+	c.RevertedAt = &now
+	c.Status = ScheduleReverted
+	app.Logger.Info("scheduled routing change reverted", "id", c.ID, "kind", c.Kind, "prefix", c.Prefix, "service", c.Service)
+	app.configReloaded("schedule", map[string]interface{}{"id": c.ID, "kind": c.Kind, "action": "reverted"})
+}
+
+// HandleSchedule serves GET, POST and DELETE /admin/schedule. GET lists the
+// upcoming changes, and finished ones too with ?history=true. POST schedules
+// a change such as {"kind": "cutover", "prefix": "/v1", "to": "green",
+// "at": "2025-06-01T02:00:00Z"}, and DELETE cancels one with ?id=.
+func (app *Application) HandleSchedule(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		history := r.URL.Query().Get("history") == "true"
+		writeJSON(w, http.StatusOK, map[string]interface{}{"changes": app.Schedule.List(history)})
+	case http.MethodPost:
+		var change ScheduledChange
+		if err := json.NewDecoder(r.Body).Decode(&change); err != nil {
+			http.Error(w, "invalid payload in request", http.StatusBadRequest)
+			return
+		}
+		if err := app.validateScheduledChange(&change); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !change.At.After(app.clock.Now()) {
+			http.Error(w, "at must be in the future", http.StatusBadRequest)
+			return
+		}
+		change = app.Schedule.Add(change)
+		app.Logger.InfoContext(r.Context(), "routing change scheduled", "id", change.ID, "kind", change.Kind, "at", change.At)
+		writeJSON(w, http.StatusCreated, change)
+	case http.MethodDelete:
+		change, err := app.Schedule.Cancel(r.URL.Query().Get("id"))
+		switch {
+		case errors.Is(err, errScheduledChangeNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		app.Logger.InfoContext(r.Context(), "scheduled routing change cancelled", "id", change.ID, "status", change.Status)
+		writeJSON(w, http.StatusOK, change)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,378 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// SchemaReloadInterval is how often schema files are checked for changes
+const SchemaReloadInterval = 2 * time.Second
+
+// SchemaConfig is the on-disk schema validation config
+type SchemaConfig struct {
+	Routes []SchemaRouteConfig `json:"routes"`
+}
+
+// SchemaRouteConfig attaches a request body schema to a route prefix. The
+// schema is either a JSON Schema file or an operation in an OpenAPI 3 JSON
+// document, selected by operationId or "METHOD /path". Relative paths are
+// resolved against the config file's directory.
+type SchemaRouteConfig struct {
+	Prefix    string `json:"prefix"`
+	Schema    string `json:"schema,omitempty"`
+	OpenAPI   string `json:"openapi,omitempty"`
+	Operation string `json:"operation,omitempty"`
+}
+
+// routeSchema is a compiled request body schema for a route prefix
+type routeSchema struct {
+	prefix   string
+	schema   *jsonSchema
+	required bool
+}
+
+// schemaSet is one loaded generation of route schemas
+type schemaSet struct {
+	routes   map[string]*routeSchema
+	files    map[string]time.Time // file -> modification time when loaded
+	loadedAt time.Time
+}
+
+// SchemaValidator validates JSON request bodies against per-route schemas and
+// reloads them when the config or any schema file changes
+type SchemaValidator struct {
+	path    string
+	current atomic.Pointer[schemaSet]
+	logger  *slog.Logger
+	// events receives reloads; nil discards them
+	events *EventBus
+}
+
+// NewSchemaValidator loads a schema config file
+func NewSchemaValidator(path string, logger *slog.Logger) (*SchemaValidator, error) {
+	sv := &SchemaValidator{path: path, logger: logger}
+	if err := sv.Reload(); err != nil {
+		return nil, err
+	}
+	return sv, nil
+}
+
+// Reload re-reads the config and every schema it references, keeping the
+// previous schemas if anything fails to load
+func (sv *SchemaValidator) Reload() error {
+	set, err := loadSchemaSet(sv.path)
+	if err != nil {
+		return err
+	}
+
+	sv.current.Store(set)
+	sv.logger.Info("request schemas loaded", "path", sv.path, "routes", len(set.routes))
+	sv.events.Publish(Event{Type: EventConfigReloaded, Data: map[string]interface{}{"config": "schemas", "routes": len(set.routes)}})
+	return nil
+}
+
+// Watch reloads the schemas whenever one of their files changes, until ctx
+// is done
+func (sv *SchemaValidator) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !sv.changed() {
+				continue
+			}
+			if err := sv.Reload(); err != nil {
+				sv.logger.Error("failed to reload request schemas, keeping previous version", "error", err)
+			}
+		}
+	}
+}
+
+// changed reports whether any file of the current generation was modified
+func (sv *SchemaValidator) changed() bool {
+	for file, modTime := range sv.current.Load().files {
+		info, err := os.Stat(file)
+		if err != nil || !info.ModTime().Equal(modTime) {
+			return true
+		}
+	}
+	return false
+}
+
+// Routes returns the prefixes that currently have a schema
+func (sv *SchemaValidator) Routes() []string {
+	set := sv.current.Load()
+
+	prefixes := make([]string, 0, len(set.routes))
+	for prefix := range set.routes {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	return prefixes
+}
+
+// Validate checks a request body against the schema for the longest
+// matching prefix, returning the violations found. Requests on routes
+// without a schema always pass.
+func (sv *SchemaValidator) Validate(path string, body []byte) []string {
+	set := sv.current.Load()
+
+	var route *routeSchema
+	for prefix, rs := range set.routes {
+		if strings.HasPrefix(path, prefix) && (route == nil || len(prefix) > len(route.prefix)) {
+			route = rs
+		}
+	}
+	if route == nil {
+		return nil
+	}
+
+	if len(body) == 0 {
+		if route.required {
+			return []string{"/: a JSON request body is required"}
+		}
+		return nil
+	}
+
+	v, err := decodeJSON(body)
+	if err != nil {
+		return []string{"/: invalid JSON: " + err.Error()}
+	}
+	return route.schema.validate(v)
+}
+
+// loadSchemaSet reads a schema config and compiles every route's schema
+func loadSchemaSet(path string) (*schemaSet, error) {
+	set := &schemaSet{
+		routes:   make(map[string]*routeSchema),
+		files:    make(map[string]time.Time),
+		loadedAt: time.Now(),
+	}
+
+	data, err := set.readFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema config: %w", err)
+	}
+
+	var cfg SchemaConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse schema config: %w", err)
+	}
+
+	dir := filepath.Dir(path)
+	resolve := func(file string) string {
+		if filepath.IsAbs(file) {
+			return file
+		}
+		return filepath.Join(dir, file)
+	}
+
+	for _, route := range cfg.Routes {
+		if route.Prefix == "" {
+			return nil, fmt.Errorf("schema route is missing a prefix")
+		}
+
+		var rs *routeSchema
+		switch {
+		case route.Schema != "" && route.OpenAPI == "":
+			rs, err = set.loadJSONSchema(resolve(route.Schema))
+		case route.OpenAPI != "" && route.Schema == "":
+			rs, err = set.loadOpenAPISchema(resolve(route.OpenAPI), route.Operation)
+		default:
+			err = fmt.Errorf("exactly one of schema or openapi is required")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("schema for %s: %w", route.Prefix, err)
+		}
+
+		rs.prefix = route.Prefix
+		set.routes[route.Prefix] = rs
+	}
+
+	return set, nil
+}
+
+// readFile reads a file and records its modification time for reloading
+func (set *schemaSet) readFile(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	set.files[path] = info.ModTime()
+	return data, nil
+}
+
+// loadJSONSchema compiles a standalone JSON Schema file
+func (set *schemaSet) loadJSONSchema(path string) (*routeSchema, error) {
+	data, err := set.readFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := decodeJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	schema, err := compileJSONSchema(doc, "")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &routeSchema{schema: schema}, nil
+}
+
+// loadOpenAPISchema compiles the application/json request body schema of an
+// operation in an OpenAPI 3 JSON document
+func (set *schemaSet) loadOpenAPISchema(path, operation string) (*routeSchema, error) {
+	if operation == "" {
+		return nil, fmt.Errorf("openapi schemas need an operation")
+	}
+
+	data, err := set.readFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := decodeJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	pointer, err := findOpenAPIOperation(doc, operation)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	c := &schemaCompiler{root: doc, refs: make(map[string]*jsonSchema)}
+
+	node, err := c.resolve(pointer + "/requestBody")
+	if err != nil {
+		return nil, fmt.Errorf("%s: operation %s has no request body", path, operation)
+	}
+	body, _ := node.(map[string]interface{})
+	if ref, ok := body["$ref"].(string); ok {
+		if node, err = c.resolve(ref); err != nil {
+			return nil, err
+		}
+		body, _ = node.(map[string]interface{})
+	}
+
+	content, _ := body["content"].(map[string]interface{})
+	media, _ := content["application/json"].(map[string]interface{})
+	if media == nil || media["schema"] == nil {
+		return nil, fmt.Errorf("%s: operation %s has no application/json request body schema", path, operation)
+	}
+
+	schema, err := c.compile(media["schema"])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	required, _ := body["required"].(bool)
+	return &routeSchema{schema: schema, required: required}, nil
+}
+
+// findOpenAPIOperation returns the JSON pointer of an operation, given its
+// operationId or "METHOD /path"
+func findOpenAPIOperation(doc interface{}, operation string) (string, error) {
+	root, _ := doc.(map[string]interface{})
+	paths, _ := root["paths"].(map[string]interface{})
+
+	escape := strings.NewReplacer("~", "~0", "/", "~1")
+
+	if method, opPath, ok := strings.Cut(operation, " "); ok {
+		item, _ := paths[opPath].(map[string]interface{})
+		if _, exists := item[strings.ToLower(method)]; exists {
+			return "#/paths/" + escape.Replace(opPath) + "/" + strings.ToLower(method), nil
+		}
+	}
+
+	for opPath, item := range paths {
+		methods, _ := item.(map[string]interface{})
+		for method, op := range methods {
+			fields, _ := op.(map[string]interface{})
+			if id, _ := fields["operationId"].(string); id == operation {
+				return "#/paths/" + escape.Replace(opPath) + "/" + method, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("operation %q not found", operation)
+}
+
+// ConfigureSchemas enables request body validation from a schema config file
+func (app *Application) ConfigureSchemas(path string) error {
+	sv, err := NewSchemaValidator(path, app.Logger)
+	if err != nil {
+		return err
+	}
+
+	sv.events = app.Events
+	app.Schemas = sv
+	return nil
+}
+
+// validateRequestBody rejects a request whose body does not match its route's
+// schema with a 400 listing the violations. It reports whether the request
+// may continue.
+func (app *Application) validateRequestBody(w http.ResponseWriter, r *http.Request, body []byte) bool {
+	if app.Schemas == nil || app.skipStage(r, StageSchema) {
+		return true
+	}
+
+	errs := app.Schemas.Validate(r.URL.Path, body)
+	if len(errs) == 0 {
+		return true
+	}
+
+	app.Logger.InfoContext(r.Context(), "request body failed schema validation", "path", r.URL.Path, "errors", len(errs))
+	app.writeErrorDetails(w, r, CodeSchemaViolation, "the request body does not match the route's schema", errs)
+	return false
+}
+
+// HandleSchemas serves /admin/schemas:
+//
+//	GET    list the routes with a request schema
+//	POST   reload the schema config now
+func (app *Application) HandleSchemas(w http.ResponseWriter, r *http.Request) {
+	if app.Schemas == nil {
+		http.Error(w, "schema validation is not configured", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"routes":    app.Schemas.Routes(),
+			"loaded_at": app.Schemas.current.Load().loadedAt,
+		})
+
+	case http.MethodPost:
+		if err := app.Schemas.Reload(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "reloaded", "routes": app.Schemas.Routes()})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,284 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Self-monitor defaults used by DefaultSelfMonitorConfig
+const (
+	DefaultSelfMonitorInterval = time.Second
+	DefaultMemoryRejectRatio   = 0.9
+)
+
+// cgroup files holding the container memory limit, v2 then v1
+var cgroupMemoryLimitFiles = []string{
+	"/sys/fs/cgroup/memory.max",
+	"/sys/fs/cgroup/memory/memory.limit_in_bytes",
+}
+
+// SelfMonitorConfig controls how the proxy watches its own resource use
+type SelfMonitorConfig struct {
+	// Interval is how often runtime metrics are sampled
+	Interval time.Duration
+	// MemoryLimit is the memory the proxy may use in bytes; 0 detects it
+	// from the container's cgroup and GOMEMLIMIT
+	MemoryLimit int64
+	// RejectRatio rejects new proxied requests while memory use passes this
+	// share of MemoryLimit, between 0 and 1; 0 never rejects
+	RejectRatio float64
+}
+
+// DefaultSelfMonitorConfig samples every second and rejects requests at 90%
+// of the detected memory limit
+func DefaultSelfMonitorConfig() SelfMonitorConfig {
+	return SelfMonitorConfig{Interval: DefaultSelfMonitorInterval, RejectRatio: DefaultMemoryRejectRatio}
+}
+
+// RuntimeStats is a sample of the proxy's own resource use
+type RuntimeStats struct {
+	Time time.Time `json:"time"`
+	// HeapBytes is memory held by live and unswept heap objects
+	HeapBytes uint64 `json:"heap_bytes"`
+	// MemoryBytes is all memory the Go runtime has mapped and not returned
+	// to the OS, close to the process's resident size
+	MemoryBytes uint64 `json:"memory_bytes"`
+	// MemoryLimit is 0 when no limit is configured or detected
+	MemoryLimit int64   `json:"memory_limit,omitempty"`
+	MemoryRatio float64 `json:"memory_ratio,omitempty"`
+	Goroutines  uint64  `json:"goroutines"`
+	// CPU is the share of the CPU available to the process, across
+	// GOMAXPROCS, used since the previous sample
+	CPU      float64 `json:"cpu"`
+	GCCycles uint64  `json:"gc_cycles"`
+	// GCPauseP99Ms and GCPauseMaxMs cover the pauses since the previous
+	// sample
+	GCPauseP99Ms float64 `json:"gc_pause_p99_ms"`
+	GCPauseMaxMs float64 `json:"gc_pause_max_ms"`
+	// Rejecting is true while new requests are turned away for memory
+	Rejecting bool   `json:"rejecting"`
+	Rejected  uint64 `json:"rejected"`
+}
+
+// SelfMonitor samples the runtime's heap, memory, goroutines, GC pauses and
+// CPU use, and turns away new requests while memory nears the limit
+type SelfMonitor struct {
+	cfg    SelfMonitorConfig
+	logger *slog.Logger
+
+	rejecting atomic.Bool
+	rejected  atomic.Uint64
+
+	mu      sync.Mutex
+	latest  RuntimeStats
+	samples []metrics.Sample
+	pauses  []uint64
+	cpuTime time.Duration
+	cpuAt   time.Time
+}
+
+// Runtime metrics read by the self-monitor, in samples order
+const (
+	metricHeapObjects = "/memory/classes/heap/objects:bytes"
+	metricMemoryTotal = "/memory/classes/total:bytes"
+	metricHeapFreed   = "/memory/classes/heap/released:bytes"
+	metricGoroutines  = "/sched/goroutines:goroutines"
+	metricGCCycles    = "/gc/cycles/total:gc-cycles"
+	metricGCPauses    = "/sched/pauses/total/gc:seconds"
+)
+
+// NewSelfMonitor validates cfg and creates a self-monitor, detecting the
+// memory limit when cfg leaves it unset
+func NewSelfMonitor(cfg SelfMonitorConfig, logger *slog.Logger) (*SelfMonitor, error) {
+	if cfg.Interval <= 0 {
+		return nil, fmt.Errorf("self-monitor interval must be positive")
+	}
+	if cfg.MemoryLimit < 0 {
+		return nil, fmt.Errorf("memory limit must not be negative")
+	}
+	if cfg.RejectRatio < 0 || cfg.RejectRatio > 1 {
+		return nil, fmt.Errorf("memory reject ratio must be between 0 and 1")
+	}
+	if cfg.MemoryLimit == 0 {
+		cfg.MemoryLimit = detectMemoryLimit()
+	}
+
+	sm := &SelfMonitor{cfg: cfg, logger: logger}
+	for _, name := range []string{metricHeapObjects, metricMemoryTotal, metricHeapFreed, metricGoroutines, metricGCCycles, metricGCPauses} {
+		sm.samples = append(sm.samples, metrics.Sample{Name: name})
+	}
+	sm.sample(time.Now())
+	return sm, nil
+}
+
+// SetSelfMonitor replaces the default self-monitor. It must be called before
+// Start.
+func (app *Application) SetSelfMonitor(sm *SelfMonitor) {
+	app.SelfMonitor = sm
+}
+
+// detectMemoryLimit returns the lower of the cgroup memory limit and
+// GOMEMLIMIT, or 0 when neither is set
+func detectMemoryLimit() int64 {
+	limit := int64(0)
+	for _, path := range cgroupMemoryLimitFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		// v2 writes "max" and v1 a huge page-aligned number for no limit
+		n, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+		if err == nil && n > 0 && n < 1<<62 {
+			limit = n
+		}
+		break
+	}
+
+	if goLimit := debug.SetMemoryLimit(-1); goLimit > 0 && goLimit < math.MaxInt64 && (limit == 0 || goLimit < limit) {
+		limit = goLimit
+	}
+	return limit
+}
+
+// processCPUTime returns the user and system CPU time used by the process
+func processCPUTime() (time.Duration, error) {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0, err
+	}
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano()), nil
+}
+
+// sample reads the runtime metrics and starts or stops rejecting requests
+func (sm *SelfMonitor) sample(now time.Time) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	metrics.Read(sm.samples)
+	stats := RuntimeStats{
+		Time:        now,
+		HeapBytes:   sm.samples[0].Value.Uint64(),
+		MemoryBytes: sm.samples[1].Value.Uint64() - sm.samples[2].Value.Uint64(),
+		MemoryLimit: sm.cfg.MemoryLimit,
+		Goroutines:  sm.samples[3].Value.Uint64(),
+		GCCycles:    sm.samples[4].Value.Uint64(),
+	}
+	if stats.MemoryLimit > 0 {
+		stats.MemoryRatio = float64(stats.MemoryBytes) / float64(stats.MemoryLimit)
+	}
+	stats.GCPauseP99Ms, stats.GCPauseMaxMs = sm.recentPauses(sm.samples[5].Value.Float64Histogram())
+
+	if cpuTime, err := processCPUTime(); err == nil {
+		if elapsed := now.Sub(sm.cpuAt); !sm.cpuAt.IsZero() && elapsed > 0 {
+			stats.CPU = float64(cpuTime-sm.cpuTime) / float64(elapsed) / float64(runtime.GOMAXPROCS(0))
+		}
+		sm.cpuTime, sm.cpuAt = cpuTime, now
+	}
+
+	pressure := sm.cfg.RejectRatio > 0 && stats.MemoryLimit > 0 && stats.MemoryRatio >= sm.cfg.RejectRatio
+	switch wasRejecting := sm.rejecting.Swap(pressure); {
+	case pressure && !wasRejecting:
+		sm.logger.Warn("rejecting new requests near the memory limit",
+			"memory_bytes", stats.MemoryBytes, "memory_limit", stats.MemoryLimit, "ratio", stats.MemoryRatio)
+	case !pressure && wasRejecting:
+		sm.logger.Info("accepting requests again",
+			"memory_bytes", stats.MemoryBytes, "memory_limit", stats.MemoryLimit, "ratio", stats.MemoryRatio)
+	}
+	sm.latest = stats
+}
+
+// recentPauses returns the 99th percentile and longest GC pause, in
+// milliseconds, recorded in hist since the previous call
+func (sm *SelfMonitor) recentPauses(hist *metrics.Float64Histogram) (p99, longest float64) {
+	total := uint64(0)
+	delta := make([]uint64, len(hist.Counts))
+	for i, count := range hist.Counts {
+		if i < len(sm.pauses) {
+			delta[i] = count - sm.pauses[i]
+		} else {
+			delta[i] = count
+		}
+		total += delta[i]
+	}
+	sm.pauses = append(sm.pauses[:0], hist.Counts...)
+	if total == 0 {
+		return 0, 0
+	}
+
+	// A bucket is reported by its upper bound, or its lower one for the
+	// last, unbounded bucket
+	bound := func(i int) float64 {
+		if upper := hist.Buckets[i+1]; !math.IsInf(upper, 1) {
+			return upper * 1000
+		}
+		return hist.Buckets[i] * 1000
+	}
+	target := uint64(math.Ceil(float64(total) * 0.99))
+	seen := uint64(0)
+	for i, count := range delta {
+		if count == 0 {
+			continue
+		}
+		seen += count
+		if p99 == 0 && seen >= target {
+			p99 = bound(i)
+		}
+		longest = bound(i)
+	}
+	return p99, longest
+}
+
+// Run samples every interval until ctx is done
+func (sm *SelfMonitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(sm.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			sm.sample(now)
+		}
+	}
+}
+
+// Stats returns the latest sample
+func (sm *SelfMonitor) Stats() RuntimeStats {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	stats := sm.latest
+	stats.Rejecting = sm.rejecting.Load()
+	stats.Rejected = sm.rejected.Load()
+	return stats
+}
+
+// CPU returns the CPU share of the latest sample
+func (sm *SelfMonitor) CPU() float64 {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return sm.latest.CPU
+}
+
+// underMemoryPressure reports whether a new request should be turned away
+// because memory is near the limit, counting each one that is
+func (sm *SelfMonitor) underMemoryPressure() bool {
+	if !sm.rejecting.Load() {
+		return false
+	}
+	sm.rejected.Add(1)
+	return true
+}
@@ -0,0 +1,230 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/codytheroux96/go-reverse-proxy/internal/registry"
+)
+
+// DefaultServiceWeight is the traffic weight of a service with none set
+const DefaultServiceWeight = 1
+
+// Service health states
+const (
+	ServiceHealthy   = "healthy"
+	ServiceDegraded  = "degraded"
+	ServiceUnhealthy = "unhealthy"
+)
+
+// ServiceGroups splits a route's traffic between the logical services its
+// servers are instances of. Health checks and circuit breakers still track
+// each instance; a route served by several services first picks a service by
+// weight among those with a usable instance, then balances across that
+// service's instances.
+type ServiceGroups struct {
+	mu      sync.RWMutex
+	weights map[string]int
+}
+
+// NewServiceGroups creates service groups with every weight at
+// DefaultServiceWeight
+func NewServiceGroups() *ServiceGroups {
+	return &ServiceGroups{weights: make(map[string]int)}
+}
+
+// SetWeight sets a service's share of the traffic on the routes it shares
+// with other services; 0 drains it while any other service can serve
+func (sg *ServiceGroups) SetWeight(service string, weight int) error {
+	if service == "" {
+		return fmt.Errorf("service is required")
+	}
+	if weight < 0 {
+		return fmt.Errorf("weight must not be negative")
+	}
+
+	sg.mu.Lock()
+	defer sg.mu.Unlock()
+	sg.weights[service] = weight
+	return nil
+}
+
+// Weight returns a service's traffic weight
+func (sg *ServiceGroups) Weight(service string) int {
+	sg.mu.RLock()
+	defer sg.mu.RUnlock()
+
+	if weight, ok := sg.weights[service]; ok {
+		return weight
+	}
+	return DefaultServiceWeight
+}
+
+// Weights returns the services with a weight set
+func (sg *ServiceGroups) Weights() map[string]int {
+	sg.mu.RLock()
+	defer sg.mu.RUnlock()
+
+	weights := make(map[string]int, len(sg.weights))
+	for service, weight := range sg.weights {
+		weights[service] = weight
+	}
+	return weights
+}
+
+// pick narrows a route's usable servers to the instances of one service,
+// chosen in proportion to service weight. Routes whose servers all belong
+// to no named service are returned whole, so they keep balancing per
+// server.
+func (sg *ServiceGroups) pick(servers []registry.Server) []registry.Server {
+	var names []string
+	instances := make(map[string][]registry.Server)
+	grouped := false
+	for _, server := range servers {
+		name := server.ServiceName()
+		if _, seen := instances[name]; !seen {
+			names = append(names, name)
+		}
+		instances[name] = append(instances[name], server)
+		grouped = grouped || server.Service != ""
+	}
+	if !grouped || len(names) < 2 {
+		return servers
+	}
+
+	total := 0
+	weights := make([]int, len(names))
+	for i, name := range names {
+		weights[i] = sg.Weight(name)
+		total += weights[i]
+	}
+	// Drained services still serve when nothing else can
+	if total == 0 {
+		return servers
+	}
+
+	target := rand.IntN(total)
+	for i, weight := range weights {
+		if target < weight {
+			return instances[names[i]]
+		}
+		target -= weight
+	}
+	return instances[names[len(names)-1]]
+}
+
+// ServiceInstance is one server of a logical service
+type ServiceInstance struct {
+	Name    string `json:"name"`
+	BaseURL string `json:"base_url"`
+	Zone    string `json:"zone,omitempty"`
+	Healthy bool   `json:"healthy"`
+	Live    bool   `json:"live"`
+	Breaker string `json:"breaker"`
+}
+
+// ServiceGroupStatus is a logical service with the health of its instances
+type ServiceGroupStatus struct {
+	Name   string   `json:"name"`
+	Routes []string `json:"routes"`
+	Weight int      `json:"weight"`
+	// Health is healthy when every instance is usable, degraded when some
+	// are, and unhealthy when none are
+	Health    string            `json:"health"`
+	Usable    int               `json:"usable"`
+	Instances []ServiceInstance `json:"instances"`
+}
+
+// serviceGroupStatuses groups the registered servers by logical service
+func (app *Application) serviceGroupStatuses() ([]ServiceGroupStatus, error) {
+	servers, err := app.Registry.GetServers()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(servers, func(i, j int) bool { return servers[i].Name < servers[j].Name })
+
+	byName := make(map[string]*ServiceGroupStatus)
+	var statuses []*ServiceGroupStatus
+	for _, server := range servers {
+		name := server.ServiceName()
+		status, exists := byName[name]
+		if !exists {
+			status = &ServiceGroupStatus{Name: name, Weight: app.ServiceGroups.Weight(name)}
+			byName[name] = status
+			statuses = append(statuses, status)
+		}
+
+		for _, prefix := range server.Prefixes {
+			if !slices.Contains(status.Routes, prefix) {
+				status.Routes = append(status.Routes, prefix)
+			}
+		}
+
+		instance := ServiceInstance{
+			Name:    server.Name,
+			BaseURL: server.BaseURL,
+			Zone:    server.Zone,
+			Healthy: app.HealthMonitor.IsHealthy(server.Name),
+			Live:    app.HealthMonitor.IsLive(server.Name),
+			Breaker: Closed.String(),
+		}
+		if info, exists := app.CircuitBreaker.GetBreakerInfo(server.Name); exists {
+			instance.Breaker = info.State.String()
+		}
+		if instance.Healthy && instance.Breaker != Open.String() {
+			status.Usable++
+		}
+		status.Instances = append(status.Instances, instance)
+	}
+
+	result := make([]ServiceGroupStatus, len(statuses))
+	for i, status := range statuses {
+		switch status.Usable {
+		case len(status.Instances):
+			status.Health = ServiceHealthy
+		case 0:
+			status.Health = ServiceUnhealthy
+		default:
+			status.Health = ServiceDegraded
+		}
+		sort.Strings(status.Routes)
+		result[i] = *status
+	}
+	return result, nil
+}
+
+// HandleServiceGroups serves GET /admin/service-groups, listing each logical
+// service with its weight and instance health, and POST with
+// {"service": "checkout", "weight": 9} to set a service's weight
+func (app *Application) HandleServiceGroups(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		statuses, err := app.serviceGroupStatuses()
+		if err != nil {
+			http.Error(w, "failed to list servers", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"services": statuses})
+	case http.MethodPost:
+		var req struct {
+			Service string `json:"service"`
+			Weight  *int   `json:"weight"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Weight == nil {
+			http.Error(w, "invalid payload in request", http.StatusBadRequest)
+			return
+		}
+		if err := app.ServiceGroups.SetWeight(req.Service, *req.Weight); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"service": req.Service, "weight": *req.Weight})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,305 @@
+package app
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codytheroux96/go-reverse-proxy/internal/registry"
+)
+
+// Services API page sizes
+const (
+	DefaultServicesPageSize = 50
+	MaxServicesPageSize     = 500
+)
+
+// Error codes specific to the registry APIs
+var (
+	CodeUnauthorized = ErrorCode{Name: "unauthorized", Status: http.StatusUnauthorized}
+	CodeNotFound     = ErrorCode{Name: "not_found", Status: http.StatusNotFound}
+	CodeConflict     = ErrorCode{Name: "conflict", Status: http.StatusConflict}
+	// CodeRegistryUnavailable means the registry database cannot be reached;
+	// reads are served from its last snapshot but changes are refused
+	CodeRegistryUnavailable = ErrorCode{Name: "registry_unavailable", Status: http.StatusServiceUnavailable, Retryable: true}
+)
+
+// ServiceResource is a registered service as the v2 services API returns it
+type ServiceResource struct {
+	Name         string    `json:"name"`
+	BaseURL      string    `json:"base_url"`
+	Routes       []string  `json:"routes"`
+	Zone         string    `json:"zone,omitempty"`
+	Group        string    `json:"group,omitempty"`
+	RegisteredAt time.Time `json:"registered_at"`
+	Healthy      bool      `json:"healthy"`
+	// Live is set while the backend process is up, even when it is not
+	// ready for traffic
+	Live bool `json:"live"`
+}
+
+// serviceFields are the fields the fields query parameter can select
+var serviceFields = map[string]bool{
+	"name": true, "base_url": true, "routes": true, "zone": true, "group": true, "registered_at": true, "healthy": true, "live": true,
+}
+
+// ServiceList is a page of services
+type ServiceList struct {
+	Services []interface{} `json:"services"`
+	// Total counts the services matching the filters across all pages
+	Total int `json:"total"`
+	// NextPageToken fetches the next page; empty on the last page
+	NextPageToken string `json:"next_page_token,omitempty"`
+}
+
+// writeAPIError writes a JSON error envelope regardless of the route's error
+// page format, so API clients always get the same shape
+func (app *Application) writeAPIError(w http.ResponseWriter, r *http.Request, code ErrorCode, message string) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, code.Status, ErrorEnvelope{Error: ErrorBody{
+		Status:    code.Status,
+		Code:      code.Name,
+		Message:   message,
+		RequestID: RequestIDFromContext(r.Context()),
+		Retryable: code.Retryable,
+	}})
+}
+
+// writeRegistryError reports a failed registry operation, as retryable when
+// the registry database is unavailable
+func (app *Application) writeRegistryError(w http.ResponseWriter, r *http.Request, err error, message string) {
+	if errors.Is(err, registry.ErrDatabaseUnavailable) {
+		app.writeAPIError(w, r, CodeRegistryUnavailable, "registry database unavailable")
+		return
+	}
+	app.writeAPIError(w, r, CodeInternal, message)
+}
+
+// serviceResource describes a registered server with its health
+func (app *Application) serviceResource(server registry.Server) ServiceResource {
+	return ServiceResource{
+		Name:         server.Name,
+		BaseURL:      server.BaseURL,
+		Routes:       server.Prefixes,
+		Zone:         server.Zone,
+		Group:        server.Service,
+		RegisteredAt: server.RegisteredAt,
+		Healthy:      app.HealthMonitor.IsHealthy(server.Name),
+		Live:         app.HealthMonitor.IsLive(server.Name),
+	}
+}
+
+// HandleServicesV2 serves GET /api/v2/services?prefix=&zone=&group=&healthy=&fields=&page_size=&page_token=
+// and POST /api/v2/services with a ServiceResource body
+func (app *Application) HandleServicesV2(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		app.listServicesV2(w, r)
+	case http.MethodPost:
+		app.registerServiceV2(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		app.writeAPIError(w, r, CodeMethodNotAllowed, "method not allowed")
+	}
+}
+
+// HandleServiceV2 serves GET and DELETE /api/v2/services/{name}
+func (app *Application) HandleServiceV2(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	server, err := app.Registry.GetServer(name)
+	if err != nil || server == nil {
+		app.writeAPIError(w, r, CodeNotFound, fmt.Sprintf("service %q is not registered", name))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, app.serviceResource(*server))
+	case http.MethodDelete:
+		_, err := app.DeregisterGracefully(r.Context(), name)
+		if errors.Is(err, ErrAlreadyDraining) {
+			app.writeAPIError(w, r, CodeConflict, fmt.Sprintf("service %q is already being deregistered", name))
+			return
+		}
+		if err != nil {
+			app.Logger.ErrorContext(r.Context(), "failed to deregister service", "service", name, "error", err)
+			app.writeRegistryError(w, r, err, "failed to deregister service")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		app.writeAPIError(w, r, CodeMethodNotAllowed, "method not allowed")
+	}
+}
+
+// listServicesV2 returns one page of the services matching the filters,
+// ordered by name
+func (app *Application) listServicesV2(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	pageSize := DefaultServicesPageSize
+	if v := query.Get("page_size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > MaxServicesPageSize {
+			app.writeAPIError(w, r, CodeBadRequest, fmt.Sprintf("page_size must be between 1 and %d", MaxServicesPageSize))
+			return
+		}
+		pageSize = n
+	}
+
+	after := ""
+	if token := query.Get("page_token"); token != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil {
+			app.writeAPIError(w, r, CodeBadRequest, "invalid page_token")
+			return
+		}
+		after = string(decoded)
+	}
+
+	var healthy *bool
+	if v := query.Get("healthy"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			app.writeAPIError(w, r, CodeBadRequest, "healthy must be true or false")
+			return
+		}
+		healthy = &b
+	}
+
+	var fields []string
+	if v := query.Get("fields"); v != "" {
+		for _, field := range strings.Split(v, ",") {
+			if !serviceFields[field] {
+				app.writeAPIError(w, r, CodeBadRequest, fmt.Sprintf("unknown field %q", field))
+				return
+			}
+			fields = append(fields, field)
+		}
+	}
+
+	servers, err := app.Registry.GetServers()
+	if err != nil {
+		app.Logger.ErrorContext(r.Context(), "failed to list services", "error", err)
+		app.writeRegistryError(w, r, err, "failed to list services")
+		return
+	}
+	sort.Slice(servers, func(i, j int) bool { return servers[i].Name < servers[j].Name })
+
+	prefix, zone, group := query.Get("prefix"), query.Get("zone"), query.Get("group")
+	list := ServiceList{Services: []interface{}{}}
+	last, more := "", false
+	for _, server := range servers {
+		if prefix != "" && !slices.Contains(server.Prefixes, prefix) {
+			continue
+		}
+		if zone != "" && server.Zone != zone {
+			continue
+		}
+		if group != "" && server.ServiceName() != group {
+			continue
+		}
+		res := app.serviceResource(server)
+		if healthy != nil && res.Healthy != *healthy {
+			continue
+		}
+
+		list.Total++
+		if server.Name <= after {
+			continue
+		}
+		if len(list.Services) == pageSize {
+			more = true
+			continue
+		}
+		list.Services = append(list.Services, selectFields(res, fields))
+		last = server.Name
+	}
+	if more {
+		list.NextPageToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// registerServiceV2 registers a service from a ServiceResource body
+func (app *Application) registerServiceV2(w http.ResponseWriter, r *http.Request) {
+	var body ServiceResource
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		app.writeAPIError(w, r, CodeBadRequest, "invalid JSON body")
+		return
+	}
+	if body.Name == "" || body.BaseURL == "" || len(body.Routes) == 0 {
+		app.writeAPIError(w, r, CodeBadRequest, "name, base_url and routes are required")
+		return
+	}
+	for _, route := range body.Routes {
+		if !strings.HasPrefix(route, "/") {
+			app.writeAPIError(w, r, CodeBadRequest, fmt.Sprintf("route %q must start with /", route))
+			return
+		}
+	}
+	if existing, err := app.Registry.GetServer(body.Name); err == nil && existing != nil {
+		app.writeAPIError(w, r, CodeConflict, fmt.Sprintf("service %q is already registered", body.Name))
+		return
+	}
+
+	server := registry.Server{
+		Name:         body.Name,
+		BaseURL:      strings.TrimSuffix(body.BaseURL, "/"),
+		Prefixes:     body.Routes,
+		Zone:         body.Zone,
+		Service:      body.Group,
+		RegisteredAt: time.Now(),
+	}
+	if err := app.Registry.Register(server); err != nil {
+		app.Logger.ErrorContext(r.Context(), "failed to register service", "service", server.Name, "error", err)
+		app.writeRegistryError(w, r, err, "failed to register service")
+		return
+	}
+
+	w.Header().Set("Location", "/api/v2/services/"+server.Name)
+	writeJSON(w, http.StatusCreated, app.serviceResource(server))
+}
+
+// selectFields narrows a service to the requested fields; no fields returns
+// it whole
+func selectFields(res ServiceResource, fields []string) interface{} {
+	if len(fields) == 0 {
+		return res
+	}
+
+	all := map[string]interface{}{
+		"name":          res.Name,
+		"base_url":      res.BaseURL,
+		"routes":        res.Routes,
+		"zone":          res.Zone,
+		"group":         res.Group,
+		"registered_at": res.RegisteredAt,
+		"healthy":       res.Healthy,
+	}
+	selected := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		selected[field] = all[field]
+	}
+	return selected
+}
+
+// HandleServicesV2Spec serves GET /api/v2/openapi.json, the OpenAPI
+// description of the services API
+func (app *Application) HandleServicesV2Spec(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		app.writeAPIError(w, r, CodeMethodNotAllowed, "method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(servicesAPISpec))
+}
@@ -0,0 +1,138 @@
+package app
+
+// servicesAPISpec is the OpenAPI 3 description of the v2 services API, served
+// at /api/v2/openapi.json
+const servicesAPISpec = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "go-reverse-proxy services API",
+    "version": "2.0.0",
+    "description": "Registers, lists and removes the backend services the proxy routes to."
+  },
+  "paths": {
+    "/api/v2/services": {
+      "get": {
+        "summary": "List services",
+        "operationId": "listServices",
+        "parameters": [
+          {"name": "prefix", "in": "query", "description": "Only services registered for this route prefix", "schema": {"type": "string"}},
+          {"name": "zone", "in": "query", "description": "Only services labelled with this zone", "schema": {"type": "string"}},
+          {"name": "group", "in": "query", "description": "Only instances of this logical service; an ungrouped service is its own group", "schema": {"type": "string"}},
+          {"name": "healthy", "in": "query", "description": "Only services passing (true) or failing (false) health checks", "schema": {"type": "boolean"}},
+          {"name": "fields", "in": "query", "description": "Comma separated fields to return for each service", "schema": {"type": "string", "example": "name,healthy"}},
+          {"name": "page_size", "in": "query", "schema": {"type": "integer", "minimum": 1, "maximum": 500, "default": 50}},
+          {"name": "page_token", "in": "query", "description": "next_page_token from the previous page", "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "200": {"description": "A page of services ordered by name", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ServiceList"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"},
+          "503": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "post": {
+        "summary": "Register a service",
+        "operationId": "registerService",
+        "parameters": [
+          {"name": "X-Registry-Token", "in": "header", "description": "Team token, required when prefix ownership is enabled", "schema": {"type": "string"}},
+          {"name": "X-Prefix-Takeover", "in": "header", "description": "Take over prefixes owned by other teams under the takeover conflict policy", "schema": {"type": "boolean"}}
+        ],
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ServiceInput"}}}
+        },
+        "responses": {
+          "201": {"description": "The registered service", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Service"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"},
+          "503": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/v2/services/{name}": {
+      "parameters": [
+        {"name": "name", "in": "path", "required": true, "schema": {"type": "string"}}
+      ],
+      "get": {
+        "summary": "Get a service",
+        "operationId": "getService",
+        "responses": {
+          "200": {"description": "The service", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Service"}}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "delete": {
+        "summary": "Deregister a service",
+        "operationId": "deregisterService",
+        "responses": {
+          "204": {"description": "The service was deregistered"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"},
+          "500": {"$ref": "#/components/responses/Error"},
+          "503": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    }
+  },
+  "components": {
+    "schemas": {
+      "ServiceInput": {
+        "type": "object",
+        "required": ["name", "base_url", "routes"],
+        "properties": {
+          "name": {"type": "string"},
+          "base_url": {"type": "string", "format": "uri"},
+          "routes": {"type": "array", "items": {"type": "string", "pattern": "^/"}, "minItems": 1},
+          "zone": {"type": "string"},
+          "group": {"type": "string", "description": "Logical service this instance belongs to"}
+        }
+      },
+      "Service": {
+        "type": "object",
+        "properties": {
+          "name": {"type": "string"},
+          "base_url": {"type": "string", "format": "uri"},
+          "routes": {"type": "array", "items": {"type": "string"}},
+          "zone": {"type": "string"},
+          "group": {"type": "string"},
+          "registered_at": {"type": "string", "format": "date-time"},
+          "healthy": {"type": "boolean", "description": "Ready for traffic"},
+          "live": {"type": "boolean", "description": "Backend process is up, whether or not it is ready"}
+        }
+      },
+      "ServiceList": {
+        "type": "object",
+        "properties": {
+          "services": {"type": "array", "items": {"$ref": "#/components/schemas/Service"}},
+          "total": {"type": "integer", "description": "Services matching the filters across all pages"},
+          "next_page_token": {"type": "string", "description": "Absent on the last page"}
+        }
+      },
+      "Error": {
+        "type": "object",
+        "properties": {
+          "error": {
+            "type": "object",
+            "properties": {
+              "status": {"type": "integer"},
+              "code": {"type": "string", "enum": ["bad_request", "unauthorized", "not_found", "conflict", "method_not_allowed", "internal_error", "registry_unavailable"]},
+              "message": {"type": "string"},
+              "request_id": {"type": "string"},
+              "retryable": {"type": "boolean"}
+            }
+          }
+        }
+      }
+    },
+    "responses": {
+      "Error": {
+        "description": "An error envelope",
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      }
+    }
+  }
+}
+`
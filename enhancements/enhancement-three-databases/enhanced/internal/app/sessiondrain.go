@@ -0,0 +1,77 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// DefaultSessionDrainPeriod is how long client connections are told to go
+// elsewhere before the listeners close
+const DefaultSessionDrainPeriod = 10 * time.Second
+
+// SetSessionDrainPeriod sets how long DrainSessions announces the shutdown
+// before closing the listeners; 0 uses DefaultSessionDrainPeriod
+func (app *Application) SetSessionDrainPeriod(period time.Duration) error {
+	if period < 0 {
+		return fmt.Errorf("session drain period must not be negative")
+	}
+	if period == 0 {
+		period = DefaultSessionDrainPeriod
+	}
+	app.sessionDrain = period
+	return nil
+}
+
+// SessionsDraining reports whether DrainSessions has started
+func (app *Application) SessionsDraining() bool {
+	return app.sessionsDraining.Load()
+}
+
+// DrainSessions moves long-lived client connections to other instances
+// before shutting servers down. For the drain period it fails readiness,
+// closes idle keep-alive connections and answers every HTTP/1.x request with
+// Connection: close, so each client reconnects, through its load balancer,
+// after its current request. The listeners stay open meanwhile for clients
+// whose load balancer has not yet noticed. The servers are then shut down,
+// waiting for in-flight requests until ctx is done.
+func (app *Application) DrainSessions(ctx context.Context, servers ...*http.Server) error {
+	if !app.sessionsDraining.CompareAndSwap(false, true) {
+		return nil
+	}
+	app.Logger.Info("draining client sessions", "period", app.sessionDrain)
+
+	for _, server := range servers {
+		// Also closes connections that are idle between requests
+		server.SetKeepAlivesEnabled(false)
+	}
+
+	timer := time.NewTimer(app.sessionDrain)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	var errs []error
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// AnnounceDrain asks HTTP/1.x clients to close their connection after each
+// response while sessions drain. HTTP/2 clients are sent GOAWAY when the
+// server shuts down.
+func (app *Application) AnnounceDrain(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.sessionsDraining.Load() && r.ProtoMajor == 1 {
+			w.Header().Set("Connection", "close")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
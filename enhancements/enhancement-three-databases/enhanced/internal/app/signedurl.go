@@ -0,0 +1,193 @@
+package app
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Query parameters carrying a signed URL's expiry and signature
+const (
+	SignedURLExpiresParam   = "expires"
+	SignedURLSignatureParam = "signature"
+)
+
+// Signed URL lifetimes applied to zero values
+const (
+	DefaultSignedURLTTL    = 15 * time.Minute
+	DefaultSignedURLMaxTTL = 24 * time.Hour
+)
+
+// SignedURLConfig designates routes, such as private downloads, that are only
+// served to short-lived URLs signed with a shared secret
+type SignedURLConfig struct {
+	// Routes are the route prefixes that require a signed URL
+	Routes []string
+	// Secret keys the HMAC-SHA256 over each URL's path and query
+	Secret string
+	// MaxTTL is the longest lifetime a URL may be minted with; 0 uses
+	// DefaultSignedURLMaxTTL
+	MaxTTL time.Duration
+}
+
+// signedURLs is a validated SignedURLConfig
+type signedURLs struct {
+	routes []string
+	secret []byte
+	maxTTL time.Duration
+}
+
+// SetSignedURLs requires a valid, unexpired signature on requests to the
+// given routes
+func (app *Application) SetSignedURLs(cfg SignedURLConfig) error {
+	if len(cfg.Routes) == 0 {
+		return fmt.Errorf("signed urls need at least one route prefix")
+	}
+	for _, route := range cfg.Routes {
+		if !strings.HasPrefix(route, "/") {
+			return fmt.Errorf("signed url route %q must start with /", route)
+		}
+	}
+	if cfg.Secret == "" {
+		return fmt.Errorf("signed urls need a secret")
+	}
+	if cfg.MaxTTL < 0 {
+		return fmt.Errorf("signed url max ttl must not be negative")
+	}
+	if cfg.MaxTTL == 0 {
+		cfg.MaxTTL = DefaultSignedURLMaxTTL
+	}
+	app.signedURLs = &signedURLs{routes: cfg.Routes, secret: []byte(cfg.Secret), maxTTL: cfg.MaxTTL}
+	return nil
+}
+
+// signed reports whether path is on a route requiring signed URLs
+func (s *signedURLs) signed(path string) bool {
+	for _, route := range s.routes {
+		if strings.HasPrefix(path, route) {
+			return true
+		}
+	}
+	return false
+}
+
+// signature is the HMAC of a path and its query without the signature
+// parameter. The query is re-encoded in sorted order so parameter order does
+// not matter.
+func (s *signedURLs) signature(path string, query url.Values) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(path))
+	mac.Write([]byte{'\n'})
+	mac.Write([]byte(query.Encode()))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// SignURL mints a URL for target, a path with an optional query on a signed
+// route, that is valid for ttl; 0 uses DefaultSignedURLTTL. It returns the
+// signed path and query and when it expires.
+func (app *Application) SignURL(target string, ttl time.Duration) (string, time.Time, error) {
+	s := app.signedURLs
+	if s == nil {
+		return "", time.Time{}, fmt.Errorf("signed urls are not configured")
+	}
+	if ttl == 0 {
+		ttl = DefaultSignedURLTTL
+	}
+	if ttl < 0 || ttl > s.maxTTL {
+		return "", time.Time{}, fmt.Errorf("ttl must be between 0 and %s", s.maxTTL)
+	}
+
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != "" || u.Host != "" {
+		return "", time.Time{}, fmt.Errorf("url must be a path with an optional query")
+	}
+	if !s.signed(u.Path) {
+		return "", time.Time{}, fmt.Errorf("%s is not on a signed url route", u.Path)
+	}
+
+	expires := app.clock.Now().Add(ttl).Truncate(time.Second)
+	query := u.Query()
+	query.Del(SignedURLSignatureParam)
+	query.Set(SignedURLExpiresParam, strconv.FormatInt(expires.Unix(), 10))
+	query.Set(SignedURLSignatureParam, s.signature(u.Path, query))
+	u.RawQuery = query.Encode()
+	return u.RequestURI(), expires, nil
+}
+
+// verifySignedURL rejects requests to signed routes whose signature is
+// missing, does not match, or has expired, before they reach the cache or a
+// backend. Accepted requests have the expiry and signature removed from
+// their query so every signed URL for an object shares a cache entry. It
+// reports whether the request may continue.
+func (app *Application) verifySignedURL(w http.ResponseWriter, r *http.Request) bool {
+	s := app.signedURLs
+	if s == nil || !s.signed(r.URL.Path) {
+		return true
+	}
+
+	query := r.URL.Query()
+	provided := query[SignedURLSignatureParam]
+	query.Del(SignedURLSignatureParam)
+	if len(provided) != 1 || !hmac.Equal([]byte(provided[0]), []byte(s.signature(r.URL.Path, query))) {
+		app.Logger.InfoContext(r.Context(), "signed url rejected", "path", r.URL.Path, "reason", "signature")
+		app.writeError(w, r, CodeInvalidSignedURL, "invalid url signature")
+		return false
+	}
+
+	// The expiry is covered by the signature, so only its value needs checking
+	expires, err := strconv.ParseInt(query.Get(SignedURLExpiresParam), 10, 64)
+	if err != nil {
+		app.Logger.InfoContext(r.Context(), "signed url rejected", "path", r.URL.Path, "reason", "expiry")
+		app.writeError(w, r, CodeInvalidSignedURL, "signed url has no valid expiry")
+		return false
+	}
+	if !app.clock.Now().Before(time.Unix(expires, 0)) {
+		app.Logger.InfoContext(r.Context(), "signed url rejected", "path", r.URL.Path, "reason", "expired")
+		app.writeError(w, r, CodeSignedURLExpired, "signed url has expired")
+		return false
+	}
+
+	query.Del(SignedURLExpiresParam)
+	r.URL.RawQuery = query.Encode()
+	return true
+}
+
+// HandleSignedURLs serves POST /admin/signed-urls, minting a signed URL from
+// {"url": "/downloads/report.pdf", "ttl": "10m"}
+func (app *Application) HandleSignedURLs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		URL string `json:"url"`
+		TTL string `json:"ttl"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.URL == "" {
+		http.Error(w, "expected {\"url\": \"<path>\", \"ttl\": \"<duration>\"}", http.StatusBadRequest)
+		return
+	}
+	var ttl time.Duration
+	if req.TTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(req.TTL); err != nil {
+			http.Error(w, "invalid ttl: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	signed, expires, err := app.SignURL(req.URL, ttl)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"url": signed, "expires_at": expires})
+}
@@ -0,0 +1,223 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/codytheroux96/go-reverse-proxy/internal/registry"
+)
+
+// SnapshotVersion is the format version of snapshots written by this build
+const SnapshotVersion = 1
+
+// Snapshot is the proxy's effective routing config and state at one moment,
+// for reproducing its routing decisions offline with LoadSnapshot. Secrets
+// are left out and credentials in URLs are redacted.
+type Snapshot struct {
+	Version  int                        `json:"version"`
+	TakenAt  time.Time                  `json:"taken_at"`
+	Config   SnapshotConfig             `json:"config"`
+	Servers  []registry.Server          `json:"servers"`
+	Health   map[string]HealthStatus    `json:"health"`
+	Breakers map[string]SnapshotBreaker `json:"breakers"`
+	Cache    CacheStats                 `json:"cache"`
+}
+
+// SnapshotConfig is the runtime config behind routing decisions
+type SnapshotConfig struct {
+	BlueGreen      []BlueGreenRoute       `json:"bluegreen"`
+	ServiceWeights map[string]int         `json:"service_weights"`
+	DefaultBackend string                 `json:"default_backend,omitempty"`
+	RateLimit      map[string]interface{} `json:"rate_limit"`
+	// Timeouts maps route prefixes, and "" for the default, to durations
+	Timeouts     map[string]string `json:"timeouts"`
+	DrainTimeout string            `json:"drain_timeout"`
+	// SignedURLRoutes lists the routes requiring signed URLs; the secret is
+	// never included
+	SignedURLRoutes []string        `json:"signed_url_routes,omitempty"`
+	Features        map[string]bool `json:"features"`
+}
+
+// SnapshotBreaker is a circuit breaker's state in a snapshot
+type SnapshotBreaker struct {
+	State        string    `json:"state"`
+	Failures     int       `json:"failures"`
+	LastOpenTime time.Time `json:"last_open_time"`
+}
+
+// redactURL hides the password of a URL carrying credentials
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	return u.Redacted()
+}
+
+// Snapshot captures the proxy's routing config and state
+func (app *Application) Snapshot() (Snapshot, error) {
+	servers, err := app.Registry.GetServers()
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("failed to list servers: %w", err)
+	}
+	sort.Slice(servers, func(i, j int) bool { return servers[i].Name < servers[j].Name })
+	for i := range servers {
+		servers[i].BaseURL = redactURL(servers[i].BaseURL)
+	}
+
+	snap := Snapshot{
+		Version:  SnapshotVersion,
+		TakenAt:  app.clock.Now(),
+		Servers:  servers,
+		Health:   app.HealthMonitor.GetAllHealthStatuses(),
+		Breakers: make(map[string]SnapshotBreaker),
+		Cache:    app.Cache.Stats(),
+	}
+	for name, b := range app.CircuitBreaker.GetAllBreakers() {
+		snap.Breakers[name] = SnapshotBreaker{State: b.State.String(), Failures: b.Failures, LastOpenTime: b.LastOpenTime}
+	}
+
+	cfg := SnapshotConfig{
+		BlueGreen:      app.BlueGreen.List(),
+		ServiceWeights: app.ServiceGroups.Weights(),
+		RateLimit:      rateLimitView(app.LimiterConfig()),
+		Timeouts:       make(map[string]string),
+		DrainTimeout:   app.drainTimeout.String(),
+		Features:       make(map[string]bool),
+	}
+	app.Router.mu.Lock()
+	if app.Router.defaultBackend != nil {
+		cfg.DefaultBackend = redactURL(app.Router.defaultBackend.BaseURL)
+	}
+	app.Router.mu.Unlock()
+	if app.timeouts.Default > 0 {
+		cfg.Timeouts[""] = app.timeouts.Default.String()
+	}
+	for prefix, timeout := range app.timeouts.Routes {
+		cfg.Timeouts[prefix] = timeout.String()
+	}
+	if app.signedURLs != nil {
+		cfg.SignedURLRoutes = app.signedURLs.routes
+	}
+	for _, feature := range []string{FeatureRetries, FeatureCoalescing} {
+		cfg.Features[feature] = app.Reliability.Enabled(feature)
+	}
+	snap.Config = cfg
+	return snap, nil
+}
+
+// parseBreakerState is the inverse of BreakerState.String
+func parseBreakerState(s string) (BreakerState, error) {
+	for _, state := range []BreakerState{Closed, Open, HalfOpen} {
+		if state.String() == s {
+			return state, nil
+		}
+	}
+	return Closed, fmt.Errorf("unknown breaker state %q", s)
+}
+
+// LoadSnapshot loads a snapshot's servers, health, breakers, blue/green
+// routes, service weights and default backend, so routing decisions can be
+// replayed. It is meant for an application that has not
+// been started, since health checks would overwrite the loaded health.
+func (app *Application) LoadSnapshot(snap Snapshot) error {
+	if snap.Version != SnapshotVersion {
+		return fmt.Errorf("snapshot version %d is not supported, expected %d", snap.Version, SnapshotVersion)
+	}
+
+	for _, server := range snap.Servers {
+		if err := app.Registry.Register(server); err != nil {
+			return fmt.Errorf("failed to register %s: %w", server.Name, err)
+		}
+	}
+
+	app.HealthMonitor.mu.Lock()
+	for name, status := range snap.Health {
+		status := status
+		app.HealthMonitor.healthMap[name] = &status
+	}
+	app.HealthMonitor.mu.Unlock()
+
+	for name, b := range snap.Breakers {
+		state, err := parseBreakerState(b.State)
+		if err != nil {
+			return fmt.Errorf("breaker %s: %w", name, err)
+		}
+		breaker := app.CircuitBreaker.getOrCreate(name)
+		breaker.mu.Lock()
+		breaker.setState(state)
+		breaker.failures.Store(int64(b.Failures))
+		breaker.lastOpenTime = b.LastOpenTime
+		breaker.mu.Unlock()
+	}
+
+	for _, route := range snap.Config.BlueGreen {
+		if err := app.BlueGreen.Set(route); err != nil {
+			return fmt.Errorf("blue/green route %s: %w", route.Prefix, err)
+		}
+	}
+	for service, weight := range snap.Config.ServiceWeights {
+		if err := app.ServiceGroups.SetWeight(service, weight); err != nil {
+			return err
+		}
+	}
+	app.Router.SetDefaultBackend(snap.Config.DefaultBackend)
+	return nil
+}
+
+// NewReplayApplication creates an in-memory application holding a
+// snapshot's state, with its clock stopped at the time the snapshot was
+// taken so breaker cooldowns are as they were
+func NewReplayApplication(snap Snapshot, logger *slog.Logger) (*Application, error) {
+	app := newApplication(logger, registry.NewRegistry(logger))
+	app.SetClock(NewFakeClock(snap.TakenAt))
+	if err := app.LoadSnapshot(snap); err != nil {
+		return nil, err
+	}
+	return app, nil
+}
+
+// RouteDecision is where the router sent a request path
+type RouteDecision struct {
+	Path      string `json:"path"`
+	Prefix    string `json:"prefix,omitempty"`
+	Server    string `json:"server,omitempty"`
+	TargetURL string `json:"target_url,omitempty"`
+	// Error is why the path could not be routed
+	Error string `json:"error,omitempty"`
+}
+
+// ReplayRoute resolves a path as a request would be, without sending it.
+// Like a request, it advances round robin counters, so replaying a path
+// repeatedly shows how requests are spread.
+func (app *Application) ReplayRoute(ctx context.Context, path string) RouteDecision {
+	backend, err := app.Router.ResolveBackend(ctx, path)
+	if err != nil {
+		return RouteDecision{Path: path, Error: err.Error()}
+	}
+	app.CircuitBreaker.OnRequestComplete(backend.Server.Name)
+	return RouteDecision{Path: path, Prefix: backend.Prefix, Server: backend.Server.Name, TargetURL: backend.TargetURL}
+}
+
+// HandleSnapshot serves GET /admin/snapshot, the proxy's routing config and
+// state as one JSON document that NewReplayApplication can load
+func (app *Application) HandleSnapshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	snap, err := app.Snapshot()
+	if err != nil {
+		app.Logger.ErrorContext(r.Context(), "failed to take snapshot", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="proxy-snapshot.json"`)
+	writeJSON(w, http.StatusOK, snap)
+}
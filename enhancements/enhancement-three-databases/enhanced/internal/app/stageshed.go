@@ -0,0 +1,253 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Optional request stages that can be skipped under load
+const (
+	// StageWasm runs WASM filters, such as WAF inspection
+	StageWasm = "wasm"
+	// StageSchema validates request bodies against route schemas
+	StageSchema = "schema"
+	// StageAssertions checks backend responses against route assertions
+	StageAssertions = "assertions"
+	// StageEnrichment adds geo, TLS, device and subject headers
+	StageEnrichment = "enrichment"
+)
+
+// OptionalStages lists the stages a StageShedConfig may name
+var OptionalStages = []string{StageWasm, StageSchema, StageAssertions, StageEnrichment}
+
+// DefaultStageShedInterval is how often load is sampled when
+// StageShedConfig.Interval is zero
+const DefaultStageShedInterval = 5 * time.Second
+
+// StageShedRecoverySamples is how many samples in a row must be under every
+// threshold before skipped stages run again
+const StageShedRecoverySamples = 3
+
+// StageShedConfig skips expensive optional stages while the proxy is under
+// stress, keeping the forwarding path itself fast
+type StageShedConfig struct {
+	// Stages are the optional stages that may be skipped
+	Stages []string
+	// LatencyThreshold skips the stages while the proxy's average request
+	// time over a sample passes it; 0 ignores latency
+	LatencyThreshold time.Duration
+	// CPUThreshold skips the stages while the proxy's share of its available
+	// CPU over a sample passes it, between 0 and 1; 0 ignores CPU
+	CPUThreshold float64
+	// Interval is how often load is sampled; 0 uses DefaultStageShedInterval
+	Interval time.Duration
+}
+
+// StageShedStats is a point-in-time view of stage shedding
+type StageShedStats struct {
+	Shedding  bool              `json:"shedding"`
+	Stages    []string          `json:"stages"`
+	LatencyMs float64           `json:"latency_ms"`
+	CPU       float64           `json:"cpu"`
+	Since     *time.Time        `json:"since,omitempty"`
+	Skipped   map[string]uint64 `json:"skipped"`
+}
+
+// StageShedder samples the proxy's request latency and CPU use and skips the
+// configured optional stages while either passes its threshold
+type StageShedder struct {
+	cfg    StageShedConfig
+	logger *slog.Logger
+	events *EventBus
+	// monitor supplies CPU use; nil reads it as 0
+	monitor *SelfMonitor
+
+	shedding atomic.Bool
+	// skipped counts skipped stage runs; its keys are fixed at creation
+	skipped map[string]*atomic.Uint64
+
+	// requests and requestNanos accumulate request times since the last
+	// sample
+	requests     atomic.Uint64
+	requestNanos atomic.Int64
+
+	mu      sync.Mutex
+	latency time.Duration
+	cpu     float64
+	since   time.Time
+	calm    int
+}
+
+// NewStageShedder validates cfg and creates a stage shedder
+func NewStageShedder(cfg StageShedConfig, logger *slog.Logger) (*StageShedder, error) {
+	if len(cfg.Stages) == 0 {
+		return nil, fmt.Errorf("at least one optional stage is required")
+	}
+	if cfg.LatencyThreshold < 0 || cfg.Interval < 0 {
+		return nil, fmt.Errorf("stage shed latency threshold and interval must not be negative")
+	}
+	if cfg.CPUThreshold < 0 || cfg.CPUThreshold > 1 {
+		return nil, fmt.Errorf("stage shed CPU threshold must be between 0 and 1")
+	}
+	if cfg.LatencyThreshold == 0 && cfg.CPUThreshold == 0 {
+		return nil, fmt.Errorf("a latency or CPU threshold is required")
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = DefaultStageShedInterval
+	}
+
+	s := &StageShedder{
+		cfg:     cfg,
+		logger:  logger,
+		skipped: make(map[string]*atomic.Uint64, len(cfg.Stages)),
+	}
+	for _, stage := range cfg.Stages {
+		known := false
+		for _, optional := range OptionalStages {
+			known = known || stage == optional
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown optional stage %q", stage)
+		}
+		s.skipped[stage] = &atomic.Uint64{}
+	}
+	return s, nil
+}
+
+// SetStageShedder skips optional stages while the proxy is overloaded. It
+// must be called before Start.
+func (app *Application) SetStageShedder(s *StageShedder) {
+	s.events = app.Events
+	app.StageShed = s
+}
+
+// observe records the time the proxy took over one request
+func (s *StageShedder) observe(d time.Duration) {
+	s.requests.Add(1)
+	s.requestNanos.Add(int64(d))
+}
+
+// sample measures load since the previous sample and starts or stops
+// skipping stages
+func (s *StageShedder) sample(now time.Time) {
+	requests := s.requests.Swap(0)
+	nanos := s.requestNanos.Swap(0)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.latency = 0
+	if requests > 0 {
+		s.latency = time.Duration(nanos / int64(requests))
+	}
+	s.cpu = 0
+	if s.monitor != nil {
+		s.cpu = s.monitor.CPU()
+	}
+
+	overloaded := (s.cfg.LatencyThreshold > 0 && s.latency > s.cfg.LatencyThreshold) ||
+		(s.cfg.CPUThreshold > 0 && s.cpu > s.cfg.CPUThreshold)
+	data := map[string]interface{}{
+		"stages":     s.cfg.Stages,
+		"latency_ms": float64(s.latency) / float64(time.Millisecond),
+		"cpu":        s.cpu,
+	}
+
+	switch {
+	case overloaded:
+		s.calm = 0
+		if !s.shedding.Swap(true) {
+			s.since = now
+			s.logger.Warn("skipping optional stages under load",
+				"stages", s.cfg.Stages, "latency", s.latency, "cpu", s.cpu)
+			s.events.Publish(Event{Type: EventStagesShed, From: "running", To: "skipped", Data: data})
+		}
+	case s.shedding.Load():
+		s.calm++
+		if s.calm >= StageShedRecoverySamples {
+			s.calm = 0
+			s.shedding.Store(false)
+			s.logger.Info("running optional stages again",
+				"stages", s.cfg.Stages, "latency", s.latency, "cpu", s.cpu, "skipped_for", now.Sub(s.since))
+			s.events.Publish(Event{Type: EventStagesShed, From: "skipped", To: "running", Data: data})
+		}
+	}
+}
+
+// Run samples load every interval until ctx is done
+func (s *StageShedder) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.sample(now)
+		}
+	}
+}
+
+// Stats returns the current load and how often each stage was skipped
+func (s *StageShedder) Stats() StageShedStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := StageShedStats{
+		Shedding:  s.shedding.Load(),
+		Stages:    s.cfg.Stages,
+		LatencyMs: float64(s.latency) / float64(time.Millisecond),
+		CPU:       s.cpu,
+		Skipped:   make(map[string]uint64, len(s.skipped)),
+	}
+	if stats.Shedding {
+		since := s.since
+		stats.Since = &since
+	}
+	for stage, count := range s.skipped {
+		stats.Skipped[stage] = count.Load()
+	}
+	return stats
+}
+
+// skipStage reports whether an optional stage should be skipped for r
+// because the proxy is overloaded, counting and logging each skip
+func (app *Application) skipStage(r *http.Request, stage string) bool {
+	s := app.StageShed
+	if s == nil || !s.shedding.Load() {
+		return false
+	}
+	count, ok := s.skipped[stage]
+	if !ok {
+		return false
+	}
+	count.Add(1)
+	app.Logger.DebugContext(r.Context(), "optional stage skipped under load", "stage", stage, "path", r.URL.Path)
+	return true
+}
+
+// HandleStageShedMetrics serves GET /admin/metrics/stages
+func (app *Application) HandleStageShedMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if app.StageShed == nil {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": false})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"enabled":           true,
+		"latency_threshold": app.StageShed.cfg.LatencyThreshold.String(),
+		"cpu_threshold":     app.StageShed.cfg.CPUThreshold,
+		"stats":             app.StageShed.Stats(),
+	})
+}
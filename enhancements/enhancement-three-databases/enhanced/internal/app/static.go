@@ -0,0 +1,94 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultStaticMaxAge is the Cache-Control max-age used for static files
+const DefaultStaticMaxAge = 5 * time.Minute
+
+// StaticRoute serves files from a local directory under a route prefix.
+// Index files, Range requests and conditional requests are handled by
+// http.FileServer.
+type StaticRoute struct {
+	Prefix string        `json:"prefix"`
+	Dir    string        `json:"dir"`
+	MaxAge time.Duration `json:"max_age"`
+	server http.Handler
+}
+
+// StaticRoutes holds the static routes configured on the proxy
+type StaticRoutes struct {
+	mu     sync.RWMutex
+	routes map[string]*StaticRoute
+}
+
+// NewStaticRoutes creates an empty set of static routes
+func NewStaticRoutes() *StaticRoutes {
+	return &StaticRoutes{routes: make(map[string]*StaticRoute)}
+}
+
+// Add serves dir under prefix with the given cache max-age
+func (sr *StaticRoutes) Add(prefix, dir string, maxAge time.Duration) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("static route %s: %w", prefix, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("static route %s: %s is not a directory", prefix, dir)
+	}
+
+	route := &StaticRoute{
+		Prefix: prefix,
+		Dir:    dir,
+		MaxAge: maxAge,
+		server: http.StripPrefix(strings.TrimSuffix(prefix, "/"), http.FileServer(http.Dir(dir))),
+	}
+
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+
+	sr.routes[prefix] = route
+	return nil
+}
+
+// match returns the static route with the longest prefix matching path
+func (sr *StaticRoutes) match(path string) *StaticRoute {
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+
+	var best *StaticRoute
+	for prefix, route := range sr.routes {
+		if strings.HasPrefix(path, prefix) && (best == nil || len(prefix) > len(best.Prefix)) {
+			best = route
+		}
+	}
+	return best
+}
+
+// serveStatic serves the request from a static route if one matches and
+// reports whether it did
+func (app *Application) serveStatic(w http.ResponseWriter, r *http.Request) bool {
+	route := app.Static.match(r.URL.Path)
+	if route == nil {
+		return false
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		app.writeError(w, r, CodeMethodNotAllowed, "static content only supports GET and HEAD")
+		return true
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(route.MaxAge.Seconds())))
+	route.server.ServeHTTP(w, r)
+
+	app.Logger.DebugContext(r.Context(), "served static content", "path", r.URL.Path, "dir", route.Dir)
+	return true
+}
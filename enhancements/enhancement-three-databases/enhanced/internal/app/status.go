@@ -0,0 +1,61 @@
+package app
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/codytheroux96/go-reverse-proxy/db/migrations"
+	"github.com/codytheroux96/go-reverse-proxy/internal/migrate"
+	"github.com/codytheroux96/go-reverse-proxy/internal/registry"
+)
+
+// HandleStatus serves GET /admin/status, reporting the registry backend, the
+// proxy's own resource use, certificate expiry and, for PostgreSQL, the schema version against
+// the embedded migrations
+func (app *Application) HandleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	resp := map[string]interface{}{"registry": "memory"}
+	if reg, ok := app.Registry.(interface{ DB() *sql.DB }); ok {
+		resp["registry"] = "postgres"
+		schema, err := migrate.GetStatus(r.Context(), reg.DB(), migrations.FS)
+		if err != nil {
+			app.Logger.ErrorContext(r.Context(), "failed to read schema version", "error", err)
+			http.Error(w, "failed to read schema version", http.StatusInternalServerError)
+			return
+		}
+		resp["schema"] = schema
+	}
+
+	app.Probes.mu.RLock()
+	if app.Probes.started {
+		resp["started_at"] = app.Probes.startedAt
+	}
+	app.Probes.mu.RUnlock()
+	resp["runtime"] = app.SelfMonitor.Stats()
+	resp["certificates"] = app.Certificates.Expiries()
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// HandleDatabaseMetrics serves GET /admin/metrics/database, the registry's
+// connection pool stats and database availability
+func (app *Application) HandleDatabaseMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	reg, ok := app.Registry.(interface{ PoolStats() registry.PoolStats })
+	if !ok {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": false})
+		return
+	}
+	resp := map[string]interface{}{"enabled": true, "pool": reg.PoolStats()}
+	if failover, ok := app.Registry.(interface{ FailoverStats() registry.FailoverStats }); ok {
+		resp["failover"] = failover.FailoverStats()
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,164 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// BackendTimeout bounds each attempt at a backend request, from dialing to
+// the end of a buffered response's body. A streamed response is only bounded
+// until its headers arrive, and then runs as long as the client listens.
+const BackendTimeout = 10 * time.Second
+
+// errBackendTimeout cancels an attempt that ran past BackendTimeout
+var errBackendTimeout = fmt.Errorf("backend attempt exceeded %s: %w", BackendTimeout, context.DeadlineExceeded)
+
+// backendAttempt is the context of one attempt at a backend request,
+// cancelled once BackendTimeout passes
+type backendAttempt struct {
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	timer  *time.Timer
+}
+
+// newBackendAttempt starts an attempt's deadline
+func newBackendAttempt(ctx context.Context) *backendAttempt {
+	ctx, cancel := context.WithCancelCause(ctx)
+	return &backendAttempt{
+		ctx:    ctx,
+		cancel: cancel,
+		timer:  time.AfterFunc(BackendTimeout, func() { cancel(errBackendTimeout) }),
+	}
+}
+
+// end releases an attempt whose response will not be read
+func (a *backendAttempt) end() {
+	a.timer.Stop()
+	a.cancel(nil)
+}
+
+// hold hands the attempt to resp, ending it when the body is closed. A
+// stream loses its deadline, and so does an upgrade, whose body is the
+// connection and is left unwrapped.
+func (a *backendAttempt) hold(resp *http.Response) {
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		a.timer.Stop()
+		return
+	}
+	if isStreamingResponse(resp) {
+		a.timer.Stop()
+	}
+	resp.Body = &attemptBody{ReadCloser: resp.Body, attempt: a}
+}
+
+// attemptBody ends its attempt when closed
+type attemptBody struct {
+	io.ReadCloser
+	attempt *backendAttempt
+}
+
+func (b *attemptBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.attempt.end()
+	return err
+}
+
+// TimeoutConfig bounds how long the proxy spends on a request overall,
+// including retries and backoff, independently of BackendTimeout
+type TimeoutConfig struct {
+	// Default applies to every request without a route timeout; 0 disables it
+	Default time.Duration
+	// Routes maps a route prefix to its timeout, overriding Default
+	Routes map[string]time.Duration
+}
+
+// SetRequestTimeouts configures per-request timeouts and installs the timeout
+// middleware; it must be called before Handler
+func (app *Application) SetRequestTimeouts(cfg TimeoutConfig) {
+	app.timeouts = cfg
+	app.Use(app.Timeout)
+}
+
+// timeoutFor returns the timeout for a path, preferring the longest matching
+// route prefix
+func (app *Application) timeoutFor(path string) time.Duration {
+	longest := ""
+	for prefix := range app.timeouts.Routes {
+		if strings.HasPrefix(path, prefix) && len(prefix) > len(longest) {
+			longest = prefix
+		}
+	}
+	if longest != "" {
+		return app.timeouts.Routes[longest]
+	}
+	return app.timeouts.Default
+}
+
+// Timeout attaches the request's deadline to its context; forwarding stops
+// when it passes and the handler answers 504
+func (app *Application) Timeout(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout := app.timeoutFor(r.URL.Path)
+		if timeout <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// upstreamTimedOut reports whether forwarding failed because the request
+// timeout passed. It answers 504 and, when countFailure is set, records the
+// timeout as a breaker failure.
+func (app *Application) upstreamTimedOut(w http.ResponseWriter, r *http.Request, backend *BackendInfo, err error, countFailure bool) bool {
+	if err == nil || !errors.Is(r.Context().Err(), context.DeadlineExceeded) {
+		return false
+	}
+
+	if countFailure {
+		app.CircuitBreaker.OnFailure(backend.Server.Name)
+	}
+	app.CircuitBreaker.OnRequestComplete(backend.Server.Name)
+	app.Logger.WarnContext(r.Context(), "request timed out waiting for backend",
+		"server", backend.Server.Name,
+		"path", r.URL.Path,
+		"timeout", app.timeoutFor(r.URL.Path))
+	app.runErrorPlugins(r, err)
+	app.writeError(w, r, CodeUpstreamTimeout, "the backend did not respond in time")
+	return true
+}
+
+// ParseRouteTimeouts parses a comma separated list of prefix=duration pairs,
+// for example "/s1=2s,/reports=30s"
+func ParseRouteTimeouts(spec string) (map[string]time.Duration, error) {
+	routes := make(map[string]time.Duration)
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		prefix, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid route timeout %q, expected prefix=duration", part)
+		}
+
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid timeout %q for %s", value, prefix)
+		}
+		routes[prefix] = timeout
+	}
+
+	return routes, nil
+}
@@ -0,0 +1,43 @@
+package app
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestBackendAttemptDropsDeadlineForStreams(t *testing.T) {
+	tests := []struct {
+		contentType string
+		deadline    bool
+	}{
+		{"application/json", true},
+		{"text/event-stream", false},
+		{"application/x-ndjson", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			attempt := newBackendAttempt(context.Background())
+			resp := &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": {tt.contentType}},
+				Body:       io.NopCloser(strings.NewReader("data")),
+			}
+			attempt.hold(resp)
+
+			// Stop reports whether the deadline was still pending
+			if pending := attempt.timer.Stop(); pending != tt.deadline {
+				t.Fatalf("deadline pending = %v, want %v", pending, tt.deadline)
+			}
+			if attempt.ctx.Err() != nil {
+				t.Fatal("attempt ended before its body was closed")
+			}
+			resp.Body.Close()
+			if attempt.ctx.Err() == nil {
+				t.Fatal("closing the body did not end the attempt")
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package app
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// tlsVersions maps policy min_version values to TLS versions
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLSPolicy restricts how requests for a route may reach the proxy. Host
+// scopes the policy to one tenant's hostname; empty matches every host.
+type TLSPolicy struct {
+	Host   string `json:"host,omitempty"`
+	Prefix string `json:"prefix"`
+	// AllowPlaintext lets the route be served on the plaintext internal
+	// listener as well as over TLS
+	AllowPlaintext bool `json:"allow_plaintext,omitempty"`
+	// MinVersion is "1.2" or "1.3"; empty accepts any version the listener does
+	MinVersion string `json:"min_version,omitempty"`
+	// RequireClientCert requires a client certificate signed by the
+	// listener's client CA
+	RequireClientCert bool `json:"require_client_cert,omitempty"`
+	// ClientCommonNames limits which client certificates are accepted by
+	// subject common name; empty accepts any verified certificate
+	ClientCommonNames []string `json:"client_common_names,omitempty"`
+}
+
+// tlsPolicy is a validated TLSPolicy
+type tlsPolicy struct {
+	TLSPolicy
+	minVersion  uint16
+	commonNames map[string]bool
+}
+
+// TLSPolicies enforces per-route TLS requirements. Requests matching no
+// policy must arrive over TLS.
+type TLSPolicies struct {
+	policies []tlsPolicy
+}
+
+// LoadTLSPolicies reads a JSON array of TLS policies
+func LoadTLSPolicies(path string) ([]TLSPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tls policies: %w", err)
+	}
+
+	var policies []TLSPolicy
+	if err := json.Unmarshal(data, &policies); err != nil {
+		return nil, fmt.Errorf("failed to parse tls policies: %w", err)
+	}
+	return policies, nil
+}
+
+// NewTLSPolicies validates a set of TLS policies
+func NewTLSPolicies(policies []TLSPolicy) (*TLSPolicies, error) {
+	tp := &TLSPolicies{}
+	seen := make(map[string]bool)
+
+	for _, policy := range policies {
+		if !strings.HasPrefix(policy.Prefix, "/") {
+			return nil, fmt.Errorf("tls policy prefix %q must start with /", policy.Prefix)
+		}
+		policy.Host = strings.ToLower(policy.Host)
+
+		key := policy.Host + policy.Prefix
+		if seen[key] {
+			return nil, fmt.Errorf("duplicate tls policy for %s", key)
+		}
+		seen[key] = true
+
+		compiled := tlsPolicy{TLSPolicy: policy}
+		if policy.MinVersion != "" {
+			version, ok := tlsVersions[policy.MinVersion]
+			if !ok {
+				return nil, fmt.Errorf("tls policy for %s: min_version must be 1.2 or 1.3", key)
+			}
+			compiled.minVersion = version
+		}
+		if len(policy.ClientCommonNames) > 0 {
+			if !policy.RequireClientCert {
+				return nil, fmt.Errorf("tls policy for %s: client_common_names needs require_client_cert", key)
+			}
+			compiled.commonNames = make(map[string]bool, len(policy.ClientCommonNames))
+			for _, name := range policy.ClientCommonNames {
+				compiled.commonNames[name] = true
+			}
+		}
+		if policy.AllowPlaintext && (compiled.minVersion != 0 || policy.RequireClientCert) {
+			return nil, fmt.Errorf("tls policy for %s: allow_plaintext conflicts with its TLS requirements", key)
+		}
+
+		tp.policies = append(tp.policies, compiled)
+	}
+
+	return tp, nil
+}
+
+// Policies returns the configured policies
+func (tp *TLSPolicies) Policies() []TLSPolicy {
+	policies := make([]TLSPolicy, len(tp.policies))
+	for i, policy := range tp.policies {
+		policies[i] = policy.TLSPolicy
+	}
+	return policies
+}
+
+// RequiresClientCerts reports whether any policy requires a client
+// certificate, so the listener must ask for one
+func (tp *TLSPolicies) RequiresClientCerts() bool {
+	for _, policy := range tp.policies {
+		if policy.RequireClientCert {
+			return true
+		}
+	}
+	return false
+}
+
+// hostName returns a Host header's hostname, lowercased and without a port
+func hostName(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// policyHost returns the hostname a request's policy is chosen by. Over TLS
+// that is the SNI server name the connection was set up for, since the Host
+// header is the client's to choose, and a Host naming another server is
+// refused. Clients send no SNI for IP addresses, so without one the Host
+// must be an IP address. It reports false for a misdirected request.
+func policyHost(r *http.Request) (string, bool) {
+	host := hostName(r.Host)
+	if r.TLS == nil {
+		return host, true
+	}
+
+	sni := strings.ToLower(strings.TrimSuffix(r.TLS.ServerName, "."))
+	if sni == "" {
+		return "", net.ParseIP(host) != nil
+	}
+	return sni, sni == host
+}
+
+// match returns the policy for a request: a policy for the request's host
+// beats one for every host, then the longest matching prefix wins
+func (tp *TLSPolicies) match(host, path string) *tlsPolicy {
+	var best *tlsPolicy
+	for i := range tp.policies {
+		policy := &tp.policies[i]
+		if (policy.Host != "" && policy.Host != host) || !strings.HasPrefix(path, policy.Prefix) {
+			continue
+		}
+		if best == nil || policy.moreSpecific(best) {
+			best = policy
+		}
+	}
+	return best
+}
+
+// moreSpecific reports whether p should win over other when both match
+func (p *tlsPolicy) moreSpecific(other *tlsPolicy) bool {
+	if (p.Host != "") != (other.Host != "") {
+		return p.Host != ""
+	}
+	return len(p.Prefix) > len(other.Prefix)
+}
+
+// enforceTLSPolicy rejects requests that arrived in a way their route's TLS
+// policy does not allow: plaintext or too old a TLS version gets 426, and a
+// missing or unaccepted client certificate gets 403. A TLS request whose
+// Host does not match its SNI server name gets 421, so a client cannot pick
+// a laxer host's policy. It reports whether the request may continue.
+func (app *Application) enforceTLSPolicy(w http.ResponseWriter, r *http.Request) bool {
+	tp := app.TLSPolicies
+	if tp == nil {
+		return true
+	}
+
+	host, ok := policyHost(r)
+	if !ok {
+		app.Logger.InfoContext(r.Context(), "tls policy violated", "path", r.URL.Path, "host", r.Host, "reason", "sni_mismatch",
+			"server_name", r.TLS.ServerName)
+		app.writeError(w, r, CodeMisdirectedRequest, "the request's host does not match the TLS server name")
+		return false
+	}
+	policy := tp.match(host, r.URL.Path)
+
+	if r.TLS == nil {
+		if policy != nil && policy.AllowPlaintext {
+			return true
+		}
+		app.Logger.InfoContext(r.Context(), "tls policy violated", "path", r.URL.Path, "host", r.Host, "reason", "plaintext")
+		w.Header().Set("Upgrade", "TLS/1.2, HTTP/1.1")
+		w.Header().Set("Connection", "Upgrade")
+		app.writeError(w, r, CodeTLSRequired, "this route is only served over TLS")
+		return false
+	}
+
+	if policy == nil {
+		return true
+	}
+
+	if r.TLS.Version < policy.minVersion {
+		app.Logger.InfoContext(r.Context(), "tls policy violated", "path", r.URL.Path, "host", r.Host, "reason", "tls_version",
+			"version", tls.VersionName(r.TLS.Version), "min_version", policy.MinVersion)
+		w.Header().Set("Upgrade", "TLS/"+policy.MinVersion+", HTTP/1.1")
+		w.Header().Set("Connection", "Upgrade")
+		app.writeError(w, r, CodeTLSRequired, "this route requires TLS "+policy.MinVersion+" or newer")
+		return false
+	}
+
+	if policy.RequireClientCert {
+		if len(r.TLS.VerifiedChains) == 0 {
+			app.Logger.InfoContext(r.Context(), "tls policy violated", "path", r.URL.Path, "host", r.Host, "reason", "client_cert_missing")
+			app.writeError(w, r, CodeClientCertRequired, "this route requires a client certificate")
+			return false
+		}
+		if cn := r.TLS.VerifiedChains[0][0].Subject.CommonName; policy.commonNames != nil && !policy.commonNames[cn] {
+			app.Logger.InfoContext(r.Context(), "tls policy violated", "path", r.URL.Path, "host", r.Host, "reason", "client_cert_rejected", "common_name", cn)
+			app.writeError(w, r, CodeClientCertRequired, "the client certificate is not accepted for this route")
+			return false
+		}
+	}
+
+	return true
+}
+
+// HandleTLSPolicies serves GET /admin/tls-policies
+func (app *Application) HandleTLSPolicies(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if app.TLSPolicies == nil {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": false, "policies": []TLSPolicy{}})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": true, "policies": app.TLSPolicies.Policies()})
+}
@@ -0,0 +1,53 @@
+package app
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTLSPolicyChosenBySNI(t *testing.T) {
+	app := newTestApp(t)
+	policies, err := NewTLSPolicies([]TLSPolicy{
+		{Host: "acme.example.com", Prefix: "/", RequireClientCert: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.TLSPolicies = policies
+
+	tests := []struct {
+		name   string
+		host   string
+		sni    string
+		status int
+	}{
+		{"policy host without certificate", "acme.example.com", "acme.example.com", http.StatusForbidden},
+		{"host with port", "acme.example.com:8443", "acme.example.com", http.StatusForbidden},
+		{"other host", "globex.example.com", "globex.example.com", 0},
+		{"host naming a laxer server", "globex.example.com", "acme.example.com", http.StatusMisdirectedRequest},
+		{"sni naming a laxer server", "acme.example.com", "globex.example.com", http.StatusMisdirectedRequest},
+		{"no sni with a hostname", "globex.example.com", "", http.StatusMisdirectedRequest},
+		{"no sni with an address", "203.0.113.7:8443", "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/orders", nil)
+			r.Host = tt.host
+			r.TLS = &tls.ConnectionState{Version: tls.VersionTLS13, ServerName: tt.sni}
+			rec := httptest.NewRecorder()
+
+			allowed := app.enforceTLSPolicy(rec, r)
+			if tt.status == 0 {
+				if !allowed {
+					t.Fatalf("rejected with %d, want allowed", rec.Code)
+				}
+				return
+			}
+			if allowed || rec.Code != tt.status {
+				t.Fatalf("allowed %v, status %d; want rejected with %d", allowed, rec.Code, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,327 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Traffic accounting defaults applied to zero TrafficSummaryConfig fields
+const (
+	DefaultTrafficSummaryInterval = 5 * time.Minute
+	DefaultPayloadGrowthFactor    = 2.0
+)
+
+// MaxTrafficClients bounds the client keys tracked; traffic from further
+// clients is counted under TrafficOtherClients
+const MaxTrafficClients = 10000
+
+// TrafficOtherClients is the client key that traffic past
+// MaxTrafficClients is counted under
+const TrafficOtherClients = "(other)"
+
+// TrafficUnrouted is the route and backend of requests the proxy answered
+// without routing them, such as cache hits and rejections
+const TrafficUnrouted = "(unrouted)"
+
+// trafficSummaryTop is how many routes a summary log line names
+const trafficSummaryTop = 5
+
+// minGrowthRequests is how many requests a route needs in a summary window
+// before its payload size is compared with its baseline
+const minGrowthRequests = 20
+
+// TrafficSummaryConfig controls the periodic traffic summary log
+type TrafficSummaryConfig struct {
+	// Interval is how often the summary is logged; 0 uses
+	// DefaultTrafficSummaryInterval
+	Interval time.Duration
+	// GrowthFactor warns about a route whose average response size in a
+	// summary window passes its baseline by this factor; 0 uses
+	// DefaultPayloadGrowthFactor
+	GrowthFactor float64
+}
+
+// TrafficCounters is the traffic of a route, backend or client
+type TrafficCounters struct {
+	Requests uint64 `json:"requests"`
+	// BytesIn counts request body bytes received from clients
+	BytesIn uint64 `json:"bytes_in"`
+	// BytesOut counts response body bytes sent to clients
+	BytesOut uint64 `json:"bytes_out"`
+}
+
+// ClientTraffic is the traffic of one client key
+type ClientTraffic struct {
+	Client string `json:"client"`
+	TrafficCounters
+}
+
+// trafficCounters are TrafficCounters updated with atomics
+type trafficCounters struct {
+	requests atomic.Uint64
+	bytesIn  atomic.Uint64
+	bytesOut atomic.Uint64
+
+	// windowRequests and windowBytesOut count since the last summary
+	windowRequests atomic.Uint64
+	windowBytesOut atomic.Uint64
+}
+
+func (c *trafficCounters) add(in, out int64) {
+	c.requests.Add(1)
+	c.bytesIn.Add(uint64(in))
+	c.bytesOut.Add(uint64(out))
+	c.windowRequests.Add(1)
+	c.windowBytesOut.Add(uint64(out))
+}
+
+func (c *trafficCounters) snapshot() TrafficCounters {
+	return TrafficCounters{Requests: c.requests.Load(), BytesIn: c.bytesIn.Load(), BytesOut: c.bytesOut.Load()}
+}
+
+// TrafficMetrics counts request and response bytes per route, backend and
+// client key, for egress cost attribution
+type TrafficMetrics struct {
+	mu       sync.RWMutex
+	routes   map[string]*trafficCounters
+	backends map[string]*trafficCounters
+	clients  map[string]*trafficCounters
+	// templates labels routes, so unknown ones share RouteLabelOther
+	templates *RouteTemplates
+
+	// baselines are the average response size of each route that summaries
+	// compare against; only the summary loop uses them
+	baselines map[string]float64
+}
+
+// NewTrafficMetrics creates empty traffic counters, labelling routes by
+// templates
+func NewTrafficMetrics(templates *RouteTemplates) *TrafficMetrics {
+	return &TrafficMetrics{
+		templates: templates,
+		routes:    make(map[string]*trafficCounters),
+		backends:  make(map[string]*trafficCounters),
+		clients:   make(map[string]*trafficCounters),
+		baselines: make(map[string]float64),
+	}
+}
+
+// counters returns the counters for key, creating them if needed. Once m
+// holds limit keys, new keys share the counters of overflow.
+func (tm *TrafficMetrics) counters(m map[string]*trafficCounters, key string, limit int, overflow string) *trafficCounters {
+	tm.mu.RLock()
+	c, exists := m[key]
+	tm.mu.RUnlock()
+	if exists {
+		return c
+	}
+
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	if c, exists = m[key]; exists {
+		return c
+	}
+	if limit > 0 && len(m) >= limit {
+		key = overflow
+		if c, exists = m[key]; exists {
+			return c
+		}
+	}
+	c = &trafficCounters{}
+	m[key] = c
+	return c
+}
+
+// Observe records a request's body bytes in and out against its route,
+// backend and client key
+func (tm *TrafficMetrics) Observe(route, backend, client string, in, out int64) {
+	route = tm.templates.Label(route)
+	if route == "" {
+		route = TrafficUnrouted
+	}
+	if backend == "" {
+		backend = TrafficUnrouted
+	}
+	tm.counters(tm.routes, route, 0, "").add(in, out)
+	tm.counters(tm.backends, backend, 0, "").add(in, out)
+	tm.counters(tm.clients, client, MaxTrafficClients, TrafficOtherClients).add(in, out)
+}
+
+// snapshotMap copies a counter map
+func (tm *TrafficMetrics) snapshotMap(m map[string]*trafficCounters) map[string]TrafficCounters {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+
+	snapshot := make(map[string]TrafficCounters, len(m))
+	for key, c := range m {
+		snapshot[key] = c.snapshot()
+	}
+	return snapshot
+}
+
+// Routes returns the traffic of every route prefix
+func (tm *TrafficMetrics) Routes() map[string]TrafficCounters {
+	return tm.snapshotMap(tm.routes)
+}
+
+// Backends returns the traffic of every backend
+func (tm *TrafficMetrics) Backends() map[string]TrafficCounters {
+	return tm.snapshotMap(tm.backends)
+}
+
+// TopClients returns the n client keys that received the most bytes
+func (tm *TrafficMetrics) TopClients(n int) []ClientTraffic {
+	snapshot := tm.snapshotMap(tm.clients)
+	clients := make([]ClientTraffic, 0, len(snapshot))
+	for client, counters := range snapshot {
+		clients = append(clients, ClientTraffic{Client: client, TrafficCounters: counters})
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		if clients[i].BytesOut != clients[j].BytesOut {
+			return clients[i].BytesOut > clients[j].BytesOut
+		}
+		return clients[i].Client < clients[j].Client
+	})
+	if len(clients) > n {
+		clients = clients[:n]
+	}
+	return clients
+}
+
+// summarize logs the traffic of each route since the last summary and warns
+// about routes whose average response grew past factor times its baseline
+func (tm *TrafficMetrics) summarize(logger *slog.Logger, factor float64, interval time.Duration) {
+	type routeTotal struct {
+		route    string
+		requests uint64
+		bytesOut uint64
+	}
+
+	tm.mu.RLock()
+	totals := make([]routeTotal, 0, len(tm.routes))
+	for route, c := range tm.routes {
+		totals = append(totals, routeTotal{route: route, requests: c.windowRequests.Swap(0), bytesOut: c.windowBytesOut.Swap(0)})
+	}
+	tm.mu.RUnlock()
+
+	var requests, bytesOut uint64
+	for _, t := range totals {
+		requests += t.requests
+		bytesOut += t.bytesOut
+		if t.requests < minGrowthRequests {
+			continue
+		}
+
+		avg := float64(t.bytesOut) / float64(t.requests)
+		baseline := tm.baselines[t.route]
+		if baseline > 0 && avg > baseline*factor {
+			logger.Warn("response size grew past its baseline",
+				"route", t.route, "avg_bytes", int64(avg), "baseline_bytes", int64(baseline), "requests", t.requests)
+		}
+		// The baseline follows sustained changes over a few windows
+		if baseline == 0 {
+			tm.baselines[t.route] = avg
+		} else {
+			tm.baselines[t.route] = baseline*0.75 + avg*0.25
+		}
+	}
+
+	sort.Slice(totals, func(i, j int) bool { return totals[i].bytesOut > totals[j].bytesOut })
+	top := make([]string, 0, trafficSummaryTop)
+	for i := 0; i < len(totals) && i < trafficSummaryTop && totals[i].bytesOut > 0; i++ {
+		top = append(top, fmt.Sprintf("%s=%d", totals[i].route, totals[i].bytesOut))
+	}
+	logger.Info("traffic summary",
+		"interval", interval, "requests", requests, "bytes_out", bytesOut, "top_routes_bytes_out", top)
+}
+
+// RunSummary logs a traffic summary every interval until ctx is done
+func (tm *TrafficMetrics) RunSummary(ctx context.Context, logger *slog.Logger, cfg TrafficSummaryConfig) {
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			tm.summarize(logger, cfg.GrowthFactor, cfg.Interval)
+		}
+	}
+}
+
+// SetTrafficSummary logs a summary of the traffic per route every interval,
+// warning about routes whose response size grows. It must be called before
+// Start.
+func (app *Application) SetTrafficSummary(cfg TrafficSummaryConfig) error {
+	if cfg.Interval < 0 || cfg.GrowthFactor < 0 {
+		return fmt.Errorf("traffic summary interval and growth factor must not be negative")
+	}
+	if cfg.GrowthFactor != 0 && cfg.GrowthFactor <= 1 {
+		return fmt.Errorf("payload growth factor must be greater than 1")
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = DefaultTrafficSummaryInterval
+	}
+	if cfg.GrowthFactor == 0 {
+		cfg.GrowthFactor = DefaultPayloadGrowthFactor
+	}
+	app.trafficSummary = &cfg
+	return nil
+}
+
+// countingBody counts the bytes read from a request body
+type countingBody struct {
+	io.ReadCloser
+	n atomic.Int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n.Add(int64(n))
+	return n, err
+}
+
+// trafficClient returns the key a request's traffic is attributed to: the
+// policy's rate limiting key, or the client IP
+func (app *Application) trafficClient(r *http.Request, clientIP string) string {
+	if key := app.rateLimitKey(r); key != "" {
+		return key
+	}
+	return clientIP
+}
+
+// HandleTrafficMetrics serves GET /admin/metrics/traffic with the bytes in
+// and out per route and backend and for the top clients; ?clients=N sets how
+// many clients are listed
+func (app *Application) HandleTrafficMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := 100
+	if v := r.URL.Query().Get("clients"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "clients must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"routes":   app.Traffic.Routes(),
+		"backends": app.Traffic.Backends(),
+		"clients":  app.Traffic.TopClients(limit),
+	})
+}
@@ -0,0 +1,233 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errConnExpired fails the first write of a request on a connection past its
+// max lifetime. Nothing has been written yet, so the transport retries the
+// request on a new connection.
+var errConnExpired = errors.New("upstream connection exceeded its max lifetime")
+
+// UpstreamConnConfig bounds how long pooled backend connections are reused,
+// so connections silently dropped by firewalls or load balancers between
+// the proxy and a backend are retired before a request lands on them
+type UpstreamConnConfig struct {
+	// MaxLifetime retires a connection before its next request once it is
+	// this old; 0 reuses connections indefinitely
+	MaxLifetime time.Duration
+	// IdleTimeout closes connections idle for this long; 0 keeps the
+	// transport default of 90s
+	IdleTimeout time.Duration
+	// KeepAlive is the TCP keep-alive probe interval, which keeps idle
+	// connections alive through stateful middleboxes; 0 keeps the default
+	// of 30s
+	KeepAlive time.Duration
+}
+
+// connCounters counts connection churn for one backend address
+type connCounters struct {
+	opened  atomic.Uint64
+	closed  atomic.Uint64
+	expired atomic.Uint64
+	active  atomic.Int64
+}
+
+// UpstreamConns tracks pooled backend connections and retires them by age
+type UpstreamConns struct {
+	cfg   UpstreamConnConfig
+	clock Clock
+
+	total connCounters
+	mu    sync.Mutex
+	hosts map[string]*connCounters
+}
+
+// NewUpstreamConns validates cfg and creates a connection tracker
+func NewUpstreamConns(cfg UpstreamConnConfig) (*UpstreamConns, error) {
+	if cfg.MaxLifetime < 0 || cfg.IdleTimeout < 0 || cfg.KeepAlive < 0 {
+		return nil, fmt.Errorf("upstream connection max lifetime, idle timeout and keep-alive must not be negative")
+	}
+	return &UpstreamConns{cfg: cfg, clock: SystemClock, hosts: make(map[string]*connCounters)}, nil
+}
+
+// counters returns the churn counters for a backend address
+func (uc *UpstreamConns) counters(addr string) *connCounters {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+
+	c, exists := uc.hosts[addr]
+	if !exists {
+		c = &connCounters{}
+		uc.hosts[addr] = c
+	}
+	return c
+}
+
+// wrapDial returns a dial function that tracks the connections dial opens
+func (uc *UpstreamConns) wrapDial(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		if tcp, ok := conn.(*net.TCPConn); ok && uc.cfg.KeepAlive > 0 {
+			tcp.SetKeepAlive(true)
+			tcp.SetKeepAlivePeriod(uc.cfg.KeepAlive)
+		}
+
+		host := uc.counters(addr)
+		for _, c := range []*connCounters{&uc.total, host} {
+			c.opened.Add(1)
+			c.active.Add(1)
+		}
+		return &trackedConn{Conn: conn, uc: uc, host: host, opened: uc.clock.Now()}, nil
+	}
+}
+
+// trackedConn is a backend connection that counts its closing and refuses
+// to start a request once it is past the max lifetime
+type trackedConn struct {
+	net.Conn
+	uc     *UpstreamConns
+	host   *connCounters
+	opened time.Time
+
+	mu sync.Mutex
+	// lastRead is set once a read returns data, so the next write starts a
+	// new request rather than continuing a request body
+	lastRead bool
+	expired  bool
+	closed   atomic.Bool
+}
+
+func (c *trackedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.mu.Lock()
+		c.lastRead = true
+		c.mu.Unlock()
+	}
+	return n, err
+}
+
+func (c *trackedConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	startsRequest := c.lastRead
+	c.lastRead = false
+	expired := startsRequest && c.uc.cfg.MaxLifetime > 0 && c.uc.clock.Since(c.opened) >= c.uc.cfg.MaxLifetime
+	c.expired = c.expired || expired
+	c.mu.Unlock()
+
+	if expired {
+		c.Close()
+		return 0, errConnExpired
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *trackedConn) Close() error {
+	if c.closed.CompareAndSwap(false, true) {
+		c.mu.Lock()
+		expired := c.expired
+		c.mu.Unlock()
+
+		for _, counters := range []*connCounters{&c.uc.total, c.host} {
+			counters.closed.Add(1)
+			counters.active.Add(-1)
+			if expired {
+				counters.expired.Add(1)
+			}
+		}
+	}
+	return c.Conn.Close()
+}
+
+// UpstreamConnStats reports connection churn
+type UpstreamConnStats struct {
+	Opened  uint64 `json:"opened"`
+	Closed  uint64 `json:"closed"`
+	Expired uint64 `json:"expired"`
+	Active  int64  `json:"active"`
+}
+
+func (c *connCounters) stats() UpstreamConnStats {
+	return UpstreamConnStats{
+		Opened:  c.opened.Load(),
+		Closed:  c.closed.Load(),
+		Expired: c.expired.Load(),
+		Active:  c.active.Load(),
+	}
+}
+
+// Stats returns the total churn and the churn per backend address
+func (uc *UpstreamConns) Stats() (UpstreamConnStats, map[string]UpstreamConnStats) {
+	uc.mu.Lock()
+	addrs := make([]string, 0, len(uc.hosts))
+	for addr := range uc.hosts {
+		addrs = append(addrs, addr)
+	}
+	uc.mu.Unlock()
+	sort.Strings(addrs)
+
+	hosts := make(map[string]UpstreamConnStats, len(addrs))
+	for _, addr := range addrs {
+		hosts[addr] = uc.counters(addr).stats()
+	}
+	return uc.total.stats(), hosts
+}
+
+// upstreamTransport returns a transport for backend requests that waits
+// BackendTimeout for response headers, and dials through the resolver and
+// tracks connections when those are set
+func (app *Application) upstreamTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = BackendTimeout
+	if app.Resolver != nil {
+		transport.DialContext = app.Resolver.DialContext
+	}
+	if uc := app.UpstreamConns; uc != nil {
+		dial := transport.DialContext
+		if dial == nil {
+			dial = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+		}
+		transport.DialContext = uc.wrapDial(dial)
+		if uc.cfg.IdleTimeout > 0 {
+			transport.IdleConnTimeout = uc.cfg.IdleTimeout
+		}
+	}
+	return transport
+}
+
+// SetUpstreamConns retires pooled backend connections by age and idle time
+// and counts their churn. It replaces the transport of Client, so it must be
+// called before Start and after any Client replacement.
+func (app *Application) SetUpstreamConns(uc *UpstreamConns) {
+	uc.clock = app.clock
+	app.UpstreamConns = uc
+	app.Client.Transport = app.upstreamTransport()
+}
+
+// HandleUpstreamConnMetrics serves GET /admin/metrics/upstream-connections
+func (app *Application) HandleUpstreamConnMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if app.UpstreamConns == nil {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": false})
+		return
+	}
+
+	total, hosts := app.UpstreamConns.Stats()
+	writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": true, "total": total, "hosts": hosts})
+}
@@ -0,0 +1,223 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// UpstreamErrorClass is the kind of failure a backend request ended in
+type UpstreamErrorClass string
+
+// Upstream error classes
+const (
+	// UpstreamErrorDNS is a failed lookup of the backend's hostname
+	UpstreamErrorDNS UpstreamErrorClass = "dns"
+	// UpstreamErrorConnectRefused is a backend host with nothing listening
+	UpstreamErrorConnectRefused UpstreamErrorClass = "connect_refused"
+	// UpstreamErrorConnectTimeout is a connection that could not be opened
+	// in time
+	UpstreamErrorConnectTimeout UpstreamErrorClass = "connect_timeout"
+	// UpstreamErrorTLS is a failed TLS handshake or certificate check
+	UpstreamErrorTLS UpstreamErrorClass = "tls"
+	// UpstreamErrorReset is a connection the backend closed or reset before
+	// the response was complete
+	UpstreamErrorReset UpstreamErrorClass = "reset"
+	// UpstreamErrorReadTimeout is a backend that accepted the request but
+	// did not answer in time
+	UpstreamErrorReadTimeout UpstreamErrorClass = "read_timeout"
+	// UpstreamErrorMalformed is a response that is not valid HTTP
+	UpstreamErrorMalformed UpstreamErrorClass = "malformed_response"
+	// UpstreamErrorOther is any other failure
+	UpstreamErrorOther UpstreamErrorClass = "other"
+)
+
+// upstreamErrorClasses lists every class, in the order they are reported
+var upstreamErrorClasses = []UpstreamErrorClass{
+	UpstreamErrorDNS, UpstreamErrorConnectRefused, UpstreamErrorConnectTimeout, UpstreamErrorTLS,
+	UpstreamErrorReset, UpstreamErrorReadTimeout, UpstreamErrorMalformed, UpstreamErrorOther,
+}
+
+// upstreamErrorPolicy is how a class of failure is handled
+type upstreamErrorPolicy struct {
+	// retryable failures are worth another attempt: the request most
+	// likely never reached the backend, or a stale pooled connection broke
+	retryable bool
+	// weight is how many failures the class counts as against the
+	// backend's breaker
+	weight int64
+}
+
+// upstreamErrorPolicies sets each class's handling. A refused or timed out
+// connection says the backend is down, so it counts double and opens the
+// breaker sooner. Read timeouts are not retried, since the backend may still
+// be working on the request and a retry only adds load; TLS and malformed
+// response failures would only fail again.
+var upstreamErrorPolicies = map[UpstreamErrorClass]upstreamErrorPolicy{
+	UpstreamErrorDNS:            {retryable: true, weight: 1},
+	UpstreamErrorConnectRefused: {retryable: true, weight: 2},
+	UpstreamErrorConnectTimeout: {retryable: true, weight: 2},
+	UpstreamErrorTLS:            {retryable: false, weight: 1},
+	UpstreamErrorReset:          {retryable: true, weight: 1},
+	UpstreamErrorReadTimeout:    {retryable: false, weight: 1},
+	UpstreamErrorMalformed:      {retryable: false, weight: 1},
+	UpstreamErrorOther:          {retryable: true, weight: 1},
+}
+
+// Retryable reports whether a failure of the class is worth retrying
+func (c UpstreamErrorClass) Retryable() bool {
+	return upstreamErrorPolicies[c].retryable
+}
+
+// UpstreamError is a failed backend request with the class of its failure
+type UpstreamError struct {
+	Class UpstreamErrorClass
+	Err   error
+}
+
+func (e *UpstreamError) Error() string { return e.Err.Error() }
+func (e *UpstreamError) Unwrap() error { return e.Err }
+
+// classifyUpstreamError returns the class of a backend request's error.
+// connected reports whether the request got a connection, which tells a
+// timeout while connecting from one while waiting for the response.
+func classifyUpstreamError(err error, connected bool) UpstreamErrorClass {
+	var dnsErr *net.DNSError
+	var recordErr tls.RecordHeaderError
+	var verifyErr *tls.CertificateVerificationError
+	var alertErr tls.AlertError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	var netErr net.Error
+
+	switch {
+	case errors.As(err, &dnsErr):
+		return UpstreamErrorDNS
+	case errors.As(err, &recordErr), errors.As(err, &verifyErr), errors.As(err, &alertErr),
+		errors.As(err, &authorityErr), errors.As(err, &hostnameErr), errors.As(err, &invalidErr):
+		return UpstreamErrorTLS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return UpstreamErrorConnectRefused
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		if connected {
+			return UpstreamErrorReadTimeout
+		}
+		return UpstreamErrorConnectTimeout
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return UpstreamErrorReset
+	case strings.Contains(err.Error(), "malformed HTTP"), strings.Contains(err.Error(), "malformed MIME"):
+		return UpstreamErrorMalformed
+	case strings.Contains(err.Error(), "tls: "), strings.Contains(err.Error(), "HTTP response to HTTPS client"):
+		return UpstreamErrorTLS
+	default:
+		return UpstreamErrorOther
+	}
+}
+
+// upstreamErrorClass returns the class of an error from performRequest
+func upstreamErrorClass(err error) UpstreamErrorClass {
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) {
+		return upstreamErr.Class
+	}
+	return UpstreamErrorOther
+}
+
+// OnUpstreamFailure records a failed backend request against the backend's
+// breaker, weighted by the class of its failure
+func (cbm *CircuitBreakerManager) OnUpstreamFailure(serverName string, err error) {
+	cbm.onFailure(serverName, upstreamErrorPolicies[upstreamErrorClass(err)].weight)
+}
+
+// UpstreamErrorMetrics counts failed backend requests per backend and class.
+// Every failed attempt counts, including ones that were retried.
+type UpstreamErrorMetrics struct {
+	mu       sync.Mutex
+	backends map[string]map[UpstreamErrorClass]uint64
+}
+
+// NewUpstreamErrorMetrics creates empty upstream error counters
+func NewUpstreamErrorMetrics() *UpstreamErrorMetrics {
+	return &UpstreamErrorMetrics{backends: make(map[string]map[UpstreamErrorClass]uint64)}
+}
+
+// Observe counts one failed attempt against a backend
+func (um *UpstreamErrorMetrics) Observe(backend string, class UpstreamErrorClass) {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+
+	counts, exists := um.backends[backend]
+	if !exists {
+		counts = make(map[UpstreamErrorClass]uint64, len(upstreamErrorClasses))
+		um.backends[backend] = counts
+	}
+	counts[class]++
+}
+
+// RemoveBackend drops a backend's counters
+func (um *UpstreamErrorMetrics) RemoveBackend(backend string) {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+
+	delete(um.backends, backend)
+}
+
+// BackendErrorSnapshot is a backend's failure counts for the admin API
+type BackendErrorSnapshot struct {
+	Total   uint64            `json:"total"`
+	Classes map[string]uint64 `json:"classes"`
+}
+
+// Snapshot returns every backend's failure counts, with every class listed
+func (um *UpstreamErrorMetrics) Snapshot() map[string]BackendErrorSnapshot {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+
+	snapshot := make(map[string]BackendErrorSnapshot, len(um.backends))
+	for name, counts := range um.backends {
+		snap := BackendErrorSnapshot{Classes: make(map[string]uint64, len(upstreamErrorClasses))}
+		for _, class := range upstreamErrorClasses {
+			snap.Classes[string(class)] = counts[class]
+			snap.Total += counts[class]
+		}
+		snapshot[name] = snap
+	}
+	return snapshot
+}
+
+// upstreamErrorClassView lists each class with how it is handled
+func upstreamErrorClassView() []map[string]interface{} {
+	view := make([]map[string]interface{}, 0, len(upstreamErrorClasses))
+	for _, class := range upstreamErrorClasses {
+		policy := upstreamErrorPolicies[class]
+		view = append(view, map[string]interface{}{
+			"class":          class,
+			"retryable":      policy.retryable,
+			"breaker_weight": policy.weight,
+		})
+	}
+	return view
+}
+
+// HandleUpstreamErrorMetrics serves GET /admin/metrics/upstream-errors with
+// each backend's failed requests per class
+func (app *Application) HandleUpstreamErrorMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"backends": app.UpstreamErrors.Snapshot(),
+		"classes":  upstreamErrorClassView(),
+	})
+}
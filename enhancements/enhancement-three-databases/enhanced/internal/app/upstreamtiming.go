@@ -0,0 +1,298 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"sync"
+	"time"
+)
+
+// UpstreamTiming breaks down where the time of a backend request went.
+// DNS, Connect and TLS are zero when a pooled connection was reused.
+type UpstreamTiming struct {
+	DNS     time.Duration `json:"dns,omitempty"`
+	Connect time.Duration `json:"connect,omitempty"`
+	TLS     time.Duration `json:"tls,omitempty"`
+	// TTFB is from the request being written to the first response byte:
+	// the backend's own processing time
+	TTFB time.Duration `json:"ttfb"`
+	// BodyRead is from the response headers to the end of the body,
+	// including time spent waiting to write it to the client
+	BodyRead time.Duration `json:"body_read"`
+	Reused   bool          `json:"reused_conn"`
+}
+
+// upstreamTimer collects the httptrace events of one backend request.
+// Dialing runs on the transport's goroutines, so every field is guarded.
+type upstreamTimer struct {
+	mu         sync.Mutex
+	dnsStart   time.Time
+	dnsDone    time.Time
+	dialStart  time.Time
+	dialDone   time.Time
+	tlsStart   time.Time
+	tlsDone    time.Time
+	wrote      time.Time
+	firstByte  time.Time
+	headersAt  time.Time
+	reusedConn bool
+	gotConn    bool
+}
+
+// trace returns the httptrace hooks that fill in the timer
+func (t *upstreamTimer) trace() *httptrace.ClientTrace {
+	at := func(field *time.Time) {
+		t.mu.Lock()
+		if field.IsZero() {
+			*field = time.Now()
+		}
+		t.mu.Unlock()
+	}
+	return &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { at(&t.dnsStart) },
+		ConnectStart:         func(string, string) { at(&t.dialStart) },
+		TLSHandshakeStart:    func() { at(&t.tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { at(&t.tlsDone) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { at(&t.wrote) },
+		GotFirstResponseByte: func() { at(&t.firstByte) },
+		// Lookups may nest and a dial may try several addresses; the last
+		// one to finish is the one that counted
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mu.Lock()
+			t.dnsDone = time.Now()
+			t.mu.Unlock()
+		},
+		ConnectDone: func(string, string, error) {
+			t.mu.Lock()
+			t.dialDone = time.Now()
+			t.mu.Unlock()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			t.reusedConn = info.Reused
+			t.gotConn = true
+			t.mu.Unlock()
+		},
+	}
+}
+
+// connected reports whether the request got a connection to the backend
+func (t *upstreamTimer) connected() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.gotConn
+}
+
+// timing returns the phases measured so far, with the body read ending at end
+func (t *upstreamTimer) timing(end time.Time) UpstreamTiming {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	span := func(start, done time.Time) time.Duration {
+		if start.IsZero() || done.IsZero() || done.Before(start) {
+			return 0
+		}
+		return done.Sub(start)
+	}
+	timing := UpstreamTiming{
+		DNS:      span(t.dnsStart, t.dnsDone),
+		Connect:  span(t.dialStart, t.dialDone),
+		TLS:      span(t.tlsStart, t.tlsDone),
+		TTFB:     span(t.wrote, t.firstByte),
+		BodyRead: span(t.headersAt, end),
+		Reused:   t.reusedConn,
+	}
+	return timing
+}
+
+// timedBody records the request's timing once its response body has been
+// read to the end or closed
+type timedBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func(time.Time)
+}
+
+func (b *timedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.once.Do(func() { b.done(time.Now()) })
+	}
+	return n, err
+}
+
+func (b *timedBody) Close() error {
+	b.once.Do(func() { b.done(time.Now()) })
+	return b.ReadCloser.Close()
+}
+
+// timeResponse wraps resp's body to record t's timing against the request's
+// backend when the body is done. Protocol upgrades are left alone, since
+// their body is the connection.
+func (app *Application) timeResponse(r *http.Request, t *upstreamTimer, resp *http.Response) {
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		return
+	}
+
+	t.mu.Lock()
+	t.headersAt = time.Now()
+	t.mu.Unlock()
+
+	resp.Body = &timedBody{ReadCloser: resp.Body, done: func(end time.Time) {
+		timing := t.timing(end)
+		if record := requestRecordFrom(r.Context()); record != nil {
+			record.mu.Lock()
+			record.upstream = &timing
+			backend := record.backend
+			record.mu.Unlock()
+			if backend != "" {
+				app.UpstreamTiming.Observe(backend, timing)
+			}
+		}
+	}}
+}
+
+// upstreamTimingAttr returns the request's upstream timing as a log
+// attribute, or an empty attribute that handlers drop when there is none yet
+func upstreamTimingAttr(ctx context.Context) slog.Attr {
+	record := requestRecordFrom(ctx)
+	if record == nil {
+		return slog.Attr{}
+	}
+	record.mu.Lock()
+	defer record.mu.Unlock()
+
+	if record.upstream == nil {
+		return slog.Attr{}
+	}
+	t := record.upstream
+	return slog.Group("upstream",
+		"dns", t.DNS, "connect", t.Connect, "tls", t.TLS,
+		"ttfb", t.TTFB, "body_read", t.BodyRead, "reused_conn", t.Reused)
+}
+
+// upstreamPhases are the phases UpstreamTimingMetrics tracks, in order
+var upstreamPhases = []string{"dns", "connect", "tls", "ttfb", "body_read"}
+
+// backendTiming holds a backend's phase histograms and connection counts
+type backendTiming struct {
+	phases   map[string]*Histogram
+	mu       sync.Mutex
+	requests uint64
+	reused   uint64
+}
+
+// BackendTimingSnapshot is a backend's timing breakdown for the admin API.
+// Connection setup phases only count requests that opened a connection.
+type BackendTimingSnapshot struct {
+	Requests    uint64                       `json:"requests"`
+	ReusedConns uint64                       `json:"reused_conns"`
+	Phases      map[string]HistogramSnapshot `json:"phases"`
+}
+
+// UpstreamTimingMetrics aggregates the timing breakdown of backend requests
+// per backend
+type UpstreamTimingMetrics struct {
+	mu       sync.RWMutex
+	backends map[string]*backendTiming
+}
+
+// NewUpstreamTimingMetrics creates empty timing metrics
+func NewUpstreamTimingMetrics() *UpstreamTimingMetrics {
+	return &UpstreamTimingMetrics{backends: make(map[string]*backendTiming)}
+}
+
+// backend returns the timing of a backend, creating it if needed
+func (um *UpstreamTimingMetrics) backend(name string) *backendTiming {
+	um.mu.RLock()
+	bt, exists := um.backends[name]
+	um.mu.RUnlock()
+	if exists {
+		return bt
+	}
+
+	um.mu.Lock()
+	defer um.mu.Unlock()
+
+	if bt, exists = um.backends[name]; !exists {
+		bt = &backendTiming{phases: make(map[string]*Histogram, len(upstreamPhases))}
+		for _, phase := range upstreamPhases {
+			bt.phases[phase] = NewHistogram(LatencyBuckets)
+		}
+		um.backends[name] = bt
+	}
+	return bt
+}
+
+// Observe records one request's timing against its backend
+func (um *UpstreamTimingMetrics) Observe(backend string, timing UpstreamTiming) {
+	bt := um.backend(backend)
+
+	bt.mu.Lock()
+	bt.requests++
+	if timing.Reused {
+		bt.reused++
+	}
+	bt.mu.Unlock()
+
+	if !timing.Reused {
+		if timing.DNS > 0 {
+			bt.phases["dns"].Observe(timing.DNS)
+		}
+		bt.phases["connect"].Observe(timing.Connect)
+		if timing.TLS > 0 {
+			bt.phases["tls"].Observe(timing.TLS)
+		}
+	}
+	bt.phases["ttfb"].Observe(timing.TTFB)
+	bt.phases["body_read"].Observe(timing.BodyRead)
+}
+
+// RemoveBackend drops a backend's timing
+func (um *UpstreamTimingMetrics) RemoveBackend(backend string) {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+
+	delete(um.backends, backend)
+}
+
+// Snapshot returns every backend's timing breakdown
+func (um *UpstreamTimingMetrics) Snapshot() map[string]BackendTimingSnapshot {
+	um.mu.RLock()
+	names := make([]string, 0, len(um.backends))
+	for name := range um.backends {
+		names = append(names, name)
+	}
+	um.mu.RUnlock()
+	sort.Strings(names)
+
+	snapshot := make(map[string]BackendTimingSnapshot, len(names))
+	for _, name := range names {
+		bt := um.backend(name)
+		bt.mu.Lock()
+		snap := BackendTimingSnapshot{Requests: bt.requests, ReusedConns: bt.reused, Phases: make(map[string]HistogramSnapshot, len(bt.phases))}
+		bt.mu.Unlock()
+		for phase, h := range bt.phases {
+			snap.Phases[phase] = h.Snapshot()
+		}
+		snapshot[name] = snap
+	}
+	return snapshot
+}
+
+// HandleUpstreamTimingMetrics serves GET /admin/metrics/upstream-timing with
+// each backend's DNS, connect, TLS, time to first byte and body read
+// histograms
+func (app *Application) HandleUpstreamTimingMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"backends": app.UpstreamTiming.Snapshot()})
+}
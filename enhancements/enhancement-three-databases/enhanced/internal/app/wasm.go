@@ -0,0 +1,365 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+)
+
+// WasmFilterTimeout bounds how long a single filter invocation may run
+const WasmFilterTimeout = 50 * time.Millisecond
+
+// WASM filter ABI
+//
+// A filter is a WebAssembly module exporting:
+//
+//	alloc(size i32) -> i32                  allocate size bytes in guest memory
+//	on_request(ptr i32, len i32) -> i64     handle a request
+//
+// on_request receives a JSON encoded WasmRequest and returns the guest
+// pointer and length of a JSON encoded WasmDecision packed as (ptr << 32 | len).
+// Returning 0 leaves the request unchanged. Each invocation runs in a fresh
+// module instance, so filters cannot share state between requests.
+
+// WasmRequest is the view of a request passed to a filter
+type WasmRequest struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Query   string            `json:"query"`
+	Host    string            `json:"host"`
+	Headers map[string]string `json:"headers"`
+}
+
+// WasmDecision is what a filter returns for a request
+type WasmDecision struct {
+	SetHeaders    map[string]string `json:"set_headers,omitempty"`
+	RemoveHeaders []string          `json:"remove_headers,omitempty"`
+	Respond       *WasmResponse     `json:"respond,omitempty"`
+}
+
+// WasmResponse short-circuits a request with a response from the filter
+type WasmResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+}
+
+// WasmFilter is a compiled filter module attached to a route prefix
+type WasmFilter struct {
+	Name     string    `json:"name"`
+	Prefix   string    `json:"prefix"`
+	Path     string    `json:"path"`
+	LoadedAt time.Time `json:"loaded_at"`
+	compiled wazero.CompiledModule
+}
+
+// release closes the compiled module once in-flight invocations have finished
+func (f *WasmFilter) release() {
+	time.AfterFunc(2*WasmFilterTimeout, func() {
+		f.compiled.Close(context.Background())
+	})
+}
+
+// WasmFilterManager compiles filters and runs them for matching routes
+type WasmFilterManager struct {
+	runtime wazero.Runtime
+	mu      sync.RWMutex
+	routes  map[string][]*WasmFilter // prefix -> filters in attach order
+	logger  *slog.Logger
+}
+
+// NewWasmFilterManager creates a manager with a sandboxed WASM runtime
+func NewWasmFilterManager(logger *slog.Logger) *WasmFilterManager {
+	ctx := context.Background()
+	cfg := wazero.NewRuntimeConfig().WithCloseOnContextDone(true)
+
+	return &WasmFilterManager{
+		runtime: wazero.NewRuntimeWithConfig(ctx, cfg),
+		routes:  make(map[string][]*WasmFilter),
+		logger:  logger,
+	}
+}
+
+// Load compiles a filter from disk and attaches it to a route prefix,
+// replacing any filter of the same name on that prefix
+func (wm *WasmFilterManager) Load(name, prefix, path string) (*WasmFilter, error) {
+	code, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read wasm filter: %w", err)
+	}
+
+	compiled, err := wm.runtime.CompileModule(context.Background(), code)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile wasm filter: %w", err)
+	}
+
+	exports := compiled.ExportedFunctions()
+	for _, fn := range []string{"alloc", "on_request"} {
+		if _, ok := exports[fn]; !ok {
+			compiled.Close(context.Background())
+			return nil, fmt.Errorf("wasm filter does not export %q", fn)
+		}
+	}
+
+	filter := &WasmFilter{
+		Name:     name,
+		Prefix:   prefix,
+		Path:     path,
+		LoadedAt: time.Now(),
+		compiled: compiled,
+	}
+
+	wm.mu.Lock()
+	var old *WasmFilter
+	filters := wm.routes[prefix]
+	replaced := false
+	for i, f := range filters {
+		if f.Name == name {
+			old = f
+			filters[i] = filter
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		filters = append(filters, filter)
+	}
+	wm.routes[prefix] = filters
+	wm.mu.Unlock()
+
+	if old != nil {
+		old.release()
+	}
+
+	wm.logger.Info("wasm filter loaded", "name", name, "prefix", prefix, "path", path, "replaced", replaced)
+	return filter, nil
+}
+
+// Unload detaches a filter from a route prefix
+func (wm *WasmFilterManager) Unload(name, prefix string) error {
+	wm.mu.Lock()
+	filters := wm.routes[prefix]
+	var removed *WasmFilter
+	for i, f := range filters {
+		if f.Name == name {
+			removed = f
+			filters = append(filters[:i:i], filters[i+1:]...)
+			break
+		}
+	}
+	if len(filters) == 0 {
+		delete(wm.routes, prefix)
+	} else {
+		wm.routes[prefix] = filters
+	}
+	wm.mu.Unlock()
+
+	if removed == nil {
+		return fmt.Errorf("wasm filter '%s' not attached to '%s'", name, prefix)
+	}
+
+	removed.release()
+	wm.logger.Info("wasm filter unloaded", "name", name, "prefix", prefix)
+	return nil
+}
+
+// List returns every attached filter ordered by prefix
+func (wm *WasmFilterManager) List() []WasmFilter {
+	wm.mu.RLock()
+	defer wm.mu.RUnlock()
+
+	result := []WasmFilter{}
+	for _, filters := range wm.routes {
+		for _, f := range filters {
+			result = append(result, *f)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Prefix < result[j].Prefix })
+	return result
+}
+
+// filtersFor returns the filters attached to the longest matching prefix
+func (wm *WasmFilterManager) filtersFor(path string) []*WasmFilter {
+	wm.mu.RLock()
+	defer wm.mu.RUnlock()
+
+	longest := ""
+	for prefix := range wm.routes {
+		if strings.HasPrefix(path, prefix) && len(prefix) > len(longest) {
+			longest = prefix
+		}
+	}
+	if longest == "" {
+		return nil
+	}
+	return append([]*WasmFilter(nil), wm.routes[longest]...)
+}
+
+// Close releases the WASM runtime and every compiled filter
+func (wm *WasmFilterManager) Close() error {
+	return wm.runtime.Close(context.Background())
+}
+
+// invoke runs a filter against a request in a fresh module instance
+func (wm *WasmFilterManager) invoke(ctx context.Context, filter *WasmFilter, req WasmRequest) (*WasmDecision, error) {
+	ctx, cancel := context.WithTimeout(ctx, WasmFilterTimeout)
+	defer cancel()
+
+	mod, err := wm.runtime.InstantiateModule(ctx, filter.compiled, wazero.NewModuleConfig().WithName(""))
+	if err != nil {
+		return nil, fmt.Errorf("failed to instantiate: %w", err)
+	}
+	defer mod.Close(ctx)
+
+	input, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := mod.ExportedFunction("alloc").Call(ctx, uint64(len(input)))
+	if err != nil {
+		return nil, fmt.Errorf("alloc failed: %w", err)
+	}
+	ptr := uint32(results[0])
+
+	if !mod.Memory().Write(ptr, input) {
+		return nil, fmt.Errorf("alloc returned out of range pointer")
+	}
+
+	results, err = mod.ExportedFunction("on_request").Call(ctx, uint64(ptr), uint64(len(input)))
+	if err != nil {
+		return nil, fmt.Errorf("on_request failed: %w", err)
+	}
+
+	outPtr, outLen := uint32(results[0]>>32), uint32(results[0])
+	if outLen == 0 {
+		return nil, nil
+	}
+
+	output, ok := mod.Memory().Read(outPtr, outLen)
+	if !ok {
+		return nil, fmt.Errorf("on_request returned out of range result")
+	}
+
+	var decision WasmDecision
+	if err := json.Unmarshal(output, &decision); err != nil {
+		return nil, fmt.Errorf("invalid decision: %w", err)
+	}
+	return &decision, nil
+}
+
+// runWasmFilters applies the filters for the request's route. It reports
+// whether the request may continue; a filter failure fails closed with 500.
+func (app *Application) runWasmFilters(w http.ResponseWriter, r *http.Request) bool {
+	if app.WasmFilters == nil {
+		return true
+	}
+
+	filters := app.WasmFilters.filtersFor(r.URL.Path)
+	if len(filters) == 0 || app.skipStage(r, StageWasm) {
+		return true
+	}
+
+	for _, filter := range filters {
+		req := WasmRequest{
+			Method:  r.Method,
+			Path:    r.URL.Path,
+			Query:   r.URL.RawQuery,
+			Host:    r.Host,
+			Headers: make(map[string]string, len(r.Header)),
+		}
+		for key := range r.Header {
+			req.Headers[key] = r.Header.Get(key)
+		}
+
+		decision, err := app.WasmFilters.invoke(r.Context(), filter, req)
+		if err != nil {
+			app.Logger.ErrorContext(r.Context(), "wasm filter failed", "filter", filter.Name, "path", r.URL.Path, "error", err)
+			app.writeError(w, r, CodeInternal, "internal server error")
+			return false
+		}
+		if decision == nil {
+			continue
+		}
+
+		for key, value := range decision.SetHeaders {
+			r.Header.Set(key, value)
+		}
+		for _, key := range decision.RemoveHeaders {
+			r.Header.Del(key)
+		}
+
+		if decision.Respond != nil {
+			for key, value := range decision.Respond.Headers {
+				w.Header().Set(key, value)
+			}
+			status := decision.Respond.Status
+			if status == 0 {
+				status = http.StatusForbidden
+			}
+			app.Logger.InfoContext(r.Context(), "request short-circuited by wasm filter",
+				"filter", filter.Name, "path", r.URL.Path, "status", status)
+			w.WriteHeader(status)
+			w.Write([]byte(decision.Respond.Body))
+			return false
+		}
+	}
+
+	return true
+}
+
+// HandleWasmFilters serves /admin/filters:
+//
+//	GET                                                       list filters
+//	POST   {"name": "auth", "prefix": "/s1", "path": "f.wasm"} load or swap a filter
+//	DELETE ?name=auth&prefix=/s1                              detach a filter
+func (app *Application) HandleWasmFilters(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]interface{}{"filters": app.WasmFilters.List()})
+
+	case http.MethodPost:
+		var req struct {
+			Name   string `json:"name"`
+			Prefix string `json:"prefix"`
+			Path   string `json:"path"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" || req.Prefix == "" || req.Path == "" {
+			http.Error(w, "missing a required field in payload", http.StatusBadRequest)
+			return
+		}
+
+		filter, err := app.WasmFilters.Load(req.Name, req.Prefix, req.Path)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		app.configReloaded("filters", map[string]interface{}{"name": req.Name, "prefix": req.Prefix})
+		writeJSON(w, http.StatusCreated, filter)
+
+	case http.MethodDelete:
+		name, prefix := r.URL.Query().Get("name"), r.URL.Query().Get("prefix")
+		if name == "" || prefix == "" {
+			http.Error(w, "name and prefix parameters required", http.StatusBadRequest)
+			return
+		}
+		if err := app.WasmFilters.Unload(name, prefix); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		app.configReloaded("filters", map[string]interface{}{"name": name, "prefix": prefix, "removed": true})
+		writeJSON(w, http.StatusOK, map[string]string{"status": "removed", "name": name, "prefix": prefix})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
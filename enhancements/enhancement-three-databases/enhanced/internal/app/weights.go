@@ -0,0 +1,203 @@
+package app
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/codytheroux96/go-reverse-proxy/internal/registry"
+)
+
+// Health weighting defaults applied to zero WeightConfig fields
+const (
+	DefaultWeightErrorDecay   = 0.1
+	DefaultWeightLatencyFloor = 50 * time.Millisecond
+	DefaultWeightMin          = 0.05
+)
+
+// WeightConfig tunes how health-check latency and request errors turn into
+// backend selection weights
+type WeightConfig struct {
+	// ErrorDecay is the weight of each new request outcome in a server's
+	// error rate, between 0 and 1; 0 uses DefaultWeightErrorDecay
+	ErrorDecay float64
+	// LatencyFloor is the health check latency below which servers count as
+	// equally fast, so jitter between fast servers does not skew traffic;
+	// 0 uses DefaultWeightLatencyFloor
+	LatencyFloor time.Duration
+	// MinWeight keeps a trickle of traffic on the worst server so its error
+	// rate can recover, between 0 and 1; 0 uses DefaultWeightMin
+	MinWeight float64
+}
+
+// HealthWeights picks among a route's healthy servers in proportion to their
+// measured quality rather than round-robin. A server's weight is its latency
+// factor, the fastest health check latency in the route over its own, times
+// one minus its recent error rate.
+type HealthWeights struct {
+	cfg WeightConfig
+
+	mu         sync.Mutex
+	errorRates map[string]float64
+}
+
+// NewHealthWeights validates cfg and creates health-weighted balancing
+func NewHealthWeights(cfg WeightConfig) (*HealthWeights, error) {
+	if cfg.ErrorDecay < 0 || cfg.ErrorDecay > 1 {
+		return nil, fmt.Errorf("error decay must be between 0 and 1")
+	}
+	if cfg.MinWeight < 0 || cfg.MinWeight > 1 {
+		return nil, fmt.Errorf("min weight must be between 0 and 1")
+	}
+	if cfg.LatencyFloor < 0 {
+		return nil, fmt.Errorf("latency floor must not be negative")
+	}
+	if cfg.ErrorDecay == 0 {
+		cfg.ErrorDecay = DefaultWeightErrorDecay
+	}
+	if cfg.LatencyFloor == 0 {
+		cfg.LatencyFloor = DefaultWeightLatencyFloor
+	}
+	if cfg.MinWeight == 0 {
+		cfg.MinWeight = DefaultWeightMin
+	}
+	return &HealthWeights{cfg: cfg, errorRates: make(map[string]float64)}, nil
+}
+
+// SetHealthWeights weights backend selection by health check latency and
+// request errors. It must be called before Start.
+func (app *Application) SetHealthWeights(hw *HealthWeights) {
+	app.CircuitBreaker.observe = hw.observe
+	app.HealthWeights = hw
+}
+
+// observe folds a request outcome into a server's error rate
+func (hw *HealthWeights) observe(serverName string, success bool) {
+	outcome := 0.0
+	if !success {
+		outcome = 1
+	}
+
+	hw.mu.Lock()
+	defer hw.mu.Unlock()
+	rate := hw.errorRates[serverName]
+	hw.errorRates[serverName] = rate + hw.cfg.ErrorDecay*(outcome-rate)
+}
+
+// remove drops a deregistered server's error rate
+func (hw *HealthWeights) remove(serverName string) {
+	hw.mu.Lock()
+	defer hw.mu.Unlock()
+	delete(hw.errorRates, serverName)
+}
+
+// weights returns the selection weight of each server, scaled down for
+// servers reporting themselves degraded
+func (hw *HealthWeights) weights(servers []registry.Server, hm *HealthMonitor) []float64 {
+	latencies := make([]time.Duration, len(servers))
+	levels := make([]float64, len(servers))
+	fastest := time.Duration(0)
+	for i, server := range servers {
+		levels[i] = hm.levelWeight(server.Name)
+		latency := hw.cfg.LatencyFloor
+		if status, ok := hm.GetHealthStatus(server.Name); ok && status.LastResponseTime > latency {
+			latency = status.LastResponseTime
+		}
+		latencies[i] = latency
+		if fastest == 0 || latency < fastest {
+			fastest = latency
+		}
+	}
+
+	hw.mu.Lock()
+	defer hw.mu.Unlock()
+	weights := make([]float64, len(servers))
+	for i, server := range servers {
+		weight := float64(fastest) / float64(latencies[i]) * (1 - hw.errorRates[server.Name]) * levels[i]
+		weights[i] = max(weight, hw.cfg.MinWeight)
+	}
+	return weights
+}
+
+// pick chooses a server at random in proportion to its weight
+func (hw *HealthWeights) pick(servers []registry.Server, hm *HealthMonitor) registry.Server {
+	weights := hw.weights(servers, hm)
+	total := 0.0
+	for _, weight := range weights {
+		total += weight
+	}
+
+	target := rand.Float64() * total
+	for i, weight := range weights {
+		if target < weight {
+			return servers[i]
+		}
+		target -= weight
+	}
+	return servers[len(servers)-1]
+}
+
+// ServerWeight is a server's current selection weight and its inputs
+type ServerWeight struct {
+	Weight    float64 `json:"weight"`
+	ErrorRate float64 `json:"error_rate"`
+	Latency   string  `json:"latency"`
+}
+
+// healthWeightStats returns the current weight of every registered server,
+// keyed by server name
+func (app *Application) healthWeightStats() (map[string]ServerWeight, error) {
+	servers, err := app.Registry.GetServers()
+	if err != nil {
+		return nil, err
+	}
+
+	byPrefix := make(map[string][]registry.Server)
+	for _, server := range servers {
+		for _, prefix := range server.Prefixes {
+			byPrefix[prefix] = append(byPrefix[prefix], server)
+		}
+	}
+
+	stats := make(map[string]ServerWeight, len(servers))
+	for _, group := range byPrefix {
+		weights := app.HealthWeights.weights(group, app.HealthMonitor)
+		for i, server := range group {
+			// A server on several routes reports its lowest weight
+			if existing, seen := stats[server.Name]; seen && existing.Weight <= weights[i] {
+				continue
+			}
+			status, _ := app.HealthMonitor.GetHealthStatus(server.Name)
+			app.HealthWeights.mu.Lock()
+			rate := app.HealthWeights.errorRates[server.Name]
+			app.HealthWeights.mu.Unlock()
+			stats[server.Name] = ServerWeight{
+				Weight:    weights[i],
+				ErrorRate: rate,
+				Latency:   status.LastResponseTime.String(),
+			}
+		}
+	}
+	return stats, nil
+}
+
+// HandleWeightMetrics serves GET /admin/metrics/weights
+func (app *Application) HandleWeightMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if app.HealthWeights == nil {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": false})
+		return
+	}
+	stats, err := app.healthWeightStats()
+	if err != nil {
+		http.Error(w, "failed to list servers", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": true, "servers": stats})
+}
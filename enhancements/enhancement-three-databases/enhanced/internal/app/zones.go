@@ -0,0 +1,133 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/codytheroux96/go-reverse-proxy/internal/registry"
+)
+
+// ZoneConfig names the zone the proxy runs in
+type ZoneConfig struct {
+	// Zone is the proxy's own zone or region, matched against the zone
+	// backends register with
+	Zone string
+	// MinLocal is how many healthy local servers a route needs before it
+	// stops spilling to other zones; 0 means 1
+	MinLocal int
+}
+
+// ZoneRouting keeps each route's traffic on servers in the proxy's zone while
+// enough of them are healthy, and spreads it over every zone otherwise.
+// Servers registered without a zone count as local.
+type ZoneRouting struct {
+	cfg ZoneConfig
+
+	mu     sync.Mutex
+	routes map[string]*zoneCounts
+}
+
+type zoneCounts struct {
+	local   uint64
+	spilled map[string]uint64
+}
+
+// NewZoneRouting validates cfg and creates zone-local routing
+func NewZoneRouting(cfg ZoneConfig) (*ZoneRouting, error) {
+	if cfg.Zone == "" {
+		return nil, fmt.Errorf("zone is required")
+	}
+	if cfg.MinLocal < 0 {
+		return nil, fmt.Errorf("min local servers must not be negative")
+	}
+	if cfg.MinLocal == 0 {
+		cfg.MinLocal = 1
+	}
+	return &ZoneRouting{cfg: cfg, routes: make(map[string]*zoneCounts)}, nil
+}
+
+// isLocal reports whether a server runs in the proxy's zone
+func (z *ZoneRouting) isLocal(server registry.Server) bool {
+	return server.Zone == "" || server.Zone == z.cfg.Zone
+}
+
+// prefer narrows healthy servers to the local ones when there are at least
+// MinLocal of them, and otherwise returns them all
+func (z *ZoneRouting) prefer(healthy []registry.Server) []registry.Server {
+	var local []registry.Server
+	for _, server := range healthy {
+		if z.isLocal(server) {
+			local = append(local, server)
+		}
+	}
+	if len(local) >= z.cfg.MinLocal {
+		return local
+	}
+	return healthy
+}
+
+// record counts a request routed to server under prefix, and reports whether
+// it left the proxy's zone
+func (z *ZoneRouting) record(prefix string, server registry.Server) bool {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	counts, exists := z.routes[prefix]
+	if !exists {
+		counts = &zoneCounts{spilled: make(map[string]uint64)}
+		z.routes[prefix] = counts
+	}
+	if z.isLocal(server) {
+		counts.local++
+		return false
+	}
+	counts.spilled[server.Zone]++
+	return true
+}
+
+// RouteZoneStats counts a route's zone-local and cross-zone requests
+type RouteZoneStats struct {
+	Local   uint64 `json:"local"`
+	Spilled uint64 `json:"spilled"`
+	// SpilledTo breaks Spilled down by destination zone
+	SpilledTo map[string]uint64 `json:"spilled_to"`
+}
+
+// ZoneStats reports the proxy's zone and per-route spillover
+type ZoneStats struct {
+	Zone     string                    `json:"zone"`
+	MinLocal int                       `json:"min_local"`
+	Routes   map[string]RouteZoneStats `json:"routes"`
+}
+
+// Stats returns the zone configuration and per-route counters
+func (z *ZoneRouting) Stats() ZoneStats {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	routes := make(map[string]RouteZoneStats, len(z.routes))
+	for prefix, counts := range z.routes {
+		stats := RouteZoneStats{Local: counts.local, SpilledTo: make(map[string]uint64, len(counts.spilled))}
+		for zone, count := range counts.spilled {
+			stats.Spilled += count
+			stats.SpilledTo[zone] = count
+		}
+		routes[prefix] = stats
+	}
+	return ZoneStats{Zone: z.cfg.Zone, MinLocal: z.cfg.MinLocal, Routes: routes}
+}
+
+// HandleZoneMetrics serves GET /admin/metrics/zones
+func (app *Application) HandleZoneMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if app.Zones == nil {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": false})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": true, "stats": app.Zones.Stats()})
+}
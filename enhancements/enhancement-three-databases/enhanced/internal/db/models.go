@@ -6,13 +6,49 @@ package db
 
 import (
 	"database/sql"
+	"encoding/json"
+	"time"
 )
 
+type AuditLog struct {
+	ID           int64           `json:"id"`
+	Action       string          `json:"action"`
+	Actor        string          `json:"actor"`
+	Target       string          `json:"target"`
+	Payload      json.RawMessage `json:"payload"`
+	CreatedAt    time.Time       `json:"created_at"`
+	ClaimedActor string          `json:"claimed_actor"`
+}
+
+type BackendHealth struct {
+	ServerName                  string          `json:"server_name"`
+	IsHealthy                   bool            `json:"is_healthy"`
+	ConsecutiveFailures         int32           `json:"consecutive_failures"`
+	ConsecutiveSuccesses        int32           `json:"consecutive_successes"`
+	LastChecked                 time.Time       `json:"last_checked"`
+	LastResponseTimeMs          int64           `json:"last_response_time_ms"`
+	UpdatedAt                   time.Time       `json:"updated_at"`
+	IsLive                      bool            `json:"is_live"`
+	ConsecutiveLivenessFailures int32           `json:"consecutive_liveness_failures"`
+	NotLiveSince                sql.NullTime    `json:"not_live_since"`
+	Level                       string          `json:"level"`
+	Details                     json.RawMessage `json:"details"`
+	Started                     bool            `json:"started"`
+}
+
+type PrefixOwner struct {
+	Prefix    string    `json:"prefix"`
+	Owner     string    `json:"owner"`
+	ClaimedAt time.Time `json:"claimed_at"`
+}
+
 type Service struct {
-	ID        int32        `json:"id"`
-	Name      string       `json:"name"`
-	BaseUrl   string       `json:"base_url"`
-	Prefixes  []string     `json:"prefixes"`
-	CreatedAt sql.NullTime `json:"created_at"`
-	UpdatedAt sql.NullTime `json:"updated_at"`
+	ID           int32        `json:"id"`
+	Name         string       `json:"name"`
+	BaseUrl      string       `json:"base_url"`
+	Prefixes     []string     `json:"prefixes"`
+	CreatedAt    sql.NullTime `json:"created_at"`
+	UpdatedAt    sql.NullTime `json:"updated_at"`
+	Zone         string       `json:"zone"`
+	ServiceGroup string       `json:"service_group"`
 }
@@ -6,14 +6,25 @@ package db
 
 import (
 	"context"
+	"time"
 )
 
 type Querier interface {
+	AdvisoryUnlock(ctx context.Context, key int64) (bool, error)
+	ClaimPrefix(ctx context.Context, arg ClaimPrefixParams) error
 	DeleteService(ctx context.Context, name string) error
 	GetAllServices(ctx context.Context) ([]Service, error)
 	GetService(ctx context.Context, name string) (Service, error)
 	GetServicesByPrefix(ctx context.Context, prefixes []string) ([]Service, error)
+	InsertAuditEvent(ctx context.Context, arg InsertAuditEventParams) (AuditLog, error)
+	ListAuditEvents(ctx context.Context, arg ListAuditEventsParams) ([]AuditLog, error)
+	ListBackendHealth(ctx context.Context) ([]BackendHealth, error)
+	ListPrefixOwners(ctx context.Context) ([]PrefixOwner, error)
+	PruneBackendHealth(ctx context.Context, updatedAt time.Time) error
 	RegisterService(ctx context.Context, arg RegisterServiceParams) (Service, error)
+	ReleasePrefix(ctx context.Context, prefix string) error
+	TryAdvisoryLock(ctx context.Context, key int64) (bool, error)
+	UpsertBackendHealth(ctx context.Context, arg UpsertBackendHealthParams) error
 }
 
 var _ Querier = (*Queries)(nil)
@@ -21,7 +21,7 @@ func (q *Queries) DeleteService(ctx context.Context, name string) error {
 }
 
 const getAllServices = `-- name: GetAllServices :many
-SELECT id, name, base_url, prefixes, created_at, updated_at FROM services ORDER BY name
+SELECT id, name, base_url, prefixes, created_at, updated_at, zone, service_group FROM services ORDER BY name
 `
 
 func (q *Queries) GetAllServices(ctx context.Context) ([]Service, error) {
@@ -40,6 +40,8 @@ func (q *Queries) GetAllServices(ctx context.Context) ([]Service, error) {
 			pq.Array(&i.Prefixes),
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.Zone,
+			&i.ServiceGroup,
 		); err != nil {
 			return nil, err
 		}
@@ -55,7 +57,7 @@ func (q *Queries) GetAllServices(ctx context.Context) ([]Service, error) {
 }
 
 const getService = `-- name: GetService :one
-SELECT id, name, base_url, prefixes, created_at, updated_at FROM services WHERE name = $1
+SELECT id, name, base_url, prefixes, created_at, updated_at, zone, service_group FROM services WHERE name = $1
 `
 
 func (q *Queries) GetService(ctx context.Context, name string) (Service, error) {
@@ -68,12 +70,14 @@ func (q *Queries) GetService(ctx context.Context, name string) (Service, error)
 		pq.Array(&i.Prefixes),
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.Zone,
+		&i.ServiceGroup,
 	)
 	return i, err
 }
 
 const getServicesByPrefix = `-- name: GetServicesByPrefix :many
-SELECT id, name, base_url, prefixes, created_at, updated_at FROM services WHERE $1 = ANY(prefixes) ORDER BY name
+SELECT id, name, base_url, prefixes, created_at, updated_at, zone, service_group FROM services WHERE $1 = ANY(prefixes) ORDER BY name
 `
 
 func (q *Queries) GetServicesByPrefix(ctx context.Context, prefixes []string) ([]Service, error) {
@@ -92,6 +96,8 @@ func (q *Queries) GetServicesByPrefix(ctx context.Context, prefixes []string) ([
 			pq.Array(&i.Prefixes),
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.Zone,
+			&i.ServiceGroup,
 		); err != nil {
 			return nil, err
 		}
@@ -107,23 +113,33 @@ func (q *Queries) GetServicesByPrefix(ctx context.Context, prefixes []string) ([
 }
 
 const registerService = `-- name: RegisterService :one
-INSERT INTO services (name, base_url, prefixes)
-VALUES ($1, $2, $3)
+INSERT INTO services (name, base_url, prefixes, zone, service_group)
+VALUES ($1, $2, $3, $4, $5)
 ON CONFLICT (name) DO UPDATE SET
     base_url = EXCLUDED.base_url,
     prefixes = EXCLUDED.prefixes,
+    zone = EXCLUDED.zone,
+    service_group = EXCLUDED.service_group,
     updated_at = NOW()
-RETURNING id, name, base_url, prefixes, created_at, updated_at
+RETURNING id, name, base_url, prefixes, created_at, updated_at, zone, service_group
 `
 
 type RegisterServiceParams struct {
-	Name     string   `json:"name"`
-	BaseUrl  string   `json:"base_url"`
-	Prefixes []string `json:"prefixes"`
+	Name         string   `json:"name"`
+	BaseUrl      string   `json:"base_url"`
+	Prefixes     []string `json:"prefixes"`
+	Zone         string   `json:"zone"`
+	ServiceGroup string   `json:"service_group"`
 }
 
 func (q *Queries) RegisterService(ctx context.Context, arg RegisterServiceParams) (Service, error) {
-	row := q.db.QueryRowContext(ctx, registerService, arg.Name, arg.BaseUrl, pq.Array(arg.Prefixes))
+	row := q.db.QueryRowContext(ctx, registerService,
+		arg.Name,
+		arg.BaseUrl,
+		pq.Array(arg.Prefixes),
+		arg.Zone,
+		arg.ServiceGroup,
+	)
 	var i Service
 	err := row.Scan(
 		&i.ID,
@@ -132,6 +148,8 @@ func (q *Queries) RegisterService(ctx context.Context, arg RegisterServiceParams
 		pq.Array(&i.Prefixes),
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.Zone,
+		&i.ServiceGroup,
 	)
 	return i, err
 }
@@ -0,0 +1,714 @@
+// Package expr implements a small, CEL-like expression language used for
+// routing and policy conditions, e.g.
+//
+//	request.header["x-tier"] == "gold" && request.path.startsWith("/api")
+//
+// Supported syntax: string, number and boolean literals; identifiers with
+// field access (a.b) and indexing (a["k"]); the operators ! && || == != < <=
+// > >= and in; parentheses; and the string methods startsWith, endsWith,
+// contains, matches (regular expression), lower and upper.
+package expr
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Program is a compiled expression that can be evaluated repeatedly
+type Program struct {
+	source string
+	root   node
+}
+
+// String returns the source the program was compiled from
+func (p *Program) String() string {
+	return p.source
+}
+
+// Compile parses an expression
+func Compile(source string) (*Program, error) {
+	tokens, err := lex(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.peek().text, p.peek().pos)
+	}
+
+	return &Program{source: source, root: root}, nil
+}
+
+// MustCompile is like Compile but panics on error
+func MustCompile(source string) *Program {
+	p, err := Compile(source)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// Eval evaluates the program against the given variables. Values may be
+// string, float64, int, bool, map[string]string or map[string]interface{}.
+func (p *Program) Eval(vars map[string]interface{}) (interface{}, error) {
+	return p.root.eval(vars)
+}
+
+// EvalBool evaluates the program and requires a boolean result
+func (p *Program) EvalBool(vars map[string]interface{}) (bool, error) {
+	v, err := p.Eval(vars)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expression %q evaluated to %T, want bool", p.source, v)
+	}
+	return b, nil
+}
+
+// EvalString evaluates the program and converts the result to a string
+func (p *Program) EvalString(vars map[string]interface{}) (string, error) {
+	v, err := p.Eval(vars)
+	if err != nil {
+		return "", err
+	}
+	switch s := v.(type) {
+	case string:
+		return s, nil
+	case nil:
+		return "", nil
+	default:
+		return fmt.Sprint(s), nil
+	}
+}
+
+// Lexer
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func lex(src string) ([]token, error) {
+	var tokens []token
+	i := 0
+
+	for i < len(src) {
+		c := src[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case c == '"' || c == '\'':
+			start := i
+			i++
+			var sb strings.Builder
+			for i < len(src) && src[i] != c {
+				if src[i] == '\\' && i+1 < len(src) {
+					i++
+					switch src[i] {
+					case 'n':
+						sb.WriteByte('\n')
+					case 't':
+						sb.WriteByte('\t')
+					default:
+						sb.WriteByte(src[i])
+					}
+				} else {
+					sb.WriteByte(src[i])
+				}
+				i++
+			}
+			if i >= len(src) {
+				return nil, fmt.Errorf("unterminated string at position %d", start)
+			}
+			i++
+			tokens = append(tokens, token{kind: tokString, text: sb.String(), pos: start})
+
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(src) && (src[i] >= '0' && src[i] <= '9' || src[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: src[start:i], pos: start})
+
+		case c == '_' || unicode.IsLetter(rune(c)):
+			start := i
+			for i < len(src) && (src[i] == '_' || unicode.IsLetter(rune(src[i])) || unicode.IsDigit(rune(src[i]))) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: src[start:i], pos: start})
+
+		default:
+			start := i
+			two := ""
+			if i+1 < len(src) {
+				two = src[i : i+2]
+			}
+			switch two {
+			case "&&", "||", "==", "!=", "<=", ">=":
+				tokens = append(tokens, token{kind: tokOp, text: two, pos: start})
+				i += 2
+				continue
+			}
+			if strings.ContainsRune("!<>()[].,", rune(c)) {
+				tokens = append(tokens, token{kind: tokOp, text: string(c), pos: start})
+				i++
+				continue
+			}
+			return nil, fmt.Errorf("unexpected character %q at position %d", c, start)
+		}
+	}
+
+	return append(tokens, token{kind: tokEOF, pos: len(src)}), nil
+}
+
+// Parser
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) accept(op string) bool {
+	if t := p.peek(); t.kind == tokOp && t.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(op string) error {
+	if !p.accept(op) {
+		t := p.peek()
+		return fmt.Errorf("expected %q at position %d, found %q", op, t.pos, t.text)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseComparison()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.parseComparison()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	t := p.peek()
+	isCmp := t.kind == tokOp && (t.text == "==" || t.text == "!=" || t.text == "<" || t.text == "<=" || t.text == ">" || t.text == ">=")
+	isIn := t.kind == tokIdent && t.text == "in"
+	if !isCmp && !isIn {
+		return left, nil
+	}
+	p.next()
+
+	right, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return &compareNode{op: t.text, left: left, right: right}, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.accept("!") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{operand: operand}, nil
+	}
+	return p.parsePostfix()
+}
+
+func (p *parser) parsePostfix() (node, error) {
+	n, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		switch {
+		case p.accept("."):
+			name := p.next()
+			if name.kind != tokIdent {
+				return nil, fmt.Errorf("expected identifier after '.' at position %d", name.pos)
+			}
+			if p.accept("(") {
+				var args []node
+				if !p.accept(")") {
+					for {
+						arg, err := p.parseOr()
+						if err != nil {
+							return nil, err
+						}
+						args = append(args, arg)
+						if p.accept(")") {
+							break
+						}
+						if err := p.expect(","); err != nil {
+							return nil, err
+						}
+					}
+				}
+				n = &callNode{target: n, method: name.text, args: args}
+			} else {
+				n = &indexNode{target: n, key: &literalNode{value: name.text}}
+			}
+
+		case p.accept("["):
+			key, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			n = &indexNode{target: n, key: key}
+
+		default:
+			return n, nil
+		}
+	}
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+
+	switch t.kind {
+	case tokString:
+		return &literalNode{value: t.text}, nil
+
+	case tokNumber:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at position %d", t.text, t.pos)
+		}
+		return &literalNode{value: f}, nil
+
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return &literalNode{value: true}, nil
+		case "false":
+			return &literalNode{value: false}, nil
+		case "null":
+			return &literalNode{value: nil}, nil
+		}
+		return &identNode{name: t.text}, nil
+
+	case tokOp:
+		if t.text == "(" {
+			n, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return n, nil
+		}
+		if t.text == "[" {
+			var items []node
+			if !p.accept("]") {
+				for {
+					item, err := p.parseOr()
+					if err != nil {
+						return nil, err
+					}
+					items = append(items, item)
+					if p.accept("]") {
+						break
+					}
+					if err := p.expect(","); err != nil {
+						return nil, err
+					}
+				}
+			}
+			return &listNode{items: items}, nil
+		}
+	}
+
+	if t.kind == tokEOF {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q at position %d", t.text, t.pos)
+}
+
+// AST and evaluation
+
+type node interface {
+	eval(vars map[string]interface{}) (interface{}, error)
+}
+
+type literalNode struct{ value interface{} }
+
+func (n *literalNode) eval(map[string]interface{}) (interface{}, error) { return n.value, nil }
+
+type identNode struct{ name string }
+
+func (n *identNode) eval(vars map[string]interface{}) (interface{}, error) {
+	v, ok := vars[n.name]
+	if !ok {
+		return nil, fmt.Errorf("undefined variable %q", n.name)
+	}
+	return normalize(v), nil
+}
+
+type listNode struct{ items []node }
+
+func (n *listNode) eval(vars map[string]interface{}) (interface{}, error) {
+	values := make([]interface{}, len(n.items))
+	for i, item := range n.items {
+		v, err := item.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+type indexNode struct {
+	target node
+	key    node
+}
+
+func (n *indexNode) eval(vars map[string]interface{}) (interface{}, error) {
+	target, err := n.target.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	key, err := n.key.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	k, ok := key.(string)
+	if !ok {
+		return nil, fmt.Errorf("index key must be a string, got %T", key)
+	}
+
+	switch m := target.(type) {
+	case map[string]interface{}:
+		return normalize(m[k]), nil
+	case map[string]string:
+		// Missing keys evaluate to "" so header checks don't need guards
+		return m[k], nil
+	case nil:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("cannot index %T", target)
+	}
+}
+
+type notNode struct{ operand node }
+
+func (n *notNode) eval(vars map[string]interface{}) (interface{}, error) {
+	v, err := n.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return nil, fmt.Errorf("'!' requires bool, got %T", v)
+	}
+	return !b, nil
+}
+
+type logicalNode struct {
+	op          string
+	left, right node
+}
+
+func (n *logicalNode) eval(vars map[string]interface{}) (interface{}, error) {
+	l, err := n.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	lb, ok := l.(bool)
+	if !ok {
+		return nil, fmt.Errorf("%q requires bool operands, got %T", n.op, l)
+	}
+
+	// Short-circuit
+	if n.op == "&&" && !lb {
+		return false, nil
+	}
+	if n.op == "||" && lb {
+		return true, nil
+	}
+
+	r, err := n.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	rb, ok := r.(bool)
+	if !ok {
+		return nil, fmt.Errorf("%q requires bool operands, got %T", n.op, r)
+	}
+	return rb, nil
+}
+
+type compareNode struct {
+	op          string
+	left, right node
+}
+
+func (n *compareNode) eval(vars map[string]interface{}) (interface{}, error) {
+	l, err := n.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	r, err := n.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return equal(l, r), nil
+	case "!=":
+		return !equal(l, r), nil
+	case "in":
+		switch c := r.(type) {
+		case []interface{}:
+			for _, item := range c {
+				if equal(l, item) {
+					return true, nil
+				}
+			}
+			return false, nil
+		case map[string]string:
+			k, _ := l.(string)
+			_, ok := c[k]
+			return ok, nil
+		case map[string]interface{}:
+			k, _ := l.(string)
+			_, ok := c[k]
+			return ok, nil
+		case string:
+			s, ok := l.(string)
+			return ok && strings.Contains(c, s), nil
+		default:
+			return nil, fmt.Errorf("'in' requires a list, map or string, got %T", r)
+		}
+	}
+
+	// Ordering comparisons work on numbers or strings
+	if lf, ok := l.(float64); ok {
+		rf, ok := r.(float64)
+		if !ok {
+			return nil, fmt.Errorf("cannot compare number with %T", r)
+		}
+		return compareOrdered(n.op, lf, rf), nil
+	}
+	if ls, ok := l.(string); ok {
+		rs, ok := r.(string)
+		if !ok {
+			return nil, fmt.Errorf("cannot compare string with %T", r)
+		}
+		return compareOrdered(n.op, ls, rs), nil
+	}
+	return nil, fmt.Errorf("cannot order %T", l)
+}
+
+func compareOrdered[T float64 | string](op string, l, r T) bool {
+	switch op {
+	case "<":
+		return l < r
+	case "<=":
+		return l <= r
+	case ">":
+		return l > r
+	default:
+		return l >= r
+	}
+}
+
+type callNode struct {
+	target node
+	method string
+	args   []node
+}
+
+// regexCache avoids recompiling regular expressions used with matches()
+var regexCache sync.Map
+
+func (n *callNode) eval(vars map[string]interface{}) (interface{}, error) {
+	target, err := n.target.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := target.(string)
+	if !ok {
+		if target != nil {
+			return nil, fmt.Errorf("%s() requires a string receiver, got %T", n.method, target)
+		}
+		s = ""
+	}
+
+	args := make([]string, len(n.args))
+	for i, a := range n.args {
+		v, err := a.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		arg, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s() requires string arguments, got %T", n.method, v)
+		}
+		args[i] = arg
+	}
+
+	arity := func(want int) error {
+		if len(args) != want {
+			return fmt.Errorf("%s() takes %d argument(s), got %d", n.method, want, len(args))
+		}
+		return nil
+	}
+
+	switch n.method {
+	case "startsWith":
+		if err := arity(1); err != nil {
+			return nil, err
+		}
+		return strings.HasPrefix(s, args[0]), nil
+	case "endsWith":
+		if err := arity(1); err != nil {
+			return nil, err
+		}
+		return strings.HasSuffix(s, args[0]), nil
+	case "contains":
+		if err := arity(1); err != nil {
+			return nil, err
+		}
+		return strings.Contains(s, args[0]), nil
+	case "matches":
+		if err := arity(1); err != nil {
+			return nil, err
+		}
+		re, err := cachedRegexp(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString(s), nil
+	case "lower":
+		if err := arity(0); err != nil {
+			return nil, err
+		}
+		return strings.ToLower(s), nil
+	case "upper":
+		if err := arity(0); err != nil {
+			return nil, err
+		}
+		return strings.ToUpper(s), nil
+	default:
+		return nil, fmt.Errorf("unknown method %q", n.method)
+	}
+}
+
+func cachedRegexp(pattern string) (*regexp.Regexp, error) {
+	if re, ok := regexCache.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression %q: %w", pattern, err)
+	}
+	regexCache.Store(pattern, re)
+	return re, nil
+}
+
+// normalize converts Go values into the types the evaluator works with
+func normalize(v interface{}) interface{} {
+	switch x := v.(type) {
+	case int:
+		return float64(x)
+	case int64:
+		return float64(x)
+	case int32:
+		return float64(x)
+	case float32:
+		return float64(x)
+	default:
+		return v
+	}
+}
+
+func equal(a, b interface{}) bool {
+	a, b = normalize(a), normalize(b)
+	// A missing map entry compares equal to both null and ""
+	if a == nil {
+		return b == nil || b == ""
+	}
+	if b == nil {
+		return a == ""
+	}
+	switch a.(type) {
+	case string, float64, bool:
+		return a == b
+	}
+	return false
+}
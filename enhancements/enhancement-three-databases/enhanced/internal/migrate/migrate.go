@@ -0,0 +1,97 @@
+// Package migrate applies the embedded goose migrations to PostgreSQL with
+// goose's provider, so databases migrated with the goose CLI and by the
+// proxy stay interchangeable.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+
+	"github.com/pressly/goose/v3"
+	"github.com/pressly/goose/v3/lock"
+)
+
+// lockKey is the Postgres advisory lock key held while migrating, so proxy
+// instances starting together apply each migration once
+const lockKey int64 = 0x7270726f78790002
+
+// versionTable is goose's version bookkeeping table
+const versionTable = "goose_db_version"
+
+// Status is a database's schema version against the embedded migrations
+type Status struct {
+	Current int64 `json:"current"`
+	Latest  int64 `json:"latest"`
+	Pending int   `json:"pending"`
+}
+
+// newProvider returns a goose provider for the migrations in fsys that
+// holds the advisory lock while it migrates
+func newProvider(database *sql.DB, fsys fs.FS) (*goose.Provider, error) {
+	locker, err := lock.NewPostgresSessionLocker(lock.WithLockID(lockKey))
+	if err != nil {
+		return nil, err
+	}
+	return goose.NewProvider(goose.DialectPostgres, database, fsys,
+		goose.WithSessionLocker(locker),
+		goose.WithTableName(versionTable),
+		goose.WithDisableGlobalRegistry(true),
+	)
+}
+
+// Up applies every pending migration under an advisory lock and returns the
+// versions it applied. Each migration runs in its own transaction with its
+// version row.
+func Up(ctx context.Context, database *sql.DB, fsys fs.FS) ([]int64, error) {
+	provider, err := newProvider(database, fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := provider.Up(ctx)
+	applied := make([]int64, 0, len(results))
+	for _, result := range results {
+		if result.Error == nil {
+			applied = append(applied, result.Source.Version)
+		}
+	}
+	if err != nil {
+		return applied, fmt.Errorf("migrate: %w", err)
+	}
+	return applied, nil
+}
+
+// GetStatus compares the database's schema version with the embedded
+// migrations. A database never migrated reports version 0; it is only
+// read, so the version table is not created.
+func GetStatus(ctx context.Context, database *sql.DB, fsys fs.FS) (Status, error) {
+	provider, err := newProvider(database, fsys)
+	if err != nil {
+		return Status{}, err
+	}
+
+	sources := provider.ListSources()
+	var status Status
+	if len(sources) > 0 {
+		status.Latest = sources[len(sources)-1].Version
+	}
+
+	var exists bool
+	if err := database.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, versionTable).Scan(&exists); err != nil {
+		return Status{}, fmt.Errorf("read schema version: %w", err)
+	}
+	if exists {
+		if status.Current, err = provider.GetDBVersion(ctx); err != nil {
+			return Status{}, fmt.Errorf("read schema version: %w", err)
+		}
+	}
+
+	for _, source := range sources {
+		if source.Version > status.Current {
+			status.Pending++
+		}
+	}
+	return status, nil
+}
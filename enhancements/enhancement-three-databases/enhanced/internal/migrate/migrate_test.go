@@ -0,0 +1,37 @@
+package migrate_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	_ "github.com/lib/pq"
+
+	"github.com/codytheroux96/go-reverse-proxy/db/migrations"
+	"github.com/codytheroux96/go-reverse-proxy/internal/migrate"
+	"github.com/codytheroux96/go-reverse-proxy/proxy/proxytest"
+)
+
+// TestUpIsIdempotent runs against the database from proxytest.Postgres,
+// which is already migrated, and is skipped when none is available
+func TestUpIsIdempotent(t *testing.T) {
+	database, err := sql.Open("postgres", proxytest.Postgres(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	ctx := context.Background()
+
+	applied, err := migrate.Up(ctx, database, migrations.FS)
+	if err != nil || len(applied) != 0 {
+		t.Fatalf("second Up applied %v, err %v; want nothing", applied, err)
+	}
+
+	status, err := migrate.GetStatus(ctx, database, migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Pending != 0 || status.Current != status.Latest || status.Latest == 0 {
+		t.Fatalf("status %+v, want fully migrated", status)
+	}
+}
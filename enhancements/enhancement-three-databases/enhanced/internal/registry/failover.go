@@ -0,0 +1,444 @@
+package registry
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/codytheroux96/go-reverse-proxy/internal/db"
+)
+
+// Failover registry modes
+const (
+	ModePrimary = "primary"
+	ModeMirror  = "mirror"
+)
+
+// FailoverRegistry serves from PostgreSQL while it is reachable and from an
+// in-memory mirror while it is not. The mirror starts from the PostgreSQL
+// registry's last route snapshot and accepts registrations and
+// deregistrations during the outage. When the database comes back they are
+// replayed into it before PostgreSQL serves again.
+//
+// Conflicts are resolved per server by the latest write: a change accepted
+// during the outage is dropped if another instance updated the same server
+// in the database after it was accepted, and applied otherwise.
+type FailoverRegistry struct {
+	primary  *PostgreSQLRegistry
+	mirror   *Registry
+	logger   *slog.Logger
+	onChange func(Change)
+
+	// writeMu serializes writes with resynchronization, so no write lands
+	// between replaying the journal and switching back to PostgreSQL
+	writeMu sync.Mutex
+
+	mu            sync.Mutex
+	degraded      bool
+	degradedSince time.Time
+	resyncing     bool
+	// journal holds the latest outage change per server, in order
+	journal    []journalEntry
+	failovers  uint64
+	resyncs    uint64
+	conflicts  uint64
+	lastResync time.Time
+	lastError  string
+}
+
+// journalEntry is a change accepted by the mirror during an outage
+type journalEntry struct {
+	Change
+	at time.Time
+}
+
+// NewFailoverRegistry wraps primary with an in-memory mirror. It takes over
+// primary's reconnect notifications.
+func NewFailoverRegistry(primary *PostgreSQLRegistry, logger *slog.Logger) *FailoverRegistry {
+	f := &FailoverRegistry{
+		primary: primary,
+		mirror:  NewRegistry(logger),
+		logger:  logger,
+	}
+	primary.OnReconnect(f.startResync)
+	return f
+}
+
+// OnChange sets a function called after every successful registration and
+// deregistration through this instance, in either mode. It must be called
+// before the registry is used.
+func (f *FailoverRegistry) OnChange(fn func(Change)) {
+	f.onChange = fn
+}
+
+// isDegraded reports whether the mirror is serving, switching to it if the
+// database has become unavailable and starting a resync once it is back
+func (f *FailoverRegistry) isDegraded() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	available := f.primary.available() == nil
+	switch {
+	case !f.degraded && !available:
+		f.failoverLocked()
+	case f.degraded && available && !f.resyncing:
+		// The reconnect notification can arrive before the failover
+		f.resyncing = true
+		go f.resync()
+	}
+	return f.degraded
+}
+
+// startResync resynchronizes in the background unless a resync is running
+func (f *FailoverRegistry) startResync() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.degraded && !f.resyncing {
+		f.resyncing = true
+		go f.resync()
+	}
+}
+
+// failoverLocked switches to the mirror, seeded from the route snapshot;
+// f.mu must be held
+func (f *FailoverRegistry) failoverLocked() {
+	f.mirror.replace(f.primary.snapshotServers())
+	f.degraded = true
+	f.degradedSince = time.Now()
+	f.failovers++
+	f.logger.Warn("Registry database unavailable, serving from in-memory mirror")
+}
+
+// record adds an outage change to the journal, superseding any earlier
+// change to the same server
+func (f *FailoverRegistry) record(c Change) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i, entry := range f.journal {
+		if entry.Server.Name == c.Server.Name {
+			f.journal = append(f.journal[:i], f.journal[i+1:]...)
+			break
+		}
+	}
+	f.journal = append(f.journal, journalEntry{Change: c, at: time.Now()})
+}
+
+func (f *FailoverRegistry) notify(c Change) {
+	if f.onChange != nil {
+		f.onChange(c)
+	}
+}
+
+func (f *FailoverRegistry) Register(s Server) error {
+	f.writeMu.Lock()
+	defer f.writeMu.Unlock()
+
+	if !f.isDegraded() {
+		// A write that loses the database fails over and lands in the mirror
+		err := f.primary.Register(s)
+		if err == nil {
+			f.notify(Change{Kind: ChangeRegistered, Server: s})
+			return nil
+		}
+		if !f.isDegraded() {
+			return err
+		}
+	}
+
+	f.mirror.put(s)
+	f.record(Change{Kind: ChangeRegistered, Server: s})
+	f.logger.Info("Service registered in mirror", "service", s.Name)
+	f.notify(Change{Kind: ChangeRegistered, Server: s})
+	return nil
+}
+
+// RegisterBatch registers every server or none, in PostgreSQL or, during an
+// outage, in the mirror
+func (f *FailoverRegistry) RegisterBatch(servers []Server) error {
+	f.writeMu.Lock()
+	defer f.writeMu.Unlock()
+
+	if !f.isDegraded() {
+		err := f.primary.RegisterBatch(servers)
+		if err == nil {
+			for _, s := range servers {
+				f.notify(Change{Kind: ChangeRegistered, Server: s})
+			}
+			return nil
+		}
+		if !f.isDegraded() {
+			return err
+		}
+	}
+
+	for _, s := range servers {
+		f.mirror.put(s)
+		f.record(Change{Kind: ChangeRegistered, Server: s})
+	}
+	f.logger.Info("Services registered in mirror", "count", len(servers))
+	for _, s := range servers {
+		f.notify(Change{Kind: ChangeRegistered, Server: s})
+	}
+	return nil
+}
+
+func (f *FailoverRegistry) Deregister(name string) error {
+	f.writeMu.Lock()
+	defer f.writeMu.Unlock()
+
+	if !f.isDegraded() {
+		// A write that loses the database fails over and lands in the mirror
+		err := f.primary.Deregister(name)
+		if err == nil {
+			f.notify(Change{Kind: ChangeDeregistered, Server: Server{Name: name}})
+			return nil
+		}
+		if !f.isDegraded() {
+			return err
+		}
+	}
+
+	s, exists := f.mirror.remove(name)
+	if !exists {
+		return fmt.Errorf("server '%s' does not exist... cannot deregister", name)
+	}
+	f.record(Change{Kind: ChangeDeregistered, Server: s})
+	f.logger.Info("Service deregistered in mirror", "service", name)
+	f.notify(Change{Kind: ChangeDeregistered, Server: s})
+	return nil
+}
+
+func (f *FailoverRegistry) GetServers() ([]Server, error) {
+	if f.isDegraded() {
+		return f.mirror.GetServers()
+	}
+	return f.primary.GetServers()
+}
+
+func (f *FailoverRegistry) GetServer(name string) (*Server, error) {
+	if f.isDegraded() {
+		return f.mirror.GetServer(name)
+	}
+	return f.primary.GetServer(name)
+}
+
+func (f *FailoverRegistry) ServersForPath(requestPath string) (string, []Server, bool) {
+	if f.isDegraded() {
+		return f.mirror.ServersForPath(requestPath)
+	}
+	return f.primary.ServersForPath(requestPath)
+}
+
+// resync replays the outage journal into the database and switches back to
+// it. A replay that loses the database again leaves the rest of the journal
+// for the next reconnect.
+func (f *FailoverRegistry) resync() {
+	f.writeMu.Lock()
+	defer f.writeMu.Unlock()
+
+	f.mu.Lock()
+	journal := append([]journalEntry(nil), f.journal...)
+	f.mu.Unlock()
+
+	applied, conflicts := 0, 0
+	for i, entry := range journal {
+		conflict, err := f.replay(entry)
+		if err != nil {
+			f.primary.failed(err)
+			f.mu.Lock()
+			f.journal = f.journal[i:]
+			f.lastError = err.Error()
+			f.resyncing = false
+			f.mu.Unlock()
+			f.logger.Error("Failed to resynchronize registry, staying on mirror", "error", err, "service", entry.Server.Name)
+			return
+		}
+		if conflict {
+			conflicts++
+		} else {
+			applied++
+		}
+	}
+
+	f.mu.Lock()
+	f.journal = nil
+	f.degraded = false
+	f.resyncing = false
+	f.resyncs++
+	f.conflicts += uint64(conflicts)
+	f.lastResync = time.Now()
+	f.lastError = ""
+	f.mu.Unlock()
+	f.logger.Info("Registry resynchronized with database", "applied", applied, "conflicts", conflicts)
+}
+
+// replay applies one outage change to the database, reporting a conflict
+// instead when the database copy of the server changed after it
+func (f *FailoverRegistry) replay(entry journalEntry) (bool, error) {
+	ctx, cancel := f.primary.queryContext()
+	defer cancel()
+
+	current, err := f.primary.queries.GetService(ctx, entry.Server.Name)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		if entry.Kind == ChangeDeregistered {
+			return false, nil
+		}
+	case err != nil:
+		if isConnError(err) {
+			return false, err
+		}
+		// The database rejected the lookup; drop the change rather than
+		// blocking every later one
+		f.logger.Error("Dropping registry change from outage", "error", err, "service", entry.Server.Name)
+		return true, nil
+	case current.UpdatedAt.Valid && current.UpdatedAt.Time.After(entry.at):
+		f.logger.Warn("Registry change from outage superseded by database",
+			"service", entry.Server.Name, "change", entry.Kind, "updated_at", current.UpdatedAt.Time)
+		return true, nil
+	}
+
+	if entry.Kind == ChangeDeregistered {
+		err = f.primary.queries.DeleteService(ctx, entry.Server.Name)
+	} else {
+		_, err = f.primary.queries.RegisterService(ctx, db.RegisterServiceParams{
+			Name:         entry.Server.Name,
+			BaseUrl:      entry.Server.BaseURL,
+			Prefixes:     entry.Server.Prefixes,
+			Zone:         entry.Server.Zone,
+			ServiceGroup: entry.Server.Service,
+		})
+	}
+	if err != nil && !isConnError(err) {
+		f.logger.Error("Dropping registry change from outage", "error", err, "service", entry.Server.Name)
+		return true, nil
+	}
+	return false, err
+}
+
+// FailoverStats reports which registry is serving and the outage journal
+type FailoverStats struct {
+	Mode string `json:"mode"`
+	// DegradedSince is when the mirror took over, while it is serving
+	DegradedSince *time.Time `json:"degraded_since,omitempty"`
+	// Pending counts outage changes waiting to be replayed
+	Pending    int        `json:"pending"`
+	Failovers  uint64     `json:"failovers"`
+	Resyncs    uint64     `json:"resyncs"`
+	Conflicts  uint64     `json:"conflicts"`
+	LastResync *time.Time `json:"last_resync,omitempty"`
+	LastError  string     `json:"last_error,omitempty"`
+}
+
+// FailoverStats returns the registry's mode and resynchronization counters
+func (f *FailoverRegistry) FailoverStats() FailoverStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	stats := FailoverStats{
+		Mode:      ModePrimary,
+		Pending:   len(f.journal),
+		Failovers: f.failovers,
+		Resyncs:   f.resyncs,
+		Conflicts: f.conflicts,
+		LastError: f.lastError,
+	}
+	if f.degraded {
+		stats.Mode = ModeMirror
+		since := f.degradedSince
+		stats.DegradedSince = &since
+	}
+	if !f.lastResync.IsZero() {
+		at := f.lastResync
+		stats.LastResync = &at
+	}
+	return stats
+}
+
+// PoolStats returns the PostgreSQL connection pool stats
+func (f *FailoverRegistry) PoolStats() PoolStats {
+	return f.primary.PoolStats()
+}
+
+// DB exposes the PostgreSQL connection for other PostgreSQL-backed stores
+func (f *FailoverRegistry) DB() *sql.DB {
+	return f.primary.DB()
+}
+
+func (f *FailoverRegistry) Close() error {
+	return f.primary.Close()
+}
+
+// HTTP Handlers
+func (f *FailoverRegistry) HandleRegister(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var srv Server
+	if err := json.NewDecoder(req.Body).Decode(&srv); err != nil {
+		http.Error(w, "invalid payload in request", http.StatusBadRequest)
+		return
+	}
+
+	srv.RegisteredAt = time.Now()
+
+	if err := f.Register(srv); err != nil {
+		f.logger.Error("Failed to register server", "error", err)
+		http.Error(w, "failed to register server", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"status": "registered", "server": srv.Name})
+}
+
+func (f *FailoverRegistry) HandleDeregister(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := req.URL.Query().Get("name")
+	if name == "" {
+		http.Error(w, "name parameter required", http.StatusBadRequest)
+		return
+	}
+
+	if err := f.Deregister(name); err != nil {
+		f.logger.Error("Failed to deregister server", "error", err)
+		http.Error(w, "failed to deregister server", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"status": "deregistered", "server": name})
+}
+
+func (f *FailoverRegistry) HandleRegistryList(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	servers, err := f.GetServers()
+	if err != nil {
+		f.logger.Error("Failed to get servers", "error", err)
+		http.Error(w, "failed to get servers", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(servers)
+}
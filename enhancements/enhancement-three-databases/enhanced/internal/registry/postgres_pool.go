@@ -0,0 +1,337 @@
+package registry
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/codytheroux96/go-reverse-proxy/internal/db"
+	"github.com/lib/pq"
+)
+
+// Connection pool defaults applied to zero PoolConfig fields
+const (
+	DefaultMaxOpenConns        = 20
+	DefaultMaxIdleConns        = 10
+	DefaultConnMaxLifetime     = 30 * time.Minute
+	DefaultConnMaxIdleTime     = 5 * time.Minute
+	DefaultPingInterval        = 10 * time.Second
+	DefaultQueryTimeout        = 5 * time.Second
+	DefaultReconnectMaxBackoff = 30 * time.Second
+)
+
+// reconnectMinBackoff is the first retry delay after the database goes away
+const reconnectMinBackoff = time.Second
+
+// ErrDatabaseUnavailable is returned by registry writes while the database
+// cannot be reached. Reads fall back to the last route snapshot instead.
+var ErrDatabaseUnavailable = errors.New("registry database unavailable")
+
+// PoolConfig sizes the PostgreSQL connection pool and tunes how the registry
+// notices the database going away and coming back
+type PoolConfig struct {
+	// MaxOpenConns caps open connections; 0 uses DefaultMaxOpenConns
+	MaxOpenConns int
+	// MaxIdleConns caps idle connections kept for reuse; 0 uses
+	// DefaultMaxIdleConns
+	MaxIdleConns int
+	// ConnMaxLifetime closes connections older than this; 0 uses
+	// DefaultConnMaxLifetime
+	ConnMaxLifetime time.Duration
+	// ConnMaxIdleTime closes connections idle for longer than this; 0 uses
+	// DefaultConnMaxIdleTime
+	ConnMaxIdleTime time.Duration
+	// PingInterval is how often a healthy database is pinged; 0 uses
+	// DefaultPingInterval
+	PingInterval time.Duration
+	// QueryTimeout bounds every registry query and ping; 0 uses
+	// DefaultQueryTimeout
+	QueryTimeout time.Duration
+	// ReconnectMaxBackoff caps the doubling delay between pings while the
+	// database is unavailable; 0 uses DefaultReconnectMaxBackoff
+	ReconnectMaxBackoff time.Duration
+}
+
+// withDefaults validates cfg and fills in zero fields
+func (cfg PoolConfig) withDefaults() (PoolConfig, error) {
+	if cfg.MaxOpenConns < 0 || cfg.MaxIdleConns < 0 {
+		return cfg, fmt.Errorf("connection limits must not be negative")
+	}
+	if cfg.ConnMaxLifetime < 0 || cfg.ConnMaxIdleTime < 0 || cfg.PingInterval < 0 ||
+		cfg.QueryTimeout < 0 || cfg.ReconnectMaxBackoff < 0 {
+		return cfg, fmt.Errorf("pool durations must not be negative")
+	}
+	if cfg.MaxOpenConns == 0 {
+		cfg.MaxOpenConns = DefaultMaxOpenConns
+	}
+	if cfg.MaxIdleConns == 0 {
+		cfg.MaxIdleConns = DefaultMaxIdleConns
+	}
+	if cfg.MaxIdleConns > cfg.MaxOpenConns {
+		cfg.MaxIdleConns = cfg.MaxOpenConns
+	}
+	if cfg.ConnMaxLifetime == 0 {
+		cfg.ConnMaxLifetime = DefaultConnMaxLifetime
+	}
+	if cfg.ConnMaxIdleTime == 0 {
+		cfg.ConnMaxIdleTime = DefaultConnMaxIdleTime
+	}
+	if cfg.PingInterval == 0 {
+		cfg.PingInterval = DefaultPingInterval
+	}
+	if cfg.QueryTimeout == 0 {
+		cfg.QueryTimeout = DefaultQueryTimeout
+	}
+	if cfg.ReconnectMaxBackoff == 0 {
+		cfg.ReconnectMaxBackoff = DefaultReconnectMaxBackoff
+	}
+	return cfg, nil
+}
+
+// PoolStats reports the connection pool and database availability
+type PoolStats struct {
+	Available bool   `json:"available"`
+	LastError string `json:"last_error,omitempty"`
+	// UnavailableSince is when the database was last found unreachable
+	UnavailableSince  *time.Time `json:"unavailable_since,omitempty"`
+	PingFailures      uint64     `json:"ping_failures"`
+	Reconnects        uint64     `json:"reconnects"`
+	SnapshotReads     uint64     `json:"snapshot_reads"`
+	SnapshotServices  int        `json:"snapshot_services"`
+	SnapshotAt        *time.Time `json:"snapshot_at,omitempty"`
+	MaxOpenConns      int        `json:"max_open_conns"`
+	OpenConns         int        `json:"open_conns"`
+	InUse             int        `json:"in_use"`
+	Idle              int        `json:"idle"`
+	WaitCount         int64      `json:"wait_count"`
+	WaitDuration      string     `json:"wait_duration"`
+	MaxIdleClosed     int64      `json:"max_idle_closed"`
+	MaxIdleTimeClosed int64      `json:"max_idle_time_closed"`
+	MaxLifetimeClosed int64      `json:"max_lifetime_closed"`
+}
+
+// PoolStats returns the pool's counters and whether the database is reachable
+func (r *PostgreSQLRegistry) PoolStats() PoolStats {
+	dbStats := r.db.Stats()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := PoolStats{
+		Available:         r.lastErr == nil,
+		PingFailures:      r.pingFailures,
+		Reconnects:        r.reconnects,
+		SnapshotReads:     r.snapshotReads,
+		SnapshotServices:  len(r.snapshot),
+		MaxOpenConns:      dbStats.MaxOpenConnections,
+		OpenConns:         dbStats.OpenConnections,
+		InUse:             dbStats.InUse,
+		Idle:              dbStats.Idle,
+		WaitCount:         dbStats.WaitCount,
+		WaitDuration:      dbStats.WaitDuration.String(),
+		MaxIdleClosed:     dbStats.MaxIdleClosed,
+		MaxIdleTimeClosed: dbStats.MaxIdleTimeClosed,
+		MaxLifetimeClosed: dbStats.MaxLifetimeClosed,
+	}
+	if r.lastErr != nil {
+		stats.LastError = r.lastErr.Error()
+		since := r.unavailableSince
+		stats.UnavailableSince = &since
+	}
+	if !r.snapshotAt.IsZero() {
+		at := r.snapshotAt
+		stats.SnapshotAt = &at
+	}
+	return stats
+}
+
+// available returns ErrDatabaseUnavailable while the database is unreachable
+func (r *PostgreSQLRegistry) available() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.lastErr != nil {
+		return fmt.Errorf("%w: %v", ErrDatabaseUnavailable, r.lastErr)
+	}
+	return nil
+}
+
+// queryContext bounds a registry query by the pool's query timeout
+func (r *PostgreSQLRegistry) queryContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), r.pool.QueryTimeout)
+}
+
+// failed marks the database unavailable when err means it could not be
+// reached, and asks the monitor to start reconnecting
+func (r *PostgreSQLRegistry) failed(err error) {
+	if !isConnError(err) {
+		return
+	}
+	r.markUnavailable(err)
+	select {
+	case r.recheck <- struct{}{}:
+	default:
+	}
+}
+
+// markUnavailable records that the database could not be reached
+func (r *PostgreSQLRegistry) markUnavailable(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.lastErr == nil {
+		r.unavailableSince = time.Now()
+		r.logger.Error("Registry database unavailable, serving routes from snapshot", "error", err)
+	}
+	r.lastErr = err
+}
+
+// isConnError reports whether err means the database could not be reached,
+// as opposed to it rejecting a query
+func isConnError(err error) bool {
+	var netErr net.Error
+	var pqErr *pq.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, driver.ErrBadConn),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.As(err, &netErr):
+		return true
+	case errors.As(err, &pqErr):
+		// Class 08 is connection exceptions; 57P0x is the server shutting down
+		return pqErr.Code.Class() == "08" || strings.HasPrefix(string(pqErr.Code), "57P0")
+	}
+	return false
+}
+
+// monitor pings the database every PingInterval while it is reachable, and
+// with doubling backoff while it is not, refreshing the route snapshot after
+// each successful ping
+func (r *PostgreSQLRegistry) monitor() {
+	timer := time.NewTimer(r.pool.PingInterval)
+	defer timer.Stop()
+	backoff := reconnectMinBackoff
+
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-r.recheck:
+		case <-timer.C:
+		}
+
+		ctx, cancel := r.queryContext()
+		err := r.db.PingContext(ctx)
+		if err == nil {
+			err = r.refreshSnapshot(ctx)
+		}
+		cancel()
+
+		if err != nil {
+			r.mu.Lock()
+			r.pingFailures++
+			r.mu.Unlock()
+			r.markUnavailable(err)
+			resetTimer(timer, backoff)
+			backoff = min(backoff*2, r.pool.ReconnectMaxBackoff)
+			continue
+		}
+
+		r.mu.Lock()
+		reconnected := r.lastErr != nil
+		if reconnected {
+			r.logger.Info("Registry database reconnected", "downtime", time.Since(r.unavailableSince).Round(time.Millisecond))
+			r.lastErr = nil
+			r.reconnects++
+		}
+		r.mu.Unlock()
+		if reconnected && r.onReconnect != nil {
+			r.onReconnect()
+		}
+		backoff = reconnectMinBackoff
+		resetTimer(timer, r.pool.PingInterval)
+	}
+}
+
+// resetTimer stops timer, drains it if it fired, and restarts it
+func resetTimer(timer *time.Timer, d time.Duration) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+	timer.Reset(d)
+}
+
+// allServices lists every service, falling back to the last snapshot while
+// the database is unavailable
+func (r *PostgreSQLRegistry) allServices() ([]db.Service, error) {
+	if err := r.available(); err == nil {
+		ctx, cancel := r.queryContext()
+		defer cancel()
+		services, err := r.queries.GetAllServices(ctx)
+		if err == nil {
+			r.storeSnapshot(services)
+			return services, nil
+		}
+		r.failed(err)
+		if !isConnError(err) {
+			return nil, err
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.snapshotAt.IsZero() {
+		return nil, r.lastErrLocked()
+	}
+	r.snapshotReads++
+	return r.snapshot, nil
+}
+
+// lastErrLocked wraps the last connection error; r.mu must be held
+func (r *PostgreSQLRegistry) lastErrLocked() error {
+	return fmt.Errorf("%w: %v", ErrDatabaseUnavailable, r.lastErr)
+}
+
+// refreshSnapshot reloads the route snapshot from the database
+func (r *PostgreSQLRegistry) refreshSnapshot(ctx context.Context) error {
+	services, err := r.queries.GetAllServices(ctx)
+	if err != nil {
+		// A missing table means migrations have not run yet, not an outage
+		if isConnError(err) {
+			return err
+		}
+		return nil
+	}
+	r.storeSnapshot(services)
+	return nil
+}
+
+// snapshotServers returns the route snapshot as servers
+func (r *PostgreSQLRegistry) snapshotServers() []Server {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	servers := make([]Server, len(r.snapshot))
+	for i, service := range r.snapshot {
+		servers[i] = Server{
+			Name:         service.Name,
+			BaseURL:      service.BaseUrl,
+			Prefixes:     service.Prefixes,
+			Zone:         service.Zone,
+			Service:      service.ServiceGroup,
+			RegisteredAt: service.CreatedAt.Time,
+		}
+	}
+	return servers
+}
+
+// storeSnapshot replaces the route snapshot
+func (r *PostgreSQLRegistry) storeSnapshot(services []db.Service) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.snapshot = services
+	r.snapshotAt = time.Now()
+}
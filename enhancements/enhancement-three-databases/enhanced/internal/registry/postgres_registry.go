@@ -4,10 +4,12 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/codytheroux96/go-reverse-proxy/internal/db"
@@ -15,67 +17,187 @@ import (
 )
 
 type PostgreSQLRegistry struct {
-	queries *db.Queries
-	db      *sql.DB
-	logger  *slog.Logger
+	queries  *db.Queries
+	db       *sql.DB
+	logger   *slog.Logger
+	onChange func(Change)
+	pool     PoolConfig
+	// onReconnect is called by the monitor when the database comes back
+	onReconnect func()
+
+	recheck   chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu               sync.Mutex
+	lastErr          error
+	unavailableSince time.Time
+	pingFailures     uint64
+	reconnects       uint64
+	// snapshot is the last service list read from the database, served
+	// while it is unavailable
+	snapshot      []db.Service
+	snapshotAt    time.Time
+	snapshotReads uint64
 }
 
+// NewPostgreSQLRegistry connects with the default pool settings
 func NewPostgreSQLRegistry(databaseURL string, logger *slog.Logger) (*PostgreSQLRegistry, error) {
+	return NewPostgreSQLRegistryWithPool(databaseURL, logger, PoolConfig{})
+}
+
+// NewPostgreSQLRegistryWithPool connects with the given pool settings and
+// starts pinging the database in the background until Close
+func NewPostgreSQLRegistryWithPool(databaseURL string, logger *slog.Logger, pool PoolConfig) (*PostgreSQLRegistry, error) {
+	pool, err := pool.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+
 	database, err := sql.Open("postgres", databaseURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
-
-	if err := database.Ping(); err != nil {
+	database.SetMaxOpenConns(pool.MaxOpenConns)
+	database.SetMaxIdleConns(pool.MaxIdleConns)
+	database.SetConnMaxLifetime(pool.ConnMaxLifetime)
+	database.SetConnMaxIdleTime(pool.ConnMaxIdleTime)
+
+	ctx, cancel := context.WithTimeout(context.Background(), pool.QueryTimeout)
+	defer cancel()
+	if err := database.PingContext(ctx); err != nil {
 		database.Close()
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	return &PostgreSQLRegistry{
+	r := &PostgreSQLRegistry{
 		queries: db.New(database),
 		db:      database,
 		logger:  logger,
-	}, nil
+		pool:    pool,
+		recheck: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	r.refreshSnapshot(ctx)
+	go r.monitor()
+	return r, nil
+}
+
+// OnChange sets a function called after every successful registration and
+// deregistration through this instance. It must be called before the
+// registry is used.
+func (r *PostgreSQLRegistry) OnChange(fn func(Change)) {
+	r.onChange = fn
+}
+
+// OnReconnect sets a function called when the database becomes reachable
+// again after an outage. It must be called before the registry is used.
+func (r *PostgreSQLRegistry) OnReconnect(fn func()) {
+	r.onReconnect = fn
 }
 
 func (r *PostgreSQLRegistry) Register(s Server) error {
-	ctx := context.Background()
+	if err := r.available(); err != nil {
+		return err
+	}
+	ctx, cancel := r.queryContext()
+	defer cancel()
 
 	// Convert []string to pq.StringArray for PostgreSQL
 	prefixes := pq.StringArray(s.Prefixes)
 
 	service, err := r.queries.RegisterService(ctx, db.RegisterServiceParams{
-		Name:     s.Name,
-		BaseUrl:  s.BaseURL,
-		Prefixes: prefixes,
+		Name:         s.Name,
+		BaseUrl:      s.BaseURL,
+		Prefixes:     prefixes,
+		Zone:         s.Zone,
+		ServiceGroup: s.Service,
 	})
 	if err != nil {
+		r.failed(err)
 		r.logger.Error("Failed to register service", "error", err, "service", s.Name)
 		return fmt.Errorf("failed to register service: %w", err)
 	}
 
 	r.logger.Info("Service registered", "service", s.Name, "base_url", s.BaseURL, "prefixes", s.Prefixes)
 	_ = service // Use the returned service if needed
+	if r.onChange != nil {
+		r.onChange(Change{Kind: ChangeRegistered, Server: s})
+	}
+	return nil
+}
+
+// RegisterBatch registers every server in one transaction, so either all of
+// them are registered or none are
+func (r *PostgreSQLRegistry) RegisterBatch(servers []Server) error {
+	if err := r.available(); err != nil {
+		return err
+	}
+	ctx, cancel := r.queryContext()
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		r.failed(err)
+		return fmt.Errorf("failed to begin batch registration: %w", err)
+	}
+	defer tx.Rollback()
+
+	queries := r.queries.WithTx(tx)
+	for i, s := range servers {
+		_, err := queries.RegisterService(ctx, db.RegisterServiceParams{
+			Name:         s.Name,
+			BaseUrl:      s.BaseURL,
+			Prefixes:     pq.StringArray(s.Prefixes),
+			Zone:         s.Zone,
+			ServiceGroup: s.Service,
+		})
+		if err != nil {
+			r.failed(err)
+			if isConnError(err) {
+				return fmt.Errorf("failed to register batch: %w", err)
+			}
+			r.logger.Error("Failed to register service in batch", "error", err, "service", s.Name)
+			return &BatchError{Failed: map[int]error{i: err}}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		r.failed(err)
+		return fmt.Errorf("failed to commit batch registration: %w", err)
+	}
+
+	r.logger.Info("Services registered in batch", "count", len(servers))
+	if r.onChange != nil {
+		for _, s := range servers {
+			r.onChange(Change{Kind: ChangeRegistered, Server: s})
+		}
+	}
 	return nil
 }
 
 func (r *PostgreSQLRegistry) Deregister(name string) error {
-	ctx := context.Background()
+	if err := r.available(); err != nil {
+		return err
+	}
+	ctx, cancel := r.queryContext()
+	defer cancel()
 
 	err := r.queries.DeleteService(ctx, name)
 	if err != nil {
+		r.failed(err)
 		r.logger.Error("Failed to deregister service", "error", err, "service", name)
 		return fmt.Errorf("failed to deregister service: %w", err)
 	}
 
 	r.logger.Info("Service deregistered", "service", name)
+	if r.onChange != nil {
+		r.onChange(Change{Kind: ChangeDeregistered, Server: Server{Name: name}})
+	}
 	return nil
 }
 
 func (r *PostgreSQLRegistry) GetServers() ([]Server, error) {
-	ctx := context.Background()
-
-	services, err := r.queries.GetAllServices(ctx)
+	services, err := r.allServices()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get services: %w", err)
 	}
@@ -90,6 +212,8 @@ func (r *PostgreSQLRegistry) GetServers() ([]Server, error) {
 			Name:         service.Name,
 			BaseURL:      service.BaseUrl,
 			Prefixes:     []string(service.Prefixes),
+			Zone:         service.Zone,
+			Service:      service.ServiceGroup,
 			RegisteredAt: registeredAt,
 		}
 	}
@@ -98,13 +222,21 @@ func (r *PostgreSQLRegistry) GetServers() ([]Server, error) {
 }
 
 func (r *PostgreSQLRegistry) GetServer(name string) (*Server, error) {
-	ctx := context.Background()
+	if r.available() != nil {
+		return r.snapshotServer(name)
+	}
+	ctx, cancel := r.queryContext()
+	defer cancel()
 
 	service, err := r.queries.GetService(ctx, name)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("server '%s' not found", name)
 		}
+		r.failed(err)
+		if isConnError(err) {
+			return r.snapshotServer(name)
+		}
 		return nil, fmt.Errorf("failed to get service: %w", err)
 	}
 
@@ -117,17 +249,31 @@ func (r *PostgreSQLRegistry) GetServer(name string) (*Server, error) {
 		Name:         service.Name,
 		BaseURL:      service.BaseUrl,
 		Prefixes:     []string(service.Prefixes),
+		Zone:         service.Zone,
+		Service:      service.ServiceGroup,
 		RegisteredAt: registeredAt,
 	}
 
 	return server, nil
 }
 
-func (r *PostgreSQLRegistry) ServersForPath(requestPath string) (string, []Server, bool) {
-	ctx := context.Background()
+// snapshotServer looks a server up in the route snapshot
+func (r *PostgreSQLRegistry) snapshotServer(name string) (*Server, error) {
+	servers, err := r.GetServers()
+	if err != nil {
+		return nil, err
+	}
+	for _, server := range servers {
+		if server.Name == name {
+			return &server, nil
+		}
+	}
+	return nil, fmt.Errorf("server '%s' not found", name)
+}
 
+func (r *PostgreSQLRegistry) ServersForPath(requestPath string) (string, []Server, bool) {
 	// Get all services and find the longest prefix match
-	services, err := r.queries.GetAllServices(ctx)
+	services, err := r.allServices()
 	if err != nil {
 		r.logger.Error("Failed to get services for path matching", "error", err)
 		return "", nil, false
@@ -160,6 +306,8 @@ func (r *PostgreSQLRegistry) ServersForPath(requestPath string) (string, []Serve
 						Name:         service.Name,
 						BaseURL:      service.BaseUrl,
 						Prefixes:     prefixes,
+						Zone:         service.Zone,
+						Service:      service.ServiceGroup,
 						RegisteredAt: registeredAt,
 					}
 					matchingServers = append(matchingServers, server)
@@ -172,7 +320,13 @@ func (r *PostgreSQLRegistry) ServersForPath(requestPath string) (string, []Serve
 	return longestPrefix, matchingServers, len(matchingServers) > 0
 }
 
+// DB exposes the underlying connection for other PostgreSQL-backed stores
+func (r *PostgreSQLRegistry) DB() *sql.DB {
+	return r.db
+}
+
 func (r *PostgreSQLRegistry) Close() error {
+	r.closeOnce.Do(func() { close(r.done) })
 	return r.db.Close()
 }
 
@@ -193,6 +347,10 @@ func (r *PostgreSQLRegistry) HandleRegister(w http.ResponseWriter, req *http.Req
 
 	if err := r.Register(srv); err != nil {
 		r.logger.Error("Failed to register server", "error", err)
+		if errors.Is(err, ErrDatabaseUnavailable) {
+			http.Error(w, "registry database unavailable", http.StatusServiceUnavailable)
+			return
+		}
 		http.Error(w, "failed to register server", http.StatusInternalServerError)
 		return
 	}
@@ -216,6 +374,10 @@ func (r *PostgreSQLRegistry) HandleDeregister(w http.ResponseWriter, req *http.R
 
 	if err := r.Deregister(name); err != nil {
 		r.logger.Error("Failed to deregister server", "error", err)
+		if errors.Is(err, ErrDatabaseUnavailable) {
+			http.Error(w, "registry database unavailable", http.StatusServiceUnavailable)
+			return
+		}
 		http.Error(w, "failed to deregister server", http.StatusInternalServerError)
 		return
 	}
@@ -9,18 +9,42 @@ import (
 )
 
 type Registry struct {
-	servers map[string]Server
-	mu      sync.RWMutex
-	logger  *slog.Logger
+	servers  map[string]Server
+	mu       sync.RWMutex
+	logger   *slog.Logger
+	onChange func(Change)
+}
+
+// Change kinds
+const (
+	ChangeRegistered   = "registered"
+	ChangeDeregistered = "deregistered"
+)
+
+// Change describes a server being registered or deregistered
+type Change struct {
+	Kind   string
+	Server Server
 }
 
 type Server struct {
 	Name         string    `json:"name"`
 	BaseURL      string    `json:"base_url"`
 	Prefixes     []string  `json:"routes"`
+	Zone         string    `json:"zone,omitempty"`
+	Service      string    `json:"service,omitempty"`
 	RegisteredAt time.Time `json:"registered_at"`
 }
 
+// ServiceName returns the logical service the server is an instance of; a
+// server registered without one is its own service
+func (s Server) ServiceName() string {
+	if s.Service != "" {
+		return s.Service
+	}
+	return s.Name
+}
+
 func NewRegistry(logger *slog.Logger) *Registry {
 	return &Registry{
 		servers: make(map[string]Server),
@@ -28,27 +52,91 @@ func NewRegistry(logger *slog.Logger) *Registry {
 	}
 }
 
-func (r *Registry) Register(s Server) error {
+// OnChange sets a function called after every successful registration and
+// deregistration
+func (r *Registry) OnChange(fn func(Change)) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	r.onChange = fn
+}
+
+func (r *Registry) Register(s Server) error {
+	r.mu.Lock()
+
 	if _, exists := r.servers[s.Name]; exists {
+		r.mu.Unlock()
 		return fmt.Errorf("server '%s' already registered", s.Name)
 	}
 
 	r.servers[s.Name] = s
+	onChange := r.onChange
+	r.mu.Unlock()
+
+	if onChange != nil {
+		onChange(Change{Kind: ChangeRegistered, Server: s})
+	}
+	return nil
+}
+
+// BatchError reports the servers that stopped a batch registration, keyed by
+// their index in the batch. None of the batch was registered.
+type BatchError struct {
+	Failed map[int]error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("%d of the batch's servers could not be registered", len(e.Failed))
+}
+
+// RegisterBatch registers every server or, if any is already registered or
+// named twice, none of them
+func (r *Registry) RegisterBatch(servers []Server) error {
+	r.mu.Lock()
+
+	failed := make(map[int]error)
+	seen := make(map[string]bool, len(servers))
+	for i, s := range servers {
+		if _, exists := r.servers[s.Name]; exists || seen[s.Name] {
+			failed[i] = fmt.Errorf("server '%s' already registered", s.Name)
+		}
+		seen[s.Name] = true
+	}
+	if len(failed) > 0 {
+		r.mu.Unlock()
+		return &BatchError{Failed: failed}
+	}
+
+	for _, s := range servers {
+		r.servers[s.Name] = s
+	}
+	onChange := r.onChange
+	r.mu.Unlock()
+
+	if onChange != nil {
+		for _, s := range servers {
+			onChange(Change{Kind: ChangeRegistered, Server: s})
+		}
+	}
 	return nil
 }
 
 func (r *Registry) Deregister(name string) error {
 	r.mu.Lock()
-	defer r.mu.Unlock()
 
-	if _, exists := r.servers[name]; !exists {
+	s, exists := r.servers[name]
+	if !exists {
+		r.mu.Unlock()
 		return fmt.Errorf("server '%s' does not exist... cannot deregister", name)
 	}
 
 	delete(r.servers, name)
+	onChange := r.onChange
+	r.mu.Unlock()
+
+	if onChange != nil {
+		onChange(Change{Kind: ChangeDeregistered, Server: s})
+	}
 	return nil
 }
 
@@ -81,6 +169,36 @@ func (r *Registry) GetServer(name string) (*Server, error) {
 	return nil, fmt.Errorf("server '%s' not found", name)
 }
 
+// put registers or replaces s without notifying OnChange
+func (r *Registry) put(s Server) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.servers[s.Name] = s
+}
+
+// remove deregisters name without notifying OnChange, reporting whether it
+// was registered
+func (r *Registry) remove(name string) (Server, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, exists := r.servers[name]
+	delete(r.servers, name)
+	return s, exists
+}
+
+// replace swaps every registered server for servers
+func (r *Registry) replace(servers []Server) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.servers = make(map[string]Server, len(servers))
+	for _, s := range servers {
+		r.servers[s.Name] = s
+	}
+}
+
 // ServersForPath returns the longest matching prefix and all servers that handle that prefix
 func (r *Registry) ServersForPath(requestPath string) (string, []Server, bool) {
 	r.mu.RLock()
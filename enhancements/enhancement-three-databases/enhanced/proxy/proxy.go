@@ -0,0 +1,1079 @@
+// Package proxy embeds the reverse proxy in another Go program.
+//
+//	reg := proxy.NewMemoryRegistry(logger)
+//	p, err := proxy.New(
+//		proxy.WithLogger(logger),
+//		proxy.WithRegistry(reg),
+//		proxy.WithCache(proxy.NewCache(time.Minute, 64<<20, logger)),
+//	)
+//	if err != nil {
+//		return err
+//	}
+//	defer p.Shutdown(context.Background())
+//	http.Handle("/", p)
+//
+// A Proxy is an http.Handler; pass WithListener to have Serve run its own
+// http.Server instead.
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/codytheroux96/go-reverse-proxy/internal/app"
+	"github.com/codytheroux96/go-reverse-proxy/internal/registry"
+)
+
+// Registry is the service registry the proxy routes from
+type Registry = app.RegistryInterface
+
+// Server is a backend registered with a Registry
+type Server = registry.Server
+
+// PoolConfig sizes the PostgreSQL registry's connection pool
+type PoolConfig = registry.PoolConfig
+
+// Cache is the LRU response cache used for GET requests
+type Cache = app.ResponseCache
+
+// Middleware wraps the proxy's handler
+type Middleware = app.Middleware
+
+// ConnLimitConfig bounds concurrent and per-IP connections on the listener
+type ConnLimitConfig = app.ConnLimitConfig
+
+// ClientStreamConfig caps concurrent requests per client and route class
+type ClientStreamConfig = app.ClientStreamConfig
+
+// ClientStreamClass is a route class with its own per-client cap
+type ClientStreamClass = app.ClientStreamClass
+
+// SamplingConfig selects requests recorded in full for GET /admin/samples
+type SamplingConfig = app.SamplingConfig
+
+// RouteLogRule samples or quiets the log lines of a route's requests
+type RouteLogRule = app.RouteLogRule
+
+// RouteMissConfig configures the cache of paths no route matched
+type RouteMissConfig = app.RouteMissConfig
+
+// SampleFilter matches requests that are always sampled
+type SampleFilter = app.SampleFilter
+
+// OpenAPIImportConfig describes an OpenAPI spec to create a route from
+type OpenAPIImportConfig = app.OpenAPIImportConfig
+
+// AdmissionConfig bounds concurrent requests and queues the rest by priority class
+type AdmissionConfig = app.AdmissionConfig
+
+// StageShedConfig skips optional stages while the proxy is overloaded
+type StageShedConfig = app.StageShedConfig
+
+// BotDetectionConfig sets the routes scored for bots and their actions
+type BotDetectionConfig = app.BotDetectionConfig
+
+// BotRouteConfig sets what a route does with likely bots
+type BotRouteConfig = app.BotRouteConfig
+
+// BotScorer adds a bot classification heuristic
+type BotScorer = app.BotScorer
+
+// TrafficSummaryConfig controls the periodic traffic summary log
+type TrafficSummaryConfig = app.TrafficSummaryConfig
+
+// SelfMonitorConfig controls how the proxy watches its own resource use
+type SelfMonitorConfig = app.SelfMonitorConfig
+
+// RuntimeStats is a sample of the proxy's own resource use
+type RuntimeStats = app.RuntimeStats
+
+// PenaltyConfig escalates rate limit violations to tarpits and temporary bans
+type PenaltyConfig = app.PenaltyConfig
+
+// RateLimitScheduleConfig switches rate limits by time of day
+type RateLimitScheduleConfig = app.RateLimitScheduleConfig
+
+// Event is a registration, health, breaker, config or cache event
+type Event = app.Event
+
+// EventType identifies what an Event reports
+type EventType = app.EventType
+
+// ClusterConfig joins replicas into a cluster that gossips backend failures
+type ClusterConfig = app.ClusterConfig
+
+// BlueGreenRoute splits a prefix's backends into groups, one of which is active
+type BlueGreenRoute = app.BlueGreenRoute
+
+// LeaderBackend coordinates leader election between proxy instances
+type LeaderBackend = app.LeaderBackend
+
+// RequestCompressionRoute sets the request body encoding a route's backends accept
+type RequestCompressionRoute = app.RequestCompressionRoute
+
+// TLSPolicy restricts how requests for a route may reach the proxy
+type TLSPolicy = app.TLSPolicy
+
+// Experiment splits a route's requests between variants served by their own servers
+type Experiment = app.Experiment
+
+// ResponseAssertion describes what a route's backend responses must look like
+type ResponseAssertion = app.ResponseAssertion
+
+// ResolverConfig sets DNS caching and fallback nameservers for backend hostnames
+type ResolverConfig = app.ResolverConfig
+
+// RangeFillConfig makes range requests fetch and cache whole objects
+type RangeFillConfig = app.RangeFillConfig
+
+// SignedURLConfig designates routes only served to signed, short-lived URLs
+type SignedURLConfig = app.SignedURLConfig
+
+// UpstreamConnConfig bounds how long pooled backend connections are reused
+type UpstreamConnConfig = app.UpstreamConnConfig
+
+// PrewarmConfig controls opening backend connections ahead of traffic
+type PrewarmConfig = app.PrewarmConfig
+
+// HeaderLimitConfig bounds the request headers forwarded to backends
+type HeaderLimitConfig = app.HeaderLimitConfig
+
+// GeoLookup resolves client addresses for the geo enrichment
+type GeoLookup = app.GeoLookup
+
+// GeoInfo is the location and network of a client address
+type GeoInfo = app.GeoInfo
+
+// DegradeConfig marks routes served from expired cache entries when they
+// have no backend
+type DegradeConfig = app.DegradeConfig
+
+// OwnershipConfig maps teams to registry tokens for prefix ownership
+type OwnershipConfig = app.OwnershipConfig
+
+// OwnershipStore persists prefix claims
+type OwnershipStore = app.OwnershipStore
+
+// WeightConfig tunes health-weighted backend selection
+type WeightConfig = app.WeightConfig
+
+// HealthCheckConfig maps backend readiness and liveness to endpoints
+type HealthCheckConfig = app.HealthCheckConfig
+
+// ZoneConfig names the proxy's zone for zone-local routing
+type ZoneConfig = app.ZoneConfig
+
+// RegistryWebhookConfig sends signed registry changes to external systems
+type RegistryWebhookConfig = app.RegistryWebhookConfig
+
+// Clock tells time for cache expiry, breaker cooldowns, health checks and retry backoff
+type Clock = app.Clock
+
+// FakeClock is a Clock for tests that only moves when advanced
+type FakeClock = app.FakeClock
+
+// NewFakeClock creates a fake clock reading now
+func NewFakeClock(now time.Time) *FakeClock {
+	return app.NewFakeClock(now)
+}
+
+// NewPostgresLeaderBackend elects a leader with a PostgreSQL advisory lock
+// and shares health through the backend_health table
+func NewPostgresLeaderBackend(database *sql.DB) LeaderBackend {
+	return app.NewPostgresLeaderBackend(database)
+}
+
+// NewRedisLeaderBackend elects a leader with an expiring Redis lease, keeping
+// its keys under prefix
+func NewRedisLeaderBackend(redisURL, prefix string) (LeaderBackend, error) {
+	return app.NewRedisLeaderBackend(redisURL, prefix)
+}
+
+// NewMemoryRegistry creates an in-memory registry
+func NewMemoryRegistry(logger *slog.Logger) Registry {
+	return registry.NewRegistry(logger)
+}
+
+// NewPostgreSQLRegistry creates a registry backed by PostgreSQL
+func NewPostgreSQLRegistry(databaseURL string, logger *slog.Logger) (Registry, error) {
+	return registry.NewPostgreSQLRegistry(databaseURL, logger)
+}
+
+// NewPostgreSQLRegistryWithPool creates a registry backed by PostgreSQL with
+// the given connection pool settings
+func NewPostgreSQLRegistryWithPool(databaseURL string, logger *slog.Logger, pool PoolConfig) (Registry, error) {
+	return registry.NewPostgreSQLRegistryWithPool(databaseURL, logger, pool)
+}
+
+// NewFailoverRegistry creates a PostgreSQL registry that serves from an
+// in-memory mirror while the database is unreachable and replays the
+// changes it accepted into the database when it comes back
+func NewFailoverRegistry(databaseURL string, logger *slog.Logger, pool PoolConfig) (Registry, error) {
+	primary, err := registry.NewPostgreSQLRegistryWithPool(databaseURL, logger, pool)
+	if err != nil {
+		return nil, err
+	}
+	return registry.NewFailoverRegistry(primary, logger), nil
+}
+
+// NewCache creates a response cache with the given TTL and byte capacity
+func NewCache(ttl time.Duration, maxBytes int, logger *slog.Logger) *Cache {
+	return app.NewResponseCache(ttl, maxBytes, logger)
+}
+
+// DefaultSelfMonitorConfig samples every second and rejects requests at 90%
+// of the detected memory limit
+func DefaultSelfMonitorConfig() SelfMonitorConfig {
+	return app.DefaultSelfMonitorConfig()
+}
+
+// Option configures a Proxy
+type Option func(*options)
+
+type options struct {
+	logger     *slog.Logger
+	registry   Registry
+	cache      *Cache
+	client     *http.Client
+	listener   net.Listener
+	cert       *tls.Certificate
+	adminToken string
+	middleware []Middleware
+	coalesce   bool
+	connLimits *app.ConnLimitConfig
+	streams    *ClientStreamConfig
+	sampling   *SamplingConfig
+	misses     *RouteMissConfig
+	logRules   []RouteLogRule
+	idemPosts  []string
+	drain      time.Duration
+	sessDrain  time.Duration
+	timeouts   *app.TimeoutConfig
+	admission  *app.AdmissionConfig
+	shed       *StageShedConfig
+	bots       *BotDetectionConfig
+	botScorers []BotScorer
+	monitor    *SelfMonitorConfig
+	traffic    *TrafficSummaryConfig
+	schemaFile string
+	openAPI    []OpenAPIImportConfig
+	rateLimit  *rateLimitAlgorithms
+	penalties  *app.PenaltyConfig
+	clock      Clock
+	resolver   *ResolverConfig
+	upstream   *UpstreamConnConfig
+	prewarm    *PrewarmConfig
+	headers    *HeaderLimitConfig
+	degrade    *DegradeConfig
+	zone       *ZoneConfig
+	weights    *WeightConfig
+	checks     *HealthCheckConfig
+	ownership  *OwnershipConfig
+	owners     OwnershipStore
+	enrich     []string
+	geo        GeoLookup
+	hardLimit  *rateLimitValues
+	softLimit  *rateLimitValues
+	costs      map[string]int
+	schedule   *app.RateLimitScheduleConfig
+	handlers   []eventHandler
+	webhooks   *RegistryWebhookConfig
+	leader     *leaderElection
+	cluster    *app.ClusterConfig
+	blueGreen  []BlueGreenRoute
+	svcWeights map[string]int
+	experiment []Experiment
+	tlsPolicy  []TLSPolicy
+	compress   []RequestCompressionRoute
+	assertions []ResponseAssertion
+	cacheAuth  map[string]string
+	rangeFill  *RangeFillConfig
+	signedURLs *SignedURLConfig
+	clientCAs  *x509.CertPool
+}
+
+type leaderElection struct {
+	backend LeaderBackend
+	id      string
+}
+
+type eventHandler struct {
+	fn    func(Event)
+	types []EventType
+}
+
+type rateLimitValues struct {
+	rps   float64
+	burst int
+}
+
+type rateLimitAlgorithms struct {
+	algorithm string
+	routes    map[string]string
+}
+
+// WithLogger sets the logger; the default writes text logs to stdout
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) { o.logger = logger }
+}
+
+// WithRegistry sets the service registry; the default is an in-memory registry
+func WithRegistry(r Registry) Option {
+	return func(o *options) { o.registry = r }
+}
+
+// WithCache replaces the default response cache
+func WithCache(c *Cache) Option {
+	return func(o *options) { o.cache = c }
+}
+
+// WithHTTPClient replaces the client used to reach backends. Health checks
+// use its transport too, with their own timeout. A client Timeout also
+// bounds reading the body, so it cuts off streamed responses.
+func WithHTTPClient(c *http.Client) Option {
+	return func(o *options) { o.client = c }
+}
+
+// WithListener sets the listener used by Serve
+func WithListener(l net.Listener) Option {
+	return func(o *options) { o.listener = l }
+}
+
+// WithResolver resolves backend hostnames for requests and health checks
+// with caching, negative caching and fallback nameservers. A client given
+// with WithHTTPClient keeps its own transport, which health checks then use.
+func WithResolver(cfg ResolverConfig) Option {
+	return func(o *options) { o.resolver = &cfg }
+}
+
+// WithUpstreamConns retires pooled backend connections by age and idle time
+// and counts their churn. A client given with WithHTTPClient keeps its own
+// transport.
+func WithUpstreamConns(cfg UpstreamConnConfig) Option {
+	return func(o *options) { o.upstream = &cfg }
+}
+
+// WithPrewarm opens a few connections, with their TLS sessions, to each
+// backend at start, on registration and on config reload, so the first
+// requests skip the handshakes
+func WithPrewarm(cfg PrewarmConfig) Option {
+	return func(o *options) { o.prewarm = &cfg }
+}
+
+// WithHeaderLimits strips listed and oversized request headers before they
+// reach backends, and answers 431 to requests whose headers stay over the
+// count or size limit
+func WithHeaderLimits(cfg HeaderLimitConfig) Option {
+	return func(o *options) { o.headers = &cfg }
+}
+
+// WithDegradedRoutes serves the last cached response, with a Warning: 110
+// header, on the given routes when every backend for them is down
+func WithDegradedRoutes(cfg DegradeConfig) Option {
+	return func(o *options) { o.degrade = &cfg }
+}
+
+// WithPrefixOwnership lets teams claim the route prefixes they register, so
+// registrations by other teams under or around them are refused. Claims are
+// kept in store, or in memory when store is nil.
+func WithPrefixOwnership(cfg OwnershipConfig, store OwnershipStore) Option {
+	return func(o *options) {
+		o.ownership = &cfg
+		o.owners = store
+	}
+}
+
+// WithHealthChecks sets the backend readiness and liveness endpoints,
+// deregisters backends that stay not live past cfg.DeregisterAfter, and
+// gives starting backends cfg.StartupTimeout to answer their checks
+func WithHealthChecks(cfg HealthCheckConfig) Option {
+	return func(o *options) { o.checks = &cfg }
+}
+
+// WithHealthWeights picks each request's backend in proportion to its health
+// check latency and recent error rate instead of round-robin, so a slow or
+// erroring backend gets less traffic before it is marked unhealthy
+func WithHealthWeights(cfg WeightConfig) Option {
+	return func(o *options) { o.weights = &cfg }
+}
+
+// WithZone keeps each route's traffic on backends registered in the proxy's
+// zone while enough of them are healthy, spilling to other zones otherwise
+func WithZone(cfg ZoneConfig) Option {
+	return func(o *options) { o.zone = &cfg }
+}
+
+// WithEnrichment sends client context headers to backends: "geo" (looked up
+// with geo), "tls", "device" and "subject". Inbound copies of the headers are
+// removed.
+func WithEnrichment(enrichments []string, geo GeoLookup) Option {
+	return func(o *options) {
+		o.enrich = enrichments
+		o.geo = geo
+	}
+}
+
+// WithClock replaces the clock behind cache expiry, breaker cooldowns,
+// health check intervals and retry backoff, typically with a FakeClock
+func WithClock(clock Clock) Option {
+	return func(o *options) { o.clock = clock }
+}
+
+// WithTLSCertificate serves TLS on the listener and reports the certificate
+// through the readiness probe
+func WithTLSCertificate(cert tls.Certificate) Option {
+	return func(o *options) { o.cert = &cert }
+}
+
+// WithClientCAs verifies client certificates presented on the TLS listener
+// against pool; TLS policies decide which routes require one
+func WithClientCAs(pool *x509.CertPool) Option {
+	return func(o *options) { o.clientCAs = pool }
+}
+
+// WithTLSPolicy adds a per-route TLS requirement. Once any policy is set,
+// requests matching none must arrive over TLS.
+func WithTLSPolicy(policy TLSPolicy) Option {
+	return func(o *options) { o.tlsPolicy = append(o.tlsPolicy, policy) }
+}
+
+// WithAdminToken requires a bearer token on /admin/ endpoints
+func WithAdminToken(token string) Option {
+	return func(o *options) { o.adminToken = token }
+}
+
+// WithRequestCoalescing shares one upstream response among identical
+// concurrent GET requests
+func WithRequestCoalescing() Option {
+	return func(o *options) { o.coalesce = true }
+}
+
+// WithDrainTimeout bounds how long deregistration waits for a backend's
+// in-flight requests before removing it anyway
+func WithDrainTimeout(timeout time.Duration) Option {
+	return func(o *options) { o.drain = timeout }
+}
+
+// WithSessionDrain makes Shutdown first ask clients, for period, to
+// reconnect elsewhere, failing readiness and closing keep-alive connections
+// after each response, before it stops accepting connections
+func WithSessionDrain(period time.Duration) Option {
+	return func(o *options) { o.sessDrain = period }
+}
+
+// WithConnectionLimits bounds the connections Serve accepts on its listener
+func WithConnectionLimits(cfg ConnLimitConfig) Option {
+	return func(o *options) { o.connLimits = &cfg }
+}
+
+// WithClientStreamLimits caps how many requests one client may have in
+// flight, per route class, and HTTP/2 streams per connection
+func WithClientStreamLimits(cfg ClientStreamConfig) Option {
+	return func(o *options) { o.streams = &cfg }
+}
+
+// WithSampling records the full detail of a random share of requests, and
+// of every request matching a filter, for GET /admin/samples
+func WithSampling(cfg SamplingConfig) Option {
+	return func(o *options) { o.sampling = &cfg }
+}
+
+// WithRouteLogRules samples or quiets the info and debug lines logged for
+// requests under each rule's prefix; warnings and errors are always logged.
+// The rules can be changed at runtime with PUT /admin/logging/routes.
+func WithRouteLogRules(rules ...RouteLogRule) Option {
+	return func(o *options) { o.logRules = rules }
+}
+
+// WithIdempotentPosts retries POSTs under the given path prefixes like
+// idempotent requests. Other POSTs are only retried when the failed attempt
+// never reached a backend. The prefixes can be changed at runtime with PUT
+// /admin/retries.
+func WithIdempotentPosts(prefixes ...string) Option {
+	return func(o *options) { o.idemPosts = prefixes }
+}
+
+// WithRouteMissCache remembers paths no route matched for a short while, so
+// repeated requests for them are answered without a registry lookup
+func WithRouteMissCache(cfg RouteMissConfig) Option {
+	return func(o *options) { o.misses = &cfg }
+}
+
+// WithRequestTimeout bounds each request overall, answering 504 when it is
+// exceeded; routes maps prefixes to timeouts that override def
+func WithRequestTimeout(def time.Duration, routes map[string]time.Duration) Option {
+	return func(o *options) { o.timeouts = &app.TimeoutConfig{Default: def, Routes: routes} }
+}
+
+// WithSchemaFile validates JSON request bodies against the per-route schemas
+// in a schema config file, reloading them when the files change
+func WithSchemaFile(path string) Option {
+	return func(o *options) { o.schemaFile = path }
+}
+
+// WithOpenAPIImport creates a route from an OpenAPI spec, forwarding only the
+// operations it defines
+func WithOpenAPIImport(cfg OpenAPIImportConfig) Option {
+	return func(o *options) { o.openAPI = append(o.openAPI, cfg) }
+}
+
+// WithBlueGreenRoute sends a prefix's traffic only to the route's active
+// backend group; POST /admin/bluegreen/cutover switches groups
+func WithBlueGreenRoute(route BlueGreenRoute) Option {
+	return func(o *options) { o.blueGreen = append(o.blueGreen, route) }
+}
+
+// WithServiceWeights splits the traffic of routes shared by several logical
+// services in proportion to these weights, keyed by service; unlisted
+// services weigh 1
+func WithServiceWeights(weights map[string]int) Option {
+	return func(o *options) { o.svcWeights = weights }
+}
+
+// WithExperiment assigns a route's requests to the experiment's variants,
+// routing each to its variant's servers and tagging it with X-Experiment
+func WithExperiment(e Experiment) Option {
+	return func(o *options) { o.experiment = append(o.experiment, e) }
+}
+
+// WithCacheAuthRoutes lets requests with an Authorization or Cookie header
+// use the response cache on routes mapped to "shared" or "user" (or
+// "user:<cookie>"); elsewhere such requests skip the cache
+func WithCacheAuthRoutes(routes map[string]string) Option {
+	return func(o *options) { o.cacheAuth = routes }
+}
+
+// WithRangeFill makes a range request that misses the cache on the given
+// routes fetch the whole object, cache it and answer with the slice, so later
+// ranges are served from the cache
+func WithRangeFill(cfg RangeFillConfig) Option {
+	return func(o *options) { o.rangeFill = &cfg }
+}
+
+// WithSignedURLs rejects requests to the given routes with 403 unless their
+// URL carries a valid, unexpired signature; Proxy.SignURL mints such URLs
+func WithSignedURLs(cfg SignedURLConfig) Option {
+	return func(o *options) { o.signedURLs = &cfg }
+}
+
+// WithRequestCompression decompresses or compresses POST bodies toward a
+// route's backends to the encoding they accept
+func WithRequestCompression(route RequestCompressionRoute) Option {
+	return func(o *options) { o.compress = append(o.compress, route) }
+}
+
+// WithResponseAssertion checks a route's backend responses for content type,
+// size and JSON well-formedness
+func WithResponseAssertion(assertion ResponseAssertion) Option {
+	return func(o *options) { o.assertions = append(o.assertions, assertion) }
+}
+
+// WithAdmission limits concurrent requests, queueing the rest by priority
+// class; classes are assigned by the policy file's priority_class expression
+func WithAdmission(cfg AdmissionConfig) Option {
+	return func(o *options) { o.admission = &cfg }
+}
+
+// WithSelfMonitor sets how often the proxy samples its own resource use, its
+// memory limit, and the share of it past which new requests are rejected;
+// the default detects the container limit and rejects at 90%
+func WithSelfMonitor(cfg SelfMonitorConfig) Option {
+	return func(o *options) { o.monitor = &cfg }
+}
+
+// WithTrafficSummary logs the bytes sent per route every interval, warning
+// when a route's average response grows past its baseline
+func WithTrafficSummary(cfg TrafficSummaryConfig) Option {
+	return func(o *options) { o.traffic = &cfg }
+}
+
+// WithStageShedding skips the named optional stages (wasm, schema,
+// assertions, enrichment) while the proxy's request latency or CPU use passes
+// its threshold
+func WithStageShedding(cfg StageShedConfig) Option {
+	return func(o *options) { o.shed = &cfg }
+}
+
+// WithBotDetection scores requests on the config's routes with the built-in
+// header, cookie and request rate heuristics plus scorers, and allows, rate
+// limits, challenges or blocks likely bots
+func WithBotDetection(cfg BotDetectionConfig, scorers ...BotScorer) Option {
+	return func(o *options) {
+		o.bots = &cfg
+		o.botScorers = scorers
+	}
+}
+
+// WithRateLimitAlgorithm sets the client rate limiting algorithm (token_bucket,
+// fixed_window, sliding_log or gcra); routes maps prefixes to algorithms that
+// override it
+func WithRateLimitAlgorithm(algorithm string, routes map[string]string) Option {
+	return func(o *options) { o.rateLimit = &rateLimitAlgorithms{algorithm: algorithm, routes: routes} }
+}
+
+// WithRateLimitCosts makes requests to each route prefix consume the given
+// number of units of a client's rate limit budget instead of 1
+func WithRateLimitCosts(costs map[string]int) Option {
+	return func(o *options) { o.costs = costs }
+}
+
+// WithRateLimitSchedule overrides the rate limits with the first profile
+// whose daily window contains the current time
+func WithRateLimitSchedule(cfg RateLimitScheduleConfig) Option {
+	return func(o *options) { o.schedule = &cfg }
+}
+
+// WithRateLimit sets the per-client rate limit; rps 0 disables rate limiting
+func WithRateLimit(rps float64, burst int) Option {
+	return func(o *options) { o.hardLimit = &rateLimitValues{rps: rps, burst: burst} }
+}
+
+// WithSoftRateLimit serves requests over rps (with the given burst, or the
+// hard burst scaled down when 0) but marks them with an X-RateLimit-Warning
+// header; rps must be below the hard limit
+func WithSoftRateLimit(rps float64, burst int) Option {
+	return func(o *options) { o.softLimit = &rateLimitValues{rps: rps, burst: burst} }
+}
+
+// WithRateLimitPenalties tarpits and then temporarily bans clients that keep
+// exceeding the rate limit
+func WithRateLimitPenalties(cfg PenaltyConfig) Option {
+	return func(o *options) { o.penalties = &cfg }
+}
+
+// WithEventHandler calls fn for every internal event of the given types, or
+// of every type when none are given. Events are delivered in order on a
+// goroutine per handler.
+func WithEventHandler(fn func(Event), types ...EventType) Option {
+	return func(o *options) { o.handlers = append(o.handlers, eventHandler{fn: fn, types: types}) }
+}
+
+// WithRegistryWebhooks POSTs a signed payload to each of cfg's URLs when a
+// server registers or deregisters, retrying failed deliveries and dead
+// lettering those that run out of attempts
+func WithRegistryWebhooks(cfg RegistryWebhookConfig) Option {
+	return func(o *options) { o.webhooks = &cfg }
+}
+
+// WithLeaderElection runs active health checks only while this instance is
+// the elected leader, as id (or a hostname based id when empty); the other
+// instances route with the health the leader shares through backend
+func WithLeaderElection(backend LeaderBackend, id string) Option {
+	return func(o *options) { o.leader = &leaderElection{backend: backend, id: id} }
+}
+
+// WithCluster gossips backend failures with other replicas, so they stop
+// routing to a backend as soon as one of them finds it failing
+func WithCluster(cfg ClusterConfig) Option {
+	return func(o *options) { o.cluster = &cfg }
+}
+
+// WithMiddleware appends middleware to the global chain
+func WithMiddleware(mws ...Middleware) Option {
+	return func(o *options) { o.middleware = append(o.middleware, mws...) }
+}
+
+// Proxy is an embeddable reverse proxy
+type Proxy struct {
+	app      *app.Application
+	handler  http.Handler
+	listener net.Listener
+	server   *http.Server
+	// sessDrain drains client sessions on Shutdown
+	sessDrain bool
+}
+
+// New builds a proxy from the given options and starts its background
+// components (health checks, access logging, cache cleanup)
+func New(opts ...Option) (*Proxy, error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if o.logger == nil {
+		o.logger = slog.New(slog.NewTextHandler(os.Stdout, nil))
+	}
+	if o.registry == nil {
+		o.registry = registry.NewRegistry(o.logger)
+	}
+
+	application := app.NewApplicationWithRegistry(o.logger, o.registry)
+	if o.cache != nil {
+		application.Cache = o.cache
+	}
+	if o.resolver != nil {
+		resolver, err := app.NewResolver(*o.resolver, o.logger)
+		if err != nil {
+			return nil, err
+		}
+		application.SetResolver(resolver)
+	}
+	if o.headers != nil {
+		limits, err := app.NewHeaderLimits(*o.headers)
+		if err != nil {
+			return nil, err
+		}
+		application.HeaderLimits = limits
+	}
+	if o.upstream != nil {
+		conns, err := app.NewUpstreamConns(*o.upstream)
+		if err != nil {
+			return nil, err
+		}
+		application.SetUpstreamConns(conns)
+	}
+	if o.prewarm != nil {
+		prewarmer, err := app.NewPrewarmer(*o.prewarm, o.logger)
+		if err != nil {
+			return nil, err
+		}
+		application.SetPrewarmer(prewarmer)
+	}
+	if o.enrich != nil {
+		enrichment, err := app.NewEnrichment(o.enrich, o.geo)
+		if err != nil {
+			return nil, err
+		}
+		application.Enrichment = enrichment
+	}
+	if o.degrade != nil {
+		degrader, err := app.NewDegrader(*o.degrade, o.logger)
+		if err != nil {
+			return nil, err
+		}
+		application.SetDegrader(degrader)
+	}
+	if o.ownership != nil {
+		store := o.owners
+		if store == nil {
+			store = app.NewMemoryOwnershipStore()
+		}
+		ownership, err := app.NewPrefixOwnership(*o.ownership, store)
+		if err != nil {
+			return nil, err
+		}
+		application.Ownership = ownership
+	}
+	if o.checks != nil {
+		if err := application.SetHealthChecks(*o.checks); err != nil {
+			return nil, err
+		}
+	}
+	if o.weights != nil {
+		weights, err := app.NewHealthWeights(*o.weights)
+		if err != nil {
+			return nil, err
+		}
+		application.SetHealthWeights(weights)
+	}
+	if o.zone != nil {
+		zones, err := app.NewZoneRouting(*o.zone)
+		if err != nil {
+			return nil, err
+		}
+		application.Zones = zones
+	}
+	if o.client != nil {
+		application.Client = o.client
+	}
+	if o.clock != nil {
+		application.SetClock(o.clock)
+	}
+	if o.coalesce {
+		application.Coalescer = app.NewCoalescer()
+	}
+	if o.adminToken != "" {
+		application.UseAdmin(app.RequireBearerToken(o.adminToken))
+	}
+	if o.timeouts != nil {
+		application.SetRequestTimeouts(*o.timeouts)
+	}
+	if o.drain != 0 {
+		if err := application.SetDrainTimeout(o.drain); err != nil {
+			return nil, err
+		}
+	}
+	if o.sessDrain != 0 {
+		if err := application.SetSessionDrainPeriod(o.sessDrain); err != nil {
+			return nil, err
+		}
+	}
+	if o.streams != nil {
+		if err := application.SetClientStreamLimits(*o.streams); err != nil {
+			return nil, err
+		}
+	}
+	if o.sampling != nil {
+		if err := application.SetSampling(*o.sampling); err != nil {
+			return nil, err
+		}
+	}
+	if o.logRules != nil {
+		if err := application.RouteLogging.SetRules(o.logRules); err != nil {
+			return nil, err
+		}
+	}
+	if o.idemPosts != nil {
+		if err := application.RetryPolicy.SetIdempotentPosts(o.idemPosts); err != nil {
+			return nil, err
+		}
+	}
+	if o.misses != nil {
+		if err := application.SetRouteMissCache(*o.misses); err != nil {
+			return nil, err
+		}
+	}
+	if o.admission != nil {
+		application.SetAdmission(*o.admission)
+	}
+	if o.traffic != nil {
+		if err := application.SetTrafficSummary(*o.traffic); err != nil {
+			return nil, err
+		}
+	}
+	if o.monitor != nil {
+		monitor, err := app.NewSelfMonitor(*o.monitor, o.logger)
+		if err != nil {
+			return nil, err
+		}
+		application.SetSelfMonitor(monitor)
+	}
+	if o.shed != nil {
+		shedder, err := app.NewStageShedder(*o.shed, o.logger)
+		if err != nil {
+			return nil, err
+		}
+		application.SetStageShedder(shedder)
+	}
+	if o.bots != nil {
+		detector, err := app.NewBotDetector(*o.bots, o.logger)
+		if err != nil {
+			return nil, err
+		}
+		for _, scorer := range o.botScorers {
+			detector.AddScorer(scorer)
+		}
+		application.SetBotDetector(detector)
+	}
+	if o.schedule != nil {
+		scheduler, err := app.NewRateLimitScheduler(*o.schedule, o.logger)
+		if err != nil {
+			return nil, err
+		}
+		application.RateLimitSchedule = scheduler
+	}
+	if o.costs != nil {
+		if err := application.SetRateLimitCosts(o.costs); err != nil {
+			return nil, err
+		}
+	}
+	if o.hardLimit != nil {
+		if err := application.SetRateLimit(o.hardLimit.rps, o.hardLimit.burst); err != nil {
+			return nil, err
+		}
+	}
+	if o.softLimit != nil {
+		if err := application.SetSoftRateLimit(o.softLimit.rps, o.softLimit.burst); err != nil {
+			return nil, err
+		}
+	}
+	if o.penalties != nil {
+		application.Penalties = app.NewPenaltyBox(*o.penalties)
+	}
+	if o.rateLimit != nil {
+		if err := application.SetRateLimitAlgorithms(o.rateLimit.algorithm, o.rateLimit.routes); err != nil {
+			return nil, err
+		}
+	}
+	if o.cacheAuth != nil {
+		if err := application.SetCacheAuthRoutes(o.cacheAuth); err != nil {
+			return nil, err
+		}
+	}
+	if o.rangeFill != nil {
+		if err := application.SetRangeFill(*o.rangeFill); err != nil {
+			return nil, err
+		}
+	}
+	if o.signedURLs != nil {
+		if err := application.SetSignedURLs(*o.signedURLs); err != nil {
+			return nil, err
+		}
+	}
+	if o.compress != nil {
+		if err := application.SetRequestCompression(o.compress); err != nil {
+			return nil, err
+		}
+	}
+	if o.assertions != nil {
+		assertions, err := app.NewResponseAssertions(o.assertions)
+		if err != nil {
+			return nil, err
+		}
+		application.ResponseAssertions = assertions
+	}
+	if o.schemaFile != "" {
+		if err := application.ConfigureSchemas(o.schemaFile); err != nil {
+			return nil, err
+		}
+	}
+	for _, cfg := range o.openAPI {
+		if err := application.ImportOpenAPI(cfg); err != nil {
+			return nil, err
+		}
+	}
+	for _, route := range o.blueGreen {
+		if err := application.BlueGreen.Set(route); err != nil {
+			return nil, fmt.Errorf("blue/green route %s: %w", route.Prefix, err)
+		}
+	}
+	for service, weight := range o.svcWeights {
+		if err := application.ServiceGroups.SetWeight(service, weight); err != nil {
+			return nil, fmt.Errorf("service %s: %w", service, err)
+		}
+	}
+	for _, e := range o.experiment {
+		if _, err := application.Experiments.Set(e); err != nil {
+			return nil, fmt.Errorf("experiment %s: %w", e.Name, err)
+		}
+	}
+	if o.tlsPolicy != nil {
+		policies, err := app.NewTLSPolicies(o.tlsPolicy)
+		if err != nil {
+			return nil, err
+		}
+		if policies.RequiresClientCerts() && o.clientCAs == nil {
+			return nil, errors.New("proxy: tls policies require client certificates, use WithClientCAs")
+		}
+		application.TLSPolicies = policies
+	}
+	if o.leader != nil {
+		application.Leader = app.NewLeaderElection(o.leader.backend, o.leader.id, o.logger)
+	}
+	if o.cluster != nil {
+		cluster, err := app.NewCluster(*o.cluster, o.logger)
+		if err != nil {
+			return nil, err
+		}
+		application.Cluster = cluster
+	}
+	for i, h := range o.handlers {
+		application.Events.Subscribe(fmt.Sprintf("handler-%d", i), h.fn, h.types...)
+	}
+	if o.webhooks != nil {
+		webhooks, err := app.NewRegistryWebhooks(*o.webhooks, o.logger)
+		if err != nil {
+			return nil, err
+		}
+		application.SetRegistryWebhooks(webhooks)
+	}
+	application.Use(o.middleware...)
+
+	p := &Proxy{app: application, listener: o.listener, sessDrain: o.sessDrain != 0}
+
+	if o.connLimits != nil {
+		application.Connections = app.NewConnLimiter(*o.connLimits, o.logger)
+		if p.listener != nil {
+			p.listener = application.Connections.Wrap(p.listener)
+		}
+	}
+
+	if o.cert != nil {
+		if err := application.Probes.SetTLSCertificate(o.cert); err != nil {
+			return nil, fmt.Errorf("invalid tls certificate: %w", err)
+		}
+		if err := application.Certificates.TrackPair("serving", app.CertRoleServing, o.cert); err != nil {
+			return nil, fmt.Errorf("invalid tls certificate: %w", err)
+		}
+		if p.listener != nil {
+			tlsConfig := &tls.Config{Certificates: []tls.Certificate{*o.cert}}
+			if o.clientCAs != nil {
+				tlsConfig.ClientCAs = o.clientCAs
+				tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+			}
+			p.listener = tls.NewListener(p.listener, tlsConfig)
+		}
+	}
+
+	p.handler = application.Handler()
+	p.server = &http.Server{
+		Handler:      p,
+		IdleTimeout:  time.Minute,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+	if application.ClientStreams != nil {
+		application.ClientStreams.ConfigureServer(p.server)
+	}
+
+	application.Start()
+
+	return p, nil
+}
+
+// ServeHTTP serves a request through the proxy
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.handler.ServeHTTP(w, r)
+}
+
+// Registry returns the proxy's service registry
+func (p *Proxy) Registry() Registry {
+	return p.app.Registry
+}
+
+// CheckHealth runs a round of backend health checks now instead of waiting
+// for the next interval, so newly registered servers can take traffic
+func (p *Proxy) CheckHealth(ctx context.Context) {
+	p.app.HealthMonitor.CheckAll(ctx)
+}
+
+// FlushCache waits until responses already queued for the cache have been
+// stored. Responses are cached in the background after they are sent, so a
+// request made right after another may not find it cached yet.
+func (p *Proxy) FlushCache() {
+	p.app.FlushCaches()
+}
+
+// SignURL mints a URL for target, a path with an optional query on a route
+// given to WithSignedURLs, valid for ttl (0 for the default), returning the
+// signed path and query and when it expires
+func (p *Proxy) SignURL(target string, ttl time.Duration) (string, time.Time, error) {
+	return p.app.SignURL(target, ttl)
+}
+
+// Serve accepts connections on the listener given with WithListener until
+// Shutdown is called
+func (p *Proxy) Serve() error {
+	if p.listener == nil {
+		return errors.New("proxy: no listener configured, use WithListener")
+	}
+
+	err := p.server.Serve(p.listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown gracefully stops Serve, if running, and the proxy's background
+// components. With WithSessionDrain, client sessions are drained first.
+func (p *Proxy) Shutdown(ctx context.Context) error {
+	var err error
+	if p.sessDrain {
+		err = p.app.DrainSessions(ctx, p.server)
+	} else {
+		err = p.server.Shutdown(ctx)
+	}
+	p.app.Shutdown()
+	return err
+}
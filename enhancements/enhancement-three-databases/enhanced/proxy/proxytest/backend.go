@@ -0,0 +1,178 @@
+package proxytest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// BackendHeader names the fake backend that answered a response
+const BackendHeader = "X-Proxytest-Backend"
+
+// Backend is a programmable fake backend. By default it answers /health with
+// 200 and every other path with a JSON body describing the request; its
+// latency, failures and streaming can be changed while a test runs.
+type Backend struct {
+	Name string
+	URL  string
+
+	server *httptest.Server
+
+	mu          sync.Mutex
+	latency     time.Duration
+	failNext    int
+	failStatus  int
+	unhealthy   bool
+	chunks      int
+	interval    time.Duration
+	handlers    map[string]http.HandlerFunc
+	requests    []*http.Request
+	healthCount int
+}
+
+// NewBackend starts a fake backend that is closed when the test ends
+func NewBackend(t testing.TB, name string) *Backend {
+	t.Helper()
+
+	b := &Backend{Name: name, handlers: make(map[string]http.HandlerFunc)}
+	b.server = httptest.NewServer(http.HandlerFunc(b.serve))
+	b.URL = b.server.URL
+	t.Cleanup(b.server.Close)
+	return b
+}
+
+// SetLatency delays every non-health response by d
+func (b *Backend) SetLatency(d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.latency = d
+}
+
+// FailNext answers the next n non-health requests with status
+func (b *Backend) FailNext(n, status int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failNext, b.failStatus = n, status
+}
+
+// SetHealthy makes /health answer 200, or 503 when healthy is false
+func (b *Backend) SetHealthy(healthy bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.unhealthy = !healthy
+}
+
+// Stream makes responses send chunks lines, flushing each and pausing
+// interval between them; 0 chunks turns streaming off
+func (b *Backend) Stream(chunks int, interval time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.chunks, b.interval = chunks, interval
+}
+
+// Handle answers requests for an exact path with fn instead of the default
+// response; latency and failures still apply
+func (b *Backend) Handle(path string, fn http.HandlerFunc) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[path] = fn
+}
+
+// Requests returns the number of non-health requests the backend received
+func (b *Backend) Requests() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.requests)
+}
+
+// LastRequest returns the most recent non-health request, or nil
+func (b *Backend) LastRequest() *http.Request {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.requests) == 0 {
+		return nil
+	}
+	return b.requests[len(b.requests)-1]
+}
+
+// HealthChecks returns the number of health checks the backend answered
+func (b *Backend) HealthChecks() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.healthCount
+}
+
+func (b *Backend) serve(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(BackendHeader, b.Name)
+
+	b.mu.Lock()
+	if r.URL.Path == "/health" {
+		b.healthCount++
+		unhealthy := b.unhealthy
+		b.mu.Unlock()
+		if unhealthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	b.requests = append(b.requests, r.Clone(r.Context()))
+	latency, chunks, interval := b.latency, b.chunks, b.interval
+	status := 0
+	if b.failNext > 0 {
+		b.failNext--
+		status = b.failStatus
+	}
+	handler := b.handlers[r.URL.Path]
+	b.mu.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	switch {
+	case status != 0:
+		http.Error(w, "proxytest: injected failure", status)
+	case handler != nil:
+		handler(w, r)
+	case chunks > 0:
+		b.stream(w, r, chunks, interval)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"backend": b.Name,
+			"method":  r.Method,
+			"path":    r.URL.Path,
+			"query":   r.URL.RawQuery,
+		})
+	}
+}
+
+// stream writes chunks lines to w, flushing after each
+func (b *Backend) stream(w http.ResponseWriter, r *http.Request, chunks int, interval time.Duration) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	flusher, _ := w.(http.Flusher)
+	for i := 0; i < chunks; i++ {
+		if i > 0 && interval > 0 {
+			select {
+			case <-time.After(interval):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		fmt.Fprintf(w, "data: %s %d\n\n", b.Name, i)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
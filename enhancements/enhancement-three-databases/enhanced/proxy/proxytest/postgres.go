@@ -0,0 +1,140 @@
+package proxytest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codytheroux96/go-reverse-proxy/db/migrations"
+	dbmigrate "github.com/codytheroux96/go-reverse-proxy/internal/migrate"
+	_ "github.com/lib/pq"
+)
+
+// DatabaseURLEnv names an environment variable holding a postgres:// URL to
+// use instead of starting a container, for CI jobs that provide a database.
+// Each test gets its own schema in it.
+const DatabaseURLEnv = "PROXYTEST_DATABASE_URL"
+
+// PostgresImage is the image Postgres starts
+const PostgresImage = "postgres:16-alpine"
+
+// postgresStartTimeout bounds how long a container may take to accept
+// connections
+const postgresStartTimeout = 30 * time.Second
+
+// Postgres returns the URL of an ephemeral PostgreSQL database with the
+// repository's migrations applied. It starts a container with the docker CLI
+// and removes it when the test ends, or uses DatabaseURLEnv when set. The
+// test is skipped when neither is available.
+func Postgres(t testing.TB) string {
+	t.Helper()
+
+	if url := os.Getenv(DatabaseURLEnv); url != "" {
+		url, err := isolatedSchema(t, url)
+		if err != nil {
+			t.Fatalf("proxytest: %v", err)
+		}
+		if err := migrate(url); err != nil {
+			t.Fatalf("proxytest: %v", err)
+		}
+		return url
+	}
+
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skipf("proxytest: docker not found and %s not set", DatabaseURLEnv)
+	}
+
+	out, err := exec.Command("docker", "run", "-d", "--rm",
+		"-e", "POSTGRES_USER=proxytest",
+		"-e", "POSTGRES_PASSWORD=proxytest",
+		"-e", "POSTGRES_DB=proxytest",
+		"-p", "127.0.0.1::5432",
+		PostgresImage).Output()
+	if err != nil {
+		t.Skipf("proxytest: failed to start postgres container: %v", err)
+	}
+	id := strings.TrimSpace(string(out))
+	t.Cleanup(func() { exec.Command("docker", "rm", "-f", id).Run() })
+
+	out, err = exec.Command("docker", "port", id, "5432/tcp").Output()
+	if err != nil {
+		t.Fatalf("proxytest: failed to find postgres port: %v", err)
+	}
+	addr := strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+	url := fmt.Sprintf("postgres://proxytest:proxytest@%s/proxytest?sslmode=disable", addr)
+
+	if err := waitForPostgres(url, postgresStartTimeout); err != nil {
+		t.Fatalf("proxytest: %v", err)
+	}
+	if err := migrate(url); err != nil {
+		t.Fatalf("proxytest: %v", err)
+	}
+	return url
+}
+
+// isolatedSchema creates a schema for the test in a shared database, drops
+// it when the test ends and returns url with its search_path set to it
+func isolatedSchema(t testing.TB, url string) (string, error) {
+	database, err := sql.Open("postgres", url)
+	if err != nil {
+		return "", fmt.Errorf("failed to open database: %w", err)
+	}
+	defer database.Close()
+
+	schema := fmt.Sprintf("proxytest_%d_%d", os.Getpid(), time.Now().UnixNano())
+	if _, err := database.Exec("CREATE SCHEMA " + schema); err != nil {
+		return "", fmt.Errorf("failed to create schema: %w", err)
+	}
+	t.Cleanup(func() {
+		if database, err := sql.Open("postgres", url); err == nil {
+			database.Exec("DROP SCHEMA " + schema + " CASCADE")
+			database.Close()
+		}
+	})
+
+	sep := "?"
+	if strings.Contains(url, "?") {
+		sep = "&"
+	}
+	return url + sep + "search_path=" + schema, nil
+}
+
+// waitForPostgres pings the database until it accepts connections. The
+// image's init phase only listens on a socket, so a successful TCP ping
+// means the final server is up.
+func waitForPostgres(url string, timeout time.Duration) error {
+	database, err := sql.Open("postgres", url)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer database.Close()
+
+	deadline := time.Now().Add(timeout)
+	for {
+		err := database.Ping()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("postgres not ready after %s: %w", timeout, err)
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+// migrate applies the embedded migrations in db/migrations
+func migrate(url string) error {
+	database, err := sql.Open("postgres", url)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer database.Close()
+
+	_, err = dbmigrate.Up(context.Background(), database, migrations.FS)
+	return err
+}
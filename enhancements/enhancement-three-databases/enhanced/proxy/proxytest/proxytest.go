@@ -0,0 +1,213 @@
+// Package proxytest runs the proxy end to end in tests, in front of
+// programmable fake backends and optionally an ephemeral PostgreSQL registry.
+//
+//	func TestRouting(t *testing.T) {
+//		h := proxytest.New(t)
+//		api := h.AddBackend("api", "/api")
+//
+//		resp := h.Get("/api/items")
+//		h.AssertStatus(resp, http.StatusOK)
+//		h.AssertServedBy(resp, api)
+//		h.AssertCached("/api/items", api)
+//	}
+//
+// Tests that need the PostgreSQL registry pass
+// proxy.WithRegistry(proxytest.PostgresRegistry(t)) to New.
+package proxytest
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/codytheroux96/go-reverse-proxy/proxy"
+)
+
+// Harness is a proxy serving on a loopback test server
+type Harness struct {
+	Proxy  *proxy.Proxy
+	URL    string
+	Client *http.Client
+
+	t testing.TB
+}
+
+// Response is a proxy response with its body read
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// New starts a proxy configured with opts, with an in-memory registry and
+// discarded logs unless opts say otherwise, and stops it when the test ends.
+// Rate limiting is disabled so tests can send bursts; pass
+// proxy.WithRateLimit to test it.
+func New(t testing.TB, opts ...proxy.Option) *Harness {
+	t.Helper()
+
+	logger := slog.New(slog.DiscardHandler)
+	defaults := []proxy.Option{
+		proxy.WithLogger(logger),
+		proxy.WithRegistry(proxy.NewMemoryRegistry(logger)),
+		proxy.WithRateLimit(0, 0),
+	}
+	p, err := proxy.New(append(defaults, opts...)...)
+	if err != nil {
+		t.Fatalf("proxytest: failed to create proxy: %v", err)
+	}
+
+	server := httptest.NewServer(p)
+	t.Cleanup(func() {
+		server.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		p.Shutdown(ctx)
+	})
+
+	return &Harness{Proxy: p, URL: server.URL, Client: server.Client(), t: t}
+}
+
+// PostgresRegistry returns a registry backed by an ephemeral database from
+// Postgres
+func PostgresRegistry(t testing.TB) proxy.Registry {
+	t.Helper()
+
+	reg, err := proxy.NewPostgreSQLRegistry(Postgres(t), slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatalf("proxytest: %v", err)
+	}
+	return reg
+}
+
+// AddBackend starts a fake backend, registers it for prefixes and runs a
+// health check so it takes traffic immediately
+func (h *Harness) AddBackend(name string, prefixes ...string) *Backend {
+	h.t.Helper()
+
+	b := NewBackend(h.t, name)
+	if err := h.Proxy.Registry().Register(proxy.Server{Name: name, BaseURL: b.URL, Prefixes: prefixes}); err != nil {
+		h.t.Fatalf("proxytest: failed to register %s: %v", name, err)
+	}
+	h.Proxy.CheckHealth(context.Background())
+	return b
+}
+
+// Get sends a GET request for path through the proxy
+func (h *Harness) Get(path string) *Response {
+	h.t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, h.URL+path, nil)
+	if err != nil {
+		h.t.Fatalf("proxytest: %v", err)
+	}
+	return h.Do(req)
+}
+
+// Do sends a request through the proxy; a relative URL is resolved against
+// the proxy's
+func (h *Harness) Do(req *http.Request) *Response {
+	h.t.Helper()
+
+	if req.URL.Host == "" {
+		target, err := http.NewRequest(req.Method, h.URL+req.URL.RequestURI(), req.Body)
+		if err != nil {
+			h.t.Fatalf("proxytest: %v", err)
+		}
+		target.Header = req.Header
+		req = target
+	}
+
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		h.t.Fatalf("proxytest: %s %s: %v", req.Method, req.URL.Path, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		h.t.Fatalf("proxytest: failed to read response: %v", err)
+	}
+	return &Response{StatusCode: resp.StatusCode, Header: resp.Header, Body: body}
+}
+
+// AssertStatus fails the test unless resp has the given status
+func (h *Harness) AssertStatus(resp *Response, status int) {
+	h.t.Helper()
+
+	if resp.StatusCode != status {
+		h.t.Errorf("status = %d, want %d; body %q", resp.StatusCode, status, resp.Body)
+	}
+}
+
+// AssertServedBy fails the test unless resp came from backend b
+func (h *Harness) AssertServedBy(resp *Response, b *Backend) {
+	h.t.Helper()
+
+	if got := resp.Header.Get(BackendHeader); got != b.Name {
+		h.t.Errorf("served by %q, want %q", got, b.Name)
+	}
+}
+
+// AssertCached requests path and fails the test if the request reached
+// backend b, meaning it was not answered from the cache. The path must have
+// been requested before.
+func (h *Harness) AssertCached(path string, b *Backend) {
+	h.t.Helper()
+
+	h.Proxy.FlushCache()
+	before := b.Requests()
+	resp := h.Get(path)
+	if b.Requests() != before {
+		h.t.Errorf("GET %s reached %s, want a cached response (status %d)", path, b.Name, resp.StatusCode)
+	}
+}
+
+// AssertNotCached requests path and fails the test unless the request
+// reached backend b
+func (h *Harness) AssertNotCached(path string, b *Backend) {
+	h.t.Helper()
+
+	h.Proxy.FlushCache()
+	before := b.Requests()
+	resp := h.Get(path)
+	if b.Requests() == before {
+		h.t.Errorf("GET %s was answered from the cache, want it to reach %s (status %d)", path, b.Name, resp.StatusCode)
+	}
+}
+
+// BreakerState returns a backend's circuit breaker state, such as "Closed",
+// "Open" or "HalfOpen", from the admin API, or "" when it has none. It needs
+// the proxy without an admin token.
+func (h *Harness) BreakerState(b *Backend) string {
+	h.t.Helper()
+
+	resp := h.Get("/admin/breakers")
+	if resp.StatusCode != http.StatusOK {
+		h.t.Fatalf("proxytest: GET /admin/breakers: status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Breakers map[string]struct {
+			State string `json:"state"`
+		} `json:"breakers"`
+	}
+	if err := json.Unmarshal(resp.Body, &result); err != nil {
+		h.t.Fatalf("proxytest: failed to decode breakers: %v", err)
+	}
+	return result.Breakers[b.Name].State
+}
+
+// AssertBreakerState fails the test unless backend b's breaker is in state
+func (h *Harness) AssertBreakerState(b *Backend, state string) {
+	h.t.Helper()
+
+	if got := h.BreakerState(b); got != state {
+		h.t.Errorf("breaker for %s is %q, want %q", b.Name, got, state)
+	}
+}
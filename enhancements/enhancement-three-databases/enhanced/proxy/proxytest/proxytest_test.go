@@ -0,0 +1,110 @@
+package proxytest_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codytheroux96/go-reverse-proxy/proxy/proxytest"
+)
+
+func TestBreakerOpens(t *testing.T) {
+	h := proxytest.New(t)
+	api := h.AddBackend("api", "/api")
+
+	h.AssertStatus(h.Get("/api/items"), http.StatusOK)
+	h.AssertCached("/api/items", api)
+
+	// Each failing GET is attempted three times before it counts against
+	// the breaker once
+	api.FailNext(15, http.StatusInternalServerError)
+	for i := 0; i < 5; i++ {
+		h.Get(fmt.Sprintf("/api/fail?i=%d", i))
+	}
+	h.AssertBreakerState(api, "Open")
+
+	before := api.Requests()
+	resp := h.Get("/api/after")
+	h.AssertStatus(resp, http.StatusServiceUnavailable)
+	if api.Requests() != before {
+		t.Errorf("open breaker let a request reach %s", api.Name)
+	}
+}
+
+func TestRetriesTransientFailures(t *testing.T) {
+	h := proxytest.New(t)
+	api := h.AddBackend("api", "/api")
+
+	api.FailNext(2, http.StatusBadGateway)
+	resp := h.Get("/api/items")
+	h.AssertStatus(resp, http.StatusOK)
+	h.AssertServedBy(resp, api)
+	if got := api.Requests(); got != 3 {
+		t.Errorf("backend received %d attempts, want 3", got)
+	}
+	h.AssertBreakerState(api, "Closed")
+}
+
+func TestUnhealthyBackendTakesNoTraffic(t *testing.T) {
+	h := proxytest.New(t)
+	blue := h.AddBackend("blue", "/api")
+	green := h.AddBackend("green", "/api")
+
+	blue.SetHealthy(false)
+	checks := blue.HealthChecks()
+	for i := 0; i < 3; i++ {
+		h.Proxy.CheckHealth(context.Background())
+	}
+	if blue.HealthChecks() <= checks {
+		t.Fatal("health checks did not reach the backend")
+	}
+
+	before := blue.Requests()
+	for i := 0; i < 10; i++ {
+		resp := h.Get(fmt.Sprintf("/api/items?i=%d", i))
+		h.AssertStatus(resp, http.StatusOK)
+		h.AssertServedBy(resp, green)
+	}
+	if blue.Requests() != before {
+		t.Errorf("unhealthy %s received %d requests", blue.Name, blue.Requests()-before)
+	}
+
+	blue.SetHealthy(true)
+	h.Proxy.CheckHealth(context.Background())
+	served := map[string]bool{}
+	for i := 0; i < 10; i++ {
+		served[h.Get(fmt.Sprintf("/api/again?i=%d", i)).Header.Get(proxytest.BackendHeader)] = true
+	}
+	if !served[blue.Name] {
+		t.Errorf("recovered %s took no traffic", blue.Name)
+	}
+}
+
+func TestCachesGetResponses(t *testing.T) {
+	h := proxytest.New(t)
+	api := h.AddBackend("api", "/api")
+
+	h.AssertStatus(h.Get("/api/items"), http.StatusOK)
+	h.AssertCached("/api/items", api)
+	h.AssertNotCached("/api/items?page=2", api)
+
+	api.FailNext(3, http.StatusInternalServerError)
+	h.AssertStatus(h.Get("/api/broken"), http.StatusInternalServerError)
+	h.AssertNotCached("/api/broken", api)
+}
+
+func TestStreamsAreRelayedUncached(t *testing.T) {
+	h := proxytest.New(t)
+	api := h.AddBackend("api", "/api")
+	api.Stream(3, 10*time.Millisecond)
+
+	resp := h.Get("/api/events")
+	h.AssertStatus(resp, http.StatusOK)
+	if got := strings.Count(string(resp.Body), "data: api"); got != 3 {
+		t.Errorf("relayed %d events, want 3; body %q", got, resp.Body)
+	}
+	h.AssertNotCached("/api/events", api)
+}
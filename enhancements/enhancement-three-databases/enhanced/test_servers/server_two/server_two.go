@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -13,16 +14,17 @@ import (
 	"time"
 )
 
-func Serve() {
+// Serve runs server two on addr and registers it with the proxy at proxyURL
+func Serve(addr, proxyURL string) {
 	app := newApplication()
 
-	if err := registerWithProxy(); err != nil {
+	if err := registerWithProxy(addr, proxyURL); err != nil {
 		app.logger.Error("failed to register server two with proxy", "error", err)
 		os.Exit(1)
 	}
 
 	serverTwo := &http.Server{
-		Addr:         ":2200",
+		Addr:         addr,
 		Handler:      app.routes(),
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  10 * time.Second,
@@ -36,7 +38,7 @@ func Serve() {
 		<-shutdown
 		app.logger.Info("shutting server two down gracefully")
 
-		if err := deregisterFromProxy(); err != nil {
+		if err := deregisterFromProxy(proxyURL); err != nil {
 			app.logger.Error("failed to deregister server two from proxy", "error", err)
 		} else {
 			app.logger.Info("successfully deregistered server two from proxy")
@@ -53,10 +55,11 @@ func Serve() {
 	}
 }
 
-func registerWithProxy() error {
+// registerWithProxy registers the server, retrying while the proxy starts up
+func registerWithProxy(addr, proxyURL string) error {
 	payload := map[string]interface{}{
 		"name":     "server_two",
-		"base_url": "http://localhost:2200",
+		"base_url": baseURL(addr),
 		"routes":   []string{"/s2"},
 	}
 
@@ -72,9 +75,16 @@ func registerWithProxy() error {
 		Timeout: 10 * time.Second,
 	}
 
-	resp, err := client.Post("https://localhost:8443/register", "application/json", bytes.NewBuffer(jsonPayload))
-	if err != nil {
-		return fmt.Errorf("failed to call proxy register endpoint: %w", err)
+	var resp *http.Response
+	for attempt := 1; ; attempt++ {
+		resp, err = client.Post(proxyURL+"/register", "application/json", bytes.NewBuffer(jsonPayload))
+		if err == nil {
+			break
+		}
+		if attempt == 5 {
+			return fmt.Errorf("failed to call proxy register endpoint: %w", err)
+		}
+		time.Sleep(time.Second)
 	}
 	defer resp.Body.Close()
 
@@ -86,7 +96,7 @@ func registerWithProxy() error {
 	return nil
 }
 
-func deregisterFromProxy() error {
+func deregisterFromProxy(proxyURL string) error {
 	payload := map[string]string{
 		"name": "server_two",
 	}
@@ -103,7 +113,7 @@ func deregisterFromProxy() error {
 		Timeout: 10 * time.Second,
 	}
 
-	resp, err := client.Post("https://localhost:8443/deregister", "application/json", bytes.NewBuffer(jsonPayload))
+	resp, err := client.Post(proxyURL+"/deregister", "application/json", bytes.NewBuffer(jsonPayload))
 	if err != nil {
 		return fmt.Errorf("failed to call proxy register endpoint: %w", err)
 	}
@@ -116,3 +126,15 @@ func deregisterFromProxy() error {
 
 	return nil
 }
+
+// baseURL turns a listen address such as ":4200" into a URL the proxy can reach
+func baseURL(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "http://" + addr
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}